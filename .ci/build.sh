@@ -75,7 +75,7 @@ echo ""
 
 # Build Go binary
 echo -e "${YELLOW}[4/6] Compiling Go binary...${NC}"
-go build -v -o build/cerberus cmd/cerberus/main.go
+go build -v -o build/cerberus ./cmd/cerberus
 
 if [ ! -f "build/cerberus" ]; then
     echo -e "${RED}✗ Go compilation failed: binary not found${NC}"
@@ -0,0 +1,244 @@
+package cerberus
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/eventsock"
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/version"
+)
+
+// SocketFile is the name of the event socket in the storage directory
+const SocketFile = eventsock.SocketFile
+
+// DefaultReloadInterval is how often an api-only runner checks whether the
+// database changed
+const DefaultReloadInterval = 30 * time.Second
+
+// retireDelay is how long a replaced monitor stays open for the requests
+// still reading it
+const retireDelay = time.Minute
+
+// eventSocketPath returns the path of the event socket, or "" without one
+func (o *options) eventSocketPath() string {
+	if o.eventSocket != "" {
+		return o.eventSocket
+	}
+	if o.storageDir == "" {
+		return ""
+	}
+	return filepath.Join(o.storageDir, SocketFile)
+}
+
+// publishEvents publishes the patterns, anomalies, device changes and
+// interface states of the monitor on the event socket, for api-only
+// processes, until the returned function is called. Without storage there is
+// nothing to share and nothing is published.
+func (r *Runner) publishEvents() (func(), error) {
+	path := r.opts.eventSocketPath()
+	if path == "" {
+		return func() {}, nil
+	}
+	mon := r.mon
+	interfaceStates := func() []eventsock.Event {
+		var events []eventsock.Event
+		for _, status := range mon.Interfaces().List() {
+			events = append(events, eventsock.Event{Kind: eventsock.KindInterface, Data: status})
+		}
+		return events
+	}
+	hello := eventsock.Hello{PID: os.Getpid(), Version: version.Version, Started: time.Now()}
+	publisher, err := eventsock.Listen(path, hello, interfaceStates)
+	if err != nil {
+		return nil, err
+	}
+
+	_, patterns, stopPatterns := mon.SubscribePatterns(nil, false)
+	_, anomalies, stopAnomalies := mon.SubscribeAnomalies(false)
+	changes, stopChanges := mon.SubscribeDeviceChanges()
+	interfaces, stopInterfaces := mon.Interfaces().Subscribe()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// Interface counters change without notifying subscribers; resending
+		// the states keeps those of readers close to the writer's
+		counters := time.NewTicker(eventsock.PingInterval)
+		defer counters.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case pattern := <-patterns:
+				publisher.Publish(eventsock.KindPattern, pattern)
+			case anomaly := <-anomalies:
+				publisher.Publish(eventsock.KindAnomaly, anomaly)
+			case change := <-changes:
+				publisher.Publish(eventsock.KindDevice, change)
+			case status := <-interfaces:
+				publisher.Publish(eventsock.KindInterface, status)
+			case <-counters.C:
+				for _, event := range interfaceStates() {
+					publisher.Publish(event.Kind, event.Data)
+				}
+			}
+		}
+	}()
+
+	r.opts.logger.Printf("Publishing events for api-only processes on %s", path)
+	return func() {
+		close(stop)
+		<-stopped
+		stopPatterns()
+		stopAnomalies()
+		stopChanges()
+		stopInterfaces()
+		publisher.Close()
+	}, nil
+}
+
+// follower keeps an api-only runner up to date with the capturing process:
+// it relays the events read from its socket and reloads its database when
+// it changed
+type follower struct {
+	r          *Runner
+	server     *api.Server
+	socket     string
+	dbPath     string
+	interfaces *ifaces.Registry
+	subscriber *eventsock.Subscriber
+
+	mu       sync.Mutex
+	loaded   os.FileInfo // Of the database file last loaded
+	loadedAt time.Time
+}
+
+// followWriter connects an api-only runner's API server to the capturing
+// process. It must be called before the server starts.
+func (r *Runner) followWriter(server *api.Server) *follower {
+	f := &follower{
+		r:          r,
+		server:     server,
+		socket:     r.opts.eventSocketPath(),
+		dbPath:     filepath.Join(r.opts.storageDir, DatabaseFile),
+		interfaces: r.mon.Interfaces(),
+		loadedAt:   r.loadedAt,
+	}
+	f.loaded, _ = os.Stat(f.dbPath)
+
+	relay := eventsock.NewRelay(f.interfaces, func() []*models.Anomaly {
+		return r.monitor().RecentAnomalies()
+	})
+	f.subscriber = eventsock.Subscribe(f.socket, relay.Handle)
+	server.SetEventSource(relay)
+	server.SetWriterStatus(f.status)
+	return f
+}
+
+// status describes the capturing process for /health
+func (f *follower) status() *models.WriterStatus {
+	connection := f.subscriber.Status()
+	status := &models.WriterStatus{
+		Socket:     f.socket,
+		Connected:  connection.Connected,
+		Since:      connection.Since,
+		LastError:  connection.Error,
+		Reconnects: connection.Reconnects,
+	}
+	if writer := connection.Writer; writer != nil {
+		status.PID = writer.PID
+		status.Version = writer.Version
+		status.Started = &writer.Started
+	}
+	f.mu.Lock()
+	status.LoadedAt = f.loadedAt
+	f.mu.Unlock()
+	return status
+}
+
+// run reloads the database until ctx is canceled or the runner is shut down,
+// and reports when the capturing process comes and goes
+func (f *follower) run(ctx context.Context, stop <-chan struct{}) {
+	defer f.subscriber.Close()
+
+	interval := f.r.opts.reloadInterval
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	reload := time.NewTicker(interval)
+	defer reload.Stop()
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+
+	f.r.opts.logger.Printf("Serving %s for the capturing process on %s", f.dbPath, f.socket)
+	connected := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-reload.C:
+			f.reload()
+		case <-check.C:
+			connection := f.subscriber.Status()
+			if connection.Connected == connected {
+				continue
+			}
+			connected = connection.Connected
+			if connected {
+				f.r.opts.logger.Printf("Following the capturing process (pid %d)", connection.Writer.PID)
+			} else {
+				f.r.opts.logger.Printf("WARNING: lost the capturing process (%s), serving persisted data only", connection.Error)
+			}
+		}
+	}
+}
+
+// reload swaps in a monitor loaded from the database when the file changed
+// since it was last loaded. The replaced monitor is closed once the requests
+// reading it have had time to finish.
+func (f *follower) reload() {
+	info, err := os.Stat(f.dbPath)
+	if err != nil {
+		f.r.opts.logger.Printf("WARNING: cannot reload %s: %v", f.dbPath, err)
+		return
+	}
+	if f.loaded != nil && os.SameFile(info, f.loaded) && info.Size() == f.loaded.Size() && info.ModTime().Equal(f.loaded.ModTime()) {
+		return
+	}
+
+	mon, err := monitor.NewReadOnlyNetworkMonitor(cacheSize, f.dbPath)
+	if err != nil {
+		f.r.opts.logger.Printf("WARNING: cannot reload %s, serving the previous copy: %v", f.dbPath, err)
+		return
+	}
+	mon.UseInterfaces(f.interfaces)
+	for _, setup := range f.r.opts.monitorSetup {
+		setup(mon)
+	}
+	f.server.SetMonitor(mon)
+
+	f.r.mu.Lock()
+	retired := f.r.mon
+	f.r.mon = mon
+	f.r.mu.Unlock()
+	time.AfterFunc(retireDelay, func() {
+		if err := retired.Close(); err != nil {
+			f.r.opts.logger.Printf("Warning: failed to close a replaced monitor: %v", err)
+		}
+	})
+
+	f.mu.Lock()
+	f.loaded = info
+	f.loadedAt = time.Now()
+	f.mu.Unlock()
+}
@@ -0,0 +1,424 @@
+package cerberus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+
+	"github.com/zrougamed/cerberus/internal/capture"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Backend is where a runner's events come from: BPF or Replay
+type Backend interface {
+	// Name identifies the backend in /api/v1/version
+	Name() string
+
+	// start starts feeding events into the runner's monitor
+	start(r *Runner) error
+	// stop stops the events; none are tracked once it returns
+	stop()
+}
+
+// BPFConfig configures the BPF backend
+type BPFConfig struct {
+	Mode     string // tcx (default), xdp-generic or xdp-native
+	Object   string // Compiled BPF program (default: cerberus_tc.o)
+	PinPath  string // Directory on the BPF filesystem to pin maps in (empty pins nothing)
+	PinLinks bool   // Pin the TCX links in PinPath too, so they stay attached after exit
+
+	// Silence after which an attached interface that produced traffic is
+	// reported degraded (0 disables the check), once it produced MinEvents
+	InterfaceSilence   time.Duration
+	InterfaceMinEvents uint64
+	Reattach           bool // Re-attach a silent interface once before reporting it
+}
+
+// bpfBackend attaches the BPF program to interfaces and reads its ring buffers.
+// It needs root, or CAP_BPF and CAP_NET_ADMIN.
+type bpfBackend struct {
+	config BPFConfig
+	logger *log.Logger
+	mon    *monitor.NetworkMonitor
+
+	coll    *ebpf.Collection
+	readers []*ringbuf.Reader
+	wg      sync.WaitGroup // Ring buffer readers and the flow sweep
+	quit    chan struct{}  // Closed by stop to end the flow sweep
+
+	// Links by ifindex; the interface watch may replace them concurrently
+	linksMu sync.Mutex
+	links   map[int]link.Link
+}
+
+// BPF returns the backend capturing live traffic with the BPF program
+func BPF(config BPFConfig) Backend {
+	if config.Mode == "" {
+		config.Mode = capture.ModeTCX
+	}
+	if config.Object == "" {
+		config.Object = capture.Object
+	}
+	return &bpfBackend{config: config}
+}
+
+func (b *bpfBackend) Name() string {
+	return b.config.Mode
+}
+
+func (b *bpfBackend) start(r *Runner) error {
+	logger := r.opts.logger
+	b.logger = logger
+	b.mon = r.mon
+
+	switch b.config.Mode {
+	case capture.ModeTCX, capture.ModeXDPGeneric, capture.ModeXDPNative:
+	default:
+		return fmt.Errorf("invalid attach mode %q, want tcx, xdp-generic or xdp-native", b.config.Mode)
+	}
+	// Taking over a pinned link can't tell generic from native XDP
+	if b.config.PinLinks && (b.config.Mode != capture.ModeTCX || b.config.PinPath == "") {
+		return errors.New("pinning links needs the tcx attach mode and a pin path")
+	}
+
+	// Clean up any existing TC hooks
+	utils.CleanCards()
+
+	// Load BPF collection from compiled object file
+	coll, layout, err := capture.LoadCollection(b.config.Object, b.config.PinPath, logger)
+	if err != nil {
+		return err
+	}
+	b.coll = coll
+	b.links = make(map[int]link.Link)
+	if err := b.attach(r, layout); err != nil {
+		b.stop()
+		return err
+	}
+	return nil
+}
+
+// attach configures the filters, attaches the program and starts reading the
+// ring buffers
+func (b *bpfBackend) attach(r *Runner, layout uint32) error {
+	logger := r.opts.logger
+	mon := r.mon
+
+	if layout != models.EventLayoutVersion {
+		logger.Printf("Warning: %s emits event layout %d, this build expects %d; rebuild it with 'make bpf'",
+			b.config.Object, layout, models.EventLayoutVersion)
+	}
+	order := utils.EventByteOrder(layout)
+
+	// Drop disabled event types in the kernel before they hit the ring buffer
+	filter, err := capture.NewFilter(b.coll)
+	if err != nil {
+		return err
+	}
+	mon.SetCaptureControl(filter)
+	mon.SetInterfaceCaptures(r.opts.interfaceCapture)
+	if _, err := mon.ApplyCaptureConfig(r.opts.captureConfig); err != nil {
+		return fmt.Errorf("failed to configure event filter: %w", err)
+	}
+
+	// Get the program to attach
+	var prog *ebpf.Program
+	if b.config.Mode == capture.ModeTCX {
+		prog, err = capture.ClassifierProgram(b.coll)
+	} else {
+		prog, err = capture.XDPProgram(b.coll)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Get all network interfaces
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	attachSet, note, err := capture.SelectInterfaces(mon.Topology(), strings.Join(r.opts.interfaces, ","), r.opts.allInterfaces)
+	if err != nil {
+		return fmt.Errorf("invalid interfaces: %w", err)
+	}
+	if note != "" {
+		logger.Print(note)
+	}
+
+	logger.Print("Scanning for network interfaces...")
+
+	attached := make(map[int]string)
+	registry := mon.Interfaces()
+	// attachTo attaches the program to an interface and records the outcome
+	// in the interface registry
+	attachTo := func(ifindex int, name string) (link.Link, error) {
+		var l link.Link
+		var err error
+		mode := b.config.Mode
+		switch {
+		case b.config.Mode != capture.ModeTCX:
+			l, mode, err = capture.AttachXDP(prog, ifindex, b.config.Mode == capture.ModeXDPNative, logger)
+		case b.config.PinLinks:
+			l, err = capture.AttachPinnedTCX(prog, ifindex, b.config.PinPath, logger)
+		default:
+			l, err = capture.AttachTCX(prog, ifindex)
+		}
+		registry.Update(ifindex, func(s *models.InterfaceStatus) {
+			s.Name = name
+			s.Attached = err == nil
+			s.AttachError = ""
+			if err != nil {
+				s.AttachError = err.Error()
+				return
+			}
+			s.Mode = mode
+		})
+		if err == nil {
+			if err := mon.InterfaceAttached(ifindex, name); err != nil {
+				logger.Printf("Warning: %s captures with the global settings: %v", name, err)
+			}
+		}
+		return l, err
+	}
+
+	for _, iface := range capture.AttachCandidates(ifaces, attachSet) {
+		logger.Printf("Attaching to %s...", iface.Name)
+
+		l, err := attachTo(iface.Index, iface.Name)
+		if err != nil {
+			logger.Printf("Failed to attach to %s: %v", iface.Name, err)
+			continue
+		}
+
+		b.linksMu.Lock()
+		b.links[iface.Index] = l
+		b.linksMu.Unlock()
+		attached[iface.Index] = iface.Name
+		logger.Printf("Successfully attached to %s", iface.Name)
+	}
+
+	if len(attached) == 0 {
+		return errors.New("failed to attach to any interface")
+	}
+
+	logger.Printf("\nMonitoring %d interface(s)\n", len(attached))
+
+	if b.config.InterfaceSilence > 0 {
+		watch := monitor.InterfaceWatchConfig{Silence: b.config.InterfaceSilence, MinEvents: b.config.InterfaceMinEvents}
+		if b.config.Reattach {
+			watch.Reattach = func(ifindex int) error {
+				b.linksMu.Lock()
+				defer b.linksMu.Unlock()
+				if b.links == nil {
+					return errors.New("capture is stopped")
+				}
+				if old := b.links[ifindex]; old != nil {
+					capture.DetachLink(old)
+					delete(b.links, ifindex)
+					mon.InterfaceDetached(ifindex)
+				}
+				l, err := attachTo(ifindex, attached[ifindex])
+				if err != nil {
+					return err
+				}
+				b.links[ifindex] = l
+				return nil
+			}
+		}
+		mon.WatchInterfaces(attached, watch)
+	}
+
+	return b.readRingBuffers(r, layout, order)
+}
+
+// readRingBuffers starts a reader per ring buffer
+func (b *bpfBackend) readRingBuffers(r *Runner, layout uint32, order binary.ByteOrder) error {
+	logger := r.opts.logger
+	mon := r.mon
+
+	// Open ring buffer for event communication
+	eventsMap := b.coll.Maps["events"]
+	if eventsMap == nil {
+		return errors.New("ring buffer map 'events' not found")
+	}
+	minEventSize := utils.MinEventSize(layout)
+	err := b.read(eventsMap, func(raw []byte) {
+		// Validate packet size
+		if len(raw) < minEventSize {
+			logger.Printf("Short packet: %d bytes (expected %d)", len(raw), minEventSize)
+			return
+		}
+		r.trackEvent(utils.ParseNetworkEvent(raw, layout))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open ring buffer: %w", err)
+	}
+
+	// TLS ClientHellos for JA3 fingerprinting arrive on their own ring buffer
+	if hellosMap := b.coll.Maps["tls_hellos"]; hellosMap != nil {
+		err := b.read(hellosMap, func(raw []byte) {
+			if hello := utils.ParseTLSHelloEvent(raw, order); hello != nil {
+				mon.TrackTLSHello(hello)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open TLS hello ring buffer: %w", err)
+		}
+	} else {
+		logger.Print("Warning: BPF map 'tls_hellos' not found, JA3 fingerprinting disabled")
+	}
+
+	// Full DNS queries (tunneling detection) and responses (direct-IP
+	// detection) arrive on their own ring buffer
+	if queriesMap := b.coll.Maps["dns_queries"]; queriesMap != nil {
+		err := b.read(queriesMap, func(raw []byte) {
+			if query := utils.ParseDNSQueryEvent(raw, order); query != nil {
+				r.trackDNS(query)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open DNS query ring buffer: %w", err)
+		}
+	} else {
+		logger.Print("Warning: BPF map 'dns_queries' not found, DNS tunneling and direct-IP detection disabled")
+	}
+
+	// HTTP request headers arrive on their own ring buffer
+	if requestsMap := b.coll.Maps["http_requests"]; requestsMap != nil {
+		err := b.read(requestsMap, func(raw []byte) {
+			if request := utils.ParseHTTPRequestEvent(raw, order); request != nil {
+				mon.TrackHTTPRequest(request)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open HTTP request ring buffer: %w", err)
+		}
+	} else {
+		logger.Print("Warning: BPF map 'http_requests' not found, HTTP Host header capture disabled")
+	}
+
+	// Summaries of established TCP flows counted in the kernel arrive on
+	// their own ring buffer; the rest is found by sweeping the flows map
+	if summariesMap := b.coll.Maps["flow_summaries"]; summariesMap != nil {
+		err := b.read(summariesMap, func(raw []byte) {
+			if summary := utils.ParseFlowSummaryEvent(raw, order); summary != nil {
+				mon.TrackFlowSummary(summary)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to open flow summary ring buffer: %w", err)
+		}
+		if flows := capture.NewFlows(b.coll); flows != nil {
+			b.sweepFlows(flows, mon)
+		}
+	} else {
+		logger.Print("Warning: BPF map 'flow_summaries' not found, TCP flows are not aggregated in the kernel")
+	}
+	return nil
+}
+
+// read opens a ring buffer and hands each record to handle until the reader
+// is closed
+func (b *bpfBackend) read(m *ebpf.Map, handle func(raw []byte)) error {
+	reader, err := ringbuf.NewReader(m)
+	if err != nil {
+		return err
+	}
+	b.readers = append(b.readers, reader)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			record, err := reader.Read()
+			if err != nil {
+				if errors.Is(err, ringbuf.ErrClosed) {
+					return
+				}
+				continue
+			}
+			handle(record.RawSample)
+		}
+	}()
+	return nil
+}
+
+// sweepFlows reports idle flows of the flows map every FlowSweepInterval, and
+// every flow still counted when stopped
+func (b *bpfBackend) sweepFlows(flows *capture.Flows, mon *monitor.NetworkMonitor) {
+	b.quit = make(chan struct{})
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(capture.FlowSweepInterval)
+		defer ticker.Stop()
+		for {
+			all := false
+			select {
+			case <-ticker.C:
+			case <-b.quit:
+				all = true
+			}
+			summaries, err := flows.Sweep(all)
+			if err != nil {
+				b.logger.Printf("Flow sweep failed: %v", err)
+			}
+			for _, summary := range summaries {
+				mon.TrackFlowSummary(summary)
+			}
+			if all {
+				return
+			}
+		}
+	}()
+}
+
+// stop closes the ring buffers and detaches the program. Pinned links stay
+// attached for the next run.
+func (b *bpfBackend) stop() {
+	if b.quit != nil {
+		close(b.quit)
+		b.quit = nil
+	}
+	for _, reader := range b.readers {
+		reader.Close()
+	}
+	b.wg.Wait()
+	b.readers = nil
+
+	b.linksMu.Lock()
+	if len(b.links) > 0 {
+		b.logger.Print("\nCleaning up hooks...")
+	}
+	for ifindex, l := range b.links {
+		if err := l.Close(); err != nil {
+			b.logger.Printf("Error cleaning up link: %v", err)
+		}
+		// Pinned links keep capturing with the pinned maps
+		if !b.config.PinLinks {
+			b.mon.InterfaceDetached(ifindex)
+		}
+	}
+	if b.config.PinLinks && len(b.links) > 0 {
+		b.logger.Printf("%d pinned link(s) stay attached for the next run; 'cerberus cleanup' detaches them", len(b.links))
+	}
+	b.links = nil
+	b.linksMu.Unlock()
+
+	if b.coll != nil {
+		b.coll.Close()
+		b.coll = nil
+	}
+}
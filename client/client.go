@@ -0,0 +1,313 @@
+// Package client talks to the HTTP API of a running cerberus. Responses are
+// decoded into the types the server encodes them from, so the fields of both
+// sides never drift apart. It is the package programs outside this module
+// import to query cerberus:
+//
+//	c, err := client.New("http://10.0.0.2:8080", "")
+//	if err != nil {
+//		return err
+//	}
+//	devices, err := c.Devices(ctx, url.Values{"sort": {"risk"}})
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Response types. They are the types the server encodes its responses from,
+// named here so that programs outside this module can use them.
+type (
+	Device               = models.DeviceInfo
+	DeviceHints          = models.DeviceHints
+	DeviceHealth         = models.DeviceHealth
+	DeviceMute           = models.DeviceMute
+	OSGuess              = models.OSGuess
+	DeviceTypeGuess      = models.DeviceTypeGuess
+	Pattern              = models.CommunicationPattern
+	Stats                = models.StatsReport
+	InterfaceStats       = models.InterfaceStats
+	InterfaceUtilization = models.InterfaceUtilization
+)
+
+// requestTimeout bounds the requests answered with one JSON document
+const requestTimeout = 30 * time.Second
+
+// Error is an error response of the API
+type Error struct {
+	Status  int    // HTTP status code
+	Message string // The error message of the body, or the status text
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// Client sends requests to one cerberus API
+type Client struct {
+	base  *url.URL
+	token string
+	http  *http.Client
+}
+
+// New returns a client of the API at server, a URL or a host:port as given
+// to -api-addr. token is sent as the bearer token when not empty, which the
+// admin endpoints require.
+func New(server, token string) (*Client, error) {
+	base, err := BaseURL(server)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{base: base, token: token, http: &http.Client{}}, nil
+}
+
+// BaseURL turns a URL or a listen address into the base URL of the API. A
+// listen address on every interface is reached on the loopback one.
+func BaseURL(server string) (*url.URL, error) {
+	if server == "" {
+		return nil, errors.New("no server address")
+	}
+	if !strings.Contains(server, "://") {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server address %q: %v", server, err)
+		}
+		switch host {
+		case "", "0.0.0.0":
+			host = "127.0.0.1"
+		case "::":
+			host = "::1"
+		}
+		server = "http://" + net.JoinHostPort(host, port)
+	}
+	base, err := url.Parse(server)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q: expected http(s)://host:port", server)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	return base, nil
+}
+
+// Devices returns the tracked devices, filtered and sorted by query as
+// GET /api/v1/devices accepts
+func (c *Client) Devices(ctx context.Context, query url.Values) ([]Device, error) {
+	var devices []Device
+	return devices, c.get(ctx, "/api/v1/devices", query, &devices)
+}
+
+// Device returns one device by MAC, device ID or UUID
+func (c *Client) Device(ctx context.Context, id string) (*Device, error) {
+	var device Device
+	if err := c.get(ctx, "/api/v1/devices/"+url.PathEscape(id), nil, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// DeviceHints returns the presentation hints of a device
+func (c *Client) DeviceHints(ctx context.Context, id string) (*DeviceHints, error) {
+	var hints DeviceHints
+	if err := c.get(ctx, "/api/v1/devices/"+url.PathEscape(id)+"/hints", nil, &hints); err != nil {
+		return nil, err
+	}
+	return &hints, nil
+}
+
+// Stats returns the counters of the running instance
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var stats Stats
+	if err := c.get(ctx, "/api/v1/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Patterns calls fn with every persisted pattern matching query, as
+// GET /api/v1/bulk/patterns accepts, following continuations until the
+// export is complete. It needs the admin token.
+func (c *Client) Patterns(ctx context.Context, query url.Values, fn func(*Pattern) error) error {
+	query = cloneValues(query)
+	for {
+		resp, err := c.do(ctx, "/api/v1/bulk/patterns", query)
+		if err != nil {
+			return err
+		}
+		trailer, err := readBulk(resp.Body, fn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if trailer.Complete {
+			return nil
+		}
+		if trailer.Continuation == "" {
+			return errors.New("incomplete bulk response without continuation")
+		}
+		query.Set("after", trailer.Continuation)
+	}
+}
+
+// bulkTrailer is the last line of a bulk response
+type bulkTrailer struct {
+	Trailer      bool   `json:"_trailer"`
+	Continuation string `json:"continuation"`
+	Complete     bool   `json:"complete"`
+}
+
+// readBulk decodes the patterns of one NDJSON bulk response and returns its
+// trailer
+func readBulk(body io.Reader, fn func(*Pattern) error) (bulkTrailer, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var trailer bulkTrailer
+		if json.Unmarshal(line, &trailer) == nil && trailer.Trailer {
+			return trailer, nil
+		}
+		var pattern Pattern
+		if err := json.Unmarshal(line, &pattern); err != nil {
+			return bulkTrailer{}, fmt.Errorf("invalid pattern: %v", err)
+		}
+		if err := fn(&pattern); err != nil {
+			return bulkTrailer{}, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return bulkTrailer{}, err
+	}
+	return bulkTrailer{}, errors.New("bulk response ended without its trailer")
+}
+
+// FollowPatterns calls fn with every new pattern the instance streams,
+// filtered by query as GET /api/v1/patterns/stream accepts, and dropped, if
+// not nil, with the number of patterns the server reports it dropped. It
+// returns when ctx is done, fn fails or the server ends the stream.
+func (c *Client) FollowPatterns(ctx context.Context, query url.Values, fn func(*Pattern) error, dropped func(count int)) error {
+	resp, err := c.do(ctx, "/api/v1/patterns/stream", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	err = readEvents(resp.Body, func(event string, data []byte) error {
+		switch event {
+		case "pattern":
+			var pattern Pattern
+			if err := json.Unmarshal(data, &pattern); err != nil {
+				return fmt.Errorf("invalid pattern: %v", err)
+			}
+			return fn(&pattern)
+		case "dropped":
+			var report struct {
+				Dropped int `json:"dropped"`
+			}
+			if json.Unmarshal(data, &report) == nil && dropped != nil {
+				dropped(report.Dropped)
+			}
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// readEvents calls fn with the type and data of every server-sent event
+func readEvents(body io.Reader, fn func(event string, data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	event, data := "", []byte(nil)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				if err := fn(event, data); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comment, such as a keepalive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	return scanner.Err()
+}
+
+// get sends a GET request and decodes its JSON response into v
+func (c *Client) get(ctx context.Context, path string, query url.Values, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := c.do(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid response from %s: %v", path, err)
+	}
+	return nil
+}
+
+// do sends a GET request and returns its response if successful
+func (c *Client) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		apiErr := &Error{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && body.Error != "" {
+			apiErr.Message = body.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+func cloneValues(values url.Values) url.Values {
+	clone := make(url.Values, len(values))
+	for key, list := range values {
+		clone[key] = append([]string(nil), list...)
+	}
+	return clone
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string // "" for an error
+	}{
+		{"127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{":8080", "http://127.0.0.1:8080"},
+		{"0.0.0.0:8080", "http://127.0.0.1:8080"},
+		{"[::]:8080", "http://[::1]:8080"},
+		{"http://10.0.0.2:8080/", "http://10.0.0.2:8080"},
+		{"https://cerberus.example/api/", "https://cerberus.example/api"},
+		{"", ""},
+		{"localhost", ""},
+		{"ftp://10.0.0.2", ""},
+		{"http://", ""},
+	}
+	for _, tt := range tests {
+		base, err := BaseURL(tt.server)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("BaseURL(%q) = %s, want an error", tt.server, base)
+		case tt.want != "" && (err != nil || base.String() != tt.want):
+			t.Errorf("BaseURL(%q) = %v, %v, want %s", tt.server, base, err, tt.want)
+		}
+	}
+}
+
+// Requests carry the token and query under the base path; error responses
+// become an *Error with the API's message
+func TestRequests(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Authorization")+" "+r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/base/api/v1/devices":
+			fmt.Fprint(w, `[{"id":"aa:bb:cc:dd:ee:01","ip":"10.0.0.5","targets":["1.1.1.1"]}]`)
+		case "/base/api/v1/devices/aa:bb:cc:dd:ee:01/hints":
+			fmt.Fprint(w, `{"icon":"camera","risk_score":12}`)
+		case "/base/api/v1/stats":
+			fmt.Fprint(w, `{"total_devices":3,"interfaces":[{"name":"eth0","utilization":null}]}`)
+		case "/base/api/v1/devices/missing":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"device not found"}`)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, "<html>proxy error</html>")
+		}
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL+"/base", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	devices, err := c.Devices(ctx, url.Values{"sort": {"risk"}})
+	if err != nil || len(devices) != 1 || devices[0].IP != "10.0.0.5" || !slices.Equal(devices[0].Targets.Values(), []string{"1.1.1.1"}) {
+		t.Errorf("Devices = %+v, %v", devices, err)
+	}
+	if hints, err := c.DeviceHints(ctx, "aa:bb:cc:dd:ee:01"); err != nil || hints.Icon != "camera" || hints.RiskScore != 12 {
+		t.Errorf("DeviceHints = %+v, %v", hints, err)
+	}
+	if stats, err := c.Stats(ctx); err != nil || stats.TotalDevices != 3 || len(stats.Interfaces) != 1 || stats.Interfaces[0].Utilization != nil {
+		t.Errorf("Stats = %+v, %v", stats, err)
+	}
+	if want := "Bearer secret /base/api/v1/devices?sort=risk"; requests[0] != want {
+		t.Errorf("request %q, want %q", requests[0], want)
+	}
+
+	var apiErr *Error
+	if _, err := c.Device(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || apiErr.Message != "device not found" {
+		t.Errorf("Device(missing) = %v", err)
+	}
+	if _, err := c.Device(ctx, "other"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadGateway || apiErr.Message != "Bad Gateway" {
+		t.Errorf("Device(other) = %v", err)
+	}
+
+	unauthenticated, _ := New(ts.URL+"/base", "")
+	unauthenticated.Stats(ctx)
+	if last := requests[len(requests)-1]; !strings.HasPrefix(last, " /") {
+		t.Errorf("request without a token sent %q", last)
+	}
+}
+
+// Patterns follows continuations until the export is complete, and fails on
+// an export cut short
+func TestPatterns(t *testing.T) {
+	pages := map[string]string{
+		"": `{"id":"p1","dst_ip":"1.1.1.1"}` + "\n" + `{"id":"p2","dst_ip":"8.8.8.8"}` + "\n" +
+			`{"_trailer":true,"continuation":"next","complete":false}` + "\n",
+		"next": "\n" + `{"id":"p3","dst_ip":"9.9.9.9"}` + "\n" + `{"_trailer":true,"complete":true}` + "\n",
+		"cut":  `{"id":"p4"}` + "\n",
+	}
+	var afters []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		after := r.URL.Query().Get("after")
+		afters = append(afters, after)
+		if r.URL.Query().Get("since") == "cut" {
+			after = "cut"
+		}
+		fmt.Fprint(w, pages[after])
+	}))
+	defer ts.Close()
+
+	c, _ := New(ts.URL, "secret")
+	var ids []string
+	query := url.Values{"since": {"2026-01-01T00:00:00Z"}}
+	err := c.Patterns(context.Background(), query, func(p *Pattern) error {
+		ids = append(ids, p.ID)
+		return nil
+	})
+	if err != nil || !slices.Equal(ids, []string{"p1", "p2", "p3"}) || !slices.Equal(afters, []string{"", "next"}) {
+		t.Errorf("Patterns read %v after %q: %v", ids, afters, err)
+	}
+	if query.Get("after") != "" {
+		t.Error("Patterns changed the caller's query")
+	}
+
+	err = c.Patterns(context.Background(), url.Values{"since": {"cut"}}, func(*Pattern) error { return nil })
+	if err == nil {
+		t.Error("an export without its trailer succeeded")
+	}
+	stop := errors.New("stop")
+	if err := c.Patterns(context.Background(), nil, func(*Pattern) error { return stop }); err != stop {
+		t.Errorf("Patterns = %v, want the callback's error", err)
+	}
+}
+
+// FollowPatterns decodes pattern events, reports drops and skips comments and
+// other events, until the server ends the stream
+func TestFollowPatterns(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("device") != "aa:bb:cc:dd:ee:01" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: hello\ndata: {}\n\n")
+		fmt.Fprint(w, ": keepalive\n\n")
+		fmt.Fprint(w, "event: pattern\nid: p1\ndata: {\"id\":\"p1\",\"dst_port\":443}\n\n")
+		fmt.Fprint(w, "event: dropped\ndata: {\"dropped\":7}\n\n")
+		fmt.Fprint(w, "event: pattern\ndata: {\"id\":\"p2\",\ndata: \"dst_port\":53}\n\n")
+	}))
+	defer ts.Close()
+
+	c, _ := New(ts.URL, "")
+	var got []string
+	dropped := 0
+	err := c.FollowPatterns(context.Background(), url.Values{"device": {"aa:bb:cc:dd:ee:01"}},
+		func(p *Pattern) error {
+			got = append(got, fmt.Sprintf("%s:%d", p.ID, p.DstPort))
+			return nil
+		},
+		func(count int) { dropped += count })
+	if err != nil || !slices.Equal(got, []string{"p1:443", "p2:53"}) || dropped != 7 {
+		t.Errorf("followed %v, %d dropped: %v", got, dropped, err)
+	}
+
+	var apiErr *Error
+	if err := c.FollowPatterns(context.Background(), nil, func(*Pattern) error { return nil }, nil); !errors.As(err, &apiErr) {
+		t.Errorf("FollowPatterns of a refused stream = %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/network"
+)
+
+// runCheck prints the detected topology and the recommended attach targets
+func runCheck() {
+	topo, err := network.DetectNetworkTopology()
+	if err != nil {
+		log.Fatalf("topology detection failed: %v", err)
+	}
+
+	topo.PrintTopology()
+
+	fmt.Println("\nRecommended interfaces:")
+	for _, rec := range topo.InterfaceRecommendations() {
+		mark := "✗"
+		if rec.Recommended {
+			mark = "✓"
+		}
+		fmt.Printf("  %s %-12s %-18s %s\n", mark, rec.Name, rec.Subnet, rec.Reason)
+	}
+
+	if names := topo.RecommendedInterfaces(); len(names) > 0 {
+		fmt.Printf("\nDefault attach set: -interfaces %s\n", strings.Join(names, ","))
+	} else {
+		fmt.Println("\nNo physical interface detected; cerberus will attach to all interfaces")
+	}
+}
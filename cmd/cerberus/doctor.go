@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/tidwall/buntdb"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/capture"
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/network"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Outcomes of a doctor check. Only failed checks make doctor exit non-zero;
+// problems cerberus runs with anyway are warnings.
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip" // A check it depends on failed
+)
+
+// tcxMinKernel is the first kernel version with TCX, 6.6
+const tcxMinKernel = 6<<16 | 6<<8
+
+// Capabilities needed to load and attach the BPF program
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+	capBPF      = 39
+)
+
+// doctorCheck is the result of one doctor check
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Data   any    `json:"data,omitempty"`
+}
+
+// doctorReport is the output of 'cerberus doctor -json'
+type doctorReport struct {
+	Time   time.Time     `json:"time"`
+	Checks []doctorCheck `json:"checks"`
+	OK     bool          `json:"ok"` // No check failed
+}
+
+func (r *doctorReport) add(name, status, detail string, data any) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Detail: detail, Data: data})
+	if status == checkFail {
+		r.OK = false
+	}
+}
+
+// doctorInterface is an interface as doctor sees it
+type doctorInterface struct {
+	Name        string `json:"name"`
+	Up          bool   `json:"up"`
+	Loopback    bool   `json:"loopback"`
+	Recommended bool   `json:"recommended"`
+	Attach      bool   `json:"attach"` // Attached to under the given flags
+	Reason      string `json:"reason,omitempty"`
+}
+
+// doctorSample is what the live capture saw on one interface
+type doctorSample struct {
+	Interface string         `json:"interface"`
+	Error     string         `json:"error,omitempty"` // Attaching failed
+	Events    map[string]int `json:"events"`          // Event type -> events
+	Total     int            `json:"total"`
+}
+
+// runDoctor checks everything cerberus needs to capture traffic, using the
+// same code paths as a normal run, and returns the exit status
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON, e.g. to attach to a bug report")
+	interfacesFlag := flags.String("interfaces", "", "Interfaces cerberus would be run with (default: recommended physical interfaces)")
+	allInterfaces := flags.Bool("all-interfaces", false, "Check as if cerberus were run with -all-interfaces")
+	apiAddr := flags.String("api-addr", "127.0.0.1:8080", "API listen address cerberus would be run with (empty skips the check)")
+	sample := flags.Duration("sample", 10*time.Second, "How long to capture traffic on each attached interface")
+	flags.Parse(args)
+	if _, err := applyEnv(flags, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	report := &doctorReport{Time: time.Now(), OK: true}
+
+	privileged := doctorPrivileges(report)
+	doctorKernel(report, privileged)
+	coll, layout := doctorBPFObject(report, privileged)
+	if coll != nil {
+		defer coll.Close()
+	}
+	candidates := doctorInterfaces(report, *interfacesFlag, *allInterfaces)
+	doctorCapture(report, coll, layout, candidates, *sample)
+	doctorDataDir(report)
+	doctorCaches(report)
+	doctorAPI(report, *apiAddr)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		printDoctorReport(report)
+	}
+	if !report.OK {
+		return 1
+	}
+	return 0
+}
+
+// effectiveCapabilities reads the effective capability set of the process
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, errors.New("CapEff not found in /proc/self/status")
+}
+
+func doctorPrivileges(report *doctorReport) bool {
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		report.add("privileges", checkFail, fmt.Sprintf("cannot read capabilities: %v", err), nil)
+		return false
+	}
+
+	has := func(capability int) bool { return caps&(1<<capability) != 0 }
+	var missing []string
+	if !has(capNetAdmin) {
+		missing = append(missing, "CAP_NET_ADMIN")
+	}
+	if !has(capBPF) && !has(capSysAdmin) {
+		missing = append(missing, "CAP_BPF (or CAP_SYS_ADMIN)")
+	}
+	if len(missing) > 0 {
+		report.add("privileges", checkFail,
+			fmt.Sprintf("missing %s, run as root or with sudo", strings.Join(missing, " and ")), nil)
+		return false
+	}
+	report.add("privileges", checkOK, fmt.Sprintf("uid %d with the needed capabilities", os.Getuid()), nil)
+	return true
+}
+
+func doctorKernel(report *doctorReport, privileged bool) {
+	release := "unknown"
+	if data, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		release = strings.TrimSpace(string(data))
+	}
+
+	version, err := features.LinuxVersionCode()
+	if err != nil {
+		report.add("kernel", checkFail, fmt.Sprintf("cannot determine the kernel version: %v", err), nil)
+		return
+	}
+	if version < tcxMinKernel {
+		report.add("kernel", checkFail,
+			fmt.Sprintf("kernel %s has no TCX support, cerberus needs 6.6 or later", release), nil)
+		return
+	}
+	if privileged {
+		if err := features.HaveProgramType(ebpf.SchedCLS); err != nil {
+			report.add("kernel", checkFail, fmt.Sprintf("kernel %s can't load TC programs: %v", release, err), nil)
+			return
+		}
+	}
+	report.add("kernel", checkOK, fmt.Sprintf("kernel %s supports TCX", release), nil)
+}
+
+// doctorBPFObject loads the BPF object like a normal run, reporting the
+// verifier log if the kernel rejects it, and returns the event layout it emits
+func doctorBPFObject(report *doctorReport, privileged bool) (*ebpf.Collection, uint32) {
+	if !privileged {
+		report.add("bpf object", checkSkip, "loading needs the privileges above", nil)
+		return nil, 0
+	}
+
+	coll, layout, err := capture.LoadCollection(capture.Object, "", log.Default())
+	if err != nil {
+		detail := err.Error()
+		var verifierErr *ebpf.VerifierError
+		if errors.As(err, &verifierErr) {
+			detail = fmt.Sprintf("rejected by the verifier:\n%+v", verifierErr)
+		}
+		report.add("bpf object", checkFail, detail, nil)
+		return nil, 0
+	}
+	if _, err := capture.ClassifierProgram(coll); err != nil {
+		coll.Close()
+		report.add("bpf object", checkFail, err.Error(), nil)
+		return nil, 0
+	}
+	if layout != models.EventLayoutVersion {
+		report.add("bpf object", checkWarn, fmt.Sprintf("%s loaded and verified, but emits event layout %d while this build expects %d; rebuild it with 'make bpf'",
+			capture.Object, layout, models.EventLayoutVersion), nil)
+		return coll, layout
+	}
+	report.add("bpf object", checkOK, fmt.Sprintf("%s loaded and verified, event layout %d", capture.Object, layout), nil)
+	return coll, layout
+}
+
+// doctorInterfaces lists the interfaces and returns those cerberus would
+// attach to under the given flags
+func doctorInterfaces(report *doctorReport, list string, all bool) []net.Interface {
+	topo, err := network.DetectNetworkTopology()
+	if err != nil {
+		report.add("interfaces", checkFail, fmt.Sprintf("topology detection failed: %v", err), nil)
+		return nil
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		report.add("interfaces", checkFail, fmt.Sprintf("cannot list interfaces: %v", err), nil)
+		return nil
+	}
+	attachSet, _, err := capture.SelectInterfaces(topo, list, all)
+	if err != nil {
+		report.add("interfaces", checkFail, fmt.Sprintf("invalid -interfaces value: %v", err), nil)
+		return nil
+	}
+	candidates := capture.AttachCandidates(ifaces, attachSet)
+
+	recommendations := make(map[string]network.InterfaceRecommendation)
+	for _, rec := range topo.InterfaceRecommendations() {
+		recommendations[rec.Name] = rec
+	}
+	attach := make(map[string]bool)
+	for _, iface := range candidates {
+		attach[iface.Name] = true
+	}
+	listed := make([]doctorInterface, 0, len(ifaces))
+	names := make([]string, 0, len(candidates))
+	for _, iface := range ifaces {
+		listed = append(listed, doctorInterface{
+			Name:        iface.Name,
+			Up:          iface.Flags&net.FlagUp != 0,
+			Loopback:    iface.Flags&net.FlagLoopback != 0,
+			Recommended: recommendations[iface.Name].Recommended,
+			Attach:      attach[iface.Name],
+			Reason:      recommendations[iface.Name].Reason,
+		})
+		if attach[iface.Name] {
+			names = append(names, iface.Name)
+		}
+	}
+
+	if len(candidates) == 0 {
+		report.add("interfaces", checkFail, "no up, non-loopback interface to attach to", listed)
+		return nil
+	}
+	detail := "would attach to " + strings.Join(names, ", ")
+	switch {
+	case all:
+		detail += " (-all-interfaces)"
+	case list != "":
+		detail += " (-interfaces)"
+	case attachSet == nil:
+		detail += " (no physical interface detected, so every interface)"
+	default:
+		detail += " (recommended)"
+	}
+	report.add("interfaces", checkOK, detail, listed)
+	return candidates
+}
+
+// doctorCapture attaches to each interface like a normal run and counts the
+// events parsed from the ring buffer for the sample duration
+func doctorCapture(report *doctorReport, coll *ebpf.Collection, layout uint32, candidates []net.Interface, sample time.Duration) {
+	if coll == nil || len(candidates) == 0 {
+		report.add("capture", checkSkip, "needs the BPF object and an interface to attach to", nil)
+		return
+	}
+
+	prog, _ := capture.ClassifierProgram(coll)
+	eventsMap := coll.Maps["events"]
+	if eventsMap == nil {
+		report.add("capture", checkFail, "Ring buffer map 'events' not found", nil)
+		return
+	}
+	reader, err := ringbuf.NewReader(eventsMap)
+	if err != nil {
+		report.add("capture", checkFail, fmt.Sprintf("failed to open ring buffer: %v", err), nil)
+		return
+	}
+	defer reader.Close()
+
+	samples := make(map[uint32]*doctorSample)
+	var ordered []*doctorSample
+	var links []link.Link
+	for _, iface := range candidates {
+		s := &doctorSample{Interface: iface.Name, Events: make(map[string]int)}
+		ordered = append(ordered, s)
+		l, err := capture.AttachTCX(prog, iface.Index)
+		if err != nil {
+			s.Error = err.Error()
+			continue
+		}
+		links = append(links, l)
+		samples[uint32(iface.Index)] = s
+	}
+	defer func() {
+		for _, l := range links {
+			l.Close()
+		}
+	}()
+	if len(links) == 0 {
+		report.add("capture", checkFail, "failed to attach to any interface", ordered)
+		return
+	}
+
+	short := 0
+	reader.SetDeadline(time.Now().Add(sample))
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, ringbuf.ErrClosed) {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		if len(record.RawSample) < utils.MinEventSize(layout) {
+			short++
+			continue
+		}
+		evt := utils.ParseNetworkEvent(record.RawSample, layout)
+		s := samples[evt.IfIndex]
+		if s == nil {
+			continue
+		}
+		name, ok := models.EventTypeNames[evt.EventType]
+		if !ok {
+			name = "unknown"
+		}
+		s.Events[name]++
+		s.Total++
+	}
+
+	var silent, failed []string
+	total := 0
+	for _, s := range ordered {
+		total += s.Total
+		if s.Error != "" {
+			failed = append(failed, s.Interface)
+		} else if s.Total == 0 {
+			silent = append(silent, s.Interface)
+		}
+	}
+
+	status, detail := checkOK, fmt.Sprintf("%d events in %s", total, sample)
+	var problems []string
+	if len(failed) > 0 {
+		problems = append(problems, "failed to attach to "+strings.Join(failed, ", "))
+	}
+	if len(silent) > 0 {
+		problems = append(problems, "no traffic on "+strings.Join(silent, ", "))
+	}
+	if short > 0 {
+		problems = append(problems, fmt.Sprintf("%d events shorter than %d bytes, the BPF object may not match this build", short, utils.MinEventSize(layout)))
+	}
+	if len(problems) > 0 {
+		status = checkWarn
+		detail += "; " + strings.Join(problems, "; ")
+	}
+	report.add("capture", status, detail, ordered)
+}
+
+func doctorDataDir(report *doctorReport) {
+	info, err := os.Stat(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		// Created at startup, so its parent must be writable
+		probe, err := os.CreateTemp(filepath.Dir(filepath.Clean(dataDir)), ".cerberus-probe-*")
+		if err != nil {
+			report.add("data directory", checkFail, fmt.Sprintf("%s doesn't exist and can't be created: %v", dataDir, err), nil)
+			return
+		}
+		probe.Close()
+		os.Remove(probe.Name())
+		report.add("data directory", checkOK, fmt.Sprintf("%s doesn't exist yet and will be created", dataDir), nil)
+		return
+	}
+	if err != nil || !info.IsDir() {
+		report.add("data directory", checkFail, fmt.Sprintf("%s is not a usable directory: %v", dataDir, err), nil)
+		return
+	}
+	if err := checkWritable(dataDir); err != nil {
+		report.add("data directory", checkFail, fmt.Sprintf("%s is not writable: %v", dataDir, err), nil)
+		return
+	}
+
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		report.add("data directory", checkOK, fmt.Sprintf("%s is writable, the database will be created", dataDir), nil)
+		return
+	}
+	db, err := buntdb.Open(dbPath)
+	if errors.Is(err, buntdb.ErrInvalid) {
+		report.add("data directory", checkWarn, fmt.Sprintf("%s is corrupt; it will be moved aside and replaced with what can be salvaged at startup (unless -db-fail-hard)", dbPath), nil)
+		return
+	}
+	if err != nil {
+		report.add("data directory", checkFail, fmt.Sprintf("cannot open %s: %v", dbPath, err), nil)
+		return
+	}
+	defer db.Close()
+	keys := 0
+	db.View(func(tx *buntdb.Tx) error {
+		keys, err = tx.Len()
+		return err
+	})
+	report.add("data directory", checkOK, fmt.Sprintf("%s is writable and %s opens (%d keys)", dataDir, dbPath, keys), nil)
+}
+
+// doctorCaches reports the age of the vendor and service caches, which fall
+// back to small built-in lists when missing or outdated
+func doctorCaches(report *doctorReport) {
+	caches := []struct {
+		name   string
+		path   string
+		maxAge time.Duration
+	}{
+		{"OUI vendors", filepath.Join(databases.CACHE_DIR, databases.OUI_CACHE_FILE), databases.CACHE_VALID_DAYS * 24 * time.Hour},
+		{"IANA services", filepath.Join(databases.CACHE_DIR, databases.SERVICES_CACHE_FILE), databases.SERVICES_CACHE_DAYS * 24 * time.Hour},
+	}
+
+	status := checkOK
+	var details []string
+	for _, cache := range caches {
+		info, err := os.Stat(cache.path)
+		switch {
+		case err != nil:
+			status = checkWarn
+			details = append(details, fmt.Sprintf("%s: %s missing, using the built-in fallback", cache.name, cache.path))
+		case time.Since(info.ModTime()) > cache.maxAge:
+			status = checkWarn
+			details = append(details, fmt.Sprintf("%s: %s is %s old (refreshed after %s), using the built-in fallback",
+				cache.name, cache.path, time.Since(info.ModTime()).Round(time.Hour), cache.maxAge))
+		default:
+			details = append(details, fmt.Sprintf("%s: %s is %s old", cache.name, cache.path, time.Since(info.ModTime()).Round(time.Hour)))
+		}
+	}
+	report.add("caches", status, strings.Join(details, "; "), nil)
+}
+
+func doctorAPI(report *doctorReport, addr string) {
+	if addr == "" {
+		report.add("api", checkOK, "disabled", nil)
+		return
+	}
+	if err := api.ValidateListenAddr(addr); err != nil {
+		report.add("api", checkFail, err.Error(), nil)
+		return
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		report.add("api", checkFail, fmt.Sprintf("cannot listen on %s: %v (is cerberus already running?)", addr, err), nil)
+		return
+	}
+	listener.Close()
+	report.add("api", checkOK, fmt.Sprintf("%s is available", addr), nil)
+}
+
+func printDoctorReport(report *doctorReport) {
+	marks := map[string]string{checkOK: "✓", checkWarn: "!", checkFail: "✗", checkSkip: "-"}
+	for _, check := range report.Checks {
+		lines := strings.Split(check.Detail, "\n")
+		fmt.Printf("%s %-15s %s\n", marks[check.Status], check.Name, lines[0])
+		for _, line := range lines[1:] {
+			fmt.Printf("  %-15s %s\n", "", line)
+		}
+
+		if samples, ok := check.Data.([]*doctorSample); ok {
+			for _, s := range samples {
+				fmt.Printf("  %-15s %s\n", "", formatSample(s))
+			}
+		}
+	}
+
+	if report.OK {
+		fmt.Println("\nNo problem found that would stop cerberus")
+	} else {
+		fmt.Println("\nCerberus can't run until the failed checks (✗) are fixed")
+	}
+}
+
+// formatSample summarizes a capture sample on one line
+func formatSample(s *doctorSample) string {
+	if s.Error != "" {
+		return fmt.Sprintf("%s: attach failed: %s", s.Interface, s.Error)
+	}
+	types := make([]string, 0, len(s.Events))
+	for name := range s.Events {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for i, name := range types {
+		types[i] = fmt.Sprintf("%s=%d", name, s.Events[name])
+	}
+	if len(types) == 0 {
+		return fmt.Sprintf("%s: no events", s.Interface)
+	}
+	return fmt.Sprintf("%s: %d events (%s)", s.Interface, s.Total, strings.Join(types, " "))
+}
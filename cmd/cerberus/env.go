@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// envPrefix prefixes the environment variable of every flag: -api-admin-token
+// is read from CERBERUS_API_ADMIN_TOKEN
+const envPrefix = "CERBERUS_"
+
+// Where the value of a flag came from, by precedence
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+// listFlags are the comma-separated flags. Their environment variables drop
+// blanks around items and empty items, so a trailing comma is harmless.
+var listFlags = map[string]bool{
+	"interfaces":          true,
+	"events":              true,
+	"capture-subnets":     true,
+	"payload-bytes":       true,
+	"event-payload-bytes": true,
+	"routed-cidrs":        true,
+	"trusted-cidrs":       true,
+	"risk-weights":        true,
+	"resource-limits":     true,
+	"dns-allow":           true,
+	"direct-ip-allow":     true,
+	"arp-mismatch-allow":  true,
+	"port-share-watch":    true,
+	"infra-roles":         true,
+	"guest-interfaces":    true,
+	"guest-subnets":       true,
+	"threat-lists":        true,
+	"ip-reservations":     true,
+}
+
+// commandFlags only make sense on the command line. CERBERUS_VERSION in
+// particular is often set by images for their own purposes.
+var commandFlags = map[string]bool{
+	"version":      true,
+	"print-config": true,
+}
+
+// secretFlags are masked wherever the configuration is shown
+var secretFlags = map[string]bool{
+	"api-admin-token": true,
+	"influx-token":    true,
+}
+
+// envName returns the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag not given on the command line from its environment
+// variable, if set, and returns where each flag's value came from. A variable
+// set to the empty string sets the flag to the empty string, which only
+// string and list flags accept.
+func applyEnv(flags *flag.FlagSet, lookup func(string) (string, bool)) (map[string]string, error) {
+	sources := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		sources[f.Name] = sourceFlag
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if err != nil || sources[f.Name] != "" {
+			return
+		}
+		value, ok := lookup(envName(f.Name))
+		if !ok || commandFlags[f.Name] {
+			sources[f.Name] = sourceDefault
+			return
+		}
+
+		if boolFlag, isBool := f.Value.(interface{ IsBoolFlag() bool }); isBool && boolFlag.IsBoolFlag() {
+			if value, ok = parseEnvBool(value); !ok {
+				err = fmt.Errorf("invalid %s value %q: expected 1, true, yes, 0, false or no", envName(f.Name), value)
+				return
+			}
+		} else if listFlags[f.Name] {
+			value = cleanList(value)
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid %s value %q: %v", envName(f.Name), value, setErr)
+			return
+		}
+		sources[f.Name] = sourceEnv
+	})
+	return sources, err
+}
+
+// parseEnvBool turns 1/true/yes/on and 0/false/no/off, in any case, into a
+// value the flag package accepts
+func parseEnvBool(value string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return "true", true
+	case "0", "f", "false", "n", "no", "off":
+		return "false", true
+	}
+	return value, false
+}
+
+// cleanList trims the items of a comma-separated list and drops empty ones
+func cleanList(value string) string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// displayValue returns the value of a flag as shown in the configuration,
+// with secrets and URL passwords masked
+func displayValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if value == "" {
+		return `""`
+	}
+	if secretFlags[f.Name] {
+		return "****"
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// printConfig writes every flag with its effective value and source, one per
+// line, in the order of their names
+func printConfig(w io.Writer, flags *flag.FlagSet, sources map[string]string) {
+	flags.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "%s = %s (%s)\n", f.Name, displayValue(f), sources[f.Name])
+	})
+}
+
+// configSummary lists the flags not left at their default, with their source,
+// on one line
+func configSummary(flags *flag.FlagSet, sources map[string]string) string {
+	var set []string
+	flags.VisitAll(func(f *flag.Flag) {
+		if source := sources[f.Name]; source != sourceDefault {
+			set = append(set, fmt.Sprintf("%s=%s (%s)", f.Name, displayValue(f), source))
+		}
+	})
+	if len(set) == 0 {
+		return "all defaults"
+	}
+	return strings.Join(set, ", ")
+}
@@ -1,221 +1,114 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"flag"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
-	"time"
 
-	"github.com/cilium/ebpf"
-	"github.com/cilium/ebpf/link"
-	"github.com/cilium/ebpf/ringbuf"
+	"github.com/zrougamed/cerberus"
+	"github.com/zrougamed/cerberus/internal/version"
+)
 
-	"github.com/zrougamed/cerberus/internal/monitor"
-	"github.com/zrougamed/cerberus/internal/utils"
+// Paths of the data directory and the database in it
+var (
+	dataDir = cerberus.DataDir
+	dbPath  = filepath.Join(cerberus.DataDir, cerberus.DatabaseFile)
 )
 
 func main() {
-	// Clean up any existing TC hooks
-	utils.CleanCards()
-
-	// Ensure the data directory exists
-	err := os.MkdirAll("./data", 0755)
-	if err != nil {
-		log.Fatalf("failed to create data directory: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck()
+		return
 	}
-
-	// Initialize monitor
-	mon, err := monitor.NewNetworkMonitor(1000, "./data/network.db")
-	if err != nil {
-		panic(err)
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:]))
 	}
-	defer mon.Close()
-
-	// Load BPF collection from compiled object file
-	spec, err := ebpf.LoadCollectionSpec("cerberus_tc.o")
-	if err != nil {
-		panic(fmt.Errorf("failed to load BPF spec: %w", err))
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		os.Exit(runCleanup(os.Args[2:]))
 	}
-
-	coll, err := ebpf.NewCollection(spec)
-	if err != nil {
-		panic(fmt.Errorf("failed to create BPF collection: %w", err))
+	if len(os.Args) > 1 && queryCommands[os.Args[1]] != nil {
+		os.Exit(queryCommands[os.Args[1]](os.Args[2:]))
 	}
-	defer coll.Close()
 
-	// Get the classifier program
-	prog := coll.Programs["xdp_arp_monitor"]
-	if prog == nil {
-		panic("BPF program 'xdp_arp_monitor' not found in object file")
-	}
+	flags := cerberus.RegisterFlags(flag.CommandLine)
+	runAsUser := flag.String("user", "", "User (name or uid) to drop root privileges to once capture is set up (empty keeps running as root)")
+	runAsGroup := flag.String("group", "", "Group (name or gid) to drop to with -user (default: the user's primary group)")
+	showVersion := flag.Bool("version", false, "Print the build version and exit")
+	showConfig := flag.Bool("print-config", false, "Print the effective value of every flag and where it came from (flag, "+envPrefix+"* environment variable or default), then exit")
+	flag.Parse()
 
-	// Get all network interfaces
-	ifaces, err := net.Interfaces()
+	sources, err := applyEnv(flag.CommandLine, os.LookupEnv)
 	if err != nil {
-		panic(err)
+		log.Fatalf("%v", err)
 	}
 
-	fmt.Println("Scanning for network interfaces...")
-
-	var links []link.Link
-	attachedCount := 0
-
-	for _, iface := range ifaces {
-		// Skip loopback and down interfaces
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
-			continue
-		}
-
-		fmt.Printf("Attaching to %s...\n", iface.Name)
-
-		// Attach using TCX (modern TC hook mechanism)
-		// TCX is the new way to attach TC programs, replacing the old clsact qdisc approach
-		l, err := link.AttachTCX(link.TCXOptions{
-			Interface: iface.Index,
-			Program:   prog,
-			Attach:    ebpf.AttachTCXIngress,
-		})
-		if err != nil {
-			fmt.Printf("Failed to attach to %s: %v\n", iface.Name, err)
-			continue
-		}
-
-		links = append(links, l)
-		attachedCount++
-		fmt.Printf("Successfully attached to %s\n", iface.Name)
+	if *showVersion {
+		info := version.Info(nil)
+		fmt.Printf("cerberus %s (commit %s, built %s, %s, event layout %d)\n",
+			info.Version, info.Commit, info.BuildDate, info.GoVersion, info.EventLayout)
+		return
 	}
-
-	if attachedCount == 0 {
-		panic("Failed to attach to any interface!")
+	if *showConfig {
+		printConfig(os.Stdout, flag.CommandLine, sources)
+		return
 	}
 
-	fmt.Printf("\nMonitoring %d interface(s)\n\n", attachedCount)
-
-	// Cleanup hooks on exit
-	defer func() {
-		fmt.Println("\nCleaning up hooks...")
-		for _, l := range links {
-			if err := l.Close(); err != nil {
-				fmt.Printf("Error cleaning up link: %v\n", err)
-			}
+	var dropTo *credentials
+	if *runAsUser != "" {
+		dropTo, err = lookupCredentials(*runAsUser, *runAsGroup)
+		if err != nil {
+			log.Fatalf("invalid -user/-group value: %v", err)
 		}
-	}()
-
-	// Open ring buffer for event communication
-	eventsMap := coll.Maps["events"]
-	if eventsMap == nil {
-		panic("Ring buffer map 'events' not found")
+	} else if *runAsGroup != "" {
+		log.Fatalf("-group requires -user")
+	}
+	// Attaching needs the privileges -user gives up
+	if flags.Reattach() && dropTo != nil {
+		log.Fatalf("-interface-reattach can't be combined with -user")
 	}
 
-	reader, err := ringbuf.NewReader(eventsMap)
+	opts, err := flags.Options()
 	if err != nil {
-		panic(fmt.Errorf("failed to open ring buffer: %w", err))
+		log.Fatalf("%v", err)
 	}
-	defer reader.Close()
-
-	fmt.Println("Monitoring network traffic... Press Ctrl+C to exit")
-	fmt.Println("Stats will be printed every 60 seconds")
-
-	// Debug ticker to show we're alive
-	debugTicker := time.NewTicker(10 * time.Second)
-	defer debugTicker.Stop()
-
-	go func() {
-		for range debugTicker.C {
-			fmt.Printf("Alive - Packets: Total=%d ARP=%d TCP=%d UDP=%d ICMP=%d DNS=%d HTTP=%d TLS=%d | Devices=%d\n",
-				mon.Stats.TotalPackets,
-				mon.Stats.ArpPackets,
-				mon.Stats.TcpPackets,
-				mon.Stats.UdpPackets,
-				mon.Stats.IcmpPackets,
-				mon.Stats.DnsPackets,
-				mon.Stats.HttpPackets,
-				mon.Stats.TlsPackets,
-				mon.Cache.Len())
-		}
-	}()
-
-	// Statistics ticker
-	statsTicker := time.NewTicker(60 * time.Second)
-	defer statsTicker.Stop()
-
-	go func() {
-		for range statsTicker.C {
-			mon.PrintStats()
-		}
-	}()
-
-	// Event processor goroutine
-	go func() {
-		eventCount := 0
-		// Expected packet size: 79 bytes as defined in cerberus_tc.c
-		expectedSize := 79
-
-		for {
-			// Read event from ring buffer
-			record, err := reader.Read()
-			if err != nil {
-				if errors.Is(err, ringbuf.ErrClosed) {
-					fmt.Println("Ring buffer closed, stopping event processor")
-					return
-				}
-				fmt.Printf("Error reading from ring buffer: %v\n", err)
-				continue
+	fmt.Printf("Configuration: %s\n", configSummary(flag.CommandLine, sources))
+
+	// Programs are attached and every ring buffer is open, which is all that
+	// needs root. Reading the ring buffers, detaching the links on exit and
+	// serving the already bound API socket work unprivileged.
+	opts = append(opts, cerberus.WithCaptureStarted(func() error {
+		if dropTo != nil {
+			if err := dropPrivileges(dropTo); err != nil {
+				return fmt.Errorf("failed to drop privileges: %w", err)
 			}
-
-			eventCount++
-
-			// Validate packet size
-			if len(record.RawSample) < expectedSize {
-				fmt.Printf("Short packet: %d bytes (expected %d)\n",
-					len(record.RawSample), expectedSize)
-				continue
-			}
-
-			// Parse network event
-			evt := utils.ParseNetworkEvent(record.RawSample)
-
-			// Debug: Print first 10 events to verify parsing
-			if eventCount <= 10 {
-				eventTypeStr := "UNKNOWN"
-				switch evt.EventType {
-				case 1:
-					eventTypeStr = "ARP"
-				case 2:
-					eventTypeStr = "TCP"
-				case 3:
-					eventTypeStr = "UDP"
-				case 4:
-					eventTypeStr = "ICMP"
-				case 5:
-					eventTypeStr = "DNS"
-				case 6:
-					eventTypeStr = "HTTP"
-				case 7:
-					eventTypeStr = "TLS"
-				}
-
-				fmt.Printf("Event #%d: Type=%s(%d) SrcIP=%s DstIP=%s SrcPort=%d DstPort=%d\n",
-					eventCount, eventTypeStr, evt.EventType,
-					utils.IntToIP(evt.SrcIP), utils.IntToIP(evt.DstIP),
-					evt.SrcPort, evt.DstPort)
+			fmt.Printf("Dropped privileges to uid %d, gid %d\n", dropTo.uid, dropTo.gid)
+			if err := checkWritable(dataDir); err != nil {
+				fmt.Printf("Warning: data directory not writable after dropping privileges, persistence will fail: %v (fix with: chown -R %d:%d %s)\n",
+					err, dropTo.uid, dropTo.gid, dataDir)
 			}
-
-			// Track event in monitor
-			mon.TrackEvent(evt)
 		}
-	}()
 
-	// Wait for interrupt signal
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	<-sig
+		fmt.Println("Monitoring network traffic... Press Ctrl+C to exit")
+		fmt.Println("Stats will be printed every 60 seconds")
+		return nil
+	}))
 
-	fmt.Println("\n\nFinal Statistics:")
-	mon.PrintStats()
+	runner, err := cerberus.New(opts...)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer runner.Shutdown(context.Background())
+
+	// Capture until interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runner.Run(ctx); err != nil {
+		log.Fatalf("%v", err)
+	}
 	fmt.Println("Shutting down...")
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zrougamed/cerberus/internal/capture"
+)
+
+// runCleanup removes the pinned maps and links of previous runs. Unpinning
+// the links detaches the classifier from every interface.
+func runCleanup(args []string) int {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	pinPath := flags.String("pin-path", capture.DefaultPinPath, "Directory on the BPF filesystem the maps and links were pinned in")
+	flags.Parse(args)
+	if _, err := applyEnv(flags, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	entries, err := os.ReadDir(*pinPath)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Nothing pinned in %s\n", *pinPath)
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot read %s: %v\n", *pinPath, err)
+		return 1
+	}
+
+	links, _ := os.ReadDir(filepath.Join(*pinPath, capture.LinksDir))
+	maps := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			maps++
+		}
+	}
+	if err := os.RemoveAll(*pinPath); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot remove %s: %v\n", *pinPath, err)
+		return 1
+	}
+	fmt.Printf("Removed %s: %d maps and %d links unpinned\n", *pinPath, maps, len(links))
+	return 0
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// credentials identify the unprivileged user cerberus drops to
+type credentials struct {
+	uid, gid int
+}
+
+// lookupCredentials resolves a user and an optional group, each given as a name
+// or a numeric ID. Without a group the user's primary group is used.
+func lookupCredentials(userName, groupName string) (*credentials, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return nil, fmt.Errorf("unknown user %q", userName)
+		}
+	}
+
+	gid := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return nil, fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		gid = g.Gid
+	}
+
+	creds := &credentials{}
+	if creds.uid, err = strconv.Atoi(u.Uid); err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric uid %q", userName, u.Uid)
+	}
+	if creds.gid, err = strconv.Atoi(gid); err != nil {
+		return nil, fmt.Errorf("group has non-numeric gid %q", gid)
+	}
+	if creds.uid == 0 {
+		return nil, fmt.Errorf("user %q is root", userName)
+	}
+	return creds, nil
+}
+
+// dropPrivileges switches every thread to the unprivileged user. Leaving uid 0
+// clears all capabilities, so root can't be regained afterwards.
+func dropPrivileges(creds *credentials) error {
+	if err := syscall.Setgroups([]int{creds.gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(creds.gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(creds.uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	if syscall.Setuid(0) == nil {
+		return errors.New("root privileges could be regained")
+	}
+	return nil
+}
+
+// checkWritable verifies that files can be created in dir, as the database
+// needs when compacting, and that the files already in it can be written
+func checkWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".cerberus-probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file, err := os.OpenFile(filepath.Join(dir, entry.Name()), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+	return nil
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zrougamed/cerberus/client"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// queryCommands are the subcommands querying the API of a running instance
+var queryCommands = map[string]func(args []string) int{
+	"devices":  runDevices,
+	"device":   runDevice,
+	"patterns": runPatterns,
+	"stats":    runStats,
+}
+
+// Where the query subcommands print, replaced by tests
+var (
+	queryOut = os.Stdout
+	queryErr = os.Stderr
+)
+
+// deviceListFields are the device fields the devices subcommand requests
+const deviceListFields = "id,ip,name,vendor,first_seen,last_seen,health,hints"
+
+// cellReplacer keeps a value on one line and in one tab-separated field
+var cellReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// queryFlags adds the flags locating the API to a subcommand. The address
+// and token default to those cerberus itself reads, so a shell with the
+// service's environment reaches it without flags.
+func queryFlags(flags *flag.FlagSet) (server, apiAddr, token *string) {
+	server = flags.String("server", "", "URL of the cerberus API, e.g. http://10.0.0.2:8080 (default: derived from -api-addr)")
+	apiAddr = flags.String("api-addr", "127.0.0.1:8080", "Listen address of the running cerberus, used when -server is not set")
+	token = flags.String("api-admin-token", "", "Bearer token of the running cerberus, needed by admin endpoints")
+	return server, apiAddr, token
+}
+
+// parseQuery parses the flags of a subcommand, which may follow its
+// positional arguments, applies their environment variables and returns the
+// client and the positional arguments
+func parseQuery(flags *flag.FlagSet, args []string, server, apiAddr, token *string) (*client.Client, []string, bool) {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if _, err := applyEnv(flags, os.LookupEnv); err != nil {
+		fmt.Fprintln(queryErr, err)
+		return nil, nil, false
+	}
+
+	address := *server
+	if address == "" {
+		address = *apiAddr
+	}
+	c, err := client.New(address, *token)
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return nil, nil, false
+	}
+	return c, positional, true
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// table writes rows aligned under a header on a terminal, and as plain
+// tab-separated values without the header otherwise, for scripts
+type table struct {
+	out   io.Writer
+	tw    *tabwriter.Writer
+	plain bool
+}
+
+func newTable(out *os.File, header ...string) *table {
+	t := &table{out: out, plain: !isTerminal(out)}
+	if !t.plain {
+		t.tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		t.out = t.tw
+		t.row(header...)
+	}
+	return t
+}
+
+// row writes one row. Tabs and line breaks in cells become spaces, so every
+// row stays one line of as many fields.
+func (t *table) row(cells ...string) {
+	for i, cell := range cells {
+		cells[i] = cellReplacer.Replace(cell)
+		if cells[i] == "" && !t.plain {
+			cells[i] = "-"
+		}
+	}
+	fmt.Fprintln(t.out, strings.Join(cells, "\t"))
+}
+
+func (t *table) flush() {
+	if t.tw != nil {
+		t.tw.Flush()
+	}
+}
+
+// timeCell shows a time relative to now on a terminal, in RFC 3339 otherwise
+func (t *table) timeCell(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	if t.plain {
+		return at.UTC().Format(time.RFC3339)
+	}
+	return ago(time.Since(at))
+}
+
+// ago describes an age coarsely, e.g. 3m ago
+func ago(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return strconv.Itoa(int(max(age, 0)/time.Second)) + "s ago"
+	case age < time.Hour:
+		return strconv.Itoa(int(age/time.Minute)) + "m ago"
+	case age < 48*time.Hour:
+		return strconv.Itoa(int(age/time.Hour)) + "h ago"
+	}
+	return strconv.Itoa(int(age/(24*time.Hour))) + "d ago"
+}
+
+// deviceSorts order devices for the -sort flag of the devices subcommand
+var deviceSorts = map[string]func(a, b *client.Device) bool{
+	"last_seen":  func(a, b *client.Device) bool { return a.LastSeen.After(b.LastSeen) },
+	"first_seen": func(a, b *client.Device) bool { return a.FirstSeen.After(b.FirstSeen) },
+	"id":         func(a, b *client.Device) bool { return a.ID < b.ID },
+	"vendor":     func(a, b *client.Device) bool { return strings.ToLower(a.Vendor) < strings.ToLower(b.Vendor) },
+	"ip": func(a, b *client.Device) bool {
+		ipA, errA := netip.ParseAddr(a.IP)
+		ipB, errB := netip.ParseAddr(b.IP)
+		if errA != nil || errB != nil {
+			return errA == nil
+		}
+		return ipA.Less(ipB)
+	},
+	"risk":   func(a, b *client.Device) bool { return riskScore(a) > riskScore(b) },
+	"health": func(a, b *client.Device) bool { return healthScore(a) < healthScore(b) },
+}
+
+func riskScore(device *client.Device) int {
+	if device.Hints == nil {
+		return 0
+	}
+	return device.Hints.RiskScore
+}
+
+// healthScore returns the health score of a device, or more than any score
+// while unknown so those sort last
+func healthScore(device *client.Device) int {
+	if device.Health == nil || device.Health.Score == nil {
+		return 101
+	}
+	return *device.Health.Score
+}
+
+// healthCell renders the health of a device as its state and score
+func healthCell(device *client.Device) string {
+	if device.Health == nil {
+		return models.DeviceHealthUnknown
+	}
+	if device.Health.Score == nil {
+		return device.Health.State
+	}
+	return device.Health.State + " " + strconv.Itoa(*device.Health.Score)
+}
+
+// runDevices prints the tracked devices as a table
+func runDevices(args []string) int {
+	flags := flag.NewFlagSet("devices", flag.ExitOnError)
+	server, apiAddr, token := queryFlags(flags)
+	active := flags.Duration("active", 0, "Only list devices seen within this long (0 lists all)")
+	sortBy := flags.String("sort", "last_seen", "Order of the devices: last_seen, first_seen, ip, id, vendor, risk or health")
+	subnet := flags.String("subnet", "", "Only list devices in this CIDR")
+	vendor := flags.String("vendor", "", "Only list devices of this vendor")
+	iface := flags.String("interface", "", "Only list devices seen on this interface")
+	c, positional, ok := parseQuery(flags, args, server, apiAddr, token)
+	if !ok {
+		return 2
+	}
+	less, known := deviceSorts[*sortBy]
+	if len(positional) > 0 || !known || *active < 0 {
+		fmt.Fprintln(queryErr, "usage: cerberus devices [-active duration] [-sort last_seen|first_seen|ip|id|vendor|risk|health]")
+		return 2
+	}
+
+	query := url.Values{"fields": {deviceListFields}, "include": {"hints"}}
+	for name, value := range map[string]string{"subnet": *subnet, "vendor": *vendor, "interface": *iface} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	devices, err := c.Devices(context.Background(), query)
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return 1
+	}
+
+	if *active > 0 {
+		cutoff := time.Now().Add(-*active)
+		kept := devices[:0]
+		for _, device := range devices {
+			if device.LastSeen.After(cutoff) {
+				kept = append(kept, device)
+			}
+		}
+		devices = kept
+	}
+	sort.SliceStable(devices, func(i, j int) bool { return less(&devices[i], &devices[j]) })
+
+	t := newTable(queryOut, "ID", "IP", "NAME", "VENDOR", "RISK", "LEVEL", "HEALTH", "LAST SEEN")
+	for i := range devices {
+		device := &devices[i]
+		name, score, level := device.Name, "", ""
+		if device.Hints != nil {
+			name, score, level = device.Hints.Label, strconv.Itoa(device.Hints.RiskScore), device.Hints.RiskLevel
+		}
+		t.row(device.ID, device.IP, name, device.Vendor, score, level, healthCell(device), t.timeCell(device.LastSeen))
+	}
+	t.flush()
+	return 0
+}
+
+// runDevice prints the detail view of one device, one field per line
+func runDevice(args []string) int {
+	flags := flag.NewFlagSet("device", flag.ExitOnError)
+	server, apiAddr, token := queryFlags(flags)
+	c, positional, ok := parseQuery(flags, args, server, apiAddr, token)
+	if !ok {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintln(queryErr, "usage: cerberus device <mac|id|uuid>")
+		return 2
+	}
+
+	ctx := context.Background()
+	device, err := c.Device(ctx, positional[0])
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return 1
+	}
+	hints, err := c.DeviceHints(ctx, device.ID)
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return 1
+	}
+
+	t := newTable(queryOut)
+	field := func(name, value string) {
+		if value != "" {
+			t.row(name, value)
+		}
+	}
+	guess := func(value, confidence string) string {
+		if value == "" || t.plain {
+			return value
+		}
+		return value + " (" + confidence + ")"
+	}
+
+	field("id", device.ID)
+	field("uuid", device.UUID)
+	field("mac", device.MAC)
+	field("ip", device.IP)
+	field("subnet", device.Subnet)
+	field("label", hints.Label)
+	field("name", device.Name)
+	field("owner", device.Owner)
+	field("location", device.Location)
+	field("vendor", device.Vendor)
+	if device.DeviceType != nil {
+		field("type", guess(device.DeviceType.Type, device.DeviceType.Confidence))
+	}
+	if device.OSGuess != nil {
+		field("os", guess(device.OSGuess.OS, device.OSGuess.Confidence))
+	}
+	field("icon", hints.Icon)
+	field("risk_score", strconv.Itoa(hints.RiskScore))
+	field("risk_level", hints.RiskLevel)
+	field("health", healthCell(device))
+	if device.Health != nil {
+		for _, factor := range device.Health.Factors {
+			field("health."+factor.Name, factor.Detail)
+		}
+	}
+	field("interfaces", strings.Join(device.Interfaces, ","))
+	field("first_seen", t.timeCell(device.FirstSeen))
+	field("last_seen", t.timeCell(device.LastSeen))
+	field("tcp_connections", strconv.Itoa(device.TCPConnections))
+	field("udp_connections", strconv.Itoa(device.UDPConnections))
+	field("dns_queries", strconv.Itoa(device.DNSQueries))
+	field("http_requests", strconv.Itoa(device.HTTPRequests))
+	field("tls_connections", strconv.Itoa(device.TLSConnections))
+	field("external_patterns", strconv.Itoa(device.ExternalPatterns))
+	field("recent_targets", strings.Join(device.Targets.Last(5), ","))
+	if mute := device.Mute; mute != nil {
+		muted := "yes"
+		if mute.ExpiresAt != nil {
+			muted = "until " + mute.ExpiresAt.Local().Format(time.RFC3339)
+		}
+		field("muted", muted)
+	}
+	t.flush()
+	return 0
+}
+
+// runStats prints the counters of the running instance and the traffic of
+// its interfaces
+func runStats(args []string) int {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	server, apiAddr, token := queryFlags(flags)
+	c, positional, ok := parseQuery(flags, args, server, apiAddr, token)
+	if !ok {
+		return 2
+	}
+	if len(positional) > 0 {
+		fmt.Fprintln(queryErr, "usage: cerberus stats")
+		return 2
+	}
+
+	stats, err := c.Stats(context.Background())
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return 1
+	}
+
+	t := newTable(queryOut)
+	counters := []struct {
+		name  string
+		value uint64
+	}{
+		{"total_devices", uint64(stats.TotalDevices)},
+		{"total_packets", stats.TotalPackets},
+		{"self_packets", stats.SelfPackets},
+		{"arp_packets", stats.ArpPackets},
+		{"tcp_packets", stats.TcpPackets},
+		{"udp_packets", stats.UdpPackets},
+		{"icmp_packets", stats.IcmpPackets},
+		{"dns_packets", stats.DnsPackets},
+		{"http_packets", stats.HttpPackets},
+		{"tls_packets", stats.TlsPackets},
+		{"filtered_packets", stats.FilteredPackets},
+		{"invalid_events", stats.InvalidEvents},
+		{"flow_summaries", stats.FlowSummaries},
+		{"flow_packets", stats.FlowPackets},
+		{"flow_bytes", stats.FlowBytes},
+		{"failed_persists", stats.FailedPersists},
+	}
+	for _, counter := range counters {
+		t.row(counter.name, strconv.FormatUint(counter.value, 10))
+	}
+	t.row("enabled_events", strings.Join(stats.EnabledEvents, ","))
+	if len(stats.WriterOnly) > 0 {
+		t.row("writer_only", strings.Join(stats.WriterOnly, ","))
+	}
+	t.flush()
+	if len(stats.Interfaces) == 0 {
+		return 0
+	}
+
+	// Interfaces are keyed by name when piped, so every line stays a pair
+	if t.plain {
+		for _, iface := range stats.Interfaces {
+			prefix := "interfaces." + iface.Name + "."
+			t.row(prefix+"packets", strconv.FormatUint(iface.Packets, 10))
+			t.row(prefix+"bytes", strconv.FormatUint(iface.Bytes, 10))
+			if iface.SpeedMbps > 0 {
+				t.row(prefix+"speed_mbps", strconv.Itoa(iface.SpeedMbps))
+			}
+			if u := iface.Utilization; u != nil {
+				windows := []struct {
+					name  string
+					value *float64
+				}{{"last_10s", u.Last10s}, {"last_1m", u.Last1m}, {"last_5m", u.Last5m}}
+				for _, window := range windows {
+					if window.value != nil {
+						t.row(prefix+"utilization."+window.name, percentCell(window.value))
+					}
+				}
+			}
+		}
+		return 0
+	}
+	fmt.Fprintln(queryOut)
+	t = newTable(queryOut, "INTERFACE", "SPEED", "PACKETS", "BYTES", "10S", "1M", "5M")
+	for _, iface := range stats.Interfaces {
+		speed := ""
+		if iface.SpeedMbps > 0 {
+			speed = strconv.Itoa(iface.SpeedMbps) + " Mb/s"
+		}
+		var last10s, last1m, last5m string
+		if u := iface.Utilization; u != nil {
+			last10s, last1m, last5m = percentCell(u.Last10s)+"%", percentCell(u.Last1m)+"%", percentCell(u.Last5m)+"%"
+		}
+		t.row(iface.Name, speed, strconv.FormatUint(iface.Packets, 10), strconv.FormatUint(iface.Bytes, 10), last10s, last1m, last5m)
+	}
+	t.flush()
+	return 0
+}
+
+func percentCell(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 1, 64)
+}
+
+// runPatterns prints the patterns persisted recently, or follows the live
+// feed of new ones, one per line
+func runPatterns(args []string) int {
+	flags := flag.NewFlagSet("patterns", flag.ExitOnError)
+	server, apiAddr, token := queryFlags(flags)
+	follow := flags.Bool("follow", false, "Stream new patterns as they are seen until interrupted")
+	since := flags.Duration("since", time.Hour, "Without -follow, list the patterns persisted within this long (needs -api-admin-token)")
+	device := flags.String("device", "", "Only show patterns of this device ID")
+	protocol := flags.String("protocol", "", "Only show patterns of this protocol, e.g. TCP")
+	iface := flags.String("interface", "", "Only show patterns seen on this interface")
+	c, positional, ok := parseQuery(flags, args, server, apiAddr, token)
+	if !ok {
+		return 2
+	}
+	if len(positional) > 0 || *since <= 0 {
+		fmt.Fprintln(queryErr, "usage: cerberus patterns [-follow] [-since duration] [-device id] [-protocol name] [-interface name]")
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	plain := !isTerminal(queryOut)
+	show := func(pattern *client.Pattern) error {
+		_, err := fmt.Fprintln(queryOut, patternLine(pattern, plain))
+		return err
+	}
+
+	query := url.Values{}
+	if *iface != "" {
+		query.Set("interface", *iface)
+	}
+	if *follow {
+		if *device != "" {
+			query.Set("device", *device)
+		}
+		if *protocol != "" {
+			query.Set("protocol", *protocol)
+		}
+		err := c.FollowPatterns(ctx, query, show, func(count int) {
+			fmt.Fprintf(queryErr, "%d patterns dropped by the server's stream rate limit\n", count)
+		})
+		if err != nil {
+			fmt.Fprintln(queryErr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// The bulk export can't filter on these, and is ordered by device
+	query.Set("since", time.Now().Add(-*since).UTC().Format(time.RFC3339))
+	var patterns []*client.Pattern
+	err := c.Patterns(ctx, query, func(pattern *client.Pattern) error {
+		if (*device == "" || strings.EqualFold(pattern.DeviceID, *device)) &&
+			(*protocol == "" || strings.EqualFold(pattern.Protocol, *protocol)) {
+			patterns = append(patterns, pattern)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(queryErr, err)
+		return 1
+	}
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].Timestamp.Before(patterns[j].Timestamp) })
+	for _, pattern := range patterns {
+		if show(pattern) != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+// patternLine formats a pattern as a readable line, or as tab-separated
+// fields when plain
+func patternLine(p *client.Pattern, plain bool) string {
+	if plain {
+		fields := []string{
+			p.Timestamp.UTC().Format(time.RFC3339), p.DeviceID, p.SrcIP, p.DstIP, strconv.Itoa(int(p.DstPort)),
+			p.Protocol, string(p.TrafficType), p.Service, p.L7Info, p.Interface,
+		}
+		for i, field := range fields {
+			fields[i] = cellReplacer.Replace(field)
+		}
+		return strings.Join(fields, "\t")
+	}
+
+	line := fmt.Sprintf("%s  %-17s  %s -> %s:%d  %s %s", p.Timestamp.Local().Format("15:04:05"),
+		p.DeviceID, p.SrcIP, p.DstIP, p.DstPort, p.Protocol, p.Service)
+	if p.L7Info != "" {
+		line += "  " + p.L7Info
+	}
+	if p.Interface != "" {
+		line += "  [" + p.Interface + "]"
+	}
+	return line
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// discardSink takes the monitor's events so it doesn't print them
+type discardSink struct{}
+
+func (discardSink) Emit(string, any) {}
+
+// queryServer is a running API over a monitor that has seen two devices
+type queryServer struct {
+	mon *monitor.NetworkMonitor
+	api *api.Server
+	url string
+}
+
+func newQueryServer(t *testing.T) *queryServer {
+	t.Helper()
+	mon, err := monitor.NewNetworkMonitor(100, filepath.Join(t.TempDir(), "network.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.SetEventSink(discardSink{}, true)
+	s := api.NewServer(mon)
+	s.SetAdminToken("secret")
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		mon.Close()
+	})
+
+	q := &queryServer{mon: mon, api: s, url: ts.URL}
+	q.track(t, "02:00:00:00:00:0a", "192.168.1.10", "151.101.1.5", 443)
+	q.track(t, "02:00:00:00:00:0b", "192.168.1.9", "8.8.8.8", 853)
+	return q
+}
+
+// track has a device open a TCP connection to dst:port
+func (q *queryServer) track(t *testing.T, mac, src, dst string, port uint16) {
+	t.Helper()
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.mon.TrackEvent(&models.NetworkEvent{
+		EventType: models.EVENT_TYPE_TCP,
+		SrcMac:    [6]byte(hw),
+		DstMac:    [6]byte{0x02, 0, 0, 0, 0, 0x01},
+		SrcIP:     binary.BigEndian.Uint32(net.ParseIP(src).To4()),
+		DstIP:     binary.BigEndian.Uint32(net.ParseIP(dst).To4()),
+		SrcPort:   40000,
+		DstPort:   port,
+		Protocol:  6,
+		TCPFlags:  0x10,
+		IfIndex:   1,
+		IPTTL:     64,
+		PacketLen: 60,
+	})
+}
+
+// runQuery runs a subcommand against the server with its output in files,
+// which are not terminals, and returns what it printed and its exit code
+func runQuery(t *testing.T, server, command string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errOut, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(out, errOut *os.File) { queryOut, queryErr = out, errOut }(queryOut, queryErr)
+	queryOut, queryErr = out, errOut
+
+	code = queryCommands[command](append(args, "-server", server))
+	outData, _ := os.ReadFile(out.Name())
+	errData, _ := os.ReadFile(errOut.Name())
+	return string(outData), string(errData), code
+}
+
+// Piped, devices prints one tab-separated row per device without a header,
+// in the requested order
+func TestQueryDevices(t *testing.T) {
+	q := newQueryServer(t)
+
+	stdout, stderr, code := runQuery(t, q.url, "devices", "-sort", "ip")
+	if code != 0 {
+		t.Fatalf("devices exited %d: %s", code, stderr)
+	}
+	rows := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(rows) != 2 {
+		t.Fatalf("devices printed %q, want 2 rows", stdout)
+	}
+	for i, want := range []string{"02:00:00:00:00:0b\t192.168.1.9\t", "02:00:00:00:00:0a\t192.168.1.10\t"} {
+		fields := strings.Split(rows[i], "\t")
+		if !strings.HasPrefix(rows[i], want) || len(fields) != 8 {
+			t.Errorf("row %d = %q, want %q and 8 fields", i, rows[i], want)
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, fields[7]); err != nil {
+			t.Errorf("row %d last seen %q is not RFC 3339", i, fields[7])
+		}
+	}
+
+	if stdout, _, _ := runQuery(t, q.url, "devices", "-subnet", "192.168.1.10/32"); !strings.HasPrefix(stdout, "02:00:00:00:00:0a\t") || strings.Count(stdout, "\n") != 1 {
+		t.Errorf("devices -subnet printed %q", stdout)
+	}
+	if stdout, _, _ := runQuery(t, q.url, "devices", "-active", "1ns"); stdout != "" {
+		t.Errorf("devices -active 1ns printed %q", stdout)
+	}
+}
+
+// device prints name and value pairs of the device and its hints, and fails
+// on a device the server doesn't know
+func TestQueryDevice(t *testing.T) {
+	q := newQueryServer(t)
+
+	stdout, stderr, code := runQuery(t, q.url, "device", "02:00:00:00:00:0A")
+	if code != 0 {
+		t.Fatalf("device exited %d: %s", code, stderr)
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		name, value, ok := strings.Cut(line, "\t")
+		if !ok || strings.Contains(value, "\t") {
+			t.Fatalf("line %q is not a name and value pair", line)
+		}
+		fields[name] = value
+	}
+	for name, want := range map[string]string{
+		"id":              "02:00:00:00:00:0a",
+		"ip":              "192.168.1.10",
+		"tcp_connections": "1",
+		"recent_targets":  "151.101.1.5",
+	} {
+		if fields[name] != want {
+			t.Errorf("%s = %q, want %q", name, fields[name], want)
+		}
+	}
+	for _, name := range []string{"icon", "risk_score", "risk_level", "health", "first_seen"} {
+		if fields[name] == "" {
+			t.Errorf("%s missing from %q", name, stdout)
+		}
+	}
+
+	stdout, stderr, code = runQuery(t, q.url, "device", "02:00:00:00:00:ff")
+	if code != 1 || stdout != "" || !strings.Contains(stderr, "not found") {
+		t.Errorf("device of an unknown MAC = %d, %q, %q", code, stdout, stderr)
+	}
+}
+
+// stats prints every counter as a name and value pair
+func TestQueryStats(t *testing.T) {
+	q := newQueryServer(t)
+
+	stdout, stderr, code := runQuery(t, q.url, "stats")
+	if code != 0 {
+		t.Fatalf("stats exited %d: %s", code, stderr)
+	}
+	for _, want := range []string{"total_devices\t2\n", "tcp_packets\t2\n", "enabled_events\t"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("stats printed %q, want %q", stdout, want)
+		}
+	}
+}
+
+// patterns lists the persisted patterns through the bulk export, which needs
+// the admin token, filtered by device
+func TestQueryPatterns(t *testing.T) {
+	q := newQueryServer(t)
+	if _, err := q.mon.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runQuery(t, q.url, "patterns", "-api-admin-token", "secret", "-device", "02:00:00:00:00:0b")
+	if code != 0 {
+		t.Fatalf("patterns exited %d: %s", code, stderr)
+	}
+	fields := strings.Split(strings.TrimSuffix(stdout, "\n"), "\t")
+	if strings.Count(stdout, "\n") != 1 || len(fields) != 10 ||
+		fields[1] != "02:00:00:00:00:0b" || fields[2] != "192.168.1.9" || fields[3] != "8.8.8.8" || fields[4] != "853" {
+		t.Errorf("patterns printed %q", stdout)
+	}
+
+	if stdout, stderr, code := runQuery(t, q.url, "patterns"); code != 1 || stdout != "" || stderr == "" {
+		t.Errorf("patterns without the token = %d, %q, %q", code, stdout, stderr)
+	}
+}
+
+// patterns -follow prints patterns as they are seen until the server ends
+// the stream
+func TestQueryPatternsFollow(t *testing.T) {
+	q := newQueryServer(t)
+
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(out, errOut *os.File) { queryOut, queryErr = out, errOut }(queryOut, queryErr)
+	queryOut, queryErr = out, out
+	exited := make(chan int, 1)
+	go func() { exited <- runPatterns([]string{"-follow", "-device", "02:00:00:00:00:0c", "-server", q.url}) }()
+
+	// The stream only sends what is seen once it is open, so keep the
+	// device busy until a pattern comes through
+	var stdout string
+	for port := uint16(1000); ; port++ {
+		q.track(t, "02:00:00:00:00:0b", "192.168.1.9", "1.1.1.1", port)
+		q.track(t, "02:00:00:00:00:0c", "192.168.1.12", "1.1.1.1", port)
+		data, _ := os.ReadFile(out.Name())
+		if stdout = string(data); stdout != "" {
+			break
+		}
+		if port == 1500 {
+			t.Fatal("no pattern followed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	q.api.Shutdown(context.Background())
+
+	select {
+	case code := <-exited:
+		if code != 0 {
+			t.Errorf("patterns -follow exited %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("patterns -follow kept running after the stream ended")
+	}
+	data, _ := os.ReadFile(out.Name())
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if fields := strings.Split(line, "\t"); len(fields) != 10 || fields[1] != "02:00:00:00:00:0c" || fields[3] != "1.1.1.1" {
+			t.Errorf("followed %q", line)
+		}
+	}
+}
+
+// Positional arguments a subcommand doesn't take and bad flag values are
+// usage errors
+func TestQueryUsage(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"devices", []string{"extra"}},
+		{"devices", []string{"-sort", "size"}},
+		{"devices", []string{"-active", "-1h"}},
+		{"device", nil},
+		{"device", []string{"02:00:00:00:00:0a", "02:00:00:00:00:0b"}},
+		{"stats", []string{"extra"}},
+		{"patterns", []string{"-since", "0s"}},
+	}
+	for _, tt := range tests {
+		stdout, stderr, code := runQuery(t, "http://127.0.0.1:1", tt.command, tt.args...)
+		if code != 2 || stdout != "" || !strings.HasPrefix(stderr, "usage: cerberus "+tt.command) {
+			t.Errorf("%s %v = %d, %q, %q", tt.command, tt.args, code, stdout, stderr)
+		}
+	}
+	if _, stderr, code := runQuery(t, "ftp://127.0.0.1", "stats"); code != 2 || stderr == "" {
+		t.Errorf("stats with a bad server = %d, %q", code, stderr)
+	}
+}
+
+// On a terminal, tables align under their header with - for empty cells
+func TestTable(t *testing.T) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	tb := &table{out: tw, tw: tw}
+	tb.row("ID", "NAME")
+	tb.row("02:00:00:00:00:0a", "")
+	tb.row("x", "line\nbreak")
+	tb.flush()
+	want := "ID                 NAME\n02:00:00:00:00:0a  -\nx                  line break\n"
+	if b.String() != want {
+		t.Errorf("table = %q, want %q", b.String(), want)
+	}
+}
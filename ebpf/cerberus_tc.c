@@ -35,6 +35,18 @@
 #define HTTPS_PORT 443
 #define HTTPS_ALT_PORT 8443
 
+// Bytes of a TLS ClientHello captured for fingerprinting (power of two)
+#define TLS_HELLO_MAX 2048
+
+// Bytes of a DNS message captured for tunneling and direct-IP detection (power of two, fits any QNAME)
+#define DNS_QUERY_MAX 512
+
+// Bytes of an HTTP request captured for its Host header (power of two)
+#define HTTP_REQUEST_MAX 1024
+
+// Bytes of L7 payload a network_event can carry (power of two)
+#define L7_PAYLOAD_MAX 512
+
 // Define ICMP header structure directly to avoid including <linux/icmp.h>
 struct icmp_hdr {
     __u8  type;
@@ -61,6 +73,15 @@ struct arp_hdr {
     __u16 ar_op;
 } __attribute__((packed));
 
+// Wire format of every ring buffer record: structs are packed, so there is no
+// padding, and every multi-byte field is in network byte order (big-endian)
+// whatever the host architecture. Userspace parses them by byte offset.
+// Payload arrays come last and are sent only up to their length field, so
+// records vary in size.
+// event_layout tells userspace which layout this object emits; bump it, and
+// models.EventLayoutVersion, whenever a record changes.
+const volatile __u32 event_layout = 5;
+
 struct network_event {
     __u8 event_type;       // 1 byte
     __u8 src_mac[6];       // 6 bytes
@@ -77,15 +98,461 @@ struct network_event {
     __u8 icmp_type;        // 1 byte
     __u8 icmp_code;        // 1 byte
     __u32 ifindex;         // 4 bytes
-    __u8 l7_payload[32];   // 32 bytes
+    __u8 ip_ttl;           // 1 byte
+    __u16 tcp_window;      // 2 bytes
+    __u16 pkt_len;         // 2 bytes - frame length, capped at 65535
+    __u16 l7_len;          // 2 bytes - bytes captured in l7_payload
+    __u8 l7_payload[L7_PAYLOAD_MAX]; // 512 bytes - only l7_len of them are sent
+} __attribute__((packed));
+// Header: 54 bytes, total: 566 bytes
+#define EVENT_HEADER_SIZE __builtin_offsetof(struct network_event, l7_payload)
+_Static_assert(EVENT_HEADER_SIZE == 54, "network_event wire size changed");
+
+// TLS ClientHello record, sent separately so regular events stay small
+struct tls_hello_event {
+    __u8 src_mac[6];       // 6 bytes
+    __u32 src_ip;          // 4 bytes
+    __u32 dst_ip;          // 4 bytes
+    __u16 src_port;        // 2 bytes
+    __u16 dst_port;        // 2 bytes
+    __u16 length;          // 2 bytes - bytes captured in data
+    __u8 data[TLS_HELLO_MAX]; // 2048 bytes - TLS record starting at the record header
+} __attribute__((packed));
+// Total: 2068 bytes
+_Static_assert(sizeof(struct tls_hello_event) == 20 + TLS_HELLO_MAX, "tls_hello_event wire size changed");
+
+// DNS query or response, sent separately since full messages don't fit the event payload
+struct dns_query_event {
+    __u8 src_mac[6];       // 6 bytes
+    __u32 src_ip;          // 4 bytes
+    __u32 dst_ip;          // 4 bytes
+    __u16 length;          // 2 bytes - bytes captured in data
+    __u8 data[DNS_QUERY_MAX]; // 512 bytes - DNS message starting at its header
+} __attribute__((packed));
+// Total: 528 bytes
+_Static_assert(sizeof(struct dns_query_event) == 16 + DNS_QUERY_MAX, "dns_query_event wire size changed");
+
+// Start of an HTTP request, sent separately since headers don't fit the event payload
+struct http_request_event {
+    __u8 src_mac[6];       // 6 bytes
+    __u32 src_ip;          // 4 bytes
+    __u32 dst_ip;          // 4 bytes
+    __u16 src_port;        // 2 bytes
+    __u16 dst_port;        // 2 bytes
+    __u16 length;          // 2 bytes - bytes captured in data
+    __u8 data[HTTP_REQUEST_MAX]; // 1024 bytes - request line and headers
+} __attribute__((packed));
+// Total: 1044 bytes
+_Static_assert(sizeof(struct http_request_event) == 20 + HTTP_REQUEST_MAX, "http_request_event wire size changed");
+
+// Packets and bytes of an established TCP flow counted in the kernel since
+// its last summary, sent instead of an event per packet
+struct flow_summary_event {
+    __u8 src_mac[6];       // 6 bytes
+    __u8 dst_mac[6];       // 6 bytes
+    __u32 src_ip;          // 4 bytes
+    __u32 dst_ip;          // 4 bytes
+    __u16 src_port;        // 2 bytes
+    __u16 dst_port;        // 2 bytes
+    __u8 protocol;         // 1 byte
+    __u8 reason;           // 1 byte - FLOW_SUMMARY_*
+    __u32 ifindex;         // 4 bytes
+    __u32 packets;         // 4 bytes
+    __u64 bytes;           // 8 bytes
+    __u32 duration_ms;     // 4 bytes - from the first packet counted to the last
 } __attribute__((packed));
-// Total: 79 bytes
+// Total: 46 bytes
+_Static_assert(sizeof(struct flow_summary_event) == 46, "flow_summary_event wire size changed");
 
 struct {
     __uint(type, BPF_MAP_TYPE_RINGBUF);
     __uint(max_entries, 256 * 1024);
 } events SEC(".maps");
 
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1024 * 1024);
+} tls_hellos SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} dns_queries SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} http_requests SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+} flow_summaries SEC(".maps");
+
+// Bytes of L7 payload copied to the side ring buffers, per event type (DNS,
+// HTTP, TLS), written by userspace at any time. 0, or more than the record
+// holds, captures as much as the record holds.
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 8);
+    __type(key, __u32);
+    __type(value, __u16);
+} payload_limits SEC(".maps");
+
+// Bytes of L7 payload copied into network_event itself, per event type,
+// written by userspace at any time. 0 (or a missing entry) sends none; more
+// than the event holds sends as much as it holds.
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 8);
+    __type(key, __u32);
+    __type(value, __u16);
+} event_payload_limits SEC(".maps");
+
+// Events are built here and sent with only the payload bytes captured
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct network_event);
+} event_scratch SEC(".maps");
+
+// Side ring buffer records are built here and sent with only the bytes
+// captured, so a smaller payload limit saves ring buffer space
+union payload_record {
+    struct tls_hello_event tls;
+    struct dns_query_event dns;
+    struct http_request_event http;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, union payload_record);
+} payload_scratch SEC(".maps");
+
+// Flags of an event_filter entry
+#define FILTER_DISABLED     0x01 // Drop before reaching the ring buffer
+#define FILTER_CONTROL_ONLY 0x02 // TCP: only SYN, FIN and RST segments
+
+// Capture settings per event type, written by userspace at any time
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 8);
+    __type(key, __u32);
+    __type(value, __u8);
+} event_filter SEC(".maps");
+
+// Events dropped because of event_filter, subnet_filter or sampling, per event type
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 8);
+    __type(key, __u32);
+    __type(value, __u64);
+} filter_drops SEC(".maps");
+
+// Subnets events must involve, as source or destination, while
+// capture_options.subnet_filter is set
+#define SUBNET_FILTER_MAX 64
+
+struct subnet_key {
+    __u32 prefixlen;      // 4 bytes - bits of addr that must match
+    __u32 addr;           // 4 bytes - network byte order
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, SUBNET_FILTER_MAX);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct subnet_key);
+    __type(value, __u8);
+} subnet_filter SEC(".maps");
+
+// Settings that apply to every event type, written by userspace at any time
+struct capture_options {
+    __u8 subnet_filter;   // 1 byte - 1 = only emit events matching subnet_filter
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct capture_options);
+} capture_options SEC(".maps");
+
+// Flows counted in the kernel at once. The least recently used is evicted
+// when full, losing what it counted since its last summary.
+#define FLOW_MAX 8192
+
+// Why a flow_summary_event was sent
+#define FLOW_SUMMARY_PACKETS  1 // The packet threshold was reached
+#define FLOW_SUMMARY_INTERVAL 2 // The interval passed since the first packet counted
+#define FLOW_SUMMARY_END      3 // A FIN or RST ended the flow
+
+struct flow_key {
+    __u32 src_ip;         // 4 bytes - network byte order
+    __u32 dst_ip;         // 4 bytes - network byte order
+    __u16 src_port;       // 2 bytes - network byte order
+    __u16 dst_port;       // 2 bytes - network byte order
+    __u8 protocol;        // 1 byte
+    __u8 pad[3];          // 3 bytes - zero
+};
+
+// Counters of one flow on one CPU, so no packet needs an atomic update.
+// Userspace sums the CPUs when it sweeps idle flows.
+struct flow_counters {
+    __u64 packets;        // Counted since the last summary
+    __u64 bytes;
+    __u64 first_ns;       // First packet counted since the last summary
+    __u64 last_ns;        // Last packet seen
+    __u8 src_mac[6];
+    __u8 dst_mac[6];
+    __u32 ifindex;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, FLOW_MAX);
+    __type(key, struct flow_key);
+    __type(value, struct flow_counters);
+} flows SEC(".maps");
+
+// Flow aggregation settings, written by userspace at any time
+struct flow_config {
+    __u32 packets;        // 4 bytes - packets per summary, 0 sends every packet as an event
+    __u32 interval_ms;    // 4 bytes - most time a summary waits, 0 for no limit
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct flow_config);
+} flow_config SEC(".maps");
+
+// Capture settings of one interface, written by userspace at any time. They
+// replace event_filter, payload_limits and event_payload_limits for packets
+// arriving on the interface; the arrays are indexed by event type like those
+// maps. subnet_filter and flow_config stay global.
+#define IFACE_CAPTURE_MAX 64
+
+struct iface_capture {
+    __u8 event_filter[8];            // 8 bytes - FILTER_* flags
+    __u16 payload_limits[8];         // 16 bytes
+    __u16 event_payload_limits[8];   // 16 bytes
+    __u32 sample;                    // 4 bytes - send 1 in sample events; 0 or 1 sends all
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, IFACE_CAPTURE_MAX);
+    __type(key, __u32);               // ifindex
+    __type(value, struct iface_capture);
+} iface_capture SEC(".maps");
+
+// Ignore list, written by userspace at any time: traffic from an ignored
+// source MAC, or to or from an ignored subnet, is dropped before any other
+// check, and counted under the entry's ignore_hits slot
+#define IGNORE_MAX 256
+
+#define IGNORE_ALL        0 // Drop every event
+#define IGNORE_DATA_PLANE 1 // Let ARP and DNS through, for presence tracking
+
+struct mac_key {
+    __u8 addr[6];         // 6 bytes
+    __u8 pad[2];          // 2 bytes - zero
+};
+
+struct ignore_value {
+    __u32 slot;           // 4 bytes - index into ignore_hits
+    __u8 scope;           // 1 byte - IGNORE_*
+    __u8 pad[3];          // 3 bytes
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, IGNORE_MAX);
+    __type(key, struct mac_key);
+    __type(value, struct ignore_value);
+} ignore_macs SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);
+    __uint(max_entries, IGNORE_MAX);
+    __uint(map_flags, BPF_F_NO_PREALLOC);
+    __type(key, struct subnet_key);
+    __type(value, struct ignore_value);
+} ignore_cidrs SEC(".maps");
+
+// Events dropped by each ignore list entry
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, IGNORE_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} ignore_hits SEC(".maps");
+
+// Packet being handled, so the same handlers serve the TC classifier and the
+// XDP program. Each entry point sets xdp to a constant; every handler is
+// inlined into it, so the compiler drops the helper calls of the other
+// program type, which the verifier would reject.
+struct pkt {
+    void *ctx;             // struct __sk_buff, or struct xdp_md if xdp is set
+    __u8 xdp;
+    void *data;
+    void *data_end;
+    __u32 len;             // Packet length; XDP only counts the linear part
+    __u32 ifindex;         // Interface the packet arrived on
+    struct iface_capture *iface; // Settings of the interface, NULL for the global ones
+};
+
+// Helper to check the ignore list for a packet from eth's source MAC and
+// src_ip to dst_ip (both 0 to only check the MAC), counting it if dropped.
+// The source MAC wins over the subnets, and the destination over the source.
+// Control plane traffic (ARP, DNS) is only dropped by entries ignoring
+// everything.
+static __always_inline int ignored(struct ethhdr *eth, __u32 src_ip, __u32 dst_ip, int control_plane)
+{
+    struct mac_key mac = {};
+    __builtin_memcpy(mac.addr, eth->h_source, 6);
+    struct ignore_value *entry = bpf_map_lookup_elem(&ignore_macs, &mac);
+    if (!entry && dst_ip) {
+        struct subnet_key key = { .prefixlen = 32, .addr = dst_ip };
+        entry = bpf_map_lookup_elem(&ignore_cidrs, &key);
+    }
+    if (!entry && src_ip) {
+        struct subnet_key key = { .prefixlen = 32, .addr = src_ip };
+        entry = bpf_map_lookup_elem(&ignore_cidrs, &key);
+    }
+    if (!entry || (control_plane && entry->scope == IGNORE_DATA_PLANE))
+        return 0;
+
+    __u32 slot = entry->slot;
+    __u64 *hits = bpf_map_lookup_elem(&ignore_hits, &slot);
+    if (hits)
+        (*hits)++;
+    return 1;
+}
+
+// Helper to check the subnet filter; an unset filter lets everything through
+static __always_inline int subnet_wanted(__u32 src_ip, __u32 dst_ip)
+{
+    __u32 zero = 0;
+    struct capture_options *options = bpf_map_lookup_elem(&capture_options, &zero);
+    if (!options || !options->subnet_filter)
+        return 1;
+
+    struct subnet_key key = { .prefixlen = 32, .addr = src_ip };
+    if (bpf_map_lookup_elem(&subnet_filter, &key))
+        return 1;
+    key.addr = dst_ip;
+    return bpf_map_lookup_elem(&subnet_filter, &key) != NULL;
+}
+
+// Helper to read the filter flags of an event type on the packet's interface
+static __always_inline __u8 event_filter_flags(struct pkt *p, __u32 event_type)
+{
+    if (p->iface)
+        return p->iface->event_filter[event_type & 7];
+    __u8 *flags = bpf_map_lookup_elem(&event_filter, &event_type);
+    return flags ? *flags : 0;
+}
+
+// Helper to check if userspace disabled an event type
+static __always_inline int event_disabled(struct pkt *p, __u32 event_type)
+{
+    return event_filter_flags(p, event_type) & FILTER_DISABLED;
+}
+
+// Helper to read the payload bytes to capture for an event type, at most max
+static __always_inline __u32 payload_limit(struct pkt *p, __u32 event_type, __u32 max)
+{
+    __u32 limit = 0;
+    if (p->iface) {
+        limit = p->iface->payload_limits[event_type & 7];
+    } else {
+        __u16 *value = bpf_map_lookup_elem(&payload_limits, &event_type);
+        if (value) limit = *value;
+    }
+    if (limit == 0 || limit > max)
+        return max;
+    return limit;
+}
+
+// Helper to check if an event falls outside its interface's sample
+static __always_inline int sampled_out(struct pkt *p)
+{
+    return p->iface && p->iface->sample > 1 && bpf_get_prandom_u32() % p->iface->sample;
+}
+
+// Helper to bound a copy length to max - 1 (max a power of two) in a way the
+// verifier tracks. The empty asm hides len from the compiler, which otherwise
+// drops a mask it can prove redundant and passes the helper call a register
+// the verifier only knows as an unbounded copy.
+static __always_inline __u32 bound_len(__u32 len, __u32 max)
+{
+    asm volatile("" : "+r"(len));
+    return len & (max - 1);
+}
+
+// Helper to copy packet bytes. Reads non-linear skb data too, unlike direct
+// packet access. p->xdp is a constant here, see struct pkt.
+static __always_inline long pkt_load_bytes(struct pkt *p, __u32 offset, void *to, __u32 len)
+{
+    if (p->xdp)
+        return bpf_xdp_load_bytes(p->ctx, offset, to, len);
+    return bpf_skb_load_bytes(p->ctx, offset, to, len);
+}
+
+// Helper to copy up to the event type's payload limit of the L7 payload at
+// offset into an event, setting l7_len
+static __always_inline void copy_l7_payload(struct pkt *p, struct network_event *e,
+                                            __u32 offset)
+{
+    e->l7_len = 0;
+    __u32 event_type = e->event_type;
+    __u32 limit = 0;
+    if (p->iface) {
+        limit = p->iface->event_payload_limits[event_type & 7];
+    } else {
+        __u16 *value = bpf_map_lookup_elem(&event_payload_limits, &event_type);
+        if (value) limit = *value;
+    }
+    if (limit == 0 || offset >= p->len) return;
+
+    __u32 len = p->len - offset;
+    if (len > limit) len = limit;
+    if (len > L7_PAYLOAD_MAX - 1) len = L7_PAYLOAD_MAX - 1;
+    len = bound_len(len, L7_PAYLOAD_MAX);
+    if (len == 0) return;
+
+    if (pkt_load_bytes(p, offset, e->l7_payload, len) < 0) return;
+    e->l7_len = bpf_htons(len);
+}
+
+// Helper to send an event with only the payload bytes it captured
+static __always_inline void send_event(struct network_event *e)
+{
+    __u32 len = bpf_ntohs(e->l7_len) & (L7_PAYLOAD_MAX - 1);
+    bpf_ringbuf_output(&events, e, EVENT_HEADER_SIZE + len, 0);
+}
+
+// Helper to get the per-CPU event being built
+static __always_inline struct network_event *event_scratch_get(void)
+{
+    __u32 zero = 0;
+    return bpf_map_lookup_elem(&event_scratch, &zero);
+}
+
+// Helper to count an event dropped by the filter
+static __always_inline void count_filtered(__u32 event_type)
+{
+    __u64 *count = bpf_map_lookup_elem(&filter_drops, &event_type);
+    if (count)
+        (*count)++;
+}
+
 // Helper to check if payload looks like HTTP
 static __always_inline int is_http_request(__u8 *payload, void *data_end)
 {
@@ -122,9 +589,17 @@ static __always_inline int is_tls_handshake(__u8 *payload, void *data_end)
 }
 
 // ------------------- ARP -------------------
-static __always_inline int handle_arp(struct __sk_buff *skb, struct ethhdr *eth)
+static __always_inline int handle_arp(struct pkt *p, struct ethhdr *eth)
 {
-    void *data_end = (void *)(long)skb->data_end;
+    if (ignored(eth, 0, 0, 1))
+        return TC_ACT_OK;
+
+    if (event_disabled(p, EVENT_TYPE_ARP) || sampled_out(p)) {
+        count_filtered(EVENT_TYPE_ARP);
+        return TC_ACT_OK;
+    }
+
+    void *data_end = p->data_end;
     struct arp_hdr *arp = (void *)(eth + 1);
     if ((void *)(arp + 1) > data_end)
         return TC_ACT_OK;
@@ -138,7 +613,15 @@ static __always_inline int handle_arp(struct __sk_buff *skb, struct ethhdr *eth)
     if ((void *)(arp_data + 20) > data_end)
         return TC_ACT_OK;
 
-    struct network_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    __u32 sender_ip, target_ip;
+    __builtin_memcpy(&sender_ip, arp_data + 6, 4);
+    __builtin_memcpy(&target_ip, arp_data + 16, 4);
+    if (!subnet_wanted(sender_ip, target_ip)) {
+        count_filtered(EVENT_TYPE_ARP);
+        return TC_ACT_OK;
+    }
+
+    struct network_event *e = event_scratch_get();
     if (!e) return TC_ACT_OK;
 
     e->event_type = EVENT_TYPE_ARP;
@@ -148,31 +631,231 @@ static __always_inline int handle_arp(struct __sk_buff *skb, struct ethhdr *eth)
     __builtin_memcpy(&e->src_ip, arp_data + 6, 4);
     __builtin_memcpy(e->arp_tha, arp_data + 10, 6);
     __builtin_memcpy(&e->dst_ip, arp_data + 16, 4);
-    e->arp_op = bpf_ntohs(arp->ar_op);
+    e->arp_op = arp->ar_op;
     e->protocol = 0;
     e->src_port = 0;
     e->dst_port = 0;
     e->tcp_flags = 0;
     e->icmp_type = 0;
     e->icmp_code = 0;
-    e->ifindex = skb->ifindex;
-    __builtin_memset(e->l7_payload, 0, sizeof(e->l7_payload));
+    e->ifindex = bpf_htonl(p->ifindex);
+    e->l7_len = 0;
+
+    e->ip_ttl = 0;
+    e->tcp_window = 0;
+    e->pkt_len = bpf_htons(p->len > 0xffff ? 0xffff : p->len);
 
-    bpf_ringbuf_submit(e, 0);
+    send_event(e);
     return TC_ACT_OK;
 }
 
+// ------------------- TLS ClientHello -------------------
+static __always_inline void capture_tls_hello(struct pkt *p, struct ethhdr *eth,
+                                              struct iphdr *iph, __u32 offset,
+                                              __u16 src_port, __u16 dst_port)
+{
+    if (offset >= p->len) return;
+
+    // Hellos longer than the buffer (or split across segments) arrive truncated
+    // and are marked partial in userspace
+    __u32 len = p->len - offset;
+    __u32 limit = payload_limit(p, EVENT_TYPE_TLS, TLS_HELLO_MAX - 1);
+    if (len > limit) len = limit;
+    len = bound_len(len, TLS_HELLO_MAX);
+    if (len == 0) return;
+
+    __u32 zero = 0;
+    union payload_record *rec = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!rec) return;
+    struct tls_hello_event *h = &rec->tls;
+
+    __builtin_memcpy(h->src_mac, eth->h_source, 6);
+    h->src_ip = iph->saddr;
+    h->dst_ip = iph->daddr;
+    h->src_port = bpf_htons(src_port);
+    h->dst_port = bpf_htons(dst_port);
+
+    if (pkt_load_bytes(p, offset, h->data, len) < 0) return;
+    h->length = bpf_htons(len);
+
+    bpf_ringbuf_output(&tls_hellos, h, __builtin_offsetof(struct tls_hello_event, data) + len, 0);
+}
+
+// ------------------- HTTP request -------------------
+static __always_inline void capture_http_request(struct pkt *p, struct ethhdr *eth,
+                                                 struct iphdr *iph, __u32 offset,
+                                                 __u16 src_port, __u16 dst_port)
+{
+    if (offset >= p->len) return;
+
+    __u32 len = p->len - offset;
+    __u32 limit = payload_limit(p, EVENT_TYPE_HTTP, HTTP_REQUEST_MAX - 1);
+    if (len > limit) len = limit;
+    len = bound_len(len, HTTP_REQUEST_MAX);
+    if (len == 0) return;
+
+    __u32 zero = 0;
+    union payload_record *rec = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!rec) return;
+    struct http_request_event *r = &rec->http;
+
+    __builtin_memcpy(r->src_mac, eth->h_source, 6);
+    r->src_ip = iph->saddr;
+    r->dst_ip = iph->daddr;
+    r->src_port = bpf_htons(src_port);
+    r->dst_port = bpf_htons(dst_port);
+
+    if (pkt_load_bytes(p, offset, r->data, len) < 0) return;
+    r->length = bpf_htons(len);
+
+    bpf_ringbuf_output(&http_requests, r, __builtin_offsetof(struct http_request_event, data) + len, 0);
+}
+
+// ------------------- DNS query -------------------
+static __always_inline void capture_dns_query(struct pkt *p, struct ethhdr *eth,
+                                              struct iphdr *iph, __u32 offset)
+{
+    if (offset >= p->len) return;
+
+    __u32 len = p->len - offset;
+    __u32 limit = payload_limit(p, EVENT_TYPE_DNS, DNS_QUERY_MAX - 1);
+    if (len > limit) len = limit;
+    len = bound_len(len, DNS_QUERY_MAX);
+    if (len < 12) return; // Shorter than a DNS header
+
+    __u32 zero = 0;
+    union payload_record *rec = bpf_map_lookup_elem(&payload_scratch, &zero);
+    if (!rec) return;
+    struct dns_query_event *q = &rec->dns;
+
+    __builtin_memcpy(q->src_mac, eth->h_source, 6);
+    q->src_ip = iph->saddr;
+    q->dst_ip = iph->daddr;
+
+    if (pkt_load_bytes(p, offset, q->data, len) < 0) return;
+    q->length = bpf_htons(len);
+
+    bpf_ringbuf_output(&dns_queries, q, __builtin_offsetof(struct dns_query_event, data) + len, 0);
+}
+
+// ------------------- Flow aggregation -------------------
+// Helper to send what this CPU counted of a flow and start counting anew
+static __always_inline void send_flow_summary(struct flow_key *key, struct flow_counters *c,
+                                              __u8 reason)
+{
+    struct flow_summary_event s = {};
+    __builtin_memcpy(s.src_mac, c->src_mac, 6);
+    __builtin_memcpy(s.dst_mac, c->dst_mac, 6);
+    s.src_ip = key->src_ip;
+    s.dst_ip = key->dst_ip;
+    s.src_port = key->src_port;
+    s.dst_port = key->dst_port;
+    s.protocol = key->protocol;
+    s.reason = reason;
+    s.ifindex = bpf_htonl(c->ifindex);
+    s.packets = bpf_htonl(c->packets > 0xffffffff ? 0xffffffff : (__u32)c->packets);
+    s.bytes = bpf_cpu_to_be64(c->bytes);
+    __u64 duration = (c->last_ns - c->first_ns) / 1000000;
+    s.duration_ms = bpf_htonl(duration > 0xffffffff ? 0xffffffff : (__u32)duration);
+
+    bpf_ringbuf_output(&flow_summaries, &s, sizeof(s), 0);
+    c->packets = 0;
+    c->bytes = 0;
+    c->first_ns = 0;
+}
+
+// Helper to count a packet of an established flow instead of sending it.
+// Returns 0 when the packet must be sent as an event: aggregation is off, or
+// it is the first packet seen of the flow.
+static __always_inline int count_flow(struct pkt *p, struct ethhdr *eth, struct iphdr *iph,
+                                      __u16 src_port, __u16 dst_port)
+{
+    __u32 zero = 0;
+    struct flow_config *config = bpf_map_lookup_elem(&flow_config, &zero);
+    if (!config || config->packets == 0)
+        return 0;
+
+    struct flow_key key = {
+        .src_ip = iph->saddr,
+        .dst_ip = iph->daddr,
+        .src_port = bpf_htons(src_port),
+        .dst_port = bpf_htons(dst_port),
+        .protocol = PROTO_TCP,
+    };
+    __u64 now = bpf_ktime_get_ns();
+    struct flow_counters *c = bpf_map_lookup_elem(&flows, &key);
+    if (!c) {
+        struct flow_counters init = { .last_ns = now };
+        bpf_map_update_elem(&flows, &key, &init, BPF_NOEXIST);
+        return 0;
+    }
+
+    if (c->packets == 0) {
+        c->first_ns = now;
+        __builtin_memcpy(c->src_mac, eth->h_source, 6);
+        __builtin_memcpy(c->dst_mac, eth->h_dest, 6);
+        c->ifindex = p->ifindex;
+    }
+    c->packets++;
+    c->bytes += p->len;
+    c->last_ns = now;
+
+    if (c->packets >= config->packets)
+        send_flow_summary(&key, c, FLOW_SUMMARY_PACKETS);
+    else if (config->interval_ms && now - c->first_ns >= (__u64)config->interval_ms * 1000000)
+        send_flow_summary(&key, c, FLOW_SUMMARY_INTERVAL);
+    return 1;
+}
+
+// Helper to summarize what this CPU counted of a flow ending with a FIN or
+// RST. Counts on other CPUs are left to the userspace sweep.
+static __always_inline void end_flow(struct iphdr *iph, __u16 src_port, __u16 dst_port)
+{
+    struct flow_key key = {
+        .src_ip = iph->saddr,
+        .dst_ip = iph->daddr,
+        .src_port = bpf_htons(src_port),
+        .dst_port = bpf_htons(dst_port),
+        .protocol = PROTO_TCP,
+    };
+    struct flow_counters *c = bpf_map_lookup_elem(&flows, &key);
+    if (c && c->packets)
+        send_flow_summary(&key, c, FLOW_SUMMARY_END);
+}
+
 // ------------------- TCP -------------------
-static __always_inline int handle_tcp(struct __sk_buff *skb, struct ethhdr *eth, struct iphdr *iph)
+static __always_inline int handle_tcp(struct pkt *p, struct ethhdr *eth, struct iphdr *iph)
 {
-    void *data_end = (void *)(long)skb->data_end;
+    void *data_end = p->data_end;
     struct tcphdr *tcph = (void *)iph + (iph->ihl * 4);
     if ((void *)(tcph + 1) > data_end) return TC_ACT_OK;
 
     __u16 src_port = bpf_ntohs(tcph->source);
     __u16 dst_port = bpf_ntohs(tcph->dest);
+
+    if (ignored(eth, iph->saddr, iph->daddr, 0))
+        return TC_ACT_OK;
+
+    // Plain TCP events can be limited to connection setup and teardown
+    __u8 tcp_filter = event_filter_flags(p, EVENT_TYPE_TCP);
+    int tcp_wanted = !(tcp_filter & FILTER_DISABLED) &&
+                     (!(tcp_filter & FILTER_CONTROL_ONLY) || tcph->syn || tcph->fin || tcph->rst);
+
+    // Only these ports can turn into HTTP or TLS events
+    int l7_port = dst_port == HTTP_PORT || dst_port == HTTP_ALT_PORT ||
+                  src_port == HTTP_PORT || src_port == HTTP_ALT_PORT ||
+                  dst_port == HTTPS_PORT || dst_port == HTTPS_ALT_PORT ||
+                  src_port == HTTPS_PORT || src_port == HTTPS_ALT_PORT;
+
+    // Skip building the event when no TCP-derived type can be wanted
+    if (!subnet_wanted(iph->saddr, iph->daddr) ||
+        (!tcp_wanted && (!l7_port || (event_disabled(p, EVENT_TYPE_HTTP) &&
+                                      event_disabled(p, EVENT_TYPE_TLS))))) {
+        count_filtered(EVENT_TYPE_TCP);
+        return TC_ACT_OK;
+    }
     
-    struct network_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    struct network_event *e = event_scratch_get();
     if (!e) return TC_ACT_OK;
 
     // Default to TCP event type
@@ -182,11 +865,11 @@ static __always_inline int handle_tcp(struct __sk_buff *skb, struct ethhdr *eth,
     __builtin_memcpy(e->dst_mac, eth->h_dest, 6);
     e->src_ip = iph->saddr;
     e->dst_ip = iph->daddr;
-    e->src_port = src_port;
-    e->dst_port = dst_port;
+    e->src_port = bpf_htons(src_port);
+    e->dst_port = bpf_htons(dst_port);
     e->protocol = PROTO_TCP;
     e->arp_op = 0;
-    e->ifindex = skb->ifindex;
+    e->ifindex = bpf_htonl(p->ifindex);
 
     // TCP flags
     __u8 flags = 0;
@@ -197,29 +880,23 @@ static __always_inline int handle_tcp(struct __sk_buff *skb, struct ethhdr *eth,
     if (tcph->psh) flags |= 0x08;
     e->tcp_flags = flags;
 
+    // Initial TTL and window size of SYNs feed passive OS fingerprinting
+    e->ip_ttl = iph->ttl;
+    e->tcp_window = tcph->window;
+    e->pkt_len = bpf_htons(p->len > 0xffff ? 0xffff : p->len);
+
     e->icmp_type = 0;
     e->icmp_code = 0;
     __builtin_memset(e->arp_sha, 0, 6);
     __builtin_memset(e->arp_tha, 0, 6);
 
-    // Copy first 32 bytes of TCP payload (if present)
+    // Classify by the start of the TCP payload (if present)
     __u8 *payload = (__u8 *)tcph + (tcph->doff * 4);
-    __builtin_memset(e->l7_payload, 0, 32);
-    
+    int client_hello = 0;
+
     if ((void *)payload < data_end) {
         __u64 size = (__u64)data_end - (__u64)payload;
         if (size > 0) {
-            if (size > 32) size = 32;
-            
-            #pragma unroll
-            for (int i = 0; i < 32; i++) {
-                if (i < size && (void *)(payload + i) < data_end) {
-                    e->l7_payload[i] = payload[i];
-                } else {
-                    break;
-                }
-            }
-
             // Detect HTTP on port 80 or 8080 
             if (dst_port == HTTP_PORT || dst_port == HTTP_ALT_PORT || 
                 src_port == HTTP_PORT || src_port == HTTP_ALT_PORT) {
@@ -233,83 +910,132 @@ static __always_inline int handle_tcp(struct __sk_buff *skb, struct ethhdr *eth,
                 src_port == HTTPS_PORT || src_port == HTTPS_ALT_PORT) {
                 if (is_tls_handshake(payload, data_end)) {
                     e->event_type = EVENT_TYPE_TLS;
+                    // Handshake type 1 = ClientHello
+                    client_hello = (void *)(payload + 6) <= data_end && payload[5] == 0x01;
                 }
             }
         }
     }
 
-    bpf_ringbuf_submit(e, 0);
+    // Final type is only known after payload inspection
+    if (e->event_type == EVENT_TYPE_TCP ? !tcp_wanted : event_disabled(p, e->event_type)) {
+        count_filtered(e->event_type);
+        return TC_ACT_OK;
+    }
+
+    // Established flows are counted instead of sent per packet; connection
+    // setup and teardown, and HTTP and TLS, are still sent as events
+    if (e->event_type == EVENT_TYPE_TCP) {
+        if (flags & 0x05)
+            end_flow(iph, src_port, dst_port);
+        else if (!(flags & 0x02) && count_flow(p, eth, iph, src_port, dst_port))
+            return TC_ACT_OK;
+    }
+
+    // Sampling leaves flow counts complete; only events are skipped
+    if (sampled_out(p)) {
+        count_filtered(e->event_type);
+        return TC_ACT_OK;
+    }
+
+    int http_request = e->event_type == EVENT_TYPE_HTTP;
+
+    // The payload limit depends on the final type
+    __u32 offset = (__u32)((void *)payload - p->data);
+    copy_l7_payload(p, e, offset);
+    send_event(e);
+
+    if (client_hello) {
+        capture_tls_hello(p, eth, iph, offset, src_port, dst_port);
+    } else if (http_request) {
+        capture_http_request(p, eth, iph, offset, src_port, dst_port);
+    }
     return TC_ACT_OK;
 }
 
 // ------------------- UDP -------------------
-static __always_inline int handle_udp(struct __sk_buff *skb, struct ethhdr *eth, struct iphdr *iph)
+static __always_inline int handle_udp(struct pkt *p, struct ethhdr *eth, struct iphdr *iph)
 {
-    void *data_end = (void *)(long)skb->data_end;
+    void *data_end = p->data_end;
     struct udphdr *udph = (void *)iph + (iph->ihl * 4);
     if ((void *)(udph + 1) > data_end) return TC_ACT_OK;
 
     __u16 src_port = bpf_ntohs(udph->source);
     __u16 dst_port = bpf_ntohs(udph->dest);
-    
-    struct network_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
-    if (!e) return TC_ACT_OK;
 
     // Default to UDP event type
-    e->event_type = EVENT_TYPE_UDP;
-    
+    __u8 event_type = EVENT_TYPE_UDP;
+
     // Check if this is DNS traffic (port 53)
     if (src_port == DNS_PORT || dst_port == DNS_PORT) {
-        e->event_type = EVENT_TYPE_DNS;
+        event_type = EVENT_TYPE_DNS;
     }
+
+    if (ignored(eth, iph->saddr, iph->daddr, event_type == EVENT_TYPE_DNS))
+        return TC_ACT_OK;
+
+    if (event_disabled(p, event_type) || !subnet_wanted(iph->saddr, iph->daddr) || sampled_out(p)) {
+        count_filtered(event_type);
+        return TC_ACT_OK;
+    }
+    
+    struct network_event *e = event_scratch_get();
+    if (!e) return TC_ACT_OK;
+
+    e->event_type = event_type;
     
     __builtin_memcpy(e->src_mac, eth->h_source, 6);
     __builtin_memcpy(e->dst_mac, eth->h_dest, 6);
     e->src_ip = iph->saddr;
     e->dst_ip = iph->daddr;
-    e->src_port = src_port;
-    e->dst_port = dst_port;
+    e->src_port = bpf_htons(src_port);
+    e->dst_port = bpf_htons(dst_port);
     e->protocol = PROTO_UDP;
     e->tcp_flags = 0;
+    e->ip_ttl = iph->ttl;
+    e->tcp_window = 0;
+    e->pkt_len = bpf_htons(p->len > 0xffff ? 0xffff : p->len);
     e->arp_op = 0;
     e->icmp_type = 0;
     e->icmp_code = 0;
-    e->ifindex = skb->ifindex;
+    e->ifindex = bpf_htonl(p->ifindex);
     __builtin_memset(e->arp_sha, 0, 6);
     __builtin_memset(e->arp_tha, 0, 6);
 
-    // Copy first 32 bytes of UDP payload (DNS, etc.)
+    // Copy the start of the UDP payload (DNS, etc.)
     __u8 *payload = (__u8 *)(udph + 1);
-    __builtin_memset(e->l7_payload, 0, 32);
-    
-    if ((void *)payload < data_end) {
-        __u64 size = (__u64)data_end - (__u64)payload;
-        if (size > 0) {
-            if (size > 32) size = 32;
-            
-            #pragma unroll
-            for (int i = 0; i < 32; i++) {
-                if (i < size && (void *)(payload + i) < data_end) {
-                    e->l7_payload[i] = payload[i];
-                } else {
-                    break;
-                }
-            }
-        }
-    }
+    __u32 offset = (__u32)((void *)payload - p->data);
+    copy_l7_payload(p, e, offset);
+
+    // QR bit clear = query to a server, set = response from one
+    int qr = (void *)(payload + 3) <= data_end && (payload[2] & 0x80);
+    int dns_message = event_type == EVENT_TYPE_DNS &&
+                      ((dst_port == DNS_PORT && !qr) || (src_port == DNS_PORT && qr));
 
-    bpf_ringbuf_submit(e, 0);
+    send_event(e);
+
+    if (dns_message) {
+        capture_dns_query(p, eth, iph, offset);
+    }
     return TC_ACT_OK;
 }
 
 // ------------------- ICMP -------------------
-static __always_inline int handle_icmp(struct __sk_buff *skb, struct ethhdr *eth, struct iphdr *iph)
+static __always_inline int handle_icmp(struct pkt *p, struct ethhdr *eth, struct iphdr *iph)
 {
-    void *data_end = (void *)(long)skb->data_end;
+    void *data_end = p->data_end;
     struct icmp_hdr *icmph = (void *)iph + (iph->ihl * 4);
     if ((void *)(icmph + 1) > data_end) return TC_ACT_OK;
 
-    struct network_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (ignored(eth, iph->saddr, iph->daddr, 0))
+        return TC_ACT_OK;
+
+    if (event_disabled(p, EVENT_TYPE_ICMP) || !subnet_wanted(iph->saddr, iph->daddr) || sampled_out(p)) {
+        count_filtered(EVENT_TYPE_ICMP);
+        return TC_ACT_OK;
+    }
+
+    struct network_event *e = event_scratch_get();
     if (!e) return TC_ACT_OK;
 
     e->event_type = EVENT_TYPE_ICMP;
@@ -320,43 +1046,82 @@ static __always_inline int handle_icmp(struct __sk_buff *skb, struct ethhdr *eth
     e->protocol = PROTO_ICMP;
     e->icmp_type = icmph->type;
     e->icmp_code = icmph->code;
-    e->ifindex = skb->ifindex;
+    e->ifindex = bpf_htonl(p->ifindex);
 
     e->tcp_flags = 0;
+    e->ip_ttl = iph->ttl;
+    e->tcp_window = 0;
+    e->pkt_len = bpf_htons(p->len > 0xffff ? 0xffff : p->len);
     e->arp_op = 0;
     e->src_port = 0;
     e->dst_port = 0;
     __builtin_memset(e->arp_sha, 0, 6);
     __builtin_memset(e->arp_tha, 0, 6);
-    __builtin_memset(e->l7_payload, 0, 32);
+    e->l7_len = 0;
 
-    bpf_ringbuf_submit(e, 0);
+    send_event(e);
     return TC_ACT_OK;
 }
 
-// ------------------- Classifier -------------------
-SEC("classifier")
-int xdp_arp_monitor(struct __sk_buff *skb)
+// ------------------- Entry points -------------------
+static __always_inline void handle_packet(struct pkt *p)
 {
-    void *data_end = (void *)(long)skb->data_end;
-    void *data = (void *)(long)skb->data;
-    struct ethhdr *eth = data;
+    struct ethhdr *eth = p->data;
 
-    if ((void *)(eth + 1) > data_end) return TC_ACT_OK;
+    if ((void *)(eth + 1) > p->data_end) return;
 
     __u16 proto = bpf_ntohs(eth->h_proto);
 
-    if (proto == ETH_P_ARP) return handle_arp(skb, eth);
+    if (proto == ETH_P_ARP) {
+        handle_arp(p, eth);
+        return;
+    }
     if (proto == ETH_P_IP) {
         struct iphdr *iph = (void *)(eth + 1);
-        if ((void *)(iph + 1) > data_end) return TC_ACT_OK;
+        if ((void *)(iph + 1) > p->data_end) return;
 
-        if (iph->protocol == PROTO_TCP) return handle_tcp(skb, eth, iph);
-        if (iph->protocol == PROTO_UDP) return handle_udp(skb, eth, iph);
-        if (iph->protocol == PROTO_ICMP) return handle_icmp(skb, eth, iph);
+        if (iph->protocol == PROTO_TCP) handle_tcp(p, eth, iph);
+        else if (iph->protocol == PROTO_UDP) handle_udp(p, eth, iph);
+        else if (iph->protocol == PROTO_ICMP) handle_icmp(p, eth, iph);
     }
+}
 
+// TC classifier, attached with TCX (the default attach mode). Despite its
+// name it is not an XDP program; the name is kept for existing deployments.
+SEC("classifier")
+int xdp_arp_monitor(struct __sk_buff *skb)
+{
+    struct pkt p = {
+        .ctx = skb,
+        .xdp = 0,
+        .data = (void *)(long)skb->data,
+        .data_end = (void *)(long)skb->data_end,
+        .len = skb->len,
+        .ifindex = skb->ifindex,
+    };
+    p.iface = bpf_map_lookup_elem(&iface_capture, &p.ifindex);
+    handle_packet(&p);
     return TC_ACT_OK;
 }
 
+// XDP program for the xdp-generic and xdp-native attach modes. It only sees
+// the linear part of a frame, so payload and length past it are missed.
+SEC("xdp")
+int xdp_monitor(struct xdp_md *ctx)
+{
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+    struct pkt p = {
+        .ctx = ctx,
+        .xdp = 1,
+        .data = data,
+        .data_end = data_end,
+        .len = data_end - data,
+        .ifindex = ctx->ingress_ifindex,
+    };
+    p.iface = bpf_map_lookup_elem(&iface_capture, &p.ifindex);
+    handle_packet(&p);
+    return XDP_PASS;
+}
+
 char _license[] SEC("license") = "GPL";
\ No newline at end of file
@@ -0,0 +1,781 @@
+package cerberus
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/capture"
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/export"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/network"
+	"github.com/zrougamed/cerberus/internal/outbound"
+	"github.com/zrougamed/cerberus/internal/threatintel"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// DataDir is the storage directory of the cerberus command
+const DataDir = "./data"
+
+// Values of -mode
+const (
+	modeFull    = "full"
+	modeAPIOnly = "api-only"
+)
+
+// Flags are the command-line settings of the cerberus command. RegisterFlags
+// defines them on a flag set; once it is parsed, Options checks them and
+// turns them into runner options.
+type Flags struct {
+	// Capture
+	interfaces, attachMode, events, captureSubnets string
+	allInterfaces, tcpControlOnly                  bool
+	payloadBytes, eventPayloadBytes                string
+	flowPackets                                    int
+	flowInterval                                   time.Duration
+	interfaceCapture                               string
+	interfaceWatch                                 monitor.InterfaceWatchConfig
+	reattach                                       bool
+	noPin, pinLinks                                bool
+	pinPath                                        string
+
+	// Storage and serving
+	mode, eventSocket            string
+	reloadInterval               time.Duration
+	dbReadOnly, dbFailHard       bool
+	patternRetention             time.Duration
+	anomalyRetention, ackWindow  time.Duration
+	apiAddr, apiAdminToken       string
+	streamRate                   int
+	outbound                     outbound.Config
+	output, outputFile           string
+	outputMaxSize                int64
+	outputMaxFiles               int
+	digest                       export.DigestConfig
+	alertDestinations            string
+	influx                       export.InfluxConfig
+	resourceInterval             time.Duration
+	resourceLimits               string
+	inventory, serviceNames      string
+	ja3Blocklist, geoIP          string
+	threatLists                  string
+	threatRefresh                time.Duration
+	maintenance                  monitor.MaintenanceConfig
+	l7InternSize                 int
+	deviceChangeDebounce         time.Duration
+	routedCIDRs, trustedCIDRs    string
+	routedAuto                   bool
+	riskWeights, egressPolicy    string
+	ipReservations, infraRoles   string
+	guestInterfaces, guestCIDRs  string
+	domainAllow, directIPAllow   string
+	arpMismatchAllow, portsWatch string
+
+	// Detection
+	baseline      monitor.BaselineConfig
+	fleet         monitor.FleetConfig
+	dnsTunnel     monitor.DNSTunnelConfig
+	domainScore   monitor.DomainScoreConfig
+	directIP      monitor.DirectIPConfig
+	novel         monitor.NovelDestinationConfig
+	firstContact  monitor.FirstContactConfig
+	arpMismatch   monitor.ARPMismatchConfig
+	portShare     monitor.PortShareConfig
+	patternNotify monitor.PatternNotifyConfig
+	uplink        monitor.UplinkConfig
+	availability  monitor.AvailabilityConfig
+	freeAddress   monitor.FreeAddressConfig
+	churn         monitor.ChurnConfig
+	snapshot      monitor.SnapshotConfig
+	self          monitor.SelfConfig
+	guest         monitor.GuestConfig
+	utilization   monitor.UtilizationConfig
+	deviceHealth  monitor.DeviceHealthConfig
+}
+
+// RegisterFlags defines the flags of the cerberus command on fs
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		interfaceWatch: monitor.DefaultInterfaceWatchConfig(),
+		maintenance:    monitor.DefaultMaintenanceConfig(),
+		fleet:          monitor.DefaultFleetConfig(),
+		dnsTunnel:      monitor.DefaultDNSTunnelConfig(),
+		domainScore:    monitor.DefaultDomainScoreConfig(),
+		directIP:       monitor.DefaultDirectIPConfig(),
+		novel:          monitor.DefaultNovelDestinationConfig(),
+		firstContact:   monitor.DefaultFirstContactConfig(),
+		arpMismatch:    monitor.DefaultARPMismatchConfig(),
+		portShare:      monitor.DefaultPortShareConfig(),
+		patternNotify:  monitor.DefaultPatternNotifyConfig(),
+		uplink:         monitor.DefaultUplinkConfig(),
+		availability:   monitor.DefaultAvailabilityConfig(),
+		freeAddress:    monitor.DefaultFreeAddressConfig(),
+		churn:          monitor.DefaultChurnConfig(),
+		snapshot:       monitor.DefaultSnapshotConfig(),
+		self:           monitor.DefaultSelfConfig(),
+		guest:          monitor.DefaultGuestConfig(),
+		utilization:    monitor.DefaultUtilizationConfig(),
+		deviceHealth:   monitor.DefaultDeviceHealthConfig(),
+	}
+
+	fs.StringVar(&f.interfaces, "interfaces", "", "Comma-separated interfaces to attach to (default: recommended physical interfaces, see 'cerberus check')")
+	fs.BoolVar(&f.allInterfaces, "all-interfaces", false, "Attach to every up, non-loopback interface, including virtual and container ones")
+	fs.StringVar(&f.attachMode, "attach-mode", capture.ModeTCX, "How to hook interfaces: tcx, xdp-generic or xdp-native (falls back to generic where the driver lacks XDP support)")
+	fs.StringVar(&f.events, "events", "all", "Comma-separated event types to capture (arp,tcp,udp,icmp,dns,http,tls)")
+	fs.StringVar(&f.captureSubnets, "capture-subnets", "", "Comma-separated IPv4 CIDRs; only events to or from them are captured (empty captures all)")
+	fs.StringVar(&f.payloadBytes, "payload-bytes", "dns=511,http=512,tls=2047", "L7 payload bytes captured per event type for DNS, HTTP and TLS inspection, e.g. dns=128,http=256,tls=1024")
+	fs.StringVar(&f.eventPayloadBytes, "event-payload-bytes", "dns=64,http=192,tls=256", "L7 payload bytes carried by each event per event type (TCP, UDP, DNS, HTTP, TLS); unlisted types carry none")
+	fs.BoolVar(&f.tcpControlOnly, "tcp-control-only", false, "Capture plain TCP events only for SYN, FIN and RST segments (HTTP and TLS events are unaffected)")
+	fs.IntVar(&f.flowPackets, "flow-packets", 64, "Count established TCP flows in the kernel and send one summary per this many packets instead of an event each (0 sends every packet)")
+	fs.DurationVar(&f.flowInterval, "flow-interval", time.Second, "Most time a kernel-side flow summary waits for -flow-packets packets (0 for no limit)")
+	fs.StringVar(&f.interfaceCapture, "interface-capture", "", "JSON file whose \"interfaces\" section sets event types, payload lengths and sampling per interface name, replacing the global capture settings on them")
+	fs.StringVar(&f.routedCIDRs, "routed-cidrs", "", "Comma-separated remote CIDRs whose devices are identified by IP instead of MAC")
+	fs.BoolVar(&f.routedAuto, "routed-auto", false, "Identify private IPs outside all local subnets by IP instead of MAC")
+	fs.StringVar(&f.trustedCIDRs, "trusted-cidrs", "", "Comma-separated CIDRs of other home networks (remote sites, VPNs) whose traffic isn't classified external")
+	fs.StringVar(&f.riskWeights, "risk-weights", "", "Override risk factor weights, e.g. threat_port=40,doh=0")
+	fs.DurationVar(&f.resourceInterval, "resource-interval", 30*time.Second, "How often cerberus samples its own resource usage")
+	fs.StringVar(&f.resourceLimits, "resource-limits", "", "Soft:hard resource limits, e.g. rss_mb=300:400,fds=800:1000,goroutines=500:1000,db_mb=500:800")
+	fs.DurationVar(&f.baseline.Interval, "baseline-interval", 10*time.Second, "Sampling interval for packet and new-pattern rate baselines")
+	fs.Float64Var(&f.baseline.ZScore, "anomaly-zscore", 4, "Deviation from a rate baseline (in standard deviations) that raises an anomaly")
+	fs.IntVar(&f.baseline.Warmup, "baseline-warmup", 30, "Baseline samples collected before rate anomalies are raised")
+	fs.IntVar(&f.fleet.MinDevices, "fleet-min-devices", f.fleet.MinDevices, "Devices of one vendor that must start contacting a new destination to raise a fleet anomaly")
+	fs.DurationVar(&f.fleet.Window, "fleet-window", f.fleet.Window, "Window in which those devices must start contacting it")
+	fs.IntVar(&f.dnsTunnel.MaxLabelLength, "dns-tunnel-label-length", f.dnsTunnel.MaxLabelLength, "DNS labels longer than this make a query suspicious")
+	fs.Float64Var(&f.dnsTunnel.MaxEntropy, "dns-tunnel-entropy", f.dnsTunnel.MaxEntropy, "Subdomain entropy (bits/char) above which a DNS query is suspicious")
+	fs.IntVar(&f.dnsTunnel.MaxSuspicious, "dns-tunnel-queries", f.dnsTunnel.MaxSuspicious, "Suspicious queries under one domain that raise a DNS tunneling anomaly")
+	fs.IntVar(&f.dnsTunnel.MaxSubdomains, "dns-tunnel-subdomains", f.dnsTunnel.MaxSubdomains, "Unique subdomains under one domain that raise a DNS tunneling anomaly")
+	fs.DurationVar(&f.dnsTunnel.Window, "dns-tunnel-window", f.dnsTunnel.Window, "Window over which DNS tunneling indicators are counted")
+	fs.Float64Var(&f.domainScore.Threshold, "dns-suspicious-threshold", f.domainScore.Threshold, "Suspicious-domain score of a device that raises a MEDIUM anomaly (0 disables scoring)")
+	fs.DurationVar(&f.domainScore.HalfLife, "dns-suspicious-half-life", f.domainScore.HalfLife, "Time after which a device's suspicious-domain score has decayed by half")
+	fs.Float64Var(&f.domainScore.MinDGAScore, "dns-dga-score", f.domainScore.MinDGAScore, "Score (0-1) from which a registered domain name counts as algorithmically generated")
+	fs.IntVar(&f.domainScore.MaxLabelLength, "dns-suspicious-label-length", f.domainScore.MaxLabelLength, "DNS labels longer than this add to a device's suspicious-domain score")
+	fs.IntVar(&f.domainScore.MaxSubdomains, "dns-suspicious-subdomains", f.domainScore.MaxSubdomains, "Unique subdomains under one domain that add to a device's suspicious-domain score")
+	fs.StringVar(&f.domainAllow, "dns-allow", "", "Comma-separated domains, with their subdomains, never scored as suspicious (added to the built-in CDN list)")
+	fs.DurationVar(&f.directIP.Window, "direct-ip-window", f.directIP.Window, "How long a DNS answer vouches for its IPs, and the window unresolved destinations are counted in")
+	fs.IntVar(&f.directIP.MaxUnresolved, "direct-ip-max", f.directIP.MaxUnresolved, "External IPs a device connects to without resolving them within the window that raise an anomaly")
+	fs.StringVar(&f.directIPAllow, "direct-ip-allow", "", "Comma-separated CIDRs never counted as unresolved destinations, e.g. CDNs or hardcoded services")
+	fs.DurationVar(&f.novel.Window, "novel-destination-window", f.novel.Window, "Window in which external destinations never seen on the network are counted")
+	fs.Float64Var(&f.novel.Multiple, "novel-destination-multiple", f.novel.Multiple, "Multiple of its baseline the count of never-seen destinations within the window must reach to raise a MEDIUM anomaly (0 = never)")
+	fs.IntVar(&f.novel.MinCount, "novel-destination-min", f.novel.MinCount, "Never-seen destinations within the window below which no anomaly is raised, whatever the baseline")
+	fs.DurationVar(&f.novel.Warmup, "novel-destination-warmup", f.novel.Warmup, "Baseline of never-seen destinations learned before anomalies are raised")
+	fs.DurationVar(&f.firstContact.MinAge, "first-contact-min-age", f.firstContact.MinAge, "Time since a device was first seen from which its first external contact raises a MEDIUM anomaly (0 = never)")
+	fs.DurationVar(&f.arpMismatch.Window, "arp-mismatch-window", f.arpMismatch.Window, "Window in which ARP packets whose sender MAC differs from the Ethernet source are counted per device")
+	fs.IntVar(&f.arpMismatch.Threshold, "arp-mismatch-threshold", f.arpMismatch.Threshold, "ARP sender mismatches from one device within the window that raise a HIGH anomaly")
+	fs.StringVar(&f.arpMismatchAllow, "arp-mismatch-allow", "", "Comma-separated MACs, or <ethernet MAC>=<sender MAC> pairs, whose ARP sender mismatches are expected (bonding, failover)")
+	fs.DurationVar(&f.portShare.Window, "port-share-window", f.portShare.Window, "Recent traffic whose per-port shares are compared with the device's earlier traffic (up to 1h)")
+	fs.Uint64Var(&f.portShare.MinBytes, "port-share-min-bytes", f.portShare.MinBytes, "Bytes a device must send within the window, and before it, for its port shares to be judged")
+	fs.Float64Var(&f.portShare.MaxShare, "port-share-max", f.portShare.MaxShare, "Share of a device's bytes over a watched port that raises an anomaly...")
+	fs.Float64Var(&f.portShare.MinorShare, "port-share-minor", f.portShare.MinorShare, "...when that port carried at most this share before the window")
+	fs.StringVar(&f.portsWatch, "port-share-watch", strings.Join(f.portShare.Watch, ","), "Comma-separated ports whose share is judged, as TCP/<port>, UDP/<port> or ICMP")
+	fs.Uint64Var(&f.portShare.ICMPMaxBytes, "icmp-max-bytes", f.portShare.ICMPMaxBytes, "ICMP payload bytes a device may send within -port-share-window before an anomaly is raised (0 disables)")
+	fs.StringVar(&f.egressPolicy, "egress-policy", "", "JSON file whose \"egress\" section maps device tags to the proxy or VPN endpoints their external traffic must go through")
+	fs.StringVar(&f.infraRoles, "infra-roles", "", "Comma-separated <MAC or IP>=<role> pairs exempting infrastructure from port share detection on its own service: dns, ntp or monitoring")
+	fs.IntVar(&f.patternNotify.PerDevice, "pattern-notify-max", f.patternNotify.PerDevice, "New-pattern notifications per device per window; the rest are summarized (0 = unlimited)")
+	fs.DurationVar(&f.patternNotify.Window, "pattern-notify-window", f.patternNotify.Window, "Window in which new-pattern notifications are counted per device")
+	fs.DurationVar(&f.deviceChangeDebounce, "device-change-debounce", monitor.DefaultDeviceChangeDebounce, "How long a device must stay unchanged before its changes (IP, vendor, OS...) are reported")
+	fs.IntVar(&f.uplink.MinDestinations, "uplink-min-destinations", f.uplink.MinDestinations, "Distinct external destinations (or DNS names) that must fail before uplink health scores down")
+	fs.IntVar(&f.uplink.Drop, "uplink-drop", f.uplink.Drop, "Uplink health points below the recent level that raise an INFO anomaly (0 = never)")
+	fs.DurationVar(&f.availability.Absence, "availability-absence", f.availability.Absence, "Silence after which a critical device counts as down since its last traffic")
+	fs.DurationVar(&f.availability.Grace, "availability-grace", f.availability.Grace, "Time a critical device is down before a DEVICE_UNAVAILABLE anomaly is raised")
+	fs.DurationVar(&f.freeAddress.Lookback, "free-lookback", f.freeAddress.Lookback, "Addresses used within this period aren't suggested by /api/v1/subnets/{cidr}/free")
+	fs.StringVar(&f.ipReservations, "ip-reservations", "", "Comma-separated address ranges (a-b), CIDRs or addresses never suggested as free, e.g. DHCP pools")
+	fs.DurationVar(&f.churn.Inactive, "churn-inactive", f.churn.Inactive, "Silence after which a device counts as having left the network in churn metrics")
+	fs.IntVar(&f.churn.Burst, "new-device-burst", f.churn.Burst, "New devices within an hour that raise an INFO NEW_DEVICE_BURST anomaly (0 = never)")
+	fs.DurationVar(&f.snapshot.Interval, "snapshot-interval", f.snapshot.Interval, "How often the state of each device active meanwhile is snapshotted for /api/v1/devices/{id}/asof (0 disables snapshots)")
+	fs.DurationVar(&f.snapshot.Daily, "snapshot-daily", f.snapshot.Daily, "Age up to which one device snapshot per day is kept; older ones are thinned to one per week")
+	fs.DurationVar(&f.snapshot.Retention, "snapshot-retention", f.snapshot.Retention, "Age after which device snapshots are deleted (0 keeps them forever)")
+	fs.BoolVar(&f.self.Detect, "self-detect", f.self.Detect, "Recognize traffic of the host running cerberus by its own MACs and IPs and flag its device self")
+	fs.BoolVar(&f.self.Exclude, "self-exclude", f.self.Exclude, "Keep the host's own traffic out of anomaly detection and new-pattern notifications")
+	fs.StringVar(&f.guestInterfaces, "guest-interfaces", "", "Comma-separated interfaces carrying guest networks; devices first seen there are transient")
+	fs.StringVar(&f.guestCIDRs, "guest-subnets", "", "Comma-separated guest network CIDRs; devices first seen there are transient")
+	fs.DurationVar(&f.guest.Expiry, "guest-expiry", f.guest.Expiry, "Inactivity after which a transient device is forgotten (0 keeps them)")
+	fs.BoolVar(&f.guest.Archive, "guest-archive", f.guest.Archive, "Keep a compact summary of each forgotten transient device")
+	fs.BoolVar(&f.guest.Notify, "guest-notify", f.guest.Notify, "Announce new transient devices like any other new device")
+	fs.IntVar(&f.l7InternSize, "l7-intern-size", monitor.DefaultL7InternSize, "Distinct DNS domains, HTTP hosts and TLS SNIs stored once and shared between devices (0 disables interning)")
+	fs.StringVar(&f.inventory, "inventory", "", "Known-devices inventory (CSV with a header row, or .json) naming devices by MAC or IP with name, owner and location")
+	fs.StringVar(&f.serviceNames, "service-names", "", "File of user-defined service names (one \"<port>/<tcp|udp> <name>\" per line) taking precedence over the IANA registry")
+	fs.StringVar(&f.ja3Blocklist, "ja3-blocklist", "", "File of known-bad JA3 hashes (one \"<md5> [description]\" per line)")
+	fs.StringVar(&f.threatLists, "threat-lists", "", "Comma-separated <name>=<path or URL> threat lists of CIDRs, IPs and domains (one per line, hosts-file lines accepted)")
+	fs.DurationVar(&f.threatRefresh, "threat-refresh", 6*time.Hour, "How often -threat-lists are reloaded (0 loads them once)")
+	fs.StringVar(&f.geoIP, "geoip-db", "", "IPv4 GeoIP database locating external destinations by country and AS (ip2asn TSV, optionally .gz)")
+	fs.StringVar(&f.output, "output", "text", "Console output: text, or json for one JSON object per line per pattern, new device, anomaly and stats tick")
+	fs.StringVar(&f.outputFile, "output-file", "", "With -output json, write JSON lines to this file and keep the text console on stdout (default: JSON lines on stdout, messages on stderr)")
+	fs.Int64Var(&f.outputMaxSize, "output-max-size", 100, "Size in MB after which -output-file is rotated (0 never rotates)")
+	fs.IntVar(&f.outputMaxFiles, "output-max-files", 5, "Rotated -output-file files kept as <file>.1 to <file>.N")
+	fs.DurationVar(&f.digest.Interval, "digest-interval", 0, "With -output json, batch anomalies below -digest-severity into one anomaly_digest record this often (0 sends each at once)")
+	fs.StringVar(&f.digest.MinSeverity, "digest-severity", models.SeverityMedium, "Anomalies at or above this severity (INFO, LOW, MEDIUM, HIGH) skip the digest")
+	fs.StringVar(&f.alertDestinations, "alert-destinations", "", "JSON file of named webhook, syslog and digest destinations that alert routes (managed via the API) send events to")
+	fs.IntVar(&f.digest.MaxItems, "digest-max-items", 20, "Anomalies listed in one digest; the rest are only counted")
+	fs.StringVar(&f.apiAddr, "api-addr", "127.0.0.1:8080", "Listen address for the HTTP API, e.g. [::1]:8080 for IPv6 or [::]:8080 for every IPv4 and IPv6 address (empty disables it)")
+	fs.IntVar(&f.streamRate, "stream-rate", api.DefaultStreamRate, "Events per second sent to each API event stream client; the rest are dropped and counted (0 is unlimited)")
+	fs.StringVar(&f.apiAdminToken, "api-admin-token", "", "Bearer token for admin API endpoints such as bulk export (empty disables them)")
+	fs.DurationVar(&f.patternRetention, "pattern-retention", monitor.DefaultPatternRetention, "How long persisted communication patterns are kept (0 keeps them forever)")
+	fs.DurationVar(&f.anomalyRetention, "anomaly-retention", monitor.DefaultAnomalyRetention, "How long persisted anomalies and their acknowledgements are kept (0 keeps them forever)")
+	fs.BoolVar(&f.dbFailHard, "db-fail-hard", false, "Exit when the database file is corrupt instead of moving it aside and starting with what can be salvaged")
+	fs.StringVar(&f.mode, "mode", modeFull, "full captures and serves the API; api-only serves the database of a full process capturing into the same data directory, without root, and relays its live events")
+	fs.StringVar(&f.eventSocket, "event-socket", "", "Unix socket a full process publishes live events on for api-only processes (default: "+SocketFile+" in the data directory)")
+	fs.DurationVar(&f.reloadInterval, "reload-interval", DefaultReloadInterval, "With -mode api-only, how often the database is reloaded if it changed")
+	fs.BoolVar(&f.dbReadOnly, "db-read-only", false, "Serve the existing database over the API without writing to it or capturing, e.g. for analysis of a copied data directory")
+	fs.DurationVar(&f.ackWindow, "ack-window", monitor.DefaultAckWindow, "How long after an anomaly is acknowledged repeats of it on the same device are recorded without notification (0 disables muting)")
+	fs.StringVar(&f.influx.URL, "influx-url", "", "InfluxDB base URL for line-protocol export, e.g. http://localhost:8086 (empty disables it)")
+	fs.StringVar(&f.influx.Org, "influx-org", "", "InfluxDB organization")
+	fs.StringVar(&f.influx.Bucket, "influx-bucket", "cerberus", "InfluxDB bucket")
+	fs.StringVar(&f.influx.Token, "influx-token", "", "InfluxDB API token")
+	fs.BoolVar(&f.outbound.Disabled, "offline", false, "Disable all outbound HTTP (OUI/IANA downloads, online vendor lookups) for air-gapped deployments")
+	fs.StringVar(&f.outbound.Proxy, "http-proxy", "", "Proxy URL for outbound HTTP (default: HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment)")
+	fs.DurationVar(&f.outbound.Timeout, "http-timeout", 0, "Timeout for each outbound HTTP request (0 keeps the per-lookup defaults)")
+	fs.IntVar(&f.outbound.Retries, "http-retries", 0, "Retries for outbound HTTP requests failing with a network error, 429 or 5xx")
+	fs.StringVar(&f.outbound.CABundle, "http-ca-bundle", "", "PEM file of extra CAs to trust for outbound HTTPS, e.g. for a TLS-intercepting proxy")
+	fs.DurationVar(&f.influx.Interval, "influx-interval", 30*time.Second, "How often metrics are pushed to InfluxDB")
+	fs.DurationVar(&f.interfaceWatch.Silence, "interface-silence", f.interfaceWatch.Silence, "Silence after which an attached interface that produced traffic is reported degraded (0 disables the check)")
+	fs.Uint64Var(&f.interfaceWatch.MinEvents, "interface-min-events", f.interfaceWatch.MinEvents, "Events an interface must produce before its silence is watched")
+	fs.BoolVar(&f.reattach, "interface-reattach", false, "Re-attach a silent interface once before reporting it")
+	fs.Float64Var(&f.utilization.Threshold, "interface-utilization-threshold", f.utilization.Threshold, "Percent of its link speed an interface's traffic must stay above to raise an INFO anomaly (0 = never)")
+	fs.DurationVar(&f.utilization.Sustain, "interface-utilization-sustain", f.utilization.Sustain, "How long an interface stays above -interface-utilization-threshold before the anomaly is raised")
+	fs.DurationVar(&f.deviceHealth.Window, "device-health-window", f.deviceHealth.Window, "Traffic device health is measured over; it is recomputed every minute")
+	fs.DurationVar(&f.maintenance.Interval, "maintenance-interval", f.maintenance.Interval, "How often housekeeping (transient device expiry, L7 map trimming, detector pruning, database compaction) runs (0 only runs it via the API)")
+	fs.IntVar(&f.maintenance.L7MapLimit, "l7-map-limit", f.maintenance.L7MapLimit, "Most frequent entries kept per device in each DNS, HTTP and TLS map by maintenance (0 keeps all)")
+	fs.BoolVar(&f.noPin, "no-pin", false, "Don't pin BPF maps, so kernel-side counters start over on every run")
+	fs.StringVar(&f.pinPath, "pin-path", capture.DefaultPinPath, "Directory on the BPF filesystem to pin maps (and links, with -pin-links) in")
+	fs.BoolVar(&f.pinLinks, "pin-links", false, "Pin the TCX links too, so they stay attached after exit and the next run takes them over without a capture gap ('cerberus cleanup' detaches them)")
+	return f
+}
+
+// Reattach reports whether -interface-reattach is set, which needs the
+// privileges capture starts with
+func (f *Flags) Reattach() bool {
+	return f.reattach
+}
+
+// Options checks the flags and returns the options they select. With -output
+// json and no -output-file, the JSON lines take over stdout and everything
+// else printed to stdout moves to stderr. The output files and exporters the
+// options open are closed by the runner's Shutdown.
+func (f *Flags) Options() ([]Option, error) {
+	s, err := f.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON lines on stdout replace the console lines, and every other message
+	// moves to stderr so the stream can be piped as is
+	var closers []func()
+	if f.output == "json" && f.outputFile == "" {
+		s.jsonOnly = true
+		s.jsonOut = export.NewJSONLines(os.Stdout)
+		os.Stdout = os.Stderr
+	} else if f.outputFile != "" {
+		file, err := export.OpenRotatingFile(f.outputFile, f.outputMaxSize<<20, f.outputMaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open -output-file: %w", err)
+		}
+		closers = append(closers, func() { file.Close() })
+		s.jsonOut = export.NewJSONLines(file)
+	}
+	if s.jsonOut != nil {
+		closers = append(closers, func() { s.jsonOut.Close() })
+		s.sink = s.jsonOut
+	}
+	if f.digest.Interval > 0 {
+		config := f.digest
+		config.MinSeverity = strings.ToUpper(config.MinSeverity)
+		digest, err := export.NewDigest(s.jsonOut, config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -digest-* configuration: %w", err)
+		}
+		// Closed before jsonOut, so the last digest is written
+		closers = append(closers, digest.Close)
+		s.sink = digest
+	}
+
+	if err := outbound.Configure(f.outbound); err != nil {
+		return nil, fmt.Errorf("invalid outbound HTTP settings: %w", err)
+	}
+
+	s.logger = log.New(os.Stdout, "", 0)
+	mapPinPath := f.pinPath
+	if f.noPin {
+		mapPinPath = ""
+	}
+	onlineLookups := "on"
+	if f.outbound.Disabled {
+		onlineLookups = "off"
+	}
+	opts := []Option{
+		WithLogger(s.logger),
+		WithStorage(DataDir),
+		WithInterfaces(strings.Split(f.interfaces, ",")...),
+		WithCaptureBackend(BPF(BPFConfig{
+			Mode:               f.attachMode,
+			PinPath:            mapPinPath,
+			PinLinks:           f.pinLinks,
+			InterfaceSilence:   f.interfaceWatch.Silence,
+			InterfaceMinEvents: f.interfaceWatch.MinEvents,
+			Reattach:           f.reattach,
+		})),
+		WithCaptureConfig(s.captureConfig),
+		WithInterfaceCapture(s.perInterface),
+		WithEventSocket(f.eventSocket),
+		WithAPIAddr(f.apiAddr),
+		func(o *options) {
+			// Settings shaping what the API reads, applied to every
+			// database an api-only process reloads too
+			o.monitorSetup = append(o.monitorSetup, s.setupMonitor)
+			o.apiSetup = append(o.apiSetup, func(server *api.Server) {
+				server.SetAdminToken(f.apiAdminToken)
+				server.SetStreamRate(f.streamRate)
+				server.SetFeatures(map[string]string{
+					"capture_backend": f.attachMode,
+					"online_lookups":  onlineLookups,
+				})
+			})
+			// Everything else configures capture, which the full process
+			// runs
+			if f.mode != modeAPIOnly {
+				o.captureSetup = append(o.captureSetup, s.setupCapture)
+				o.captureStopped = append(o.captureStopped, s.printFinalStats)
+			}
+			o.closers = append(o.closers, closers...)
+			o.closers = append(o.closers, s.close)
+		},
+	}
+	if f.allInterfaces {
+		opts = append(opts, WithAllInterfaces())
+	}
+	if f.dbFailHard {
+		opts = append(opts, WithStrictStorage())
+	}
+	if f.dbReadOnly {
+		opts = append(opts, WithReadOnlyStorage())
+	}
+	if f.mode == modeAPIOnly {
+		opts = append(opts, WithAPIOnly(f.reloadInterval))
+	}
+	return opts, nil
+}
+
+// settings are the flags once parsed, and what the monitor setup opens
+type settings struct {
+	*Flags
+
+	enabledEvents   []uint8
+	captureConfig   models.CaptureConfig
+	perInterface    map[string]models.InterfaceCapture
+	egress          monitor.EgressConfig
+	routed, trusted []*net.IPNet
+	weights         monitor.RiskWeights
+	limits          monitor.ResourceLimits
+
+	logger   *log.Logger
+	jsonOut  *export.JSONLines
+	jsonOnly bool
+	sink     monitor.EventSink
+
+	mon          *monitor.NetworkMonitor // The capturing monitor
+	destinations *export.AlertDestinations
+	influxWriter *export.InfluxWriter
+	stopConsole  chan struct{}
+}
+
+// parse checks the flags and parses their values
+func (f *Flags) parse() (*settings, error) {
+	s := &settings{Flags: f, egress: monitor.DefaultEgressConfig()}
+	var err error
+
+	if s.enabledEvents, err = utils.ParseEventTypes(f.events); err != nil {
+		return nil, fmt.Errorf("invalid -events value: %w", err)
+	}
+	if f.captureSubnets != "" {
+		if _, err := utils.EncodeSubnetFilter(strings.Split(f.captureSubnets, ",")); err != nil {
+			return nil, fmt.Errorf("invalid -capture-subnets value: %w", err)
+		}
+	}
+	payloadLimits, err := utils.ParsePayloadBytes(f.payloadBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -payload-bytes value: %w", err)
+	}
+	eventPayloadLimits, err := utils.ParseEventPayloadBytes(f.eventPayloadBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -event-payload-bytes value: %w", err)
+	}
+	s.captureConfig = models.CaptureConfig{
+		Events:         strings.Split(f.events, ","),
+		TCPControlOnly: f.tcpControlOnly,
+		PayloadBytes:   payloadLimits,
+
+		EventPayloadBytes: eventPayloadLimits,
+
+		FlowPackets:    f.flowPackets,
+		FlowIntervalMs: int(f.flowInterval.Milliseconds()),
+	}
+	if _, err := utils.EncodeFlowConfig(s.captureConfig); err != nil {
+		return nil, fmt.Errorf("invalid flow aggregation: %w", err)
+	}
+	if f.captureSubnets != "" {
+		s.captureConfig.Subnets = strings.Split(f.captureSubnets, ",")
+	}
+	if f.interfaceCapture != "" {
+		if s.perInterface, err = monitor.LoadInterfaceCaptureFile(f.interfaceCapture); err != nil {
+			return nil, fmt.Errorf("invalid -interface-capture: %w", err)
+		}
+	}
+
+	if f.egressPolicy != "" {
+		if s.egress, err = monitor.LoadEgressFile(f.egressPolicy); err != nil {
+			return nil, fmt.Errorf("invalid -egress-policy: %w", err)
+		}
+	}
+	if s.routed, err = network.ParseCIDRList(f.routedCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid -routed-cidrs value: %w", err)
+	}
+	if s.trusted, err = network.ParseCIDRList(f.trustedCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid -trusted-cidrs value: %w", err)
+	}
+	if s.weights, err = monitor.ParseRiskWeights(f.riskWeights); err != nil {
+		return nil, fmt.Errorf("invalid -risk-weights value: %w", err)
+	}
+	if s.limits, err = monitor.ParseResourceLimits(f.resourceLimits); err != nil {
+		return nil, fmt.Errorf("invalid -resource-limits value: %w", err)
+	}
+
+	if f.baseline.Interval <= 0 || f.baseline.ZScore <= 0 {
+		return nil, errors.New("-baseline-interval and -anomaly-zscore must be positive")
+	}
+	if f.fleet.MinDevices < 2 || f.fleet.Window <= 0 {
+		return nil, errors.New("-fleet-min-devices must be at least 2 and -fleet-window positive")
+	}
+	if f.l7InternSize < 0 {
+		return nil, errors.New("-l7-intern-size must not be negative")
+	}
+	if f.maintenance.Interval < 0 || f.maintenance.L7MapLimit < 0 {
+		return nil, errors.New("-maintenance-interval and -l7-map-limit must not be negative")
+	}
+
+	if t := f.dnsTunnel; t.MaxLabelLength <= 0 || t.MaxEntropy <= 0 || t.MaxSuspicious <= 0 || t.MaxSubdomains <= 0 || t.Window <= 0 {
+		return nil, errors.New("-dns-tunnel-* values must be positive")
+	}
+	if d := f.domainScore; d.Threshold < 0 || d.HalfLife <= 0 || d.MinDGAScore <= 0 || d.MinDGAScore > 1 ||
+		d.MaxLabelLength <= 0 || d.MaxSubdomains <= 0 {
+		return nil, errors.New("-dns-suspicious-* values must be positive and -dns-dga-score within (0, 1]")
+	}
+	f.domainScore.Allow = strings.Split(f.domainAllow, ",")
+
+	if f.directIP.Window <= 0 || f.directIP.MaxUnresolved <= 0 {
+		return nil, errors.New("-direct-ip-window and -direct-ip-max must be positive")
+	}
+	if n := f.novel; n.Window <= 0 || n.Multiple < 0 || n.MinCount < 0 || n.Warmup < 0 {
+		return nil, errors.New("-novel-destination-window must be positive and the other -novel-destination-* values not negative")
+	}
+	if f.firstContact.MinAge < 0 {
+		return nil, errors.New("-first-contact-min-age must not be negative")
+	}
+	if f.directIP.Allow, err = network.ParseCIDRList(f.directIPAllow); err != nil {
+		return nil, fmt.Errorf("invalid -direct-ip-allow value: %w", err)
+	}
+
+	if f.arpMismatch.Window <= 0 || f.arpMismatch.Threshold <= 0 {
+		return nil, errors.New("-arp-mismatch-window and -arp-mismatch-threshold must be positive")
+	}
+	if f.arpMismatch.Allow, err = monitor.ParseARPMismatchAllow(f.arpMismatchAllow); err != nil {
+		return nil, fmt.Errorf("invalid -arp-mismatch-allow value: %w", err)
+	}
+
+	if p := f.portShare; p.Window < time.Minute || p.Window >= monitor.PortHistory {
+		return nil, fmt.Errorf("-port-share-window must be at least 1m and under %s", monitor.PortHistory)
+	}
+	if p := f.portShare; p.MaxShare <= 0 || p.MaxShare > 1 || p.MinorShare < 0 || p.MinorShare >= p.MaxShare {
+		return nil, errors.New("-port-share-max must be within (0, 1] and -port-share-minor within [0, -port-share-max)")
+	}
+	if f.portShare.Watch, err = monitor.ParsePortWatch(f.portsWatch); err != nil {
+		return nil, fmt.Errorf("invalid -port-share-watch value: %w", err)
+	}
+	if f.portShare.Roles, err = monitor.ParseInfraRoles(f.infraRoles); err != nil {
+		return nil, fmt.Errorf("invalid -infra-roles value: %w", err)
+	}
+
+	if f.deviceChangeDebounce <= 0 {
+		return nil, errors.New("-device-change-debounce must be positive")
+	}
+	if f.uplink.MinDestinations < 1 || f.uplink.Drop < 0 {
+		return nil, errors.New("-uplink-min-destinations must be positive and -uplink-drop must not be negative")
+	}
+	if f.freeAddress.Lookback <= 0 {
+		return nil, errors.New("-free-lookback must be positive")
+	}
+	if f.freeAddress.Reserved, err = monitor.ParseAddressRanges(f.ipReservations); err != nil {
+		return nil, fmt.Errorf("invalid -ip-reservations value: %w", err)
+	}
+	if n := f.snapshot; n.Interval < 0 || n.Daily < 0 || n.Retention < 0 {
+		return nil, errors.New("-snapshot-interval, -snapshot-daily and -snapshot-retention must not be negative")
+	}
+	if f.churn.Inactive <= 0 || f.churn.Burst < 0 {
+		return nil, errors.New("-churn-inactive must be positive and -new-device-burst must not be negative")
+	}
+	if f.availability.Absence <= 0 || f.availability.Grace <= 0 {
+		return nil, errors.New("-availability-absence and -availability-grace must be positive")
+	}
+	if f.patternNotify.PerDevice < 0 || f.patternNotify.Window <= 0 {
+		return nil, errors.New("-pattern-notify-max must not be negative and -pattern-notify-window must be positive")
+	}
+
+	if f.guest.Subnets, err = network.ParseCIDRList(f.guestCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid -guest-subnets value: %w", err)
+	}
+	f.guest.Interfaces = nil
+	for _, name := range strings.Split(f.guestInterfaces, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			f.guest.Interfaces = append(f.guest.Interfaces, name)
+		}
+	}
+
+	if f.noPin && f.pinLinks {
+		return nil, errors.New("-pin-links can't be combined with -no-pin")
+	}
+	switch f.attachMode {
+	case capture.ModeTCX, capture.ModeXDPGeneric, capture.ModeXDPNative:
+	default:
+		return nil, fmt.Errorf("invalid -attach-mode value %q, want tcx, xdp-generic or xdp-native", f.attachMode)
+	}
+	// Taking over a pinned link can't tell generic from native XDP
+	if f.pinLinks && f.attachMode != capture.ModeTCX {
+		return nil, errors.New("-pin-links needs -attach-mode tcx")
+	}
+
+	if f.reattach && f.interfaceWatch.Silence == 0 {
+		return nil, errors.New("-interface-reattach needs -interface-silence")
+	}
+	if f.utilization.Threshold < 0 || f.utilization.Sustain < 0 {
+		return nil, errors.New("-interface-utilization-threshold and -interface-utilization-sustain must not be negative")
+	}
+	if f.deviceHealth.Window < 10*time.Minute {
+		return nil, errors.New("-device-health-window must be at least 10m")
+	}
+
+	if f.apiAddr != "" {
+		if err := api.ValidateListenAddr(f.apiAddr); err != nil {
+			return nil, fmt.Errorf("invalid -api-addr value: %w", err)
+		}
+	}
+	if f.dbReadOnly && f.apiAddr == "" {
+		return nil, errors.New("-db-read-only serves the database over the API and needs -api-addr")
+	}
+	switch f.mode {
+	case modeFull:
+	case modeAPIOnly:
+		if f.apiAddr == "" {
+			return nil, errors.New("-mode api-only serves the database over the API and needs -api-addr")
+		}
+		if f.dbReadOnly {
+			return nil, errors.New("-mode api-only follows a running full process; use -db-read-only alone to serve a copied database")
+		}
+		if f.reloadInterval <= 0 {
+			return nil, errors.New("-reload-interval must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("invalid -mode value %q: expected %s or %s", f.mode, modeFull, modeAPIOnly)
+	}
+
+	if f.output != "text" && f.output != "json" {
+		return nil, fmt.Errorf("invalid -output value %q: expected text or json", f.output)
+	}
+	if f.outputFile != "" && f.output != "json" {
+		return nil, errors.New("-output-file requires -output json")
+	}
+	if f.outputMaxSize < 0 || f.outputMaxFiles < 0 {
+		return nil, errors.New("-output-max-size and -output-max-files can't be negative")
+	}
+	if f.digest.Interval < 0 {
+		return nil, errors.New("-digest-interval can't be negative")
+	}
+	if f.digest.Interval > 0 && f.output != "json" {
+		return nil, errors.New("-digest-interval requires -output json")
+	}
+	return s, nil
+}
+
+// setupMonitor applies the settings shaping what the API reads
+func (s *settings) setupMonitor(mon *monitor.NetworkMonitor) {
+	mon.SetRoutedSubnets(s.routed, s.routedAuto)
+	mon.SetTrustedNetworks(s.trusted)
+	mon.SetRiskWeights(s.weights)
+	mon.SetPatternRetention(s.patternRetention)
+	mon.SetAnomalyRetention(s.anomalyRetention)
+	mon.SetAvailabilityConfig(s.availability)
+	mon.SetFreeAddressConfig(s.freeAddress)
+	mon.SetChurnConfig(s.churn)
+	mon.SetSnapshotConfig(s.snapshot)
+	// Validated by LoadEgressFile
+	mon.SetEgressConfig(s.egress)
+}
+
+// setupCapture configures the detectors of the capturing monitor, loads the
+// reference data and starts the exporters and the console statistics
+func (s *settings) setupCapture(mon *monitor.NetworkMonitor) error {
+	s.mon = mon
+	mon.SetEnabledEvents(s.enabledEvents)
+	mon.SetAckWindow(s.ackWindow)
+	mon.SetL7InternSize(s.l7InternSize)
+	mon.SetFleetConfig(s.fleet)
+	if err := mon.SetDomainScoreConfig(s.domainScore); err != nil {
+		return fmt.Errorf("invalid -dns-allow value: %w", err)
+	}
+	mon.SetDNSTunnelConfig(s.dnsTunnel)
+	mon.SetDirectIPConfig(s.directIP)
+	mon.SetNovelDestinationConfig(s.novel)
+	mon.SetFirstContactConfig(s.firstContact)
+	mon.SetUtilizationConfig(s.utilization)
+	mon.SetDeviceHealthConfig(s.deviceHealth)
+	if s.sink != nil {
+		mon.SetEventSink(s.sink, s.jsonOnly)
+	}
+	if s.alertDestinations != "" {
+		destinations, err := export.LoadAlertDestinations(s.alertDestinations)
+		if err != nil {
+			return fmt.Errorf("invalid -alert-destinations: %w", err)
+		}
+		s.destinations = destinations
+		mon.SetAlertDestinations(destinations.Destinations)
+		s.logger.Printf("Loaded %d alert destinations", len(destinations.Destinations))
+	}
+	mon.SetARPMismatchConfig(s.arpMismatch)
+	mon.SetPortShareConfig(s.portShare)
+	mon.SetDeviceChangeDebounce(s.deviceChangeDebounce)
+	mon.SetPatternNotifyConfig(s.patternNotify)
+	mon.SetUplinkConfig(s.uplink)
+	mon.SetSelfConfig(s.self)
+	mon.SetGuestConfig(s.guest)
+	mon.StartResourceMonitor(s.resourceInterval, s.limits)
+	mon.StartMaintenance(s.maintenance)
+	if s.inventory != "" {
+		inventory, err := monitor.LoadInventory(s.inventory)
+		if err != nil {
+			return fmt.Errorf("invalid -inventory: %w", err)
+		}
+		mon.SetInventory(inventory)
+		s.logger.Printf("Loaded %d known devices", inventory.Len())
+	}
+	if s.serviceNames != "" {
+		count, err := mon.LoadServiceNames(s.serviceNames)
+		if err != nil {
+			return fmt.Errorf("invalid -service-names: %w", err)
+		}
+		s.logger.Printf("Loaded %d service names", count)
+	}
+	if s.ja3Blocklist != "" {
+		blocklist, err := monitor.LoadJA3Blocklist(s.ja3Blocklist)
+		if err != nil {
+			return fmt.Errorf("invalid -ja3-blocklist: %w", err)
+		}
+		mon.SetJA3Blocklist(blocklist)
+		s.logger.Printf("Loaded %d blocklisted JA3 fingerprints", len(blocklist))
+	}
+	if s.threatLists != "" {
+		sources, err := threatintel.ParseSources(s.threatLists)
+		if err != nil {
+			return fmt.Errorf("invalid -threat-lists: %w", err)
+		}
+		// A list that fails to load, say while offline, is retried on refresh
+		feed, err := threatintel.NewFeed(sources)
+		if err != nil {
+			s.logger.Printf("Warning: %v", err)
+		}
+		mon.SetThreatIntel(feed)
+		if s.threatRefresh > 0 {
+			feed.Start(s.threatRefresh, func(err error) {
+				s.logger.Printf("Warning: %v", err)
+			})
+		}
+		for _, list := range feed.Lists() {
+			s.logger.Printf("Loaded threat list %s: %d CIDRs, %d domains", list.Name, list.CIDRs, list.Domains)
+		}
+	}
+	if s.geoIP != "" {
+		geoIP, err := databases.LoadGeoIPDatabase(s.geoIP)
+		if err != nil {
+			return fmt.Errorf("invalid -geoip-db: %w", err)
+		}
+		mon.SetGeoIP(geoIP)
+		s.logger.Printf("Loaded %d GeoIP ranges", geoIP.Len())
+	}
+	mon.StartBaselineMonitor(s.baseline)
+
+	if s.influx.URL != "" {
+		influx, err := export.NewInfluxWriter(mon, s.influx)
+		if err != nil {
+			return fmt.Errorf("invalid -influx-* configuration: %w", err)
+		}
+		s.influxWriter = influx
+		influx.Start()
+	}
+
+	s.stopConsole = make(chan struct{})
+	go s.printStats(s.stopConsole)
+	return nil
+}
+
+// printStats shows that capture is alive every 10 seconds, and reports the
+// statistics every minute, until stop is closed
+func (s *settings) printStats(stop <-chan struct{}) {
+	alive := time.NewTicker(10 * time.Second)
+	defer alive.Stop()
+	stats := time.NewTicker(60 * time.Second)
+	defer stats.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-alive.C:
+			counts := s.mon.Stats.Snapshot()
+			s.logger.Printf("Alive - Packets: Total=%d ARP=%d TCP=%d UDP=%d ICMP=%d DNS=%d HTTP=%d TLS=%d | Devices=%d",
+				counts.TotalPackets,
+				counts.ArpPackets,
+				counts.TcpPackets,
+				counts.UdpPackets,
+				counts.IcmpPackets,
+				counts.DnsPackets,
+				counts.HttpPackets,
+				counts.TlsPackets,
+				s.mon.Cache.Len())
+		case <-stats.C:
+			if s.jsonOut != nil {
+				s.jsonOut.Emit(monitor.SinkStats, s.mon.StatsReport())
+			}
+			if !s.jsonOnly {
+				s.mon.PrintStats()
+			}
+		}
+	}
+}
+
+// printFinalStats reports the statistics once capture stopped
+func (s *settings) printFinalStats() {
+	if s.jsonOut != nil {
+		s.jsonOut.Emit(monitor.SinkStats, s.mon.StatsReport())
+	}
+	if !s.jsonOnly {
+		s.logger.Println("\n\nFinal Statistics:")
+		s.mon.PrintStats()
+	}
+}
+
+// close stops what setupCapture started
+func (s *settings) close() {
+	if s.stopConsole != nil {
+		close(s.stopConsole)
+	}
+	if s.influxWriter != nil {
+		s.influxWriter.Stop()
+	}
+	if s.destinations != nil {
+		s.destinations.Close()
+	}
+}
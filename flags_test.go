@@ -0,0 +1,55 @@
+package cerberus
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"testing"
+)
+
+// The command's flags check their values, and their defaults make a runner
+// that the replay backend can run
+func TestFlagsOptions(t *testing.T) {
+	parse := func(args ...string) ([]Option, error) {
+		fs := flag.NewFlagSet("cerberus", flag.ContinueOnError)
+		flags := RegisterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return flags.Options()
+	}
+
+	for _, args := range [][]string{
+		{"-events", "arp,smtp"},
+		{"-mode", "readonly"},
+		{"-mode", "api-only", "-api-addr", ""},
+		{"-pin-links", "-no-pin"},
+		{"-port-share-max", "0.1", "-port-share-minor", "0.2"},
+		{"-interface-reattach", "-interface-silence", "0"},
+		{"-output-file", "out.jsonl"},
+		{"-device-health-window", "1m"},
+	} {
+		if _, err := parse(args...); err == nil {
+			t.Errorf("%v accepted, want an error", args)
+		}
+	}
+
+	opts, err := parse("-api-addr", "", "-snapshot-interval", "0", "-maintenance-interval", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append(opts, WithStorage(t.TempDir()), WithCaptureBackend(ReplayReader(bytes.NewReader(pcapHeader()))), quietLogger())
+	r, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runAsync(ctx, r)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v", err)
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
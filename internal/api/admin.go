@@ -0,0 +1,71 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// postFlush writes pending state to the database before answering
+func (s *Server) postFlush(w http.ResponseWriter, r *http.Request) {
+	result, err := s.monitor().Flush()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// postMaintenance runs housekeeping now and reports what it reclaimed
+func (s *Server) postMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().RunMaintenance(monitor.MaintenanceOnDemand))
+}
+
+// getMaintenance returns the report of the latest maintenance run
+func (s *Server) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	report := s.monitor().LastMaintenance()
+	if report == nil {
+		writeError(w, http.StatusNotFound, "maintenance has not run yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// getBackup streams a tar.gz backup of the database. Nothing is sent until
+// the snapshot is taken, so a failed snapshot is still reported as an error.
+func (s *Server) getBackup(w http.ResponseWriter, r *http.Request) {
+	bw := &backupWriter{w: w, name: fmt.Sprintf("cerberus-backup-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))}
+	if _, err := s.monitor().Backup(bw); err != nil {
+		if !bw.started {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		// Cut the response off so a truncated archive isn't mistaken for a
+		// complete one
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// backupWriter sends the response headers on the first write and flushes
+// every write, so large archives reach the client in chunks as they are built
+type backupWriter struct {
+	w       http.ResponseWriter
+	name    string
+	started bool
+}
+
+func (bw *backupWriter) Write(p []byte) (int, error) {
+	if !bw.started {
+		bw.started = true
+		bw.w.Header().Set("Content-Type", "application/gzip")
+		bw.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bw.name))
+		bw.w.WriteHeader(http.StatusOK)
+	}
+	n, err := bw.w.Write(p)
+	if flusher, ok := bw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
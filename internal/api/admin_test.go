@@ -0,0 +1,110 @@
+package api
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// A backup downloaded from the API restores into a fresh data directory with
+// the same devices
+func TestBackupRestore(t *testing.T) {
+	mon, err := monitor.NewNetworkMonitor(1000, filepath.Join(t.TempDir(), "network.db"))
+	if err != nil {
+		t.Fatalf("NewNetworkMonitor: %v", err)
+	}
+	t.Cleanup(func() { mon.Close() })
+	s := NewServer(mon)
+	s.SetAdminToken("secret")
+	// Not flushed: the backup must flush the cached devices itself
+	seedMonitor(t, mon)
+
+	backup := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/backup", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := backup(""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("backup without the admin token = %d, want 401", rec.Code)
+	}
+	rec := backup("secret")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup = %d %s, want a gzip archive", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// Extract it the way the README says to restore
+	dataDir := t.TempDir()
+	var manifest models.BackupManifest
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading the archive: %v", err)
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch header.Name {
+		case "manifest.json":
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				t.Fatalf("manifest: %v", err)
+			}
+		case monitor.BackupDatabaseName:
+			if err := os.WriteFile(filepath.Join(dataDir, header.Name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			t.Errorf("unexpected archive entry %q", header.Name)
+		}
+	}
+
+	// Read-only, since only it loads every persisted device into the cache
+	restored, err := monitor.NewReadOnlyNetworkMonitor(1000, filepath.Join(dataDir, monitor.BackupDatabaseName))
+	if err != nil {
+		t.Fatalf("opening the restored database: %v", err)
+	}
+	defer restored.Close()
+	want := listedIDs(mon.ListDevices())
+	got := listedIDs(restored.ListDevices())
+	if len(got) != 3 || len(got) != len(want) || manifest.Devices != len(want) {
+		t.Fatalf("restored %v, manifest counts %d, want %v", got, manifest.Devices, want)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("restored %v, want %v", got, want)
+	}
+	if device, ok := restored.GetDevice(appleMAC); !ok || device.IP != "192.168.2.5" || device.TCPConnections == 0 {
+		t.Errorf("restored %s = %+v, want its address and connections", appleMAC, device)
+	}
+}
+
+// listedIDs lists the IDs of a monitor's devices, sorted
+func listedIDs(devices []*models.DeviceInfo) []string {
+	ids := make([]string, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
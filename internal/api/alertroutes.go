@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// alertRouteRequest is the body of POST /api/v1/alert-routes and of PUT on a
+// route
+type alertRouteRequest struct {
+	Name         string   `json:"name"`
+	Events       []string `json:"events"`
+	MinSeverity  string   `json:"min_severity"`
+	AnomalyTypes []string `json:"anomaly_types"`
+	Tags         []string `json:"tags"`
+	Destinations []string `json:"destinations"`
+	Default      bool     `json:"default"`
+}
+
+// dryRunRequest is the body of POST /api/v1/alert-routes/dry-run: a sample
+// event of a sink record type, with the data its notification would carry
+type dryRunRequest struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// dryRunRecords maps the record types routes match to the data they carry
+var dryRunRecords = map[string]func() any{
+	monitor.SinkAnomaly:         func() any { return &models.Anomaly{} },
+	monitor.SinkNewDevice:       func() any { return &models.DeviceInfo{} },
+	monitor.SinkDeviceChange:    func() any { return &models.DeviceUpdate{} },
+	monitor.SinkDeviceIPChanged: func() any { return &models.IPChange{} },
+	monitor.SinkPattern:         func() any { return &models.CommunicationPattern{} },
+	monitor.SinkPatternSummary:  func() any { return &models.PatternSummary{} },
+}
+
+// decodeAlertRoute reads an alert route from a request body
+func decodeAlertRoute(w http.ResponseWriter, r *http.Request) (models.AlertRoute, bool) {
+	var req alertRouteRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid alert route: "+err.Error())
+		return models.AlertRoute{}, false
+	}
+	return models.AlertRoute{
+		Name:         req.Name,
+		Events:       req.Events,
+		MinSeverity:  req.MinSeverity,
+		AnomalyTypes: req.AnomalyTypes,
+		Tags:         req.Tags,
+		Destinations: req.Destinations,
+		Default:      req.Default,
+	}, true
+}
+
+func (s *Server) listAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"routes":       s.monitor().AlertRoutes(),
+		"destinations": s.monitor().AlertDestinations(),
+	})
+}
+
+func (s *Server) createAlertRoute(w http.ResponseWriter, r *http.Request) {
+	route, ok := decodeAlertRoute(w, r)
+	if !ok {
+		return
+	}
+	route, err := s.monitor().AddAlertRoute(route)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, route)
+}
+
+func (s *Server) updateAlertRoute(w http.ResponseWriter, r *http.Request) {
+	route, ok := decodeAlertRoute(w, r)
+	if !ok {
+		return
+	}
+	route, err := s.monitor().UpdateAlertRoute(r.PathValue("id"), route)
+	if errors.Is(err, monitor.ErrAlertRouteNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, route)
+}
+
+func (s *Server) deleteAlertRoute(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().DeleteAlertRoute(r.PathValue("id"))
+	if errors.Is(err, monitor.ErrAlertRouteNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dryRunAlertRoutes reports which routes a sample event would fire, without
+// delivering it
+func (s *Server) dryRunAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	var req dryRunRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid event: "+err.Error())
+		return
+	}
+
+	newRecord, ok := dryRunRecords[req.Event]
+	if !ok {
+		events := make([]string, 0, len(dryRunRecords))
+		for event := range dryRunRecords {
+			events = append(events, event)
+		}
+		slices.Sort(events)
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown event %q: expected one of %s", req.Event, strings.Join(events, ", ")))
+		return
+	}
+	data := newRecord()
+	if len(req.Data) > 0 {
+		if err := json.Unmarshal(req.Data, data); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid data: "+err.Error())
+			return
+		}
+	}
+	if device, ok := data.(*models.DeviceInfo); ok {
+		device.ID = strings.ToLower(device.ID)
+	}
+	writeJSON(w, http.StatusOK, s.monitor().MatchAlertRoutes(req.Event, data))
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// bulkAnomalyRequest is the body of POST /api/v1/anomalies/bulk. Anomalies
+// are selected by ids, by filter, or by the name of a saved filter.
+type bulkAnomalyRequest struct {
+	Action      string                `json:"action"`
+	IDs         []string              `json:"ids"`
+	Filter      *models.AnomalyFilter `json:"filter"`
+	SavedFilter string                `json:"saved_filter"`
+	Comment     string                `json:"comment"` // ack
+	Note        string                `json:"note"`    // assign-note
+}
+
+// anomalyFilterRequest is the body of POST /api/v1/anomalies/filters, and of
+// PUT on a saved filter, which ignores the name
+type anomalyFilterRequest struct {
+	Name   string               `json:"name"`
+	Filter models.AnomalyFilter `json:"filter"`
+}
+
+// withSavedFilter adds the saved filter named by the filter query parameter,
+// if any, to a filter. It reports false once it wrote an error.
+func (s *Server) withSavedFilter(w http.ResponseWriter, r *http.Request, filter anomalyFilter) (anomalyFilter, bool) {
+	name := r.URL.Query().Get("filter")
+	if name == "" {
+		return filter, true
+	}
+	saved, err := s.monitor().AnomalyFilter(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return filter, false
+	}
+	if filter.saved, err = s.monitor().AnomalyMatcher(saved.Filter); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return filter, false
+	}
+	return filter, true
+}
+
+// bulkAnomalies acknowledges, deletes or assigns a note to several anomalies.
+// Deleting by filter requires ?confirm=true.
+func (s *Server) bulkAnomalies(w http.ResponseWriter, r *http.Request) {
+	var req bulkAnomalyRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid bulk operation: "+err.Error())
+		return
+	}
+
+	selectors := 0
+	for _, set := range []bool{len(req.IDs) > 0, req.Filter != nil, req.SavedFilter != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		writeError(w, http.StatusBadRequest, "select anomalies with exactly one of ids, filter or saved_filter")
+		return
+	}
+	if req.SavedFilter != "" {
+		saved, err := s.monitor().AnomalyFilter(req.SavedFilter)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		req.Filter = &saved.Filter
+	}
+	confirm, _ := strconv.ParseBool(r.URL.Query().Get("confirm"))
+
+	result, err := s.monitor().BulkAnomalies(monitor.BulkAnomalyOp{
+		Action:  req.Action,
+		IDs:     req.IDs,
+		Filter:  req.Filter,
+		Comment: req.Comment,
+		Note:    req.Note,
+		Confirm: confirm,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) listAnomalyFilters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().AnomalyFilters())
+}
+
+func (s *Server) getAnomalyFilter(w http.ResponseWriter, r *http.Request) {
+	saved, err := s.monitor().AnomalyFilter(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, saved)
+}
+
+// decodeAnomalyFilter reads a saved filter from a request body
+func decodeAnomalyFilter(w http.ResponseWriter, r *http.Request) (anomalyFilterRequest, bool) {
+	var req anomalyFilterRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid saved filter: "+err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+func (s *Server) createAnomalyFilter(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAnomalyFilter(w, r)
+	if !ok {
+		return
+	}
+	saved, err := s.monitor().SaveAnomalyFilter(req.Name, req.Filter, true)
+	if errors.Is(err, monitor.ErrAnomalyFilterExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, saved)
+}
+
+func (s *Server) updateAnomalyFilter(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeAnomalyFilter(w, r)
+	if !ok {
+		return
+	}
+	saved, err := s.monitor().SaveAnomalyFilter(r.PathValue("name"), req.Filter, false)
+	if errors.Is(err, monitor.ErrAnomalyFilterNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, saved)
+}
+
+func (s *Server) deleteAnomalyFilter(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().DeleteAnomalyFilter(r.PathValue("name"))
+	if errors.Is(err, monitor.ErrAnomalyFilterNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// defaultAvailabilityWindow is the window availability is reported over by
+// default
+const defaultAvailabilityWindow = 24 * time.Hour
+
+// availabilityReport is the response of GET /api/v1/availability
+type availabilityReport struct {
+	Window  string                `json:"window"`
+	Devices []models.Availability `json:"devices"`
+}
+
+// availabilityWindow parses the window parameter, a duration up to
+// monitor.AvailabilityHistory
+func availabilityWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	window := defaultAvailabilityWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 || window > monitor.AvailabilityHistory {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid window: expected a duration up to %s", monitor.AvailabilityHistory))
+			return 0, false
+		}
+	}
+	return window, true
+}
+
+// getDeviceAvailability returns the availability of a critical device and its
+// up/down transitions over the window, 24h by default
+func (s *Server) getDeviceAvailability(w http.ResponseWriter, r *http.Request) {
+	window, ok := availabilityWindow(w, r)
+	if !ok {
+		return
+	}
+
+	availability, ok := s.monitor().DeviceAvailability(s.deviceID(r), window)
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found or not critical")
+		return
+	}
+	writeJSON(w, http.StatusOK, availability)
+}
+
+// listAvailability returns the availability of every critical device over the
+// window, 24h by default, least available first
+func (s *Server) listAvailability(w http.ResponseWriter, r *http.Request) {
+	window, ok := availabilityWindow(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, availabilityReport{Window: window.String(), Devices: s.monitor().Availability(window)})
+}
@@ -0,0 +1,321 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// bulkFlushEvery is how many NDJSON records are written between flushes
+const bulkFlushEvery = 100
+
+// bulkTrailer is the last NDJSON line of a bulk response
+type bulkTrailer struct {
+	Trailer      bool   `json:"_trailer"`
+	Count        int    `json:"count"`
+	Continuation string `json:"continuation"`
+	Complete     bool   `json:"complete"`
+}
+
+// requireAdmin only lets requests bearing the admin token through. Admin
+// endpoints are disabled entirely while no token is configured.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			writeError(w, http.StatusForbidden, "admin API disabled: start cerberus with -api-admin-token")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cerberus"`)
+			writeError(w, http.StatusUnauthorized, "admin token required")
+			return
+		}
+
+		// Every write goes through the admin API
+		if s.monitor().ReadOnly() && r.Method != http.MethodGet {
+			writeError(w, http.StatusForbidden, "database is read-only: cerberus was started with -db-read-only")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// parseBulkQuery reads since, until, after, shard ("i/N"), limit, annotated,
+// evidence, origin, traffic_type, interface and snapshots. The interface is
+// checked by Server.checkInterfaces.
+func parseBulkQuery(r *http.Request) (monitor.BulkQuery, error) {
+	var q monitor.BulkQuery
+	params := r.URL.Query()
+
+	for name, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: expected RFC 3339 time", name)
+			}
+			*dst = ts
+		}
+	}
+
+	if v := params.Get("after"); v != "" {
+		key, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid continuation token")
+		}
+		q.After = string(key)
+	}
+
+	if v := params.Get("shard"); v != "" {
+		index, count, ok := strings.Cut(v, "/")
+		shard, err1 := strconv.Atoi(index)
+		shards, err2 := strconv.Atoi(count)
+		if !ok || err1 != nil || err2 != nil || shards < 1 || shard < 0 || shard >= shards {
+			return q, fmt.Errorf("invalid shard: expected i/N with 0 <= i < N")
+		}
+		q.Shard, q.Shards = shard, shards
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("invalid limit")
+		}
+		q.Limit = limit
+	}
+
+	if v := params.Get("annotated"); v != "" {
+		annotated, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid annotated: expected true or false")
+		}
+		q.Annotated = annotated
+	}
+
+	if v := params.Get("evidence"); v != "" {
+		if !slices.Contains(models.Evidences, v) {
+			return q, fmt.Errorf("invalid evidence: expected one of %s", strings.Join(models.Evidences, ", "))
+		}
+		q.Evidence = v
+	}
+
+	if v := params.Get("snapshots"); v != "" {
+		snapshots, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid snapshots: expected true or false")
+		}
+		q.Snapshots = snapshots
+	}
+
+	if v := params.Get("origin"); v != "" {
+		if v != models.OriginSelf && v != monitor.OriginNetwork {
+			return q, fmt.Errorf("invalid origin: expected %s or %s", models.OriginSelf, monitor.OriginNetwork)
+		}
+		q.Origin = v
+	}
+
+	if v := params.Get("traffic_type"); v != "" {
+		trafficType, err := parseTrafficType(v)
+		if err != nil {
+			return q, err
+		}
+		q.Traffic = trafficType
+	}
+	q.Interface = params.Get("interface")
+
+	return q, nil
+}
+
+type bulkReader func(*monitor.NetworkMonitor, *http.Request, monitor.BulkQuery, func(string) error) (monitor.BulkResult, error)
+
+// streamBulk writes records as NDJSON followed by a trailer record. It stops as
+// soon as the client disconnects.
+func (s *Server) streamBulk(read bulkReader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseBulkQuery(r)
+		if err == nil && q.Interface != "" {
+			err = s.checkInterfaces(q.Interface)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// End the stream on shutdown as well as on client disconnect
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		r = r.WithContext(ctx)
+
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		out := bufio.NewWriter(w)
+		written := 0
+		result, err := read(s.monitor(), r, q, func(value string) error {
+			if _, err := out.WriteString(value); err != nil {
+				return err
+			}
+			if err := out.WriteByte('\n'); err != nil {
+				return err
+			}
+			written++
+			if written%bulkFlushEvery == 0 {
+				if err := out.Flush(); err != nil {
+					return err
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() != nil {
+			return // Client went away or server shutting down
+		}
+
+		trailer := bulkTrailer{
+			Trailer:      true,
+			Count:        result.Count,
+			Continuation: base64.RawURLEncoding.EncodeToString([]byte(result.Continuation)),
+			Complete:     err == nil && result.Complete,
+		}
+		data, _ := json.Marshal(trailer)
+		out.Write(data)
+		out.WriteByte('\n')
+		out.Flush()
+	}
+}
+
+func (s *Server) bulkDevices() http.HandlerFunc {
+	return s.streamBulk(func(mon *monitor.NetworkMonitor, r *http.Request, q monitor.BulkQuery, emit func(string) error) (monitor.BulkResult, error) {
+		return mon.BulkDevices(r.Context(), q, emit)
+	})
+}
+
+func (s *Server) bulkPatterns() http.HandlerFunc {
+	return s.streamBulk(func(mon *monitor.NetworkMonitor, r *http.Request, q monitor.BulkQuery, emit func(string) error) (monitor.BulkResult, error) {
+		return mon.BulkPatterns(r.Context(), q, emit)
+	})
+}
+
+// syncTrailer is the last NDJSON line of a device sync response
+type syncTrailer struct {
+	Trailer  bool   `json:"_trailer"`
+	Count    int    `json:"count"`
+	Cursor   string `json:"cursor"`
+	Complete bool   `json:"complete"`
+}
+
+// encodeSyncCursor turns a sync position into an opaque token
+func encodeSyncCursor(c monitor.SyncCursor) string {
+	if c.LastSeen.IsZero() {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(c.LastSeen.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+func decodeSyncCursor(token string) (monitor.SyncCursor, error) {
+	var c monitor.SyncCursor
+	if token == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(data), "|")
+	if c.LastSeen, err = time.Parse(time.RFC3339Nano, ts); !ok || err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	c.ID = id
+	return c, nil
+}
+
+// syncDevices streams as NDJSON the devices updated since the cursor, followed
+// by a trailer carrying the cursor for the next sync. Unlike the bulk device
+// export, which walks the whole database in key order, it resumes from
+// wherever the previous sync stopped.
+func (s *Server) syncDevices(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	cursor, err := decodeSyncCursor(params.Get("cursor"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	out := bufio.NewWriter(w)
+	written := 0
+	result, err := s.monitor().SyncDevices(ctx, cursor, limit, func(value string) error {
+		if _, err := out.WriteString(value); err != nil {
+			return err
+		}
+		if err := out.WriteByte('\n'); err != nil {
+			return err
+		}
+		written++
+		if written%bulkFlushEvery == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return // Client went away or server shutting down
+	}
+
+	data, _ := json.Marshal(syncTrailer{
+		Trailer:  true,
+		Count:    result.Count,
+		Cursor:   encodeSyncCursor(result.Cursor),
+		Complete: err == nil && result.Complete,
+	})
+	out.Write(data)
+	out.WriteByte('\n')
+	out.Flush()
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zrougamed/cerberus/internal/capture"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+func (s *Server) getCaptureConfig(w http.ResponseWriter, r *http.Request) {
+	status, err := s.monitor().CaptureStatus()
+	if err != nil {
+		writeCaptureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) putCaptureConfig(w http.ResponseWriter, r *http.Request) {
+	var config models.CaptureConfig
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid capture config: "+err.Error())
+		return
+	}
+	status, err := s.monitor().ApplyCaptureConfig(config)
+	if err != nil {
+		writeCaptureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) getInterface(w http.ResponseWriter, r *http.Request) {
+	detail, err := s.monitor().InterfaceDetail(r.PathValue("name"))
+	if err != nil {
+		writeCaptureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func (s *Server) putInterfaceCapture(w http.ResponseWriter, r *http.Request) {
+	var config models.InterfaceCapture
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid capture config: "+err.Error())
+		return
+	}
+	detail, err := s.monitor().SetInterfaceCapture(r.PathValue("name"), &config)
+	if err != nil {
+		writeCaptureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func (s *Server) deleteInterfaceCapture(w http.ResponseWriter, r *http.Request) {
+	detail, err := s.monitor().SetInterfaceCapture(r.PathValue("name"), nil)
+	if err != nil {
+		writeCaptureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+func writeCaptureError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, monitor.ErrInterfaceNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, capture.ErrNoInterfaceCapture):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case errors.Is(err, monitor.ErrInvalidCaptureConfig):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, monitor.ErrNoCaptureControl):
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// Default churn windows per granularity
+var defaultChurnWindows = map[string]time.Duration{
+	monitor.ChurnHourly: 24 * time.Hour,
+	monitor.ChurnDaily:  30 * 24 * time.Hour,
+}
+
+// churnReport is the response of GET /api/v1/stats/churn
+type churnReport struct {
+	Granularity string              `json:"granularity"`
+	Window      string              `json:"window"`
+	Points      []models.ChurnPoint `json:"points"`
+}
+
+// getChurn returns the devices joining, leaving and coming back per hour or
+// day (the default). window is a duration up to monitor.ChurnHistory, 24h for
+// hours and 30 days for days by default.
+func (s *Server) getChurn(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	granularity := params.Get("granularity")
+	if granularity == "" {
+		granularity = monitor.ChurnDaily
+	}
+
+	window := defaultChurnWindows[granularity]
+	if v := params.Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 || window > monitor.ChurnHistory {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid window: expected a duration up to %s", monitor.ChurnHistory))
+			return
+		}
+	}
+
+	points, err := s.monitor().ChurnSeries(granularity, window)
+	if errors.Is(err, monitor.ErrUnknownGranularity) {
+		writeError(w, http.StatusBadRequest, "invalid granularity: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, churnReport{Granularity: granularity, Window: window.String(), Points: points})
+}
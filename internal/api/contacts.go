@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// queryContacts returns the handler listing the devices that reached the
+// destination IP, domain or port in the path. since is an RFC 3339 time or a
+// duration back from now; subdomains=true widens a domain to the names under it.
+func (s *Server) queryContacts(kind, param string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		var since time.Time
+		if v := params.Get("since"); v != "" {
+			if window, err := time.ParseDuration(v); err == nil && window > 0 {
+				since = time.Now().Add(-window)
+			} else if since, err = time.Parse(time.RFC3339, v); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid since: expected RFC 3339 time or duration")
+				return
+			}
+		}
+
+		var subdomains bool
+		if v := params.Get("subdomains"); v != "" {
+			var err error
+			if subdomains, err = strconv.ParseBool(v); err != nil || (subdomains && kind != monitor.ContactDomain) {
+				writeError(w, http.StatusBadRequest, "invalid subdomains: expected a boolean, on domain queries only")
+				return
+			}
+		}
+
+		contacts, err := s.monitor().Contacts(kind, r.PathValue(param), since, subdomains)
+		if errors.Is(err, monitor.ErrInvalidContactQuery) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, contacts)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// auditRequest is the optional body of the PUT decision audit endpoints;
+// without a ttl the audit runs for monitor.DefaultDecisionAudit
+type auditRequest struct {
+	TTL string `json:"ttl"` // e.g. "2h", at most monitor.MaxDecisionAudit
+}
+
+func (s *Server) getDecisionAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().DecisionAudit())
+}
+
+// startNetworkAudit audits the decisions on the patterns of every device
+func (s *Server) startNetworkAudit(w http.ResponseWriter, r *http.Request) {
+	s.startAudit(w, r, "")
+}
+
+// startDeviceAudit audits the decisions on the patterns of a device given by
+// MAC, device ID or IP
+func (s *Server) startDeviceAudit(w http.ResponseWriter, r *http.Request) {
+	s.startAudit(w, r, s.monitor().ResolveDeviceID(r.PathValue("id")))
+}
+
+func (s *Server) startAudit(w http.ResponseWriter, r *http.Request, id string) {
+	var req auditRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid audit: "+err.Error())
+		return
+	}
+
+	ttl := monitor.DefaultDecisionAudit
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid ttl: expected a positive duration such as 1h")
+			return
+		}
+	}
+
+	audit, err := s.monitor().AuditDecisions(id, ttl)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, audit)
+}
+
+func (s *Server) stopNetworkAudit(w http.ResponseWriter, r *http.Request) {
+	s.stopAudit(w, "")
+}
+
+func (s *Server) stopDeviceAudit(w http.ResponseWriter, r *http.Request) {
+	s.stopAudit(w, s.monitor().ResolveDeviceID(r.PathValue("id")))
+}
+
+func (s *Server) stopAudit(w http.ResponseWriter, id string) {
+	err := s.monitor().StopDecisionAudit(id)
+	if errors.Is(err, monitor.ErrAuditNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getDeviceDecisions returns the decisions recorded while a device was
+// audited, newest first
+func (s *Server) getDeviceDecisions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().DeviceDecisions(s.deviceID(r)))
+}
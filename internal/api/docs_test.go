@@ -0,0 +1,305 @@
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// Routes whose documented query parameters are checked against their
+// behavior
+const (
+	routeDevices     = "/api/v1/devices"
+	routeBulkDevices = "/api/v1/bulk/devices"
+	routeBulkPattern = "/api/v1/bulk/patterns"
+	routeStream      = "/api/v1/patterns/stream"
+)
+
+// documentedParams reads the query parameters the README documents for each
+// route: the ?name= examples of the endpoint table row of /api/v1/devices,
+// and the parameter tables of the bulk export and the pattern stream
+func documentedParams(t *testing.T) map[string][]string {
+	t.Helper()
+	data, err := os.ReadFile("../../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	params := make(map[string][]string)
+	add := func(route, name string) {
+		if !slices.Contains(params[route], name) {
+			params[route] = append(params[route], name)
+		}
+	}
+
+	// The rows of the parameter table following a heading
+	table := func(heading string) [][]string {
+		start := slices.Index(lines, heading)
+		if start < 0 {
+			t.Fatalf("README has no %q section", heading)
+		}
+		var rows [][]string
+		for _, line := range lines[start+1:] {
+			if strings.HasPrefix(line, "#") {
+				break
+			}
+			if strings.HasPrefix(line, "| `") {
+				rows = append(rows, strings.Split(strings.Trim(line, "| "), " | "))
+			} else if len(rows) > 0 {
+				break
+			}
+		}
+		return rows
+	}
+	names := regexp.MustCompile("`([a-z_]+)`")
+
+	for _, row := range table("#### Bulk Export") {
+		routes := []string{routeBulkDevices, routeBulkPattern}
+		if strings.HasPrefix(row[1], "Patterns only") {
+			routes = routes[1:]
+		} else if strings.HasPrefix(row[1], "Devices only") {
+			routes = routes[:1]
+		}
+		for _, match := range names.FindAllStringSubmatch(row[0], -1) {
+			for _, route := range routes {
+				add(route, match[1])
+			}
+		}
+	}
+	for _, row := range table("### Event Streams") {
+		for _, match := range names.FindAllStringSubmatch(row[0], -1) {
+			add(routeStream, match[1])
+		}
+	}
+
+	examples := regexp.MustCompile(`\?([a-z_]+)=`)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "| `GET "+routeDevices+"` |") {
+			for _, match := range examples.FindAllStringSubmatch(line, -1) {
+				add(routeDevices, match[1])
+			}
+		}
+	}
+	for _, route := range []string{routeDevices, routeBulkDevices, routeBulkPattern, routeStream} {
+		if len(params[route]) == 0 {
+			t.Fatalf("found no documented parameters of %s", route)
+		}
+	}
+	return params
+}
+
+// paramsFixture is the persisted state the documented parameters are run
+// against, from testdata/documented_params.json
+type paramsFixture struct {
+	Devices  []json.RawMessage             `json:"devices"`
+	Patterns []models.CommunicationPattern `json:"patterns"`
+}
+
+// newParamsServer returns an API server over a read-only monitor of the
+// fixture devices and patterns, on interfaces eth0 and eth1, and the
+// fixture's patterns with their database keys
+func newParamsServer(t *testing.T) (*Server, []models.CommunicationPattern) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/documented_params.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture paramsFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "network.db")
+	db, err := buntdb.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *buntdb.Tx) error {
+		for _, device := range fixture.Devices {
+			var id struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(device, &id)
+			if _, _, err := tx.Set(id.ID, string(device), nil); err != nil {
+				return err
+			}
+		}
+		for i := range fixture.Patterns {
+			pattern := &fixture.Patterns[i]
+			pattern.ID = fmt.Sprintf("%s%020d:%06d", monitor.PatternKeyPrefix, pattern.Timestamp.UnixNano(), i)
+			value, _ := json.Marshal(pattern)
+			if _, _, err := tx.Set(pattern.ID, string(value), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	mon, err := monitor.NewReadOnlyNetworkMonitor(1000, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mon.Close() })
+	for index, name := range []string{"eth0", "eth1"} {
+		mon.Interfaces().Update(index+1, func(status *models.InterfaceStatus) { status.Name, status.Attached = name, true })
+	}
+	s := NewServer(mon)
+	s.SetAdminToken("secret")
+	t.Cleanup(func() { s.Shutdown(t.Context()) })
+	return s, fixture.Patterns
+}
+
+// routeResults returns what a route answers to a query, one string per
+// record in order: the listed devices, the exported records without their
+// trailer, or the IDs of the fixture patterns a stream with the query's
+// filter would send
+func routeResults(t *testing.T, s *Server, patterns []models.CommunicationPattern, route, query string) []string {
+	t.Helper()
+	var results []string
+	if route == routeStream {
+		params, _ := url.ParseQuery(query)
+		filter, err := parsePatternFilter(params)
+		if err == nil {
+			err = s.checkInterfaces(filter.Interfaces...)
+		}
+		if err != nil {
+			t.Fatalf("%s?%s: %v", route, query, err)
+		}
+		for _, pattern := range patterns {
+			if filter.match(&pattern, s.streamExternal) {
+				results = append(results, pattern.ID)
+			}
+		}
+		return results
+	}
+
+	req := httptest.NewRequest(http.MethodGet, route+"?"+query, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s?%s = %d %s", route, query, rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+	if route == routeDevices {
+		var devices []json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &devices); err != nil {
+			t.Fatal(err)
+		}
+		for _, device := range devices {
+			results = append(results, string(device))
+		}
+		return results
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Text(); !strings.Contains(line, `"_trailer":true`) {
+			results = append(results, line)
+		}
+	}
+	return results
+}
+
+// Every query parameter the README documents for a route has a fixture
+// query, and each such query changes what the route returns on the fixture
+// data: selects fewer records, orders them differently or adds to them. A
+// parameter documented but ignored, or implemented but undocumented here,
+// fails the test.
+func TestDocumentedParams(t *testing.T) {
+	s, patterns := newParamsServer(t)
+	continuation := func(key string) string { return base64.RawURLEncoding.EncodeToString([]byte(key)) }
+	day2 := time.Date(2026, 4, 2, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+
+	queries := map[string]map[string]string{
+		routeDevices: {
+			"sort":              "sort=risk",
+			"os":                "os=windows",
+			"type":              "type=printer",
+			"vendor":            "vendor=Apple%20Inc.",
+			"subnet":            "subnet=192.168.0.0/16",
+			"interface":         "interface=eth1",
+			"circuit":           "circuit=gi1/0/5",
+			"egress":            "egress=non_compliant",
+			"include_transient": "include_transient=false",
+			"include":           "include=hints",
+			"health":            "health=poor,degraded",
+		},
+		routeBulkDevices: {
+			"since":     "since=" + day2,
+			"until":     "until=" + day2,
+			"shard":     "shard=1/2",
+			"limit":     "limit=1",
+			"after":     "after=" + continuation("00:01:42:bb:00:02"),
+			"interface": "interface=eth1",
+			"snapshots": "snapshots=true",
+		},
+		routeBulkPattern: {
+			"since":        "since=" + day2,
+			"until":        "until=" + day2,
+			"shard":        "shard=1/2",
+			"limit":        "limit=2",
+			"after":        "after=" + continuation(patterns[0].ID),
+			"annotated":    "annotated=true",
+			"evidence":     "evidence=port-heuristic",
+			"traffic_type": "traffic_type=tcp_syn",
+			"interface":    "interface=eth0",
+			"origin":       "origin=network",
+		},
+		routeStream: {
+			"device":       "device=00:03:93:AA:00:01",
+			"protocol":     "protocol=dns,icmp",
+			"traffic_type": "traffic_type=TCP_SYN&traffic_type=dns_query",
+			"interface":    "interface=eth1",
+			"src_ip":       "src_ip=192.168.20.0/24",
+			"dst_ip":       "dst_ip=203.0.113.10",
+			"direction":    "direction=inbound",
+			"external":     "external=true",
+		},
+	}
+
+	documented := documentedParams(t)
+	for route, params := range documented {
+		t.Run(route, func(t *testing.T) {
+			all := routeResults(t, s, patterns, route, "")
+			if len(all) < 2 {
+				t.Fatalf("the fixture gives %d records, too few to filter", len(all))
+			}
+			for _, param := range params {
+				query, ok := queries[route][param]
+				if !ok {
+					t.Errorf("%s is documented but has no fixture query", param)
+					continue
+				}
+				got := routeResults(t, s, patterns, route, query)
+				if len(got) == 0 || slices.Equal(got, all) {
+					t.Errorf("?%s returns %d of %d records, unchanged or none: documented but ignored?", query, len(got), len(all))
+				}
+			}
+			for param := range queries[route] {
+				if !slices.Contains(params, param) {
+					t.Errorf("%s is implemented but not documented", param)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+func (s *Server) getDomainAllowlist(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().DomainAllowlist())
+}
+
+// putDomainAllowlist replaces the configured domains exempt from
+// suspicious-domain scoring; the built-in ones always apply
+func (s *Server) putDomainAllowlist(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domains []string `json:"domains"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid allowlist: "+err.Error())
+		return
+	}
+	allowlist, err := s.monitor().SetDomainAllowlist(req.Domains)
+	if err != nil {
+		if errors.Is(err, monitor.ErrInvalidDomain) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, allowlist)
+}
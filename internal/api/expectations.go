@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// expectationRequest is the body of POST /api/v1/expectations
+type expectationRequest struct {
+	Type     string     `json:"type"`
+	Vendor   string     `json:"vendor"`
+	OUI      string     `json:"oui"`
+	Role     string     `json:"role"`
+	Device   string     `json:"device"`
+	Start    *time.Time `json:"start"` // Defaults to now
+	End      *time.Time `json:"end"`
+	Duration string     `json:"duration"` // Alternative to end, from start, e.g. "1h"
+	Note     string     `json:"note"`
+}
+
+var expectationStates = map[string]bool{
+	models.ExpectationPending:   true,
+	models.ExpectationActive:    true,
+	models.ExpectationFulfilled: true,
+	models.ExpectationUnmatched: true,
+}
+
+func (s *Server) listExpectations(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && !expectationStates[state] {
+		writeError(w, http.StatusBadRequest, "invalid state: expected pending, active, fulfilled or unmatched")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.monitor().Expectations(state))
+}
+
+func (s *Server) createExpectation(w http.ResponseWriter, r *http.Request) {
+	var req expectationRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid expectation: "+err.Error())
+		return
+	}
+
+	start := time.Now()
+	if req.Start != nil {
+		start = *req.Start
+	}
+	end := req.End
+	if req.Duration != "" {
+		if end != nil {
+			writeError(w, http.StatusBadRequest, "set either end or duration, not both")
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid duration: expected a positive duration such as 1h")
+			return
+		}
+		until := start.Add(duration)
+		end = &until
+	}
+	if end == nil {
+		writeError(w, http.StatusBadRequest, "set end or duration")
+		return
+	}
+
+	expectation, err := s.monitor().AddExpectation(models.Expectation{
+		Type:   req.Type,
+		Vendor: req.Vendor,
+		OUI:    req.OUI,
+		Role:   req.Role,
+		Device: req.Device,
+		Start:  start,
+		End:    *end,
+		Note:   req.Note,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, expectation)
+}
+
+func (s *Server) cancelExpectation(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().CancelExpectation(r.PathValue("id"))
+	if errors.Is(err, monitor.ErrExpectationNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Device internals hidden from regular responses, returned only when requested
+const (
+	fieldSeenPatterns = "seen_patterns"
+	fieldFlowStats    = "flow_stats"
+)
+
+// deviceFields holds every field name accepted in ?fields= for devices
+var deviceFields = func() map[string]bool {
+	fields := map[string]bool{
+		fieldSeenPatterns: true,
+		fieldFlowStats:    true,
+	}
+
+	t := reflect.TypeOf(models.DeviceInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// parseFields reads the ?fields= sparse fieldset; nil means every regular field
+func parseFields(r *http.Request) (map[string]bool, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+
+	fields := make(map[string]bool)
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !deviceFields[name] {
+			known := make([]string, 0, len(deviceFields))
+			for field := range deviceFields {
+				known = append(known, field)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown field %q (known fields: %s)", name, strings.Join(known, ","))
+		}
+		fields[name] = true
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// deviceView shapes a device for a response, keeping only the requested fields.
+// Requested fields that are empty are returned as null rather than dropped.
+func (s *Server) deviceView(device *models.DeviceInfo, fields map[string]bool) (any, error) {
+	if fields == nil {
+		return device, nil
+	}
+
+	data, err := json.Marshal(device)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+
+	view := make(map[string]any, len(fields))
+	for name := range fields {
+		if value, ok := all[name]; ok {
+			view[name] = value
+		} else {
+			view[name] = nil
+		}
+	}
+
+	if fields[fieldSeenPatterns] || fields[fieldFlowStats] {
+		patterns, flows, ok := s.monitor().DeviceInternals(device.ID)
+		if ok {
+			if fields[fieldSeenPatterns] {
+				view[fieldSeenPatterns] = patterns
+			}
+			if fields[fieldFlowStats] {
+				view[fieldFlowStats] = flows
+			}
+		}
+	}
+
+	return view, nil
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// parseTrafficType uppercases a traffic type filter and checks it is known
+func parseTrafficType(value string) (string, error) {
+	value = strings.ToUpper(value)
+	if slices.Contains(models.TrafficTypes, models.TrafficType(value)) {
+		return value, nil
+	}
+	valid := make([]string, len(models.TrafficTypes))
+	for i, trafficType := range models.TrafficTypes {
+		valid[i] = string(trafficType)
+	}
+	return "", fmt.Errorf("invalid traffic_type %q: expected one of %s", value, strings.Join(valid, ", "))
+}
+
+// checkInterfaces checks that interface filters name interfaces cerberus
+// attached to
+func (s *Server) checkInterfaces(names ...string) error {
+	valid := s.monitor().InterfaceNames()
+	for _, name := range names {
+		if slices.Contains(valid, name) {
+			continue
+		}
+		if len(valid) == 0 {
+			return fmt.Errorf("invalid interface %q: no interface is attached", name)
+		}
+		return fmt.Errorf("invalid interface %q: expected one of %s", name, strings.Join(valid, ", "))
+	}
+	return nil
+}
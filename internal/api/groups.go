@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// groupStatsReport is the response of GET /api/v1/groups/stats
+type groupStatsReport struct {
+	GroupBy string              `json:"group_by"`
+	Window  string              `json:"window"`
+	Groups  []models.GroupStats `json:"groups"`
+}
+
+// getGroupStats aggregates devices by vendor or network. window is a duration
+// up to monitor.GroupHistory, which is also the default.
+func (s *Server) getGroupStats(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	groupBy := params.Get("group_by")
+	if groupBy == "tag" || groupBy == "type" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid group_by: devices have no %ss, expected %s or %s", groupBy, monitor.GroupByVendor, monitor.GroupByNetwork))
+		return
+	}
+
+	window := monitor.GroupHistory
+	if v := params.Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 || window > monitor.GroupHistory {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid window: expected a duration up to %s", monitor.GroupHistory))
+			return
+		}
+	}
+
+	groups, err := s.monitor().GroupStats(groupBy, window)
+	if errors.Is(err, monitor.ErrUnknownGrouping) {
+		writeError(w, http.StatusBadRequest, "invalid group_by: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if groups == nil {
+		groups = []models.GroupStats{}
+	}
+	writeJSON(w, http.StatusOK, groupStatsReport{GroupBy: groupBy, Window: window.String(), Groups: groups})
+}
@@ -0,0 +1,830 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/version"
+)
+
+// defaultSearchLimit caps the matches returned per search result group
+const defaultSearchLimit = 50
+
+// maxDiffPatterns bounds the new patterns returned by one diff
+const maxDiffPatterns = 10000
+
+// sseKeepalive is how often idle event streams send a comment to stay open
+const sseKeepalive = 15 * time.Second
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// deviceID normalizes the {id} path value: MACs are stored lowercase, and a
+// device UUID resolves to the device holding it
+func (s *Server) deviceID(r *http.Request) string {
+	return s.monitor().ResolveDeviceID(strings.ToLower(r.PathValue("id")))
+}
+
+// getHealth always answers 200 while the process is serving; a degraded state
+// is reported in the body since capture keeps running
+func (s *Server) getHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.withWriter(s.monitor().Health()))
+}
+
+// getVersion describes the running build; admin_auth tells clients whether
+// admin endpoints are available
+func (s *Server) getVersion(w http.ResponseWriter, r *http.Request) {
+	features := map[string]string{"admin_auth": "off"}
+	if s.adminToken != "" {
+		features["admin_auth"] = "on"
+	}
+	for name, value := range s.features {
+		features[name] = value
+	}
+	writeJSON(w, http.StatusOK, version.Info(features))
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	stats := s.monitor().StatsReport()
+	if s.writer != nil {
+		stats.WriterOnly = writerOnlyStats
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hints := false
+	if include := r.URL.Query().Get("include"); include != "" {
+		for _, name := range strings.Split(include, ",") {
+			if name = strings.TrimSpace(name); name != "hints" {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid include %q: expected hints", name))
+				return
+			}
+			hints = true
+		}
+	}
+
+	var devices []*models.DeviceInfo
+
+	switch sort := r.URL.Query().Get("sort"); sort {
+	case "":
+		devices = s.monitor().ListDevices()
+	case "risk":
+		devices = s.monitor().DevicesByRisk()
+	case "health":
+		devices = s.monitor().ListDevices()
+		monitor.SortByHealth(devices)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported sort: "+sort)
+		return
+	}
+
+	if subnet := r.URL.Query().Get("subnet"); subnet != "" {
+		match, err := subnetFilter(subnet)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filtered := devices[:0]
+		for _, device := range devices {
+			if match(device) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if os := strings.ToLower(r.URL.Query().Get("os")); os != "" {
+		filtered := devices[:0]
+		for _, device := range devices {
+			if strings.Contains(strings.ToLower(monitor.DeviceOS(device)), os) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	// Any variant of a vendor name selects the devices of its brand
+	if vendor := r.URL.Query().Get("vendor"); vendor != "" {
+		vendor = s.monitor().CanonicalVendor(vendor)
+		filtered := devices[:0]
+		for _, device := range devices {
+			if strings.EqualFold(device.Vendor, vendor) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if deviceType := strings.ToLower(r.URL.Query().Get("type")); deviceType != "" {
+		filtered := devices[:0]
+		for _, device := range devices {
+			if strings.Contains(strings.ToLower(monitor.DeviceType(device)), deviceType) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if iface := r.URL.Query().Get("interface"); iface != "" {
+		if err := s.checkInterfaces(iface); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		filtered := devices[:0]
+		for _, device := range devices {
+			if monitor.SeenOnInterface(device, iface) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	// Only the port a device was last attributed to counts, not those it left
+	if circuit := r.URL.Query().Get("circuit"); circuit != "" {
+		filtered := devices[:0]
+		for _, device := range devices {
+			if current, ok := monitor.CurrentCircuit(device); ok && strings.EqualFold(current.CircuitID, circuit) {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if egress := strings.ToLower(r.URL.Query().Get("egress")); egress != "" {
+		if egress != models.EgressCompliant && egress != models.EgressNonCompliant {
+			writeError(w, http.StatusBadRequest, "invalid egress: expected compliant or non_compliant")
+			return
+		}
+		filtered := devices[:0]
+		for _, device := range devices {
+			if monitor.EgressStatus(device) == egress {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if health := strings.ToLower(r.URL.Query().Get("health")); health != "" {
+		states := make(map[string]bool)
+		for _, state := range strings.Split(health, ",") {
+			state = strings.TrimSpace(state)
+			if !monitor.ValidHealthState(state) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid health %q: expected %s", state, strings.Join(models.DeviceHealthStates, ", ")))
+				return
+			}
+			states[state] = true
+		}
+		filtered := devices[:0]
+		for _, device := range devices {
+			if states[monitor.HealthState(device)] {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	if r.URL.Query().Get("include_transient") == "false" {
+		filtered := devices[:0]
+		for _, device := range devices {
+			if !device.Transient {
+				filtered = append(filtered, device)
+			}
+		}
+		devices = filtered
+	}
+
+	views := make([]any, 0, len(devices))
+	for _, device := range devices {
+		if hints {
+			deviceHints := s.monitor().DeviceHints(device)
+			device.Hints = &deviceHints
+		}
+		view, err := s.deviceView(device, fields)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		views = append(views, view)
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// subnetFilter matches devices whose IP lies in a CIDR by containment, so
+// 192.168.2.0/24 doesn't match 192.168.20.5, or with monitor.OtherSubnet
+// devices outside every local subnet
+func subnetFilter(subnet string) (func(*models.DeviceInfo) bool, error) {
+	if subnet == monitor.OtherSubnet {
+		return func(device *models.DeviceInfo) bool {
+			return device.Subnet == monitor.OtherSubnet
+		}, nil
+	}
+
+	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q: expected a CIDR or %s", subnet, monitor.OtherSubnet)
+	}
+	return func(device *models.DeviceInfo) bool {
+		ip := net.ParseIP(device.IP)
+		return ip != nil && !ip.IsUnspecified() && cidr.Contains(ip)
+	}, nil
+}
+
+// summaryAttention bounds the devices needing attention in the summary
+const summaryAttention = 10
+
+// getSummary counts tracked devices by vendor, by guessed OS and by health
+// state, and lists the least healthy
+func (s *Server) getSummary(w http.ResponseWriter, r *http.Request) {
+	devices := s.monitor().ListDevices()
+	vendors := make(map[string]int)
+	systems := make(map[string]int)
+	health := make(map[string]int, len(models.DeviceHealthStates))
+	for _, state := range models.DeviceHealthStates {
+		health[state] = 0
+	}
+
+	for _, device := range devices {
+		vendors[device.Vendor]++
+		systems[monitor.DeviceOS(device)]++
+		health[monitor.HealthState(device)]++
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"total_devices": len(devices),
+		"vendors":       vendors,
+		"os":            systems,
+		"health":        health,
+		"attention":     monitor.DevicesNeedingAttention(devices, summaryAttention),
+		"churn":         s.monitor().LatestChurn(),
+	})
+}
+
+// maxForgottenDevices bounds the summaries returned by one request
+const maxForgottenDevices = 1000
+
+// listForgottenDevices returns the summaries of forgotten transient devices,
+// most recently forgotten first
+func (s *Server) listForgottenDevices(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxForgottenDevices {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: expected 1 to %d", maxForgottenDevices))
+			return
+		}
+	}
+
+	summaries, err := s.monitor().ForgottenDevices(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, ok := s.monitor().GetDevice(s.deviceID(r))
+	if !ok {
+		if _, err := s.monitor().DeviceByUUID(r.PathValue("id")); errors.Is(err, monitor.ErrDeviceForgotten) {
+			writeError(w, http.StatusGone, err.Error())
+			return
+		}
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	device.Mute = s.monitor().DeviceMute(device.ID)
+
+	view, err := s.deviceView(device, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// getDeviceByUUID serves /devices/by-id/{uuid}: the device a UUID refers to,
+// including a former UUID of a device that absorbed another. Unlike
+// /devices/{id} it never takes a MAC, so integrations keyed on UUIDs can't
+// hit another device.
+func (s *Server) getDeviceByUUID(w http.ResponseWriter, r *http.Request) {
+	fields, err := parseFields(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, err := s.monitor().DeviceByUUID(r.PathValue("uuid"))
+	if errors.Is(err, monitor.ErrDeviceForgotten) {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	device.Mute = s.monitor().DeviceMute(device.ID)
+
+	view, err := s.deviceView(device, fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+func (s *Server) getDeviceScore(w http.ResponseWriter, r *http.Request) {
+	score, ok := s.monitor().DeviceRiskScore(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, score)
+}
+
+// getDeviceHints suggests an icon, a color and a label to present a device with
+func (s *Server) getDeviceHints(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.monitor().GetDevice(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.monitor().DeviceHints(device))
+}
+
+// getDeviceFirsts returns the first external contact of a device, its first
+// use of each protocol and its first contact with each country and AS
+func (s *Server) getDeviceFirsts(w http.ResponseWriter, r *http.Request) {
+	firsts, ok := s.monitor().DeviceFirsts(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, firsts)
+}
+
+func (s *Server) getTopology(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().Topology().Summary())
+}
+
+func (s *Server) getRecommendedInterfaces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().Topology().InterfaceRecommendations())
+}
+
+func (s *Server) listInterfaces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.interfaces.List())
+}
+
+// streamInterfaces sends the new state of an interface as a server-sent event
+// whenever it is attached, detached, degraded or recovers. Event counters
+// change too often to be streamed; they are read from /api/v1/interfaces.
+func (s *Server) streamInterfaces(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	updates, unsubscribe := s.interfaces.Subscribe()
+	defer unsubscribe()
+
+	client := s.openStream(w, r, flusher, streamInterfaces, r.URL.Query())
+	defer s.closeStream(client)
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	drops := time.NewTicker(streamDropInterval)
+	defer drops.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-drops.C:
+			client.reportDropped(w, flusher)
+		case status, ok := <-updates:
+			if !ok {
+				return
+			}
+			client.send(w, flusher, "interface", "", status)
+		}
+	}
+}
+
+func (s *Server) getUplink(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().UplinkHealth())
+}
+
+func (s *Server) getDeviceActivity(w http.ResponseWriter, r *http.Request) {
+	activity, ok := s.monitor().DeviceActivity(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, activity)
+}
+
+// getDeviceServices returns the services of a device by how they were named
+func (s *Server) getDeviceServices(w http.ResponseWriter, r *http.Request) {
+	services, ok := s.monitor().DeviceServices(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+// getDevicePortBehavior returns the source port statistics of a device
+func (s *Server) getDevicePortBehavior(w http.ResponseWriter, r *http.Request) {
+	behavior, ok := s.monitor().DevicePortBehavior(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, behavior)
+}
+
+func (s *Server) getUnknownPorts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().UnknownPorts())
+}
+
+// getDevicePorts returns the traffic of a device per destination port. window
+// is a duration up to monitor.PortHistory, the detection window by default.
+func (s *Server) getDevicePorts(w http.ResponseWriter, r *http.Request) {
+	var window time.Duration
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		if window, err = time.ParseDuration(v); err != nil || window <= 0 || window > monitor.PortHistory {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid window: expected a duration up to %s", monitor.PortHistory))
+			return
+		}
+	}
+
+	ports, ok := s.monitor().DevicePorts(s.deviceID(r), window)
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, ports)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	results, err := s.monitor().Search(r.URL.Query().Get("q"), limit)
+	if errors.Is(err, monitor.ErrSearchQueryTooShort) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// listTLSFingerprints returns JA3 fingerprints, most frequent first, or with
+// ?sort=rare those seen on the fewest devices first
+func (s *Server) listTLSFingerprints(w http.ResponseWriter, r *http.Request) {
+	fingerprints := s.monitor().TLSFingerprints()
+
+	switch order := r.URL.Query().Get("sort"); order {
+	case "":
+	case "rare":
+		sort.SliceStable(fingerprints, func(i, j int) bool {
+			return fingerprints[i].Devices < fingerprints[j].Devices
+		})
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported sort: "+order)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fingerprints)
+}
+
+// listThreatLists returns the loaded threat lists with their match counters
+func (s *Server) listThreatLists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().ThreatLists())
+}
+
+// getEgressSummary reports the egress policies and which devices comply
+func (s *Server) getEgressSummary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().EgressSummary())
+}
+
+// getAnomalyTemplates lists the templates anomaly descriptions are rendered
+// from, for clients rendering the type and params of anomalies themselves
+func (s *Server) getAnomalyTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().Messages().Templates())
+}
+
+// anomalyFilter selects anomalies by the device, type and severity query
+// parameters. Each accepts several comma-separated or repeated values; an
+// anomaly must match one value of every parameter given. acknowledged=true or
+// false also selects by triage state, and min_severity drops less severe ones.
+// filter=<name> also applies a saved filter, see withSavedFilter.
+type anomalyFilter struct {
+	devices      map[string]bool
+	types        map[string]bool
+	severities   map[string]bool
+	minSeverity  int
+	acknowledged *bool
+	saved        func(*models.Anomaly) bool
+}
+
+func parseAnomalyFilter(r *http.Request) anomalyFilter {
+	values := func(name string, normalize func(string) string) map[string]bool {
+		set := make(map[string]bool)
+		for _, param := range r.URL.Query()[name] {
+			for _, value := range strings.Split(param, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					set[normalize(value)] = true
+				}
+			}
+		}
+		return set
+	}
+	filter := anomalyFilter{
+		devices:    values("device", strings.ToLower),
+		types:      values("type", strings.ToUpper),
+		severities: values("severity", strings.ToUpper),
+	}
+	if v := r.URL.Query().Get("min_severity"); v != "" {
+		filter.minSeverity = max(0, models.SeverityRank(strings.ToUpper(v)))
+	}
+	if acknowledged, err := strconv.ParseBool(r.URL.Query().Get("acknowledged")); err == nil {
+		filter.acknowledged = &acknowledged
+	}
+	return filter
+}
+
+func (f anomalyFilter) match(anomaly *models.Anomaly) bool {
+	if f.acknowledged != nil && *f.acknowledged != (anomaly.Ack != nil) {
+		return false
+	}
+	if models.SeverityRank(anomaly.Severity) < f.minSeverity {
+		return false
+	}
+	if f.saved != nil && !f.saved(anomaly) {
+		return false
+	}
+	return (len(f.devices) == 0 || f.devices[anomaly.DeviceID]) &&
+		(len(f.types) == 0 || f.types[anomaly.Type]) &&
+		(len(f.severities) == 0 || f.severities[anomaly.Severity])
+}
+
+func (s *Server) listAnomalies(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.withSavedFilter(w, r, parseAnomalyFilter(r))
+	if !ok {
+		return
+	}
+
+	anomalies := []*models.Anomaly{}
+	for _, anomaly := range s.monitor().RecentAnomalies() {
+		if filter.match(anomaly) {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	writeJSON(w, http.StatusOK, anomalies)
+}
+
+func (s *Server) getAnomaly(w http.ResponseWriter, r *http.Request) {
+	anomaly, ok := s.monitor().FindAnomaly(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "anomaly not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, anomaly)
+}
+
+// streamAnomalies pushes anomalies to the client as server-sent events.
+// ?replay=N first sends the last N recent anomalies passing the filter. A
+// saved filter is resolved once, as the stream opens: its relative window
+// and tagged devices are those of that moment.
+func (s *Server) streamAnomalies(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	replay, err := parseReplay(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, ok := s.withSavedFilter(w, r, parseAnomalyFilter(r))
+	if !ok {
+		return
+	}
+
+	recent, anomalies, unsubscribe := s.eventSource().SubscribeAnomalies(replay > 0)
+	defer unsubscribe()
+
+	var replayed []*models.Anomaly
+	for _, anomaly := range recent {
+		if filter.match(anomaly) {
+			replayed = append(replayed, anomaly)
+		}
+	}
+	replayed = replayed[max(0, len(replayed)-replay):]
+
+	client := s.openStream(w, r, flusher, streamAnomalies, r.URL.Query())
+	defer s.closeStream(client)
+	for _, anomaly := range replayed {
+		writeEvent(w, "anomaly", anomaly.ID, anomaly)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	drops := time.NewTicker(streamDropInterval)
+	defer drops.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-drops.C:
+			client.reportDropped(w, flusher)
+		case anomaly, ok := <-anomalies:
+			if !ok {
+				return
+			}
+			if !filter.match(anomaly) {
+				continue
+			}
+			client.send(w, flusher, "anomaly", anomaly.ID, anomaly)
+		}
+	}
+}
+
+// listIPChanges returns the IP changes announced since startup, newest first.
+// device selects the changes of one device and limit caps them.
+func (s *Server) listIPChanges(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+	device := strings.ToLower(r.URL.Query().Get("device"))
+	writeJSON(w, http.StatusOK, s.monitor().IPChanges(device, limit))
+}
+
+// streamDeviceChanges sends changes to known devices as server-sent events.
+// device and field select changes like the anomaly filters; a change matches
+// field if any of its changed fields does.
+func (s *Server) streamDeviceChanges(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	values := func(name string) map[string]bool {
+		set := make(map[string]bool)
+		for _, param := range r.URL.Query()[name] {
+			for _, value := range strings.Split(param, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					set[value] = true
+				}
+			}
+		}
+		return set
+	}
+	devices, fields := values("device"), values("field")
+	match := func(update *models.DeviceUpdate) bool {
+		if len(devices) > 0 && !devices[update.DeviceID] {
+			return false
+		}
+		if len(fields) == 0 {
+			return true
+		}
+		for field := range update.Changes {
+			if fields[field] {
+				return true
+			}
+		}
+		return false
+	}
+
+	updates, unsubscribe := s.eventSource().SubscribeDeviceChanges()
+	defer unsubscribe()
+
+	client := s.openStream(w, r, flusher, streamDevices, r.URL.Query())
+	defer s.closeStream(client)
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	drops := time.NewTicker(streamDropInterval)
+	defer drops.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-drops.C:
+			client.reportDropped(w, flusher)
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if !match(update) {
+				continue
+			}
+			client.send(w, flusher, "device_change", update.ID, update)
+		}
+	}
+}
+
+func (s *Server) getResources(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().ResourceUsage())
+}
+
+// getDiff compares the persisted inventory between from and to (default now)
+func (s *Server) getDiff(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	from, err := time.Parse(time.RFC3339, params.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from: expected RFC 3339 time")
+		return
+	}
+	to := time.Now()
+	if v := params.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to: expected RFC 3339 time")
+			return
+		}
+	}
+	if !from.Before(to) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	limit := 1000
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxDiffPatterns {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: expected 1 to %d", maxDiffPatterns))
+			return
+		}
+	}
+
+	diff, err := s.monitor().Diff(r.Context(), from, to, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
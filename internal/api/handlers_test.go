@@ -0,0 +1,454 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// Devices seeded by seedMonitor. The OUIs are in the fallback vendor database.
+const (
+	appleMAC = "00:03:93:aa:00:01"
+	ciscoMAC = "00:01:42:bb:00:02"
+	otherMAC = "02:00:00:cc:00:03"
+)
+
+// seedMonitor feeds the monitor TCP traffic of three devices: an Apple one in
+// 192.168.2.0/24 reaching two web servers, a Cisco one in 192.168.20.0/24 and
+// a locally administered one in 10.0.0.0/8
+func seedMonitor(t testing.TB, mon *monitor.NetworkMonitor) {
+	t.Helper()
+	for _, e := range []struct {
+		mac, src, dst string
+		port          uint16
+	}{
+		{appleMAC, "192.168.2.5", "203.0.113.10", 443},
+		{appleMAC, "192.168.2.5", "203.0.113.11", 80},
+		{ciscoMAC, "192.168.20.5", "203.0.113.10", 443},
+		{otherMAC, "10.1.2.3", "198.51.100.7", 22},
+	} {
+		mon.TrackEvent(tcpEvent(t, e.mac, e.src, e.dst, e.port))
+	}
+}
+
+// tcpEvent returns an established TCP segment from a device to dst:port
+func tcpEvent(t testing.TB, mac, src, dst string, port uint16) *models.NetworkEvent {
+	t.Helper()
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatalf("invalid MAC %q", mac)
+	}
+	ip := func(s string) uint32 {
+		parsed := net.ParseIP(s).To4()
+		if parsed == nil {
+			t.Fatalf("invalid IPv4 address %q", s)
+		}
+		return binary.BigEndian.Uint32(parsed)
+	}
+	return &models.NetworkEvent{
+		EventType: models.EVENT_TYPE_TCP,
+		SrcMac:    [6]byte(hw),
+		DstMac:    [6]byte{0x02, 0, 0, 0, 0, 0x01},
+		SrcIP:     ip(src),
+		DstIP:     ip(dst),
+		SrcPort:   40000,
+		DstPort:   port,
+		Protocol:  6,
+		TCPFlags:  0x10,
+		IfIndex:   1,
+		IPTTL:     64,
+		PacketLen: 60,
+	}
+}
+
+// get serves a GET request through the handler and decodes the JSON response
+// into v, failing unless it answers want
+func get(t testing.TB, s *Server, target string, want int, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != want {
+		t.Fatalf("GET %s = %d %s, want %d", target, rec.Code, strings.TrimSpace(rec.Body.String()), want)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: decoding %q: %v", target, rec.Body.String(), err)
+		}
+	}
+}
+
+// deviceIDs lists the IDs of listed devices, sorted
+func deviceIDs(devices []models.DeviceInfo) []string {
+	ids := make([]string, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestListDevicesFilters(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{ciscoMAC, appleMAC, otherMAC}},
+		// Containment, not the string prefix "192.168.2" that 192.168.20.5 shares
+		{"?subnet=192.168.2.0/24", []string{appleMAC}},
+		{"?subnet=192.168.0.0/16", []string{ciscoMAC, appleMAC}},
+		{"?subnet=172.16.0.0/12", []string{}},
+		{"?vendor=Apple", []string{appleMAC}},
+		{"?vendor=apple%20inc.", []string{appleMAC}},
+		{"?vendor=Cisco%20Systems&subnet=192.168.20.0/24", []string{ciscoMAC}},
+		{"?include_transient=false", []string{ciscoMAC, appleMAC, otherMAC}},
+	}
+	for _, tt := range tests {
+		var devices []models.DeviceInfo
+		get(t, s, "/api/v1/devices"+tt.query, http.StatusOK, &devices)
+		if got := deviceIDs(devices); !slices.Equal(got, tt.want) {
+			t.Errorf("GET /api/v1/devices%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"?subnet=192.168.2", "?sort=name", "?fields=nope", "?include=all", "?health=bad", "?egress=maybe"} {
+		get(t, s, "/api/v1/devices"+query, http.StatusBadRequest, nil)
+	}
+}
+
+func TestListDevicesSortAndFields(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+
+	for _, sort := range []string{"risk", "health"} {
+		var devices []models.DeviceInfo
+		get(t, s, "/api/v1/devices?sort="+sort, http.StatusOK, &devices)
+		if len(devices) != 3 {
+			t.Errorf("sort=%s listed %d devices, want 3", sort, len(devices))
+		}
+	}
+
+	var views []map[string]any
+	get(t, s, "/api/v1/devices?fields=id,ip", http.StatusOK, &views)
+	if len(views) != 3 {
+		t.Fatalf("listed %d devices, want 3", len(views))
+	}
+	for _, view := range views {
+		if len(view) != 2 || view["id"] == nil || view["ip"] == nil {
+			t.Errorf("fields=id,ip returned %v", view)
+		}
+	}
+}
+
+func TestGetDevice(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+
+	// MACs are stored lowercase but may be requested in any case
+	for _, id := range []string{appleMAC, strings.ToUpper(appleMAC)} {
+		var device models.DeviceInfo
+		get(t, s, "/api/v1/devices/"+id, http.StatusOK, &device)
+		if device.ID != appleMAC || device.IP != "192.168.2.5" || device.Vendor != "Apple" {
+			t.Errorf("GET device %s = id %q ip %q vendor %q", id, device.ID, device.IP, device.Vendor)
+		}
+	}
+
+	get(t, s, "/api/v1/devices/02:00:00:00:00:99", http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/"+appleMAC+"?fields=bogus", http.StatusBadRequest, nil)
+}
+
+// Devices are looked up by UUID alone under /devices/by-id, which takes no MAC
+// and leaves the other two-segment device routes alone
+func TestGetDeviceByUUID(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+	apple, _ := mon.GetDevice(appleMAC)
+
+	for _, uuid := range []string{apple.UUID, strings.ToUpper(apple.UUID)} {
+		var device models.DeviceInfo
+		get(t, s, "/api/v1/devices/by-id/"+uuid, http.StatusOK, &device)
+		if device.ID != appleMAC || device.UUID != apple.UUID {
+			t.Errorf("GET by-id %s = id %q uuid %q", uuid, device.ID, device.UUID)
+		}
+	}
+	var device map[string]any
+	get(t, s, "/api/v1/devices/by-id/"+apple.UUID+"?fields=mac", http.StatusOK, &device)
+	if len(device) != 1 || device["mac"] != appleMAC {
+		t.Errorf("GET by-id with fields = %v", device)
+	}
+
+	get(t, s, "/api/v1/devices/by-id/"+appleMAC, http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/by-id/00000000-0000-4000-8000-000000000000", http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/"+appleMAC+"/"+apple.UUID, http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/by-id/"+apple.UUID+"/score", http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/by-id", http.StatusNotFound, nil)
+	get(t, s, "/api/v1/devices/by-id/"+apple.UUID+"?fields=bogus", http.StatusBadRequest, nil)
+	var score models.RiskScore
+	get(t, s, "/api/v1/devices/"+apple.UUID+"/score", http.StatusOK, &score)
+	get(t, s, "/api/v1/devices/"+appleMAC+"/score", http.StatusOK, &score)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/devices/by-id/"+apple.UUID, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE by-id = %d, want 405", rec.Code)
+	}
+}
+
+func TestSearchAndContacts(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+
+	var results models.SearchResults
+	get(t, s, "/api/v1/search?q=192.168.2.5", http.StatusOK, &results)
+	found := false
+	for _, group := range results.Groups {
+		for _, match := range group.Matches {
+			found = found || match.DeviceID == appleMAC
+		}
+	}
+	if !found {
+		t.Errorf("search for the Apple device's IP returned %+v", results.Groups)
+	}
+	get(t, s, "/api/v1/search?q=1", http.StatusBadRequest, nil)
+
+	var contacts models.ContactQuery
+	get(t, s, "/api/v1/query/destination/203.0.113.10", http.StatusOK, &contacts)
+	var ids []string
+	for _, contact := range contacts.Contacts {
+		ids = append(ids, contact.DeviceID)
+	}
+	slices.Sort(ids)
+	if want := []string{ciscoMAC, appleMAC}; !slices.Equal(ids, want) {
+		t.Errorf("devices reaching 203.0.113.10 = %v, want %v", ids, want)
+	}
+
+	get(t, s, "/api/v1/query/port/443?subdomains=true", http.StatusBadRequest, nil)
+	get(t, s, "/api/v1/query/destination/203.0.113.10?since=yesterday", http.StatusBadRequest, nil)
+}
+
+func TestVendorAliases(t *testing.T) {
+	s, _ := newTestServer(t)
+	var aliases struct {
+		BuiltIn    map[string]string `json:"built_in"`
+		Configured map[string]string `json:"configured"`
+	}
+	get(t, s, "/api/v1/lookup/vendor/aliases", http.StatusOK, &aliases)
+	if aliases.BuiltIn["apple"] != "Apple" || len(aliases.Configured) != 0 {
+		t.Errorf("vendor aliases = %+v, want the built-in apple alias and none configured", aliases)
+	}
+}
+
+// bulkExport reads a bulk export as the admin "secret", returning its records
+// and trailer
+func bulkExport(t *testing.T, s *Server, target string) (records []json.RawMessage, trailer bulkTrailer) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d %s", target, rec.Code, rec.Body.String())
+	}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := json.RawMessage(slices.Clone(scanner.Bytes()))
+		if strings.Contains(string(line), `"_trailer":true`) {
+			json.Unmarshal(line, &trailer)
+			continue
+		}
+		records = append(records, line)
+	}
+	return records, trailer
+}
+
+// Bulk exports page through the database with the trailer's continuation,
+// and every pattern carries its identifying fields
+func TestBulkPagination(t *testing.T) {
+	s, mon := newTestServer(t)
+	s.SetAdminToken("secret")
+	seedMonitor(t, mon)
+	if _, err := mon.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	var ids []string
+	after := ""
+	for page := 0; ; page++ {
+		if page > 3 {
+			t.Fatal("bulk device export did not complete")
+		}
+		records, trailer := bulkExport(t, s, "/api/v1/bulk/devices?limit=2&after="+after)
+		if len(records) > 2 || trailer.Count != len(records) {
+			t.Fatalf("page %d: %d records, trailer count %d, limit 2", page, len(records), trailer.Count)
+		}
+		for _, record := range records {
+			var device models.DeviceInfo
+			json.Unmarshal(record, &device)
+			ids = append(ids, device.ID)
+		}
+		if trailer.Complete {
+			break
+		}
+		after = trailer.Continuation
+	}
+	slices.Sort(ids)
+	if want := []string{ciscoMAC, appleMAC, otherMAC}; !slices.Equal(ids, want) {
+		t.Errorf("paged through devices %v, want %v", ids, want)
+	}
+
+	patterns, trailer := bulkExport(t, s, "/api/v1/bulk/patterns")
+	if len(patterns) != 4 || !trailer.Complete {
+		t.Fatalf("exported %d patterns (complete %v), want 4", len(patterns), trailer.Complete)
+	}
+	for _, record := range patterns {
+		var pattern models.CommunicationPattern
+		json.Unmarshal(record, &pattern)
+		if pattern.DeviceID == "" || pattern.SrcMAC == "" || pattern.SrcIP == "" || pattern.DstIP == "" ||
+			pattern.DstPort == 0 || pattern.Protocol == "" || pattern.Timestamp.IsZero() {
+			t.Errorf("pattern with empty fields: %s", record)
+		}
+	}
+}
+
+// Reading shards 0/4 to 3/4 returns every device and every pattern exactly
+// once, each pattern in the shard of its device
+func TestBulkShards(t *testing.T) {
+	s, mon := newTestServer(t)
+	s.SetAdminToken("secret")
+	seedMonitor(t, mon)
+	for i := range 16 {
+		mac := net.HardwareAddr{0x02, 0x00, 0x00, 0xdd, 0x00, byte(i)}.String()
+		src := net.IPv4(10, 2, 0, byte(i+1)).String()
+		mon.TrackEvent(tcpEvent(t, mac, src, "203.0.113.10", 443))
+		mon.TrackEvent(tcpEvent(t, mac, src, "198.51.100.7", 22))
+	}
+	if _, err := mon.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	patternKey := func(p models.CommunicationPattern) string {
+		return p.DeviceID + ">" + p.DstIP + ":" + strconv.Itoa(int(p.DstPort))
+	}
+	devices, _ := bulkExport(t, s, "/api/v1/bulk/devices")
+	patterns, _ := bulkExport(t, s, "/api/v1/bulk/patterns")
+	if len(devices) != 19 || len(patterns) != 36 {
+		t.Fatalf("unsharded export: %d devices and %d patterns, want 19 and 36", len(devices), len(patterns))
+	}
+
+	const shards = 4
+	deviceShard := make(map[string]int)
+	seenPatterns := make(map[string]int)
+	for shard := range shards {
+		query := fmt.Sprintf("?shard=%d/%d", shard, shards)
+		records, trailer := bulkExport(t, s, "/api/v1/bulk/devices"+query)
+		if trailer.Count != len(records) || !trailer.Complete {
+			t.Errorf("devices%s: %d records, trailer %+v", query, len(records), trailer)
+		}
+		for _, record := range records {
+			var device models.DeviceInfo
+			json.Unmarshal(record, &device)
+			if previous, ok := deviceShard[device.ID]; ok {
+				t.Errorf("device %s in shards %d and %d", device.ID, previous, shard)
+			}
+			deviceShard[device.ID] = shard
+		}
+
+		records, _ = bulkExport(t, s, "/api/v1/bulk/patterns"+query)
+		for _, record := range records {
+			var pattern models.CommunicationPattern
+			json.Unmarshal(record, &pattern)
+			seenPatterns[patternKey(pattern)]++
+			if want := monitor.ShardOf(pattern.DeviceID, shards); want != shard {
+				t.Errorf("pattern %s in shard %d, its device in %d", patternKey(pattern), shard, want)
+			}
+		}
+	}
+
+	for _, record := range devices {
+		var device models.DeviceInfo
+		json.Unmarshal(record, &device)
+		if _, ok := deviceShard[device.ID]; !ok {
+			t.Errorf("device %s in no shard", device.ID)
+		}
+	}
+	if len(deviceShard) != len(devices) {
+		t.Errorf("shards hold %d devices, want %d", len(deviceShard), len(devices))
+	}
+	for _, record := range patterns {
+		var pattern models.CommunicationPattern
+		json.Unmarshal(record, &pattern)
+		if n := seenPatterns[patternKey(pattern)]; n != 1 {
+			t.Errorf("pattern %s in %d shards, want 1", patternKey(pattern), n)
+		}
+	}
+	if len(seenPatterns) != len(patterns) {
+		t.Errorf("shards hold %d patterns, want %d", len(seenPatterns), len(patterns))
+	}
+
+	for _, shard := range []string{"4/4", "-1/4", "0/0", "1", "a/4"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/bulk/devices?shard="+shard, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("shard=%s: %d, want %d", shard, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// The subnet filter matches by CIDR containment, where the substring match of
+// the ip filter it replaced put 192.168.20.5 and 192.168.200.5 in "192.168.2"
+func TestSubnetFilter(t *testing.T) {
+	devices := []*models.DeviceInfo{
+		{ID: "a", IP: "192.168.2.5", Subnet: "192.168.2.0/24"},
+		{ID: "b", IP: "192.168.20.5", Subnet: "192.168.20.0/24"},
+		{ID: "c", IP: "192.168.200.5", Subnet: monitor.OtherSubnet},
+		{ID: "d", IP: "0.0.0.0"},
+		{ID: "e"},
+	}
+	tests := []struct {
+		subnet string
+		want   []string
+	}{
+		{"192.168.2.0/24", []string{"a"}},
+		{"192.168.2.77/24", []string{"a"}},
+		{"192.168.0.0/16", []string{"a", "b", "c"}},
+		{"192.168.20.0/23", []string{"b"}},
+		{"192.168.2.5/32", []string{"a"}},
+		{monitor.OtherSubnet, []string{"c"}},
+	}
+	for _, tt := range tests {
+		match, err := subnetFilter(tt.subnet)
+		if err != nil {
+			t.Fatalf("subnetFilter(%q): %v", tt.subnet, err)
+		}
+		var got []string
+		for _, device := range devices {
+			if match(device) {
+				got = append(got, device.ID)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("subnet %s matched %v, want %v", tt.subnet, got, tt.want)
+		}
+	}
+
+	for _, subnet := range []string{"192.168.2", "192.168.2.0", "192.168.2.0/33", "other"} {
+		if _, err := subnetFilter(subnet); err == nil {
+			t.Errorf("subnetFilter(%q) succeeded, want an error", subnet)
+		}
+	}
+}
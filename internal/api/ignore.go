@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// getIgnoreList returns the ignore list with the events each entry dropped
+func (s *Server) getIgnoreList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.monitor().IgnoreList()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// addIgnore adds a MAC or CIDR to the ignore list
+func (s *Server) addIgnore(w http.ResponseWriter, r *http.Request) {
+	var entry models.IgnoreEntry
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entry); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid ignore entry: "+err.Error())
+		return
+	}
+	entry, err := s.monitor().AddIgnore(entry)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, entry)
+}
+
+func (s *Server) deleteIgnore(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().DeleteIgnore(r.PathValue("id"))
+	if errors.Is(err, monitor.ErrIgnoreNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
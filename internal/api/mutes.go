@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// muteRequest is the optional body of POST /api/v1/devices/{id}/mute; without
+// an expiry the mute lasts until it is removed
+type muteRequest struct {
+	Comment   string     `json:"comment"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       string     `json:"ttl"` // Alternative to expires_at, e.g. "24h"
+}
+
+func (s *Server) listMutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().Mutes())
+}
+
+// muteDevice mutes a device given by MAC, device ID or IP
+func (s *Server) muteDevice(w http.ResponseWriter, r *http.Request) {
+	var req muteRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, "invalid mute: "+err.Error())
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.TTL != "" {
+		if expiresAt != nil {
+			writeError(w, http.StatusBadRequest, "set either expires_at or ttl, not both")
+			return
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl: expected a positive duration such as 24h")
+			return
+		}
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	mute, err := s.monitor().MuteDevice(s.monitor().ResolveDeviceID(r.PathValue("id")), req.Comment, expiresAt)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, mute)
+}
+
+func (s *Server) unmuteDevice(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().UnmuteDevice(s.monitor().ResolveDeviceID(r.PathValue("id")))
+	if errors.Is(err, monitor.ErrMuteNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/messages"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+//go:embed templates/device_report.html
+var reportFiles embed.FS
+
+var deviceReportTemplate = template.Must(template.ParseFS(reportFiles, "templates/device_report.html"))
+
+// reportTopK bounds each ranked list in a report, so chatty devices render as
+// fast as quiet ones
+const reportTopK = 10
+
+// reportMaxAnomalies bounds the anomalies listed in a report, newest first
+const reportMaxAnomalies = 20
+
+// reportMaxDecisions bounds the audited detector decisions listed in a
+// report, newest first
+const reportMaxDecisions = 10
+
+// Heatmap geometry in pixels
+const (
+	heatmapCell   = 16 // Cell pitch; cells are drawn 2px smaller
+	heatmapLabels = 30 // Width of the weekday label column
+)
+
+// reportKinds describes devices by their recognized type or, failing that,
+// their guessed operating system
+var reportKinds = map[string]struct{ kind, icon string }{
+	monitor.DeviceTypePrinter:   {"Printer", "\U0001F5A8"},
+	monitor.DeviceTypeCamera:    {"IP camera", "\U0001F4F9"},
+	monitor.DeviceTypeVoIPPhone: {"VoIP phone", "\u260E"},
+	monitor.OSWindows:           {"Windows computer", "\U0001F4BB"},
+	monitor.OSApple:             {"Apple computer, phone or tablet", "\U0001F4F1"},
+	monitor.OSLinux:             {"Linux computer or smart device", "\U0001F5A5"},
+	monitor.OSUnixLike:          {"Unix-like device", "\U0001F5A5"},
+	monitor.OSNetworkDevice:     {"Network equipment", "\U0001F4E1"},
+}
+
+type reportCount struct {
+	Name  string
+	Count int
+}
+
+// reportDestination is an external destination, located when a GeoIP
+// database is loaded
+type reportDestination struct {
+	IP string
+	models.GeoInfo
+}
+
+type reportAnomaly struct {
+	Severity    string
+	Plain       string
+	Description string
+	Time        time.Time
+}
+
+type heatmapCellView struct {
+	X, Y    int
+	Opacity string
+}
+
+type heatmapDay struct {
+	Label string
+	Y     int // Label baseline
+	Cells []heatmapCellView
+}
+
+type heatmapHour struct {
+	X     int
+	Label string
+}
+
+type heatmapView struct {
+	Width, Height, LabelY int
+	Days                  []heatmapDay
+	Hours                 []heatmapHour
+}
+
+type deviceReport struct {
+	Device       *models.DeviceInfo
+	Name         string
+	Kind         string
+	Icon         string
+	Generated    time.Time
+	Risk         *models.RiskScore
+	RiskLevel    string
+	Heatmap      *heatmapView
+	Domains      []reportCount
+	Services     []reportCount
+	Destinations []reportDestination
+	Countries    []reportCount // Countries of the recent external destinations
+	Anomalies    []reportAnomaly
+	Decisions    []models.Decision
+}
+
+// getDeviceReport renders a self-contained, plain-language report on a device
+func (s *Server) getDeviceReport(w http.ResponseWriter, r *http.Request) {
+	if format := r.URL.Query().Get("format"); format != "" && format != "html" {
+		writeError(w, http.StatusBadRequest, "invalid format: only html is supported")
+		return
+	}
+
+	id := s.deviceID(r)
+	device, ok := s.monitor().GetDevice(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	risk, _ := s.monitor().DeviceRiskScore(id)
+	activity, _ := s.monitor().DeviceActivity(id)
+
+	var anomalies []*models.Anomaly
+	for _, anomaly := range s.monitor().RecentAnomalies() {
+		if anomaly.DeviceID == id {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+
+	decisions := s.monitor().DeviceDecisions(id).Decisions
+
+	report := buildDeviceReport(device, risk, activity, anomalies, decisions, s.monitor().Messages(),
+		s.isExternal, s.monitor().GeoLookup, time.Now())
+
+	var body bytes.Buffer
+	if err := deviceReportTemplate.Execute(&body, report); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body.Bytes())
+}
+
+func (s *Server) isExternal(ip net.IP) bool {
+	return s.monitor().Topology().ClassifyIP(ip) == "EXTERNAL"
+}
+
+// buildDeviceReport gathers the report data; it only reads its arguments.
+// Anomalies are explained with the report templates of catalog, whose output
+// the HTML template escapes like any other text. decisions are those of a
+// decision audit, newest first. geo locates external destinations.
+func buildDeviceReport(device *models.DeviceInfo, risk *models.RiskScore, activity *models.DeviceActivity,
+	anomalies []*models.Anomaly, decisions []models.Decision, catalog *messages.Catalog,
+	external func(net.IP) bool, geo func(string) (models.GeoInfo, bool), now time.Time) *deviceReport {
+
+	report := &deviceReport{
+		Device:    device,
+		Name:      device.Vendor + " device",
+		Kind:      "Device",
+		Icon:      "\U0001F50C",
+		Generated: now,
+		Risk:      risk,
+		Domains:   topCounts(device.DNSDomains, reportTopK),
+		Services:  topCounts(device.Services.Totals(), reportTopK),
+	}
+	if device.Name != "" {
+		report.Name = device.Name
+	} else if device.Vendor == "" || device.Vendor == "Unknown" {
+		report.Name = "Device " + device.ID
+	}
+	if kind, ok := reportKinds[monitor.DeviceType(device)]; ok {
+		report.Kind, report.Icon = kind.kind, kind.icon
+	} else if device.OSGuess != nil {
+		if kind, ok := reportKinds[device.OSGuess.OS]; ok {
+			report.Kind, report.Icon = kind.kind, kind.icon
+		}
+	}
+
+	if risk != nil {
+		report.RiskLevel = monitor.RiskLevel(risk.Score)
+	}
+
+	// Targets holds only the most recent destinations, so every one of them
+	// counts towards the countries
+	targets := device.Targets.Values()
+	countries := make(map[string]int)
+	for i := len(targets) - 1; i >= 0; i-- {
+		if ip := net.ParseIP(targets[i]); ip == nil || !external(ip) {
+			continue
+		}
+		info, _ := geo(targets[i])
+		if info.Country != "" {
+			countries[info.Country]++
+		}
+		if len(report.Destinations) < reportTopK {
+			report.Destinations = append(report.Destinations, reportDestination{IP: targets[i], GeoInfo: info})
+		}
+	}
+	report.Countries = topCounts(countries, reportTopK)
+
+	for i := len(anomalies) - 1; i >= 0 && len(report.Anomalies) < reportMaxAnomalies; i-- {
+		anomaly := anomalies[i]
+		plain := anomaly.Description
+		if key := messages.ReportKey(anomaly.Type); catalog.Has(key) {
+			plain = catalog.Render(key, anomaly.Params)
+		}
+		report.Anomalies = append(report.Anomalies, reportAnomaly{
+			Severity:    anomaly.Severity,
+			Plain:       plain,
+			Description: anomaly.Description,
+			Time:        anomaly.Timestamp,
+		})
+	}
+
+	report.Decisions = decisions[:min(len(decisions), reportMaxDecisions)]
+
+	if activity != nil {
+		report.Heatmap = buildHeatmap(activity.Matrix)
+	}
+	return report
+}
+
+// topCounts returns the k largest counts, ties by name
+func topCounts(counts map[string]int, k int) []reportCount {
+	top := make([]reportCount, 0, len(counts))
+	for name, count := range counts {
+		top = append(top, reportCount{name, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > k {
+		top = top[:k]
+	}
+	return top
+}
+
+// buildHeatmap lays out a weekday x hour activity matrix as SVG cells whose
+// opacity is relative to the busiest slot
+func buildHeatmap(matrix [7][24]float64) *heatmapView {
+	var busiest float64
+	for _, day := range matrix {
+		for _, count := range day {
+			busiest = max(busiest, count)
+		}
+	}
+
+	view := &heatmapView{
+		Width:  heatmapLabels + 24*heatmapCell,
+		Height: 8*heatmapCell + 4,
+		LabelY: 7*heatmapCell + 12,
+	}
+	for weekday, day := range matrix {
+		row := heatmapDay{
+			Label: time.Weekday(weekday).String()[:3],
+			Y:     weekday*heatmapCell + heatmapCell/2 - 1,
+		}
+		for hour, count := range day {
+			opacity := 0.0
+			if busiest > 0 && count > 0 {
+				opacity = max(count/busiest, 0.05)
+			}
+			row.Cells = append(row.Cells, heatmapCellView{
+				X:       heatmapLabels + hour*heatmapCell,
+				Y:       weekday * heatmapCell,
+				Opacity: fmt.Sprintf("%.2f", opacity),
+			})
+		}
+		view.Days = append(view.Days, row)
+	}
+	for hour := 0; hour < 24; hour += 3 {
+		view.Hours = append(view.Hours, heatmapHour{
+			X:     heatmapLabels + hour*heatmapCell + heatmapCell/2 - 1,
+			Label: fmt.Sprintf("%02d", hour),
+		})
+	}
+	return view
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/messages"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// reportGeoIP locates the fixture destinations: 203.0.113.0/24 and
+// 198.51.100.0/24 in two countries, 192.0.2.0/24 unknown
+const reportGeoIP = "203.0.113.0\t203.0.113.255\t64500\tUS\tEXAMPLE-NET\n" +
+	"198.51.100.0\t198.51.100.255\t64501\tDE\tBEISPIEL-AS <Ops & Co>\n"
+
+// reportFixture returns the arguments of buildDeviceReport for a laptop with
+// some traffic, an anomaly whose parameters need escaping and an audit
+func reportFixture(t *testing.T) (*models.DeviceInfo, *models.RiskScore, *models.DeviceActivity, []*models.Anomaly, []models.Decision) {
+	t.Helper()
+	seen := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	device := &models.DeviceInfo{
+		ID:             "00:03:93:aa:00:01",
+		MAC:            "00:03:93:aa:00:01",
+		IP:             "192.168.2.5",
+		Subnet:         "192.168.2.0/24",
+		Vendor:         "Apple",
+		Name:           "Sam's <laptop>",
+		FirstSeen:      seen,
+		LastSeen:       seen.Add(50 * time.Hour),
+		DNSQueries:     42,
+		TLSConnections: 17,
+		HTTPRequests:   3,
+		TCPConnections: 25,
+		UDPConnections: 44,
+		OSGuess:        &models.OSGuess{OS: monitor.OSApple, Confidence: "high"},
+		DNSDomains:     map[string]int{"example.com": 30, "updates.example.net": 10, "x.example.org": 2},
+		Services: models.ServiceCounts{
+			L7:   map[string]int{"DNS": 42, "TLS": 17},
+			Port: map[string]int{"HTTPS": 20},
+		},
+	}
+	for _, target := range []string{"203.0.113.10", "192.168.2.1", "198.51.100.7", "192.0.2.99", "203.0.113.11"} {
+		device.Targets.Add(target)
+	}
+
+	activity := &models.DeviceActivity{ID: device.ID}
+	activity.Matrix[1][9] = 40
+	activity.Matrix[1][10] = 10
+	activity.Matrix[3][21] = 1
+
+	anomalies := []*models.Anomaly{{
+		Type:        "THREAT_INTEL_MATCH",
+		Severity:    "HIGH",
+		DeviceID:    device.ID,
+		Description: `Contacted <script>alert("x")</script> on list drop`,
+		Timestamp:   seen.Add(26 * time.Hour),
+	}}
+
+	decisions := []models.Decision{{
+		DstIP:     "203.0.113.10",
+		DstPort:   443,
+		Protocol:  "TCP",
+		L7Info:    "example.com",
+		Timestamp: seen.Add(27 * time.Hour),
+		Detectors: []models.DetectorDecision{{Detector: "novel_destination", Outcome: "passed", Reason: "seen before"}},
+	}}
+
+	risk := &models.RiskScore{ID: device.ID, Score: 35}
+	return device, risk, activity, anomalies, decisions
+}
+
+// The HTML report of a fixture device matches testdata/device_report.golden;
+// go test -run TestDeviceReportGolden -update rewrites it
+func TestDeviceReportGolden(t *testing.T) {
+	geoIP, err := databases.ParseGeoIPDatabase(strings.NewReader(reportGeoIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := func(ip string) (models.GeoInfo, bool) { return geoIP.Lookup(net.ParseIP(ip)) }
+	external := func(ip net.IP) bool { return !ip.IsPrivate() }
+
+	device, risk, activity, anomalies, decisions := reportFixture(t)
+	generated := time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC)
+	report := buildDeviceReport(device, risk, activity, anomalies, decisions, messages.Default(), external, geo, generated)
+
+	var got bytes.Buffer
+	if err := deviceReportTemplate.Execute(&got, report); err != nil {
+		t.Fatalf("rendering: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "device_report.golden")
+	if *update {
+		if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("report differs from %s (run with -update after checking the change):\n%s", golden, got.String())
+	}
+
+	// Whatever the golden file says, text from the network stays escaped
+	for _, raw := range []string{"<script>", "<laptop>", "<Ops & Co>"} {
+		if bytes.Contains(got.Bytes(), []byte(raw)) {
+			t.Errorf("report contains unescaped %q", raw)
+		}
+	}
+}
+
+func TestDeviceReportCountries(t *testing.T) {
+	geoIP, err := databases.ParseGeoIPDatabase(strings.NewReader(reportGeoIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	geo := func(ip string) (models.GeoInfo, bool) { return geoIP.Lookup(net.ParseIP(ip)) }
+	external := func(ip net.IP) bool { return !ip.IsPrivate() }
+
+	device, _, _, _, _ := reportFixture(t)
+	report := buildDeviceReport(device, nil, nil, nil, nil, messages.Default(), external, geo, time.Now())
+
+	// Most recent first, private addresses left out
+	var ips []string
+	for _, destination := range report.Destinations {
+		ips = append(ips, destination.IP)
+	}
+	if got, want := strings.Join(ips, " "), "203.0.113.11 192.0.2.99 198.51.100.7 203.0.113.10"; got != want {
+		t.Errorf("destinations %s, want %s", got, want)
+	}
+	if d := report.Destinations[0]; d.Country != "US" || d.ASN != 64500 || d.ASOrg != "EXAMPLE-NET" {
+		t.Errorf("destination %+v not located", d)
+	}
+	if d := report.Destinations[1]; d.Country != "" || d.ASN != 0 {
+		t.Errorf("destination %+v located outside the database", d)
+	}
+	if len(report.Countries) != 2 || report.Countries[0] != (reportCount{"US", 2}) || report.Countries[1] != (reportCount{"DE", 1}) {
+		t.Errorf("countries %+v, want US 2 and DE 1", report.Countries)
+	}
+
+	// Without a GeoIP database every destination is listed, no country
+	none := func(string) (models.GeoInfo, bool) { return models.GeoInfo{}, false }
+	report = buildDeviceReport(device, nil, nil, nil, nil, messages.Default(), external, none, time.Now())
+	if len(report.Destinations) != 4 || report.Countries == nil || len(report.Countries) != 0 {
+		t.Errorf("without GeoIP: %d destinations, countries %v", len(report.Destinations), report.Countries)
+	}
+}
+
+// Parameters substituted into a report explanation are escaped like any
+// other text of the report, whatever the template
+func TestDeviceReportEscapesParams(t *testing.T) {
+	catalog, err := messages.Override("test", map[string]string{
+		messages.ReportKey("SUSPICIOUS_DOMAINS"): "It looked up {domain} ({heuristics})",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, _, _, _, _ := reportFixture(t)
+	anomalies := []*models.Anomaly{{
+		Type:     "SUSPICIOUS_DOMAINS",
+		Severity: "MEDIUM",
+		DeviceID: device.ID,
+		Params: map[string]string{
+			"domain":     `<script>alert("x")</script>.example`,
+			"heuristics": `entropy & "length" <img src=x onerror=alert(1)>`,
+		},
+	}}
+	external := func(ip net.IP) bool { return !ip.IsPrivate() }
+	none := func(string) (models.GeoInfo, bool) { return models.GeoInfo{}, false }
+	report := buildDeviceReport(device, nil, nil, anomalies, nil, catalog, external, none, time.Now())
+	if len(report.Anomalies) != 1 || !strings.HasPrefix(report.Anomalies[0].Plain, "It looked up <script>") {
+		t.Fatalf("anomalies %+v, want the overridden explanation", report.Anomalies)
+	}
+
+	var got bytes.Buffer
+	if err := deviceReportTemplate.Execute(&got, report); err != nil {
+		t.Fatalf("rendering: %v", err)
+	}
+	for _, raw := range []string{"<script>", "<img", `"length"`, "entropy & "} {
+		if bytes.Contains(got.Bytes(), []byte(raw)) {
+			t.Errorf("report contains unescaped %q", raw)
+		}
+	}
+	if want := "It looked up &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;.example"; !bytes.Contains(got.Bytes(), []byte(want)) {
+		t.Errorf("report lacks the escaped explanation %q", want)
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// EventSource feeds the streaming endpoints. The monitor is one; an api-only
+// process streams the events the capturing process relays to it instead.
+type EventSource interface {
+	SubscribePatterns(match func(*models.CommunicationPattern) bool, history bool) ([]*models.CommunicationPattern, <-chan *models.CommunicationPattern, func())
+	SubscribeAnomalies(history bool) ([]*models.Anomaly, <-chan *models.Anomaly, func())
+	SubscribeDeviceChanges() (<-chan *models.DeviceUpdate, func())
+}
+
+// Server exposes the monitor state over a JSON HTTP API
+type Server struct {
+	mon        atomic.Pointer[monitor.NetworkMonitor]
+	events     EventSource                 // Nil streams from the monitor
+	writer     func() *models.WriterStatus // Set in api-only processes
+	interfaces *ifaces.Registry
+	mux        *http.ServeMux
+	root       *http.ServeMux // Mounts mux, and the routes that can't share it
+	server     *http.Server
+	done       chan struct{} // Closed on shutdown to end streaming responses
+	streams    streamRegistry
+	streamRate int // Events per second per streaming client, 0 for unlimited
+	logger     *log.Logger
+
+	adminToken string            // Bearer token for admin endpoints; empty disables them
+	features   map[string]string // Runtime settings reported by /api/v1/version
+}
+
+// NewServer creates an API server backed by the given monitor
+func NewServer(mon *monitor.NetworkMonitor) *Server {
+	s := &Server{
+		interfaces: mon.Interfaces(),
+		mux:        http.NewServeMux(),
+		root:       http.NewServeMux(),
+		done:       make(chan struct{}),
+		streams:    streamRegistry{clients: make(map[string]*streamClient)},
+		streamRate: DefaultStreamRate,
+		logger:     log.New(os.Stdout, "", 0),
+	}
+	s.mon.Store(mon)
+	s.routes()
+	return s
+}
+
+// monitor returns the monitor requests are served from
+func (s *Server) monitor() *monitor.NetworkMonitor {
+	return s.mon.Load()
+}
+
+// SetMonitor replaces the monitor requests are served from, such as with a
+// fresh copy of the database an api-only process serves. Requests already
+// running finish with the previous one. It must share the interface
+// registry of the first.
+func (s *Server) SetMonitor(mon *monitor.NetworkMonitor) {
+	s.mon.Store(mon)
+}
+
+// eventSource returns where the streaming endpoints read events from
+func (s *Server) eventSource() EventSource {
+	if s.events != nil {
+		return s.events
+	}
+	return s.monitor()
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("GET /health", s.getHealth)
+	s.mux.HandleFunc("GET /api/v1/version", s.getVersion)
+	s.mux.HandleFunc("GET /api/v1/stats", s.getStats)
+	s.mux.HandleFunc("GET /api/v1/stats/churn", s.getChurn)
+	s.mux.HandleFunc("GET /api/v1/stats/unknown-ports", s.getUnknownPorts)
+	s.mux.HandleFunc("GET /api/v1/devices", s.listDevices)
+	s.mux.HandleFunc("GET /api/v1/devices/forgotten", s.listForgottenDevices)
+	s.mux.HandleFunc("GET /api/v1/devices/stream", s.streamDeviceChanges)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}", s.getDevice)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/score", s.getDeviceScore)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/hints", s.getDeviceHints)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/firsts", s.getDeviceFirsts)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/activity", s.getDeviceActivity)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/ports", s.getDevicePorts)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/services", s.getDeviceServices)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/port-behavior", s.getDevicePortBehavior)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/report", s.getDeviceReport)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/availability", s.getDeviceAvailability)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/snapshots", s.getDeviceSnapshots)
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/asof", s.getDeviceAsOf)
+	s.mux.HandleFunc("POST /api/v1/devices/{id}/mute", s.requireAdmin(s.muteDevice))
+	s.mux.HandleFunc("DELETE /api/v1/devices/{id}/mute", s.requireAdmin(s.unmuteDevice))
+	s.mux.HandleFunc("GET /api/v1/devices/{id}/decisions", s.getDeviceDecisions)
+	s.mux.HandleFunc("PUT /api/v1/devices/{id}/decisions/audit", s.requireAdmin(s.startDeviceAudit))
+	s.mux.HandleFunc("DELETE /api/v1/devices/{id}/decisions/audit", s.requireAdmin(s.stopDeviceAudit))
+	s.mux.HandleFunc("GET /api/v1/mutes", s.listMutes)
+	s.mux.HandleFunc("GET /api/v1/decisions/audit", s.getDecisionAudit)
+	s.mux.HandleFunc("PUT /api/v1/decisions/audit", s.requireAdmin(s.startNetworkAudit))
+	s.mux.HandleFunc("DELETE /api/v1/decisions/audit", s.requireAdmin(s.stopNetworkAudit))
+	s.mux.HandleFunc("GET /api/v1/expectations", s.listExpectations)
+	s.mux.HandleFunc("POST /api/v1/expectations", s.requireAdmin(s.createExpectation))
+	s.mux.HandleFunc("DELETE /api/v1/expectations/{id}", s.requireAdmin(s.cancelExpectation))
+	s.mux.HandleFunc("GET /api/v1/changes/ip", s.listIPChanges)
+	s.mux.HandleFunc("GET /api/v1/summary", s.getSummary)
+	s.mux.HandleFunc("GET /api/v1/groups/stats", s.getGroupStats)
+	s.mux.HandleFunc("GET /api/v1/diff", s.getDiff)
+	s.mux.HandleFunc("GET /api/v1/subnets/{path...}", s.getSubnet)
+	s.mux.HandleFunc("GET /api/v1/topology", s.getTopology)
+	s.mux.HandleFunc("GET /api/v1/topology/recommended-interfaces", s.getRecommendedInterfaces)
+	s.mux.HandleFunc("GET /api/v1/interfaces", s.listInterfaces)
+	s.mux.HandleFunc("GET /api/v1/interfaces/stream", s.streamInterfaces)
+	s.mux.HandleFunc("GET /api/v1/interfaces/{name}", s.getInterface)
+	s.mux.HandleFunc("PUT /api/v1/interfaces/{name}/capture", s.requireAdmin(s.putInterfaceCapture))
+	s.mux.HandleFunc("DELETE /api/v1/interfaces/{name}/capture", s.requireAdmin(s.deleteInterfaceCapture))
+	s.mux.HandleFunc("GET /api/v1/uplink", s.getUplink)
+	s.mux.HandleFunc("GET /api/v1/availability", s.listAvailability)
+	s.mux.HandleFunc("GET /api/v1/capture/config", s.getCaptureConfig)
+	s.mux.HandleFunc("PUT /api/v1/capture/config", s.requireAdmin(s.putCaptureConfig))
+	s.mux.HandleFunc("GET /api/v1/ignore", s.getIgnoreList)
+	s.mux.HandleFunc("POST /api/v1/ignore", s.requireAdmin(s.addIgnore))
+	s.mux.HandleFunc("DELETE /api/v1/ignore/{id}", s.requireAdmin(s.deleteIgnore))
+	s.mux.HandleFunc("GET /api/v1/search", s.search)
+	s.mux.HandleFunc("GET /api/v1/query/destination/{ip}", s.queryContacts(monitor.ContactDestination, "ip"))
+	s.mux.HandleFunc("GET /api/v1/query/domain/{name}", s.queryContacts(monitor.ContactDomain, "name"))
+	s.mux.HandleFunc("GET /api/v1/query/port/{port}", s.queryContacts(monitor.ContactPort, "port"))
+	s.mux.HandleFunc("GET /api/v1/dns/allowlist", s.getDomainAllowlist)
+	s.mux.HandleFunc("PUT /api/v1/dns/allowlist", s.requireAdmin(s.putDomainAllowlist))
+	s.mux.HandleFunc("GET /api/v1/lookup/vendor/aliases", s.getVendorAliases)
+	s.mux.HandleFunc("PUT /api/v1/lookup/vendor/aliases", s.requireAdmin(s.putVendorAliases))
+	s.mux.HandleFunc("GET /api/v1/tls/fingerprints", s.listTLSFingerprints)
+	s.mux.HandleFunc("GET /api/v1/threats/lists", s.listThreatLists)
+	s.mux.HandleFunc("GET /api/v1/egress", s.getEgressSummary)
+	s.mux.HandleFunc("GET /api/v1/anomalies", s.listAnomalies)
+	s.mux.HandleFunc("GET /api/v1/anomalies/stream", s.streamAnomalies)
+	s.mux.HandleFunc("GET /api/v1/anomalies/history", s.getAnomalyHistory)
+	s.mux.HandleFunc("GET /api/v1/anomalies/templates", s.getAnomalyTemplates)
+	s.mux.HandleFunc("GET /api/v1/anomalies/{id}", s.getAnomaly)
+	s.mux.HandleFunc("POST /api/v1/anomalies/{id}/ack", s.requireAdmin(s.ackAnomaly))
+	s.mux.HandleFunc("POST /api/v1/anomalies/bulk", s.requireAdmin(s.bulkAnomalies))
+	s.mux.HandleFunc("GET /api/v1/anomalies/filters", s.listAnomalyFilters)
+	s.mux.HandleFunc("POST /api/v1/anomalies/filters", s.requireAdmin(s.createAnomalyFilter))
+	s.mux.HandleFunc("GET /api/v1/anomalies/filters/{name}", s.getAnomalyFilter)
+	s.mux.HandleFunc("PUT /api/v1/anomalies/filters/{name}", s.requireAdmin(s.updateAnomalyFilter))
+	s.mux.HandleFunc("DELETE /api/v1/anomalies/filters/{name}", s.requireAdmin(s.deleteAnomalyFilter))
+	s.mux.HandleFunc("GET /api/v1/debug/resources", s.getResources)
+	s.mux.HandleFunc("GET /api/v1/debug/streams", s.getStreams)
+	s.mux.HandleFunc("GET /api/v1/patterns/stream", s.streamPatterns)
+	s.mux.HandleFunc("PUT /api/v1/streams/{id}/filter", s.putStreamFilter)
+	s.mux.HandleFunc("POST /api/v1/admin/flush", s.requireAdmin(s.postFlush))
+	s.mux.HandleFunc("GET /api/v1/admin/backup", s.requireAdmin(s.getBackup))
+	s.mux.HandleFunc("POST /api/v1/admin/maintenance", s.requireAdmin(s.postMaintenance))
+	s.mux.HandleFunc("GET /api/v1/admin/maintenance", s.requireAdmin(s.getMaintenance))
+	s.mux.HandleFunc("POST /api/v1/admin/vendors/reindex", s.requireAdmin(s.postVendorReindex))
+	s.mux.HandleFunc("GET /api/v1/bulk/devices", s.requireAdmin(s.bulkDevices()))
+	s.mux.HandleFunc("GET /api/v1/bulk/devices/sync", s.requireAdmin(s.syncDevices))
+	s.mux.HandleFunc("GET /api/v1/bulk/patterns", s.requireAdmin(s.bulkPatterns()))
+	s.mux.HandleFunc("GET /api/v1/suppressions", s.listSuppressions)
+	s.mux.HandleFunc("POST /api/v1/suppressions", s.requireAdmin(s.createSuppression))
+	s.mux.HandleFunc("DELETE /api/v1/suppressions/{id}", s.requireAdmin(s.deleteSuppression))
+	s.mux.HandleFunc("GET /api/v1/alert-routes", s.listAlertRoutes)
+	s.mux.HandleFunc("POST /api/v1/alert-routes", s.requireAdmin(s.createAlertRoute))
+	s.mux.HandleFunc("POST /api/v1/alert-routes/dry-run", s.dryRunAlertRoutes)
+	s.mux.HandleFunc("PUT /api/v1/alert-routes/{id}", s.requireAdmin(s.updateAlertRoute))
+	s.mux.HandleFunc("DELETE /api/v1/alert-routes/{id}", s.requireAdmin(s.deleteAlertRoute))
+
+	// by-id/{uuid} can't share mux with the {id}/... routes: both match
+	// by-id/score and neither is more specific, which ServeMux rejects. It
+	// gets a mux of its own, mounted in front of mux.
+	byUUID := http.NewServeMux()
+	byUUID.HandleFunc("GET /api/v1/devices/by-id/{uuid}", s.getDeviceByUUID)
+	s.root.Handle("/api/v1/devices/by-id/{uuid}", byUUID)
+	s.root.Handle("/", s.mux)
+}
+
+// SetAdminToken sets the bearer token required by admin endpoints
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// SetFeatures sets the runtime settings reported by /api/v1/version, such as
+// the capture backend
+func (s *Server) SetFeatures(features map[string]string) {
+	s.features = features
+}
+
+// SetLogger sets where the server reports its address and serving errors
+// (default: stdout). Must be called before Start.
+func (s *Server) SetLogger(logger *log.Logger) {
+	s.logger = logger
+}
+
+// SetEventSource makes the streaming endpoints read events from src instead
+// of the monitor. Must be called before Start.
+func (s *Server) SetEventSource(src EventSource) {
+	s.events = src
+}
+
+// SetWriterStatus marks the server as serving an api-only process, whose
+// health reports the capturing process it follows as status returns it.
+// Must be called before Start.
+func (s *Server) SetWriterStatus(status func() *models.WriterStatus) {
+	s.writer = status
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	return s.root
+}
+
+// ValidateListenAddr checks that addr is a host:port the API can listen on.
+// IPv6 hosts must be bracketed, e.g. [::1]:8080; an empty host or [::] listens
+// on every IPv4 and IPv6 address.
+func ValidateListenAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return fmt.Errorf("invalid address %q: put IPv6 addresses in brackets, e.g. [::1]:8080", addr)
+		}
+		return fmt.Errorf("invalid address %q: expected host:port, e.g. 127.0.0.1:8080 or [::1]:8080", addr)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q in %q", port, addr)
+	}
+	if strings.Contains(host, "%") {
+		// Link-local addresses name their zone, e.g. [fe80::1%eth0]:8080
+		host = host[:strings.Index(host, "%")]
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid IPv6 address %q in %q", host, addr)
+	}
+	return nil
+}
+
+// Start binds addr and serves the API in the background. Binding errors, such
+// as an address that is not configured on this host, are returned.
+func (s *Server) Start(addr string) error {
+	if err := ValidateListenAddr(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.root,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          s.logger,
+	}
+
+	// The listener address brackets IPv6 hosts and resolves port 0
+	s.logger.Printf("API listening on http://%s", listener.Addr())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Printf("API server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown gracefully stops the API server
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.done)
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"maps"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/version"
+)
+
+// newTestServer returns an API server over an in-memory monitor, both closed
+// when the test ends
+func newTestServer(t testing.TB) (*Server, *monitor.NetworkMonitor) {
+	t.Helper()
+	mon, err := monitor.NewNetworkMonitor(1000, ":memory:")
+	if err != nil {
+		t.Fatalf("NewNetworkMonitor: %v", err)
+	}
+	t.Cleanup(func() { mon.Close() })
+	s := NewServer(mon)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s, mon
+}
+
+func TestStartLogsThroughLogger(t *testing.T) {
+	s, _ := newTestServer(t)
+	var buf bytes.Buffer
+	s.SetLogger(log.New(&buf, "test: ", 0))
+
+	if err := s.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "test: API listening on http://127.0.0.1:") {
+		t.Errorf("logged %q, want the listening address through the logger", got)
+	}
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8080", ":8080", "[::1]:8080", "[::]:0", "[fe80::1%eth0]:8080"} {
+		if err := ValidateListenAddr(addr); err != nil {
+			t.Errorf("ValidateListenAddr(%q): %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"8080", "::1:8080", "127.0.0.1:http", "127.0.0.1:70000", "[fe80::zz]:8080"} {
+		if err := ValidateListenAddr(addr); err == nil {
+			t.Errorf("ValidateListenAddr(%q) succeeded, want an error", addr)
+		}
+	}
+}
+
+// The version endpoint reports the build information stamped at link time,
+// with defaults under plain go test, along with the runtime features; no API
+// documentation is served
+func TestGetVersion(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.SetFeatures(map[string]string{"capture_backend": "tc", "online_lookups": "off"})
+
+	var info models.BuildInfo
+	get(t, s, "/api/v1/version", http.StatusOK, &info)
+	if info.Version != "dev" || info.Commit == "" || info.BuildDate == "" {
+		t.Errorf("unstamped build = version %q, commit %q, date %q, want dev and non-empty ones", info.Version, info.Commit, info.BuildDate)
+	}
+	if info.GoVersion != runtime.Version() || info.EventLayout != models.EventLayoutVersion {
+		t.Errorf("Go %q, event layout %d, want %q and %d", info.GoVersion, info.EventLayout, runtime.Version(), models.EventLayoutVersion)
+	}
+	want := map[string]string{"admin_auth": "off", "capture_backend": "tc", "online_lookups": "off"}
+	if !maps.Equal(info.Features, want) {
+		t.Errorf("features = %v, want %v", info.Features, want)
+	}
+
+	// As make build stamps it
+	stamped, commit, date := version.Version, version.Commit, version.BuildDate
+	t.Cleanup(func() { version.Version, version.Commit, version.BuildDate = stamped, commit, date })
+	version.Version, version.Commit, version.BuildDate = "v1.2.0", "0123abcd", "2026-03-02T09:00:00Z"
+	s.SetAdminToken("secret")
+	get(t, s, "/api/v1/version", http.StatusOK, &info)
+	if info.Version != "v1.2.0" || info.Commit != "0123abcd" || info.BuildDate != "2026-03-02T09:00:00Z" {
+		t.Errorf("stamped build = version %q, commit %q, date %q", info.Version, info.Commit, info.BuildDate)
+	}
+	if info.Features["admin_auth"] != "on" {
+		t.Errorf("admin_auth = %q with a token, want on", info.Features["admin_auth"])
+	}
+
+	for _, path := range []string{"/swagger/", "/swagger/index.html", "/docs", "/api/v1/docs"} {
+		get(t, s, path, http.StatusNotFound, nil)
+	}
+}
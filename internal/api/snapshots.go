@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// getDeviceSnapshots returns the state snapshots of a device, oldest first
+func (s *Server) getDeviceSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, ok := s.monitor().DeviceSnapshots(s.deviceID(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, "no snapshots of device")
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// getDeviceAsOf returns the snapshot of a device nearest to t (RFC 3339) and
+// how the device changed since
+func (s *Server) getDeviceAsOf(w http.ResponseWriter, r *http.Request) {
+	at, err := time.Parse(time.RFC3339, r.URL.Query().Get("t"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid t: expected RFC 3339 time")
+		return
+	}
+	asOf, ok := s.monitor().DeviceAsOf(s.deviceID(r), at)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no snapshots of device")
+		return
+	}
+	writeJSON(w, http.StatusOK, asOf)
+}
@@ -0,0 +1,468 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// DefaultStreamRate is how many events per second each streaming client is
+// sent by default
+const DefaultStreamRate = 100
+
+// streamDropInterval is how often a client is told how many events its budget
+// dropped
+const streamDropInterval = time.Second
+
+// Stream kinds, as listed by /api/v1/debug/streams
+const (
+	streamAnomalies  = "anomalies"
+	streamDevices    = "devices"
+	streamInterfaces = "interfaces"
+	streamPatterns   = "patterns"
+)
+
+// Pattern directions, relative to the local networks
+const (
+	directionOutbound = "outbound" // From a local device to an external address
+	directionInbound  = "inbound"  // From an external address to a local one
+	directionInternal = "internal" // Between local addresses
+)
+
+// patternFilter selects the patterns sent to a stream. A list matches when
+// empty or when one of its values does; a pattern must match every field set.
+type patternFilter struct {
+	Devices      []string `json:"devices,omitempty"`
+	Protocols    []string `json:"protocols,omitempty"`
+	TrafficTypes []string `json:"traffic_types,omitempty"`
+	Interfaces   []string `json:"interfaces,omitempty"`
+	SrcIPs       []string `json:"src_ips,omitempty"` // Source IPs or CIDRs
+	DstIPs       []string `json:"dst_ips,omitempty"` // Destination IPs or CIDRs
+	Direction    string   `json:"direction,omitempty"`
+	External     bool     `json:"external,omitempty"` // Only patterns with an external end
+
+	srcNets, dstNets []*net.IPNet // SrcIPs and DstIPs, parsed by normalize
+}
+
+// parsePatternFilter reads a filter from the device, protocol, traffic_type,
+// interface, src_ip, dst_ip, direction and external query parameters. Lists
+// accept comma-separated or repeated values.
+func parsePatternFilter(params url.Values) (*patternFilter, error) {
+	values := func(name string) []string {
+		var list []string
+		for _, param := range params[name] {
+			for _, value := range strings.Split(param, ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					list = append(list, value)
+				}
+			}
+		}
+		return list
+	}
+	filter := &patternFilter{
+		Devices:      values("device"),
+		Protocols:    values("protocol"),
+		TrafficTypes: values("traffic_type"),
+		Interfaces:   values("interface"),
+		SrcIPs:       values("src_ip"),
+		DstIPs:       values("dst_ip"),
+		Direction:    params.Get("direction"),
+	}
+	if v := params.Get("external"); v != "" {
+		external, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external: expected true or false")
+		}
+		filter.External = external
+	}
+	return filter, filter.normalize()
+}
+
+// normalize lowercases device IDs and uppercases protocols and traffic
+// types, parses the addresses, and checks the traffic types and direction.
+// Interfaces are checked by Server.checkInterfaces.
+func (f *patternFilter) normalize() error {
+	var err error
+	if f.srcNets, err = parseAddressFilter("src_ip", f.SrcIPs); err != nil {
+		return err
+	}
+	if f.dstNets, err = parseAddressFilter("dst_ip", f.DstIPs); err != nil {
+		return err
+	}
+	for i, device := range f.Devices {
+		f.Devices[i] = strings.ToLower(device)
+	}
+	for i, protocol := range f.Protocols {
+		f.Protocols[i] = strings.ToUpper(protocol)
+	}
+	for i, trafficType := range f.TrafficTypes {
+		var err error
+		if f.TrafficTypes[i], err = parseTrafficType(trafficType); err != nil {
+			return err
+		}
+	}
+	switch f.Direction {
+	case "", directionOutbound, directionInbound, directionInternal:
+		return nil
+	}
+	return fmt.Errorf("invalid direction: expected %s, %s or %s", directionOutbound, directionInbound, directionInternal)
+}
+
+// parseAddressFilter parses the IPs and CIDRs of an address filter, an IP
+// standing for itself alone
+func parseAddressFilter(name string, addresses []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: expected an IP or CIDR", name, address)
+		}
+		nets = append(nets, cidr)
+	}
+	return nets, nil
+}
+
+// containsAddress reports whether addr is in one of nets, or nets is empty
+func containsAddress(nets []*net.IPNet, addr string) bool {
+	if len(nets) == 0 {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
+// match reports whether a pattern passes the filter; isExternal classifies
+// its addresses, and is only called when the filter needs it
+func (f *patternFilter) match(pattern *models.CommunicationPattern, isExternal func(string) bool) bool {
+	if len(f.Devices) > 0 && !slices.Contains(f.Devices, pattern.DeviceID) {
+		return false
+	}
+	if len(f.Protocols) > 0 && !slices.Contains(f.Protocols, pattern.Protocol) {
+		return false
+	}
+	if len(f.TrafficTypes) > 0 && !slices.Contains(f.TrafficTypes, string(pattern.TrafficType)) {
+		return false
+	}
+	if len(f.Interfaces) > 0 && !slices.Contains(f.Interfaces, pattern.Interface) {
+		return false
+	}
+	if !containsAddress(f.srcNets, pattern.SrcIP) || !containsAddress(f.dstNets, pattern.DstIP) {
+		return false
+	}
+	if f.Direction == "" && !f.External {
+		return true
+	}
+
+	srcExternal, dstExternal := isExternal(pattern.SrcIP), isExternal(pattern.DstIP)
+	if f.External && !srcExternal && !dstExternal {
+		return false
+	}
+	switch f.Direction {
+	case directionOutbound:
+		return !srcExternal && dstExternal
+	case directionInbound:
+		return srcExternal && !dstExternal
+	case directionInternal:
+		return !srcExternal && !dstExternal
+	}
+	return true
+}
+
+// streamClient is a connected event stream. Its budget lets through rate
+// events per second with bursts of as many; the rest are dropped before they
+// are serialized and reported in a "dropped" event.
+type streamClient struct {
+	id        string
+	kind      string
+	remote    string
+	connected time.Time
+	rate      int          // Events per second, 0 for unlimited
+	filter    atomic.Value // The active filter: *patternFilter for pattern streams, the query otherwise
+	sent      atomic.Uint64
+	dropped   atomic.Uint64
+
+	// Used by the streaming goroutine only
+	tokens     float64
+	refilled   time.Time
+	unreported uint64 // Dropped since the last "dropped" event
+}
+
+// allow spends one event of the budget, reporting whether there was one left
+func (c *streamClient) allow(now time.Time) bool {
+	if c.rate <= 0 {
+		return true
+	}
+	c.tokens = min(float64(c.rate), c.tokens+now.Sub(c.refilled).Seconds()*float64(c.rate))
+	c.refilled = now
+	if c.tokens < 1 {
+		c.dropped.Add(1)
+		c.unreported++
+		return false
+	}
+	c.tokens--
+	return true
+}
+
+// send writes an event unless the budget is spent. id may be empty.
+func (c *streamClient) send(w http.ResponseWriter, flusher http.Flusher, event, id string, v any) {
+	if !c.allow(time.Now()) {
+		return
+	}
+	if writeEvent(w, event, id, v) {
+		flusher.Flush()
+		c.sent.Add(1)
+	}
+}
+
+// writeEvent writes a server-sent event carrying v as JSON, reporting whether
+// it could be encoded. id may be empty.
+func writeEvent(w http.ResponseWriter, event, id string, v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return true
+}
+
+// parseReplay reads the replay query parameter: how many recent events a
+// stream sends before the live ones
+func parseReplay(params url.Values) (int, error) {
+	value := params.Get("replay")
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid replay: expected a non-negative integer")
+	}
+	return n, nil
+}
+
+// reportDropped tells the client how many events were dropped since the last
+// report, if any
+func (c *streamClient) reportDropped(w http.ResponseWriter, flusher http.Flusher) {
+	if c.unreported == 0 {
+		return
+	}
+	fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d,\"rate\":%d}\n\n", c.unreported, c.rate)
+	flusher.Flush()
+	c.unreported = 0
+}
+
+// streamRegistry tracks the connected event streams
+type streamRegistry struct {
+	mu      sync.Mutex
+	seq     uint64
+	clients map[string]*streamClient
+}
+
+// SetStreamRate sets how many events per second each streaming client is sent
+// (0 for unlimited). Events over it are dropped and summarized.
+func (s *Server) SetStreamRate(rate int) {
+	s.streamRate = rate
+}
+
+// openStream registers a streaming client and starts its event stream. The
+// first event, "stream", carries the ID its filter can be changed with.
+func (s *Server) openStream(w http.ResponseWriter, r *http.Request, flusher http.Flusher, kind string, filter any) *streamClient {
+	now := time.Now()
+	client := &streamClient{
+		kind:      kind,
+		remote:    r.RemoteAddr,
+		connected: now,
+		rate:      s.streamRate,
+		tokens:    float64(s.streamRate),
+		refilled:  now,
+	}
+	client.filter.Store(filter)
+
+	s.streams.mu.Lock()
+	s.streams.seq++
+	client.id = "s-" + strconv.FormatUint(s.streams.seq, 10)
+	s.streams.clients[client.id] = client
+	s.streams.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: stream\ndata: {\"id\":%q}\n\n", client.id)
+	flusher.Flush()
+	return client
+}
+
+func (s *Server) closeStream(client *streamClient) {
+	s.streams.mu.Lock()
+	defer s.streams.mu.Unlock()
+	delete(s.streams.clients, client.id)
+}
+
+// streamExternal reports whether an address lies outside the local networks
+func (s *Server) streamExternal(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	return s.isExternal(ip)
+}
+
+// streamPatterns sends new communication patterns as server-sent events,
+// selected by the parameters of parsePatternFilter. The filter is evaluated
+// as patterns are recorded; it can be changed while connected with PUT
+// /api/v1/streams/{id}/filter. ?replay=N first sends the last N recent
+// patterns passing the filter.
+func (s *Server) streamPatterns(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	replay, err := parseReplay(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := parsePatternFilter(r.URL.Query())
+	if err == nil {
+		err = s.checkInterfaces(filter.Interfaces...)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := s.openStream(w, r, flusher, streamPatterns, filter)
+	defer s.closeStream(client)
+
+	recent, patterns, unsubscribe := s.eventSource().SubscribePatterns(func(pattern *models.CommunicationPattern) bool {
+		return client.filter.Load().(*patternFilter).match(pattern, s.streamExternal)
+	}, replay > 0)
+	defer unsubscribe()
+
+	var replayed []*models.CommunicationPattern
+	for _, pattern := range recent {
+		if filter.match(pattern, s.streamExternal) {
+			replayed = append(replayed, pattern)
+		}
+	}
+	for _, pattern := range replayed[max(0, len(replayed)-replay):] {
+		writeEvent(w, "pattern", pattern.ID, pattern)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(sseKeepalive)
+	defer keepalive.Stop()
+	drops := time.NewTicker(streamDropInterval)
+	defer drops.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-drops.C:
+			client.reportDropped(w, flusher)
+		case pattern, ok := <-patterns:
+			if !ok {
+				return
+			}
+			client.send(w, flusher, "pattern", pattern.ID, pattern)
+		}
+	}
+}
+
+// putStreamFilter replaces the filter of a connected pattern stream
+func (s *Server) putStreamFilter(w http.ResponseWriter, r *http.Request) {
+	s.streams.mu.Lock()
+	client := s.streams.clients[r.PathValue("id")]
+	s.streams.mu.Unlock()
+	if client == nil {
+		writeError(w, http.StatusNotFound, "stream not found")
+		return
+	}
+	if client.kind != streamPatterns {
+		writeError(w, http.StatusConflict, "only the filters of pattern streams can be changed")
+		return
+	}
+
+	var filter patternFilter
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filter); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid filter: "+err.Error())
+		return
+	}
+	err := filter.normalize()
+	if err == nil {
+		err = s.checkInterfaces(filter.Interfaces...)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	client.filter.Store(&filter)
+	writeJSON(w, http.StatusOK, filter)
+}
+
+// streamInfo describes a connected event stream
+type streamInfo struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Remote    string    `json:"remote"`
+	Connected time.Time `json:"connected"`
+	Filter    any       `json:"filter"`
+	Rate      int       `json:"rate"` // Events per second, 0 for unlimited
+	Sent      uint64    `json:"sent"`
+	Dropped   uint64    `json:"dropped"`
+}
+
+// getStreams lists the connected event streams, oldest first
+func (s *Server) getStreams(w http.ResponseWriter, r *http.Request) {
+	s.streams.mu.Lock()
+	streams := make([]streamInfo, 0, len(s.streams.clients))
+	for _, client := range s.streams.clients {
+		streams = append(streams, streamInfo{
+			ID:        client.id,
+			Kind:      client.kind,
+			Remote:    client.remote,
+			Connected: client.connected,
+			Filter:    client.filter.Load(),
+			Rate:      client.rate,
+			Sent:      client.sent.Load(),
+			Dropped:   client.dropped.Load(),
+		})
+	}
+	s.streams.mu.Unlock()
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Connected.Before(streams[j].Connected)
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"clients": len(streams), "streams": streams})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// readEvents connects to a stream and decodes the data of its first n events
+// of the given name. feed, if set, runs after the first one, once the stream
+// is subscribed.
+func readEvents[T any](t *testing.T, url, name string, n int, feed func()) []T {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var events []T
+	scanner := bufio.NewScanner(resp.Body)
+	for event := ""; len(events) < n && scanner.Scan(); {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == name:
+			var v T
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &v); err != nil {
+				t.Fatalf("%s: decoding %q: %v", url, line, err)
+			}
+			events = append(events, v)
+			if len(events) == 1 && feed != nil {
+				feed()
+			}
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("%s: %d %s events before %v, want %d", url, len(events), name, scanner.Err(), n)
+	}
+	return events
+}
+
+// readPatterns connects to a pattern stream and returns its first n pattern
+// events, as readEvents does
+func readPatterns(t *testing.T, base, query string, n int, feed func()) []models.CommunicationPattern {
+	t.Helper()
+	return readEvents[models.CommunicationPattern](t, base+"/api/v1/patterns/stream?"+query, "pattern", n, feed)
+}
+
+// A stream with replay first sends the latest recent patterns passing its
+// filter, then the live ones; src_ip and dst_ip take IPs and CIDRs
+func TestStreamPatternsReplay(t *testing.T) {
+	s, mon := newTestServer(t)
+	seedMonitor(t, mon)
+	// Patterns are published asynchronously
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		recent, _, unsubscribe := mon.SubscribePatterns(nil, true)
+		unsubscribe()
+		if len(recent) == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d patterns published, want 4", len(recent))
+		}
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		query string
+		want  []string // SrcIP > DstIP of the replayed patterns
+	}{
+		{"replay=5&src_ip=192.168.2.0/24", []string{"192.168.2.5>203.0.113.10", "192.168.2.5>203.0.113.11"}},
+		{"replay=1&src_ip=192.168.2.0/24", []string{"192.168.2.5>203.0.113.11"}},
+		{"replay=5&dst_ip=203.0.113.10", []string{"192.168.2.5>203.0.113.10", "192.168.20.5>203.0.113.10"}},
+		{"replay=5&src_ip=10.1.2.3,192.168.20.5&dst_ip=198.51.100.0/24", []string{"10.1.2.3>198.51.100.7"}},
+	}
+	for _, tt := range tests {
+		patterns := readPatterns(t, server.URL, tt.query, len(tt.want), nil)
+		for i, pattern := range patterns {
+			if got := pattern.SrcIP + ">" + pattern.DstIP; got != tt.want[i] {
+				t.Errorf("stream?%s: pattern %d is %s, want %s", tt.query, i, got, tt.want[i])
+			}
+		}
+	}
+
+	// Live patterns pass the same filter, after the replayed ones
+	live := readPatterns(t, server.URL, "replay=1&dst_ip=203.0.113.0/24", 2, func() {
+		mon.TrackEvent(tcpEvent(t, otherMAC, "10.1.2.3", "198.51.100.8", 22))
+		mon.TrackEvent(tcpEvent(t, otherMAC, "10.1.2.3", "203.0.113.12", 443))
+	})
+	if live[0].DstIP != "203.0.113.10" || live[1].DstIP != "203.0.113.12" {
+		t.Errorf("streamed to %s then %s, want 203.0.113.10 then 203.0.113.12", live[0].DstIP, live[1].DstIP)
+	}
+
+	for _, query := range []string{"replay=-1", "replay=many", "src_ip=192.168.2", "dst_ip=example.com", "dst_ip=10.0.0.0/33"} {
+		get(t, s, "/api/v1/patterns/stream?"+query, http.StatusBadRequest, nil)
+	}
+}
+
+// arpSpoof sends the ARP replies of a device claiming ip with another sender
+// address, enough for an ARP_SENDER_MISMATCH anomaly
+func arpSpoof(t *testing.T, mon *monitor.NetworkMonitor, mac, ip string) {
+	t.Helper()
+	for range monitor.DefaultARPMismatchConfig().Threshold {
+		evt := tcpEvent(t, mac, ip, ip, 0)
+		evt.EventType, evt.ArpOp, evt.ArpSha = models.EVENT_TYPE_ARP, 2, [6]byte{0x02, 0, 0, 0, 0, 0x01}
+		evt.Protocol, evt.SrcPort, evt.TCPFlags = 0, 0, 0
+		mon.TrackEvent(evt)
+	}
+}
+
+// A saved filter named on the anomaly stream applies to the replayed and the
+// live anomalies alike, on top of the query filters; an unknown one is a 404
+func TestStreamAnomaliesSavedFilter(t *testing.T) {
+	s, mon := newTestServer(t)
+	const spoofer, watched, other = "02:00:00:00:00:0a", "02:00:00:00:00:0b", "02:00:00:00:00:0c"
+	if _, err := mon.SaveAnomalyFilter("watched", models.AnomalyFilter{Devices: []string{watched, other}}, true); err != nil {
+		t.Fatal(err)
+	}
+	arpSpoof(t, mon, spoofer, "192.168.1.10")
+	arpSpoof(t, mon, watched, "192.168.1.11")
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	url := server.URL + "/api/v1/anomalies/stream?replay=5&filter=watched&type=ARP_SENDER_MISMATCH"
+	anomalies := readEvents[models.Anomaly](t, url, "anomaly", 2, func() {
+		arpSpoof(t, mon, "02:00:00:00:00:0d", "192.168.1.13")
+		arpSpoof(t, mon, other, "192.168.1.12")
+	})
+	if anomalies[0].DeviceID != watched || anomalies[1].DeviceID != other {
+		t.Errorf("streamed anomalies of %s then %s, want %s then %s", anomalies[0].DeviceID, anomalies[1].DeviceID, watched, other)
+	}
+
+	// Through the server: a stream opened by mistake would never end
+	resp, err := http.Get(server.URL + "/api/v1/anomalies/stream?filter=unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("stream with an unknown saved filter = %d, want 404", resp.StatusCode)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+const (
+	defaultFreeAddresses = 20   // Free addresses suggested by default
+	maxFreeAddresses     = 1000 // Free addresses suggested at most
+)
+
+// getSubnet serves the resources of a subnet, whose CIDR spans two path
+// segments: /api/v1/subnets/{cidr}/free
+func (s *Server) getSubnet(w http.ResponseWriter, r *http.Request) {
+	cidr, ok := strings.CutSuffix(r.PathValue("path"), "/free")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil || subnet.IP.To4() == nil {
+		writeError(w, http.StatusBadRequest, "invalid subnet: expected an IPv4 CIDR")
+		return
+	}
+	s.getFreeAddresses(w, r, subnet)
+}
+
+// getFreeAddresses suggests addresses of a local subnet for static assignment.
+// lookback is a duration (the configured one by default), limit the number of
+// addresses (20 by default).
+func (s *Server) getFreeAddresses(w http.ResponseWriter, r *http.Request, subnet *net.IPNet) {
+	params := r.URL.Query()
+	var lookback time.Duration
+	if v := params.Get("lookback"); v != "" {
+		var err error
+		if lookback, err = time.ParseDuration(v); err != nil || lookback <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid lookback: expected a positive duration")
+			return
+		}
+	}
+	limit := defaultFreeAddresses
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxFreeAddresses {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: expected 1 to %d", maxFreeAddresses))
+			return
+		}
+	}
+
+	free, err := s.monitor().FreeAddresses(subnet, lookback, limit)
+	if errors.Is(err, monitor.ErrNotLocalSubnet) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, free)
+}
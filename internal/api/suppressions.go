@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// suppressionRequest is the body of POST /api/v1/suppressions
+type suppressionRequest struct {
+	Device      string     `json:"device"`
+	Destination string     `json:"destination"`
+	Domain      string     `json:"domain"`
+	Port        uint16     `json:"port"`
+	Protocol    string     `json:"protocol"`
+	Mode        string     `json:"mode"`
+	Comment     string     `json:"comment"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	TTL         string     `json:"ttl"` // Alternative to expires_at, e.g. "24h"
+}
+
+func (s *Server) listSuppressions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().Suppressions())
+}
+
+func (s *Server) createSuppression(w http.ResponseWriter, r *http.Request) {
+	var req suppressionRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid suppression: "+err.Error())
+		return
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.TTL != "" {
+		if expiresAt != nil {
+			writeError(w, http.StatusBadRequest, "set either expires_at or ttl, not both")
+			return
+		}
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid ttl: expected a positive duration such as 24h")
+			return
+		}
+		expiry := time.Now().Add(ttl)
+		expiresAt = &expiry
+	}
+
+	suppression, err := s.monitor().AddSuppression(models.Suppression{
+		Device:      req.Device,
+		Destination: req.Destination,
+		Domain:      req.Domain,
+		Port:        req.Port,
+		Protocol:    req.Protocol,
+		Mode:        req.Mode,
+		Comment:     req.Comment,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, suppression)
+}
+
+func (s *Server) deleteSuppression(w http.ResponseWriter, r *http.Request) {
+	err := s.monitor().DeleteSuppression(r.PathValue("id"))
+	if errors.Is(err, monitor.ErrSuppressionNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
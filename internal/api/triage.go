@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// maxHistoryPage bounds the anomalies returned by one history request
+const maxHistoryPage = 1000
+
+// anomalyHistoryPage is the response of GET /api/v1/anomalies/history
+type anomalyHistoryPage struct {
+	Anomalies []*models.Anomaly `json:"anomalies"`
+	Next      string            `json:"next,omitempty"` // Pass as before for the next page
+}
+
+// ackRequest is the optional body of POST /api/v1/anomalies/{id}/ack
+type ackRequest struct {
+	Comment string `json:"comment"`
+}
+
+// getAnomalyHistory pages through the persisted anomalies, newest first
+func (s *Server) getAnomalyHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	limit := 100
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxHistoryPage {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit: expected 1 to %d", maxHistoryPage))
+			return
+		}
+	}
+
+	filter, ok := s.withSavedFilter(w, r, parseAnomalyFilter(r))
+	if !ok {
+		return
+	}
+	anomalies, next, err := s.monitor().AnomalyHistory(params.Get("before"), limit, filter.match)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if anomalies == nil {
+		anomalies = []*models.Anomaly{}
+	}
+	writeJSON(w, http.StatusOK, anomalyHistoryPage{Anomalies: anomalies, Next: next})
+}
+
+func (s *Server) ackAnomaly(w http.ResponseWriter, r *http.Request) {
+	var req ackRequest
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid acknowledgement: "+err.Error())
+			return
+		}
+	}
+
+	anomaly, err := s.monitor().AckAnomaly(r.PathValue("id"), req.Comment)
+	if errors.Is(err, monitor.ErrAnomalyNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, anomaly)
+}
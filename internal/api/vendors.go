@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (s *Server) getVendorAliases(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.monitor().VendorAliases())
+}
+
+// putVendorAliases replaces the configured aliases mapping vendor names to
+// canonical brands; the built-in ones apply where none is configured
+func (s *Server) putVendorAliases(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Aliases map[string]string `json:"aliases"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid aliases: "+err.Error())
+		return
+	}
+	aliases, err := s.monitor().SetVendorAliases(req.Aliases)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, aliases)
+}
+
+// postVendorReindex re-canonicalizes the vendor of every known device
+func (s *Server) postVendorReindex(w http.ResponseWriter, r *http.Request) {
+	result, err := s.monitor().ReindexVendors()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
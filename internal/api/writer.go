@@ -0,0 +1,35 @@
+package api
+
+import "github.com/zrougamed/cerberus/internal/models"
+
+// ModeAPIOnly is the health mode of a process serving the database of a
+// capturing process, see SetWriterStatus
+const ModeAPIOnly = "api-only"
+
+// writerOnlyStats are the fields of /api/v1/stats counted in memory by the
+// capturing process, which an api-only process reports as zero
+var writerOnlyStats = []string{
+	"total_packets", "self_packets", "arp_packets", "tcp_packets", "udp_packets",
+	"icmp_packets", "dns_packets", "http_packets", "tls_packets", "filtered_packets",
+	"invalid_events", "flow_summaries", "flow_packets", "flow_bytes", "failed_persists",
+	"l7_intern", "packet_sizes", "alert_routes",
+}
+
+// withWriter adds the capturing process an api-only process follows to its
+// health, degrading it while that process can't be reached
+func (s *Server) withWriter(health models.HealthStatus) models.HealthStatus {
+	if s.writer == nil {
+		return health
+	}
+	health.Mode = ModeAPIOnly
+	health.Writer = s.writer()
+	if !health.Writer.Connected {
+		reason := "capturing process not reachable on " + health.Writer.Socket + ", serving persisted data only"
+		if health.Writer.LastError != "" {
+			reason += ": " + health.Writer.LastError
+		}
+		health.Reasons = append(health.Reasons, reason)
+		health.Status = models.HealthDegraded
+	}
+	return health
+}
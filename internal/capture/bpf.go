@@ -0,0 +1,117 @@
+// Package capture loads the BPF program, attaches it to interfaces and
+// configures its filters. It also decodes captured frames in userspace the way
+// the BPF program does, for replaying capture files without privileges.
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Object is the compiled BPF program, see ebpf/cerberus_tc.c
+const Object = "cerberus_tc.o"
+
+// legacyEventLayout is assumed for BPF objects without an event_layout
+// variable, which predate the explicit byte order of layout 4
+const legacyEventLayout = 3
+
+// Attach modes
+const (
+	ModeTCX        = "tcx"
+	ModeXDPGeneric = "xdp-generic"
+	ModeXDPNative  = "xdp-native"
+)
+
+// LoadCollection loads a BPF object into the kernel and returns the event
+// layout it emits. With a pinPath, maps are pinned there and those pinned by
+// a previous run are reused if they still match (see pinCollectionSpec). A
+// verifier rejection is returned as an *ebpf.VerifierError carrying the
+// verifier log.
+func LoadCollection(object, pinPath string, logger *log.Logger) (*ebpf.Collection, uint32, error) {
+	spec, err := ebpf.LoadCollectionSpec(object)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load BPF spec: %w", err)
+	}
+
+	layout := uint32(legacyEventLayout)
+	if v := spec.Variables["event_layout"]; v != nil {
+		if err := v.Get(&layout); err != nil {
+			return nil, 0, fmt.Errorf("failed to read BPF event layout: %w", err)
+		}
+	}
+
+	var opts ebpf.CollectionOptions
+	if pinPath != "" {
+		opts, err = pinCollectionSpec(spec, pinPath, logger)
+		if err != nil {
+			// Usually no BPF filesystem mounted; capture works without pins
+			logger.Printf("Warning: not pinning BPF maps: %v", err)
+		}
+	}
+
+	coll, err := ebpf.NewCollectionWithOptions(spec, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create BPF collection: %w", err)
+	}
+	return coll, layout, nil
+}
+
+// ClassifierProgram returns the program attached to every interface
+func ClassifierProgram(coll *ebpf.Collection) (*ebpf.Program, error) {
+	prog := coll.Programs["xdp_arp_monitor"]
+	if prog == nil {
+		return nil, errors.New("BPF program 'xdp_arp_monitor' not found in object file")
+	}
+	return prog, nil
+}
+
+// XDPProgram returns the program attached to every interface in the XDP
+// attach modes
+func XDPProgram(coll *ebpf.Collection) (*ebpf.Program, error) {
+	prog := coll.Programs["xdp_monitor"]
+	if prog == nil {
+		return nil, errors.New("BPF program 'xdp_monitor' not found in object file; rebuild it with 'make bpf'")
+	}
+	return prog, nil
+}
+
+// AttachTCX attaches the classifier to the ingress of an interface using TCX,
+// the modern TC hook mechanism that replaces the clsact qdisc approach
+func AttachTCX(prog *ebpf.Program, ifindex int) (link.Link, error) {
+	return link.AttachTCX(link.TCXOptions{
+		Interface: ifindex,
+		Program:   prog,
+		Attach:    ebpf.AttachTCXIngress,
+	})
+}
+
+// AttachXDP attaches the XDP program to an interface, in the driver if native
+// is set, and returns the mode it ended up in. Interfaces whose driver can't
+// run XDP fall back to generic mode, which runs after the kernel has built the
+// skb.
+func AttachXDP(prog *ebpf.Program, ifindex int, native bool, logger *log.Logger) (link.Link, string, error) {
+	if native {
+		l, err := link.AttachXDP(link.XDPOptions{
+			Program:   prog,
+			Interface: ifindex,
+			Flags:     link.XDPDriverMode,
+		})
+		if err == nil {
+			return l, ModeXDPNative, nil
+		}
+		logger.Printf("Native XDP not available on %s (%v), falling back to generic XDP",
+			utils.IfIndexToName(uint32(ifindex)), err)
+	}
+	l, err := link.AttachXDP(link.XDPOptions{
+		Program:   prog,
+		Interface: ifindex,
+		Flags:     link.XDPGenericMode,
+	})
+	return l, ModeXDPGeneric, err
+}
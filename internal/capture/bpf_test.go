@@ -0,0 +1,627 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// The kernel tests run the BPF program built by 'make bpf' on crafted frames
+// with BPF_PROG_TEST_RUN and compare its records with the Decoder's. They
+// need root (CAP_BPF and CAP_NET_ADMIN) and skip without it.
+var bpfObject = flag.String("bpf-object", "../../build/"+Object, "BPF object of the kernel tests")
+
+// testIfIndex is the interface test runs are attributed to: the loopback
+// device of the network namespace
+const testIfIndex = 1
+
+// kernelProgram is one BPF program loaded for testing, with the maps it emits
+// records to and the filter configuring it
+type kernelProgram struct {
+	coll   *ebpf.Collection
+	prog   *ebpf.Program
+	filter *Filter
+	layout uint32
+	rings  map[string]*ringbuf.Reader
+}
+
+// loadKernelProgram loads the program called name out of the test BPF object,
+// skipping the test if the object or the privileges are missing
+func loadKernelProgram(t *testing.T, name string) *kernelProgram {
+	t.Helper()
+	if _, err := os.Stat(*bpfObject); err != nil {
+		t.Skipf("BPF object not built (make bpf): %v", err)
+	}
+	spec, err := ebpf.LoadCollectionSpec(*bpfObject)
+	if err != nil {
+		t.Fatalf("failed to load BPF spec: %v", err)
+	}
+	layout := uint32(legacyEventLayout)
+	if v := spec.Variables["event_layout"]; v != nil {
+		if err := v.Get(&layout); err != nil {
+			t.Fatalf("failed to read event layout: %v", err)
+		}
+	}
+	// Only the program under test is verified, so a rejection names it
+	for other := range spec.Programs {
+		if other != name {
+			delete(spec.Programs, other)
+		}
+	}
+	if spec.Programs[name] == nil {
+		t.Fatalf("BPF program %q not found in %s", name, *bpfObject)
+	}
+
+	coll, err := ebpf.NewCollection(spec)
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("cannot load BPF programs: %v", err)
+	}
+	if err != nil {
+		var verr *ebpf.VerifierError
+		if errors.As(err, &verr) {
+			t.Fatalf("%s rejected by the verifier: %+v", name, verr)
+		}
+		t.Fatalf("failed to load %s: %v", name, err)
+	}
+	t.Cleanup(func() { coll.Close() })
+
+	filter, err := NewFilter(coll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &kernelProgram{
+		coll:   coll,
+		prog:   coll.Programs[name],
+		filter: filter,
+		layout: layout,
+		rings:  make(map[string]*ringbuf.Reader),
+	}
+	for _, ring := range []string{"events", "tls_hellos", "dns_queries", "http_requests", "flow_summaries"} {
+		rd, err := ringbuf.NewReader(coll.Maps[ring])
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { rd.Close() })
+		k.rings[ring] = rd
+	}
+	return k
+}
+
+// kernelPrograms are the programs every frame is run through: the TC
+// classifier and the XDP program
+var kernelPrograms = []string{"xdp_arp_monitor", "xdp_monitor"}
+
+// raw returns the records a frame makes the program emit, by ring buffer
+func (k *kernelProgram) raw(t *testing.T, frame []byte) map[string][][]byte {
+	t.Helper()
+	if _, err := k.prog.Run(&ebpf.RunOptions{Data: frame}); err != nil {
+		t.Fatalf("test run failed: %v", err)
+	}
+	records := make(map[string][][]byte)
+	for ring, rd := range k.rings {
+		// A deadline in the past reads what is buffered without waiting
+		rd.SetDeadline(time.Now())
+		for {
+			rec, err := rd.Read()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading %s: %v", ring, err)
+			}
+			records[ring] = append(records[ring], rec.RawSample)
+		}
+	}
+	return records
+}
+
+// run returns the records of a frame the way Decoder.Decode does
+func (k *kernelProgram) run(t *testing.T, frame []byte) Frame {
+	t.Helper()
+	order := utils.EventByteOrder(k.layout)
+	var result Frame
+	for ring, records := range k.raw(t, frame) {
+		if len(records) != 1 {
+			t.Fatalf("%d records in %s, want at most 1", len(records), ring)
+		}
+		data := records[0]
+		switch ring {
+		case "events":
+			result.Event = utils.ParseNetworkEvent(data, k.layout)
+		case "tls_hellos":
+			result.TLSHello = utils.ParseTLSHelloEvent(data, order)
+		case "dns_queries":
+			result.DNSQuery = utils.ParseDNSQueryEvent(data, order)
+		case "http_requests":
+			result.HTTPRequest = utils.ParseHTTPRequestEvent(data, order)
+		case "flow_summaries":
+			result.FlowSummary = utils.ParseFlowSummaryEvent(data, order)
+		}
+	}
+	return normalizeFrame(result)
+}
+
+// normalizeFrame clears what differs between kernel and decoded records
+// without being a difference: empty payloads and flow durations, which depend
+// on the clock
+func normalizeFrame(f Frame) Frame {
+	if f.Event != nil && len(f.Event.L7Payload) == 0 {
+		f.Event.L7Payload = nil
+	}
+	if f.FlowSummary != nil {
+		f.FlowSummary.Duration = 0
+	}
+	return f
+}
+
+// kernelCase is a frame run through a program configured with config
+type kernelCase struct {
+	name    string
+	config  models.CaptureConfig
+	frame   []byte
+	dropped bool // The frame emits no record
+}
+
+// checkKernel runs every case through every program and the Decoder and
+// fails on any difference in their records
+func checkKernel(t *testing.T, cases []kernelCase) {
+	for _, name := range kernelPrograms {
+		t.Run(name, func(t *testing.T) {
+			k := loadKernelProgram(t, name)
+			for _, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					if err := k.filter.Apply(c.config); err != nil {
+						t.Fatal(err)
+					}
+					d := NewDecoder()
+					if err := d.Apply(c.config); err != nil {
+						t.Fatal(err)
+					}
+					got := k.run(t, c.frame)
+					want := normalizeFrame(d.Decode(c.frame, len(c.frame), testIfIndex, time.Time{}))
+					if empty := want == (Frame{}); empty != c.dropped {
+						t.Fatalf("decoder emitted no record: %v, want %v", empty, c.dropped)
+					}
+					compareFrames(t, got, want)
+				})
+			}
+		})
+	}
+}
+
+// compareFrames fails if two frames hold different records
+func compareFrames(t *testing.T, got, want Frame) {
+	t.Helper()
+	for _, r := range []struct {
+		name      string
+		got, want any
+	}{
+		{"event", got.Event, want.Event},
+		{"TLS hello", got.TLSHello, want.TLSHello},
+		{"HTTP request", got.HTTPRequest, want.HTTPRequest},
+		{"DNS query", got.DNSQuery, want.DNSQuery},
+		{"flow summary", got.FlowSummary, want.FlowSummary},
+	} {
+		if !reflect.DeepEqual(r.got, r.want) {
+			t.Errorf("%s:\nkernel  %+v\ndecoder %+v", r.name, r.got, r.want)
+		}
+	}
+}
+
+// Frames between two hosts of 192.168.1.0/24
+var (
+	clientMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	clientIP  = []byte{192, 168, 1, 10}
+	serverIP  = []byte{192, 168, 1, 20}
+)
+
+// ipv4Frame returns an Ethernet frame from the client to the server carrying
+// an IPv4 packet with the given transport header and payload
+func ipv4Frame(protocol uint8, transport, payload []byte) []byte {
+	frame := append(append([]byte{}, serverMAC...), clientMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, 0x0800)
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(transport)+len(payload)))
+	ip[8] = 64
+	ip[9] = protocol
+	copy(ip[12:16], clientIP)
+	copy(ip[16:20], serverIP)
+	frame = append(frame, ip...)
+	frame = append(frame, transport...)
+	return append(frame, payload...)
+}
+
+// TCP flags
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// tcpFrame returns a TCP segment from the client to a server port
+func tcpFrame(dstPort uint16, flags uint8, payload []byte) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], 40000)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 64240)
+	return ipv4Frame(6, tcp, payload)
+}
+
+// udpFrame returns a UDP datagram from the client to a server port
+func udpFrame(dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:2], 40000)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+	return ipv4Frame(17, udp, payload)
+}
+
+// dnsQuery returns a DNS query for name padded with additional records to n
+// bytes
+func dnsQuery(name string, n int) []byte {
+	msg := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			msg = append(msg, byte(i-start))
+			msg = append(msg, name[start:i]...)
+			start = i + 1
+		}
+	}
+	msg = append(msg, 0, 0, 1, 0, 1)
+	for len(msg) < n {
+		msg = append(msg, 0)
+	}
+	return msg
+}
+
+// httpRequest returns a GET request padded with a header to n bytes
+func httpRequest(n int) []byte {
+	req := []byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\nX-Pad: ")
+	for len(req) < n-4 {
+		req = append(req, 'a')
+	}
+	return append(req, "\r\n\r\n"...)
+}
+
+// clientHello returns a TLS record holding a ClientHello, padded to n bytes
+func clientHello(n int) []byte {
+	hello := make([]byte, n)
+	hello[0], hello[1], hello[2] = 0x16, 0x03, 0x01
+	binary.BigEndian.PutUint16(hello[3:5], uint16(n-5))
+	hello[5] = 0x01
+	for i := 6; i < n; i++ {
+		hello[i] = byte(i)
+	}
+	return hello
+}
+
+// TestKernelPayloadLimits checks that the side records hold as much payload
+// as payload_limits allows each event type, up to the compiled maximum
+func TestKernelPayloadLimits(t *testing.T) {
+	frames := []struct {
+		name  string
+		frame []byte
+	}{
+		{"dns", udpFrame(dnsPort, dnsQuery("printer.example.com", 700))},
+		{"http", tcpFrame(httpPort, tcpPSH|tcpACK, httpRequest(1200))},
+		{"tls", tcpFrame(httpsPort, tcpPSH|tcpACK, clientHello(1400))},
+		{"short tls", tcpFrame(httpsAltPort, tcpPSH|tcpACK, clientHello(90))},
+	}
+	limits := []struct {
+		name    string
+		payload map[string]int
+	}{
+		{"defaults", nil},
+		{"smallest", map[string]int{"DNS": 12, "HTTP": 1, "TLS": 1}},
+		{"tiers", map[string]int{"DNS": 64, "HTTP": 256, "TLS": 512}},
+		{"largest", map[string]int{"DNS": 511, "HTTP": 1023, "TLS": 2047}},
+	}
+	var cases []kernelCase
+	for _, l := range limits {
+		for _, f := range frames {
+			cases = append(cases, kernelCase{
+				name:   l.name + "/" + f.name,
+				config: models.CaptureConfig{PayloadBytes: l.payload},
+				frame:  f.frame,
+			})
+		}
+	}
+	checkKernel(t, cases)
+}
+
+// arpFrame returns an ARP request broadcast by the client for the server
+func arpFrame() []byte {
+	frame := append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, clientMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, 0x0806)
+	frame = append(frame, 0, 1, 0x08, 0x00, 6, 4, 0, 1)
+	frame = append(frame, clientMAC...)
+	frame = append(frame, clientIP...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, serverIP...)
+	return append(frame, make([]byte, 18)...) // Padded to the shortest frame
+}
+
+// icmpFrame returns an echo request from the client to the server
+func icmpFrame() []byte {
+	return ipv4Frame(1, []byte{8, 0, 0, 0, 0x12, 0x34, 0, 1}, []byte("ping"))
+}
+
+// TestKernelEventPayloadLimits checks that events carry as much payload as
+// event_payload_limits allows their type, and that the record shrinks to fit
+func TestKernelEventPayloadLimits(t *testing.T) {
+	frames := []struct {
+		name  string
+		frame []byte
+	}{
+		{"tcp", tcpFrame(22, tcpSYN, []byte("SSH-2.0-OpenSSH_9.6\r\n"))},
+		{"udp", udpFrame(5353, make([]byte, 600))},
+		{"dns", udpFrame(dnsPort, dnsQuery("printer.example.com", 100))},
+		{"http", tcpFrame(httpAltPort, tcpPSH|tcpACK, httpRequest(700))},
+		{"tls", tcpFrame(httpsPort, tcpPSH|tcpACK, clientHello(800))},
+	}
+	limits := []struct {
+		name    string
+		payload map[string]int
+	}{
+		{"defaults", nil},
+		{"none", map[string]int{}},
+		{"one byte", map[string]int{"TCP": 1, "UDP": 1, "DNS": 1, "HTTP": 1, "TLS": 1}},
+		{"tiers", map[string]int{"DNS": 64, "HTTP": 192, "TLS": 256}},
+		{"largest", map[string]int{"TCP": 511, "UDP": 511, "DNS": 511, "HTTP": 511, "TLS": 511}},
+	}
+	var cases []kernelCase
+	for _, l := range limits {
+		for _, f := range frames {
+			cases = append(cases, kernelCase{
+				name:   l.name + "/" + f.name,
+				config: models.CaptureConfig{EventPayloadBytes: l.payload},
+				frame:  f.frame,
+			})
+		}
+	}
+	checkKernel(t, cases)
+
+	// The record is the header and the payload, nothing more
+	k := loadKernelProgram(t, kernelPrograms[0])
+	if err := k.filter.Apply(models.CaptureConfig{EventPayloadBytes: map[string]int{"UDP": 100}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{0, 40, 100, 300} {
+		events := k.raw(t, udpFrame(5353, make([]byte, n)))["events"]
+		if want := utils.EventHeaderSize + min(n, 100); len(events) != 1 || len(events[0]) != want {
+			t.Errorf("%d bytes of UDP payload: records %d, want one of %d bytes", n, len(events), want)
+		}
+	}
+}
+
+// TestKernelInterfaceCapture checks that settings written for the interface
+// a packet arrives on replace the global ones, that other interfaces keep the
+// global ones, and that clearing them restores the global ones
+func TestKernelInterfaceCapture(t *testing.T) {
+	tcp, udp := tcpFrame(22, tcpSYN, nil), udpFrame(5353, []byte("x"))
+	for _, name := range kernelPrograms {
+		t.Run(name, func(t *testing.T) {
+			k := loadKernelProgram(t, name)
+			if err := k.filter.Apply(models.CaptureConfig{Events: []string{"tcp"}}); err != nil {
+				t.Fatal(err)
+			}
+			check := func(step string, wantTCP, wantUDP bool) {
+				t.Helper()
+				if got := len(k.raw(t, tcp)["events"]) == 1; got != wantTCP {
+					t.Errorf("%s: TCP event %v, want %v", step, got, wantTCP)
+				}
+				if got := len(k.raw(t, udp)["events"]) == 1; got != wantUDP {
+					t.Errorf("%s: UDP event %v, want %v", step, got, wantUDP)
+				}
+			}
+			check("global", true, false)
+
+			if err := k.filter.ApplyInterface(testIfIndex+1, &models.InterfaceCapture{Events: []string{"udp"}}); err != nil {
+				t.Fatal(err)
+			}
+			check("another interface", true, false)
+
+			if err := k.filter.ApplyInterface(testIfIndex, &models.InterfaceCapture{Events: []string{"udp"}}); err != nil {
+				t.Fatal(err)
+			}
+			check("this interface", false, true)
+			if active, err := k.filter.ActiveInterface(testIfIndex); err != nil || active == nil || len(active.Events) != 1 {
+				t.Errorf("ActiveInterface = %+v, %v", active, err)
+			}
+
+			if err := k.filter.ApplyInterface(testIfIndex, nil); err != nil {
+				t.Fatal(err)
+			}
+			check("cleared", true, false)
+		})
+	}
+}
+
+// TestKernelIgnoreList checks that the ignore list drops the traffic of a
+// source MAC, or to or from a CIDR, counting it under the entry, and that
+// data plane entries let ARP and DNS through
+func TestKernelIgnoreList(t *testing.T) {
+	frames := map[string][]byte{
+		"tcp": tcpFrame(22, tcpSYN, nil),
+		"dns": udpFrame(dnsPort, dnsQuery("printer.example.com", 0)),
+		"arp": arpFrame(),
+	}
+	tests := []struct {
+		name  string
+		entry models.IgnoreEntry
+		kept  []string // Frames still emitting an event
+	}{
+		{"source MAC", models.IgnoreEntry{MAC: "02:00:00:00:00:01"}, nil},
+		{"source MAC, data plane", models.IgnoreEntry{MAC: "02:00:00:00:00:01", Scope: models.IgnoreScopeDataPlane}, []string{"dns", "arp"}},
+		{"destination MAC", models.IgnoreEntry{MAC: "02:00:00:00:00:02"}, []string{"tcp", "dns", "arp"}},
+		{"destination", models.IgnoreEntry{CIDR: "192.168.1.20/32"}, []string{"arp"}},
+		{"source", models.IgnoreEntry{CIDR: "192.168.1.0/29", Scope: models.IgnoreScopeAll}, []string{"tcp", "dns", "arp"}},
+		{"source subnet", models.IgnoreEntry{CIDR: "192.168.1.8/29"}, []string{"arp"}},
+		{"source subnet, data plane", models.IgnoreEntry{CIDR: "192.168.1.8/29", Scope: models.IgnoreScopeDataPlane}, []string{"dns", "arp"}},
+	}
+	for _, name := range kernelPrograms {
+		t.Run(name, func(t *testing.T) {
+			k := loadKernelProgram(t, name)
+			if err := k.filter.Apply(models.CaptureConfig{}); err != nil {
+				t.Fatal(err)
+			}
+			for i, tt := range tests {
+				tt.entry.ID = fmt.Sprintf("i-%d", i+1)
+				if err := k.filter.SyncIgnore([]models.IgnoreEntry{tt.entry}); err != nil {
+					t.Fatal(err)
+				}
+				var dropped uint64
+				for frame, data := range frames {
+					kept := len(k.raw(t, data)["events"]) == 1
+					if want := slices.Contains(tt.kept, frame); kept != want {
+						t.Errorf("%s: %s event %v, want %v", tt.name, frame, kept, want)
+					}
+					if !kept {
+						dropped++
+					}
+				}
+				counts, err := k.filter.IgnoreCounters()
+				if err != nil || counts[tt.entry.ID] != dropped {
+					t.Errorf("%s: counters = %v (%v), want %d dropped", tt.name, counts, err, dropped)
+				}
+			}
+		})
+	}
+}
+
+// TestKernelLoadCollection loads the whole object the way cerberus does, so
+// both programs pass the verifier together
+func TestKernelLoadCollection(t *testing.T) {
+	if _, err := os.Stat(*bpfObject); err != nil {
+		t.Skipf("BPF object not built (make bpf): %v", err)
+	}
+	coll, _, err := LoadCollection(*bpfObject, "", log.New(io.Discard, "", 0))
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, ebpf.ErrNotSupported) {
+		t.Skipf("cannot load BPF programs: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer coll.Close()
+	if _, err := ClassifierProgram(coll); err != nil {
+		t.Error(err)
+	}
+	if _, err := XDPProgram(coll); err != nil {
+		t.Error(err)
+	}
+}
+
+// ringVolume is what crossed the ring buffers during a run
+type ringVolume struct {
+	records int
+	bytes   int    // Record bytes, with the 8-byte header the ring adds to each
+	counted uint64 // Frame bytes reported by events and flow summaries
+}
+
+// drain reads every buffered record, adding it to the volume
+func (k *kernelProgram) drain(t *testing.T, v *ringVolume) {
+	t.Helper()
+	order := utils.EventByteOrder(k.layout)
+	for ring, rd := range k.rings {
+		rd.SetDeadline(time.Now())
+		for {
+			rec, err := rd.Read()
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("reading %s: %v", ring, err)
+			}
+			v.records++
+			v.bytes += 8 + len(rec.RawSample)
+			switch ring {
+			case "events":
+				v.counted += uint64(utils.ParseNetworkEvent(rec.RawSample, k.layout).PacketLen)
+			case "flow_summaries":
+				v.counted += utils.ParseFlowSummaryEvent(rec.RawSample, order).Bytes
+			}
+		}
+	}
+}
+
+// TestKernelFlowVolume replays a bulk transfer, as iperf makes, with and
+// without flow aggregation. For 200,000 full segments the rings carry 200,002
+// records of 12,400,124 bytes per packet, and 3,128 records of 168,936 bytes
+// aggregated by 64 packets: about 73 times less. The bytes counted stay exact
+// either way, once the final sweep collects what the summaries didn't.
+func TestKernelFlowVolume(t *testing.T) {
+	const segments = 200_000
+	const batch = 1000 // Runs between reads, well within the smallest ring
+	const iperfPort = 5201
+	syn := tcpFrame(iperfPort, tcpSYN, nil)
+	data := tcpFrame(iperfPort, tcpACK, make([]byte, 1448))
+	fin := tcpFrame(iperfPort, tcpFIN|tcpACK, nil)
+	total := uint64(len(syn) + segments*len(data) + len(fin))
+
+	for _, name := range kernelPrograms {
+		t.Run(name, func(t *testing.T) {
+			k := loadKernelProgram(t, name)
+			transfer := func(config models.CaptureConfig) ringVolume {
+				t.Helper()
+				if err := k.filter.Apply(config); err != nil {
+					t.Fatal(err)
+				}
+				var v ringVolume
+				k.prog.Run(&ebpf.RunOptions{Data: syn})
+				for sent := 0; sent < segments; sent += batch {
+					if _, err := k.prog.Run(&ebpf.RunOptions{Data: data, Repeat: batch}); err != nil {
+						t.Fatalf("test run failed: %v", err)
+					}
+					k.drain(t, &v)
+				}
+				k.prog.Run(&ebpf.RunOptions{Data: fin})
+				k.drain(t, &v)
+
+				swept, err := NewFlows(k.coll).Sweep(true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, summary := range swept {
+					v.counted += summary.Bytes
+				}
+				if v.counted != total {
+					t.Errorf("%d bytes counted, want %d", v.counted, total)
+				}
+				return v
+			}
+
+			perPacket := transfer(models.CaptureConfig{})
+			aggregated := transfer(models.CaptureConfig{FlowPackets: 64, FlowIntervalMs: 1000})
+			t.Logf("per packet: %d records, %d bytes; aggregated: %d records, %d bytes",
+				perPacket.records, perPacket.bytes, aggregated.records, aggregated.bytes)
+			if perPacket.records != segments+2 {
+				t.Errorf("%d records without aggregation, want one per frame", perPacket.records)
+			}
+			if aggregated.bytes*10 > perPacket.bytes {
+				t.Errorf("aggregation cut the ring volume from %d to %d bytes, want at least 10 times less",
+					perPacket.bytes, aggregated.bytes)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Filter is the monitor's CaptureControl over the BPF filter maps
+type Filter struct {
+	filter  *ebpf.Map
+	drops   *ebpf.Map // Per-CPU counters of filtered events
+	subnets *ebpf.Map
+	options *ebpf.Map
+	limits  *ebpf.Map // Payload capture lengths, nil with BPF objects predating them
+
+	eventLimits *ebpf.Map   // Event payload lengths, nil with BPF objects predating them
+	flowConfig  *ebpf.Map   // Flow aggregation settings, nil with BPF objects predating them
+	ifaces      *ebpf.Map   // Per-interface settings by ifindex, nil with BPF objects predating them
+	ignore      *ignoreList // Nil with BPF objects predating the ignore list
+}
+
+// captureOptions mirrors struct capture_options in the BPF program
+type captureOptions struct {
+	SubnetFilter uint8
+}
+
+// NewFilter returns the filter over the maps of a loaded collection
+func NewFilter(coll *ebpf.Collection) (*Filter, error) {
+	filterMap := coll.Maps["event_filter"]
+	if filterMap == nil {
+		return nil, errors.New("BPF map 'event_filter' not found")
+	}
+	dropsMap := coll.Maps["filter_drops"]
+	if dropsMap == nil {
+		return nil, errors.New("BPF map 'filter_drops' not found")
+	}
+	subnetsMap := coll.Maps["subnet_filter"]
+	optionsMap := coll.Maps["capture_options"]
+	if subnetsMap == nil || optionsMap == nil {
+		return nil, errors.New("BPF maps 'subnet_filter' and 'capture_options' not found")
+	}
+	ignore, err := loadIgnoreList(coll)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		filter:  filterMap,
+		drops:   dropsMap,
+		subnets: subnetsMap,
+		options: optionsMap,
+		limits:  coll.Maps["payload_limits"],
+
+		eventLimits: coll.Maps["event_payload_limits"],
+		flowConfig:  coll.Maps["flow_config"],
+		ifaces:      coll.Maps["iface_capture"],
+		ignore:      ignore,
+	}, nil
+}
+
+// Apply writes the filter flags of every event type. The BPF program reads
+// them per packet, so a change takes effect immediately.
+func (f *Filter) Apply(config models.CaptureConfig) error {
+	values, err := utils.EncodeEventFilter(config)
+	if err != nil {
+		return err
+	}
+	keys, err := utils.EncodeSubnetFilter(config.Subnets)
+	if err != nil {
+		return err
+	}
+	limits, err := utils.EncodePayloadLimits(config.PayloadBytes)
+	if err != nil {
+		return err
+	}
+	eventLimits, err := utils.EncodeEventPayloadLimits(config.EventPayloadBytes)
+	if err != nil {
+		return err
+	}
+	flowConfig, err := utils.EncodeFlowConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for t, value := range values {
+		if err := f.filter.Update(uint32(t), value, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update event filter: %w", err)
+		}
+	}
+	if f.limits != nil {
+		for t, value := range limits {
+			if err := f.limits.Update(uint32(t), value, ebpf.UpdateAny); err != nil {
+				return fmt.Errorf("failed to update payload limits: %w", err)
+			}
+		}
+	}
+	if f.eventLimits != nil {
+		for t, value := range eventLimits {
+			if err := f.eventLimits.Update(uint32(t), value, ebpf.UpdateAny); err != nil {
+				return fmt.Errorf("failed to update event payload limits: %w", err)
+			}
+		}
+	}
+
+	if f.flowConfig != nil {
+		if err := f.flowConfig.Update(uint32(0), flowConfig, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update flow aggregation: %w", err)
+		}
+	}
+
+	// The filter is off while its subnets are replaced, so a change briefly
+	// captures too much rather than dropping traffic of the new subnets
+	if err := f.options.Update(uint32(0), captureOptions{}, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update capture options: %w", err)
+	}
+	old, err := f.subnetKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range old {
+		if err := f.subnets.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to update subnet filter: %w", err)
+		}
+	}
+	for _, key := range keys {
+		if err := f.subnets.Update(key, uint8(1), ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update subnet filter: %w", err)
+		}
+	}
+	if len(keys) > 0 {
+		if err := f.options.Update(uint32(0), captureOptions{SubnetFilter: 1}, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update capture options: %w", err)
+		}
+	}
+	return nil
+}
+
+// subnetKeys lists the subnet_filter entries
+func (f *Filter) subnetKeys() ([]utils.SubnetFilterKey, error) {
+	var keys []utils.SubnetFilterKey
+	var key utils.SubnetFilterKey
+	var value uint8
+	entries := f.subnets.Iterate()
+	for entries.Next(&key, &value) {
+		keys = append(keys, key)
+	}
+	if err := entries.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read subnet filter: %w", err)
+	}
+	return keys, nil
+}
+
+// Active reads the filter flags back from the kernel
+func (f *Filter) Active() (models.CaptureConfig, error) {
+	values := make(map[uint8]uint8, len(models.EventTypeNames))
+	for t := range models.EventTypeNames {
+		var value uint8
+		if err := f.filter.Lookup(uint32(t), &value); err != nil {
+			return models.CaptureConfig{}, fmt.Errorf("failed to read event filter: %w", err)
+		}
+		values[t] = value
+	}
+	config := utils.DecodeEventFilter(values)
+
+	var options captureOptions
+	if err := f.options.Lookup(uint32(0), &options); err != nil {
+		return models.CaptureConfig{}, fmt.Errorf("failed to read capture options: %w", err)
+	}
+	config.Subnets = []string{}
+	if options.SubnetFilter != 0 {
+		keys, err := f.subnetKeys()
+		if err != nil {
+			return models.CaptureConfig{}, err
+		}
+		config.Subnets = utils.DecodeSubnetFilter(keys)
+	}
+
+	if f.limits != nil {
+		limits := make(map[uint8]uint16, len(utils.PayloadBytesMax))
+		for t := range utils.PayloadBytesMax {
+			var value uint16
+			if err := f.limits.Lookup(uint32(t), &value); err != nil {
+				return models.CaptureConfig{}, fmt.Errorf("failed to read payload limits: %w", err)
+			}
+			limits[t] = value
+		}
+		config.PayloadBytes = utils.DecodePayloadLimits(limits)
+	}
+	if f.eventLimits != nil {
+		limits := make(map[uint8]uint16, len(utils.EventPayloadTypes))
+		for _, t := range utils.EventPayloadTypes {
+			var value uint16
+			if err := f.eventLimits.Lookup(uint32(t), &value); err != nil {
+				return models.CaptureConfig{}, fmt.Errorf("failed to read event payload limits: %w", err)
+			}
+			limits[t] = value
+		}
+		config.EventPayloadBytes = utils.DecodeEventPayloadLimits(limits)
+	}
+	if f.flowConfig != nil {
+		var flowConfig utils.FlowConfig
+		if err := f.flowConfig.Lookup(uint32(0), &flowConfig); err != nil {
+			return models.CaptureConfig{}, fmt.Errorf("failed to read flow aggregation: %w", err)
+		}
+		config.FlowPackets = int(flowConfig.Packets)
+		config.FlowIntervalMs = int(flowConfig.IntervalMs)
+	}
+	return config, nil
+}
+
+// Suppressed sums the per-CPU counters of events dropped by the filter
+func (f *Filter) Suppressed() (map[string]uint64, error) {
+	suppressed := make(map[string]uint64, len(models.EventTypeNames))
+	for t, name := range models.EventTypeNames {
+		var perCPU []uint64
+		if err := f.drops.Lookup(uint32(t), &perCPU); err != nil {
+			return nil, fmt.Errorf("failed to read filter counters: %w", err)
+		}
+		for _, count := range perCPU {
+			suppressed[name] += count
+		}
+	}
+	return suppressed, nil
+}
+
+// ErrNoInterfaceCapture is returned when the BPF object predates per-interface
+// capture settings
+var ErrNoInterfaceCapture = errors.New("BPF map 'iface_capture' not found, rebuild the BPF program for per-interface capture settings")
+
+// ApplyInterface writes the capture settings of an interface, replacing the
+// global ones for packets arriving on it. A nil config removes them, so the
+// interface follows the global settings again.
+func (f *Filter) ApplyInterface(ifindex int, config *models.InterfaceCapture) error {
+	key, err := utils.InterfaceCaptureKey(ifindex)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		if f.ifaces == nil {
+			return nil
+		}
+		if err := f.ifaces.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to clear interface capture settings: %w", err)
+		}
+		return nil
+	}
+
+	value, err := utils.EncodeInterfaceCapture(*config)
+	if err != nil {
+		return err
+	}
+	if f.ifaces == nil {
+		return ErrNoInterfaceCapture
+	}
+	if err := f.ifaces.Update(key, value, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update interface capture settings: %w", err)
+	}
+	return nil
+}
+
+// ActiveInterface reads the capture settings of an interface back from the
+// kernel, or returns nil if it follows the global settings
+func (f *Filter) ActiveInterface(ifindex int) (*models.InterfaceCapture, error) {
+	key, err := utils.InterfaceCaptureKey(ifindex)
+	if err != nil || f.ifaces == nil {
+		return nil, err
+	}
+	var value utils.InterfaceCaptureValue
+	if err := f.ifaces.Lookup(key, &value); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read interface capture settings: %w", err)
+	}
+	config := utils.DecodeInterfaceCapture(value)
+	return &config, nil
+}
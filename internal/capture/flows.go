@@ -0,0 +1,233 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// FlowSweepInterval is how often the flows map is swept. A flow that saw no
+// packet for a whole interval is idle: what it counted since its last summary
+// is reported and its entry deleted.
+const FlowSweepInterval = 5 * time.Second
+
+// flowKey mirrors struct flow_key in the BPF program
+type flowKey struct {
+	SrcIP    [4]byte // Network byte order
+	DstIP    [4]byte
+	SrcPort  [2]byte
+	DstPort  [2]byte
+	Protocol uint8
+	Pad      [3]uint8
+}
+
+// flowCounters mirrors struct flow_counters in the BPF program
+type flowCounters struct {
+	Packets uint64
+	Bytes   uint64
+	FirstNs uint64
+	LastNs  uint64
+	SrcMac  [6]byte
+	DstMac  [6]byte
+	IfIndex uint32
+}
+
+// Flows sweeps the kernel-side flow aggregation map. The BPF program sends a
+// summary when a flow reaches the packet threshold or ends; counts left below
+// the threshold when a flow goes quiet, or on CPUs that didn't see its end,
+// are only found by the sweep.
+type Flows struct {
+	flows *ebpf.Map
+	seen  map[flowKey]uint64 // Last packet time of each flow at the previous sweep
+}
+
+// NewFlows returns the sweeper of a loaded collection, or nil if its object
+// predates flow aggregation
+func NewFlows(coll *ebpf.Collection) *Flows {
+	flows := coll.Maps["flows"]
+	if flows == nil {
+		return nil
+	}
+	return &Flows{flows: flows, seen: make(map[flowKey]uint64)}
+}
+
+// Sweep reports and deletes the flows idle since the previous sweep, or every
+// flow if all is set
+func (f *Flows) Sweep(all bool) ([]*models.FlowSummaryEvent, error) {
+	var idle []flowKey
+	seen := make(map[flowKey]uint64, len(f.seen))
+
+	var key flowKey
+	var perCPU []flowCounters
+	entries := f.flows.Iterate()
+	for entries.Next(&key, &perCPU) {
+		var last uint64
+		for _, c := range perCPU {
+			last = max(last, c.LastNs)
+		}
+		if previous, ok := f.seen[key]; all || ok && previous == last {
+			idle = append(idle, key)
+		} else {
+			seen[key] = last
+		}
+	}
+	if err := entries.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read flows: %w", err)
+	}
+	f.seen = seen
+
+	var summaries []*models.FlowSummaryEvent
+	for _, key := range idle {
+		// A packet counted between the lookup and the delete is lost
+		if err := f.flows.Lookup(&key, &perCPU); err != nil {
+			continue
+		}
+		if err := f.flows.Delete(&key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return summaries, fmt.Errorf("failed to delete flow: %w", err)
+		}
+		if summary := mergeFlowCounters(key, perCPU); summary != nil {
+			summaries = append(summaries, summary)
+		}
+	}
+	return summaries, nil
+}
+
+// mergeFlowCounters sums what the CPUs counted of a flow into one summary, or
+// returns nil if they counted nothing
+func mergeFlowCounters(key flowKey, perCPU []flowCounters) *models.FlowSummaryEvent {
+	summary := &models.FlowSummaryEvent{
+		SrcIP:    binary.BigEndian.Uint32(key.SrcIP[:]),
+		DstIP:    binary.BigEndian.Uint32(key.DstIP[:]),
+		SrcPort:  binary.BigEndian.Uint16(key.SrcPort[:]),
+		DstPort:  binary.BigEndian.Uint16(key.DstPort[:]),
+		Protocol: key.Protocol,
+		Reason:   models.FlowSummaryIdle,
+	}
+	var first, last uint64
+	for _, c := range perCPU {
+		if c.Packets == 0 {
+			continue
+		}
+		if summary.Packets == 0 {
+			summary.SrcMac, summary.DstMac, summary.IfIndex = c.SrcMac, c.DstMac, c.IfIndex
+			first = c.FirstNs
+		}
+		summary.Packets += uint32(min(c.Packets, 1<<32-1))
+		summary.Bytes += c.Bytes
+		first, last = min(first, c.FirstNs), max(last, c.LastNs)
+	}
+	if summary.Packets == 0 {
+		return nil
+	}
+	summary.Duration = time.Duration(last - first)
+	return summary
+}
+
+// flowMax is the capacity of the flows map, FLOW_MAX in the BPF program
+const flowMax = 8192
+
+// decodedFlow is a flow counted by the Decoder, as struct flow_counters is
+// in the kernel
+type decodedFlow struct {
+	summary models.FlowSummaryEvent // Addresses, and the packets and bytes counted since the last summary
+	first   time.Time               // First packet counted since the last summary
+	last    time.Time               // Last packet seen
+}
+
+// decodedFlowKey returns the flows key of a TCP frame: its addresses and ports
+func decodedFlowKey(f *frame) string {
+	offset := transportOffset(f)
+	return string(f.data[26:34]) + string(f.data[offset:offset+4])
+}
+
+// countFlow counts an established TCP packet instead of sending its event,
+// returning the summary due, if any. counted is false when the event must be
+// sent: aggregation is off, or it is the first packet seen of the flow.
+func (d *Decoder) countFlow(f *frame, evt *models.NetworkEvent) (summary *models.FlowSummaryEvent, counted bool) {
+	if d.flowConfig.Packets == 0 {
+		return nil, false
+	}
+	key := decodedFlowKey(f)
+	flow := d.flows[key]
+	if flow == nil {
+		if len(d.flows) >= flowMax {
+			d.evictFlow()
+		}
+		d.flows[key] = &decodedFlow{
+			summary: models.FlowSummaryEvent{
+				SrcIP: evt.SrcIP, DstIP: evt.DstIP, SrcPort: evt.SrcPort, DstPort: evt.DstPort,
+				Protocol: evt.Protocol,
+			},
+			last: f.at,
+		}
+		return nil, false
+	}
+
+	if flow.summary.Packets == 0 {
+		flow.first = f.at
+		flow.summary.SrcMac, flow.summary.DstMac, flow.summary.IfIndex = evt.SrcMac, evt.DstMac, evt.IfIndex
+	}
+	flow.summary.Packets++
+	flow.summary.Bytes += uint64(f.length)
+	flow.last = f.at
+
+	interval := time.Duration(d.flowConfig.IntervalMs) * time.Millisecond
+	switch {
+	case flow.summary.Packets >= d.flowConfig.Packets:
+		return flow.flush(models.FlowSummaryPackets), true
+	case interval > 0 && f.at.Sub(flow.first) >= interval:
+		return flow.flush(models.FlowSummaryInterval), true
+	}
+	return nil, true
+}
+
+// endFlow returns the summary of a flow ending with a FIN or RST, if it
+// counted packets since its last summary
+func (d *Decoder) endFlow(f *frame) *models.FlowSummaryEvent {
+	flow := d.flows[decodedFlowKey(f)]
+	if flow == nil || flow.summary.Packets == 0 {
+		return nil
+	}
+	return flow.flush(models.FlowSummaryEnd)
+}
+
+// evictFlow drops the least recently seen flow, as the LRU map does
+func (d *Decoder) evictFlow() {
+	oldest := ""
+	for key, flow := range d.flows {
+		if oldest == "" || flow.last.Before(d.flows[oldest].last) {
+			oldest = key
+		}
+	}
+	delete(d.flows, oldest)
+}
+
+// flush returns what was counted of a flow and starts counting anew
+func (flow *decodedFlow) flush(reason uint8) *models.FlowSummaryEvent {
+	summary := flow.summary
+	summary.Reason = reason
+	summary.Duration = flow.last.Sub(flow.first)
+	flow.summary.Packets, flow.summary.Bytes = 0, 0
+	return &summary
+}
+
+// FlushFlows returns the summaries of every flow still counted and forgets
+// the flows, as the final sweep of a capture does
+func (d *Decoder) FlushFlows() []*models.FlowSummaryEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var summaries []*models.FlowSummaryEvent
+	for _, flow := range d.flows {
+		if flow.summary.Packets > 0 {
+			summaries = append(summaries, flow.flush(models.FlowSummaryIdle))
+		}
+	}
+	d.flows = make(map[string]*decodedFlow)
+	return summaries
+}
@@ -0,0 +1,395 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Ports and record sizes of the BPF program, see ebpf/cerberus_tc.c
+const (
+	dnsPort      = 53
+	httpPort     = 80
+	httpAltPort  = 8080
+	httpsPort    = 443
+	httpsAltPort = 8443
+
+	dnsHeaderSize = 12
+)
+
+// Frame holds the records the BPF program emits for one frame. Any of them
+// may be nil.
+type Frame struct {
+	Event       *models.NetworkEvent
+	TLSHello    *models.TLSHelloEvent
+	HTTPRequest *models.HTTPRequestEvent
+	DNSQuery    *models.DNSQueryEvent
+	FlowSummary *models.FlowSummaryEvent
+}
+
+// Decoder turns Ethernet frames into the records the BPF program would emit
+// for them, filtered by a capture configuration the way its maps filter them.
+// It is the monitor's CaptureControl when replaying a capture file.
+type Decoder struct {
+	mu          sync.Mutex
+	filter      map[uint8]uint8 // Event type -> utils.EventFilter* flags
+	subnetKeys  []utils.SubnetFilterKey
+	subnets     []*net.IPNet
+	limits      map[uint8]uint16
+	eventLimits map[uint8]uint16
+	flowConfig  utils.FlowConfig
+	flows       map[string]*decodedFlow // Established TCP flows being counted, by 4-tuple
+	suppressed  map[uint8]uint64        // Event type -> events filtered out
+}
+
+// NewDecoder returns a decoder capturing every event type, with the default
+// payload lengths
+func NewDecoder() *Decoder {
+	d := &Decoder{flows: make(map[string]*decodedFlow), suppressed: make(map[uint8]uint64)}
+	d.Apply(models.CaptureConfig{})
+	return d
+}
+
+// Apply replaces the capture configuration
+func (d *Decoder) Apply(config models.CaptureConfig) error {
+	filter, err := utils.EncodeEventFilter(config)
+	if err != nil {
+		return err
+	}
+	keys, err := utils.EncodeSubnetFilter(config.Subnets)
+	if err != nil {
+		return err
+	}
+	limits, err := utils.EncodePayloadLimits(config.PayloadBytes)
+	if err != nil {
+		return err
+	}
+	eventLimits, err := utils.EncodeEventPayloadLimits(config.EventPayloadBytes)
+	if err != nil {
+		return err
+	}
+	flowConfig, err := utils.EncodeFlowConfig(config)
+	if err != nil {
+		return err
+	}
+
+	subnets := make([]*net.IPNet, 0, len(keys))
+	for _, key := range keys {
+		subnets = append(subnets, &net.IPNet{
+			IP:   net.IP(key.Addr[:]),
+			Mask: net.CIDRMask(int(key.PrefixLen), 32),
+		})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.filter, d.subnetKeys, d.subnets = filter, keys, subnets
+	d.limits, d.eventLimits, d.flowConfig = limits, eventLimits, flowConfig
+	return nil
+}
+
+// Active returns the capture configuration in effect
+func (d *Decoder) Active() (models.CaptureConfig, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	config := utils.DecodeEventFilter(d.filter)
+	config.Subnets = []string{}
+	if len(d.subnetKeys) > 0 {
+		config.Subnets = utils.DecodeSubnetFilter(d.subnetKeys)
+	}
+	config.PayloadBytes = utils.DecodePayloadLimits(d.limits)
+	config.EventPayloadBytes = utils.DecodeEventPayloadLimits(d.eventLimits)
+	config.FlowPackets = int(d.flowConfig.Packets)
+	config.FlowIntervalMs = int(d.flowConfig.IntervalMs)
+	return config, nil
+}
+
+// Suppressed returns the events filtered out per event type
+func (d *Decoder) Suppressed() (map[string]uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	suppressed := make(map[string]uint64, len(models.EventTypeNames))
+	for t, name := range models.EventTypeNames {
+		suppressed[name] = d.suppressed[t]
+	}
+	return suppressed, nil
+}
+
+// frame is a frame being decoded: the captured bytes, the length of the
+// frame on the wire, which may be longer, and when it was captured
+type frame struct {
+	data    []byte
+	length  int
+	ifindex uint32
+	at      time.Time
+}
+
+// pktLen returns the frame length as recorded in events
+func (f *frame) pktLen() uint16 {
+	return uint16(min(f.length, 0xffff))
+}
+
+// payload returns up to limit bytes of the frame from offset
+func (f *frame) payload(offset, limit int) []byte {
+	if offset >= len(f.data) || limit <= 0 {
+		return nil
+	}
+	end := min(len(f.data), offset+limit)
+	return append([]byte(nil), f.data[offset:end]...)
+}
+
+// Decode returns the records of an Ethernet frame. data is the captured part
+// of the frame, length its length on the wire and at when it was captured.
+func (d *Decoder) Decode(data []byte, length int, ifindex uint32, at time.Time) Frame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f := &frame{data: data, length: max(length, len(data)), ifindex: ifindex, at: at}
+	if len(data) < 14 {
+		return Frame{}
+	}
+	switch binary.BigEndian.Uint16(data[12:14]) {
+	case 0x0806:
+		return d.decodeARP(f)
+	case 0x0800:
+		if len(data) < 34 {
+			return Frame{}
+		}
+		switch data[23] {
+		case 6:
+			return d.decodeTCP(f)
+		case 17:
+			return d.decodeUDP(f)
+		case 1:
+			return d.decodeICMP(f)
+		}
+	}
+	return Frame{}
+}
+
+func (d *Decoder) disabled(eventType uint8) bool {
+	return d.filter[eventType]&utils.EventFilterDisabled != 0
+}
+
+// subnetWanted reports whether an event between two addresses passes the
+// subnet filter
+func (d *Decoder) subnetWanted(src, dst []byte) bool {
+	if len(d.subnets) == 0 {
+		return true
+	}
+	for _, subnet := range d.subnets {
+		if subnet.Contains(src) || subnet.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadLimit returns the side record length of an event type, as
+// payload_limit does
+func (d *Decoder) payloadLimit(eventType uint8) int {
+	max := utils.PayloadBytesMax[eventType]
+	if limit := int(d.limits[eventType]); limit != 0 && limit <= max {
+		return limit
+	}
+	return max
+}
+
+// newEvent starts an event with the Ethernet and IPv4 fields of a frame
+func newEvent(f *frame, eventType uint8) *models.NetworkEvent {
+	evt := &models.NetworkEvent{EventType: eventType, IfIndex: f.ifindex, PacketLen: f.pktLen()}
+	copy(evt.DstMac[:], f.data[0:6])
+	copy(evt.SrcMac[:], f.data[6:12])
+	if eventType != models.EVENT_TYPE_ARP {
+		evt.Protocol = f.data[23]
+		evt.IPTTL = f.data[22]
+		evt.SrcIP = binary.BigEndian.Uint32(f.data[26:30])
+		evt.DstIP = binary.BigEndian.Uint32(f.data[30:34])
+	}
+	return evt
+}
+
+func (d *Decoder) decodeARP(f *frame) Frame {
+	if d.disabled(models.EVENT_TYPE_ARP) {
+		d.suppressed[models.EVENT_TYPE_ARP]++
+		return Frame{}
+	}
+	arp := f.data[14:]
+	if len(arp) < 28 ||
+		binary.BigEndian.Uint16(arp[0:2]) != 1 || binary.BigEndian.Uint16(arp[2:4]) != 0x0800 ||
+		arp[4] != 6 || arp[5] != 4 {
+		return Frame{}
+	}
+	if !d.subnetWanted(arp[14:18], arp[24:28]) {
+		d.suppressed[models.EVENT_TYPE_ARP]++
+		return Frame{}
+	}
+
+	evt := newEvent(f, models.EVENT_TYPE_ARP)
+	evt.ArpOp = binary.BigEndian.Uint16(arp[6:8])
+	copy(evt.ArpSha[:], arp[8:14])
+	evt.SrcIP = binary.BigEndian.Uint32(arp[14:18])
+	copy(evt.ArpTha[:], arp[18:24])
+	evt.DstIP = binary.BigEndian.Uint32(arp[24:28])
+	return Frame{Event: evt}
+}
+
+// transportOffset returns the offset of the IPv4 payload
+func transportOffset(f *frame) int {
+	return 14 + int(f.data[14]&0x0f)*4
+}
+
+func (d *Decoder) decodeTCP(f *frame) Frame {
+	offset := transportOffset(f)
+	if len(f.data) < offset+20 {
+		return Frame{}
+	}
+	tcp := f.data[offset:]
+	srcPort := binary.BigEndian.Uint16(tcp[0:2])
+	dstPort := binary.BigEndian.Uint16(tcp[2:4])
+	flags := tcp[13] & 0x1f // FIN, SYN, RST, PSH and ACK, at the bits of TCPFlags
+
+	// Plain TCP events can be limited to connection setup and teardown
+	tcpFilter := d.filter[models.EVENT_TYPE_TCP]
+	tcpWanted := tcpFilter&utils.EventFilterDisabled == 0 &&
+		(tcpFilter&utils.EventFilterControlOnly == 0 || flags&0x07 != 0)
+	isPort := func(a, b uint16) bool {
+		return srcPort == a || srcPort == b || dstPort == a || dstPort == b
+	}
+	httpPorts, httpsPorts := isPort(httpPort, httpAltPort), isPort(httpsPort, httpsAltPort)
+	if !d.subnetWanted(f.data[26:30], f.data[30:34]) ||
+		(!tcpWanted && (!(httpPorts || httpsPorts) ||
+			(d.disabled(models.EVENT_TYPE_HTTP) && d.disabled(models.EVENT_TYPE_TLS)))) {
+		d.suppressed[models.EVENT_TYPE_TCP]++
+		return Frame{}
+	}
+
+	evt := newEvent(f, models.EVENT_TYPE_TCP)
+	evt.SrcPort, evt.DstPort = srcPort, dstPort
+	evt.TCPFlags = flags
+	evt.TCPWindow = binary.BigEndian.Uint16(tcp[14:16])
+
+	// Classify by the start of the TCP payload
+	payloadOffset := offset + int(tcp[12]>>4)*4
+	var payload []byte
+	if payloadOffset < len(f.data) {
+		payload = f.data[payloadOffset:]
+	}
+	clientHello := false
+	if httpPorts && isHTTPRequest(payload) {
+		evt.EventType = models.EVENT_TYPE_HTTP
+	}
+	if httpsPorts && len(payload) >= 6 && payload[0] == 0x16 && payload[1] == 0x03 && payload[2] <= 0x04 {
+		evt.EventType = models.EVENT_TYPE_TLS
+		clientHello = payload[5] == 0x01
+	}
+
+	// Final type is only known after payload inspection
+	if evt.EventType == models.EVENT_TYPE_TCP && !tcpWanted ||
+		evt.EventType != models.EVENT_TYPE_TCP && d.disabled(evt.EventType) {
+		d.suppressed[evt.EventType]++
+		return Frame{}
+	}
+
+	// Established flows are counted instead of sent per packet, as count_flow
+	// and end_flow do
+	var summary *models.FlowSummaryEvent
+	if evt.EventType == models.EVENT_TYPE_TCP {
+		if flags&0x05 != 0 {
+			summary = d.endFlow(f)
+		} else if flags&0x02 == 0 {
+			var counted bool
+			if summary, counted = d.countFlow(f, evt); counted {
+				return Frame{FlowSummary: summary}
+			}
+		}
+	}
+	evt.L7Payload = f.payload(payloadOffset, min(int(d.eventLimits[evt.EventType]), utils.EventPayloadMax))
+
+	result := Frame{Event: evt, FlowSummary: summary}
+	if clientHello {
+		if data := f.payload(payloadOffset, d.payloadLimit(models.EVENT_TYPE_TLS)); len(data) > 0 {
+			result.TLSHello = &models.TLSHelloEvent{
+				SrcMac: evt.SrcMac, SrcIP: evt.SrcIP, DstIP: evt.DstIP,
+				SrcPort: srcPort, DstPort: dstPort, Data: data,
+			}
+		}
+	} else if evt.EventType == models.EVENT_TYPE_HTTP {
+		if data := f.payload(payloadOffset, d.payloadLimit(models.EVENT_TYPE_HTTP)); len(data) > 0 {
+			result.HTTPRequest = &models.HTTPRequestEvent{
+				SrcMac: evt.SrcMac, SrcIP: evt.SrcIP, DstIP: evt.DstIP,
+				SrcPort: srcPort, DstPort: dstPort, Data: data,
+			}
+		}
+	}
+	return result
+}
+
+// isHTTPRequest reports whether a TCP payload starts with a request method
+// the BPF program recognizes
+func isHTTPRequest(payload []byte) bool {
+	if len(payload) < 4 {
+		return false
+	}
+	switch string(payload[:4]) {
+	case "GET ", "POST", "HEAD", "PUT ", "DELE":
+		return true
+	}
+	return false
+}
+
+func (d *Decoder) decodeUDP(f *frame) Frame {
+	offset := transportOffset(f)
+	if len(f.data) < offset+8 {
+		return Frame{}
+	}
+	udp := f.data[offset:]
+	srcPort := binary.BigEndian.Uint16(udp[0:2])
+	dstPort := binary.BigEndian.Uint16(udp[2:4])
+
+	eventType := uint8(models.EVENT_TYPE_UDP)
+	if srcPort == dnsPort || dstPort == dnsPort {
+		eventType = models.EVENT_TYPE_DNS
+	}
+	if d.disabled(eventType) || !d.subnetWanted(f.data[26:30], f.data[30:34]) {
+		d.suppressed[eventType]++
+		return Frame{}
+	}
+
+	evt := newEvent(f, eventType)
+	evt.SrcPort, evt.DstPort = srcPort, dstPort
+	payloadOffset := offset + 8
+	evt.L7Payload = f.payload(payloadOffset, min(int(d.eventLimits[eventType]), utils.EventPayloadMax))
+
+	result := Frame{Event: evt}
+	// QR bit clear = query to a server, set = response from one
+	qr := len(udp) >= 11 && udp[10]&0x80 != 0
+	if eventType == models.EVENT_TYPE_DNS && (dstPort == dnsPort && !qr || srcPort == dnsPort && qr) {
+		if data := f.payload(payloadOffset, d.payloadLimit(models.EVENT_TYPE_DNS)); len(data) >= dnsHeaderSize {
+			result.DNSQuery = &models.DNSQueryEvent{SrcMac: evt.SrcMac, SrcIP: evt.SrcIP, DstIP: evt.DstIP, Data: data}
+		}
+	}
+	return result
+}
+
+func (d *Decoder) decodeICMP(f *frame) Frame {
+	offset := transportOffset(f)
+	if len(f.data) < offset+8 {
+		return Frame{}
+	}
+	if d.disabled(models.EVENT_TYPE_ICMP) || !d.subnetWanted(f.data[26:30], f.data[30:34]) {
+		d.suppressed[models.EVENT_TYPE_ICMP]++
+		return Frame{}
+	}
+
+	evt := newEvent(f, models.EVENT_TYPE_ICMP)
+	evt.ICMPType = f.data[offset]
+	evt.ICMPCode = f.data[offset+1]
+	return Frame{Event: evt}
+}
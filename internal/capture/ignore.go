@@ -0,0 +1,201 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// ErrNoIgnoreList is returned when the BPF object predates the ignore list
+var ErrNoIgnoreList = errors.New("BPF maps 'ignore_macs', 'ignore_cidrs' and 'ignore_hits' not found, rebuild the BPF program to drop ignored traffic in the kernel")
+
+// ignoreMap is the part of an *ebpf.Map the ignore list uses, so it can be
+// synced against a fake
+type ignoreMap interface {
+	Update(key, value any, flags ebpf.MapUpdateFlags) error
+	Delete(key any) error
+	Lookup(key, valueOut any) error
+}
+
+// ignoreSlot is an ignore list entry as written to the maps
+type ignoreSlot struct {
+	key   any // utils.IgnoreMACKey in ignore_macs, or utils.SubnetFilterKey in ignore_cidrs
+	value utils.IgnoreValue
+}
+
+// ignoreList writes the ignore list to the ignore_macs and ignore_cidrs maps.
+// It remembers what it wrote, so a sync only writes the entries that changed
+// and the counters of the others keep counting.
+type ignoreList struct {
+	macs  ignoreMap
+	cidrs ignoreMap
+	hits  ignoreMap // Per-CPU counters by slot
+	cpus  int
+
+	synced map[string]ignoreSlot // By entry ID
+	free   []uint32              // Unused slots
+}
+
+func newIgnoreList(macs, cidrs, hits ignoreMap, cpus int) *ignoreList {
+	l := &ignoreList{
+		macs:   macs,
+		cidrs:  cidrs,
+		hits:   hits,
+		cpus:   cpus,
+		synced: make(map[string]ignoreSlot),
+		free:   make([]uint32, 0, utils.IgnoreMax),
+	}
+	for slot := utils.IgnoreMax - 1; slot >= 0; slot-- {
+		l.free = append(l.free, uint32(slot))
+	}
+	return l
+}
+
+// loadIgnoreList returns the ignore list over the maps of a loaded collection,
+// or nil with BPF objects predating it. Entries left in pinned maps by a
+// previous run are removed.
+func loadIgnoreList(coll *ebpf.Collection) (*ignoreList, error) {
+	macs, cidrs, hits := coll.Maps["ignore_macs"], coll.Maps["ignore_cidrs"], coll.Maps["ignore_hits"]
+	if macs == nil || cidrs == nil || hits == nil {
+		return nil, nil
+	}
+	cpus, err := ebpf.PossibleCPU()
+	if err != nil {
+		return nil, err
+	}
+
+	var macKey utils.IgnoreMACKey
+	var cidrKey utils.SubnetFilterKey
+	var value utils.IgnoreValue
+	for _, stale := range []struct {
+		m   *ebpf.Map
+		key any
+	}{{macs, &macKey}, {cidrs, &cidrKey}} {
+		var keys []any
+		entries := stale.m.Iterate()
+		for entries.Next(stale.key, &value) {
+			switch key := stale.key.(type) {
+			case *utils.IgnoreMACKey:
+				keys = append(keys, *key)
+			case *utils.SubnetFilterKey:
+				keys = append(keys, *key)
+			}
+		}
+		if err := entries.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read ignore list: %w", err)
+		}
+		for _, key := range keys {
+			if err := stale.m.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				return nil, fmt.Errorf("failed to clear ignore list: %w", err)
+			}
+		}
+	}
+	return newIgnoreList(macs, cidrs, hits, cpus), nil
+}
+
+// encodeIgnoreEntry returns the map key and value of an entry, with no slot
+func encodeIgnoreEntry(entry models.IgnoreEntry) (ignoreSlot, error) {
+	scope, err := utils.EncodeIgnoreScope(entry.Scope)
+	if err != nil {
+		return ignoreSlot{}, err
+	}
+	slot := ignoreSlot{value: utils.IgnoreValue{Scope: scope}}
+	if entry.MAC != "" {
+		slot.key, err = utils.EncodeIgnoreMAC(entry.MAC)
+	} else {
+		slot.key, err = utils.EncodeIgnoreCIDR(entry.CIDR)
+	}
+	return slot, err
+}
+
+// mapOf returns the map holding a key
+func (l *ignoreList) mapOf(key any) ignoreMap {
+	if _, ok := key.(utils.IgnoreMACKey); ok {
+		return l.macs
+	}
+	return l.cidrs
+}
+
+// sync writes the entries to the maps, removing those no longer listed. A
+// new entry's counter starts from zero; an entry whose address or scope
+// changed counts as new.
+func (l *ignoreList) sync(entries []models.IgnoreEntry) error {
+	if len(entries) > utils.IgnoreMax {
+		return fmt.Errorf("at most %d entries can be ignored", utils.IgnoreMax)
+	}
+	wanted := make(map[string]ignoreSlot, len(entries))
+	for _, entry := range entries {
+		slot, err := encodeIgnoreEntry(entry)
+		if err != nil {
+			return fmt.Errorf("ignore entry %s: %w", entry.ID, err)
+		}
+		wanted[entry.ID] = slot
+	}
+
+	// Removals first, so a key moving to a new entry isn't deleted after
+	// being written
+	for id, synced := range l.synced {
+		if want, ok := wanted[id]; ok && want.key == synced.key && want.value.Scope == synced.value.Scope {
+			continue
+		}
+		if err := l.mapOf(synced.key).Delete(synced.key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to update ignore list: %w", err)
+		}
+		delete(l.synced, id)
+		l.free = append(l.free, synced.value.Slot)
+	}
+
+	for _, entry := range entries {
+		if _, ok := l.synced[entry.ID]; ok {
+			continue
+		}
+		slot := wanted[entry.ID]
+		slot.value.Slot = l.free[len(l.free)-1]
+		if err := l.hits.Update(slot.value.Slot, make([]uint64, l.cpus), ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to reset ignore counter: %w", err)
+		}
+		if err := l.mapOf(slot.key).Update(slot.key, slot.value, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update ignore list: %w", err)
+		}
+		l.free = l.free[:len(l.free)-1]
+		l.synced[entry.ID] = slot
+	}
+	return nil
+}
+
+// counters sums the per-CPU counters of every synced entry, by entry ID
+func (l *ignoreList) counters() (map[string]uint64, error) {
+	counts := make(map[string]uint64, len(l.synced))
+	for id, synced := range l.synced {
+		var perCPU []uint64
+		if err := l.hits.Lookup(synced.value.Slot, &perCPU); err != nil {
+			return nil, fmt.Errorf("failed to read ignore counters: %w", err)
+		}
+		for _, count := range perCPU {
+			counts[id] += count
+		}
+	}
+	return counts, nil
+}
+
+// SyncIgnore writes the ignore list to the kernel, which drops its traffic
+// from the next packet on
+func (f *Filter) SyncIgnore(entries []models.IgnoreEntry) error {
+	if f.ignore == nil {
+		return ErrNoIgnoreList
+	}
+	return f.ignore.sync(entries)
+}
+
+// IgnoreCounters returns the events the kernel dropped for each ignore list
+// entry, by entry ID
+func (f *Filter) IgnoreCounters() (map[string]uint64, error) {
+	if f.ignore == nil {
+		return nil, ErrNoIgnoreList
+	}
+	return f.ignore.counters()
+}
@@ -0,0 +1,255 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// fakeMap is an ignoreMap held in memory, counting the writes made to it
+type fakeMap struct {
+	entries map[any]any
+	updates int
+	deletes int
+	err     error // Returned by every call if set
+}
+
+func newFakeMap() *fakeMap {
+	return &fakeMap{entries: make(map[any]any)}
+}
+
+func (m *fakeMap) Update(key, value any, flags ebpf.MapUpdateFlags) error {
+	if m.err != nil {
+		return m.err
+	}
+	if counters, ok := value.([]uint64); ok {
+		value = slices.Clone(counters)
+	}
+	m.entries[key] = value
+	m.updates++
+	return nil
+}
+
+func (m *fakeMap) Delete(key any) error {
+	if m.err != nil {
+		return m.err
+	}
+	if _, ok := m.entries[key]; !ok {
+		return ebpf.ErrKeyNotExist
+	}
+	delete(m.entries, key)
+	m.deletes++
+	return nil
+}
+
+func (m *fakeMap) Lookup(key, valueOut any) error {
+	if m.err != nil {
+		return m.err
+	}
+	value, ok := m.entries[key]
+	if !ok {
+		return ebpf.ErrKeyNotExist
+	}
+	switch out := valueOut.(type) {
+	case *[]uint64:
+		*out = slices.Clone(value.([]uint64))
+	case *utils.IgnoreValue:
+		*out = value.(utils.IgnoreValue)
+	default:
+		return fmt.Errorf("unexpected value type %T", valueOut)
+	}
+	return nil
+}
+
+// fakeIgnoreList is an ignore list over fake maps, counting on two CPUs
+type fakeIgnoreList struct {
+	*ignoreList
+	macs, cidrs, hits *fakeMap
+}
+
+func newFakeIgnoreList() *fakeIgnoreList {
+	l := &fakeIgnoreList{macs: newFakeMap(), cidrs: newFakeMap(), hits: newFakeMap()}
+	l.ignoreList = newIgnoreList(l.macs, l.cidrs, l.hits, 2)
+	return l
+}
+
+// value returns the map value of an entry, failing if it isn't synced
+func (l *fakeIgnoreList) value(t *testing.T, entry models.IgnoreEntry) utils.IgnoreValue {
+	t.Helper()
+	slot, err := encodeIgnoreEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := l.cidrs
+	if entry.MAC != "" {
+		m = l.macs
+	}
+	value, ok := m.entries[slot.key].(utils.IgnoreValue)
+	if !ok {
+		t.Fatalf("%s not in the map", entry.ID)
+	}
+	return value
+}
+
+// count sets the per-CPU counters of an entry's slot
+func (l *fakeIgnoreList) count(t *testing.T, entry models.IgnoreEntry, perCPU ...uint64) {
+	t.Helper()
+	l.hits.entries[l.value(t, entry).Slot] = perCPU
+}
+
+// Sync writes new and changed entries to their map in a free slot, with a
+// zeroed counter, removes those no longer listed and leaves the others alone
+func TestIgnoreListSync(t *testing.T) {
+	l := newFakeIgnoreList()
+	nas := models.IgnoreEntry{ID: "i-1", MAC: "AA:BB:CC:DD:EE:01", Scope: models.IgnoreScopeDataPlane}
+	cdn := models.IgnoreEntry{ID: "i-2", CIDR: "151.101.0.0/16"}
+	tv := models.IgnoreEntry{ID: "i-3", MAC: "aa:bb:cc:dd:ee:02"}
+
+	if err := l.sync([]models.IgnoreEntry{nas, cdn, tv}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.macs.entries) != 2 || len(l.cidrs.entries) != 1 || len(l.hits.entries) != 3 {
+		t.Fatalf("synced %d MACs, %d CIDRs and %d counters", len(l.macs.entries), len(l.cidrs.entries), len(l.hits.entries))
+	}
+	if v := l.value(t, nas); v.Scope != utils.IgnoreScopeDataPlane {
+		t.Errorf("%s scope = %d, want data plane", nas.ID, v.Scope)
+	}
+	key := utils.SubnetFilterKey{PrefixLen: 16, Addr: [4]byte{151, 101, 0, 0}}
+	if _, ok := l.cidrs.entries[key]; !ok {
+		t.Errorf("CIDR key missing: %v", l.cidrs.entries)
+	}
+	slots := map[uint32]bool{}
+	for _, entry := range []models.IgnoreEntry{nas, cdn, tv} {
+		slot := l.value(t, entry).Slot
+		if slots[slot] {
+			t.Errorf("slot %d used twice", slot)
+		}
+		slots[slot] = true
+		if counters := l.hits.entries[slot].([]uint64); !slices.Equal(counters, []uint64{0, 0}) {
+			t.Errorf("%s counters = %v, want zeroed per CPU", entry.ID, counters)
+		}
+	}
+
+	// Unchanged entries are not rewritten, so they keep counting
+	l.count(t, nas, 5, 1)
+	l.count(t, cdn, 7, 0)
+	writes := l.macs.updates + l.cidrs.updates + l.hits.updates
+	if err := l.sync([]models.IgnoreEntry{nas, cdn, tv}); err != nil {
+		t.Fatal(err)
+	}
+	if again := l.macs.updates + l.cidrs.updates + l.hits.updates; again != writes {
+		t.Errorf("resyncing the same entries made %d writes", again-writes)
+	}
+
+	// A changed scope counts as a new entry; a removed one frees its slot
+	nasSlot, tvSlot := l.value(t, nas).Slot, l.value(t, tv).Slot
+	nas.Scope = models.IgnoreScopeAll
+	if err := l.sync([]models.IgnoreEntry{nas, cdn}); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.value(t, nas); v.Scope != utils.IgnoreScopeAll || !slices.Equal(l.hits.entries[v.Slot].([]uint64), []uint64{0, 0}) {
+		t.Errorf("changed entry = %+v with counters %v, want scope all from zero", v, l.hits.entries[v.Slot])
+	}
+	if len(l.macs.entries) != 1 || len(l.cidrs.entries) != 1 {
+		t.Errorf("%d MACs and %d CIDRs left", len(l.macs.entries), len(l.cidrs.entries))
+	}
+	// The changed entry took one of the freed slots, the other is free
+	if newSlot := l.value(t, nas).Slot; (newSlot != nasSlot && newSlot != tvSlot) || !slices.Contains(l.free, nasSlot+tvSlot-newSlot) {
+		t.Errorf("changed entry in slot %d, slots %d and %d freed", newSlot, nasSlot, tvSlot)
+	}
+	if len(l.free)+len(l.synced) != utils.IgnoreMax {
+		t.Errorf("%d free and %d used slots, want %d", len(l.free), len(l.synced), utils.IgnoreMax)
+	}
+
+	// A key moving to another entry ends up in the map
+	moved := models.IgnoreEntry{ID: "i-4", CIDR: cdn.CIDR}
+	if err := l.sync([]models.IgnoreEntry{nas, moved}); err != nil {
+		t.Fatal(err)
+	}
+	if v := l.value(t, moved); v.Slot != l.synced[moved.ID].value.Slot {
+		t.Errorf("moved key has slot %d, want %d", v.Slot, l.synced[moved.ID].value.Slot)
+	}
+
+	if err := l.sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.macs.entries) != 0 || len(l.cidrs.entries) != 0 || len(l.free) != utils.IgnoreMax {
+		t.Errorf("after clearing: %v, %v, %d free", l.macs.entries, l.cidrs.entries, len(l.free))
+	}
+}
+
+// Bad entries, too many entries and map errors fail the sync
+func TestIgnoreListSyncErrors(t *testing.T) {
+	l := newFakeIgnoreList()
+	for _, bad := range []models.IgnoreEntry{
+		{ID: "i-1", MAC: "not a mac"},
+		{ID: "i-1", CIDR: "2001:db8::/32"},
+		{ID: "i-1", CIDR: "10.0.0.0/8", Scope: "some"},
+	} {
+		if err := l.sync([]models.IgnoreEntry{bad}); err == nil {
+			t.Errorf("synced %+v", bad)
+		}
+	}
+
+	many := make([]models.IgnoreEntry, utils.IgnoreMax+1)
+	for i := range many {
+		many[i] = models.IgnoreEntry{ID: fmt.Sprintf("i-%d", i), CIDR: fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)}
+	}
+	if err := l.sync(many); err == nil {
+		t.Error("synced more entries than the maps hold")
+	}
+	if err := l.sync(many[:utils.IgnoreMax]); err != nil {
+		t.Errorf("syncing a full list: %v", err)
+	}
+
+	l = newFakeIgnoreList()
+	l.hits.err = errors.New("map full")
+	if err := l.sync([]models.IgnoreEntry{{ID: "i-1", CIDR: "10.0.0.0/8"}}); err == nil {
+		t.Error("sync ignored a counter error")
+	}
+	if len(l.cidrs.entries) != 0 || len(l.synced) != 0 || len(l.free) != utils.IgnoreMax {
+		t.Errorf("failed entry left synced: %v, %d free", l.synced, len(l.free))
+	}
+}
+
+// Counters are the sum of every CPU's, by entry ID
+func TestIgnoreListCounters(t *testing.T) {
+	l := newFakeIgnoreList()
+	nas := models.IgnoreEntry{ID: "i-1", MAC: "aa:bb:cc:dd:ee:01"}
+	cdn := models.IgnoreEntry{ID: "i-2", CIDR: "151.101.0.0/16"}
+	if err := l.sync([]models.IgnoreEntry{nas, cdn}); err != nil {
+		t.Fatal(err)
+	}
+	if counts, err := l.counters(); err != nil || counts["i-1"] != 0 || counts["i-2"] != 0 || len(counts) != 2 {
+		t.Errorf("new counters = %v, %v", counts, err)
+	}
+
+	l.count(t, nas, 3, 4)
+	l.count(t, cdn, 0, 1<<40)
+	counts, err := l.counters()
+	if err != nil || counts["i-1"] != 7 || counts["i-2"] != 1<<40 {
+		t.Errorf("counters = %v, %v", counts, err)
+	}
+
+	l.hits.err = errors.New("bad fd")
+	if _, err := l.counters(); err == nil {
+		t.Error("counters ignored a lookup error")
+	}
+}
+
+// Filters of BPF objects predating the ignore list say so
+func TestFilterWithoutIgnoreList(t *testing.T) {
+	f := &Filter{}
+	if err := f.SyncIgnore(nil); !errors.Is(err, ErrNoIgnoreList) {
+		t.Errorf("SyncIgnore = %v", err)
+	}
+	if _, err := f.IgnoreCounters(); !errors.Is(err, ErrNoIgnoreList) {
+		t.Errorf("IgnoreCounters = %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/network"
+)
+
+// AttachCandidates returns the interfaces to attach to: those in attachSet
+// (every one if nil) that are up and not loopback
+func AttachCandidates(ifaces []net.Interface, attachSet map[string]bool) []net.Interface {
+	var candidates []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if attachSet != nil && !attachSet[iface.Name] {
+			continue
+		}
+		candidates = append(candidates, iface)
+	}
+	return candidates
+}
+
+// SelectInterfaces returns the set of interfaces to attach to, or nil for every
+// interface, with a note on how it was chosen by default. An explicit list wins,
+// otherwise the topology's recommended (physical, non-container) interfaces
+// are used.
+func SelectInterfaces(topo *network.NetworkTopology, list string, all bool) (map[string]bool, string, error) {
+	if all {
+		return nil, "Attaching to all interfaces (-all-interfaces)", nil
+	}
+
+	var names []string
+	if list != "" {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, err := net.InterfaceByName(name); err != nil {
+				return nil, "", fmt.Errorf("interface %q: %w", name, err)
+			}
+			names = append(names, name)
+		}
+	} else {
+		names = topo.RecommendedInterfaces()
+		if len(names) == 0 {
+			return nil, "Warning: no physical interface detected, attaching to all interfaces", nil
+		}
+	}
+
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	if list != "" {
+		return set, "", nil
+	}
+	return set, fmt.Sprintf("Attaching to recommended interfaces: %s (override with -interfaces or -all-interfaces)",
+		strings.Join(names, ", ")), nil
+}
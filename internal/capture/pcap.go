@@ -0,0 +1,86 @@
+package capture
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Magic numbers of classic pcap files, as read in the byte order they were
+// written in
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapngMagic     = 0x0a0d0d0a
+
+	linkTypeEthernet = 1
+
+	// pcapMaxSnapLen bounds a record so a corrupt length can't allocate
+	// gigabytes
+	pcapMaxSnapLen = 256 << 10
+)
+
+// PcapReader reads Ethernet frames from a classic pcap file, as written by
+// tcpdump -w. pcapng files are not supported.
+type PcapReader struct {
+	r     io.Reader
+	order binary.ByteOrder
+	nanos bool
+	hdr   [16]byte
+}
+
+// NewPcapReader reads the file header of a pcap stream
+func NewPcapReader(r io.Reader) (*PcapReader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	p := &PcapReader{r: r}
+	switch magic := binary.LittleEndian.Uint32(hdr[0:4]); {
+	case magic == pcapMagicMicros || magic == pcapMagicNanos:
+		p.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(hdr[0:4]) == pcapMagicMicros || binary.BigEndian.Uint32(hdr[0:4]) == pcapMagicNanos:
+		p.order = binary.BigEndian
+	case magic == pcapngMagic:
+		return nil, errors.New("pcapng files are not supported; convert with: editcap -F pcap <in> <out>")
+	default:
+		return nil, fmt.Errorf("not a pcap file (magic %#08x)", magic)
+	}
+	p.nanos = p.order.Uint32(hdr[0:4]) == pcapMagicNanos
+
+	if linkType := p.order.Uint32(hdr[20:24]) & 0x0fffffff; linkType != linkTypeEthernet {
+		return nil, fmt.Errorf("unsupported pcap link type %d, only Ethernet captures can be replayed", linkType)
+	}
+	return p, nil
+}
+
+// Next returns the next frame, its length on the wire and its capture time. It
+// returns io.EOF at the end of the file.
+func (p *PcapReader) Next() ([]byte, int, time.Time, error) {
+	if _, err := io.ReadFull(p.r, p.hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, time.Time{}, errors.New("truncated pcap record header")
+		}
+		return nil, 0, time.Time{}, err
+	}
+
+	sec := int64(p.order.Uint32(p.hdr[0:4]))
+	frac := int64(p.order.Uint32(p.hdr[4:8]))
+	if !p.nanos {
+		frac *= int64(time.Microsecond)
+	}
+	capLen := p.order.Uint32(p.hdr[8:12])
+	wireLen := p.order.Uint32(p.hdr[12:16])
+	if capLen > pcapMaxSnapLen {
+		return nil, 0, time.Time{}, fmt.Errorf("pcap record of %d bytes exceeds %d", capLen, pcapMaxSnapLen)
+	}
+
+	data := make([]byte, capLen)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, 0, time.Time{}, errors.New("truncated pcap record")
+	}
+	return data, int(wireLen), time.Unix(sec, frac), nil
+}
@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+// DefaultPinPath is where maps and links are pinned on the BPF filesystem
+const DefaultPinPath = "/sys/fs/bpf/cerberus"
+
+// LinksDir is the subdirectory of the pin path holding TCX links, one per
+// interface name
+const LinksDir = "links"
+
+// What LoadCollection does with the maps pinned by a previous run
+const (
+	pinCreate  = "create"  // Nothing pinned yet: create the maps and pin them
+	pinReuse   = "reuse"   // Every pinned map matches its spec: reuse them, creating any missing
+	pinReplace = "replace" // A pinned map no longer matches: remove the pins and start over
+)
+
+// pinPlan is the decision about the pinned maps, with the reason logged
+type pinPlan struct {
+	Action string
+	Reason string
+}
+
+// pinnableMaps returns the maps of a spec that are pinned by name, in name
+// order. Internal maps (.rodata, .bss, ...) are left out: they hold the
+// program's constants and globals, which are fixed at load time.
+func pinnableMaps(spec *ebpf.CollectionSpec) []string {
+	var names []string
+	for name := range spec.Maps {
+		if !strings.HasPrefix(name, ".") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decidePins chooses what to do with the pinned maps given the maps found
+// pinned and why each pinned map doesn't match its spec, if it doesn't.
+// Maps missing from the pin path are created either way.
+func decidePins(found []string, mismatched map[string]string) pinPlan {
+	if len(mismatched) > 0 {
+		names := make([]string, 0, len(mismatched))
+		for name := range mismatched {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		reasons := make([]string, 0, len(names))
+		for _, name := range names {
+			reasons = append(reasons, fmt.Sprintf("%s (%s)", name, mismatched[name]))
+		}
+		return pinPlan{pinReplace, "pinned maps don't match this BPF object: " + strings.Join(reasons, "; ")}
+	}
+	if len(found) == 0 {
+		return pinPlan{pinCreate, "no pinned maps found"}
+	}
+	return pinPlan{pinReuse, fmt.Sprintf("%d pinned maps match this BPF object", len(found))}
+}
+
+// planPins compares the maps pinned under dir with the spec
+func planPins(spec *ebpf.CollectionSpec, dir string) pinPlan {
+	var found []string
+	mismatched := make(map[string]string)
+	for _, name := range pinnableMaps(spec) {
+		m, err := ebpf.LoadPinnedMap(filepath.Join(dir, name), nil)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			mismatched[name] = err.Error()
+			continue
+		}
+		found = append(found, name)
+		if err := spec.Maps[name].Compatible(m); err != nil {
+			mismatched[name] = err.Error()
+		}
+		m.Close()
+	}
+	return decidePins(found, mismatched)
+}
+
+// removeMapPins removes every pinned map under dir, leaving pinned links alone
+// so they can still be taken over
+func removeMapPins(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pinCollectionSpec marks the maps of a spec to be pinned under dir, reusing
+// or replacing those pinned by a previous run, and returns the options to
+// create the collection with
+func pinCollectionSpec(spec *ebpf.CollectionSpec, dir string, logger *log.Logger) (ebpf.CollectionOptions, error) {
+	var opts ebpf.CollectionOptions
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return opts, fmt.Errorf("failed to create pin path: %w", err)
+	}
+
+	plan := planPins(spec, dir)
+	logger.Printf("Pinned BPF maps in %s: %s, %s", dir, plan.Action, plan.Reason)
+	if plan.Action == pinReplace {
+		if err := removeMapPins(dir); err != nil {
+			return opts, fmt.Errorf("failed to remove stale pins: %w", err)
+		}
+	}
+
+	for _, name := range pinnableMaps(spec) {
+		spec.Maps[name].Pinning = ebpf.PinByName
+	}
+	opts.Maps.PinPath = dir
+	return opts, nil
+}
+
+// AttachPinnedTCX attaches the classifier to an interface through a link
+// pinned under dir. A link pinned by a previous run for the same interface is
+// taken over by swapping its program, so capture never stops.
+func AttachPinnedTCX(prog *ebpf.Program, ifindex int, dir string, logger *log.Logger) (link.Link, error) {
+	iface, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return nil, err
+	}
+	linksDir := filepath.Join(dir, LinksDir)
+	if err := os.MkdirAll(linksDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create link pin path: %w", err)
+	}
+	path := filepath.Join(linksDir, iface.Name)
+
+	if old, err := link.LoadPinnedLink(path, nil); err == nil {
+		if takeOver(old, ifindex, prog) {
+			logger.Printf("Took over the pinned link of %s", iface.Name)
+			return old, nil
+		}
+		// Interface recreated since, or the kernel refused the update
+		old.Unpin()
+		old.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		os.Remove(path)
+	}
+
+	l, err := AttachTCX(prog, ifindex)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.Pin(path); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to pin link: %w", err)
+	}
+	return l, nil
+}
+
+// takeOver swaps the program of a pinned TCX link if it is attached to the
+// ingress of ifindex
+func takeOver(l link.Link, ifindex int, prog *ebpf.Program) bool {
+	info, err := l.Info()
+	if err != nil {
+		return false
+	}
+	tcx := info.TCX()
+	if tcx == nil || int(tcx.Ifindex) != ifindex || ebpf.AttachType(tcx.AttachType) != ebpf.AttachTCXIngress {
+		return false
+	}
+	return l.Update(prog) == nil
+}
+
+// DetachLink detaches a link, unpinning it first (a no-op for links that
+// aren't pinned) so the pin doesn't keep it attached
+func DetachLink(l link.Link) error {
+	if err := l.Unpin(); err != nil {
+		return err
+	}
+	return l.Close()
+}
@@ -0,0 +1,244 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden records from the kernel")
+
+// recordLayout is the event layout of the golden records
+const recordLayout = 5
+
+// recordCase is a sequence of frames whose records are kept in
+// testdata/records/<name>.golden, one "ring hex" line per record
+type recordCase struct {
+	name   string
+	config models.CaptureConfig
+	frames [][]byte
+}
+
+// recordCases cover every record type and event type. The records are the
+// wire format of layout 5: every multi-byte field is big-endian and the
+// structs are packed, so they are the same bytes on any architecture.
+func recordCases() []recordCase {
+	flows := models.CaptureConfig{FlowPackets: 3}
+	return []recordCase{
+		{"arp", models.CaptureConfig{}, [][]byte{arpFrame()}},
+		{"icmp", models.CaptureConfig{}, [][]byte{icmpFrame()}},
+		{"tcp_syn", models.CaptureConfig{}, [][]byte{tcpFrame(22, tcpSYN, nil)}},
+		{"udp", models.CaptureConfig{EventPayloadBytes: map[string]int{"UDP": 16}}, [][]byte{udpFrame(5353, []byte("multicast dns payload"))}},
+		{"dns", models.CaptureConfig{}, [][]byte{udpFrame(dnsPort, dnsQuery("printer.example.com", 40))}},
+		{"http", models.CaptureConfig{}, [][]byte{tcpFrame(httpPort, tcpPSH|tcpACK, httpRequest(120))}},
+		{"tls", models.CaptureConfig{PayloadBytes: map[string]int{"TLS": 64}}, [][]byte{tcpFrame(httpsPort, tcpPSH|tcpACK, clientHello(300))}},
+		{"flow", flows, [][]byte{
+			tcpFrame(22, tcpSYN, nil),
+			tcpFrame(22, tcpACK, []byte("one")),
+			tcpFrame(22, tcpACK, []byte("two")),
+			tcpFrame(22, tcpPSH|tcpACK, []byte("three")),
+			tcpFrame(22, tcpACK, nil),
+			tcpFrame(22, tcpFIN|tcpACK, nil),
+		}},
+	}
+}
+
+// goldenRecord is one record of a golden file
+type goldenRecord struct {
+	ring string
+	data []byte
+}
+
+func goldenPath(name string) string {
+	return filepath.Join("testdata", "records", name+".golden")
+}
+
+func readGolden(t *testing.T, name string) []goldenRecord {
+	t.Helper()
+	data, err := os.ReadFile(goldenPath(name))
+	if err != nil {
+		t.Fatalf("%v (run the kernel tests with -update as root to capture it)", err)
+	}
+	var records []goldenRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ring, hexData, ok := strings.Cut(line, " ")
+		raw, err := hex.DecodeString(hexData)
+		if !ok || err != nil {
+			t.Fatalf("%s: invalid record %q", goldenPath(name), line)
+		}
+		records = append(records, goldenRecord{ring, raw})
+	}
+	return records
+}
+
+func writeGolden(t *testing.T, name string, records []goldenRecord) {
+	t.Helper()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Records of the %s frames, emitted by the BPF program with event layout %d\n", name, recordLayout)
+	for _, r := range records {
+		fmt.Fprintf(&buf, "%s %x\n", r.ring, r.data)
+	}
+	if err := os.MkdirAll(filepath.Dir(goldenPath(name)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(goldenPath(name), buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// parseGolden parses a golden record into the frame Decode returns it in
+func parseGolden(t *testing.T, r goldenRecord) Frame {
+	t.Helper()
+	order := utils.EventByteOrder(recordLayout)
+	var f Frame
+	switch r.ring {
+	case "events":
+		f.Event = utils.ParseNetworkEvent(r.data, recordLayout)
+		if err := utils.ValidateNetworkEvent(f.Event); err != nil {
+			t.Fatalf("invalid event record: %v", err)
+		}
+	case "tls_hellos":
+		f.TLSHello = utils.ParseTLSHelloEvent(r.data, order)
+	case "dns_queries":
+		f.DNSQuery = utils.ParseDNSQueryEvent(r.data, order)
+	case "http_requests":
+		f.HTTPRequest = utils.ParseHTTPRequestEvent(r.data, order)
+	case "flow_summaries":
+		f.FlowSummary = utils.ParseFlowSummaryEvent(r.data, order)
+	default:
+		t.Fatalf("unknown ring buffer %q", r.ring)
+	}
+	return normalizeFrame(f)
+}
+
+// ringOrder is the order records of one frame are listed in
+var ringOrder = []string{"events", "tls_hellos", "dns_queries", "http_requests", "flow_summaries"}
+
+// frameRecords lists the records of one decoded frame in ringOrder
+func frameRecords(f Frame) []string {
+	var rings []string
+	for _, r := range []struct {
+		ring    string
+		present bool
+	}{
+		{"events", f.Event != nil},
+		{"tls_hellos", f.TLSHello != nil},
+		{"dns_queries", f.DNSQuery != nil},
+		{"http_requests", f.HTTPRequest != nil},
+		{"flow_summaries", f.FlowSummary != nil},
+	} {
+		if r.present {
+			rings = append(rings, r.ring)
+		}
+	}
+	return rings
+}
+
+// TestGoldenRecords parses the records the BPF program emitted for each case
+// and checks they are what the Decoder returns for the same frames, so that a
+// change to the wire format or the parsers shows on any architecture and
+// without privileges
+func TestGoldenRecords(t *testing.T) {
+	for _, c := range recordCases() {
+		t.Run(c.name, func(t *testing.T) {
+			golden := readGolden(t, c.name)
+			d := NewDecoder()
+			if err := d.Apply(c.config); err != nil {
+				t.Fatal(err)
+			}
+			for i, frame := range c.frames {
+				want := normalizeFrame(d.Decode(frame, len(frame), testIfIndex, time.Time{}))
+				rings := frameRecords(want)
+				if len(golden) < len(rings) {
+					t.Fatalf("frame %d: %d golden records left, want %v", i, len(golden), rings)
+				}
+				var got Frame
+				for j, ring := range rings {
+					if golden[j].ring != ring {
+						t.Fatalf("frame %d: golden record in %s, want %s", i, golden[j].ring, ring)
+					}
+					parsed := parseGolden(t, golden[j])
+					switch ring {
+					case "events":
+						got.Event = parsed.Event
+					case "tls_hellos":
+						got.TLSHello = parsed.TLSHello
+					case "dns_queries":
+						got.DNSQuery = parsed.DNSQuery
+					case "http_requests":
+						got.HTTPRequest = parsed.HTTPRequest
+					case "flow_summaries":
+						got.FlowSummary = parsed.FlowSummary
+					}
+				}
+				golden = golden[len(rings):]
+				compareFrames(t, got, want)
+			}
+			if len(golden) > 0 {
+				t.Errorf("%d golden records left over", len(golden))
+			}
+		})
+	}
+}
+
+// TestKernelGoldenRecords checks the BPF program still emits the golden
+// records byte for byte, or rewrites them with -update
+func TestKernelGoldenRecords(t *testing.T) {
+	for _, name := range kernelPrograms {
+		t.Run(name, func(t *testing.T) {
+			k := loadKernelProgram(t, name)
+			if k.layout != recordLayout {
+				t.Fatalf("BPF object emits event layout %d, golden records are of layout %d", k.layout, recordLayout)
+			}
+			for _, c := range recordCases() {
+				t.Run(c.name, func(t *testing.T) {
+					if err := k.filter.Apply(c.config); err != nil {
+						t.Fatal(err)
+					}
+					// Flows of a previous case would be counted on
+					if _, err := NewFlows(k.coll).Sweep(true); err != nil {
+						t.Fatal(err)
+					}
+					var got []goldenRecord
+					for _, frame := range c.frames {
+						raw := k.raw(t, frame)
+						for _, ring := range ringOrder {
+							for _, data := range raw[ring] {
+								if ring == "flow_summaries" {
+									clear(data[42:46]) // Duration, which depends on the clock
+								}
+								got = append(got, goldenRecord{ring, data})
+							}
+						}
+					}
+					if *update {
+						writeGolden(t, c.name, got)
+						return
+					}
+					want := readGolden(t, c.name)
+					if !slices.EqualFunc(got, want, func(a, b goldenRecord) bool {
+						return a.ring == b.ring && bytes.Equal(a.data, b.data)
+					}) {
+						t.Errorf("records differ from %s:\nkernel %x\ngolden %x", goldenPath(c.name), got, want)
+					}
+				})
+			}
+		})
+	}
+}
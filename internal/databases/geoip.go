@@ -0,0 +1,149 @@
+package databases
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// GeoIPDatabase maps IPv4 addresses to the country and autonomous system
+// announcing them. It reads the ip2asn TSV format (https://iptoasn.com), one
+// range per line:
+//
+//	range_start  range_end  AS_number  country_code  AS_description
+//
+// IPv6 ranges and unrouted ranges (AS 0) are skipped.
+type GeoIPDatabase struct {
+	ranges []geoRange // Sorted by start, not overlapping
+}
+
+type geoRange struct {
+	start, end uint32
+	info       models.GeoInfo
+}
+
+// LoadGeoIPDatabase reads a GeoIP database file, gzip-compressed if its name
+// ends in .gz
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	db, err := ParseGeoIPDatabase(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+// ParseGeoIPDatabase reads a GeoIP database in the ip2asn TSV format
+func ParseGeoIPDatabase(r io.Reader) (*GeoIPDatabase, error) {
+	db := &GeoIPDatabase{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("line %d: expected start, end, AS number, country and AS description", lineNum)
+		}
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		if start == nil || end == nil {
+			return nil, fmt.Errorf("line %d: invalid range %s-%s", lineNum, fields[0], fields[1])
+		}
+		if start.To4() == nil || end.To4() == nil {
+			continue // IPv6, which events never carry
+		}
+		asn, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid AS number %q", lineNum, fields[2])
+		}
+		if asn == 0 {
+			continue // Not routed
+		}
+
+		r := geoRange{
+			start: binary.BigEndian.Uint32(start.To4()),
+			end:   binary.BigEndian.Uint32(end.To4()),
+			info:  models.GeoInfo{ASN: uint32(asn)},
+		}
+		if r.end < r.start {
+			return nil, fmt.Errorf("line %d: range %s-%s ends before it starts", lineNum, fields[0], fields[1])
+		}
+		if country := strings.ToUpper(fields[3]); country != "NONE" && country != "ZZ" {
+			r.info.Country = country
+		}
+		if len(fields) == 5 && fields[4] != "Not routed" {
+			r.info.ASOrg = strings.TrimSpace(fields[4])
+		}
+		db.ranges = append(db.ranges, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].start < db.ranges[j].start })
+	for i := 1; i < len(db.ranges); i++ {
+		if db.ranges[i].start <= db.ranges[i-1].end {
+			return nil, fmt.Errorf("overlapping ranges starting at %s and %s",
+				ipString(db.ranges[i-1].start), ipString(db.ranges[i].start))
+		}
+	}
+	return db, nil
+}
+
+// Lookup returns the country and autonomous system of an IPv4 address
+func (db *GeoIPDatabase) Lookup(ip net.IP) (models.GeoInfo, bool) {
+	ip4 := ip.To4()
+	if db == nil || ip4 == nil {
+		return models.GeoInfo{}, false
+	}
+	addr := binary.BigEndian.Uint32(ip4)
+
+	// The last range starting at or before addr
+	i := sort.Search(len(db.ranges), func(i int) bool { return db.ranges[i].start > addr }) - 1
+	if i < 0 || addr > db.ranges[i].end {
+		return models.GeoInfo{}, false
+	}
+	return db.ranges[i].info, true
+}
+
+// Len returns how many address ranges the database holds
+func (db *GeoIPDatabase) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+func ipString(addr uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, addr)
+	return ip.String()
+}
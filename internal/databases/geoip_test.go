@@ -0,0 +1,90 @@
+package databases
+
+import (
+	"compress/gzip"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+func TestGeoIPLookup(t *testing.T) {
+	db, err := LoadGeoIPDatabase(filepath.Join("testdata", "ip2asn.tsv"))
+	if err != nil {
+		t.Fatalf("LoadGeoIPDatabase: %v", err)
+	}
+	// The IPv6 and unrouted ranges are skipped
+	if db.Len() != 4 {
+		t.Errorf("loaded %d ranges, want 4", db.Len())
+	}
+
+	cloudflare := models.GeoInfo{Country: "US", ASN: 13335, ASOrg: "CLOUDFLARENET"}
+	tests := []struct {
+		ip   string
+		want models.GeoInfo
+		ok   bool
+	}{
+		{"1.0.0.0", cloudflare, true},
+		{"1.0.0.1", cloudflare, true},
+		{"1.0.0.255", cloudflare, true},
+		{"1.0.1.0", models.GeoInfo{}, false}, // Not routed
+		{"1.0.7.255", models.GeoInfo{Country: "AU", ASN: 38803, ASOrg: "WPL-AS-AP Wirefreebroadband Pty Ltd"}, true},
+		{"1.0.8.0", models.GeoInfo{}, false},
+		{"0.255.255.255", models.GeoInfo{}, false},
+		{"5.10.64.1", models.GeoInfo{ASN: 64496, ASOrg: "UNKNOWN-COUNTRY"}, true},
+		{"255.255.255.255", models.GeoInfo{}, false},
+		{"2001:200::1", models.GeoInfo{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := db.Lookup(net.ParseIP(tt.ip))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Lookup(%s) = %+v, %v; want %+v, %v", tt.ip, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	var none *GeoIPDatabase
+	if _, ok := none.Lookup(net.ParseIP("1.0.0.1")); ok || none.Len() != 0 {
+		t.Error("a nil database located an address")
+	}
+}
+
+func TestLoadGeoIPGzip(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "ip2asn.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ip2asn-v4.tsv.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	gz.Write(data)
+	gz.Close()
+	file.Close()
+
+	db, err := LoadGeoIPDatabase(path)
+	if err != nil {
+		t.Fatalf("LoadGeoIPDatabase: %v", err)
+	}
+	if info, ok := db.Lookup(net.ParseIP("2.16.0.10")); !ok || info.ASN != 20940 {
+		t.Errorf("Lookup in the gzipped database = %+v, %v", info, ok)
+	}
+}
+
+func TestParseGeoIPErrors(t *testing.T) {
+	for _, data := range []string{
+		"1.0.0.0\t1.0.0.255\t13335",
+		"1.0.0.0\tnope\t13335\tUS\tX",
+		"1.0.0.0\t1.0.0.255\tAS13335\tUS\tX",
+		"1.0.0.255\t1.0.0.0\t13335\tUS\tX",
+		"1.0.0.0\t1.0.0.255\t13335\tUS\tX\n1.0.0.128\t1.0.1.0\t64500\tDE\tY",
+	} {
+		if _, err := ParseGeoIPDatabase(strings.NewReader(data)); err == nil {
+			t.Errorf("ParseGeoIPDatabase(%q) succeeded, want an error", data)
+		}
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/zrougamed/cerberus/internal/outbound"
 )
 
 // OUIDatabase represents the MAC vendor lookup database
@@ -76,6 +78,9 @@ func LoadOUIDatabase() map[string]string {
 
 // downloadIEEEDatabase downloads the official IEEE OUI database
 func (db *OUIDatabase) downloadIEEEDatabase() error {
+	if err := outbound.Check(); err != nil {
+		return err
+	}
 	fmt.Println("Downloading IEEE OUI database...")
 
 	// Ensure cache directory exists
@@ -83,11 +88,7 @@ func (db *OUIDatabase) downloadIEEEDatabase() error {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	resp, err := client.Get(IEEE_OUI_URL)
+	resp, err := outbound.Get(IEEE_OUI_URL, nil, 30*time.Second)
 	if err != nil {
 		return fmt.Errorf("failed to download OUI database: %w", err)
 	}
@@ -305,19 +306,10 @@ func (db *OUIDatabase) Lookup(mac string) string {
 // queryOnlineAPI queries the macvendors.com API for vendor information
 // Rate limited to 2 requests/second by the API
 func (db *OUIDatabase) queryOnlineAPI(mac string) string {
-	client := &http.Client{
-		Timeout: 3 * time.Second,
-	}
-
 	url := fmt.Sprintf(MACVENDORS_API, mac)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return ""
-	}
-
-	req.Header.Set("User-Agent", "Cerberus-Network-Monitor/1.0")
+	header := http.Header{"User-Agent": {"Cerberus-Network-Monitor/1.0"}}
 
-	resp, err := client.Do(req)
+	resp, err := outbound.Get(url, header, 3*time.Second)
 	if err != nil {
 		return ""
 	}
@@ -403,3 +395,42 @@ func (db *OUIDatabase) SaveToCache() error {
 
 	return writer.Flush()
 }
+
+// vendorSuffixes are corporate words dropped when normalizing vendor names
+var vendorSuffixes = map[string]bool{
+	"co": true, "corp": true, "corporation": true, "company": true, "inc": true,
+	"incorporated": true, "ltd": true, "limited": true, "llc": true, "gmbh": true,
+	"ag": true, "sa": true, "bv": true, "plc": true, "technologies": true,
+	"technology": true, "tech": true, "electronics": true, "international": true,
+	"holdings": true, "group": true,
+}
+
+// NormalizeVendor reduces a vendor name to a stable grouping key, so variants
+// such as "TP-LINK TECHNOLOGIES CO.,LTD." and "Tp-Link Corporation Limited"
+// both become "tp-link". Unknown vendors normalize to "".
+func NormalizeVendor(vendor string) string {
+	normalized := strings.ToLower(strings.Join(vendorWords(vendor), " "))
+	if normalized == "unknown" || normalized == "routed" {
+		return ""
+	}
+	return normalized
+}
+
+// vendorWords splits a vendor name into words, leaving out what follows the
+// first comma (usually the legal form), punctuation around words and corporate
+// suffixes. Parenthesized parts, e.g. "(THAILAND)", become words of their own.
+func vendorWords(vendor string) []string {
+	// Some registrations use a fullwidth comma
+	name, _, _ := strings.Cut(strings.ReplaceAll(vendor, "，", ","), ",")
+	name = strings.NewReplacer("(", " ", ")", " ").Replace(name)
+
+	var words []string
+	for _, word := range strings.Fields(name) {
+		word = strings.Trim(word, ".&")
+		if word == "" || vendorSuffixes[strings.ToLower(word)] {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
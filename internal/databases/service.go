@@ -1,6 +1,7 @@
 package databases
 
 import (
+	"encoding/csv"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/outbound"
 )
 
 // ServiceDatabase represents a comprehensive service/port lookup system
@@ -20,6 +22,7 @@ type ServiceDatabase struct {
 	tcpServices    map[uint16]*models.ServiceInfo
 	udpServices    map[uint16]*models.ServiceInfo
 	threatPorts    map[uint16]ThreatInfo
+	overrides      map[string]*models.ServiceInfo // "TCP/<port>" or "UDP/<port>" -> user-defined name
 	mu             sync.RWMutex
 	dbPath         string
 	lastSync       time.Time
@@ -46,6 +49,10 @@ const (
 	SERVICES_CACHE_DAYS = 90 // Refresh every 90 days
 )
 
+// UnknownServiceDescription describes the placeholder returned for a port no
+// database names
+const UnknownServiceDescription = "Unknown Service"
+
 // NewServiceDatabase creates a comprehensive service database
 func NewServiceDatabase(enableOnline bool) (*ServiceDatabase, error) {
 	db := &ServiceDatabase{
@@ -85,14 +92,16 @@ func LoadServiceDatabase() map[uint16]*models.ServiceInfo {
 
 // downloadIANADatabase downloads the official IANA service registry
 func (db *ServiceDatabase) downloadIANADatabase() error {
+	if err := outbound.Check(); err != nil {
+		return err
+	}
 	fmt.Println("Downloading IANA service registry...")
 
 	if err := os.MkdirAll(CACHE_DIR, 0755); err != nil {
 		return err
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(IANA_SERVICES_URL)
+	resp, err := outbound.Get(IANA_SERVICES_URL, nil, 30*time.Second)
 	if err != nil {
 		return err
 	}
@@ -153,25 +162,73 @@ func (db *ServiceDatabase) loadFromCache() error {
 	return nil
 }
 
-// parseIANACSV parses IANA CSV format
+// ianaColumns are the IANA registry columns used, with their position in the
+// registry's documented layout for files whose header can't be matched
+var ianaColumns = map[string]int{
+	"service name":       0,
+	"port number":        1,
+	"transport protocol": 2,
+	"description":        3,
+	"assignment notes":   11,
+}
+
+// parseIANACSV parses the IANA service name and port number registry CSV.
+// Descriptions and notes are often quoted and may contain commas or line
+// breaks. Unassigned, reserved and de-registered entries, port ranges and
+// transports other than TCP and UDP are skipped.
 func (db *ServiceDatabase) parseIANACSV(data string) int {
-	lines := strings.Split(data, "\n")
-	count := 0
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1 // Trailing empty columns are sometimes omitted
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
 
-	for i, line := range lines {
-		if i == 0 || line == "" {
-			continue // Skip header
+	header, err := reader.Read()
+	if err != nil {
+		return 0
+	}
+	columns := make(map[string]int, len(ianaColumns))
+	for name, position := range ianaColumns {
+		columns[name] = position
+	}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := ianaColumns[name]; ok {
+			columns[name] = i
 		}
+	}
+	field := func(record []string, name string) string {
+		if i := columns[name]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
 
-		fields := strings.Split(line, ",")
-		if len(fields) < 4 {
-			continue
+	count := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A malformed record doesn't invalidate the rest of the registry
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			break
 		}
 
-		serviceName := strings.TrimSpace(fields[0])
-		portRange := strings.TrimSpace(fields[1])
-		protocol := strings.ToUpper(strings.TrimSpace(fields[2]))
-		description := strings.TrimSpace(fields[3])
+		serviceName := field(record, "service name")
+		portRange := field(record, "port number")
+		protocol := strings.ToUpper(field(record, "transport protocol"))
+		description := field(record, "description")
+
+		// Unassigned and reserved ranges have no service name
+		if serviceName == "" || (protocol != "TCP" && protocol != "UDP") {
+			continue
+		}
+		if deregistered(description) || deregistered(field(record, "assignment notes")) {
+			continue
+		}
 
 		// Parse port (skip ranges for now)
 		if strings.Contains(portRange, "-") {
@@ -179,7 +236,7 @@ func (db *ServiceDatabase) parseIANACSV(data string) int {
 		}
 
 		port, err := strconv.ParseUint(portRange, 10, 16)
-		if err != nil || port == 0 || port > 65535 {
+		if err != nil || port == 0 {
 			continue
 		}
 
@@ -189,16 +246,19 @@ func (db *ServiceDatabase) parseIANACSV(data string) int {
 			Port:        portNum,
 			Protocol:    protocol,
 			Service:     strings.ToUpper(serviceName),
-			Description: description,
+			Description: strings.Join(strings.Fields(description), " "),
 		}
 
 		db.mu.Lock()
 		if protocol == "TCP" {
 			db.tcpServices[portNum] = service
-		} else if protocol == "UDP" {
+		} else {
 			db.udpServices[portNum] = service
 		}
-		db.services[portNum] = service
+		// The protocol-agnostic lookup prefers the TCP entry
+		if existing, ok := db.services[portNum]; !ok || existing.Protocol != "TCP" || protocol == "TCP" {
+			db.services[portNum] = service
+		}
 		db.mu.Unlock()
 
 		count++
@@ -207,6 +267,14 @@ func (db *ServiceDatabase) parseIANACSV(data string) int {
 	return count
 }
 
+// deregistered reports whether an IANA description or note marks the entry
+// as withdrawn
+func deregistered(text string) bool {
+	text = strings.ToLower(text)
+	return strings.Contains(text, "de-registered") || strings.Contains(text, "deregistered") ||
+		text == "reserved" || text == "unassigned"
+}
+
 // loadFallbackDatabase loads comprehensive hardcoded database
 func (db *ServiceDatabase) loadFallbackDatabase() {
 	fallback := map[uint16]*models.ServiceInfo{
@@ -416,29 +484,114 @@ func (db *ServiceDatabase) Lookup(port uint16, protocol string) *models.ServiceI
 	defer db.mu.RUnlock()
 	protocol = strings.ToUpper(protocol)
 
+	if svc, ok := db.lookup(port, protocol); ok {
+		return svc
+	}
+	return unknownService(port, protocol)
+}
+
+// LookupBestEffort is Lookup for display purposes: when the port is unknown for
+// the given protocol it falls back to the other protocol's service, labeled with
+// that protocol (e.g. "TFTP (UDP)"), before giving up
+func (db *ServiceDatabase) LookupBestEffort(port uint16, protocol string) *models.ServiceInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	protocol = strings.ToUpper(protocol)
+
+	if svc, ok := db.lookup(port, protocol); ok {
+		return svc
+	}
+
+	var other *models.ServiceInfo
+	switch protocol {
+	case "TCP":
+		other = db.udpServices[port]
+	case "UDP":
+		other = db.tcpServices[port]
+	}
+	if other == nil {
+		return unknownService(port, protocol)
+	}
+
+	return &models.ServiceInfo{
+		Port:        port,
+		Protocol:    other.Protocol,
+		Service:     fmt.Sprintf("%s (%s)", other.Service, other.Protocol),
+		Description: other.Description,
+	}
+}
+
+// lookup checks the user-defined names, the protocol-specific map, then the
+// general one. Must hold db.mu.
+func (db *ServiceDatabase) lookup(port uint16, protocol string) (*models.ServiceInfo, bool) {
+	if svc, ok := db.overrides[fmt.Sprintf("%s/%d", protocol, port)]; ok {
+		return svc, true
+	}
+
 	// Protocol-specific lookup
 	switch protocol {
 	case "TCP":
 		if svc, ok := db.tcpServices[port]; ok {
-			return svc
+			return svc, true
 		}
 	case "UDP":
 		if svc, ok := db.udpServices[port]; ok {
-			return svc
+			return svc, true
 		}
 	}
 
 	// Fallback to general lookup
-	if svc, ok := db.services[port]; ok {
-		return svc
-	}
+	svc, ok := db.services[port]
+	return svc, ok
+}
 
+func unknownService(port uint16, protocol string) *models.ServiceInfo {
 	return &models.ServiceInfo{
 		Port:        port,
 		Protocol:    protocol,
 		Service:     fmt.Sprintf("%s/%d", protocol, port),
-		Description: "Unknown Service",
+		Description: UnknownServiceDescription,
+	}
+}
+
+// LoadOverrides reads user-defined service names, which take precedence over
+// the IANA registry and the fallback list. Each line maps a port to a name,
+// such as "8443/tcp ADMIN-UI"; blank lines and lines starting with # are
+// skipped. It returns the number of names read.
+func (db *ServiceDatabase) LoadOverrides(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	overrides := make(map[string]*models.ServiceInfo)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("%s:%d: expected \"<port>/<tcp|udp> <name>\"", path, i+1)
+		}
+		portText, protocol, _ := strings.Cut(fields[0], "/")
+		protocol = strings.ToUpper(protocol)
+		port, err := strconv.ParseUint(portText, 10, 16)
+		if err != nil || port == 0 || (protocol != "TCP" && protocol != "UDP") {
+			return 0, fmt.Errorf("%s:%d: invalid port %q", path, i+1, fields[0])
+		}
+		overrides[fmt.Sprintf("%s/%d", protocol, port)] = &models.ServiceInfo{
+			Port:        uint16(port),
+			Protocol:    protocol,
+			Service:     strings.ToUpper(fields[1]),
+			Description: "User-defined",
+		}
 	}
+
+	db.mu.Lock()
+	db.overrides = overrides
+	db.mu.Unlock()
+	return len(overrides), nil
 }
 
 // GetThreatInfo checks if a port is associated with threats
@@ -0,0 +1,169 @@
+package databases
+
+import (
+	"maps"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// builtinVendorAliases maps normalized vendor names (see NormalizeVendor) to
+// their canonical brand. A name matches the longest alias its words start
+// with, so "samsung electro-mechanics thailand" is "Samsung".
+var builtinVendorAliases = map[string]string{
+	"amazon":                      "Amazon",
+	"apple":                       "Apple",
+	"arris":                       "ARRIS",
+	"aruba":                       "Aruba",
+	"asustek computer":            "ASUS",
+	"asus":                        "ASUS",
+	"azurewave":                   "AzureWave",
+	"belkin":                      "Belkin",
+	"brother industries":          "Brother",
+	"broadcom":                    "Broadcom",
+	"canon":                       "Canon",
+	"cisco":                       "Cisco",
+	"cisco-linksys":               "Linksys",
+	"d-link":                      "D-Link",
+	"dell":                        "Dell",
+	"espressif":                   "Espressif",
+	"fn-link":                     "FN-LINK",
+	"google":                      "Google",
+	"hewlett packard":             "HP",
+	"hewlett-packard":             "HP",
+	"hp":                          "HP",
+	"hon hai precision":           "Foxconn",
+	"huawei":                      "Huawei",
+	"intel":                       "Intel",
+	"juniper networks":            "Juniper",
+	"lenovo":                      "Lenovo",
+	"lg":                          "LG",
+	"lg innotek":                  "LG Innotek",
+	"linksys":                     "Linksys",
+	"liteon":                      "Lite-On",
+	"lite-on":                     "Lite-On",
+	"microsoft":                   "Microsoft",
+	"motorola mobility":           "Motorola",
+	"murata manufacturing":        "Murata",
+	"netgear":                     "Netgear",
+	"nintendo":                    "Nintendo",
+	"nokia":                       "Nokia",
+	"oneplus":                     "OnePlus",
+	"oracle virtualbox":           "VirtualBox",
+	"philips lighting":            "Philips Hue",
+	"qualcomm":                    "Qualcomm",
+	"raspberry pi":                "Raspberry Pi",
+	"realtek semiconductor":       "Realtek",
+	"ring":                        "Ring",
+	"roku":                        "Roku",
+	"ruckus wireless":             "Ruckus",
+	"samsung":                     "Samsung",
+	"seiko epson":                 "Epson",
+	"signify":                     "Philips Hue",
+	"sonos":                       "Sonos",
+	"sony":                        "Sony",
+	"sony interactive":            "PlayStation",
+	"synology":                    "Synology",
+	"texas instruments":           "Texas Instruments",
+	"tp-link":                     "TP-Link",
+	"ubiquiti":                    "Ubiquiti",
+	"universal global scientific": "USI",
+	"vmware":                      "VMware",
+	"xiaomi":                      "Xiaomi",
+	"zte":                         "ZTE",
+}
+
+// VendorAliases turns the vendor names of the IEEE registry into canonical
+// brands, so one manufacturer registered under several names is grouped as
+// one. Configured aliases take precedence over the built-in ones.
+type VendorAliases struct {
+	mu         sync.RWMutex
+	configured map[string]string // Normalized name -> canonical brand
+}
+
+// NewVendorAliases creates a canonicalizer with the built-in aliases only
+func NewVendorAliases() *VendorAliases {
+	return &VendorAliases{configured: make(map[string]string)}
+}
+
+// Canonical returns the canonical form of a vendor name: the brand of the
+// longest matching alias, or else the name without its legal form and
+// corporate suffixes, shouted words title-cased. Names that normalize to
+// nothing, such as "Unknown", are returned unchanged.
+func (a *VendorAliases) Canonical(vendor string) string {
+	normalized := NormalizeVendor(vendor)
+	if normalized == "" {
+		return vendor
+	}
+
+	a.mu.RLock()
+	brand, ok := matchVendorAlias(a.configured, normalized)
+	a.mu.RUnlock()
+	if ok {
+		return brand
+	}
+	if brand, ok := matchVendorAlias(builtinVendorAliases, normalized); ok {
+		return brand
+	}
+
+	words := vendorWords(vendor)
+	for i, word := range words {
+		if len(word) > 3 && strings.ToUpper(word) == word {
+			words[i] = titleWord(word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// matchVendorAlias finds the alias matching the most leading words of a
+// normalized name
+func matchVendorAlias(aliases map[string]string, normalized string) (string, bool) {
+	words := strings.Fields(normalized)
+	for n := len(words); n > 0; n-- {
+		if brand, ok := aliases[strings.Join(words[:n], " ")]; ok {
+			return brand, true
+		}
+	}
+	return "", false
+}
+
+// titleWord capitalizes the first letter of each hyphenated part of a word
+func titleWord(word string) string {
+	runes := []rune(strings.ToLower(word))
+	for i := range runes {
+		if i == 0 || runes[i-1] == '-' {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+	return string(runes)
+}
+
+// SetConfigured replaces the configured aliases, mapping vendor names (in any
+// variant) to brands. Names that normalize to nothing and empty brands are
+// left out. It returns the aliases as stored, keyed by normalized name.
+func (a *VendorAliases) SetConfigured(aliases map[string]string) map[string]string {
+	configured := make(map[string]string, len(aliases))
+	for name, brand := range aliases {
+		name, brand = NormalizeVendor(name), strings.TrimSpace(brand)
+		if name != "" && brand != "" {
+			configured[name] = brand
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configured = configured
+	return maps.Clone(configured)
+}
+
+// Configured returns the configured aliases, keyed by normalized name
+func (a *VendorAliases) Configured() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return maps.Clone(a.configured)
+}
+
+// BuiltInVendorAliases returns the built-in aliases, keyed by normalized name
+func BuiltInVendorAliases() map[string]string {
+	return maps.Clone(builtinVendorAliases)
+}
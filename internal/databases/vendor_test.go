@@ -0,0 +1,78 @@
+package databases
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Registry names as the IEEE lists them, with their legal forms, punctuation
+// and shouting, group under one brand
+func TestCanonicalVendor(t *testing.T) {
+	tests := []struct{ vendor, want string }{
+		{"SAMSUNG ELECTRO-MECHANICS(THAILAND)", "Samsung"},
+		{"Samsung Electronics Co.,Ltd", "Samsung"},
+		{"SAMSUNG ELECTRONICS CO., LTD", "Samsung"},
+		{"Apple, Inc.", "Apple"},
+		{"Hewlett Packard Enterprise", "HP"},
+		{"Hewlett-Packard Company", "HP"},
+		{"HON HAI PRECISION IND. CO.,LTD.", "Foxconn"},
+		{"Sony Interactive Entertainment Inc.", "PlayStation"},
+		{"Sony Corporation", "Sony"},
+		{"Cisco-Linksys, LLC", "Linksys"},
+		{"Cisco Systems, Inc", "Cisco"},
+		{"TP-LINK TECHNOLOGIES CO.,LTD.", "TP-Link"},
+		{"Tp-Link Corporation Limited", "TP-Link"},
+		{"LG Innotek", "LG Innotek"},
+		{"LG Electronics (Mobile Communications)", "LG"},
+		{"Raspberry Pi Trading Ltd", "Raspberry Pi"},
+		{"Universal Global Scientific Industrial Co., Ltd.", "USI"},
+		{"Espressif Inc.", "Espressif"},
+		// Without an alias, shouted words are title-cased but acronyms kept
+		{"GUANGDONG OPPO MOBILE TELECOMMUNICATIONS CORP.,LTD", "Guangdong Oppo Mobile Telecommunications"},
+		{"AVM Audiovisuelles Marketing und Computersysteme GmbH", "AVM Audiovisuelles Marketing und Computersysteme"},
+		{"Shenzhen Bilian electronic Co.，Ltd", "Shenzhen Bilian electronic"},
+		{"Unknown", "Unknown"},
+		{"", ""},
+	}
+	aliases := NewVendorAliases()
+	for _, tt := range tests {
+		if got := aliases.Canonical(tt.vendor); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.vendor, got, tt.want)
+		}
+	}
+}
+
+// Configured aliases are stored normalized and win over the built-in ones
+// until they are replaced
+func TestConfiguredVendorAliases(t *testing.T) {
+	aliases := NewVendorAliases()
+	stored := aliases.SetConfigured(map[string]string{
+		"GUANGDONG OPPO MOBILE TELECOMMUNICATIONS CORP.,LTD": "OPPO",
+		"Samsung Electronics Co.,Ltd":                        " Galaxy ",
+		"Unknown":                                            "Nobody",
+		"Ring LLC":                                           " ",
+	})
+	want := map[string]string{
+		"guangdong oppo mobile telecommunications": "OPPO",
+		"samsung": "Galaxy",
+	}
+	if !reflect.DeepEqual(stored, want) || !reflect.DeepEqual(aliases.Configured(), want) {
+		t.Errorf("stored %v, configured %v, want %v", stored, aliases.Configured(), want)
+	}
+
+	for vendor, want := range map[string]string{
+		"Guangdong Oppo Mobile Telecommunications Corp.,Ltd": "OPPO",
+		"SAMSUNG ELECTRO-MECHANICS(THAILAND)":                "Galaxy",
+		"Ring LLC":                                           "Ring",
+		"Apple, Inc.":                                        "Apple",
+	} {
+		if got := aliases.Canonical(vendor); got != want {
+			t.Errorf("Canonical(%q) = %q, want %q", vendor, got, want)
+		}
+	}
+
+	aliases.SetConfigured(nil)
+	if got := aliases.Canonical("Samsung Electronics Co.,Ltd"); got != "Samsung" {
+		t.Errorf("Canonical after clearing = %q, want the built-in brand", got)
+	}
+}
@@ -0,0 +1,382 @@
+package eventsock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// socketPath returns a socket path in a new directory. Unix socket paths are
+// limited to about 100 bytes, which t.TempDir can exceed.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, SocketFile)
+}
+
+// waitFor polls cond until it holds, failing the test after a while. The
+// first reconnect comes after minBackoff, the next after twice as long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3*minBackoff + 5*time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// recorder collects the frames a Subscriber hands on
+type recorder struct {
+	mu     sync.Mutex
+	frames []Frame
+}
+
+func (r *recorder) handle(frame Frame) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.frames = append(r.frames, frame)
+}
+
+// kinds returns the kinds of the frames handed on so far
+func (r *recorder) kinds() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kinds := make([]string, len(r.frames))
+	for i, frame := range r.frames {
+		kinds[i] = frame.Kind
+	}
+	return kinds
+}
+
+// rawFrame returns a length-prefixed frame with any body
+func rawFrame(body string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+// Frames read back as written; frames of another version, too long or cut
+// short are refused
+func TestReadFrame(t *testing.T) {
+	encoded, err := encodeFrame(KindPattern, models.CommunicationPattern{DeviceID: "aa:bb:cc:dd:ee:ff", DstPort: 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ReadFrame(bytes.NewReader(encoded))
+	if err != nil || frame.Version != Version || frame.Kind != KindPattern {
+		t.Fatalf("ReadFrame = %+v, %v", frame, err)
+	}
+	var pattern models.CommunicationPattern
+	if err := json.Unmarshal(frame.Data, &pattern); err != nil || pattern.DeviceID != "aa:bb:cc:dd:ee:ff" || pattern.DstPort != 443 {
+		t.Errorf("data = %s (%v)", frame.Data, err)
+	}
+	if ping, _ := encodeFrame(KindPing, nil); !strings.Contains(string(ping), `{"v":1,"kind":"ping"}`) {
+		t.Errorf("ping frame = %q, want no data", ping)
+	}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error // Matched with errors.Is, or any error if nil
+	}{
+		{"newer version", rawFrame(`{"v":2,"kind":"pattern","data":{}}`), ErrVersion},
+		{"no version", rawFrame(`{"kind":"pattern"}`), ErrVersion},
+		{"not json", rawFrame(`pattern`), nil},
+		{"too long", binary.BigEndian.AppendUint32(nil, MaxFrame+1), nil},
+		{"short body", rawFrame(`{"v":1,"kind":"ping"}`)[:10], io.ErrUnexpectedEOF},
+		{"short header", []byte{0, 0}, io.ErrUnexpectedEOF},
+		{"nothing", nil, io.EOF},
+	}
+	for _, tt := range tests {
+		_, err := ReadFrame(bytes.NewReader(tt.frame))
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: ReadFrame = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := encodeFrame(KindPattern, strings.Repeat("x", MaxFrame)); err == nil {
+		t.Error("encoded a frame longer than MaxFrame")
+	}
+}
+
+// A reader gets the hello, the writer's state and then every event, in order
+func TestPublishSubscribe(t *testing.T) {
+	path := socketPath(t)
+	state := func() []Event {
+		return []Event{{Kind: KindInterface, Data: models.InterfaceStatus{Index: 2, Name: "eth0", Attached: true}}}
+	}
+	started := time.Date(2026, 4, 1, 10, 0, 0, 0, time.UTC)
+	p, err := Listen(path, Hello{PID: 42, Version: "v1.2.3", Started: started}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("socket file = %v, %v", info, err)
+	}
+
+	var rec recorder
+	s := Subscribe(path, rec.handle)
+	defer s.Close()
+	waitFor(t, "the reader", func() bool { return p.Readers() == 1 && s.Status().Connected })
+	status := s.Status()
+	if status.Writer == nil || status.Writer.PID != 42 || status.Writer.Version != "v1.2.3" || !status.Writer.Started.Equal(started) {
+		t.Errorf("writer = %+v", status.Writer)
+	}
+	if status.Reconnects != 0 || status.Error != "" {
+		t.Errorf("status = %+v", status)
+	}
+
+	for _, kind := range []string{KindPattern, KindAnomaly, KindDevice, "later_kind"} {
+		if err := p.Publish(kind, map[string]string{"kind": kind}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{KindInterface, KindPattern, KindAnomaly, KindDevice, "later_kind"}
+	waitFor(t, "the events", func() bool { return len(rec.kinds()) == len(want) })
+	if got := rec.kinds(); !slices.Equal(got, want) {
+		t.Errorf("handed on %v, want %v", got, want)
+	}
+	if p.Dropped() != 0 {
+		t.Errorf("%d frames dropped", p.Dropped())
+	}
+}
+
+// Only one writer listens on a socket; one left behind by a writer that
+// exited is replaced
+func TestListenWriterRunning(t *testing.T) {
+	path := socketPath(t)
+	p, err := Listen(path, Hello{PID: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(path, Hello{PID: 2}, nil); !errors.Is(err, ErrWriterRunning) {
+		t.Errorf("second writer: %v", err)
+	}
+	p.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket left after Close: %v", err)
+	}
+
+	// A crashed writer leaves the file without a listener
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	p, err = Listen(path, Hello{PID: 3}, nil)
+	if err != nil {
+		t.Fatalf("replacing a stale socket: %v", err)
+	}
+	p.Close()
+}
+
+// A reader notices the writer going away, keeps trying, and gets the state
+// again from the writer that comes back
+func TestSubscriberReconnect(t *testing.T) {
+	path := socketPath(t)
+	state := func() []Event { return []Event{{Kind: KindInterface, Data: models.InterfaceStatus{Index: 2}}} }
+	p, err := Listen(path, Hello{PID: 1}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rec recorder
+	s := Subscribe(path, rec.handle)
+	defer s.Close()
+	waitFor(t, "the first connection", func() bool { return s.Status().Connected && len(rec.kinds()) == 1 })
+
+	p.Close()
+	waitFor(t, "the disconnection", func() bool { return !s.Status().Connected })
+	if status := s.Status(); status.Error == "" || status.Writer == nil || status.Writer.PID != 1 {
+		t.Errorf("after the writer left: %+v", status)
+	}
+
+	p, err = Listen(path, Hello{PID: 2}, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	waitFor(t, "the reconnection", func() bool { return s.Status().Connected })
+	status := s.Status()
+	if status.Reconnects != 1 || status.Writer.PID != 2 || status.Error != "" {
+		t.Errorf("after reconnecting: %+v", status)
+	}
+	waitFor(t, "the state", func() bool { return len(rec.kinds()) == 2 })
+	p.Publish(KindPattern, nil)
+	waitFor(t, "an event", func() bool { return len(rec.kinds()) == 3 })
+}
+
+// A writer of another protocol version is refused without handing on any of
+// its frames, and retried later
+func TestSubscriberVersionMismatch(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan struct{}, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(rawFrame(`{"v":2,"kind":"hello","data":{"pid":7}}`))
+			conn.Write(rawFrame(`{"v":2,"kind":"pattern","data":{}}`))
+			conn.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	var rec recorder
+	s := Subscribe(path, rec.handle)
+	defer s.Close()
+	<-accepted
+	waitFor(t, "the refusal", func() bool { return strings.Contains(s.Status().Error, ErrVersion.Error()) })
+	if status := s.Status(); status.Connected || status.Writer != nil {
+		t.Errorf("connected to a writer of another version: %+v", status)
+	}
+	<-accepted // Retried
+	if kinds := rec.kinds(); len(kinds) != 0 {
+		t.Errorf("handed on %v", kinds)
+	}
+}
+
+// A connection that doesn't start with a hello is dropped
+func TestSubscriberNoHello(t *testing.T) {
+	path := socketPath(t)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, _ := encodeFrame(KindPattern, nil)
+		conn.Write(frame)
+		io.Copy(io.Discard, conn)
+	}()
+
+	var rec recorder
+	s := Subscribe(path, rec.handle)
+	defer s.Close()
+	waitFor(t, "the refusal", func() bool { return strings.Contains(s.Status().Error, "instead of a hello") })
+	if kinds := rec.kinds(); len(kinds) != 0 {
+		t.Errorf("handed on %v", kinds)
+	}
+}
+
+// Once Close returns, nothing is handed on and nothing reconnects
+func TestSubscriberClose(t *testing.T) {
+	path := socketPath(t)
+	p, err := Listen(path, Hello{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var rec recorder
+	s := Subscribe(path, rec.handle)
+	waitFor(t, "the reader", func() bool { return p.Readers() == 1 })
+	s.Close()
+	s.Close()
+	p.Publish(KindPattern, nil)
+	waitFor(t, "the reader to leave", func() bool { return p.Readers() == 0 })
+	if kinds := rec.kinds(); len(kinds) != 0 {
+		t.Errorf("handed on %v after Close", kinds)
+	}
+}
+
+// The relay hands patterns to the subscribers whose filter accepts them,
+// anomalies and device changes to all, and mirrors interfaces
+func TestRelay(t *testing.T) {
+	registry := ifaces.NewRegistry()
+	history := []*models.Anomaly{{ID: "a-1"}}
+	r := NewRelay(registry, func() []*models.Anomaly { return history })
+	frame := func(kind string, data any) Frame {
+		encoded, err := encodeFrame(kind, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := ReadFrame(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return frame
+	}
+
+	_, all, unsubscribeAll := r.SubscribePatterns(nil, false)
+	defer unsubscribeAll()
+	_, tls, unsubscribeTLS := r.SubscribePatterns(func(p *models.CommunicationPattern) bool { return p.Protocol == "TLS" }, false)
+	defer unsubscribeTLS()
+	recent, anomalies, unsubscribeAnomalies := r.SubscribeAnomalies(true)
+	defer unsubscribeAnomalies()
+	devices, unsubscribeDevices := r.SubscribeDeviceChanges()
+	defer unsubscribeDevices()
+	if len(recent) != 1 || recent[0].ID != "a-1" {
+		t.Errorf("history = %v", recent)
+	}
+
+	r.Handle(frame(KindPattern, models.CommunicationPattern{Protocol: "DNS"}))
+	r.Handle(frame(KindPattern, models.CommunicationPattern{Protocol: "TLS"}))
+	r.Handle(frame(KindPattern, "not a pattern"))
+	r.Handle(frame("later_kind", map[string]int{"x": 1}))
+	r.Handle(frame(KindAnomaly, models.Anomaly{ID: "a-2"}))
+	r.Handle(frame(KindDevice, models.DeviceUpdate{DeviceID: "aa:bb:cc:dd:ee:ff"}))
+	r.Handle(frame(KindInterface, models.InterfaceStatus{Index: 3, Name: "eth1", Attached: true, Events: 12}))
+
+	if len(all) != 2 || (<-all).Protocol != "DNS" || (<-all).Protocol != "TLS" {
+		t.Error("unfiltered subscriber missed patterns")
+	}
+	if len(tls) != 1 || (<-tls).Protocol != "TLS" {
+		t.Error("filtered subscriber got the wrong patterns")
+	}
+	replayed, _, unsubscribeReplayed := r.SubscribePatterns(nil, true)
+	unsubscribeReplayed()
+	if len(replayed) != 2 || replayed[1].Protocol != "TLS" {
+		t.Errorf("pattern history = %v", replayed)
+	}
+	if len(anomalies) != 1 || (<-anomalies).ID != "a-2" {
+		t.Error("anomaly not relayed")
+	}
+	if len(devices) != 1 || (<-devices).DeviceID != "aa:bb:cc:dd:ee:ff" {
+		t.Error("device change not relayed")
+	}
+	if status, ok := registry.Get(3); !ok || status.Name != "eth1" || !status.Attached || status.Events != 12 {
+		t.Errorf("mirrored interface = %+v, %v", status, ok)
+	}
+
+	unsubscribeAll()
+	unsubscribeAll()
+	if _, open := <-all; open {
+		t.Error("channel open after unsubscribing")
+	}
+	r.Handle(frame(KindPattern, models.CommunicationPattern{Protocol: "TLS"}))
+	if len(tls) != 1 {
+		t.Error("remaining subscriber missed a pattern")
+	}
+}
@@ -0,0 +1,108 @@
+// Package eventsock carries live events from the capturing cerberus process
+// to api-only processes sharing its data directory, over a unix socket in it.
+//
+// The writer listens on the socket while it runs, so the socket file
+// advertises its presence. Each connection carries frames in one direction,
+// from the writer: a 4-byte big-endian length followed by that many bytes of
+// JSON, an envelope carrying the protocol version, the kind of event and the
+// event itself. The first frame is a hello; the writer then sends its current
+// state (interfaces), and from then on every event as it happens, with a ping
+// when idle so readers notice a hung writer.
+package eventsock
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Version is the protocol version. Readers refuse frames of another version,
+// such as from a writer of another release, and retry later.
+const Version = 1
+
+// SocketFile is the name of the socket in the data directory
+const SocketFile = "cerberus.sock"
+
+// MaxFrame bounds the length of a frame
+const MaxFrame = 1 << 20
+
+// PingInterval is how often an idle writer sends a ping; readers give up on
+// a writer silent for three of them
+const PingInterval = 10 * time.Second
+
+// Frame kinds
+const (
+	KindHello     = "hello"     // Hello, always first
+	KindPing      = "ping"      // No data
+	KindPattern   = "pattern"   // models.CommunicationPattern
+	KindAnomaly   = "anomaly"   // models.Anomaly
+	KindDevice    = "device"    // models.DeviceUpdate
+	KindInterface = "interface" // models.InterfaceStatus
+)
+
+// ErrVersion is returned when a frame has another protocol version
+var ErrVersion = errors.New("event socket protocol version mismatch")
+
+// Frame is the envelope of every event
+type Frame struct {
+	Version int             `json:"v"`
+	Kind    string          `json:"kind"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Hello describes the writer
+type Hello struct {
+	PID     int       `json:"pid"`
+	Version string    `json:"version"` // Release of the writer
+	Started time.Time `json:"started"`
+}
+
+// encodeFrame returns the length-prefixed frame of an event
+func encodeFrame(kind string, data any) ([]byte, error) {
+	frame := Frame{Version: Version, Kind: kind}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		frame.Data = raw
+	}
+	body, err := json.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxFrame {
+		return nil, fmt.Errorf("%s frame of %d bytes exceeds %d", kind, len(body), MaxFrame)
+	}
+	buf := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(buf, uint32(len(body)))
+	copy(buf[4:], body)
+	return buf, nil
+}
+
+// ReadFrame reads the next frame, checking its length and version
+func ReadFrame(r io.Reader) (Frame, error) {
+	var frame Frame
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrame {
+		return frame, fmt.Errorf("frame of %d bytes exceeds %d", size, MaxFrame)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return frame, err
+	}
+	if err := json.Unmarshal(body, &frame); err != nil {
+		return frame, fmt.Errorf("invalid frame: %w", err)
+	}
+	if frame.Version != Version {
+		return frame, fmt.Errorf("%w: got %d, expected %d", ErrVersion, frame.Version, Version)
+	}
+	return frame, nil
+}
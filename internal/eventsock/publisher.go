@@ -0,0 +1,215 @@
+package eventsock
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientQueue is the number of frames buffered per reader. A reader falling
+// further behind misses frames rather than hold back the writer.
+const clientQueue = 256
+
+// ErrWriterRunning is returned by Listen when another process answers on the
+// socket
+var ErrWriterRunning = errors.New("another cerberus process is writing to this data directory")
+
+// Event is a frame to be sent: a kind and its data
+type Event struct {
+	Kind string
+	Data any
+}
+
+// Publisher sends events to every reader connected to the socket
+type Publisher struct {
+	path     string
+	listener net.Listener
+	hello    []byte
+	state    func() []Event // Sent to each reader after the hello
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+	dropped atomic.Uint64
+}
+
+type client struct {
+	conn   net.Conn
+	frames chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Listen creates the socket at path and accepts readers in the background. A
+// socket left behind by a writer that exited is replaced; one another writer
+// still answers on is not. state returns the events bringing a new reader up
+// to date, such as the current interfaces, and may be nil.
+func Listen(path string, hello Hello, state func() []Event) (*Publisher, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s answers", ErrWriterRunning, path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	helloFrame, err := encodeFrame(KindHello, hello)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Readers run as another user sharing the writer's group
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	p := &Publisher{
+		path:     path,
+		listener: listener,
+		hello:    helloFrame,
+		state:    state,
+		clients:  make(map[*client]struct{}),
+	}
+	go p.accept()
+	return p, nil
+}
+
+func (p *Publisher) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, frames: make(chan []byte, clientQueue), done: make(chan struct{})}
+
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		// Queued before the client is registered, so events published
+		// meanwhile follow the state they update
+		c.frames <- p.hello
+		if p.state != nil {
+			for _, event := range p.state() {
+				if frame, err := encodeFrame(event.Kind, event.Data); err == nil {
+					c.queue(frame)
+				}
+			}
+		}
+		p.clients[c] = struct{}{}
+		p.mu.Unlock()
+
+		go p.serve(c)
+	}
+}
+
+// queue adds a frame to the client's queue, or reports false when it's full
+func (c *client) queue(frame []byte) bool {
+	select {
+	case c.frames <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// serve writes the queued frames of a client, and a ping whenever it has
+// been idle for PingInterval, until either side closes the connection
+func (p *Publisher) serve(c *client) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, c)
+		p.mu.Unlock()
+		c.close()
+	}()
+
+	ping, _ := encodeFrame(KindPing, nil)
+	idle := time.NewTimer(PingInterval)
+	defer idle.Stop()
+	for {
+		var frame []byte
+		select {
+		case <-c.done:
+			return
+		case frame = <-c.frames:
+		case <-idle.C:
+			frame = ping
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(3 * PingInterval))
+		if _, err := c.conn.Write(frame); err != nil {
+			return
+		}
+		idle.Reset(PingInterval)
+	}
+}
+
+// Publish sends an event to every connected reader. Readers whose queue is
+// full miss it, see Dropped.
+func (p *Publisher) Publish(kind string, data any) error {
+	frame, err := encodeFrame(kind, data)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.clients {
+		if !c.queue(frame) {
+			p.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Readers returns the number of connected readers
+func (p *Publisher) Readers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.clients)
+}
+
+// Dropped returns the number of frames readers missed because they fell
+// behind
+func (p *Publisher) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Close disconnects every reader and removes the socket, so readers know the
+// writer is gone
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	clients := p.clients
+	p.clients = make(map[*client]struct{})
+	p.mu.Unlock()
+
+	err := p.listener.Close()
+	for c := range clients {
+		c.close()
+	}
+	// The listener removes the socket file on close; this covers the rest
+	if removeErr := os.Remove(p.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+		err = removeErr
+	}
+	return err
+}
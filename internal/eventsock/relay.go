@@ -0,0 +1,163 @@
+package eventsock
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils/ring"
+)
+
+// maxRecentPatterns bounds the relayed patterns kept to replay to
+// subscribers, as many as the writer's monitor keeps
+const maxRecentPatterns = 1000
+
+// Relay hands the events read by a Subscriber to local subscribers, the way
+// the writer's monitor hands them to its own. Its methods match those of
+// the monitor the API streams from.
+type Relay struct {
+	interfaces *ifaces.Registry
+	history    func() []*models.Anomaly
+
+	mu        sync.Mutex
+	patterns  map[chan *models.CommunicationPattern]func(*models.CommunicationPattern) bool
+	recent    *ring.Buffer[*models.CommunicationPattern] // The latest patterns relayed
+	anomalies map[chan *models.Anomaly]struct{}
+	devices   map[chan *models.DeviceUpdate]struct{}
+}
+
+// NewRelay returns a relay mirroring interface events into a registry.
+// history returns the anomalies replayed to new anomaly subscribers, such as
+// those persisted by the writer.
+func NewRelay(interfaces *ifaces.Registry, history func() []*models.Anomaly) *Relay {
+	return &Relay{
+		interfaces: interfaces,
+		history:    history,
+		patterns:   make(map[chan *models.CommunicationPattern]func(*models.CommunicationPattern) bool),
+		recent:     ring.New[*models.CommunicationPattern](maxRecentPatterns),
+		anomalies:  make(map[chan *models.Anomaly]struct{}),
+		devices:    make(map[chan *models.DeviceUpdate]struct{}),
+	}
+}
+
+// Handle decodes an event frame and hands it on. Frames of unknown kinds,
+// such as those added by a later writer of the same protocol version, are
+// skipped.
+func (r *Relay) Handle(frame Frame) {
+	switch frame.Kind {
+	case KindPattern:
+		var pattern models.CommunicationPattern
+		if json.Unmarshal(frame.Data, &pattern) != nil {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.recent.Add(&pattern)
+		for sub, match := range r.patterns {
+			if match != nil && !match(&pattern) {
+				continue
+			}
+			// Slow subscribers miss events rather than hold back the others
+			select {
+			case sub <- &pattern:
+			default:
+			}
+		}
+	case KindAnomaly:
+		var anomaly models.Anomaly
+		if json.Unmarshal(frame.Data, &anomaly) != nil {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for sub := range r.anomalies {
+			select {
+			case sub <- &anomaly:
+			default:
+			}
+		}
+	case KindDevice:
+		var change models.DeviceUpdate
+		if json.Unmarshal(frame.Data, &change) != nil {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for sub := range r.devices {
+			select {
+			case sub <- &change:
+			default:
+			}
+		}
+	case KindInterface:
+		var status models.InterfaceStatus
+		if json.Unmarshal(frame.Data, &status) != nil {
+			return
+		}
+		r.interfaces.Mirror(status)
+	}
+}
+
+// SubscribePatterns returns the latest relayed patterns when asked, a channel
+// receiving the new patterns match accepts, or all with a nil match, and a
+// function ending the subscription
+func (r *Relay) SubscribePatterns(match func(*models.CommunicationPattern) bool, history bool) ([]*models.CommunicationPattern, <-chan *models.CommunicationPattern, func()) {
+	sub := make(chan *models.CommunicationPattern, 64)
+	r.mu.Lock()
+	var recent []*models.CommunicationPattern
+	if history {
+		recent = r.recent.Values()
+	}
+	r.patterns[sub] = match
+	r.mu.Unlock()
+
+	return recent, sub, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.patterns[sub]; ok {
+			delete(r.patterns, sub)
+			close(sub)
+		}
+	}
+}
+
+// SubscribeAnomalies returns the anomaly history when asked, a channel
+// receiving new anomalies and a function ending the subscription
+func (r *Relay) SubscribeAnomalies(history bool) ([]*models.Anomaly, <-chan *models.Anomaly, func()) {
+	sub := make(chan *models.Anomaly, 16)
+	r.mu.Lock()
+	r.anomalies[sub] = struct{}{}
+	r.mu.Unlock()
+
+	var recent []*models.Anomaly
+	if history && r.history != nil {
+		recent = r.history()
+	}
+	return recent, sub, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.anomalies[sub]; ok {
+			delete(r.anomalies, sub)
+			close(sub)
+		}
+	}
+}
+
+// SubscribeDeviceChanges returns a channel receiving reported device changes
+// and a function ending the subscription
+func (r *Relay) SubscribeDeviceChanges() (<-chan *models.DeviceUpdate, func()) {
+	sub := make(chan *models.DeviceUpdate, 16)
+	r.mu.Lock()
+	r.devices[sub] = struct{}{}
+	r.mu.Unlock()
+
+	return sub, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.devices[sub]; ok {
+			delete(r.devices, sub)
+			close(sub)
+		}
+	}
+}
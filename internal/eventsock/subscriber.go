@@ -0,0 +1,165 @@
+package eventsock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reconnect backoff: the first retry comes after minBackoff, each following
+// one waits twice as long, up to maxBackoff
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Status describes the connection of a Subscriber to the writer
+type Status struct {
+	Connected  bool
+	Since      time.Time // Of the current connection, or of the disconnection
+	Writer     *Hello    // Of the current or last connection
+	Error      string    // Why the last connection ended or failed
+	Reconnects int       // Connections made after the first
+}
+
+// Subscriber reads the events of the writer listening on a socket,
+// reconnecting whenever the connection fails or ends
+type Subscriber struct {
+	path   string
+	handle func(Frame)
+
+	mu        sync.Mutex
+	status    Status
+	connected bool // Ever
+	conn      net.Conn
+	closed    bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// Subscribe connects to the writer listening at path in the background and
+// calls handle with every event frame it sends, in order, from one
+// goroutine. Hellos and pings aren't handed on. After a reconnect the writer
+// sends its state again.
+func Subscribe(path string, handle func(Frame)) *Subscriber {
+	s := &Subscriber{
+		path:   path,
+		handle: handle,
+		status: Status{Since: time.Now()},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *Subscriber) run() {
+	defer close(s.done)
+	backoff := minBackoff
+	for {
+		err := s.read()
+
+		s.mu.Lock()
+		if s.status.Connected {
+			// A connection that got as far as the hello resets the backoff
+			backoff = minBackoff
+			s.status.Since = time.Now()
+		}
+		s.status.Connected = false
+		s.status.Error = err.Error()
+		s.conn = nil
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// read connects to the writer and handles its frames until the connection
+// ends. It never returns a nil error.
+func (s *Subscriber) read() error {
+	conn, err := net.DialTimeout("unix", s.path, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("writer not reachable: %w", err)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("subscriber closed")
+	}
+	s.conn = conn
+	s.mu.Unlock()
+	defer conn.Close()
+
+	// A writer that sends nothing for three pings is hung
+	conn.SetReadDeadline(time.Now().Add(3 * PingInterval))
+	frame, err := ReadFrame(conn)
+	if err != nil {
+		return err
+	}
+	var hello Hello
+	if frame.Kind != KindHello || json.Unmarshal(frame.Data, &hello) != nil {
+		return fmt.Errorf("writer sent %q instead of a hello", frame.Kind)
+	}
+
+	s.mu.Lock()
+	if s.connected {
+		s.status.Reconnects++
+	}
+	s.connected = true
+	s.status = Status{Connected: true, Since: time.Now(), Writer: &hello, Reconnects: s.status.Reconnects}
+	s.mu.Unlock()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(3 * PingInterval))
+		frame, err := ReadFrame(conn)
+		if err != nil {
+			return err
+		}
+		if frame.Kind != KindPing && frame.Kind != KindHello {
+			s.handle(frame)
+		}
+	}
+}
+
+// Status returns the state of the connection to the writer
+func (s *Subscriber) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	if status.Writer != nil {
+		writer := *status.Writer
+		status.Writer = &writer
+	}
+	return status
+}
+
+// Close disconnects and stops reconnecting. handle is not called once Close
+// returns.
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+}
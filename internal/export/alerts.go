@@ -0,0 +1,369 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// alertQueueSize bounds the events queued for each alert destination; events
+// routed to a full queue fail at once rather than hold back notifications
+const alertQueueSize = 256
+
+// alertTimeout bounds each webhook request and syslog write
+const alertTimeout = 10 * time.Second
+
+// Alert destination types in an alert destinations file
+const (
+	AlertWebhook = "webhook"
+	AlertSyslog  = "syslog"
+	AlertDigest  = "digest"
+)
+
+// alertItem is an event queued for a destination, encoded when routed
+type alertItem struct {
+	recordType string
+	severity   string // Of anomalies, for syslog
+	at         time.Time
+	body       []byte // JSON-lines record
+	done       func(error)
+}
+
+// alertQueue hands the events routed to a destination to a single sender
+// goroutine, so a slow destination never blocks the notifiers
+type alertQueue struct {
+	items  chan alertItem
+	send   func(alertItem) error
+	done   chan struct{}
+	mu     sync.RWMutex // Guards closed against concurrent Deliver
+	closed bool
+}
+
+func newAlertQueue(send func(alertItem) error) *alertQueue {
+	q := &alertQueue{
+		items: make(chan alertItem, alertQueueSize),
+		send:  send,
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Deliver queues an event, failing it if the queue is full or closed
+func (q *alertQueue) Deliver(recordType string, data any, done func(error)) {
+	if done == nil {
+		done = func(error) {}
+	}
+	now := time.Now()
+	body, err := json.Marshal(jsonRecord{Type: recordType, Time: now, Data: data})
+	if err != nil {
+		done(err)
+		return
+	}
+	item := alertItem{recordType: recordType, at: now, body: body, done: done}
+	if anomaly, ok := data.(*models.Anomaly); ok {
+		item.severity = anomaly.Severity
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		done(fmt.Errorf("destination closed"))
+		return
+	}
+	select {
+	case q.items <- item:
+	default:
+		done(fmt.Errorf("queue full, %d events pending", alertQueueSize))
+	}
+}
+
+// Emit queues an event, so a destination can be the target of a Digest
+func (q *alertQueue) Emit(recordType string, data any) {
+	q.Deliver(recordType, data, nil)
+}
+
+func (q *alertQueue) run() {
+	defer close(q.done)
+	for item := range q.items {
+		item.done(q.send(item))
+	}
+}
+
+// Close sends the queued events and stops the sender
+func (q *alertQueue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
+
+// Webhook posts each event routed to it as a JSON-lines record, such as
+// {"type":"anomaly","time":"...","data":{...}}
+type Webhook struct {
+	*alertQueue
+	url    string
+	header http.Header
+	client *http.Client
+}
+
+// NewWebhook starts posting routed events to url with the given extra headers
+func NewWebhook(rawURL string, header map[string]string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	w := &Webhook{
+		url:    rawURL,
+		header: make(http.Header),
+		client: &http.Client{Timeout: alertTimeout},
+	}
+	for name, value := range header {
+		w.header.Set(name, value)
+	}
+	w.alertQueue = newAlertQueue(w.post)
+	return w, nil
+}
+
+func (w *Webhook) post(item alertItem) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(item.body))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// syslogFacility is local0, shifted into the priority value
+const syslogFacility = 16 << 3
+
+// syslogSeverities maps anomaly severities to syslog severities; other
+// events are informational
+var syslogSeverities = map[string]int{
+	models.SeverityHigh:   3, // Error
+	models.SeverityMedium: 4, // Warning
+	models.SeverityLow:    5, // Notice
+	models.SeverityInfo:   6, // Informational
+}
+
+// Syslog sends each event routed to it as an RFC 5424 message whose body is
+// the JSON-lines record, over UDP or TCP (octet-counted, RFC 6587)
+type Syslog struct {
+	*alertQueue
+	network  string
+	address  string
+	hostname string
+	conn     net.Conn // Owned by the sender goroutine
+}
+
+// NewSyslog starts sending routed events to an address such as
+// udp://10.0.0.5:514 or tcp://syslog.lan:601
+func NewSyslog(address string) (*Syslog, error) {
+	network, hostPort, ok := strings.Cut(address, "://")
+	if !ok || (network != "udp" && network != "tcp") {
+		return nil, fmt.Errorf("invalid syslog address %q: expected udp://host:port or tcp://host:port", address)
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %v", address, err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &Syslog{network: network, address: hostPort, hostname: hostname}
+	s.alertQueue = newAlertQueue(s.write)
+	return s, nil
+}
+
+func (s *Syslog) write(item alertItem) error {
+	severity, ok := syslogSeverities[item.severity]
+	if !ok {
+		severity = 6
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s cerberus - %s - %s",
+		syslogFacility+severity, item.at.UTC().Format(time.RFC3339Nano), s.hostname, item.recordType, item.body)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	// A connection that failed is dialed again for the next message
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, alertTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(alertTimeout))
+	if _, err := io.WriteString(s.conn, msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close sends the queued events and closes the connection
+func (s *Syslog) Close() {
+	s.alertQueue.Close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// digestDestination batches the anomalies routed to it in a Digest in front
+// of another destination. An event counts as delivered once handed over.
+type digestDestination struct {
+	*Digest
+}
+
+func (d digestDestination) Deliver(recordType string, data any, done func(error)) {
+	d.Emit(recordType, data)
+	if done != nil {
+		done(nil)
+	}
+}
+
+// alertDestinationConfig is one destination of an alert destinations file
+type alertDestinationConfig struct {
+	Type        string            `json:"type"`
+	URL         string            `json:"url"`          // webhook
+	Headers     map[string]string `json:"headers"`      // webhook, e.g. Authorization
+	Address     string            `json:"address"`      // syslog
+	Target      string            `json:"target"`       // digest: the webhook or syslog destination it feeds
+	Interval    string            `json:"interval"`     // digest
+	MinSeverity string            `json:"min_severity"` // digest: anomalies at or above it go through at once
+	MaxItems    int               `json:"max_items"`    // digest
+}
+
+// AlertDestinations are the destinations read by LoadAlertDestinations
+type AlertDestinations struct {
+	Destinations map[string]monitor.AlertDestination
+	digests      []*Digest
+	queues       []interface{ Close() }
+}
+
+// LoadAlertDestinations reads named alert destinations from the
+// "destinations" section of a JSON file and starts them
+func LoadAlertDestinations(path string) (*AlertDestinations, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file struct {
+		Destinations map[string]alertDestinationConfig `json:"destinations"`
+	}
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	loaded := &AlertDestinations{Destinations: make(map[string]monitor.AlertDestination)}
+	targets := make(map[string]monitor.EventSink)
+	// Digests feed other destinations, so those are started first
+	for name, config := range file.Destinations {
+		var destination interface {
+			monitor.AlertDestination
+			monitor.EventSink
+			Close()
+		}
+		switch config.Type {
+		case AlertWebhook:
+			destination, err = NewWebhook(config.URL, config.Headers)
+		case AlertSyslog:
+			destination, err = NewSyslog(config.Address)
+		case AlertDigest:
+			continue
+		default:
+			err = fmt.Errorf("unknown type %q: expected %s, %s or %s", config.Type, AlertWebhook, AlertSyslog, AlertDigest)
+		}
+		if err != nil {
+			loaded.Close()
+			return nil, fmt.Errorf("%s: destination %s: %w", path, name, err)
+		}
+		loaded.Destinations[name] = destination
+		loaded.queues = append(loaded.queues, destination)
+		targets[name] = destination
+	}
+
+	for name, config := range file.Destinations {
+		if config.Type != AlertDigest {
+			continue
+		}
+		digest, err := newDigestDestination(config, targets)
+		if err != nil {
+			loaded.Close()
+			return nil, fmt.Errorf("%s: destination %s: %w", path, name, err)
+		}
+		loaded.Destinations[name] = digestDestination{digest}
+		loaded.digests = append(loaded.digests, digest)
+	}
+	return loaded, nil
+}
+
+// newDigestDestination starts the Digest of a digest destination
+func newDigestDestination(config alertDestinationConfig, targets map[string]monitor.EventSink) (*Digest, error) {
+	target, ok := targets[config.Target]
+	if !ok {
+		return nil, fmt.Errorf("target %q is not a webhook or syslog destination", config.Target)
+	}
+	interval, err := time.ParseDuration(config.Interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval %q", config.Interval)
+	}
+	if config.MinSeverity == "" {
+		config.MinSeverity = models.SeverityHigh
+	}
+	if config.MaxItems == 0 {
+		config.MaxItems = 20
+	}
+	return NewDigest(target, DigestConfig{
+		Interval:    interval,
+		MinSeverity: strings.ToUpper(config.MinSeverity),
+		MaxItems:    config.MaxItems,
+	})
+}
+
+// Close emits the pending digests, then sends the queued events of every
+// destination
+func (a *AlertDestinations) Close() {
+	for _, digest := range a.digests {
+		digest.Close()
+	}
+	for _, queue := range a.queues {
+		queue.Close()
+	}
+}
@@ -0,0 +1,172 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// digestTopDevices is how many devices a digest lists by anomaly count
+const digestTopDevices = 5
+
+// DigestConfig controls which anomalies a Digest batches and how often
+type DigestConfig struct {
+	Interval    time.Duration // How often pending anomalies are summarized
+	MinSeverity string        // Anomalies at or above it are passed on at once
+	MaxItems    int           // Anomalies listed per digest; the rest are counted
+}
+
+// Digest sits in front of an EventSink and holds back anomalies below
+// MinSeverity, emitting them every Interval as a single monitor.SinkAnomalyDigest
+// record. Every other record goes through unchanged. Nothing is emitted for an
+// interval without anomalies.
+type Digest struct {
+	sink    monitor.EventSink
+	config  DigestConfig
+	minRank int
+
+	mu      sync.Mutex
+	pending []*models.Anomaly
+	since   time.Time
+	closed  bool // Anomalies emitted after Close go straight through
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDigest starts batching the low-severity anomalies emitted to sink
+func NewDigest(sink monitor.EventSink, config DigestConfig) (*Digest, error) {
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("digest interval must be positive")
+	}
+	if config.MaxItems <= 0 {
+		return nil, fmt.Errorf("digest item cap must be positive")
+	}
+	rank := models.SeverityRank(config.MinSeverity)
+	if rank < 0 {
+		return nil, fmt.Errorf("unknown severity %q", config.MinSeverity)
+	}
+
+	d := &Digest{
+		sink:    sink,
+		config:  config,
+		minRank: rank,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go d.run()
+	return d, nil
+}
+
+// Emit batches anomalies below the minimum severity and passes on the rest
+func (d *Digest) Emit(recordType string, data any) {
+	if anomaly, ok := data.(*models.Anomaly); ok && recordType == monitor.SinkAnomaly &&
+		models.SeverityRank(anomaly.Severity) < d.minRank {
+		d.mu.Lock()
+		if !d.closed {
+			if len(d.pending) == 0 {
+				d.since = time.Now()
+			}
+			d.pending = append(d.pending, anomaly)
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+	}
+	d.sink.Emit(recordType, data)
+}
+
+func (d *Digest) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			d.flush(time.Now())
+			return
+		case now := <-ticker.C:
+			d.flush(now)
+		}
+	}
+}
+
+// flush emits the pending anomalies as one digest, if there are any
+func (d *Digest) flush(now time.Time) {
+	d.mu.Lock()
+	pending, since := d.pending, d.since
+	d.pending = nil
+	d.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	d.sink.Emit(monitor.SinkAnomalyDigest, summarize(pending, since, now, d.config.MaxItems))
+}
+
+// summarize builds the digest of anomalies collected between from and to,
+// listing at most maxItems of them
+func summarize(anomalies []*models.Anomaly, from, to time.Time, maxItems int) *models.AnomalyDigest {
+	digest := &models.AnomalyDigest{
+		From:   from,
+		To:     to,
+		Total:  len(anomalies),
+		ByType: make(map[string]int),
+	}
+
+	perDevice := make(map[string]int)
+	for i, a := range anomalies {
+		digest.ByType[a.Type]++
+		if a.DeviceID != "" {
+			perDevice[a.DeviceID]++
+		}
+		if i < maxItems {
+			digest.Items = append(digest.Items, models.DigestItem{
+				ID:       a.ID,
+				Type:     a.Type,
+				Severity: a.Severity,
+				DeviceID: a.DeviceID,
+				Time:     a.Timestamp,
+			})
+		}
+	}
+
+	for id, n := range perDevice {
+		digest.TopDevices = append(digest.TopDevices, models.DeviceCount{DeviceID: id, Count: n})
+	}
+	sort.Slice(digest.TopDevices, func(i, j int) bool {
+		a, b := digest.TopDevices[i], digest.TopDevices[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.DeviceID < b.DeviceID
+	})
+	if len(digest.TopDevices) > digestTopDevices {
+		digest.TopDevices = digest.TopDevices[:digestTopDevices]
+	}
+
+	if more := len(anomalies) - len(digest.Items); more > 0 {
+		digest.More = more
+		digest.Overflow = fmt.Sprintf("and %d more", more)
+	}
+	return digest
+}
+
+// Close emits what is still pending and stops the ticker. Closing the
+// underlying sink is left to the caller, after this returns.
+func (d *Digest) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.stop)
+	}
+	d.mu.Unlock()
+
+	<-d.done
+}
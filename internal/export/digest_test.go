@@ -0,0 +1,151 @@
+package export
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// recordingSink keeps what is emitted to it
+type recordingSink struct {
+	mu      sync.Mutex
+	records []sinkRecord
+}
+
+type sinkRecord struct {
+	recordType string
+	data       any
+}
+
+func (s *recordingSink) Emit(recordType string, data any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, sinkRecord{recordType, data})
+}
+
+func (s *recordingSink) emitted() []sinkRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sinkRecord(nil), s.records...)
+}
+
+func anomaly(id, severity, device string) *models.Anomaly {
+	return &models.Anomaly{ID: id, Type: "PORT_SCAN", Severity: severity, DeviceID: device}
+}
+
+// Anomalies below the minimum severity wait for the digest; anything else is
+// passed on as it comes, and Close flushes the batch
+func TestDigestBatchesLowSeverity(t *testing.T) {
+	sink := &recordingSink{}
+	d, err := NewDigest(sink, DigestConfig{Interval: time.Hour, MinSeverity: models.SeverityMedium, MaxItems: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Emit(monitor.SinkAnomaly, anomaly("1", models.SeverityLow, "a"))
+	d.Emit(monitor.SinkAnomaly, anomaly("2", models.SeverityHigh, "a"))
+	d.Emit(monitor.SinkAnomaly, anomaly("3", models.SeverityInfo, "b"))
+	d.Emit(monitor.SinkAnomaly, anomaly("4", models.SeverityMedium, "b"))
+	d.Emit(monitor.SinkStats, "not an anomaly")
+
+	records := sink.emitted()
+	if len(records) != 3 ||
+		records[0].data.(*models.Anomaly).ID != "2" || records[1].data.(*models.Anomaly).ID != "4" ||
+		records[2].recordType != monitor.SinkStats {
+		t.Fatalf("passed on %+v, want anomalies 2 and 4 and the stats record", records)
+	}
+
+	d.Close()
+	records = sink.emitted()
+	if len(records) != 4 || records[3].recordType != monitor.SinkAnomalyDigest {
+		t.Fatalf("after Close: %+v, want a digest last", records)
+	}
+	digest := records[3].data.(*models.AnomalyDigest)
+	if digest.Total != 2 || len(digest.Items) != 2 || digest.Items[0].ID != "1" || digest.Items[1].ID != "3" {
+		t.Errorf("digest = %+v, want anomalies 1 and 3", digest)
+	}
+
+	// Once closed, nothing is held back
+	d.Emit(monitor.SinkAnomaly, anomaly("5", models.SeverityLow, "a"))
+	if records := sink.emitted(); len(records) != 5 || records[4].recordType != monitor.SinkAnomaly {
+		t.Errorf("after Close, a low anomaly was not passed on: %+v", records)
+	}
+}
+
+// A digest is emitted every interval that had anomalies, and none otherwise
+func TestDigestInterval(t *testing.T) {
+	sink := &recordingSink{}
+	d, err := NewDigest(sink, DigestConfig{Interval: 20 * time.Millisecond, MinSeverity: models.SeverityHigh, MaxItems: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.Emit(monitor.SinkAnomaly, anomaly("1", models.SeverityLow, "a"))
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.emitted()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no digest emitted after the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Intervals without anomalies stay quiet
+	time.Sleep(100 * time.Millisecond)
+	if records := sink.emitted(); len(records) != 1 {
+		t.Errorf("%d records emitted, want one digest", len(records))
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	from := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	var anomalies []*models.Anomaly
+	// Device d<i> raises i+1 anomalies, and one anomaly has no device
+	for i := range 7 {
+		for j := range i + 1 {
+			anomalies = append(anomalies, anomaly(fmt.Sprintf("%d-%d", i, j), models.SeverityLow, fmt.Sprintf("d%d", i)))
+		}
+	}
+	anomalies = append(anomalies, &models.Anomaly{ID: "x", Type: "DNS_TUNNEL", Severity: models.SeverityInfo})
+
+	digest := summarize(anomalies, from, from.Add(time.Hour), 3)
+	if digest.Total != 29 || digest.ByType["PORT_SCAN"] != 28 || digest.ByType["DNS_TUNNEL"] != 1 {
+		t.Errorf("totals = %d %v, want 29 split 28/1", digest.Total, digest.ByType)
+	}
+	if len(digest.Items) != 3 || digest.More != 26 || digest.Overflow != "and 26 more" {
+		t.Errorf("items %d, more %d %q, want 3 and 26 more", len(digest.Items), digest.More, digest.Overflow)
+	}
+	if len(digest.TopDevices) != digestTopDevices {
+		t.Fatalf("%d top devices, want %d", len(digest.TopDevices), digestTopDevices)
+	}
+	for i, want := range []string{"d6", "d5", "d4", "d3", "d2"} {
+		if got := digest.TopDevices[i]; got.DeviceID != want || got.Count != 7-i {
+			t.Errorf("top device %d = %+v, want %s with %d", i, got, want, 7-i)
+		}
+	}
+	if !digest.From.Equal(from) || !digest.To.Equal(from.Add(time.Hour)) {
+		t.Errorf("digest spans %v-%v", digest.From, digest.To)
+	}
+
+	// Equal counts are listed by device
+	digest = summarize([]*models.Anomaly{anomaly("1", models.SeverityLow, "b"), anomaly("2", models.SeverityLow, "a")}, from, from, 10)
+	if digest.TopDevices[0].DeviceID != "a" || digest.More != 0 || digest.Overflow != "" {
+		t.Errorf("digest = %+v, want a first and nothing more", digest)
+	}
+}
+
+func TestNewDigestValidates(t *testing.T) {
+	for _, config := range []DigestConfig{
+		{Interval: 0, MinSeverity: models.SeverityLow, MaxItems: 1},
+		{Interval: time.Second, MinSeverity: models.SeverityLow, MaxItems: 0},
+		{Interval: time.Second, MinSeverity: "URGENT", MaxItems: 1},
+	} {
+		if d, err := NewDigest(&recordingSink{}, config); err == nil {
+			d.Close()
+			t.Errorf("NewDigest(%+v) succeeded, want an error", config)
+		}
+	}
+}
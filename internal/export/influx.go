@@ -0,0 +1,225 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// maxPendingBatches bounds how many unsent batches are kept while InfluxDB is unreachable
+const maxPendingBatches = 20
+
+// InfluxConfig holds the InfluxDB v2 write settings
+type InfluxConfig struct {
+	URL      string // Base URL, e.g. http://localhost:8086
+	Org      string
+	Bucket   string
+	Token    string
+	Interval time.Duration
+}
+
+// InfluxWriter periodically pushes device and aggregate metrics to InfluxDB
+// using the line protocol
+type InfluxWriter struct {
+	monitor  *monitor.NetworkMonitor
+	config   InfluxConfig
+	writeURL string
+	client   *http.Client
+	pending  [][]byte
+	failing  bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewInfluxWriter creates a writer for the given monitor and configuration
+func NewInfluxWriter(mon *monitor.NetworkMonitor, config InfluxConfig) (*InfluxWriter, error) {
+	base, err := url.Parse(config.URL)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q", config.URL)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("InfluxDB bucket is required")
+	}
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("InfluxDB interval must be positive")
+	}
+
+	query := url.Values{}
+	query.Set("bucket", config.Bucket)
+	query.Set("precision", "s")
+	if config.Org != "" {
+		query.Set("org", config.Org)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v2/write"
+	base.RawQuery = query.Encode()
+
+	return &InfluxWriter{
+		monitor:  mon,
+		config:   config,
+		writeURL: base.String(),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start begins exporting in the background. Collection and writes run on their
+// own goroutine so a slow or unreachable InfluxDB never blocks capture.
+func (w *InfluxWriter) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				// Final best-effort flush
+				w.enqueue(w.collect(time.Now()))
+				flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+				w.flush(flushCtx)
+				flushCancel()
+				return
+			case now := <-ticker.C:
+				w.enqueue(w.collect(now))
+				w.flush(ctx)
+			}
+		}
+	}()
+}
+
+// Stop halts the exporter after a final flush
+func (w *InfluxWriter) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// collect renders the current metrics as one line-protocol batch
+func (w *InfluxWriter) collect(now time.Time) []byte {
+	var buf bytes.Buffer
+	ts := now.Unix()
+
+	devices := w.monitor.ListDevices()
+	stats := w.monitor.Stats.Snapshot()
+
+	fmt.Fprintf(&buf, "cerberus_stats devices=%di,total_packets=%di,arp_packets=%di,tcp_packets=%di,udp_packets=%di,icmp_packets=%di,dns_packets=%di,http_packets=%di,tls_packets=%di,filtered_packets=%di,invalid_events=%di,failed_persists=%di %d\n",
+		len(devices),
+		stats.TotalPackets,
+		stats.ArpPackets,
+		stats.TcpPackets,
+		stats.UdpPackets,
+		stats.IcmpPackets,
+		stats.DnsPackets,
+		stats.HttpPackets,
+		stats.TlsPackets,
+		stats.FilteredPackets,
+		stats.InvalidEvents,
+		stats.FailedPersists,
+		ts)
+
+	for _, device := range devices {
+		score := 0
+		if risk, ok := w.monitor.DeviceRiskScore(device.ID); ok {
+			score = risk.Score
+		}
+
+		buf.WriteString("cerberus_device")
+		writeTag(&buf, "id", device.ID)
+		writeTag(&buf, "uuid", device.UUID)
+		writeTag(&buf, "mac", device.MAC)
+		writeTag(&buf, "ip", device.IP)
+		writeTag(&buf, "vendor", device.Vendor)
+		fmt.Fprintf(&buf, " request_count=%di,reply_count=%di,tcp_connections=%di,udp_connections=%di,icmp_packets=%di,dns_queries=%di,http_requests=%di,tls_connections=%di,targets=%di,risk_score=%di %d\n",
+			device.RequestCount,
+			device.ReplyCount,
+			device.TCPConnections,
+			device.UDPConnections,
+			device.ICMPPackets,
+			device.DNSQueries,
+			device.HTTPRequests,
+			device.TLSConnections,
+			device.Targets.Len(),
+			score,
+			ts)
+	}
+
+	return buf.Bytes()
+}
+
+// enqueue adds a batch, dropping the oldest when too many are pending
+func (w *InfluxWriter) enqueue(batch []byte) {
+	if len(w.pending) >= maxPendingBatches {
+		w.pending = w.pending[1:]
+	}
+	w.pending = append(w.pending, batch)
+}
+
+// flush sends pending batches in order, stopping at the first failure so the
+// rest are retried on the next tick
+func (w *InfluxWriter) flush(ctx context.Context) {
+	for len(w.pending) > 0 {
+		if err := w.write(ctx, w.pending[0]); err != nil {
+			if !w.failing {
+				fmt.Printf("InfluxDB write failed (will retry, %d batch(es) pending): %v\n", len(w.pending), err)
+				w.failing = true
+			}
+			return
+		}
+		w.pending = w.pending[1:]
+
+		if w.failing {
+			fmt.Println("InfluxDB writes recovered")
+			w.failing = false
+		}
+	}
+}
+
+func (w *InfluxWriter) write(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.writeURL, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.config.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.config.Token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// tagEscaper escapes the characters the line protocol reserves in tag keys and values
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// writeTag appends ",key=value", skipping empty values which the line protocol rejects
+func writeTag(buf *bytes.Buffer, key, value string) {
+	if value == "" {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	buf.WriteString(tagEscaper.Replace(value))
+}
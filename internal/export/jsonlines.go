@@ -0,0 +1,85 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonLinesBuffer bounds the records queued for the writer goroutine
+const jsonLinesBuffer = 1024
+
+// jsonRecord is one line of JSON-lines output
+type jsonRecord struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// JSONLines writes records as one JSON object per line, such as
+// {"type":"anomaly","time":"...","data":{...}}. The data of each type has the
+// schema of the matching API response. A single goroutine does all the
+// writing, so lines from concurrent emitters never interleave.
+type JSONLines struct {
+	out    io.Writer
+	now    func() time.Time // Stamps the records
+	lines  chan []byte
+	done   chan struct{}
+	mu     sync.RWMutex // Guards closed against concurrent Emit
+	closed bool
+}
+
+// NewJSONLines starts writing records to out
+func NewJSONLines(out io.Writer) *JSONLines {
+	j := &JSONLines{
+		out:   out,
+		now:   time.Now,
+		lines: make(chan []byte, jsonLinesBuffer),
+		done:  make(chan struct{}),
+	}
+	go j.run()
+	return j
+}
+
+// Emit queues a record of the given type. It blocks while the writer is
+// behind, and does nothing once the output is closed.
+func (j *JSONLines) Emit(recordType string, data any) {
+	line, err := json.Marshal(jsonRecord{Type: recordType, Time: j.now(), Data: data})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding %s record: %v\n", recordType, err)
+		return
+	}
+	line = append(line, '\n')
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if !j.closed {
+		j.lines <- line
+	}
+}
+
+func (j *JSONLines) run() {
+	defer close(j.done)
+
+	for line := range j.lines {
+		if _, err := j.out.Write(line); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON-lines output: %v\n", err)
+		}
+	}
+}
+
+// Close writes the queued records and stops the writer. Closing the
+// underlying writer is left to the caller.
+func (j *JSONLines) Close() {
+	j.mu.Lock()
+	if !j.closed {
+		j.closed = true
+		close(j.lines)
+	}
+	j.mu.Unlock()
+
+	<-j.done
+}
@@ -0,0 +1,149 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+var jsonLinesTime = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// newTestJSONLines returns JSON-lines output to buf stamping every record
+// with jsonLinesTime
+func newTestJSONLines(buf *bytes.Buffer) *JSONLines {
+	j := NewJSONLines(buf)
+	j.now = func() time.Time { return jsonLinesTime }
+	return j
+}
+
+// Each record type is written as one line with the schema of its API
+// response; the golden files pin those lines
+func TestJSONLinesRecords(t *testing.T) {
+	at := jsonLinesTime.Add(-time.Minute)
+	resolved := true
+	pattern := &models.CommunicationPattern{
+		ID: "p-1", DeviceID: "00:03:93:aa:00:01", DeviceUUID: "6f1c", SrcMAC: "00:03:93:aa:00:01",
+		SrcIP: "192.168.1.5", DstIP: "203.0.113.10", DstPort: 443, Protocol: "TLS",
+		TrafficType: models.TrafficTCPHTTPS, Service: "HTTPS", Timestamp: at, L7Info: "example.com",
+		Interface: "eth0", Resolved: &resolved,
+	}
+	device := &models.DeviceInfo{
+		ID: "00:03:93:aa:00:01", UUID: "6f1c", MAC: "00:03:93:aa:00:01", IP: "192.168.1.5",
+		Subnet: "192.168.1.0/24", Vendor: "Apple", RawVendor: "Apple, Inc.", Interface: "eth0",
+		FirstSeen: at, LastSeen: at, TCPConnections: 1, TLSConnections: 1,
+		TrafficTypeCounts: map[models.TrafficType]int{models.TrafficTCPHTTPS: 1},
+	}
+	anomalies := []*models.Anomaly{
+		anomaly("a-1", models.SeverityLow, "00:03:93:aa:00:01"),
+		anomaly("a-2", models.SeverityInfo, "02:00:00:cc:00:03"),
+	}
+	for i, a := range anomalies {
+		a.Description = "Port scan of 12 ports"
+		a.Details = map[string]string{"ports": "12"}
+		a.Timestamp = at.Add(time.Duration(i) * time.Second)
+	}
+
+	tests := []struct {
+		recordType string
+		data       any
+	}{
+		{monitor.SinkPattern, pattern},
+		{monitor.SinkPatternSummary, &models.PatternSummary{
+			DeviceID: "00:03:93:aa:00:01", DeviceUUID: "6f1c", Suppressed: 14, From: at.Add(-time.Minute), To: at,
+		}},
+		{monitor.SinkNewDevice, device},
+		{monitor.SinkDeviceChange, &models.DeviceUpdate{
+			ID: "c-1", DeviceID: "00:03:93:aa:00:01", DeviceUUID: "6f1c",
+			Changes:      map[string]models.FieldChange{"ip": {Old: "192.168.1.4", New: "192.168.1.5"}},
+			FirstChanged: at, Timestamp: at,
+		}},
+		{monitor.SinkDeviceIPChanged, &models.IPChange{
+			ID: "c-1", DeviceID: "00:03:93:aa:00:01", DeviceUUID: "6f1c", OldIP: "192.168.1.4", NewIP: "192.168.1.5",
+			OldIPHeldBy: "02:00:00:cc:00:03", FirstChanged: at, Timestamp: at,
+		}},
+		{monitor.SinkAnomaly, anomalies[0]},
+		{monitor.SinkAnomalyDigest, summarize(anomalies, at.Add(-time.Hour), at, 10)},
+		{monitor.SinkStats, models.StatsReport{
+			TotalDevices: 2, TotalPackets: 120, TcpPackets: 80, UdpPackets: 30, DnsPackets: 10,
+			EnabledEvents: []string{"tcp", "udp", "dns"},
+			Subnets:       []models.SubnetStats{{Subnet: "192.168.1.0/24", Devices: 2, Active: 1, Packets: 120}},
+		}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		j := newTestJSONLines(&buf)
+		j.Emit(tt.recordType, tt.data)
+		j.Close()
+
+		var record struct {
+			Type string          `json:"type"`
+			Time time.Time       `json:"time"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &record); err != nil || strings.Count(buf.String(), "\n") != 1 {
+			t.Errorf("%s: %q is not one JSON line: %v", tt.recordType, buf.String(), err)
+			continue
+		}
+		if record.Type != tt.recordType || !record.Time.Equal(jsonLinesTime) {
+			t.Errorf("%s: record of type %q at %v", tt.recordType, record.Type, record.Time)
+		}
+
+		golden := filepath.Join("testdata", tt.recordType+".golden")
+		if *update {
+			if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s record differs from %s (run with -update after checking the change):\n%s", tt.recordType, golden, buf.Bytes())
+		}
+	}
+}
+
+// Records of concurrent emitters come out as whole lines, and none are
+// emitted after Close
+func TestJSONLinesConcurrentEmit(t *testing.T) {
+	var buf bytes.Buffer
+	j := newTestJSONLines(&buf)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range 50 {
+				j.Emit(monitor.SinkAnomaly, anomaly(fmt.Sprintf("%d-%d", i, n), models.SeverityLow, "a"))
+			}
+		}()
+	}
+	wg.Wait()
+	j.Close()
+	j.Emit(monitor.SinkAnomaly, anomaly("late", models.SeverityLow, "a"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 400 {
+		t.Fatalf("%d lines, want 400", len(lines))
+	}
+	for _, line := range lines {
+		var record struct{ Data models.Anomaly }
+		if err := json.Unmarshal([]byte(line), &record); err != nil || record.Data.ID == "late" {
+			t.Fatalf("line %q: %v", line, err)
+		}
+	}
+}
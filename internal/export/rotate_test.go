@@ -0,0 +1,140 @@
+package export
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// readFiles returns the contents of the rotated files of path, current
+// first, with <missing> for those that do not exist
+func readFiles(t *testing.T, path string, names ...string) []string {
+	t.Helper()
+	var contents []string
+	for _, name := range names {
+		data, err := os.ReadFile(path + name)
+		if errors.Is(err, os.ErrNotExist) {
+			contents = append(contents, "<missing>")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+// A write that would take the file past its maximum size goes to a new one,
+// shifting the backups and dropping the oldest; writes are never split
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oversized write goes whole to a file of its own
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddddddddddd\n", "ee\n", "ff\n"} {
+		if n, err := r.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("writing %q: %d, %v", line, n, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ee\nff\n", "dddddddddddd\n", "cccc\n", "<missing>"}
+	got := readFiles(t, path, "", ".1", ".2", ".3")
+	for i, name := range []string{"current", ".1", ".2", ".3"} {
+		if got[i] != want[i] {
+			t.Errorf("%s file = %q, want %q", name, got[i], want[i])
+		}
+	}
+
+	if _, err := r.Write([]byte("gg\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("write after close = %v, want %v", err, os.ErrClosed)
+	}
+
+	// Reopening appends, counting what the file already holds
+	r, err = OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, line := range []string{"gg\n", "hhhh\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []string{"hhhh\n", "ee\nff\ngg\n", "dddddddddddd\n"}
+	got = readFiles(t, path, "", ".1", ".2")
+	for i, name := range []string{"current", ".1", ".2"} {
+		if got[i] != want[i] {
+			t.Errorf("after reopening, %s file = %q, want %q", name, got[i], want[i])
+		}
+	}
+}
+
+// Without backups the full file is truncated, and with no maximum size it
+// grows forever
+func TestRotatingFileLimits(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		maxSize int64
+		want    string
+	}{
+		{"no-backups.jsonl", 8, "cccc\n"},
+		{"unbounded.jsonl", 0, "aaaa\nbbbb\ncccc\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		r, err := OpenRotatingFile(path, tt.maxSize, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n"} {
+			if _, err := r.Write([]byte(line)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.Close()
+		got := readFiles(t, path, "", ".1")
+		if got[0] != tt.want || got[1] != "<missing>" {
+			t.Errorf("%s: file %q and backup %q, want %q and none", tt.name, got[0], got[1], tt.want)
+		}
+	}
+}
+
+// JSON-lines records written to a rotating file each land whole in one file
+func TestJSONLinesRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	r, err := OpenRotatingFile(path, 512, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJSONLines(r)
+	for range 20 {
+		j.Emit(monitor.SinkAnomaly, anomaly("1", models.SeverityLow, "02:00:00:cc:00:03"))
+	}
+	j.Close()
+	r.Close()
+
+	records := 0
+	for _, content := range readFiles(t, path, "", ".1", ".2", ".3", ".4", ".5", ".6", ".7", ".8", ".9", ".10") {
+		if content == "<missing>" {
+			continue
+		}
+		if len(content) > 512 || !strings.HasSuffix(content, "\n") {
+			t.Errorf("rotated file of %d bytes ends with %q", len(content), content[max(len(content)-8, 0):])
+		}
+		records += strings.Count(content, "\n")
+	}
+	if records != 20 {
+		t.Errorf("%d records across the files, want 20", records)
+	}
+}
@@ -0,0 +1,211 @@
+// Package ifaces keeps the state of the interfaces cerberus attaches to. The
+// attach code, the interface watch and the API all share one Registry.
+package ifaces
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+type entry struct {
+	status      models.InterfaceStatus // What Update set; counters and link details are filled in on read
+	events      atomic.Uint64
+	packets     atomic.Uint64
+	bytes       atomic.Uint64
+	lastEvent   atomic.Int64 // Unix nanoseconds, 0 before the first event
+	utilization atomic.Pointer[models.InterfaceUtilization]
+}
+
+// Registry holds the attach state of interfaces by ifindex and counts their
+// events. It is safe for concurrent use, and notifies subscribers of every
+// change made through Update.
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[int]*entry
+	subs    map[chan models.InterfaceStatus]struct{}
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		entries: make(map[int]*entry),
+		subs:    make(map[chan models.InterfaceStatus]struct{}),
+	}
+}
+
+// Update changes the state of an interface, adding it if needed, and notifies
+// subscribers when the state actually changed. change must not call back into
+// the registry.
+func (r *Registry) Update(index int, change func(status *models.InterfaceStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.entries[index]
+	if e == nil {
+		e = &entry{status: models.InterfaceStatus{Index: index}}
+		r.entries[index] = e
+	}
+	status := e.status
+	change(&status)
+	status.Index = index
+	if reflect.DeepEqual(status, e.status) {
+		return
+	}
+	e.status = status
+
+	snapshot := e.snapshot()
+	for sub := range r.subs {
+		// Slow subscribers miss updates rather than stall the caller
+		select {
+		case sub <- snapshot:
+		default:
+		}
+	}
+}
+
+// Mirror sets the state and counters of an interface as read from another
+// registry, such as the writer's in an api-only process. Like Update, it
+// notifies subscribers only when the state changed, not the counters alone.
+func (r *Registry) Mirror(status models.InterfaceStatus) {
+	events, lastEvent := status.Events, status.LastEvent
+	packets, bytes, utilization := status.Packets, status.Bytes, status.Utilization
+	status.Events, status.LastEvent = 0, nil
+	status.Packets, status.Bytes, status.Utilization = 0, 0, nil
+
+	r.mu.Lock()
+	e := r.entries[status.Index]
+	if e == nil {
+		e = &entry{status: models.InterfaceStatus{Index: status.Index}}
+		r.entries[status.Index] = e
+	}
+	e.events.Store(events)
+	e.packets.Store(packets)
+	e.bytes.Store(bytes)
+	if lastEvent != nil {
+		e.lastEvent.Store(lastEvent.UnixNano())
+	}
+	e.utilization.Store(utilization)
+	r.mu.Unlock()
+
+	r.Update(status.Index, func(current *models.InterfaceStatus) {
+		*current = status
+	})
+}
+
+// RecordEvent counts an event that arrived on an interface, standing for
+// packets of bytes in total. Events of interfaces not in the registry are
+// ignored. Counting never notifies.
+func (r *Registry) RecordEvent(index int, packets, bytes uint64, now time.Time) {
+	r.mu.RLock()
+	e := r.entries[index]
+	r.mu.RUnlock()
+
+	if e != nil {
+		e.events.Add(1)
+		e.packets.Add(packets)
+		e.bytes.Add(bytes)
+		e.lastEvent.Store(now.UnixNano())
+	}
+}
+
+// RestoreCounters adds the counters a previous run persisted for an
+// interface. It never notifies.
+func (r *Registry) RestoreCounters(index int, events, packets, bytes uint64) {
+	r.mu.RLock()
+	e := r.entries[index]
+	r.mu.RUnlock()
+
+	if e != nil {
+		e.events.Add(events)
+		e.packets.Add(packets)
+		e.bytes.Add(bytes)
+	}
+}
+
+// SetUtilization sets the link utilization of an interface, nil when it has
+// no meaningful link speed. Like counting, it never notifies.
+func (r *Registry) SetUtilization(index int, utilization *models.InterfaceUtilization) {
+	r.mu.RLock()
+	e := r.entries[index]
+	r.mu.RUnlock()
+
+	if e != nil {
+		e.utilization.Store(utilization)
+	}
+}
+
+// Get returns the current state of an interface
+func (r *Registry) Get(index int) (models.InterfaceStatus, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e := r.entries[index]
+	if e == nil {
+		return models.InterfaceStatus{}, false
+	}
+	return e.snapshot(), true
+}
+
+// List returns the current state of every interface, by name
+func (r *Registry) List() []models.InterfaceStatus {
+	r.mu.RLock()
+	statuses := make([]models.InterfaceStatus, 0, len(r.entries))
+	for _, e := range r.entries {
+		statuses = append(statuses, e.snapshot())
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Subscribe returns a channel receiving the new state of every interface
+// changed from now on, and a function that ends the subscription
+func (r *Registry) Subscribe() (<-chan models.InterfaceStatus, func()) {
+	sub := make(chan models.InterfaceStatus, 16)
+
+	r.mu.Lock()
+	r.subs[sub] = struct{}{}
+	r.mu.Unlock()
+
+	unsubscribe := func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subs[sub]; ok {
+			delete(r.subs, sub)
+			close(sub)
+		}
+	}
+	return sub, unsubscribe
+}
+
+// snapshot merges the state of an entry with its counters and the current
+// link details of the interface
+func (e *entry) snapshot() models.InterfaceStatus {
+	status := e.status
+	status.Events = e.events.Load()
+	status.Packets = e.packets.Load()
+	status.Bytes = e.bytes.Load()
+	status.Utilization = e.utilization.Load()
+	if nanos := e.lastEvent.Load(); nanos != 0 {
+		lastEvent := time.Unix(0, nanos)
+		status.LastEvent = &lastEvent
+	}
+
+	status.LinkUp = false
+	if iface, err := net.InterfaceByIndex(status.Index); err == nil {
+		if status.Name == "" {
+			status.Name = iface.Name
+		}
+		status.MAC = iface.HardwareAddr.String()
+		status.MTU = iface.MTU
+		status.LinkUp = iface.Flags&net.FlagUp != 0
+	}
+	return status
+}
@@ -0,0 +1,171 @@
+package ifaces
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Interface indexes no test host has, so link details stay empty
+const (
+	testIndex  = 100001
+	otherIndex = 100002
+)
+
+// received drains what a subscription got so far
+func received(sub <-chan models.InterfaceStatus) []models.InterfaceStatus {
+	var statuses []models.InterfaceStatus
+	for {
+		select {
+		case status := <-sub:
+			statuses = append(statuses, status)
+		default:
+			return statuses
+		}
+	}
+}
+
+// Subscribers hear of state changes, not of updates leaving the state as it
+// was, nor of counting
+func TestRegistryNotifiesChanges(t *testing.T) {
+	r := NewRegistry()
+	sub, unsubscribe := r.Subscribe()
+
+	attach := func(status *models.InterfaceStatus) {
+		status.Name = "eth9"
+		status.Attached = true
+		status.Mode = "tcx"
+	}
+	r.Update(testIndex, attach)
+	r.Update(testIndex, attach)
+	r.RecordEvent(testIndex, 3, 180, time.Now())
+	r.SetUtilization(testIndex, &models.InterfaceUtilization{})
+	r.RestoreCounters(testIndex, 1, 1, 60)
+
+	got := received(sub)
+	if len(got) != 1 || got[0].Index != testIndex || !got[0].Attached || got[0].Name != "eth9" {
+		t.Fatalf("notified %+v, want the attach alone", got)
+	}
+
+	r.Update(testIndex, func(status *models.InterfaceStatus) {
+		status.Attached = false
+		status.AttachError = "link down"
+		status.Index = otherIndex // Ignored: the index is the registry's
+	})
+	got = received(sub)
+	if len(got) != 1 || got[0].Attached || got[0].Index != testIndex || got[0].Events != 2 {
+		t.Fatalf("notified %+v, want the detach with the counters", got)
+	}
+
+	unsubscribe()
+	unsubscribe() // Idempotent
+	if _, ok := <-sub; ok {
+		t.Error("subscription still open after unsubscribing")
+	}
+	r.Update(testIndex, attach) // Must not send on the closed channel
+}
+
+func TestRegistryCounters(t *testing.T) {
+	r := NewRegistry()
+	r.RecordEvent(testIndex, 1, 60, time.Now()) // Not registered yet: ignored
+	r.Update(testIndex, func(status *models.InterfaceStatus) { status.Name = "eth9" })
+
+	at := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	r.RecordEvent(testIndex, 1, 60, at.Add(-time.Second))
+	r.RecordEvent(testIndex, 10, 15000, at)
+	r.RestoreCounters(testIndex, 5, 50, 5000)
+	utilization := &models.InterfaceUtilization{}
+	r.SetUtilization(testIndex, utilization)
+
+	status, ok := r.Get(testIndex)
+	if !ok {
+		t.Fatal("interface not registered")
+	}
+	if status.Events != 7 || status.Packets != 61 || status.Bytes != 20060 {
+		t.Errorf("counters = %d events, %d packets, %d bytes, want 7, 61, 20060", status.Events, status.Packets, status.Bytes)
+	}
+	if status.LastEvent == nil || !status.LastEvent.Equal(at) || status.Utilization != utilization {
+		t.Errorf("last event %v, utilization %p, want %v and %p", status.LastEvent, status.Utilization, at, utilization)
+	}
+	if status.LinkUp {
+		t.Error("an interface the host lacks is reported up")
+	}
+
+	if _, ok := r.Get(otherIndex); ok {
+		t.Error("unregistered interface found")
+	}
+}
+
+// Mirror sets the state and counters read from another registry, and only
+// notifies of state changes
+func TestRegistryMirror(t *testing.T) {
+	r := NewRegistry()
+	sub, unsubscribe := r.Subscribe()
+	defer unsubscribe()
+
+	lastEvent := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	status := models.InterfaceStatus{Name: "eth9", Index: testIndex, Attached: true, Events: 4, Packets: 40, Bytes: 4000, LastEvent: &lastEvent}
+	r.Mirror(status)
+	status.Events, status.Packets = 5, 50
+	r.Mirror(status)
+
+	got, _ := r.Get(testIndex)
+	if got.Events != 5 || got.Packets != 50 || got.Bytes != 4000 || !got.LastEvent.Equal(lastEvent) || !got.Attached {
+		t.Errorf("mirrored %+v", got)
+	}
+	if n := len(received(sub)); n != 1 {
+		t.Errorf("%d notifications, want 1 for the state change alone", n)
+	}
+}
+
+func TestRegistryListByName(t *testing.T) {
+	r := NewRegistry()
+	r.Update(testIndex, func(status *models.InterfaceStatus) { status.Name = "wlan9" })
+	r.Update(otherIndex, func(status *models.InterfaceStatus) { status.Name = "eth9" })
+
+	list := r.List()
+	if len(list) != 2 || list[0].Name != "eth9" || list[1].Name != "wlan9" {
+		t.Errorf("List = %+v, want eth9 then wlan9", list)
+	}
+}
+
+// Counting, updates, reads and subscriptions may run concurrently; run with
+// -race
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	r.Update(testIndex, func(status *models.InterfaceStatus) { status.Name = "eth9" })
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				r.RecordEvent(testIndex, 1, 60, time.Now())
+			}
+		}()
+	}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			r.Update(testIndex, func(status *models.InterfaceStatus) { status.Reattaches = i })
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 200 {
+			sub, unsubscribe := r.Subscribe()
+			received(sub)
+			r.List()
+			unsubscribe()
+		}
+	}()
+	wg.Wait()
+
+	if status, _ := r.Get(testIndex); status.Events != 4000 || status.Bytes != 4000*60 {
+		t.Errorf("counted %d events, %d bytes, want 4000 and %d", status.Events, status.Bytes, 4000*60)
+	}
+}
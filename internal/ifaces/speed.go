@@ -0,0 +1,47 @@
+package ifaces
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysfsRoot is where the kernel describes network interfaces
+var sysfsRoot = "/sys/class/net"
+
+// LinkSpeed returns the speed in Mb/s and duplex of an interface's link as
+// the kernel reports them. speed is 0 when it is unknown or meaningless:
+// for links that are down or don't negotiate, and for virtual interfaces,
+// which report a made-up speed if any. Bonds aggregate real links and keep
+// theirs.
+func LinkSpeed(name string) (speed int, duplex string) {
+	if name == "" || strings.ContainsRune(name, '/') {
+		return 0, ""
+	}
+	dir := filepath.Join(sysfsRoot, name)
+	if !exists(filepath.Join(dir, "device")) && !exists(filepath.Join(dir, "bonding")) {
+		return 0, ""
+	}
+
+	// Reading speed fails with EINVAL while the link is down
+	data, err := os.ReadFile(filepath.Join(dir, "speed"))
+	if err != nil {
+		return 0, ""
+	}
+	speed, err = strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || speed <= 0 {
+		return 0, ""
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "duplex")); err == nil {
+		if duplex = strings.TrimSpace(string(data)); duplex == "unknown" {
+			duplex = ""
+		}
+	}
+	return speed, duplex
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
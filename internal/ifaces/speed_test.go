@@ -0,0 +1,41 @@
+package ifaces
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// LinkSpeed reads a fake sysfs in testdata/sysfs: physical links and bonds
+// report their speed and duplex; links that are down, virtual interfaces and
+// names that would leave the sysfs directory report none
+func TestLinkSpeed(t *testing.T) {
+	saved := sysfsRoot
+	sysfsRoot = filepath.Join("testdata", "sysfs", "class", "net")
+	t.Cleanup(func() { sysfsRoot = saved })
+
+	tests := []struct {
+		name   string
+		speed  int
+		duplex string
+	}{
+		{"eth0", 1000, "full"},
+		{"eth1", 100, "half"},
+		{"eth2", 10000, ""},            // Duplex unknown
+		{"bond0", 2000, "full"},        // Aggregate of its links
+		{"eno1", 0, ""},                // No carrier: the driver reports -1
+		{"enp3s0", 0, ""},              // Down: speed can't be read
+		{"veth1a2b", 0, ""},            // Virtual, with a made-up speed
+		{"docker0", 0, ""},             // Bridge
+		{"lo", 0, ""},                  // Loopback
+		{"missing", 0, ""},             // No such interface
+		{"", 0, ""},                    // No name
+		{"../net/eth0", 0, ""},         // Outside the interface directory
+		{"eth0/../../net/eth1", 0, ""}, // Likewise
+	}
+	for _, tt := range tests {
+		speed, duplex := LinkSpeed(tt.name)
+		if speed != tt.speed || duplex != tt.duplex {
+			t.Errorf("LinkSpeed(%q) = %d %q, want %d %q", tt.name, speed, duplex, tt.speed, tt.duplex)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package messages
+
+// AnomalyTypes lists every anomaly type cerberus raises; each has a built-in
+// description
+var AnomalyTypes = []string{
+	"ARP_SENDER_MISMATCH",
+	"DEVICE_MOVED",
+	"DEVICE_RECOVERED",
+	"DEVICE_UNAVAILABLE",
+	"DIRECT_IP_CONNECTIONS",
+	"DNS_TUNNELING",
+	"EGRESS_POLICY_VIOLATION",
+	"FIRST_EXTERNAL_CONTACT",
+	"FLEET_NEW_DESTINATION",
+	"ICMP_PAYLOAD_VOLUME",
+	"INTERFACE_RECOVERED",
+	"INTERFACE_SATURATED",
+	"INTERFACE_SILENT",
+	"NEW_DEVICE_BURST",
+	"NOVEL_DESTINATION_SPIKE",
+	"PACKET_RATE_SPIKE",
+	"PATTERN_RATE_SPIKE",
+	"PERSISTENCE_FAILED",
+	"PERSISTENCE_RECOVERED",
+	"PORT_SHARE_SHIFT",
+	"RESOURCE_DEFENSIVE_MODE",
+	"RESOURCE_DEFENSIVE_MODE_EXIT",
+	"SUSPICIOUS_DOMAINS",
+	"THREAT_INTEL_MATCH",
+	"TLS_FINGERPRINT_BLOCKLISTED",
+	"UPLINK_DEGRADED",
+}
+
+// builtIn holds the English templates. The parameters of a key are those its
+// template here uses, so a template must use every parameter translations
+// may need.
+var builtIn = map[string]string{
+	ScopeNetwork: "Network-wide",
+	ScopeDevice:  "Device {device}",
+
+	"anomaly.ARP_SENDER_MISMATCH":          "Device {device} sent {count} ARP packets claiming {claimed_ip} from sender MAC {sender_mac} within {elapsed}",
+	"anomaly.DEVICE_MOVED":                 "Device {device} moved from switch port {from} to {to}",
+	"anomaly.DEVICE_RECOVERED":             "Critical device {label} is back after {outage} down",
+	"anomaly.DEVICE_UNAVAILABLE":           "Critical device {label} has been silent since {down_since}",
+	"anomaly.DIRECT_IP_CONNECTIONS":        "Device {device} connected to {count} external IPs it never resolved through DNS within {elapsed}",
+	"anomaly.DNS_TUNNELING":                "Device {device} sent {suspicious_queries} suspicious queries and {unique_subdomains} unique subdomains under {parent_domain} within {elapsed}",
+	"anomaly.EGRESS_POLICY_VIOLATION":      "Device {device} reached {count} external destinations directly within {elapsed}, bypassing the endpoints required by policy {policy}, latest {destination}",
+	"anomaly.FIRST_EXTERNAL_CONTACT":       "Device {device}, known for {age}, contacted {destination} over {protocol}, its first contact outside the LAN",
+	"anomaly.FLEET_NEW_DESTINATION":        "{count} {vendor} devices started contacting {destination} within {elapsed}",
+	"anomaly.ICMP_PAYLOAD_VOLUME":          "Device {device} sent {bytes} bytes of ICMP payload within {window}",
+	"anomaly.INTERFACE_RECOVERED":          "Interface {interface} is producing events again after being silent for {duration}",
+	"anomaly.INTERFACE_SATURATED":          "Interface {interface} has been at {percent}% of its {speed} link for {duration}",
+	"anomaly.INTERFACE_SILENT":             "Interface {interface} has produced no events for {silence} while its link is up",
+	"anomaly.NEW_DEVICE_BURST":             "{count} new devices appeared within {window}, possibly MAC randomization or a newly connected switch",
+	"anomaly.NOVEL_DESTINATION_SPIKE":      "{count} external destinations never seen on the network were contacted by {devices} devices within {elapsed}, against a baseline of {baseline} per {window}, e.g. {destination}",
+	"anomaly.PACKET_RATE_SPIKE":            "{scope} at {value} packets/s (baseline {mean} ± {stddev}, z={z_score})",
+	"anomaly.PATTERN_RATE_SPIKE":           "{scope} at {value} new patterns/min (baseline {mean} ± {stddev}, z={z_score})",
+	"anomaly.PERSISTENCE_FAILED":           "Device state can no longer be written to the database: {error}",
+	"anomaly.PERSISTENCE_RECOVERED":        "Device state is being written to the database again after {duration}",
+	"anomaly.PORT_SHARE_SHIFT":             "Device {device} sent {share}% of its traffic over {port} within {window}, up from {previous_share}%",
+	"anomaly.RESOURCE_DEFENSIVE_MODE":      "Entered defensive mode at {time} due to {reason}",
+	"anomaly.RESOURCE_DEFENSIVE_MODE_EXIT": "Left defensive mode at {time} after {duration}",
+	"anomaly.SUSPICIOUS_DOMAINS":           "Device {device} queried suspicious domains (score {score}, {heuristics}), e.g. {domain}",
+	"anomaly.THREAT_INTEL_MATCH":           "Device {device} matched threat list {list}: {kind} {indicator} (listed as {entry})",
+	"anomaly.TLS_FINGERPRINT_BLOCKLISTED":  "Device {device} sent a ClientHello matching blocklisted JA3 {ja3_hash} ({description})",
+	"anomaly.UPLINK_DEGRADED":              "Uplink health fell from {previous} to {score}, mostly {signal}: {detail}",
+
+	"report.ARP_SENDER_MISMATCH":         "It announced itself on the local network under another device's hardware address, a trick used to intercept traffic.",
+	"report.DEVICE_MOVED":                "It was plugged into a different network socket or switch port.",
+	"report.DEVICE_RECOVERED":            "This important device is back on the network after an outage.",
+	"report.DEVICE_UNAVAILABLE":          "This important device went quiet on the network and may be switched off, unplugged or broken.",
+	"report.DIRECT_IP_CONNECTIONS":       "It connected to many internet addresses without looking up their names first, which most normal apps do.",
+	"report.DNS_TUNNELING":               "It sent oddly shaped internet lookups. Malware sometimes hides data in them to sneak it out.",
+	"report.EGRESS_POLICY_VIOLATION":     "It connected to the internet directly instead of through the proxy or VPN it is required to use.",
+	"report.FIRST_EXTERNAL_CONTACT":      "It reached the internet for the first time, after a long time keeping to the local network.",
+	"report.FLEET_NEW_DESTINATION":       "It started talking to a new internet service at the same time as similar devices, often a sign of a software update.",
+	"report.PACKET_RATE_SPIKE":           "It suddenly sent much more traffic than usual.",
+	"report.PATTERN_RATE_SPIKE":          "It suddenly started contacting many more places than usual.",
+	"report.SUSPICIOUS_DOMAINS":          "It looked up internet names that look machine-generated, which malware uses to find its servers.",
+	"report.THREAT_INTEL_MATCH":          "It contacted an internet address or name on a list of known malicious ones.",
+	"report.TLS_FINGERPRINT_BLOCKLISTED": "Its secure connections look like those of software known to be malicious.",
+}
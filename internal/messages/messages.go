@@ -0,0 +1,290 @@
+// Package messages renders the descriptions cerberus gives its anomalies, and
+// the plain-language explanations of device reports, from templates with
+// named parameters.
+//
+// A template is text with parameters in braces, such as "Device {device}
+// sent {count} packets"; "{{" and "}}" stand for literal braces. Every key has
+// a built-in English template. A catalog loaded from a file overrides some of
+// them, for example with a translation, and falls back to English for the
+// rest.
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// File is the name of the override file in the data directory
+const File = "messages.json"
+
+// Key prefixes
+const (
+	anomalyPrefix = "anomaly." // Description of an anomaly, by type
+	reportPrefix  = "report."  // Plain-language explanation of an anomaly in device reports, by type
+)
+
+// Scope keys name what a network-wide or per-device measurement is about
+const (
+	ScopeNetwork = "scope.network"
+	ScopeDevice  = "scope.device"
+)
+
+// commonParams are the parameters every anomaly and report template can use,
+// in addition to those of its built-in template. They are empty when they do
+// not apply, such as device_name for an unnamed device.
+var commonParams = []string{"device", "device_name", "severity"}
+
+// AnomalyKey returns the key of the description of an anomaly type
+func AnomalyKey(anomalyType string) string {
+	return anomalyPrefix + anomalyType
+}
+
+// ReportKey returns the key of the plain-language explanation of an anomaly
+// type
+func ReportKey(anomalyType string) string {
+	return reportPrefix + anomalyType
+}
+
+// Template is a parsed template
+type Template struct {
+	text   string
+	parts  []part
+	params []string // Sorted, without duplicates
+}
+
+type part struct {
+	text  string
+	param bool // text names a parameter
+}
+
+// Parse parses a template, rejecting unbalanced braces and empty parameter
+// names
+func Parse(text string) (*Template, error) {
+	t := &Template{text: text}
+	var literal strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '{' && strings.HasPrefix(text[i:], "{{"), c == '}' && strings.HasPrefix(text[i:], "}}"):
+			literal.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexAny(text[i+1:], "{}")
+			if end < 0 || text[i+1+end] != '}' {
+				return nil, fmt.Errorf("unclosed { at offset %d", i)
+			}
+			name := text[i+1 : i+1+end]
+			if name == "" || strings.TrimSpace(name) != name {
+				return nil, fmt.Errorf("invalid parameter name %q at offset %d", name, i)
+			}
+			if literal.Len() > 0 {
+				t.parts = append(t.parts, part{text: literal.String()})
+				literal.Reset()
+			}
+			t.parts = append(t.parts, part{text: name, param: true})
+			t.params = append(t.params, name)
+			i += end + 1
+		case c == '}':
+			return nil, fmt.Errorf("unopened } at offset %d", i)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	if literal.Len() > 0 {
+		t.parts = append(t.parts, part{text: literal.String()})
+	}
+	slices.Sort(t.params)
+	t.params = slices.Compact(t.params)
+	return t, nil
+}
+
+// Render substitutes params into the template. Values are inserted as they
+// are: callers rendering into HTML must escape the result, as html/template
+// does. Missing parameters render empty.
+func (t *Template) Render(params map[string]string) string {
+	var out strings.Builder
+	for _, p := range t.parts {
+		if p.param {
+			out.WriteString(params[p.text])
+		} else {
+			out.WriteString(p.text)
+		}
+	}
+	return out.String()
+}
+
+// Catalog holds a template for every key. It is immutable once built and
+// safe for concurrent use.
+type Catalog struct {
+	templates  map[string]*Template
+	overridden map[string]bool
+	source     string // Override file, "" for the built-in catalog
+}
+
+// defaultCatalog is built once at startup, which fails on a built-in template
+// that does not parse or an anomaly type without one
+var defaultCatalog = func() *Catalog {
+	c := &Catalog{templates: make(map[string]*Template, len(builtIn))}
+	for key, text := range builtIn {
+		t, err := Parse(text)
+		if err != nil {
+			panic(fmt.Sprintf("messages: built-in template %s: %v", key, err))
+		}
+		c.templates[key] = t
+	}
+	for _, anomalyType := range AnomalyTypes {
+		if _, ok := c.templates[AnomalyKey(anomalyType)]; !ok {
+			panic("messages: no built-in description of " + anomalyType)
+		}
+	}
+	return c
+}()
+
+// Default returns the catalog of built-in English templates
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+// Load returns the built-in catalog overridden by the templates of a JSON
+// file mapping keys to templates. A file that does not exist overrides
+// nothing. Unknown keys, templates that do not parse and templates using
+// parameters their key does not provide are all reported in one error, by
+// key.
+func Load(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return Override(path, overrides)
+}
+
+// Override returns the built-in catalog overridden by templates by key.
+// source names where they came from in errors and in the catalog listing.
+func Override(source string, overrides map[string]string) (*Catalog, error) {
+	c := &Catalog{
+		templates:  make(map[string]*Template, len(defaultCatalog.templates)),
+		overridden: make(map[string]bool, len(overrides)),
+		source:     source,
+	}
+	for key, t := range defaultCatalog.templates {
+		c.templates[key] = t
+	}
+
+	var problems []string
+	for _, key := range sortedKeys(overrides) {
+		if !known(key) {
+			problems = append(problems, key+": unknown key")
+			continue
+		}
+		t, err := Parse(overrides[key])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		allowed := Params(key)
+		var unknown []string
+		for _, param := range t.params {
+			if !slices.Contains(allowed, param) {
+				unknown = append(unknown, "{"+param+"}")
+			}
+		}
+		if len(unknown) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: unknown parameter %s (has %s)",
+				key, strings.Join(unknown, ", "), strings.Join(allowed, ", ")))
+			continue
+		}
+		c.templates[key] = t
+		c.overridden[key] = true
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid templates in %s: %s", source, strings.Join(problems, "; "))
+	}
+	return c, nil
+}
+
+// known reports whether a key can have a template: built-in keys, and the
+// report explanations of anomaly types that have none in English
+func known(key string) bool {
+	if _, ok := defaultCatalog.templates[key]; ok {
+		return true
+	}
+	anomalyType, ok := strings.CutPrefix(key, reportPrefix)
+	return ok && slices.Contains(AnomalyTypes, anomalyType)
+}
+
+// Params returns the parameters the template of a key can use, sorted. An
+// anomaly's description and its report explanation share theirs.
+func Params(key string) []string {
+	var params []string
+	if t, ok := defaultCatalog.templates[key]; ok {
+		params = append(params, t.params...)
+	}
+	anomalyType, isAnomaly := strings.CutPrefix(key, anomalyPrefix)
+	if reportType, isReport := strings.CutPrefix(key, reportPrefix); isReport {
+		anomalyType, isAnomaly = reportType, true
+		if t, ok := defaultCatalog.templates[AnomalyKey(anomalyType)]; ok {
+			params = append(params, t.params...)
+		}
+	}
+	if isAnomaly {
+		params = append(params, commonParams...)
+	}
+	slices.Sort(params)
+	return slices.Compact(params)
+}
+
+// Render renders the template of a key. Keys without a template render as
+// the key followed by the parameters, so nothing is lost.
+func (c *Catalog) Render(key string, params map[string]string) string {
+	if t, ok := c.templates[key]; ok {
+		return t.Render(params)
+	}
+	var out strings.Builder
+	out.WriteString(key)
+	for _, name := range sortedKeys(params) {
+		fmt.Fprintf(&out, " %s=%s", name, params[name])
+	}
+	return out.String()
+}
+
+// Has reports whether a key has a template
+func (c *Catalog) Has(key string) bool {
+	_, ok := c.templates[key]
+	return ok
+}
+
+// Templates returns the templates of the catalog, by key
+func (c *Catalog) Templates() models.MessageTemplates {
+	entries := make([]models.MessageTemplate, 0, len(c.templates))
+	for key, t := range c.templates {
+		entries = append(entries, models.MessageTemplate{
+			Key:        key,
+			Template:   t.text,
+			Params:     Params(key),
+			Overridden: c.overridden[key],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return models.MessageTemplates{Source: c.source, Templates: entries}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
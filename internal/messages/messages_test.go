@@ -0,0 +1,185 @@
+package messages
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Every anomaly type the monitor raises is listed in AnomalyTypes, and so
+// has a built-in description whose parameters parse
+func TestEveryAnomalyTypeHasTemplate(t *testing.T) {
+	sources, err := filepath.Glob("../monitor/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raised := regexp.MustCompile(`(?:raiseAnomaly|raiseLinkedAnomaly)\("([A-Z_]+)"|anomalyType :?= "([A-Z_]+)"`)
+	found := 0
+	for _, source := range sources {
+		if strings.HasSuffix(source, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range raised.FindAllStringSubmatch(string(data), -1) {
+			anomalyType := match[1] + match[2]
+			found++
+			if !slices.Contains(AnomalyTypes, anomalyType) {
+				t.Errorf("%s raises %s, which AnomalyTypes does not list", filepath.Base(source), anomalyType)
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("found no anomaly raised by the monitor")
+	}
+
+	for _, anomalyType := range AnomalyTypes {
+		if !Default().Has(AnomalyKey(anomalyType)) {
+			t.Errorf("%s has no built-in description", anomalyType)
+		}
+	}
+	for key := range builtIn {
+		if anomalyType, ok := strings.CutPrefix(key, reportPrefix); ok && !slices.Contains(AnomalyTypes, anomalyType) {
+			t.Errorf("%s explains an anomaly type that is never raised", key)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text   string
+		params []string
+		ok     bool
+	}{
+		{"plain text", nil, true},
+		{"Device {device} sent {count} of {count}", []string{"count", "device"}, true},
+		{"literal {{braces}} and {x}", []string{"x"}, true},
+		{"unclosed {device", nil, false},
+		{"unopened device}", nil, false},
+		{"empty {}", nil, false},
+		{"nested {a{b}}", nil, false},
+		{"spaced { device }", nil, false},
+	}
+	for _, tt := range tests {
+		parsed, err := Parse(tt.text)
+		if (err == nil) != tt.ok {
+			t.Errorf("Parse(%q) error = %v, want ok %v", tt.text, err, tt.ok)
+			continue
+		}
+		if err == nil && !slices.Equal(parsed.params, tt.params) {
+			t.Errorf("Parse(%q) params = %v, want %v", tt.text, parsed.params, tt.params)
+		}
+	}
+}
+
+// Values are substituted as they are, missing ones render empty, and keys
+// without a template render with their parameters
+func TestRender(t *testing.T) {
+	parsed, err := Parse("{{{name}}} sent {count} to {missing}.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := parsed.Render(map[string]string{"name": "<b>&", "count": "{3}"})
+	if want := "{<b>&} sent {3} to ."; got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+
+	catalog := Default()
+	got = catalog.Render(AnomalyKey("DEVICE_MOVED"), map[string]string{"device": "d1", "from": "gi1", "to": "gi2"})
+	if want := "Device d1 moved from switch port gi1 to gi2"; got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+	got = catalog.Render("anomaly.UNKNOWN", map[string]string{"b": "2", "a": "1"})
+	if want := "anomaly.UNKNOWN a=1 b=2"; got != want {
+		t.Errorf("Render of an unknown key = %q, want %q", got, want)
+	}
+}
+
+// Overrides replace their keys only, may use the common parameters and
+// explain anomaly types that have no English explanation
+func TestOverride(t *testing.T) {
+	moved, unavailable := AnomalyKey("DEVICE_MOVED"), AnomalyKey("DEVICE_UNAVAILABLE")
+	catalog, err := Override("fr.json", map[string]string{
+		moved:                         "{device_name} a changé de port : {from} → {to}",
+		ReportKey("PORT_SHARE_SHIFT"): "{share}% sur {port}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]string{"device_name": "nas", "from": "1", "to": "2", "label": "nas", "down_since": "9h"}
+	if got := catalog.Render(moved, params); got != "nas a changé de port : 1 → 2" {
+		t.Errorf("overridden template renders %q", got)
+	}
+	if got, want := catalog.Render(unavailable, params), Default().Render(unavailable, params); got != want {
+		t.Errorf("template not overridden renders %q, want English %q", got, want)
+	}
+	if !catalog.Has(ReportKey("PORT_SHARE_SHIFT")) || Default().Has(ReportKey("PORT_SHARE_SHIFT")) {
+		t.Error("a new report explanation is not added to the override only")
+	}
+
+	listing := catalog.Templates()
+	if listing.Source != "fr.json" {
+		t.Errorf("source = %q", listing.Source)
+	}
+	for _, entry := range listing.Templates {
+		if want := entry.Key == moved || entry.Key == ReportKey("PORT_SHARE_SHIFT"); entry.Overridden != want {
+			t.Errorf("%s overridden = %v, want %v", entry.Key, entry.Overridden, want)
+		}
+	}
+}
+
+// Every bad override is reported at once, by key
+func TestOverrideErrors(t *testing.T) {
+	_, err := Override("bad.json", map[string]string{
+		"anomaly.NOT_A_TYPE":            "x",
+		AnomalyKey("DEVICE_MOVED"):      "unclosed {from",
+		AnomalyKey("INTERFACE_SILENT"):  "{interface} {password}",
+		AnomalyKey("UPLINK_DEGRADED"):   "fine {score}",
+		ReportKey("DEVICE_UNAVAILABLE"): "{label} since {down_since} ({severity})",
+	})
+	if err == nil {
+		t.Fatal("bad overrides accepted")
+	}
+	for _, want := range []string{
+		"bad.json",
+		"anomaly.NOT_A_TYPE: unknown key",
+		"anomaly.DEVICE_MOVED: unclosed {",
+		"anomaly.INTERFACE_SILENT: unknown parameter {password}",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	for _, fine := range []string{"UPLINK_DEGRADED", "report.DEVICE_UNAVAILABLE"} {
+		if strings.Contains(err.Error(), fine) {
+			t.Errorf("error %q mentions valid override %s", err, fine)
+		}
+	}
+}
+
+// A missing file overrides nothing; a file that is not JSON is an error
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if catalog, err := Load(filepath.Join(dir, File)); err != nil || catalog != Default() {
+		t.Errorf("Load of a missing file = %v, %v", catalog, err)
+	}
+	path := filepath.Join(dir, File)
+	if err := os.WriteFile(path, []byte(`{"anomaly.DEVICE_MOVED": "{device} moved"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	catalog, err := Load(path)
+	if err != nil || catalog.Render(AnomalyKey("DEVICE_MOVED"), map[string]string{"device": "d1"}) != "d1 moved" {
+		t.Errorf("Load = %v, %v", catalog, err)
+	}
+	if err := os.WriteFile(path, []byte(`{"anomaly.DEVICE_MOVED": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load accepted a truncated file")
+	}
+}
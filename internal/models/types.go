@@ -1,6 +1,13 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/utils/orderedset"
+)
 
 type TrafficType string
 
@@ -14,6 +21,17 @@ const (
 	EVENT_TYPE_TLS  = 7
 )
 
+// EventTypeNames maps BPF event type identifiers to their display names
+var EventTypeNames = map[uint8]string{
+	EVENT_TYPE_ARP:  "ARP",
+	EVENT_TYPE_TCP:  "TCP",
+	EVENT_TYPE_UDP:  "UDP",
+	EVENT_TYPE_ICMP: "ICMP",
+	EVENT_TYPE_DNS:  "DNS",
+	EVENT_TYPE_HTTP: "HTTP",
+	EVENT_TYPE_TLS:  "TLS",
+}
+
 const (
 	// ARP Traffic
 	TrafficARPRequest  TrafficType = "ARP_REQUEST"
@@ -68,6 +86,40 @@ const (
 	TrafficExternalToLocal TrafficType = "EXTERNAL_TO_LOCAL"
 )
 
+// TrafficTypes lists every traffic type
+var TrafficTypes = []TrafficType{
+	TrafficARPRequest, TrafficARPReply, TrafficARPProbe, TrafficARPAnnounce, TrafficARPScan,
+	TrafficTCPSYN, TrafficTCPSYNACK, TrafficTCPACK, TrafficTCPFIN, TrafficTCPRST,
+	TrafficTCPHTTP, TrafficTCPHTTPS, TrafficTCPSSH, TrafficTCPCustom,
+	TrafficUDPDNS, TrafficUDPDHCP, TrafficUDPNTP, TrafficUDPSNMP, TrafficUDPCustom,
+	TrafficICMPEchoRequest, TrafficICMPEchoReply, TrafficICMPDestUnreach,
+	TrafficICMPTimeExceeded, TrafficICMPRedirect, TrafficICMPCustom,
+	TrafficDNSQuery, TrafficDNSResponse,
+	TrafficHTTPGET, TrafficHTTPPOST, TrafficHTTPRequest,
+	TrafficTLSClientHello, TrafficTLSServerHello, TrafficTLSHandshake,
+	TrafficLocalToLocal, TrafficLocalToExternal, TrafficExternalToLocal,
+}
+
+// Classification evidence: what a traffic type was derived from
+const (
+	EvidencePortHeuristic = "port-heuristic" // Well-known destination (or DNS source) port only
+	EvidenceL7Detected    = "l7-detected"    // DNS header, HTTP method or TLS handshake bytes
+	EvidenceFlagBased     = "flag-based"     // TCP flags
+	EvidenceHeaderField   = "header-field"   // ARP operation and addresses, or ICMP type
+	EvidenceFallback      = "fallback"       // Nothing matched, the catch-all type of the protocol
+)
+
+// Evidences lists every classification evidence
+var Evidences = []string{EvidencePortHeuristic, EvidenceL7Detected, EvidenceFlagBased, EvidenceHeaderField, EvidenceFallback}
+
+// EventLayoutVersion identifies the network_event layout of cerberus_tc.c.
+// 1 ended with the L7 payload (79 bytes), 2 added the IP TTL and TCP window
+// (82 bytes), 3 the packet length (84 bytes), 4 made every multi-byte field
+// big-endian (before, only IPv4 addresses were; the rest was in host order),
+// 5 moved the L7 payload after a 54-byte header holding its length, so each
+// event carries only the payload bytes captured for its type.
+const EventLayoutVersion = 5
+
 type NetworkEvent struct {
 	EventType uint8
 	SrcMac    [6]byte
@@ -83,8 +135,105 @@ type NetworkEvent struct {
 	ArpTha    [6]byte
 	ICMPType  uint8
 	ICMPCode  uint8
-	IfIndex   uint32   // Interface index
-	L7Payload [32]byte // First 32 bytes of payload for L7 inspection
+	IfIndex   uint32 // Interface index
+	IPTTL     uint8  // IP time-to-live as observed
+	TCPWindow uint16 // TCP window size (TCP events only)
+	PacketLen uint16 // Frame length including the Ethernet header, 0 if unknown
+	L7Payload []byte // Start of the payload for L7 inspection, as much as captured for the event type
+}
+
+// TLSHelloEvent carries the start of a TLS ClientHello record for fingerprinting
+type TLSHelloEvent struct {
+	SrcMac  [6]byte
+	SrcIP   uint32
+	DstIP   uint32
+	SrcPort uint16
+	DstPort uint16
+	Data    []byte // TLS record from its header, possibly truncated
+}
+
+// HTTPRequestEvent carries the start of an HTTP request, headers included
+type HTTPRequestEvent struct {
+	SrcMac  [6]byte
+	SrcIP   uint32
+	DstIP   uint32
+	SrcPort uint16
+	DstPort uint16
+	Data    []byte // Request line and headers, possibly truncated
+}
+
+// DNSQueryEvent carries a DNS query (tunneling detection) or response
+// (direct-IP detection) message
+type DNSQueryEvent struct {
+	SrcMac [6]byte
+	SrcIP  uint32
+	DstIP  uint32
+	Data   []byte // DNS message from its header, possibly truncated
+}
+
+// FlowSummaryEvent carries the packets and bytes of an established TCP flow
+// counted in the kernel since its previous summary, sent instead of an event
+// per packet
+type FlowSummaryEvent struct {
+	SrcMac   [6]byte
+	DstMac   [6]byte
+	SrcIP    uint32
+	DstIP    uint32
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+	Reason   uint8 // FlowSummary* constant
+	IfIndex  uint32
+	Packets  uint32
+	Bytes    uint64        // Frame lengths, Ethernet headers included
+	Duration time.Duration // From the first packet counted to the last
+}
+
+// Why a flow summary was sent, matching FLOW_SUMMARY_* in the eBPF program
+const (
+	FlowSummaryPackets  = 1 // The packet threshold was reached
+	FlowSummaryInterval = 2 // The interval passed since the first packet counted
+	FlowSummaryEnd      = 3 // A FIN or RST ended the flow
+	FlowSummaryIdle     = 4 // Swept by userspace after the flow went idle
+)
+
+// JA3 is a TLS ClientHello fingerprint. Partial fingerprints come from
+// truncated hellos and carry no hash.
+type JA3 struct {
+	Hash    string `json:"hash,omitempty"`
+	String  string `json:"string"`
+	Partial bool   `json:"partial"`
+}
+
+// TLSFingerprint summarizes which devices sent a given JA3 fingerprint
+type TLSFingerprint struct {
+	Hash        string   `json:"hash"`
+	JA3         string   `json:"ja3"`
+	Hellos      int      `json:"hellos"`
+	Devices     int      `json:"devices"`
+	DeviceIDs   []string `json:"device_ids"`
+	Blocklisted bool     `json:"blocklisted"`
+	Description string   `json:"description,omitempty"`
+}
+
+// GeoInfo locates an IP address: the country and autonomous system announcing
+// it, as far as the GeoIP database knows them
+type GeoInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166 alpha-2 code
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// ThreatList is the state of one loaded threat intelligence list
+type ThreatList struct {
+	Name      string     `json:"name"`
+	Source    string     `json:"source"` // Path or URL
+	CIDRs     int        `json:"cidrs"`
+	Domains   int        `json:"domains"`
+	LoadedAt  *time.Time `json:"loaded_at,omitempty"`  // Last successful load
+	LastError string     `json:"last_error,omitempty"` // Of the last load attempt; the list loaded before stays in use
+	Matches   uint64     `json:"matches"`              // Matching events since startup
+	LastMatch *time.Time `json:"last_match,omitempty"`
 }
 
 type ServiceInfo struct {
@@ -95,16 +244,188 @@ type ServiceInfo struct {
 }
 
 type CommunicationPattern struct {
-	SrcMAC      string      `json:"src_mac"`
-	SrcIP       string      `json:"src_ip"`
-	DstIP       string      `json:"dst_ip"`
-	DstPort     uint16      `json:"dst_port"`
-	Protocol    string      `json:"protocol"`
-	TrafficType TrafficType `json:"traffic_type"`
-	Service     string      `json:"service"`
-	Timestamp   time.Time   `json:"timestamp"`
-	L7Info      string      `json:"l7_info,omitempty"`   // DNS domain, HTTP path, TLS SNI, etc.
-	Interface   string      `json:"interface,omitempty"` // Network interface name (e.g., eth0, wlan0)
+	DeviceID    string       `json:"device_id"` // Identity of the owning device (MAC or ip:<addr>)
+	DeviceUUID  string       `json:"device_uuid,omitempty"`
+	SrcMAC      string       `json:"src_mac"`
+	SrcIP       string       `json:"src_ip"`
+	DstIP       string       `json:"dst_ip"`
+	DstPort     uint16       `json:"dst_port"`
+	Protocol    string       `json:"protocol"`
+	TrafficType TrafficType  `json:"traffic_type"`
+	Evidence    string       `json:"evidence,omitempty"` // What TrafficType was derived from, see EvidencePortHeuristic
+	Service     string       `json:"service"`
+	Timestamp   time.Time    `json:"timestamp"`
+	L7Info      string       `json:"l7_info,omitempty"`     // DNS domain, HTTP path, TLS SNI, etc.
+	Interface   string       `json:"interface,omitempty"`   // Network interface name (e.g., eth0, wlan0)
+	ID          string       `json:"id,omitempty"`          // Database key of the persisted pattern
+	Annotations []Annotation `json:"annotations,omitempty"` // Rules and anomalies the pattern contributed to
+	Resolved    *bool        `json:"resolved,omitempty"`    // External TCP only: whether the device resolved DstIP beforehand
+	Origin      string       `json:"origin,omitempty"`      // OriginSelf for traffic of the monitoring host
+}
+
+// OriginSelf marks patterns of the monitoring host itself, see
+// CommunicationPattern.Origin
+const OriginSelf = "self"
+
+// Suppression modes
+const (
+	SuppressHide   = "hide"   // Hide matching patterns from the feed and history only
+	SuppressExempt = "exempt" // Also exempt them from risk signals and detectors
+)
+
+// Suppression is a rule silencing known-benign new communication patterns.
+// Empty criteria match anything; at least one must be set.
+type Suppression struct {
+	ID          string     `json:"id"`
+	Device      string     `json:"device,omitempty"`      // Source device ID (MAC, or ip:<addr>)
+	Destination string     `json:"destination,omitempty"` // Destination IP or CIDR
+	Domain      string     `json:"domain,omitempty"`      // Queried DNS domain, subdomains included
+	Port        uint16     `json:"port,omitempty"`        // Destination port
+	Protocol    string     `json:"protocol,omitempty"`    // ARP, ICMP, TCP (incl. HTTP, TLS), UDP (incl. DNS), DNS, HTTP or TLS
+	Mode        string     `json:"mode"`
+	Comment     string     `json:"comment,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Hits        uint64     `json:"hits"` // Patterns suppressed by this rule
+	LastHit     *time.Time `json:"last_hit,omitempty"`
+}
+
+// Scopes of an ignore list entry
+const (
+	IgnoreScopeAll       = "all"        // Drop every event
+	IgnoreScopeDataPlane = "data_plane" // Drop all but ARP and DNS, so the device still shows up
+)
+
+// IgnoreEntry drops the traffic of a source MAC, or to or from a CIDR,
+// before it is tracked
+type IgnoreEntry struct {
+	ID         string    `json:"id"`
+	MAC        string    `json:"mac,omitempty"`
+	CIDR       string    `json:"cidr,omitempty"`
+	Scope      string    `json:"scope"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Suppressed uint64    `json:"suppressed"` // Events dropped since startup, in the kernel or after it
+}
+
+// IgnoreList is the ignore list with whether the kernel drops its traffic
+type IgnoreList struct {
+	Entries     []IgnoreEntry `json:"entries"`
+	Kernel      bool          `json:"kernel"`                 // The BPF maps hold the list
+	KernelError string        `json:"kernel_error,omitempty"` // Why they don't, when capturing
+}
+
+// AlertRoute sends the notified events it matches to named alert
+// destinations. Empty criteria match anything.
+type AlertRoute struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	Events       []string         `json:"events,omitempty"`        // Event classes, such as anomaly, new_device or device_offline
+	MinSeverity  string           `json:"min_severity,omitempty"`  // Anomalies below it don't match
+	AnomalyTypes []string         `json:"anomaly_types,omitempty"` // Anomaly types, such as UPLINK_DEGRADED
+	Tags         []string         `json:"tags,omitempty"`          // Device tags, all required, such as subnet:10.0.0.0/24
+	Destinations []string         `json:"destinations"`
+	Default      bool             `json:"default,omitempty"` // Matches only events no other route matched
+	CreatedAt    time.Time        `json:"created_at"`
+	Stats        *AlertRouteStats `json:"stats,omitempty"` // Since startup
+}
+
+// AlertRouteStats counts the events an alert route matched and their
+// deliveries, one per destination
+type AlertRouteStats struct {
+	RouteID     string     `json:"route_id"`
+	Name        string     `json:"name"`
+	Matched     uint64     `json:"matched"`
+	Delivered   uint64     `json:"delivered"`
+	Failed      uint64     `json:"failed"`
+	LastMatched *time.Time `json:"last_matched,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// AlertRouteMatch lists the alert routes an event would fire and the
+// destinations it would be delivered to
+type AlertRouteMatch struct {
+	Classes      []string     `json:"classes"` // Event classes of the event
+	Tags         []string     `json:"tags"`    // Tags of its device
+	Routes       []AlertRoute `json:"routes"`
+	Destinations []string     `json:"destinations"`
+	Default      bool         `json:"default"` // Only default routes matched
+}
+
+// DeviceMute silences every anomaly of one device, for good or until it
+// expires
+type DeviceMute struct {
+	Device      string     `json:"device"` // Device ID (MAC, or ip:<addr>)
+	Comment     string     `json:"comment,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Dropped     uint64     `json:"dropped"` // Anomalies dropped since cerberus started
+	LastDropped *time.Time `json:"last_dropped,omitempty"`
+}
+
+// Expectation types
+const (
+	ExpectNewDevice   = "new_device"            // A device joining the network, optionally of a vendor or OUI
+	ExpectInfraChange = "infrastructure_change" // Changes to, or anomalies about, the devices of an infrastructure role
+	ExpectIPChange    = "ip_change"             // One device moving to another IP
+)
+
+// Expectation states, derived from the window and the matches
+const (
+	ExpectationPending   = "pending"   // The window hasn't started
+	ExpectationActive    = "active"    // Within the window
+	ExpectationFulfilled = "fulfilled" // The window ended after at least one match
+	ExpectationUnmatched = "unmatched" // The window ended without a match
+)
+
+// Expectation announces a change an operator is about to make. Matching new
+// devices, device changes and anomalies within its window are recorded as
+// usual but marked expected, and skip notification.
+type Expectation struct {
+	ID        string             `json:"id"`
+	Type      string             `json:"type"`
+	Vendor    string             `json:"vendor,omitempty"`    // new_device: vendor name prefix, case-insensitive
+	OUI       string             `json:"oui,omitempty"`       // new_device: MAC prefix, e.g. 50:c7:bf
+	Role      string             `json:"role,omitempty"`      // infrastructure_change: dns, ntp or monitoring
+	Addresses []string           `json:"addresses,omitempty"` // infrastructure_change: MACs and IPs holding the role when created
+	Device    string             `json:"device,omitempty"`    // ip_change: device ID
+	Start     time.Time          `json:"start"`
+	End       time.Time          `json:"end"` // Exclusive
+	Note      string             `json:"note,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	Matched   uint64             `json:"matched"`           // Events marked expected
+	Matches   []ExpectationMatch `json:"matches,omitempty"` // The first of them
+	State     string             `json:"state,omitempty"`   // Set on API responses, never persisted
+}
+
+// Kinds of events an expectation matches
+const (
+	MatchNewDevice    = "new_device"
+	MatchDeviceChange = "device_change"
+	MatchAnomaly      = "anomaly"
+)
+
+// ExpectationMatch is an event marked expected by an expectation
+type ExpectationMatch struct {
+	Kind     string    `json:"kind"`
+	ID       string    `json:"id"` // Device ID, device change ID or anomaly ID
+	DeviceID string    `json:"device_id,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Annotation types
+const (
+	AnnotationRule        = "rule"
+	AnnotationAnomaly     = "anomaly"
+	AnnotationARPMismatch = "arp_mismatch" // ID is the ARP sender MAC differing from the Ethernet source
+	AnnotationThreat      = "threat"       // ID is <list>:<entry> of the threat list entry matched
+)
+
+// Annotation references a rule or anomaly a communication pattern is linked
+// to, or an observation about it
+type Annotation struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
 }
 
 type FlowStats struct {
@@ -114,27 +435,1242 @@ type FlowStats struct {
 	LastSeen    time.Time `json:"last_seen"`
 }
 
+// TargetSet holds the IPs a device contacted most recently, encoded as an
+// array with the most recent last
+type TargetSet = orderedset.Set[string]
+
 type DeviceInfo struct {
-	MAC               string                `json:"mac"`
-	IP                string                `json:"ip"`
-	Vendor            string                `json:"vendor"`
-	Interface         string                `json:"interface,omitempty"` // Network interface name (e.g., eth0, wlan0)
-	FirstSeen         time.Time             `json:"first_seen"`
-	LastSeen          time.Time             `json:"last_seen"`
-	RequestCount      int                   `json:"request_count"`
-	ReplyCount        int                   `json:"reply_count"`
-	TCPConnections    int                   `json:"tcp_connections"`
-	UDPConnections    int                   `json:"udp_connections"`
-	ICMPPackets       int                   `json:"icmp_packets"`
-	DNSQueries        int                   `json:"dns_queries"`
-	HTTPRequests      int                   `json:"http_requests"`
-	TLSConnections    int                   `json:"tls_connections"`
-	Targets           []string              `json:"targets"`
-	Services          map[string]int        `json:"services"` // service -> count
-	DNSDomains        map[string]int        `json:"dns_domains,omitempty"`
-	HTTPHosts         map[string]int        `json:"http_hosts,omitempty"`
-	TLSSNIs           map[string]int        `json:"tls_snis,omitempty"`
-	SeenPatterns      map[string]bool       `json:"-"`
-	TrafficTypeCounts map[TrafficType]int   `json:"traffic_type_counts"`
-	FlowStats         map[string]*FlowStats `json:"-"` // flowKey -> stats
+	ID                   string                `json:"id"`                   // MAC, or ip:<addr> for devices behind a router
+	UUID                 string                `json:"uuid"`                 // Stable across MAC changes and merges, never reused
+	FormerIDs            []string              `json:"former_ids,omitempty"` // UUIDs of devices merged into this one
+	MAC                  string                `json:"mac"`
+	IP                   string                `json:"ip"`
+	Subnet               string                `json:"subnet,omitempty"`     // Local subnet containing IP, or other/routed
+	Routed               bool                  `json:"routed,omitempty"`     // Identity keyed on IP (not L2-adjacent)
+	Vendor               string                `json:"vendor"`               // Canonical brand, see VendorAliases
+	RawVendor            string                `json:"raw_vendor,omitempty"` // Organization name the OUI is registered to
+	Name                 string                `json:"name,omitempty"`       // Name, owner and location come from the known-devices inventory
+	Owner                string                `json:"owner,omitempty"`
+	Location             string                `json:"location,omitempty"`
+	Critical             bool                  `json:"critical,omitempty"`   // Availability is tracked, see Availability
+	Icon                 string                `json:"icon,omitempty"`       // Icon key overriding the one hints derive, see Icons
+	Interface            string                `json:"interface,omitempty"`  // Network interface name (e.g., eth0, wlan0)
+	Interfaces           []string              `json:"interfaces,omitempty"` // Every interface its traffic was seen on, sorted
+	FirstSeen            time.Time             `json:"first_seen"`
+	LastSeen             time.Time             `json:"last_seen"`
+	RequestCount         int                   `json:"request_count"`
+	ReplyCount           int                   `json:"reply_count"`
+	TCPConnections       int                   `json:"tcp_connections"`
+	UDPConnections       int                   `json:"udp_connections"`
+	ICMPPackets          int                   `json:"icmp_packets"`
+	DNSQueries           int                   `json:"dns_queries"`
+	HTTPRequests         int                   `json:"http_requests"`
+	TLSConnections       int                   `json:"tls_connections"`
+	ThreatPortAccess     int                   `json:"threat_port_access"` // Unique patterns to known-dangerous ports
+	ExternalPatterns     int                   `json:"external_patterns"`  // Unique patterns to external destinations
+	ScanPatterns         int                   `json:"scan_patterns"`      // Unique ARP request / TCP SYN probes
+	DeprecatedTLS        int                   `json:"deprecated_tls"`     // Client Hellos offering TLS < 1.2
+	DoHConnections       int                   `json:"doh_connections"`    // Unique patterns to DNS-over-HTTPS resolvers
+	OSGuess              *OSGuess              `json:"os_guess,omitempty"`
+	DeviceType           *DeviceTypeGuess      `json:"device_type,omitempty"`      // Printer, camera or VoIP phone, from protocol behavior
+	TLSFingerprints      map[string]int        `json:"tls_fingerprints,omitempty"` // JA3 hash -> ClientHellos
+	Activity             *ActivityHistogram    `json:"activity,omitempty"`
+	PartialTLSHellos     int                   `json:"partial_tls_hellos,omitempty"`
+	SuspiciousDNSQueries int                   `json:"suspicious_dns_queries,omitempty"` // Queries with tunneling-like names
+	ARPLatency           *LatencyStats         `json:"arp_latency,omitempty"`            // Time to answer ARP requests for this device
+	ResolvedPatterns     int                   `json:"resolved_patterns,omitempty"`      // New external TCP patterns to IPs the device had resolved
+	DirectIPPatterns     int                   `json:"direct_ip_patterns,omitempty"`     // New external TCP patterns to IPs it never resolved
+	Transient            bool                  `json:"transient,omitempty"`              // First seen on a guest network and not since seen elsewhere
+	ExpectedBy           string                `json:"expected_by,omitempty"`            // Expectation that announced the device
+	Self                 bool                  `json:"self,omitempty"`                   // The host running cerberus, see OriginSelf
+	PacketSizes          *SizeHistogram        `json:"packet_sizes,omitempty"`
+	PortBehavior         *PortBehavior         `json:"port_behavior,omitempty"`  // How it picks source ports
+	ARPMismatches        int                   `json:"arp_mismatches,omitempty"` // ARP packets whose sender MAC differed from the Ethernet source
+	IPHistory            []IPLease             `json:"ip_history,omitempty"`     // Most recently held last
+	Circuits             []CircuitAttribution  `json:"circuits,omitempty"`       // Switch ports relayed DHCP placed it on, current last
+	Egress               *EgressCompliance     `json:"egress,omitempty"`         // Whether it reaches the internet through its required proxy or VPN
+	Firsts               *DeviceFirsts         `json:"firsts,omitempty"`         // First external contact, first use of each protocol and first contact with each country and AS
+	Health               *DeviceHealth         `json:"health,omitempty"`         // Operational health, recomputed periodically
+	Targets              TargetSet             `json:"targets"`
+	Services             ServiceCounts         `json:"services"`
+	DNSDomains           map[string]int        `json:"dns_domains,omitempty"`
+	HTTPHosts            map[string]int        `json:"http_hosts,omitempty"`
+	TLSSNIs              map[string]int        `json:"tls_snis,omitempty"`
+	HTTPHostHeaders      map[string]int        `json:"http_host_headers,omitempty"` // Host header -> requests
+	SeenPatterns         map[string]bool       `json:"-"`
+	TrafficTypeCounts    map[TrafficType]int   `json:"traffic_type_counts"`
+	EvidenceCounts       map[string]int        `json:"evidence_counts,omitempty"` // Classification evidence -> events
+	FlowStats            map[string]*FlowStats `json:"-"`                         // flowKey -> stats
+	Mute                 *DeviceMute           `json:"mute,omitempty"`            // Set on API responses for muted devices, never persisted
+	Hints                *DeviceHints          `json:"hints,omitempty"`           // Set on API responses when requested, never persisted
+}
+
+// FieldChange is the previous and current value of a device field
+type FieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// CircuitAttribution is a switch port a device was plugged into, as named by
+// the relay agent information (DHCP option 82) a relay added to its DHCP
+// messages. IDs that are not printable text are rendered as hex.
+type CircuitAttribution struct {
+	CircuitID string    `json:"circuit_id,omitempty"`
+	RemoteID  string    `json:"remote_id,omitempty"` // Usually identifies the switch
+	Relay     string    `json:"relay,omitempty"`     // Address of the relay agent (giaddr)
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Egress compliance statuses
+const (
+	EgressCompliant    = "compliant"
+	EgressNonCompliant = "non_compliant"
+)
+
+// EgressCompliance counts how a device subject to an egress policy reached
+// external destinations: through a required proxy or VPN endpoint, or
+// directly
+type EgressCompliance struct {
+	Policy       string     `json:"policy"`                // Device tag whose policy applies
+	Status       string     `json:"status"`                // compliant, or non_compliant once direct connections exceed the policy within a window
+	Compliant    int        `json:"compliant"`             // New patterns to a required endpoint
+	Direct       int        `json:"direct"`                // New patterns to other external destinations
+	WindowStart  time.Time  `json:"window_start"`          // Start of the current counting window
+	WindowDirect int        `json:"window_direct"`         // Direct patterns within it
+	LastDirect   string     `json:"last_direct,omitempty"` // Latest destination reached directly, as IP:port
+	LastDirectAt *time.Time `json:"last_direct_at,omitempty"`
+}
+
+// EgressPolicy requires the external traffic of the devices with a tag to go
+// through proxy or VPN endpoints
+type EgressPolicy struct {
+	Tag       string   `json:"tag"`
+	Endpoints []string `json:"endpoints"`  // IPs or CIDRs, with an optional port
+	MaxDirect int      `json:"max_direct"` // Direct connections tolerated within the window
+}
+
+// EgressSummary is the egress compliance of the devices subject to a policy
+type EgressSummary struct {
+	Window       string           `json:"window"`
+	Allow        []string         `json:"allow"` // Direct traffic never counted, as PROTO/port
+	Policies     []EgressPolicy   `json:"policies"`
+	Devices      int              `json:"devices"`
+	Compliant    int              `json:"compliant"`
+	NonCompliant int              `json:"non_compliant"`
+	Violators    []EgressViolator `json:"violators"` // Non-compliant devices, most direct connections first
+}
+
+// EgressViolator is a non-compliant device
+type EgressViolator struct {
+	Device string           `json:"device"`
+	Name   string           `json:"name,omitempty"`
+	Egress EgressCompliance `json:"egress"`
+}
+
+// IPLease is an address a device held, lease-style. A device returning to an
+// address extends its lease.
+type IPLease struct {
+	IP        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Milestone kinds
+const (
+	MilestoneExternalContact = "external_contact" // First pattern to a destination outside the LAN
+	MilestoneProtocol        = "protocol"         // First pattern of the protocol named by Value
+	MilestoneCountry         = "country"          // First pattern to the country whose code is Value
+	MilestoneASN             = "asn"              // First pattern to the autonomous system numbered Value
+)
+
+// Milestone is the first time a device did something, as recorded when it
+// happened
+type Milestone struct {
+	Kind        string    `json:"kind"`
+	Value       string    `json:"value,omitempty"`
+	At          time.Time `json:"at"`
+	Destination string    `json:"destination"`          // Destination IP of the pattern
+	Port        uint16    `json:"port,omitempty"`       // Its destination port
+	Protocol    string    `json:"protocol"`             // Its protocol
+	Geo         *GeoInfo  `json:"geo,omitempty"`        // Location of the destination, when a GeoIP database knows it
+	PatternID   string    `json:"pattern_id,omitempty"` // Empty for suppressed patterns, which are not persisted
+	AnomalyID   string    `json:"anomaly_id,omitempty"` // Anomaly it raised, if any
+}
+
+// DeviceFirsts are the milestones a device reached, oldest first. Firsts
+// before Since are unknown: it is when the device was first seen, or when
+// cerberus started recording milestones for devices seen before.
+type DeviceFirsts struct {
+	Since      time.Time   `json:"since"`
+	Milestones []Milestone `json:"milestones"`
+}
+
+// IPChange announces that a device settled on a new IP
+type IPChange struct {
+	ID           string    `json:"id"` // Of the device change reporting it
+	DeviceID     string    `json:"device_id"`
+	DeviceUUID   string    `json:"device_uuid,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	OldIP        string    `json:"old_ip"`
+	NewIP        string    `json:"new_ip"`
+	OldIPHeldBy  string    `json:"old_ip_held_by,omitempty"` // Device now using the old IP, if any
+	FirstChanged time.Time `json:"first_changed"`
+	Timestamp    time.Time `json:"timestamp"`
+	ExpectedBy   string    `json:"expected_by,omitempty"` // Expectation that announced the change
+}
+
+// Availability states of a critical device
+const (
+	AvailabilityUp      = "up"
+	AvailabilityDown    = "down"
+	AvailabilityUnknown = "unknown" // Cerberus itself wasn't running
+)
+
+// AvailabilityTransition is a change of the availability state of a critical
+// device. The state lasts until the next transition.
+type AvailabilityTransition struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+}
+
+// Availability summarizes the availability of a critical device over a window
+type Availability struct {
+	DeviceID             string                   `json:"device_id"`
+	Name                 string                   `json:"name,omitempty"`
+	State                string                   `json:"state"` // Current state
+	From                 time.Time                `json:"from"`
+	To                   time.Time                `json:"to"`
+	UptimePercent        *float64                 `json:"uptime_percent"` // Of the time the state was known, nil if it never was
+	UpSeconds            float64                  `json:"up_seconds"`
+	DownSeconds          float64                  `json:"down_seconds"`
+	UnknownSeconds       float64                  `json:"unknown_seconds"` // Cerberus down, or not yet tracking the device
+	Outages              int                      `json:"outages"`         // Down intervals overlapping the window
+	LongestOutageSeconds float64                  `json:"longest_outage_seconds"`
+	Transitions          []AvailabilityTransition `json:"transitions,omitempty"` // Within the window, the state at its start first
+}
+
+// FreeAddressNote qualifies free address suggestions
+const FreeAddressNote = "Addresses cerberus hasn't seen in use since the start of the lookback. Devices that were off or silent meanwhile are invisible to it, so confirm an address is unused before assigning it."
+
+// FreeAddresses are the addresses of a subnet suggested for static assignment
+type FreeAddresses struct {
+	Subnet    string        `json:"subnet"`
+	Lookback  string        `json:"lookback"`
+	Since     time.Time     `json:"since"` // Start of the lookback
+	Note      string        `json:"note"`
+	Addresses []FreeAddress `json:"addresses"`
+	Truncated bool          `json:"truncated"` // More addresses are free than returned
+}
+
+// FreeAddress is an address not seen in use within the lookback
+type FreeAddress struct {
+	IP       string     `json:"ip"`
+	LastSeen *time.Time `json:"last_seen,omitempty"` // Before the lookback; absent if never seen
+}
+
+// ChurnPoint counts devices joining and leaving the network in one hour or
+// day
+type ChurnPoint struct {
+	Start       time.Time `json:"start"`
+	Joined      int       `json:"joined"`      // Devices first seen
+	Left        int       `json:"left"`        // Devices that went inactive
+	Reactivated int       `json:"reactivated"` // Inactive devices seen again
+	Active      int       `json:"active"`      // Devices not inactive at the end of the period, or now
+}
+
+// ChurnSummary is the churn of the current hour and day so far
+type ChurnSummary struct {
+	Hour ChurnPoint `json:"hour"`
+	Day  ChurnPoint `json:"day"`
+}
+
+// DeviceUpdate reports meaningful changes to a known device, accumulated
+// until the device settled
+type DeviceUpdate struct {
+	ID           string                 `json:"id"`
+	DeviceID     string                 `json:"device_id"`
+	DeviceUUID   string                 `json:"device_uuid,omitempty"`
+	Changes      map[string]FieldChange `json:"changes"` // Field -> values before and after
+	FirstChanged time.Time              `json:"first_changed"`
+	Timestamp    time.Time              `json:"timestamp"`
+	ExpectedBy   string                 `json:"expected_by,omitempty"` // Expectation that announced the change
+}
+
+// SizeHistogram counts captured packets by frame length, Ethernet header
+// included
+type SizeHistogram struct {
+	Under64  uint64 `json:"under_64"`  // Bare ACKs, ARP and other control traffic
+	To511    uint64 `json:"64_511"`    // Small requests and interactive traffic
+	To1514   uint64 `json:"512_1514"`  // Up to full frames of a 1500-byte MTU
+	Over1514 uint64 `json:"over_1514"` // Jumbo frames, or segments coalesced by the NIC
+}
+
+// DeviceSnapshot is the state of a device at one time: its counters (keyed
+// like the DeviceInfo fields), address, risk score and busiest domains and
+// services
+type DeviceSnapshot struct {
+	DeviceID    string         `json:"device_id"`
+	Taken       time.Time      `json:"taken"`
+	IP          string         `json:"ip"`
+	LastSeen    time.Time      `json:"last_seen"`
+	RiskScore   int            `json:"risk_score"`
+	Counters    map[string]int `json:"counters"`
+	TopDomains  []string       `json:"top_domains"`
+	TopServices []string       `json:"top_services"`
+}
+
+// SnapshotDelta is how a device changed between a snapshot and now
+type SnapshotDelta struct {
+	Counters    map[string]int `json:"counters"` // Increase of each counter that changed
+	RiskScore   int            `json:"risk_score"`
+	IP          *FieldChange   `json:"ip,omitempty"`
+	NewDomains  []string       `json:"new_domains"`  // Top domains now that weren't then
+	NewServices []string       `json:"new_services"` // Top services now that weren't then
+}
+
+// DeviceAsOf is the snapshot of a device nearest to a requested time, with
+// its changes since. Current and Delta are left out for devices not tracked
+// now.
+type DeviceAsOf struct {
+	Requested time.Time       `json:"requested"`
+	Snapshot  DeviceSnapshot  `json:"snapshot"`
+	Current   *DeviceSnapshot `json:"current,omitempty"`
+	Delta     *SnapshotDelta  `json:"delta,omitempty"`
+}
+
+// ForgottenDevice is what remains of a transient device once it is forgotten
+type ForgottenDevice struct {
+	ID          string    `json:"id"`
+	UUID        string    `json:"uuid,omitempty"`
+	MAC         string    `json:"mac"`
+	IP          string    `json:"ip"`
+	Vendor      string    `json:"vendor"`
+	Interface   string    `json:"interface,omitempty"`
+	OS          string    `json:"os,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	ForgottenAt time.Time `json:"forgotten_at"`
+	Connections int       `json:"connections"`           // TCP and UDP events
+	TopDomains  []string  `json:"top_domains,omitempty"` // Most queried DNS domains
+}
+
+// LatencyStats summarizes observed response times in milliseconds
+type LatencyStats struct {
+	Samples int     `json:"samples"`
+	LastMs  float64 `json:"last_ms"`
+	MinMs   float64 `json:"min_ms"`
+	MaxMs   float64 `json:"max_ms"`
+	AvgMs   float64 `json:"avg_ms"` // Exponentially weighted, so recent samples dominate
+}
+
+// RiskFactor is a single signal contributing to a device risk score
+type RiskFactor struct {
+	Name   string  `json:"name"`
+	Points float64 `json:"points"`
+	Detail string  `json:"detail"`
+}
+
+// Device health states
+const (
+	DeviceHealthGood     = "good"
+	DeviceHealthDegraded = "degraded"
+	DeviceHealthPoor     = "poor"
+	DeviceHealthUnknown  = "unknown" // Too little traffic to judge
+)
+
+// DeviceHealthStates lists the device health states, healthiest first
+var DeviceHealthStates = []string{DeviceHealthGood, DeviceHealthDegraded, DeviceHealthPoor, DeviceHealthUnknown}
+
+// HealthFactor is a measured signal of a device health score
+type HealthFactor struct {
+	Name    string  `json:"name"`
+	Value   float64 `json:"value"`   // Failure ratio, changes per window, or uptime percentage for availability
+	Samples int     `json:"samples"` // Events or intervals it was measured over
+	Penalty float64 `json:"penalty"` // Points taken off the score
+	Detail  string  `json:"detail"`
+}
+
+// DeviceHealth is the operational health of a device, apart from its risk:
+// whether its connections and lookups fail, its interface flaps or it goes
+// down. Factors lists those with enough data to be measured.
+type DeviceHealth struct {
+	State      string         `json:"state"`
+	Score      *int           `json:"score"` // 0-100, 100 when healthy; null while unknown
+	Factors    []HealthFactor `json:"factors"`
+	Window     string         `json:"window"` // Traffic the factors were measured over
+	ComputedAt time.Time      `json:"computed_at"`
+}
+
+// DeviceAttention is a device whose health is degraded or poor
+type DeviceAttention struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name,omitempty"`
+	IP     string       `json:"ip"`
+	Health DeviceHealth `json:"health"`
+}
+
+// RiskScore is a composite 0-100 device risk score with its contributing factors
+type RiskScore struct {
+	ID      string       `json:"id"`
+	Score   int          `json:"score"`
+	Factors []RiskFactor `json:"factors"`
+}
+
+// ActivityHistogram counts packets per day-of-week and hour with exponential
+// decay. Each cell is decayed lazily when touched, so updates are O(1).
+type ActivityHistogram struct {
+	Counts  [7][24]float64 `json:"counts"`  // [weekday][hour], Sunday first, local time
+	Updated [7][24]int64   `json:"updated"` // Unix time each cell was last decayed
+	Since   time.Time      `json:"since"`
+}
+
+// DeviceActivity is a device's activity heatmap with derived typical hours
+type DeviceActivity struct {
+	ID                  string         `json:"id"`
+	Matrix              [7][24]float64 `json:"matrix"` // Decayed packet counts as of Timestamp
+	TypicalHours        []int          `json:"typical_hours"`
+	TypicalSlots        [7][]int       `json:"typical_slots"` // Typical hours per weekday
+	Learning            bool           `json:"learning"`      // Too little history for typical hours
+	ActiveNow           bool           `json:"active_now"`
+	OutsideTypicalHours bool           `json:"outside_typical_hours"`
+	Since               time.Time      `json:"since"`
+	Timestamp           time.Time      `json:"timestamp"`
+}
+
+// Source port behavior assessments
+const (
+	PortsInsufficientData = "insufficient data"
+	PortsRandomized       = "randomized"
+	PortsSequential       = "sequential" // Typical of embedded and legacy stacks
+	PortsReused           = "reused"     // Recent ports picked again, such as one port for every DNS query
+)
+
+// PortBehavior summarizes the source ports a device opens TCP connections and
+// sends DNS queries from. The statistics are streaming: ports aren't kept,
+// and recent flows weigh more.
+type PortBehavior struct {
+	Flows         int       `json:"flows"`          // TCP connections and DNS queries counted
+	WindowFlows   int       `json:"window_flows"`   // Flows in the current window
+	DistinctPorts int       `json:"distinct_ports"` // Estimated distinct ports of the last full window, or of the first one so far
+	Sequentiality float64   `json:"sequentiality"`  // Share of flows a small step above one of the last few ports
+	ReuseRate     float64   `json:"reuse_rate"`     // Share of flows from one of the last few ports
+	Assessment    string    `json:"assessment"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Streaming state, rebuilt after a restart
+	Sketch     [16]uint64 `json:"-"` // Linear-counting bitmap of the ports of the current window
+	Recent     [16]uint16 `json:"-"` // Ring of the last ports
+	RecentNext int        `json:"-"`
+}
+
+// OS guess confidence levels
+const (
+	ConfidenceLow    = "low"
+	ConfidenceMedium = "medium"
+	ConfidenceHigh   = "high"
+)
+
+// OSEvidence is one passive fingerprinting signal and how often it was observed
+type OSEvidence struct {
+	Signal string  `json:"signal"`
+	OS     string  `json:"os"`
+	Weight float64 `json:"weight"`
+	Count  int     `json:"count"`
+}
+
+// OSGuess is the passively inferred operating system of a device
+type OSGuess struct {
+	OS         string       `json:"os"`
+	Confidence string       `json:"confidence"`
+	Evidence   []OSEvidence `json:"evidence"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// DeviceTypeEvidence is one protocol behavior supporting a device type
+type DeviceTypeEvidence struct {
+	Signal string  `json:"signal"`
+	Type   string  `json:"type"`
+	Weight float64 `json:"weight"`
+	Count  int     `json:"count"`
+}
+
+// Icon keys of device hints. Keys are only ever added, never renamed or
+// removed, so clients should show a key they don't know as IconUnknown.
+const (
+	IconUnknown     = "unknown"
+	IconPhone       = "phone"
+	IconLaptop      = "laptop"
+	IconTV          = "tv"
+	IconCamera      = "camera"
+	IconPrinter     = "printer"
+	IconRouter      = "router"
+	IconSpeaker     = "speaker"
+	IconServer      = "server"
+	IconIoT         = "iot"
+	IconGameConsole = "game_console"
+)
+
+// Icons lists every icon key, in the order they were added
+var Icons = []string{
+	IconUnknown, IconPhone, IconLaptop, IconTV, IconCamera, IconPrinter,
+	IconRouter, IconSpeaker, IconServer, IconIoT, IconGameConsole,
+}
+
+// Sources of the icon of device hints
+const (
+	IconSourceOverride = "override" // The device's icon, from the inventory
+	IconSourceType     = "type"     // Its inferred device type
+	IconSourceVendor   = "vendor"   // Its vendor
+	IconSourceOS       = "os"       // Its guessed operating system
+	IconSourceDefault  = "default"  // Nothing known, IconUnknown
+)
+
+// DeviceHints suggests how a UI presents a device
+type DeviceHints struct {
+	Icon       string `json:"icon"`        // One of Icons
+	IconSource string `json:"icon_source"` // What chose the icon
+	Color      string `json:"color"`       // #rrggbb suggested for its risk level
+	RiskScore  int    `json:"risk_score"`
+	RiskLevel  string `json:"risk_level"` // low, medium or high
+	Label      string `json:"label"`      // Short human name
+}
+
+// DeviceTypeGuess is the device class inferred from the protocols a device serves and speaks
+type DeviceTypeGuess struct {
+	Type       string               `json:"type"`
+	Confidence string               `json:"confidence"`
+	Evidence   []DeviceTypeEvidence `json:"evidence"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+// Service classes: how the service of an event was named
+const (
+	ServiceClassL7       = "l7"       // DNS, HTTP or TLS recognized in the payload
+	ServiceClassPort     = "port"     // Service database entry for the destination port
+	ServiceClassProtocol = "protocol" // ARP and ICMP traffic types, which have no port
+	ServiceClassUnknown  = "unknown"  // Port no database names, as TCP/<port> or UDP/<port>
+)
+
+// ServiceClasses lists every service class, most confident first
+var ServiceClasses = []string{ServiceClassL7, ServiceClassPort, ServiceClassProtocol, ServiceClassUnknown}
+
+// ServiceCounts counts the events of a device per service, by service class
+type ServiceCounts struct {
+	L7       map[string]int `json:"l7,omitempty"`
+	Port     map[string]int `json:"port,omitempty"`
+	Protocol map[string]int `json:"protocol,omitempty"`
+	Unknown  map[string]int `json:"unknown,omitempty"`
+}
+
+// Class returns the counts of a service class, or nil for an unknown class
+func (c *ServiceCounts) Class(class string) *map[string]int {
+	switch class {
+	case ServiceClassL7:
+		return &c.L7
+	case ServiceClassPort:
+		return &c.Port
+	case ServiceClassProtocol:
+		return &c.Protocol
+	case ServiceClassUnknown:
+		return &c.Unknown
+	}
+	return nil
+}
+
+// Totals counts the events per service name regardless of class, for
+// summaries listing top services
+func (c ServiceCounts) Totals() map[string]int {
+	totals := make(map[string]int)
+	for _, class := range ServiceClasses {
+		for service, count := range *c.Class(class) {
+			totals[service] += count
+		}
+	}
+	return totals
+}
+
+// UnmarshalJSON also reads the flat service -> count map persisted before
+// services were classified, sorting each name into the class it was most
+// likely counted under
+func (c *ServiceCounts) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for _, value := range raw {
+		if len(value) > 0 && value[0] == '{' {
+			type plain ServiceCounts // Without this method
+			return json.Unmarshal(data, (*plain)(c))
+		}
+	}
+
+	var legacy map[string]int
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	*c = ServiceCounts{}
+	for name, count := range legacy {
+		counts := c.Class(legacyServiceClass(name))
+		if *counts == nil {
+			*counts = make(map[string]int)
+		}
+		(*counts)[name] += count
+	}
+	return nil
+}
+
+// legacyServiceClass guesses the class of a service name counted before
+// services were classified. DNS, HTTP and TLS were also the names of events
+// recognized in the payload, which outnumber port guesses, so they count as
+// L7.
+func legacyServiceClass(name string) string {
+	switch {
+	case name == "DNS" || name == "HTTP" || name == "TLS":
+		return ServiceClassL7
+	case strings.HasPrefix(name, "ARP_") || strings.HasPrefix(name, "ICMP_"):
+		return ServiceClassProtocol
+	}
+	if protocol, port, ok := strings.Cut(name, "/"); ok && (protocol == "TCP" || protocol == "UDP") {
+		if _, err := strconv.ParseUint(port, 10, 16); err == nil {
+			return ServiceClassUnknown
+		}
+	}
+	return ServiceClassPort
+}
+
+// ServiceEntry is one service of a device with how it was named
+type ServiceEntry struct {
+	Service string `json:"service"`
+	Class   string `json:"class"`
+	Count   int    `json:"count"`
+}
+
+// DeviceServices lists the services of a device, most used first, with the
+// events per service class
+type DeviceServices struct {
+	DeviceID    string         `json:"device_id"`
+	Services    []ServiceEntry `json:"services"`
+	ClassCounts map[string]int `json:"class_counts"`
+}
+
+// UnknownPort is a destination port no service database names, across the
+// tracked devices
+type UnknownPort struct {
+	Protocol string `json:"protocol"`
+	Port     uint16 `json:"port"`
+	Events   int    `json:"events"`
+	Devices  int    `json:"devices"`
+}
+
+// Anomaly severities
+const (
+	SeverityInfo   = "INFO"
+	SeverityLow    = "LOW"
+	SeverityMedium = "MEDIUM"
+	SeverityHigh   = "HIGH"
+)
+
+// SeverityRank orders severities from INFO (0) to HIGH (3), returning -1 for
+// unknown ones
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityInfo:
+		return 0
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	}
+	return -1
+}
+
+// Anomaly is a noteworthy condition raised by a detector or by cerberus itself
+type Anomaly struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	Severity    string            `json:"severity"`
+	DeviceID    string            `json:"device_id,omitempty"`
+	DeviceUUID  string            `json:"device_uuid,omitempty"`
+	Description string            `json:"description"`      // Rendered from the template of Type with Params
+	Params      map[string]string `json:"params,omitempty"` // Template parameters, for clients rendering their own text
+	Details     map[string]string `json:"details,omitempty"`
+	Patterns    []string          `json:"patterns,omitempty"` // IDs of contributing communication patterns
+	Timestamp   time.Time         `json:"timestamp"`
+	Ack         *AnomalyAck       `json:"ack,omitempty"`
+	Note        *AnomalyNote      `json:"note,omitempty"`
+	MutedBy     string            `json:"muted_by,omitempty"`    // Acknowledged anomaly that silenced this one's notification
+	ExpectedBy  string            `json:"expected_by,omitempty"` // Expectation that announced it, acknowledging it on arrival
+}
+
+// MessageTemplate is the template anomaly descriptions or report
+// explanations of one key are rendered from
+type MessageTemplate struct {
+	Key        string   `json:"key"`
+	Template   string   `json:"template"`
+	Params     []string `json:"params"`               // Parameters the template can use
+	Overridden bool     `json:"overridden,omitempty"` // Loaded from the override file
+}
+
+// MessageTemplates is the template catalog in use
+type MessageTemplates struct {
+	Source    string            `json:"source,omitempty"` // Override file, empty when only built-in templates are used
+	Templates []MessageTemplate `json:"templates"`
+}
+
+// Decision records how the pattern detectors judged a new pattern of an
+// audited device
+type Decision struct {
+	PatternID       string             `json:"pattern_id,omitempty"` // Empty for suppressed patterns, which are not stored
+	DstIP           string             `json:"dst_ip"`
+	DstPort         uint16             `json:"dst_port"`
+	Protocol        string             `json:"protocol"`
+	TrafficType     TrafficType        `json:"traffic_type"`
+	L7Info          string             `json:"l7_info,omitempty"`
+	External        bool               `json:"external"`
+	Excluded        bool               `json:"excluded,omitempty"`         // Traffic of the monitoring host, not alerted on
+	Suppression     string             `json:"suppression,omitempty"`      // ID of the suppression rule that matched
+	SuppressionMode string             `json:"suppression_mode,omitempty"` // Its mode
+	Timestamp       time.Time          `json:"timestamp"`
+	Detectors       []DetectorDecision `json:"detectors"`
+}
+
+// DetectorDecision is the outcome of one detector on a pattern
+type DetectorDecision struct {
+	Detector  string   `json:"detector"`
+	Outcome   string   `json:"outcome"` // skipped, not_matched or matched
+	Reason    string   `json:"reason"`
+	Value     *float64 `json:"value,omitempty"`     // What the detector compared with its threshold
+	Threshold *float64 `json:"threshold,omitempty"` // The threshold at the time
+	AnomalyID string   `json:"anomaly_id,omitempty"`
+}
+
+// DecisionAudit lists the decision audits running
+type DecisionAudit struct {
+	Global  *time.Time    `json:"global,omitempty"` // When the audit of every device ends
+	Devices []DeviceAudit `json:"devices"`
+}
+
+// DeviceAudit is the decision audit of one device
+type DeviceAudit struct {
+	Device string    `json:"device"`
+	Until  time.Time `json:"until"`
+}
+
+// DeviceDecisions are the decisions recorded for a device, newest first
+type DeviceDecisions struct {
+	Device       string     `json:"device"`
+	AuditedUntil *time.Time `json:"audited_until,omitempty"`
+	Decisions    []Decision `json:"decisions"`
+}
+
+// AnomalyAck records that an operator has triaged an anomaly
+type AnomalyAck struct {
+	At      time.Time `json:"at"`
+	Comment string    `json:"comment,omitempty"`
+}
+
+// AnomalyNote is a note an operator assigned to an anomaly while triaging
+type AnomalyNote struct {
+	At   time.Time `json:"at"`
+	Text string    `json:"text"`
+}
+
+// AnomalyFilter selects anomalies for bulk operations and saved views. Empty
+// criteria match anything; an anomaly must match one value of every list
+// given, and every tag.
+type AnomalyFilter struct {
+	Types        []string   `json:"types,omitempty"`
+	Severities   []string   `json:"severities,omitempty"`
+	MinSeverity  string     `json:"min_severity,omitempty"`
+	Devices      []string   `json:"devices,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`   // Device tags, as alert routes match them
+	Since        *time.Time `json:"since,omitempty"`  // Raised at or after
+	Until        *time.Time `json:"until,omitempty"`  // Raised before
+	Within       string     `json:"within,omitempty"` // Raised within this duration before now, such as 12h
+	Acknowledged *bool      `json:"acknowledged,omitempty"`
+	Suppressed   *bool      `json:"suppressed,omitempty"` // Recorded without notification: muted by an ack, or expected
+}
+
+// SavedAnomalyFilter is a named anomaly filter
+type SavedAnomalyFilter struct {
+	Name      string        `json:"name"`
+	Filter    AnomalyFilter `json:"filter"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Outcomes of a bulk anomaly operation on one anomaly
+const (
+	BulkUpdated   = "updated"
+	BulkUnchanged = "unchanged"
+	BulkDeleted   = "deleted"
+	BulkNotFound  = "not_found"
+	BulkFailed    = "failed"
+)
+
+// AnomalyBulkItem is the outcome of a bulk anomaly operation on one anomaly
+type AnomalyBulkItem struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AnomalyBulkResult is the outcome of a bulk anomaly operation
+type AnomalyBulkResult struct {
+	Action   string            `json:"action"`
+	Matched  int               `json:"matched"`  // Anomalies selected
+	Affected int               `json:"affected"` // Anomalies updated or deleted
+	More     bool              `json:"more"`     // The filter selected the most one call takes; more anomalies match
+	Results  []AnomalyBulkItem `json:"results"`
+}
+
+// PersistenceStatus reports whether device state is reaching the database
+type PersistenceStatus struct {
+	Healthy      bool       `json:"healthy"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	FailedWrites uint64     `json:"failed_writes"`
+}
+
+// DatabaseIncident records a database found corrupt at startup and replaced
+type DatabaseIncident struct {
+	At             time.Time `json:"at"`
+	Error          string    `json:"error"`
+	MovedTo        string    `json:"moved_to"`        // The corrupt file, kept for inspection
+	RecoveredKeys  int       `json:"recovered_keys"`  // Entries salvaged from before the damage
+	RecoveredBytes int64     `json:"recovered_bytes"` // Length of the salvaged part of the file
+	LostBytes      int64     `json:"lost_bytes"`      // Length of the rest, from the damage on
+}
+
+// Health states
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// HealthStatus summarizes whether cerberus is fully operational
+type HealthStatus struct {
+	Status        string            `json:"status"`
+	Reasons       []string          `json:"reasons,omitempty"`
+	Persistence   PersistenceStatus `json:"persistence"`
+	DefensiveMode bool              `json:"defensive_mode"`
+	Capture       *CaptureStatus    `json:"capture,omitempty"`           // Active kernel-side capture settings
+	ReadOnly      bool              `json:"read_only,omitempty"`         // Serving a database opened read-only, without capture
+	Database      *DatabaseIncident `json:"database_incident,omitempty"` // The database was found corrupt at startup
+	Mode          string            `json:"mode,omitempty"`              // api-only, or empty for a process that captures
+	Writer        *WriterStatus     `json:"writer,omitempty"`            // The capturing process an api-only process follows
+	Version       string            `json:"version"`
+	Timestamp     time.Time         `json:"timestamp"`
+}
+
+// WriterStatus describes the capturing process an api-only process follows:
+// its event socket, which carries the live events, and the database it
+// persists, which the api-only process reloads
+type WriterStatus struct {
+	Socket     string     `json:"socket"`
+	Connected  bool       `json:"connected"`
+	Since      time.Time  `json:"since"` // Of the current connection, or of the disconnection
+	PID        int        `json:"pid,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	Started    *time.Time `json:"started,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Reconnects int        `json:"reconnects"`
+	LoadedAt   time.Time  `json:"loaded_at"` // When the persisted data served was last reloaded
+}
+
+// DomainAllowlist lists the domains exempt from suspicious-domain scoring,
+// each with its subdomains
+type DomainAllowlist struct {
+	BuiltIn    []string `json:"built_in"`
+	Configured []string `json:"configured"`
+}
+
+// VendorAliases lists the aliases mapping registered vendor names, keyed in
+// normalized form, to canonical brands
+type VendorAliases struct {
+	BuiltIn    map[string]string `json:"built_in"`
+	Configured map[string]string `json:"configured"`
+}
+
+// VendorReindex counts the devices whose vendor a reindex changed
+type VendorReindex struct {
+	Checked int `json:"checked"`
+	Changed int `json:"changed"`
+}
+
+// FlushResult counts what an on-demand persistence pass wrote
+type FlushResult struct {
+	Devices     int       `json:"devices"`
+	Patterns    int       `json:"patterns"`
+	Anomalies   int       `json:"anomalies"`
+	DurationMs  float64   `json:"duration_ms"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// MaintenanceReport describes what a maintenance run reclaimed
+type MaintenanceReport struct {
+	StartedAt        time.Time `json:"started_at"`
+	Trigger          string    `json:"trigger"` // on_demand or scheduled
+	DurationMs       float64   `json:"duration_ms"`
+	ForgottenDevices int       `json:"forgotten_devices"`       // Expired transient devices deleted
+	L7EntriesTrimmed int       `json:"l7_entries_trimmed"`      // Least seen per-device domains, hosts and SNIs dropped
+	DetectorEntries  int       `json:"detector_entries_pruned"` // Aged-out DNS, direct-IP and ARP detector state, and stale IP index entries
+	DBBytesBefore    int64     `json:"db_bytes_before"`
+	DBBytesAfter     int64     `json:"db_bytes_after"`
+	Errors           []string  `json:"errors,omitempty"`
+}
+
+// BackupManifest is the manifest.json of a backup archive
+type BackupManifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Version   string    `json:"version"`
+	Devices   int       `json:"devices"` // Devices in the database snapshot
+	Keys      int       `json:"keys"`    // Every database entry: devices, patterns, anomalies, ...
+	Database  string    `json:"database"`
+}
+
+// BuildInfo describes the running build
+type BuildInfo struct {
+	Version     string            `json:"version"`
+	Commit      string            `json:"commit"`
+	BuildDate   string            `json:"build_date"`
+	GoVersion   string            `json:"go_version"`
+	EventLayout int               `json:"event_layout"` // See EventLayoutVersion
+	Features    map[string]string `json:"features,omitempty"`
+}
+
+// InventoryDiff lists how the persisted inventory changed between two times
+type InventoryDiff struct {
+	From              time.Time               `json:"from"`
+	To                time.Time               `json:"to"`
+	AddedDevices      []DeviceChange          `json:"added_devices"`   // First seen within [from, to)
+	RemovedDevices    []DeviceChange          `json:"removed_devices"` // Active in the period before from, not seen since
+	NewPatterns       []*CommunicationPattern `json:"new_patterns"`    // First observed within [from, to)
+	PatternsTruncated bool                    `json:"patterns_truncated,omitempty"`
+}
+
+// DeviceChange identifies a device that appeared or disappeared
+type DeviceChange struct {
+	ID        string    `json:"id"`
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip"`
+	Vendor    string    `json:"vendor"`
+	Subnet    string    `json:"subnet,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// CaptureConfig selects what the eBPF program sends to userspace
+type CaptureConfig struct {
+	Events         []string `json:"events"`           // Enabled event types; empty means all
+	TCPControlOnly bool     `json:"tcp_control_only"` // Plain TCP events only for SYN, FIN and RST segments
+	Subnets        []string `json:"subnets"`          // IPv4 CIDRs events must involve as source or destination; empty means any
+
+	// L7 payload bytes captured per event type (DNS, HTTP, TLS); missing
+	// types capture as much as their record holds
+	PayloadBytes map[string]int `json:"payload_bytes,omitempty"`
+
+	// L7 payload bytes carried by the events themselves, per event type;
+	// missing types carry none. Unset uses the defaults.
+	EventPayloadBytes map[string]int `json:"event_payload_bytes,omitempty"`
+
+	// Plain TCP packets of established flows are counted in the kernel and
+	// sent as one summary every FlowPackets packets, or once FlowIntervalMs
+	// passed since the first packet counted (0 for no limit). 0 packets sends
+	// an event per packet.
+	FlowPackets    int `json:"flow_packets"`
+	FlowIntervalMs int `json:"flow_interval_ms"`
+}
+
+// InterfaceCapture is the capture configuration of one interface. It replaces
+// the global event types, payload lengths and sampling for packets arriving on
+// the interface; subnets and flow aggregation stay global.
+type InterfaceCapture struct {
+	Events            []string       `json:"events"` // Enabled event types; empty means all
+	TCPControlOnly    bool           `json:"tcp_control_only"`
+	PayloadBytes      map[string]int `json:"payload_bytes,omitempty"`       // As CaptureConfig.PayloadBytes
+	EventPayloadBytes map[string]int `json:"event_payload_bytes,omitempty"` // As CaptureConfig.EventPayloadBytes
+	Sample            int            `json:"sample"`                        // Send 1 in Sample events, chosen at random; 0 or 1 sends all
+}
+
+// Where the capture settings of an interface come from
+const (
+	CaptureScopeGlobal    = "global"
+	CaptureScopeInterface = "interface"
+)
+
+// InterfaceDetail is the state of an interface with the capture settings in
+// effect on it
+type InterfaceDetail struct {
+	InterfaceStatus
+	Capture      InterfaceCapture `json:"capture"`
+	CaptureScope string           `json:"capture_scope"` // interface when configured for it, else global
+}
+
+// CaptureStatus is the capture configuration read back from the kernel
+type CaptureStatus struct {
+	Config     CaptureConfig              `json:"config"`
+	Suppressed map[string]uint64          `json:"suppressed"`            // Events dropped by the config, per event type
+	RingBuffer map[string]RingBufferUsage `json:"ring_buffer,omitempty"` // Event ring buffer traffic, per event type
+}
+
+// RingBufferUsage is the event ring buffer traffic of one event type since
+// startup. SavedBytes estimates what sending only the captured payload saved
+// over fixed-size events holding the most payload an event can carry.
+type RingBufferUsage struct {
+	Events       uint64 `json:"events"`
+	Bytes        uint64 `json:"bytes"`
+	PayloadBytes uint64 `json:"payload_bytes"`
+	SavedBytes   uint64 `json:"saved_bytes"`
+}
+
+// PatternSummary counts the new patterns of a device whose notifications were
+// throttled within one window
+type PatternSummary struct {
+	DeviceID   string    `json:"device_id"`
+	DeviceUUID string    `json:"device_uuid,omitempty"`
+	Suppressed int       `json:"suppressed"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+// PortTraffic is what a device sent to one destination port within a window
+type PortTraffic struct {
+	Port    string  `json:"port"` // PROTO/port, such as UDP/53, or ICMP
+	Packets uint64  `json:"packets"`
+	Bytes   uint64  `json:"bytes"`
+	Share   float64 `json:"share"` // Of the device's bytes in the window
+}
+
+// PortDistribution is the body of /api/v1/devices/{id}/ports
+type PortDistribution struct {
+	DeviceID         string        `json:"device_id"`
+	Window           string        `json:"window"`
+	From             time.Time     `json:"from"`
+	To               time.Time     `json:"to"`
+	Packets          uint64        `json:"packets"`
+	Bytes            uint64        `json:"bytes"`
+	ICMPPayloadBytes uint64        `json:"icmp_payload_bytes"`
+	Role             string        `json:"role,omitempty"` // Infrastructure role, exempting its own ports from detection
+	Ports            []PortTraffic `json:"ports"`          // Most bytes first
+}
+
+// AnomalyDigest summarizes the low-severity anomalies batched over one digest
+// interval. Items is capped, with the rest counted in More.
+type AnomalyDigest struct {
+	From       time.Time      `json:"from"`
+	To         time.Time      `json:"to"`
+	Total      int            `json:"total"`
+	ByType     map[string]int `json:"by_type"`
+	TopDevices []DeviceCount  `json:"top_devices"`
+	Items      []DigestItem   `json:"items"`
+	More       int            `json:"more,omitempty"`
+	Overflow   string         `json:"overflow,omitempty"` // "and N more", when More > 0
+}
+
+// DeviceCount is the number of digested anomalies of one device
+type DeviceCount struct {
+	DeviceID string `json:"device_id"`
+	Count    int    `json:"count"`
+}
+
+// DigestItem identifies one digested anomaly
+type DigestItem struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Severity string    `json:"severity"`
+	DeviceID string    `json:"device_id,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// StatsReport is the body of /api/v1/stats
+type StatsReport struct {
+	TotalDevices    int               `json:"total_devices"`
+	TotalPackets    uint64            `json:"total_packets"`
+	SelfPackets     uint64            `json:"self_packets"` // Packets of the monitoring host, not in the other counters
+	ArpPackets      uint64            `json:"arp_packets"`
+	TcpPackets      uint64            `json:"tcp_packets"`
+	UdpPackets      uint64            `json:"udp_packets"`
+	IcmpPackets     uint64            `json:"icmp_packets"`
+	DnsPackets      uint64            `json:"dns_packets"`
+	HttpPackets     uint64            `json:"http_packets"`
+	TlsPackets      uint64            `json:"tls_packets"`
+	FilteredPackets uint64            `json:"filtered_packets"`
+	InvalidEvents   uint64            `json:"invalid_events"`          // Corrupt events dropped before tracking
+	FlowSummaries   uint64            `json:"flow_summaries"`          // Flow summary events tracked
+	FlowPackets     uint64            `json:"flow_packets"`            // Packets counted in the kernel and sent in flow summaries, not in the other counters
+	FlowBytes       uint64            `json:"flow_bytes"`              // Bytes of those packets
+	PayloadBytes    map[string]int    `json:"payload_bytes,omitempty"` // Effective L7 capture length per event type
+	FailedPersists  uint64            `json:"failed_persists"`
+	EnabledEvents   []string          `json:"enabled_events"`
+	Subnets         []SubnetStats     `json:"subnets"`
+	L7Intern        InternStats       `json:"l7_intern"`
+	PacketSizes     SizeHistogram     `json:"packet_sizes"`
+	AlertRoutes     []AlertRouteStats `json:"alert_routes,omitempty"` // Deliveries per alert route
+	Interfaces      []InterfaceStats  `json:"interfaces"`             // Traffic and utilization per interface
+	WriterOnly      []string          `json:"writer_only,omitempty"`  // Fields only counted by the capturing process, zero in an api-only one
+}
+
+// SubnetStats aggregates the devices of one local subnet
+type SubnetStats struct {
+	Subnet  string `json:"subnet"`  // CIDR, or "other/routed"
+	Devices int    `json:"devices"` // Tracked devices
+	Active  int    `json:"active"`  // Devices seen in the last 5 minutes
+	Packets int    `json:"packets"` // Packets from those devices
+}
+
+// GroupStats aggregates the devices of one vendor or network
+type GroupStats struct {
+	Group            string             `json:"group"`
+	Devices          int                `json:"devices"`        // Current members
+	Active           int                `json:"active"`         // Members seen in the last 5 minutes
+	Packets          uint64             `json:"packets"`        // Packets from members within the window
+	Bytes            uint64             `json:"bytes"`          // Their captured length
+	ExternalBytes    uint64             `json:"external_bytes"` // Of which to external destinations
+	TopDestinations  []GroupDestination `json:"top_destinations"`
+	UnackedAnomalies int                `json:"unacked_anomalies"` // Unacknowledged recent anomalies of members within the window
+}
+
+// GroupDestination is a destination of a device group
+type GroupDestination struct {
+	Destination string `json:"destination"`
+	Packets     uint64 `json:"packets"`
+}
+
+// InterfaceStatus is the state of an interface cerberus attached to, or
+// failed to attach to
+type InterfaceStatus struct {
+	Name          string     `json:"name"`
+	Index         int        `json:"index"`
+	MAC           string     `json:"mac,omitempty"`
+	MTU           int        `json:"mtu,omitempty"`
+	LinkUp        bool       `json:"link_up"`
+	Attached      bool       `json:"attached"`
+	Mode          string     `json:"mode,omitempty"`         // tcx, xdp-generic or xdp-native
+	AttachError   string     `json:"attach_error,omitempty"` // Why the latest attach failed
+	Events        uint64     `json:"events"`
+	LastEvent     *time.Time `json:"last_event,omitempty"`
+	Degraded      bool       `json:"degraded"` // Silent while the link is up
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+	Reattaches    int        `json:"reattaches"`
+	ReattachError string     `json:"reattach_error,omitempty"`
+
+	// Link speed in Mb/s and duplex as the kernel reports them, omitted for
+	// virtual interfaces and links whose speed is unknown
+	SpeedMbps   int                   `json:"speed_mbps,omitempty"`
+	Duplex      string                `json:"duplex,omitempty"`
+	Packets     uint64                `json:"packets"`     // Packets of the events, including those counted in flow summaries
+	Bytes       uint64                `json:"bytes"`       // Their frame lengths, Ethernet headers included
+	Utilization *InterfaceUtilization `json:"utilization"` // Null without a link speed
+}
+
+// InterfaceUtilization is the share of the link speed an interface's traffic
+// used over recent windows, in percent. Both directions count against the
+// speed, so a busy full-duplex link can exceed 100. A window is null until
+// it was observed in full.
+type InterfaceUtilization struct {
+	Last10s    *float64   `json:"last_10s"`
+	Last1m     *float64   `json:"last_1m"`
+	Last5m     *float64   `json:"last_5m"`
+	AboveSince *time.Time `json:"above_since,omitempty"` // Above the alert threshold since
+}
+
+// InterfaceStats is the traffic of one interface in the stats report
+type InterfaceStats struct {
+	Name        string                `json:"name"`
+	SpeedMbps   int                   `json:"speed_mbps,omitempty"`
+	Duplex      string                `json:"duplex,omitempty"`
+	Packets     uint64                `json:"packets"`
+	Bytes       uint64                `json:"bytes"`
+	Utilization *InterfaceUtilization `json:"utilization"`
+}
+
+// InternStats describes the shared table of L7 strings
+type InternStats struct {
+	Entries    int    `json:"entries"`     // Distinct strings stored once
+	Bytes      uint64 `json:"bytes"`       // Their total length
+	Shared     uint64 `json:"shared"`      // Times a stored copy was reused
+	SavedBytes uint64 `json:"saved_bytes"` // String bytes not duplicated thanks to reuse
+}
+
+// ResourceUsage is a sample of cerberus's own resource consumption
+type ResourceUsage struct {
+	RSSBytes      uint64    `json:"rss_bytes"`
+	Goroutines    int       `json:"goroutines"`
+	OpenFDs       int       `json:"open_fds"`
+	DBBytes       int64     `json:"db_bytes"`
+	CachedDevices int       `json:"cached_devices"`
+	SeenPatterns  int       `json:"seen_patterns"`
+	DefensiveMode bool      `json:"defensive_mode"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// SearchMatch is a single device field matching a search query
+type SearchMatch struct {
+	DeviceID string `json:"device_id"`
+	Field    string `json:"field"`
+	Value    string `json:"value"`
+}
+
+// SearchGroup holds the matches of one result type, capped with a total count
+type SearchGroup struct {
+	Type    string        `json:"type"`
+	Total   int           `json:"total"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// SearchResults groups the matches of a search query by result type
+type SearchResults struct {
+	Query  string        `json:"query"`
+	Groups []SearchGroup `json:"groups"`
+}
+
+// DeviceContact is how often and when a device reached a destination IP,
+// domain or destination port
+type DeviceContact struct {
+	DeviceID  string    `json:"device_id"`
+	IP        string    `json:"ip,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Domain    string    `json:"domain,omitempty"` // Subdomain queries: the name that matched
+	Via       []string  `json:"via,omitempty"`    // Domains: dns, http or tls; destinations and ports: TCP or UDP
+	Ports     []uint16  `json:"ports,omitempty"`  // Destinations only, the first few ports reached
+}
+
+// ContactQuery lists the devices that reached a destination IP, domain or
+// destination port, most recent first
+type ContactQuery struct {
+	Kind     string          `json:"kind"`
+	Value    string          `json:"value"`
+	Since    *time.Time      `json:"since,omitempty"`
+	Devices  int             `json:"devices"`
+	Contacts []DeviceContact `json:"contacts"`
+}
+
+// UplinkSignal is one signal of the uplink health score
+type UplinkSignal struct {
+	Name    string  `json:"name"`
+	Value   float64 `json:"value"`   // Failure ratio, or median round trip in milliseconds for latency
+	Penalty float64 `json:"penalty"` // Points taken off the score
+	Detail  string  `json:"detail,omitempty"`
+}
+
+// UplinkPoint is the uplink health score of one interval
+type UplinkPoint struct {
+	Time    time.Time      `json:"time"`
+	Score   int            `json:"score"` // 0-100, 100 when healthy
+	Signals []UplinkSignal `json:"signals,omitempty"`
+}
+
+// UplinkHealth is the passive uplink health estimate
+type UplinkHealth struct {
+	Interval string        `json:"interval"`
+	Latest   *UplinkPoint  `json:"latest,omitempty"` // Absent until external traffic was scored
+	History  []UplinkPoint `json:"history"`          // Last 24 hours, signals omitted
 }
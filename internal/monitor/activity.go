@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"math"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// activityHalfLife is how long it takes for old activity to count half as much
+const activityHalfLife = 14 * 24 * time.Hour
+
+// activityTypicalFraction is the share of the busiest slot a slot needs to be typical
+const activityTypicalFraction = 0.1
+
+// activityLearningPeriod is the history needed before typical hours are trusted
+const activityLearningPeriod = 7 * 24 * time.Hour
+
+// activityActiveWindow is how recently a device must be seen to count as active now
+const activityActiveWindow = 5 * time.Minute
+
+// activityDecay returns the factor a count decays by over elapsed
+func activityDecay(elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(elapsed) / float64(activityHalfLife))
+}
+
+// recordActivity adds one packet to the device's activity histogram
+func recordActivity(device *models.DeviceInfo, now time.Time) {
+	if device.Activity == nil {
+		device.Activity = &models.ActivityHistogram{Since: now}
+	}
+
+	local := now.Local()
+	day, hour := int(local.Weekday()), local.Hour()
+	h := device.Activity
+
+	h.Counts[day][hour] = h.Counts[day][hour]*activityDecay(now.Sub(time.Unix(h.Updated[day][hour], 0))) + 1
+	h.Updated[day][hour] = now.Unix()
+}
+
+// decayedActivity returns the histogram counts decayed to now
+func decayedActivity(h *models.ActivityHistogram, now time.Time) [7][24]float64 {
+	var matrix [7][24]float64
+	for day := range h.Counts {
+		for hour := range h.Counts[day] {
+			elapsed := now.Sub(time.Unix(h.Updated[day][hour], 0))
+			matrix[day][hour] = h.Counts[day][hour] * activityDecay(elapsed)
+		}
+	}
+	return matrix
+}
+
+// mergeActivity folds the histogram of src into dst
+func mergeActivity(dst, src *models.DeviceInfo) {
+	if src.Activity == nil {
+		return
+	}
+	if dst.Activity == nil {
+		activity := *src.Activity
+		dst.Activity = &activity
+		return
+	}
+
+	now := time.Now()
+	srcMatrix := decayedActivity(src.Activity, now)
+	dstMatrix := decayedActivity(dst.Activity, now)
+	for day := range dstMatrix {
+		for hour := range dstMatrix[day] {
+			dst.Activity.Counts[day][hour] = dstMatrix[day][hour] + srcMatrix[day][hour]
+			dst.Activity.Updated[day][hour] = now.Unix()
+		}
+	}
+	if src.Activity.Since.Before(dst.Activity.Since) {
+		dst.Activity.Since = src.Activity.Since
+	}
+}
+
+// AnalyzeActivity derives a device's typical hours from its activity histogram.
+// A slot is typical when it holds at least activityTypicalFraction of the
+// busiest slot's traffic; the same rule applied to hour-of-day totals gives
+// TypicalHours.
+func AnalyzeActivity(device *models.DeviceInfo, now time.Time) *models.DeviceActivity {
+	activity := &models.DeviceActivity{
+		ID:           device.ID,
+		TypicalHours: []int{},
+		Timestamp:    now,
+		ActiveNow:    now.Sub(device.LastSeen) <= activityActiveWindow,
+	}
+	for day := range activity.TypicalSlots {
+		activity.TypicalSlots[day] = []int{}
+	}
+
+	if device.Activity == nil {
+		activity.Learning = true
+		return activity
+	}
+
+	activity.Since = device.Activity.Since
+	activity.Learning = now.Sub(device.Activity.Since) < activityLearningPeriod
+	activity.Matrix = decayedActivity(device.Activity, now)
+
+	var hourTotals [24]float64
+	maxSlot, maxHour := 0.0, 0.0
+	for day := range activity.Matrix {
+		for hour, count := range activity.Matrix[day] {
+			hourTotals[hour] += count
+			maxSlot = max(maxSlot, count)
+		}
+	}
+	for _, total := range hourTotals {
+		maxHour = max(maxHour, total)
+	}
+	if maxSlot == 0 {
+		return activity
+	}
+
+	for day := range activity.Matrix {
+		for hour, count := range activity.Matrix[day] {
+			if count >= activityTypicalFraction*maxSlot {
+				activity.TypicalSlots[day] = append(activity.TypicalSlots[day], hour)
+			}
+		}
+	}
+	for hour, total := range hourTotals {
+		if total >= activityTypicalFraction*maxHour {
+			activity.TypicalHours = append(activity.TypicalHours, hour)
+		}
+	}
+
+	local := now.Local()
+	current := activity.Matrix[local.Weekday()][local.Hour()]
+	activity.OutsideTypicalHours = activity.ActiveNow && !activity.Learning &&
+		current < activityTypicalFraction*maxSlot
+
+	return activity
+}
+
+// ActiveOutsideTypicalHours reports whether a device is active right now in a
+// day-and-hour slot where it is normally quiet
+func ActiveOutsideTypicalHours(device *models.DeviceInfo, now time.Time) bool {
+	return AnalyzeActivity(device, now).OutsideTypicalHours
+}
+
+// DeviceActivity returns the activity heatmap of a tracked device
+func (nm *NetworkMonitor) DeviceActivity(id string) (*models.DeviceActivity, bool) {
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+	return AnalyzeActivity(device, time.Now()), true
+}
@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// officeWeek returns the packet times of a simulated week of a workstation:
+// 20 packets an hour from 9:00 to 17:00 Monday to Friday, a 3-packet backup
+// early on Sunday and a single stray packet on Saturday morning. Times are
+// local, as the histogram is.
+func officeWeek() []time.Time {
+	monday := time.Date(2026, 6, 1, 0, 0, 0, 0, time.Local)
+	var times []time.Time
+	for day := range 5 {
+		for hour := 9; hour < 17; hour++ {
+			for minute := range 20 {
+				times = append(times, monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour+time.Duration(minute)*time.Minute))
+			}
+		}
+	}
+	saturday, sunday := monday.AddDate(0, 0, 5), monday.AddDate(0, 0, 6)
+	times = append(times, saturday.Add(10*time.Hour+30*time.Minute))
+	for minute := range 3 {
+		times = append(times, sunday.Add(2*time.Hour+time.Duration(minute)*time.Minute))
+	}
+	return times
+}
+
+// A week of office traffic yields the decayed 7x24 matrix, office hours as
+// typical slots and hours, the weekly backup as a typical slot but not a
+// typical hour, and flags activity at night once the week is learned
+func TestAnalyzeActivityWeek(t *testing.T) {
+	times := officeWeek()
+	device := &models.DeviceInfo{ID: "02:00:00:00:00:0a"}
+	for _, at := range times {
+		recordActivity(device, at)
+	}
+	nextMonday := time.Date(2026, 6, 8, 0, 0, 0, 0, time.Local)
+	now := nextMonday.Add(10*time.Hour + 30*time.Minute)
+	device.LastSeen = now
+
+	activity := AnalyzeActivity(device, now)
+	if activity.Learning || !activity.ActiveNow || activity.OutsideTypicalHours {
+		t.Errorf("learning %v, active %v, outside %v, want false, true, false",
+			activity.Learning, activity.ActiveNow, activity.OutsideTypicalHours)
+	}
+	if !activity.Since.Equal(times[0]) {
+		t.Errorf("since %v, want %v", activity.Since, times[0])
+	}
+
+	// Each packet counts for its own decay since it was seen
+	var want [7][24]float64
+	for _, at := range times {
+		want[at.Weekday()][at.Hour()] += activityDecay(now.Sub(at))
+	}
+	for day := range want {
+		for hour := range want[day] {
+			if got := activity.Matrix[day][hour]; math.Abs(got-want[day][hour]) > 1e-3 {
+				t.Errorf("matrix[%s][%d] = %.4f, want %.4f", time.Weekday(day), hour, got, want[day][hour])
+			}
+		}
+	}
+	if monday, friday := activity.Matrix[time.Monday][9], activity.Matrix[time.Friday][9]; monday >= friday {
+		t.Errorf("Monday 9:00 = %.2f, want less than Friday's %.2f", monday, friday)
+	}
+
+	officeHours := []int{9, 10, 11, 12, 13, 14, 15, 16}
+	wantSlots := [7][]int{
+		time.Sunday:    {2},
+		time.Monday:    officeHours,
+		time.Tuesday:   officeHours,
+		time.Wednesday: officeHours,
+		time.Thursday:  officeHours,
+		time.Friday:    officeHours,
+		time.Saturday:  {},
+	}
+	for day := range wantSlots {
+		if !slices.Equal(activity.TypicalSlots[day], wantSlots[day]) {
+			t.Errorf("%s typical slots = %v, want %v", time.Weekday(day), activity.TypicalSlots[day], wantSlots[day])
+		}
+	}
+	if !slices.Equal(activity.TypicalHours, officeHours) {
+		t.Errorf("typical hours = %v, want %v", activity.TypicalHours, officeHours)
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		lastSeen time.Duration // Before now
+		want     bool
+	}{
+		{"office hours", now, 0, false},
+		{"Monday night", nextMonday.Add(23*time.Hour + 30*time.Minute), 0, true},
+		{"Sunday backup", nextMonday.AddDate(0, 0, 6).Add(2*time.Hour + 10*time.Minute), time.Minute, false},
+		{"Saturday stray", nextMonday.AddDate(0, 0, 5).Add(10*time.Hour + 45*time.Minute), 0, true},
+		{"quiet at night", nextMonday.Add(23*time.Hour + 30*time.Minute), time.Hour, false},
+		{"still learning", times[0].AddDate(0, 0, 6).Add(14 * time.Hour), 0, false},
+	}
+	for _, tt := range tests {
+		device.LastSeen = tt.now.Add(-tt.lastSeen)
+		if got := ActiveOutsideTypicalHours(device, tt.now); got != tt.want {
+			t.Errorf("%s: ActiveOutsideTypicalHours = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Without a histogram a device is learning with no typical hours
+func TestAnalyzeActivityEmpty(t *testing.T) {
+	now := time.Now()
+	activity := AnalyzeActivity(&models.DeviceInfo{ID: "02:00:00:00:00:0a", LastSeen: now}, now)
+	if !activity.Learning || !activity.ActiveNow || activity.OutsideTypicalHours || len(activity.TypicalHours) != 0 {
+		t.Errorf("activity = %+v, want learning and active with no typical hours", activity)
+	}
+}
@@ -0,0 +1,270 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/network"
+)
+
+// ErrNotLocalSubnet is returned for free addresses outside the local subnets
+var ErrNotLocalSubnet = errors.New("not within a local subnet")
+
+// FreeAddressConfig controls which addresses are suggested as free
+type FreeAddressConfig struct {
+	Lookback time.Duration  // Addresses used within it aren't free
+	Reserved []AddressRange // Never suggested, e.g. DHCP pools
+}
+
+// DefaultFreeAddressConfig returns the default free address settings
+func DefaultFreeAddressConfig() FreeAddressConfig {
+	return FreeAddressConfig{Lookback: 30 * 24 * time.Hour}
+}
+
+// AddressRange is an inclusive range of IPv4 addresses
+type AddressRange struct {
+	First, Last uint32
+}
+
+// ParseAddressRanges parses a comma-separated list of IPv4 ranges
+// ("10.0.0.100-10.0.0.199"), CIDRs and addresses
+func ParseAddressRanges(list string) ([]AddressRange, error) {
+	var ranges []AddressRange
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, "/") {
+			_, ipnet, err := net.ParseCIDR(item)
+			if err != nil || ipnet.IP.To4() == nil {
+				return nil, fmt.Errorf("invalid CIDR %q", item)
+			}
+			first, last := networkBounds(ipnet)
+			ranges = append(ranges, AddressRange{First: first, Last: last})
+			continue
+		}
+
+		from, to, isRange := strings.Cut(item, "-")
+		if !isRange {
+			to = from
+		}
+		first, ok1 := ipv4Uint(net.ParseIP(strings.TrimSpace(from)))
+		last, ok2 := ipv4Uint(net.ParseIP(strings.TrimSpace(to)))
+		if !ok1 || !ok2 || last < first {
+			return nil, fmt.Errorf("invalid address range %q", item)
+		}
+		ranges = append(ranges, AddressRange{First: first, Last: last})
+	}
+	return ranges, nil
+}
+
+func (r AddressRange) contains(addr uint32) bool {
+	return addr >= r.First && addr <= r.Last
+}
+
+// ipv4Uint returns an IPv4 address as a number
+func ipv4Uint(ip net.IP) (uint32, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(ip4), true
+}
+
+func uintIPv4(addr uint32) string {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, addr)
+	return ip.String()
+}
+
+// networkBounds returns the network and broadcast addresses of a subnet
+func networkBounds(subnet *net.IPNet) (uint32, uint32) {
+	first, _ := ipv4Uint(subnet.IP.Mask(subnet.Mask))
+	ones, bits := subnet.Mask.Size()
+	return first, first | uint32(uint64(1)<<(bits-ones)-1)
+}
+
+// addressIndex remembers when each address of the local subnets was last
+// used, so free addresses are found without scanning devices. It is guarded
+// by nm.mu.
+type addressIndex struct {
+	config FreeAddressConfig
+	seen   map[string]map[uint32]time.Time // Local subnet -> address -> last used
+}
+
+func newAddressIndex(config FreeAddressConfig) *addressIndex {
+	return &addressIndex{config: config, seen: make(map[string]map[uint32]time.Time)}
+}
+
+// SetFreeAddressConfig replaces the free address settings
+func (nm *NetworkMonitor) SetFreeAddressConfig(config FreeAddressConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.addresses.config = config
+}
+
+// observeAddress records the use of an address of a local subnet. Must hold
+// nm.mu.
+func (nm *NetworkMonitor) observeAddress(ip string, at time.Time) {
+	subnet := nm.subnetOf(ip)
+	if subnet == "" || subnet == OtherSubnet {
+		return
+	}
+	addr, ok := ipv4Uint(net.ParseIP(ip))
+	if !ok {
+		return
+	}
+	seen := nm.addresses.seen[subnet]
+	if seen == nil {
+		seen = make(map[uint32]time.Time)
+		nm.addresses.seen[subnet] = seen
+	}
+	if at.After(seen[addr]) {
+		seen[addr] = at
+	}
+}
+
+// loadAddresses fills the address index from the addresses persisted devices
+// held
+func (nm *NetworkMonitor) loadAddresses() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return ascendDevices(tx, func(key, value string) bool {
+			var device struct {
+				IP        string           `json:"ip"`
+				LastSeen  time.Time        `json:"last_seen"`
+				IPHistory []models.IPLease `json:"ip_history"`
+			}
+			if json.Unmarshal([]byte(value), &device) != nil {
+				return true
+			}
+			nm.observeAddress(device.IP, device.LastSeen)
+			for _, lease := range device.IPHistory {
+				nm.observeAddress(lease.IP, lease.LastSeen)
+			}
+			return true
+		})
+	})
+}
+
+// FreeAddresses suggests up to limit addresses of subnet, which must lie
+// within a local subnet, that cerberus hasn't seen used within lookback (the
+// configured one if 0). Addresses never seen come first, then those silent
+// the longest. Network, broadcast and gateway addresses, the host's own,
+// reserved ranges and addresses in the kernel neighbor table are left out.
+func (nm *NetworkMonitor) FreeAddresses(subnet *net.IPNet, lookback time.Duration, limit int) (models.FreeAddresses, error) {
+	now := time.Now()
+	first, last := networkBounds(subnet)
+
+	nm.mu.RLock()
+	if lookback <= 0 {
+		lookback = nm.addresses.config.Lookback
+	}
+	reserved := nm.addresses.config.Reserved
+	var local *net.IPNet
+	for _, candidate := range nm.localSubnets() {
+		lo, hi := networkBounds(candidate)
+		if first >= lo && last <= hi && (local == nil || maskLength(candidate) > maskLength(local)) {
+			local = candidate
+		}
+	}
+	seen := make(map[uint32]time.Time)
+	if local != nil {
+		for addr, at := range nm.addresses.seen[local.String()] {
+			if addr >= first && addr <= last {
+				seen[addr] = at
+			}
+		}
+	}
+	excluded := make(map[uint32]bool)
+	gateways := []net.IP{nm.topology.DefaultGateway}
+	for _, info := range nm.topology.Interfaces {
+		gateways = append(gateways, info.Gateway)
+	}
+	nm.mu.RUnlock()
+
+	if local == nil {
+		return models.FreeAddresses{}, fmt.Errorf("%w: %s", ErrNotLocalSubnet, subnet)
+	}
+
+	lo, hi := networkBounds(local)
+	if hi-lo > 1 { // /31 and /32 have no network and broadcast addresses
+		excluded[lo], excluded[hi] = true, true
+	}
+	for _, gateway := range gateways {
+		if addr, ok := ipv4Uint(gateway); ok {
+			excluded[addr] = true
+		}
+	}
+	_, selfIPs := nm.SelfAddresses()
+	neighbors, _ := network.NeighborIPs() // Not available off Linux
+	for _, ip := range append(selfIPs, neighbors...) {
+		if addr, ok := ipv4Uint(net.ParseIP(ip)); ok {
+			excluded[addr] = true
+		}
+	}
+
+	since := now.Add(-lookback)
+	result := models.FreeAddresses{
+		Subnet:    subnet.String(),
+		Lookback:  lookback.String(),
+		Since:     since,
+		Note:      models.FreeAddressNote,
+		Addresses: []models.FreeAddress{},
+	}
+	free := func(addr uint32) bool {
+		if excluded[addr] {
+			return false
+		}
+		for _, r := range reserved {
+			if r.contains(addr) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Never seen addresses, lowest first
+	for addr := uint64(first); addr <= uint64(last); addr++ {
+		if _, used := seen[uint32(addr)]; used || !free(uint32(addr)) {
+			continue
+		}
+		if len(result.Addresses) == limit {
+			result.Truncated = true
+			return result, nil
+		}
+		result.Addresses = append(result.Addresses, models.FreeAddress{IP: uintIPv4(uint32(addr))})
+	}
+
+	// Then those silent for longer than the lookback, longest first
+	var silent []uint32
+	for addr, at := range seen {
+		if at.Before(since) && free(addr) {
+			silent = append(silent, addr)
+		}
+	}
+	sort.Slice(silent, func(i, j int) bool {
+		if !seen[silent[i]].Equal(seen[silent[j]]) {
+			return seen[silent[i]].Before(seen[silent[j]])
+		}
+		return silent[i] < silent[j]
+	})
+	for _, addr := range silent {
+		if len(result.Addresses) == limit {
+			result.Truncated = true
+			break
+		}
+		lastSeen := seen[addr]
+		result.Addresses = append(result.Addresses, models.FreeAddress{IP: uintIPv4(addr), LastSeen: &lastSeen})
+	}
+	return result, nil
+}
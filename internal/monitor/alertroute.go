@@ -0,0 +1,462 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// AlertRouteKeyPrefix prefixes persisted alert routes in the database.
+const AlertRouteKeyPrefix = "route:"
+
+// AlertDeviceOffline is the event class of anomalies reporting a critical
+// device unavailable, see Availability
+const AlertDeviceOffline = "device_offline"
+
+// AlertEventClasses lists the event classes alert routes match. An anomaly
+// is of class anomaly, and also device_offline for DEVICE_UNAVAILABLE; every
+// other event is of its EventSink record type.
+var AlertEventClasses = []string{
+	SinkAnomaly, AlertDeviceOffline, SinkNewDevice, SinkDeviceChange,
+	SinkDeviceIPChanged, SinkPattern, SinkPatternSummary,
+}
+
+// ErrAlertRouteNotFound is returned for an unknown alert route
+var ErrAlertRouteNotFound = errors.New("alert route not found")
+
+// AlertDestination delivers the events alert routes send to it, such as a
+// webhook or a syslog server. Deliver must not wait on the destination itself;
+// done, when not nil, is called once the event was delivered or failed to be.
+type AlertDestination interface {
+	Deliver(recordType string, data any, done func(error))
+}
+
+// alertRoute is an alert route with its delivery counters
+type alertRoute struct {
+	models.AlertRoute
+	seq   uint64 // Creation order
+	stats models.AlertRouteStats
+}
+
+// alertRouter holds the alert routes and destinations. Its lock is taken
+// last; destinations are called without it.
+type alertRouter struct {
+	mu           sync.Mutex
+	destinations map[string]AlertDestination
+	routes       map[string]*alertRoute
+	seq          uint64
+}
+
+func newAlertRouter() *alertRouter {
+	return &alertRouter{
+		destinations: make(map[string]AlertDestination),
+		routes:       make(map[string]*alertRoute),
+	}
+}
+
+// matches reports whether a route matches an event of the given classes,
+// anomaly severity and type, from a device with the given tags
+func (r *alertRoute) matches(classes []string, severity, anomalyType string, tags []string) bool {
+	if len(r.Events) > 0 && !slices.ContainsFunc(r.Events, func(e string) bool { return slices.Contains(classes, e) }) {
+		return false
+	}
+	if r.MinSeverity != "" && (severity == "" || models.SeverityRank(severity) < models.SeverityRank(r.MinSeverity)) {
+		return false
+	}
+	if len(r.AnomalyTypes) > 0 && !slices.Contains(r.AnomalyTypes, anomalyType) {
+		return false
+	}
+	for _, tag := range r.Tags {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetAlertDestinations sets the destinations alert routes can send events
+// to, by name. Must be called before capture starts.
+func (nm *NetworkMonitor) SetAlertDestinations(destinations map[string]AlertDestination) {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	nm.alerts.destinations = make(map[string]AlertDestination, len(destinations))
+	for name, destination := range destinations {
+		nm.alerts.destinations[name] = destination
+	}
+}
+
+// AlertDestinations returns the names of the alert destinations, sorted
+func (nm *NetworkMonitor) AlertDestinations() []string {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	names := make([]string, 0, len(nm.alerts.destinations))
+	for name := range nm.alerts.destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// normalizeAlertRoute validates a route. Must hold nm.alerts.mu, as its
+// destinations must exist.
+func (nm *NetworkMonitor) normalizeAlertRoute(route models.AlertRoute) (models.AlertRoute, error) {
+	route.Name = strings.TrimSpace(route.Name)
+	route.MinSeverity = strings.ToUpper(strings.TrimSpace(route.MinSeverity))
+	if route.MinSeverity != "" && models.SeverityRank(route.MinSeverity) < 0 {
+		return models.AlertRoute{}, fmt.Errorf("unknown severity %q", route.MinSeverity)
+	}
+	for i, event := range route.Events {
+		route.Events[i] = strings.ToLower(strings.TrimSpace(event))
+		if !slices.Contains(AlertEventClasses, route.Events[i]) {
+			return models.AlertRoute{}, fmt.Errorf("unknown event class %q: expected one of %s", event, strings.Join(AlertEventClasses, ", "))
+		}
+	}
+	for i, anomalyType := range route.AnomalyTypes {
+		route.AnomalyTypes[i] = strings.ToUpper(strings.TrimSpace(anomalyType))
+	}
+	for i, tag := range route.Tags {
+		route.Tags[i] = strings.ToLower(strings.TrimSpace(tag))
+		if route.Tags[i] == "" {
+			return models.AlertRoute{}, fmt.Errorf("tags can't be empty")
+		}
+	}
+	if len(route.Destinations) == 0 {
+		return models.AlertRoute{}, fmt.Errorf("a route needs at least one destination")
+	}
+	for _, destination := range route.Destinations {
+		if _, ok := nm.alerts.destinations[destination]; !ok {
+			return models.AlertRoute{}, fmt.Errorf("unknown destination %q", destination)
+		}
+	}
+	route.Stats = nil
+	return route, nil
+}
+
+// alertRouteSeq parses the sequence number out of an "r-<n>" ID
+func alertRouteSeq(id string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(id, "r-"), 10, 64)
+	return n
+}
+
+// loadAlertRoutes reads the persisted alert routes. Routes naming a
+// destination that is no longer configured are kept; their deliveries to it
+// fail.
+func (nm *NetworkMonitor) loadAlertRoutes() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", AlertRouteKeyPrefix, AlertRouteKeyPrefix+"~", func(key, value string) bool {
+			var route models.AlertRoute
+			if json.Unmarshal([]byte(value), &route) != nil {
+				return true
+			}
+			seq := alertRouteSeq(route.ID)
+			nm.alerts.routes[route.ID] = &alertRoute{
+				AlertRoute: route,
+				seq:        seq,
+				stats:      models.AlertRouteStats{RouteID: route.ID, Name: route.Name},
+			}
+			nm.alerts.seq = max(nm.alerts.seq, seq)
+			return true
+		})
+	})
+}
+
+// persistAlertRoute writes a route. Must hold nm.alerts.mu.
+func (nm *NetworkMonitor) persistAlertRoute(route models.AlertRoute) error {
+	data, _ := json.Marshal(route)
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(AlertRouteKeyPrefix+route.ID, string(data), nil)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to persist alert route: %w", err)
+	}
+	return nil
+}
+
+// AddAlertRoute validates, persists and activates an alert route. Its ID,
+// creation time and stats are ignored.
+func (nm *NetworkMonitor) AddAlertRoute(route models.AlertRoute) (models.AlertRoute, error) {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	route, err := nm.normalizeAlertRoute(route)
+	if err != nil {
+		return models.AlertRoute{}, err
+	}
+	seq := nm.alerts.seq + 1
+	route.ID = fmt.Sprintf("r-%d", seq)
+	route.CreatedAt = time.Now()
+	if err := nm.persistAlertRoute(route); err != nil {
+		return models.AlertRoute{}, err
+	}
+
+	nm.alerts.seq = seq
+	nm.alerts.routes[route.ID] = &alertRoute{
+		AlertRoute: route,
+		seq:        seq,
+		stats:      models.AlertRouteStats{RouteID: route.ID, Name: route.Name},
+	}
+	return route, nil
+}
+
+// UpdateAlertRoute replaces the criteria and destinations of an alert route,
+// keeping its ID, creation time and stats
+func (nm *NetworkMonitor) UpdateAlertRoute(id string, route models.AlertRoute) (models.AlertRoute, error) {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	existing, ok := nm.alerts.routes[id]
+	if !ok {
+		return models.AlertRoute{}, ErrAlertRouteNotFound
+	}
+	route, err := nm.normalizeAlertRoute(route)
+	if err != nil {
+		return models.AlertRoute{}, err
+	}
+	route.ID, route.CreatedAt = existing.ID, existing.CreatedAt
+	if err := nm.persistAlertRoute(route); err != nil {
+		return models.AlertRoute{}, err
+	}
+
+	existing.AlertRoute = route
+	existing.stats.Name = route.Name
+	return route, nil
+}
+
+// DeleteAlertRoute removes an alert route
+func (nm *NetworkMonitor) DeleteAlertRoute(id string) error {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	if _, ok := nm.alerts.routes[id]; !ok {
+		return ErrAlertRouteNotFound
+	}
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(AlertRouteKeyPrefix + id)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete alert route: %w", err)
+	}
+	delete(nm.alerts.routes, id)
+	return nil
+}
+
+// sortedRoutes returns the routes oldest first. Must hold nm.alerts.mu.
+func (nm *NetworkMonitor) sortedRoutes() []*alertRoute {
+	routes := make([]*alertRoute, 0, len(nm.alerts.routes))
+	for _, route := range nm.alerts.routes {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].seq < routes[j].seq })
+	return routes
+}
+
+// AlertRoutes returns the alert routes with their stats, oldest first
+func (nm *NetworkMonitor) AlertRoutes() []models.AlertRoute {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	routes := make([]models.AlertRoute, 0, len(nm.alerts.routes))
+	for _, route := range nm.sortedRoutes() {
+		stats := route.stats
+		listed := route.AlertRoute
+		listed.Stats = &stats
+		routes = append(routes, listed)
+	}
+	return routes
+}
+
+// AlertRouteStats returns the deliveries of every alert route, oldest first
+func (nm *NetworkMonitor) AlertRouteStats() []models.AlertRouteStats {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	stats := make([]models.AlertRouteStats, 0, len(nm.alerts.routes))
+	for _, route := range nm.sortedRoutes() {
+		stats = append(stats, route.stats)
+	}
+	return stats
+}
+
+// DeviceTags returns the tags alert routes can require of a device: its
+// vendor, type, OS, subnet, interface, owner and location as key:value, and
+// critical, transient, self and routed when they apply. Tags are lowercase.
+func DeviceTags(device *models.DeviceInfo) []string {
+	var tags []string
+	add := func(key, value string) {
+		if value != "" {
+			tags = append(tags, strings.ToLower(key+":"+value))
+		}
+	}
+	add("vendor", device.Vendor)
+	if device.DeviceType != nil {
+		add("type", device.DeviceType.Type)
+	}
+	if device.OSGuess != nil {
+		add("os", device.OSGuess.OS)
+	}
+	add("subnet", device.Subnet)
+	add("interface", device.Interface)
+	add("owner", device.Owner)
+	add("location", device.Location)
+	for flag, set := range map[string]bool{
+		"critical":  device.Critical,
+		"transient": device.Transient,
+		"self":      device.Self,
+		"routed":    device.Routed,
+	} {
+		if set {
+			tags = append(tags, flag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// alertEvent describes a notified event for matching: its classes, anomaly
+// severity and type, and its device, by ID unless the event is the device
+func alertEvent(recordType string, data any) (classes []string, severity, anomalyType, deviceID string, device *models.DeviceInfo) {
+	classes = []string{recordType}
+	switch v := data.(type) {
+	case *models.Anomaly:
+		severity, anomalyType, deviceID = v.Severity, v.Type, v.DeviceID
+		if v.Type == "DEVICE_UNAVAILABLE" {
+			classes = append(classes, AlertDeviceOffline)
+		}
+	case *models.DeviceInfo:
+		device = v
+	case *models.DeviceUpdate:
+		deviceID = v.DeviceID
+	case *models.IPChange:
+		deviceID = v.DeviceID
+	case *models.CommunicationPattern:
+		deviceID = v.DeviceID
+	case *models.PatternSummary:
+		deviceID = v.DeviceID
+	default:
+		return nil, "", "", "", nil
+	}
+	return classes, severity, anomalyType, deviceID, device
+}
+
+// matchAlertRoutes finds the routes an event fires: every matching route,
+// or the matching default routes if no other matched. With count, the
+// matches are counted in the route stats. It returns the route IDs per
+// destination name.
+func (nm *NetworkMonitor) matchAlertRoutes(recordType string, data any, count bool) (models.AlertRouteMatch, map[string][]string) {
+	match := models.AlertRouteMatch{Classes: []string{}, Tags: []string{}, Routes: []models.AlertRoute{}, Destinations: []string{}}
+	classes, severity, anomalyType, deviceID, device := alertEvent(recordType, data)
+	if classes == nil {
+		return match, nil
+	}
+	match.Classes = classes
+
+	nm.alerts.mu.Lock()
+	empty := len(nm.alerts.routes) == 0
+	nm.alerts.mu.Unlock()
+	if empty && count {
+		return match, nil
+	}
+
+	if device == nil && deviceID != "" {
+		device, _ = nm.GetDevice(deviceID)
+	}
+	if device != nil {
+		match.Tags = DeviceTags(device)
+	}
+
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	var fired []*alertRoute
+	routes := nm.sortedRoutes()
+	for _, isDefault := range []bool{false, true} {
+		for _, route := range routes {
+			if route.Default == isDefault && route.matches(classes, severity, anomalyType, match.Tags) {
+				fired = append(fired, route)
+			}
+		}
+		if len(fired) > 0 {
+			match.Default = isDefault
+			break
+		}
+	}
+
+	now := time.Now()
+	byDestination := make(map[string][]string)
+	for _, route := range fired {
+		match.Routes = append(match.Routes, route.AlertRoute)
+		if count {
+			route.stats.Matched++
+			route.stats.LastMatched = &now
+		}
+		for _, destination := range route.Destinations {
+			if _, ok := byDestination[destination]; !ok {
+				match.Destinations = append(match.Destinations, destination)
+			}
+			byDestination[destination] = append(byDestination[destination], route.ID)
+		}
+	}
+	return match, byDestination
+}
+
+// MatchAlertRoutes reports which alert routes an event would fire, without
+// delivering it or counting it in their stats
+func (nm *NetworkMonitor) MatchAlertRoutes(recordType string, data any) models.AlertRouteMatch {
+	match, _ := nm.matchAlertRoutes(recordType, data, false)
+	return match
+}
+
+// routeAlert delivers a notified event to the destinations of the routes it
+// fires, once per destination
+func (nm *NetworkMonitor) routeAlert(recordType string, data any) {
+	_, byDestination := nm.matchAlertRoutes(recordType, data, true)
+	for name, routeIDs := range byDestination {
+		nm.alerts.mu.Lock()
+		destination, ok := nm.alerts.destinations[name]
+		nm.alerts.mu.Unlock()
+
+		if !ok {
+			nm.countAlertDelivery(routeIDs, fmt.Errorf("unknown destination %q", name))
+			continue
+		}
+		destination.Deliver(recordType, data, func(err error) {
+			nm.countAlertDelivery(routeIDs, err)
+		})
+	}
+}
+
+// countAlertDelivery counts a delivery, or a failed one, for each route that
+// sent the event
+func (nm *NetworkMonitor) countAlertDelivery(routeIDs []string, err error) {
+	nm.alerts.mu.Lock()
+	defer nm.alerts.mu.Unlock()
+
+	for _, id := range routeIDs {
+		route, ok := nm.alerts.routes[id]
+		if !ok {
+			continue // Deleted meanwhile
+		}
+		if err != nil {
+			route.stats.Failed++
+			route.stats.LastError = err.Error()
+		} else {
+			route.stats.Delivered++
+		}
+	}
+}
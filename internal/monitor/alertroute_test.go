@@ -0,0 +1,241 @@
+package monitor
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// fakeDestination records the events delivered to it and fails them with err
+type fakeDestination struct {
+	delivered []string
+	err       error
+}
+
+func (d *fakeDestination) Deliver(recordType string, data any, done func(error)) {
+	d.delivered = append(d.delivered, recordType)
+	if done != nil {
+		done(d.err)
+	}
+}
+
+// alertTestDestinations are the destinations of the alert route tests
+func alertTestDestinations() map[string]*fakeDestination {
+	return map[string]*fakeDestination{
+		"phone":    {},
+		"security": {},
+		"low":      {},
+		"network":  {},
+		"catchall": {},
+	}
+}
+
+// setAlertDestinations configures the fake destinations on a monitor
+func setAlertDestinations(nm *NetworkMonitor, destinations map[string]*fakeDestination) {
+	configured := make(map[string]AlertDestination, len(destinations))
+	for name, destination := range destinations {
+		configured[name] = destination
+	}
+	nm.SetAlertDestinations(configured)
+}
+
+// addAlertRoutes adds routes in order, failing the test on any error
+func addAlertRoutes(t *testing.T, nm *NetworkMonitor, routes ...models.AlertRoute) {
+	t.Helper()
+	for _, route := range routes {
+		if _, err := nm.AddAlertRoute(route); err != nil {
+			t.Fatalf("adding %s: %v", route.Name, err)
+		}
+	}
+}
+
+// routeNames lists the names of the routes an event fires
+func routeNames(match models.AlertRouteMatch) []string {
+	var names []string
+	for _, route := range match.Routes {
+		names = append(names, route.Name)
+	}
+	return names
+}
+
+// Every matching route fires, overlapping or not; tag filters require all
+// their tags of the device; default routes fire only when nothing else did
+func TestMatchAlertRoutes(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	setAlertDestinations(nm, alertTestDestinations())
+	addAlertRoutes(t, nm,
+		models.AlertRoute{Name: "urgent", Events: []string{"anomaly"}, MinSeverity: "high", Destinations: []string{"phone"}},
+		models.AlertRoute{Name: "anomalies", Events: []string{"anomaly"}, Destinations: []string{"security"}},
+		models.AlertRoute{Name: "new", Events: []string{"new_device"}, Destinations: []string{"low"}},
+		models.AlertRoute{Name: "critical new", Events: []string{"new_device"}, Tags: []string{"critical", "owner:ops"}, Destinations: []string{"phone", "low"}},
+		models.AlertRoute{Name: "uplink", AnomalyTypes: []string{"uplink_degraded"}, Destinations: []string{"network"}},
+		models.AlertRoute{Name: "offline", Events: []string{"device_offline"}, Destinations: []string{"network"}},
+		models.AlertRoute{Name: "rest", Default: true, Destinations: []string{"catchall"}},
+	)
+
+	tests := []struct {
+		name         string
+		recordType   string
+		data         any
+		routes       []string
+		destinations []string
+		isDefault    bool
+	}{
+		{"high anomaly", SinkAnomaly, &models.Anomaly{Type: "PORT_SCAN", Severity: "HIGH"},
+			[]string{"urgent", "anomalies"}, []string{"phone", "security"}, false},
+		{"low anomaly", SinkAnomaly, &models.Anomaly{Type: "PORT_SCAN", Severity: "LOW"},
+			[]string{"anomalies"}, []string{"security"}, false},
+		{"uplink anomaly", SinkAnomaly, &models.Anomaly{Type: "UPLINK_DEGRADED", Severity: "MEDIUM"},
+			[]string{"anomalies", "uplink"}, []string{"security", "network"}, false},
+		{"device offline", SinkAnomaly, &models.Anomaly{Type: "DEVICE_UNAVAILABLE", Severity: "HIGH"},
+			[]string{"urgent", "anomalies", "offline"}, []string{"phone", "security", "network"}, false},
+		{"new device", SinkNewDevice, &models.DeviceInfo{ID: "02:00:00:00:00:0a", Owner: "ops"},
+			[]string{"new"}, []string{"low"}, false},
+		{"new critical device", SinkNewDevice, &models.DeviceInfo{ID: "02:00:00:00:00:0a", Owner: "Ops", Critical: true},
+			[]string{"new", "critical new"}, []string{"low", "phone"}, false},
+		{"new critical device of another owner", SinkNewDevice, &models.DeviceInfo{ID: "02:00:00:00:00:0a", Owner: "lab", Critical: true},
+			[]string{"new"}, []string{"low"}, false},
+		{"unmatched", SinkDeviceIPChanged, &models.IPChange{DeviceID: "02:00:00:00:00:0a"},
+			[]string{"rest"}, []string{"catchall"}, true},
+		{"not routed", "stats", struct{}{}, nil, []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match := nm.MatchAlertRoutes(tt.recordType, tt.data)
+			if !slices.Equal(routeNames(match), tt.routes) || !slices.Equal(match.Destinations, tt.destinations) || match.Default != tt.isDefault {
+				t.Errorf("fired %v to %v (default %v), want %v to %v (default %v)",
+					routeNames(match), match.Destinations, match.Default, tt.routes, tt.destinations, tt.isDefault)
+			}
+		})
+	}
+
+	// Anomalies are matched on the tags of their device, as tracked
+	mac := "02:00:00:00:00:0b"
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.11", "203.0.113.5", 443))
+	addAlertRoutes(t, nm, models.AlertRoute{Name: "lab", Tags: []string{"vendor:locally administered"}, Destinations: []string{"low"}})
+	match := nm.MatchAlertRoutes(SinkAnomaly, &models.Anomaly{DeviceID: mac, Type: "PORT_SCAN", Severity: "LOW"})
+	if !slices.Equal(routeNames(match), []string{"anomalies", "lab"}) || !slices.Contains(match.Tags, "vendor:locally administered") {
+		t.Errorf("anomaly of a tracked device fired %v with tags %v", routeNames(match), match.Tags)
+	}
+
+	// Without a default route an unmatched event goes nowhere
+	for _, route := range nm.AlertRoutes() {
+		if route.Default {
+			if err := nm.DeleteAlertRoute(route.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if match := nm.MatchAlertRoutes(SinkDeviceIPChanged, &models.IPChange{DeviceID: "02:00:00:00:00:0c"}); len(match.Routes) != 0 || match.Default {
+		t.Errorf("unmatched event fired %v", routeNames(match))
+	}
+}
+
+// Events are delivered once per destination however many routes send them
+// there, and each route counts its matches and deliveries
+func TestRouteAlert(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	destinations := alertTestDestinations()
+	destinations["network"].err = errors.New("connection refused")
+	setAlertDestinations(nm, destinations)
+	addAlertRoutes(t, nm,
+		models.AlertRoute{Name: "urgent", Events: []string{"anomaly"}, MinSeverity: "HIGH", Destinations: []string{"phone", "security"}},
+		models.AlertRoute{Name: "anomalies", Events: []string{"anomaly"}, Destinations: []string{"security"}},
+		models.AlertRoute{Name: "offline", Events: []string{"device_offline"}, Destinations: []string{"network"}},
+		models.AlertRoute{Name: "rest", Default: true, Destinations: []string{"catchall"}},
+	)
+
+	nm.routeAlert(SinkAnomaly, &models.Anomaly{Type: "PORT_SCAN", Severity: "HIGH"})
+	nm.routeAlert(SinkAnomaly, &models.Anomaly{Type: "PORT_SCAN", Severity: "LOW"})
+	nm.routeAlert(SinkAnomaly, &models.Anomaly{Type: "DEVICE_UNAVAILABLE", Severity: "MEDIUM"})
+	nm.routeAlert(SinkNewDevice, &models.DeviceInfo{ID: "02:00:00:00:00:0a"})
+	nm.MatchAlertRoutes(SinkNewDevice, &models.DeviceInfo{ID: "02:00:00:00:00:0a"}) // A dry run counts nothing
+
+	for name, want := range map[string]int{"phone": 1, "security": 3, "network": 1, "catchall": 1, "low": 0} {
+		if got := len(destinations[name].delivered); got != want {
+			t.Errorf("%s got %d events, want %d", name, got, want)
+		}
+	}
+	want := map[string]models.AlertRouteStats{
+		"urgent":    {Matched: 1, Delivered: 2},
+		"anomalies": {Matched: 3, Delivered: 3},
+		"offline":   {Matched: 1, Failed: 1, LastError: "connection refused"},
+		"rest":      {Matched: 1, Delivered: 1},
+	}
+	for _, stats := range nm.AlertRouteStats() {
+		w := want[stats.Name]
+		if stats.Matched != w.Matched || stats.Delivered != w.Delivered || stats.Failed != w.Failed || stats.LastError != w.LastError {
+			t.Errorf("%s stats = %+v, want %+v", stats.Name, stats, w)
+		}
+		if stats.LastMatched == nil {
+			t.Errorf("%s has no last match", stats.Name)
+		}
+	}
+}
+
+// Routes are validated, and persisted across restarts with their IDs
+func TestAlertRoutesPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setAlertDestinations(nm, alertTestDestinations())
+
+	for _, bad := range []models.AlertRoute{
+		{Name: "no destination"},
+		{Name: "unknown destination", Destinations: []string{"pager"}},
+		{Name: "unknown class", Events: []string{"report_ready"}, Destinations: []string{"low"}},
+		{Name: "unknown severity", MinSeverity: "urgent", Destinations: []string{"low"}},
+		{Name: "empty tag", Tags: []string{" "}, Destinations: []string{"low"}},
+	} {
+		if _, err := nm.AddAlertRoute(bad); err == nil {
+			t.Errorf("%s accepted", bad.Name)
+		}
+	}
+
+	first, err := nm.AddAlertRoute(models.AlertRoute{Name: "first", Events: []string{" New_Device "}, Tags: []string{"Critical"}, Destinations: []string{"low"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := nm.AddAlertRoute(models.AlertRoute{Name: "second", Destinations: []string{"catchall"}, Default: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(first.Events, []string{"new_device"}) || !slices.Equal(first.Tags, []string{"critical"}) {
+		t.Errorf("route not normalized: %+v", first)
+	}
+	updated, err := nm.UpdateAlertRoute(first.ID, models.AlertRoute{Name: "renamed", Destinations: []string{"phone"}})
+	if err != nil || updated.ID != first.ID || !updated.CreatedAt.Equal(first.CreatedAt) {
+		t.Errorf("UpdateAlertRoute = %+v, %v", updated, err)
+	}
+	if _, err := nm.UpdateAlertRoute("r-99", second); !errors.Is(err, ErrAlertRouteNotFound) {
+		t.Errorf("updating an unknown route: %v", err)
+	}
+	if err := nm.DeleteAlertRoute(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := nm.DeleteAlertRoute(second.ID); !errors.Is(err, ErrAlertRouteNotFound) {
+		t.Errorf("deleting twice: %v", err)
+	}
+	nm.Close()
+
+	nm, err = NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	routes := nm.AlertRoutes()
+	if len(routes) != 1 || routes[0].ID != first.ID || routes[0].Name != "renamed" || !reflect.DeepEqual(routes[0].Destinations, []string{"phone"}) {
+		t.Fatalf("routes after restart = %+v", routes)
+	}
+	setAlertDestinations(nm, alertTestDestinations())
+	third, err := nm.AddAlertRoute(models.AlertRoute{Name: "third", Destinations: []string{"low"}})
+	if err != nil || third.ID == first.ID {
+		t.Errorf("new route after restart got ID %q (%v)", third.ID, err)
+	}
+}
@@ -0,0 +1,178 @@
+package monitor
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/messages"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// maxRecentAnomalies bounds the in-memory anomaly history
+const maxRecentAnomalies = 1000
+
+var anomalySeq atomic.Uint64
+
+// Messages returns the templates anomalies are described with
+func (nm *NetworkMonitor) Messages() *messages.Catalog {
+	if catalog := nm.catalog.Load(); catalog != nil {
+		return catalog
+	}
+	return messages.Default()
+}
+
+// SetMessages replaces the templates anomalies raised from now on are
+// described with, such as a translation loaded with messages.Load
+func (nm *NetworkMonitor) SetMessages(catalog *messages.Catalog) {
+	nm.catalog.Store(catalog)
+}
+
+// raiseAnomaly records an anomaly and queues it for notification. Its
+// description is rendered from the template of its type with params, which
+// the anomaly keeps; the device and severity are added to them. Anomalies of
+// muted devices, and of the monitoring host unless configured otherwise, are
+// dropped, returning nil. It is safe to call while holding nm.mu.
+func (nm *NetworkMonitor) raiseAnomaly(anomalyType, severity, deviceID string, params, details map[string]string) *models.Anomaly {
+	return nm.raiseLinkedAnomaly(anomalyType, severity, deviceID, params, details, nil)
+}
+
+// raiseLinkedAnomaly is raiseAnomaly for anomalies derived from communication
+// patterns; each contributing pattern is annotated with the anomaly
+func (nm *NetworkMonitor) raiseLinkedAnomaly(anomalyType, severity, deviceID string, params, details map[string]string, patterns []string) *models.Anomaly {
+	if len(patterns) > maxAnomalyPatterns {
+		patterns = patterns[:maxAnomalyPatterns]
+	}
+
+	if nm.self.excludes(deviceID) {
+		return nil
+	}
+
+	var deviceUUID string
+	if deviceID != "" {
+		deviceUUID = nm.uuids.device(deviceID)
+	}
+
+	if params == nil {
+		params = make(map[string]string, 2)
+	}
+	if _, ok := params["device"]; !ok && deviceID != "" {
+		params["device"] = deviceID
+	}
+	params["severity"] = severity
+	description := nm.Messages().Render(messages.AnomalyKey(anomalyType), params)
+
+	now := time.Now()
+	nm.anomalyMu.Lock()
+	if nm.dropMuted(deviceID, now) {
+		nm.anomalyMu.Unlock()
+		return nil
+	}
+
+	anomaly := &models.Anomaly{
+		ID:          fmt.Sprintf("a-%d", anomalySeq.Add(1)),
+		Type:        anomalyType,
+		Severity:    severity,
+		DeviceID:    deviceID,
+		DeviceUUID:  deviceUUID,
+		Description: description,
+		Params:      params,
+		Details:     details,
+		Patterns:    patterns,
+		Timestamp:   now,
+	}
+	anomaly.MutedBy = nm.mutedBy(anomaly)
+	if anomaly.MutedBy == "" {
+		nm.expectAnomaly(anomaly)
+	}
+	notify := anomaly.MutedBy == "" && anomaly.ExpectedBy == ""
+	for _, patternID := range patterns {
+		nm.queueAnnotation(patternID, models.Annotation{Type: models.AnnotationAnomaly, ID: anomaly.ID})
+	}
+	nm.anomalies = append(nm.anomalies, anomaly)
+	if len(nm.anomalies) > maxRecentAnomalies {
+		nm.anomalies = nm.anomalies[len(nm.anomalies)-maxRecentAnomalies:]
+	}
+	nm.queueAnomaly(anomaly, false)
+	if notify {
+		for sub := range nm.anomalySubs {
+			// Slow subscribers miss anomalies rather than stall detection
+			select {
+			case sub <- anomaly:
+			default:
+			}
+		}
+	}
+	nm.anomalyMu.Unlock()
+
+	if !notify {
+		return anomaly // Already acknowledged or expected, recorded without notification
+	}
+	select {
+	case nm.anomalyChan <- anomaly:
+	default:
+	}
+
+	return anomaly
+}
+
+// RecentAnomalies returns the most recent anomalies, oldest first
+func (nm *NetworkMonitor) RecentAnomalies() []*models.Anomaly {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	anomalies := make([]*models.Anomaly, len(nm.anomalies))
+	copy(anomalies, nm.anomalies)
+	return anomalies
+}
+
+// FindAnomaly returns an anomaly by ID, from memory or the persisted history
+func (nm *NetworkMonitor) FindAnomaly(id string) (*models.Anomaly, bool) {
+	if anomaly, ok := nm.findRecentAnomaly(id); ok {
+		return anomaly, anomaly != nil
+	}
+	anomaly, err := nm.loadAnomaly(id)
+	return anomaly, err == nil
+}
+
+// SubscribeAnomalies returns a channel receiving every anomaly raised from now
+// on, and a function that ends the subscription. With history, it also returns
+// the recent anomalies (oldest first); none is missed or repeated in between.
+func (nm *NetworkMonitor) SubscribeAnomalies(history bool) ([]*models.Anomaly, <-chan *models.Anomaly, func()) {
+	sub := make(chan *models.Anomaly, 16)
+
+	nm.anomalyMu.Lock()
+	var recent []*models.Anomaly
+	if history {
+		recent = append(recent, nm.anomalies...)
+	}
+	if nm.anomalySubs == nil {
+		nm.anomalySubs = make(map[chan *models.Anomaly]struct{})
+	}
+	nm.anomalySubs[sub] = struct{}{}
+	nm.anomalyMu.Unlock()
+
+	unsubscribe := func() {
+		nm.anomalyMu.Lock()
+		defer nm.anomalyMu.Unlock()
+		if _, ok := nm.anomalySubs[sub]; ok {
+			delete(nm.anomalySubs, sub)
+			close(sub)
+		}
+	}
+	return recent, sub, unsubscribe
+}
+
+func (nm *NetworkMonitor) anomalyNotifier() {
+	for anomaly := range nm.anomalyChan {
+		if !nm.emit(SinkAnomaly, anomaly) {
+			continue
+		}
+		fmt.Printf("\nANOMALY [%s] %s\n", anomaly.Severity, anomaly.Type)
+		if anomaly.DeviceID != "" {
+			fmt.Printf("   Device:  %s\n", anomaly.DeviceID)
+		}
+		fmt.Printf("   %s\n", anomaly.Description)
+		fmt.Printf("   Time:    %s\n\n", anomaly.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+}
@@ -0,0 +1,386 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// AnomalyFilterKeyPrefix prefixes persisted saved anomaly filters in the
+// database.
+const AnomalyFilterKeyPrefix = "savedfilter:"
+
+// maxAnomalyFilterName bounds the length of saved filter names
+const maxAnomalyFilterName = 64
+
+// Actions of a bulk anomaly operation
+const (
+	BulkAck        = "ack"
+	BulkDelete     = "delete"
+	BulkAssignNote = "assign-note"
+)
+
+// MaxBulkAnomalies bounds the anomalies one bulk operation selects. An
+// operation by filter that selects as many reports more to do; calling it
+// again continues, as changed anomalies no longer match or no longer exist.
+const MaxBulkAnomalies = 500
+
+var (
+	// ErrAnomalyFilterNotFound is returned for an unknown saved filter
+	ErrAnomalyFilterNotFound = errors.New("saved filter not found")
+	// ErrAnomalyFilterExists is returned when saving a filter under a taken name
+	ErrAnomalyFilterExists = errors.New("saved filter already exists")
+	// ErrBulkNotConfirmed is returned when deleting by filter without confirmation
+	ErrBulkNotConfirmed = errors.New("deleting anomalies by filter requires confirm=true")
+)
+
+// BulkAnomalyOp is an action on several anomalies, selected by ID or by filter
+type BulkAnomalyOp struct {
+	Action  string
+	IDs     []string
+	Filter  *models.AnomalyFilter // Selects the anomalies when IDs is empty
+	Comment string                // Of the acknowledgements
+	Note    string                // Assigned by assign-note
+	Confirm bool                  // Required to delete by filter
+}
+
+// anomalyFilters holds the saved anomaly filters. Its lock is taken last.
+type anomalyFilters struct {
+	mu      sync.Mutex
+	filters map[string]models.SavedAnomalyFilter
+}
+
+func newAnomalyFilters() *anomalyFilters {
+	return &anomalyFilters{filters: make(map[string]models.SavedAnomalyFilter)}
+}
+
+// normalizeAnomalyFilter validates a filter, normalizing the case of its
+// criteria and resolving device UUIDs. The filter given is left unchanged.
+func (nm *NetworkMonitor) normalizeAnomalyFilter(f models.AnomalyFilter) (models.AnomalyFilter, error) {
+	f.Types, f.Severities = slices.Clone(f.Types), slices.Clone(f.Severities)
+	f.Devices, f.Tags = slices.Clone(f.Devices), slices.Clone(f.Tags)
+	for i, anomalyType := range f.Types {
+		f.Types[i] = strings.ToUpper(strings.TrimSpace(anomalyType))
+	}
+	for i, severity := range f.Severities {
+		f.Severities[i] = strings.ToUpper(strings.TrimSpace(severity))
+		if models.SeverityRank(f.Severities[i]) < 0 {
+			return f, fmt.Errorf("unknown severity %q", severity)
+		}
+	}
+	f.MinSeverity = strings.ToUpper(strings.TrimSpace(f.MinSeverity))
+	if f.MinSeverity != "" && models.SeverityRank(f.MinSeverity) < 0 {
+		return f, fmt.Errorf("unknown severity %q", f.MinSeverity)
+	}
+	for i, device := range f.Devices {
+		f.Devices[i] = nm.ResolveDeviceID(strings.ToLower(strings.TrimSpace(device)))
+	}
+	for i, tag := range f.Tags {
+		f.Tags[i] = strings.ToLower(strings.TrimSpace(tag))
+		if f.Tags[i] == "" {
+			return f, fmt.Errorf("tags can't be empty")
+		}
+	}
+	if f.Since != nil && f.Until != nil && !f.Since.Before(*f.Until) {
+		return f, fmt.Errorf("since must be before until")
+	}
+	if f.Within != "" {
+		if within, err := time.ParseDuration(f.Within); err != nil || within <= 0 {
+			return f, fmt.Errorf("invalid within %q: expected a positive duration such as 12h", f.Within)
+		}
+	}
+	return f, nil
+}
+
+// anomalyMatcher compiles a normalized filter. Tags are looked up on the
+// tracked devices once, when compiled, and within counts back from now.
+func (nm *NetworkMonitor) anomalyMatcher(f models.AnomalyFilter, now time.Time) func(*models.Anomaly) bool {
+	var tagged map[string]bool
+	if len(f.Tags) > 0 {
+		tagged = nm.devicesTagged(f.Tags)
+	}
+	since := f.Since
+	if within, err := time.ParseDuration(f.Within); err == nil {
+		if start := now.Add(-within); since == nil || start.After(*since) {
+			since = &start
+		}
+	}
+	minSeverity := -1
+	if f.MinSeverity != "" {
+		minSeverity = models.SeverityRank(f.MinSeverity)
+	}
+
+	return func(anomaly *models.Anomaly) bool {
+		switch {
+		case len(f.Types) > 0 && !slices.Contains(f.Types, anomaly.Type),
+			len(f.Severities) > 0 && !slices.Contains(f.Severities, anomaly.Severity),
+			models.SeverityRank(anomaly.Severity) < minSeverity,
+			len(f.Devices) > 0 && !slices.Contains(f.Devices, anomaly.DeviceID),
+			tagged != nil && !tagged[anomaly.DeviceID],
+			since != nil && anomaly.Timestamp.Before(*since),
+			f.Until != nil && !anomaly.Timestamp.Before(*f.Until),
+			f.Acknowledged != nil && *f.Acknowledged != (anomaly.Ack != nil),
+			f.Suppressed != nil && *f.Suppressed != (anomaly.MutedBy != "" || anomaly.ExpectedBy != ""):
+			return false
+		}
+		return true
+	}
+}
+
+// devicesTagged returns the IDs of the tracked devices bearing every tag
+func (nm *NetworkMonitor) devicesTagged(tags []string) map[string]bool {
+	tagged := make(map[string]bool)
+	nm.eachDevice(func(id string, device *models.DeviceInfo) {
+		deviceTags := DeviceTags(device)
+		if !slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(deviceTags, tag) }) {
+			tagged[id] = true
+		}
+	})
+	return tagged
+}
+
+// AnomalyMatcher validates a filter and returns a function reporting whether
+// an anomaly matches it
+func (nm *NetworkMonitor) AnomalyMatcher(f models.AnomalyFilter) (func(*models.Anomaly) bool, error) {
+	f, err := nm.normalizeAnomalyFilter(f)
+	if err != nil {
+		return nil, err
+	}
+	return nm.anomalyMatcher(f, time.Now()), nil
+}
+
+// BulkAnomalies applies an action to the anomalies an operation selects and
+// reports the outcome for each. A filter only selects anomalies raised before
+// the call, newest first, up to MaxBulkAnomalies: an anomaly raised meanwhile,
+// even of an acknowledged condition, keeps its own triage state.
+func (nm *NetworkMonitor) BulkAnomalies(op BulkAnomalyOp) (models.AnomalyBulkResult, error) {
+	result := models.AnomalyBulkResult{Action: op.Action, Results: []models.AnomalyBulkItem{}}
+
+	var apply func(id string) (string, error)
+	switch op.Action {
+	case BulkAck:
+		apply = func(id string) (string, error) {
+			_, changed, err := nm.updateAnomaly(id, func(anomaly *models.Anomaly) bool {
+				return nm.ack(anomaly, op.Comment, time.Now())
+			})
+			return updateStatus(changed), err
+		}
+	case BulkAssignNote:
+		if strings.TrimSpace(op.Note) == "" {
+			return result, fmt.Errorf("assign-note requires a note")
+		}
+		apply = func(id string) (string, error) {
+			_, changed, err := nm.updateAnomaly(id, func(anomaly *models.Anomaly) bool {
+				if anomaly.Note != nil && anomaly.Note.Text == op.Note {
+					return false
+				}
+				anomaly.Note = &models.AnomalyNote{At: time.Now(), Text: op.Note}
+				return true
+			})
+			return updateStatus(changed), err
+		}
+	case BulkDelete:
+		if len(op.IDs) == 0 && !op.Confirm {
+			return result, ErrBulkNotConfirmed
+		}
+		apply = func(id string) (string, error) {
+			return models.BulkDeleted, nm.deleteAnomaly(id)
+		}
+	default:
+		return result, fmt.Errorf("unknown action %q: expected %s, %s or %s", op.Action, BulkAck, BulkDelete, BulkAssignNote)
+	}
+
+	ids, more, err := nm.selectAnomalies(op)
+	if err != nil {
+		return result, err
+	}
+	result.Matched, result.More = len(ids), more
+
+	for _, id := range ids {
+		item := models.AnomalyBulkItem{ID: id}
+		status, err := apply(id)
+		switch {
+		case errors.Is(err, ErrAnomalyNotFound):
+			item.Status = models.BulkNotFound
+		case err != nil:
+			item.Status, item.Error = models.BulkFailed, err.Error()
+		default:
+			item.Status = status
+			if status != models.BulkUnchanged {
+				result.Affected++
+			}
+		}
+		result.Results = append(result.Results, item)
+	}
+	return result, nil
+}
+
+func updateStatus(changed bool) string {
+	if changed {
+		return models.BulkUpdated
+	}
+	return models.BulkUnchanged
+}
+
+// selectAnomalies returns the IDs an operation lists, or those its filter
+// matches, and whether the filter matched more than MaxBulkAnomalies
+func (nm *NetworkMonitor) selectAnomalies(op BulkAnomalyOp) ([]string, bool, error) {
+	if len(op.IDs) > 0 {
+		if len(op.IDs) > MaxBulkAnomalies {
+			return nil, false, fmt.Errorf("too many IDs: at most %d per call", MaxBulkAnomalies)
+		}
+		ids := make([]string, 0, len(op.IDs))
+		for _, id := range op.IDs {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids, false, nil
+	}
+	if op.Filter == nil {
+		return nil, false, fmt.Errorf("select anomalies with ids or a filter")
+	}
+
+	filter, err := nm.normalizeAnomalyFilter(*op.Filter)
+	if err != nil {
+		return nil, false, err
+	}
+	// Anomalies raised from now on get later IDs, so paging from here leaves
+	// them out
+	before := fmt.Sprintf("a-%d", anomalySeq.Load()+1)
+	anomalies, next, err := nm.AnomalyHistory(before, MaxBulkAnomalies, nm.anomalyMatcher(filter, time.Now()))
+	if err != nil {
+		return nil, false, err
+	}
+	ids := make([]string, len(anomalies))
+	for i, anomaly := range anomalies {
+		ids[i] = anomaly.ID
+	}
+	return ids, next != "", nil
+}
+
+// loadAnomalyFilters reads the saved anomaly filters
+func (nm *NetworkMonitor) loadAnomalyFilters() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", AnomalyFilterKeyPrefix, AnomalyFilterKeyPrefix+"~", func(key, value string) bool {
+			var saved models.SavedAnomalyFilter
+			if json.Unmarshal([]byte(value), &saved) == nil {
+				nm.anomalyFilters.filters[saved.Name] = saved
+			}
+			return true
+		})
+	})
+}
+
+// validAnomalyFilterName reports whether a name is lowercase letters, digits,
+// dots, dashes and underscores, starting with a letter or digit
+func validAnomalyFilterName(name string) bool {
+	if name == "" || len(name) > maxAnomalyFilterName {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case i > 0 && (c == '.' || c == '-' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// SaveAnomalyFilter validates and persists a named anomaly filter. With
+// create, the name must be free; otherwise it must exist, and its filter is
+// replaced.
+func (nm *NetworkMonitor) SaveAnomalyFilter(name string, filter models.AnomalyFilter, create bool) (models.SavedAnomalyFilter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !validAnomalyFilterName(name) {
+		return models.SavedAnomalyFilter{}, fmt.Errorf("invalid name %q: expected up to %d letters, digits, dots, dashes and underscores", name, maxAnomalyFilterName)
+	}
+	filter, err := nm.normalizeAnomalyFilter(filter)
+	if err != nil {
+		return models.SavedAnomalyFilter{}, err
+	}
+
+	nm.anomalyFilters.mu.Lock()
+	defer nm.anomalyFilters.mu.Unlock()
+
+	now := time.Now()
+	saved, exists := nm.anomalyFilters.filters[name]
+	switch {
+	case create && exists:
+		return models.SavedAnomalyFilter{}, ErrAnomalyFilterExists
+	case !create && !exists:
+		return models.SavedAnomalyFilter{}, ErrAnomalyFilterNotFound
+	case create:
+		saved = models.SavedAnomalyFilter{Name: name, CreatedAt: now}
+	}
+	saved.Filter, saved.UpdatedAt = filter, now
+
+	data, _ := json.Marshal(saved)
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(AnomalyFilterKeyPrefix+name, string(data), nil)
+		return err
+	})
+	if err != nil {
+		return models.SavedAnomalyFilter{}, fmt.Errorf("failed to persist saved filter: %w", err)
+	}
+	nm.anomalyFilters.filters[name] = saved
+	return saved, nil
+}
+
+// DeleteAnomalyFilter removes a saved anomaly filter
+func (nm *NetworkMonitor) DeleteAnomalyFilter(name string) error {
+	nm.anomalyFilters.mu.Lock()
+	defer nm.anomalyFilters.mu.Unlock()
+
+	name = strings.ToLower(name)
+	if _, ok := nm.anomalyFilters.filters[name]; !ok {
+		return ErrAnomalyFilterNotFound
+	}
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(AnomalyFilterKeyPrefix + name)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete saved filter: %w", err)
+	}
+	delete(nm.anomalyFilters.filters, name)
+	return nil
+}
+
+// AnomalyFilter returns a saved anomaly filter
+func (nm *NetworkMonitor) AnomalyFilter(name string) (models.SavedAnomalyFilter, error) {
+	nm.anomalyFilters.mu.Lock()
+	defer nm.anomalyFilters.mu.Unlock()
+
+	saved, ok := nm.anomalyFilters.filters[strings.ToLower(name)]
+	if !ok {
+		return models.SavedAnomalyFilter{}, ErrAnomalyFilterNotFound
+	}
+	return saved, nil
+}
+
+// AnomalyFilters returns the saved anomaly filters by name
+func (nm *NetworkMonitor) AnomalyFilters() []models.SavedAnomalyFilter {
+	nm.anomalyFilters.mu.Lock()
+	defer nm.anomalyFilters.mu.Unlock()
+
+	filters := make([]models.SavedAnomalyFilter, 0, len(nm.anomalyFilters.filters))
+	for _, saved := range nm.anomalyFilters.filters {
+		filters = append(filters, saved)
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+	return filters
+}
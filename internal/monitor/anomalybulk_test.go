@@ -0,0 +1,183 @@
+package monitor
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// anomalyStates returns whether each anomaly in the history is acknowledged,
+// by ID
+func anomalyStates(t *testing.T, nm *NetworkMonitor) map[string]bool {
+	t.Helper()
+	anomalies, _, err := nm.AnomalyHistory("", 10000, func(*models.Anomaly) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	acked := make(map[string]bool, len(anomalies))
+	for _, anomaly := range anomalies {
+		acked[anomaly.ID] = anomaly.Ack != nil
+	}
+	return acked
+}
+
+// A bulk ack by filter acknowledges what it selected and nothing raised while
+// it runs: new occurrences of the acknowledged condition are recorded muted,
+// but stay unacknowledged. Run with -race.
+func TestBulkAckRacingNewOccurrences(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+	unacked := false
+	filter := &models.AnomalyFilter{Types: []string{"port_scan"}, Acknowledged: &unacked}
+
+	for round := range 5 {
+		for range 50 {
+			nm.raiseAnomaly("PORT_SCAN", models.SeverityLow, mac, nil, nil)
+		}
+
+		var wg sync.WaitGroup
+		raised := make(chan string, 200)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				if anomaly := nm.raiseAnomaly("PORT_SCAN", models.SeverityLow, mac, nil, nil); anomaly != nil {
+					raised <- anomaly.ID
+				}
+			}
+			close(raised)
+		}()
+		var result models.AnomalyBulkResult
+		var err error
+		go func() {
+			defer wg.Done()
+			result, err = nm.BulkAnomalies(BulkAnomalyOp{Action: BulkAck, Filter: filter, Comment: "triaged"})
+		}()
+		wg.Wait()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		selected := make(map[string]bool, len(result.Results))
+		for _, item := range result.Results {
+			selected[item.ID] = true
+			if item.Status != models.BulkUpdated {
+				t.Errorf("round %d: %s %s, want updated", round, item.ID, item.Status)
+			}
+		}
+		if result.Matched < 50 || result.Affected != result.Matched {
+			t.Errorf("round %d: matched %d, affected %d", round, result.Matched, result.Affected)
+		}
+
+		// Flushing midway checks persisted records too
+		if round%2 == 1 {
+			if _, err := nm.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		states := anomalyStates(t, nm)
+		for id := range selected {
+			if !states[id] {
+				t.Errorf("round %d: selected %s not acknowledged", round, id)
+			}
+		}
+		for id := range raised {
+			if states[id] != selected[id] {
+				t.Errorf("round %d: %s raised during the ack: acknowledged %v, selected %v", round, id, states[id], selected[id])
+			}
+			if !selected[id] {
+				anomaly, _ := nm.FindAnomaly(id)
+				if anomaly == nil || anomaly.MutedBy == "" {
+					t.Errorf("round %d: %s not muted by the acknowledged condition: %+v", round, id, anomaly)
+				}
+			}
+		}
+
+		// The next call picks up what the last one left out
+		result, err = nm.BulkAnomalies(BulkAnomalyOp{Action: BulkAck, Filter: filter})
+		if err != nil || result.More {
+			t.Fatalf("round %d: second ack %+v, %v", round, result, err)
+		}
+		for id, acked := range anomalyStates(t, nm) {
+			if !acked {
+				t.Errorf("round %d: %s left unacknowledged", round, id)
+			}
+		}
+	}
+}
+
+// Acks of the same anomaly racing each other apply once
+func TestBulkAckRacingAck(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	var ids []string
+	for range 20 {
+		ids = append(ids, nm.raiseAnomaly("PORT_SCAN", models.SeverityLow, "02:00:00:00:00:0a", nil, nil).ID)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]models.AnomalyBulkResult, 4)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = nm.BulkAnomalies(BulkAnomalyOp{Action: BulkAck, IDs: ids})
+		}()
+	}
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nm.AckAnomaly(id, "single")
+		}()
+	}
+	wg.Wait()
+
+	updated := make(map[string]int)
+	for _, result := range results {
+		for _, item := range result.Results {
+			if item.Status == models.BulkUpdated {
+				updated[item.ID]++
+			}
+		}
+	}
+	for _, id := range ids {
+		anomaly, ok := nm.FindAnomaly(id)
+		if !ok || anomaly.Ack == nil {
+			t.Errorf("%s not acknowledged", id)
+			continue
+		}
+		// Either a bulk call acknowledged it, once, or the single ack did
+		if want := map[string]int{"single": 0, "": 1}[anomaly.Ack.Comment]; updated[id] != want {
+			t.Errorf("%s acknowledged by %q, and updated by %d bulk calls", id, anomaly.Ack.Comment, updated[id])
+		}
+	}
+}
+
+// Deleting by filter needs confirmation; IDs already deleted are reported
+// not found
+func TestBulkDelete(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	a := nm.raiseAnomaly("PORT_SCAN", models.SeverityLow, "02:00:00:00:00:0a", nil, nil)
+	b := nm.raiseAnomaly("PORT_SCAN", models.SeverityHigh, "02:00:00:00:00:0a", nil, nil)
+	filter := &models.AnomalyFilter{Severities: []string{"low"}}
+
+	if _, err := nm.BulkAnomalies(BulkAnomalyOp{Action: BulkDelete, Filter: filter}); !errors.Is(err, ErrBulkNotConfirmed) {
+		t.Errorf("unconfirmed delete by filter: %v", err)
+	}
+	result, err := nm.BulkAnomalies(BulkAnomalyOp{Action: BulkDelete, Filter: filter, Confirm: true})
+	if err != nil || result.Affected != 1 || result.Results[0].ID != a.ID {
+		t.Errorf("delete by filter = %+v, %v", result, err)
+	}
+	result, err = nm.BulkAnomalies(BulkAnomalyOp{Action: BulkDelete, IDs: []string{a.ID, b.ID, b.ID}})
+	if err != nil || len(result.Results) != 2 || result.Results[0].Status != models.BulkNotFound || result.Results[1].Status != models.BulkDeleted {
+		t.Errorf("delete by IDs = %+v, %v", result, err)
+	}
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if states := anomalyStates(t, nm); len(states) != 0 {
+		t.Errorf("left %v", states)
+	}
+}
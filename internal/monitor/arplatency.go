@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// arpReplyWindow is how long a request waits for its reply; retransmissions
+// within it keep the first request, so latency includes the retries
+const arpReplyWindow = 3 * time.Second
+
+// arpMaxPending bounds the unanswered requests tracked at once
+const arpMaxPending = 4096
+
+// arpLatencyWeight is the weight of a new sample in the moving average
+const arpLatencyWeight = 0.2
+
+type arpRequestKey struct {
+	requester string // IP asking
+	target    string // IP being resolved
+}
+
+// trackARPLatency pairs ARP requests with the reply of their target and
+// records the resolution latency on the replying device. Must hold nm.mu.
+func (nm *NetworkMonitor) trackARPLatency(device *models.DeviceInfo, srcIP, dstIP string, trafficType models.TrafficType, now time.Time) {
+	switch trafficType {
+	case models.TrafficARPRequest:
+		key := arpRequestKey{requester: srcIP, target: dstIP}
+		if sent, ok := nm.arpRequests[key]; ok && now.Sub(sent) <= arpReplyWindow {
+			return
+		}
+		if len(nm.arpRequests) >= arpMaxPending {
+			for key, sent := range nm.arpRequests {
+				if now.Sub(sent) > arpReplyWindow {
+					delete(nm.arpRequests, key)
+				}
+			}
+			if len(nm.arpRequests) >= arpMaxPending {
+				return
+			}
+		}
+		nm.arpRequests[key] = now
+
+	case models.TrafficARPReply:
+		key := arpRequestKey{requester: dstIP, target: srcIP}
+		sent, ok := nm.arpRequests[key]
+		if !ok {
+			return
+		}
+		delete(nm.arpRequests, key)
+		if now.Sub(sent) > arpReplyWindow {
+			return
+		}
+		recordARPLatency(device, now.Sub(sent))
+	}
+}
+
+func recordARPLatency(device *models.DeviceInfo, latency time.Duration) {
+	ms := float64(latency.Microseconds()) / 1000
+
+	stats := device.ARPLatency
+	if stats == nil {
+		device.ARPLatency = &models.LatencyStats{Samples: 1, LastMs: ms, MinMs: ms, MaxMs: ms, AvgMs: ms}
+		return
+	}
+	stats.Samples++
+	stats.LastMs = ms
+	stats.MinMs = min(stats.MinMs, ms)
+	stats.MaxMs = max(stats.MaxMs, ms)
+	stats.AvgMs += arpLatencyWeight * (ms - stats.AvgMs)
+}
@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// arpMismatchMaxDevices bounds the devices with a counting window at once
+const arpMismatchMaxDevices = 10000
+
+// virtualRouterPrefixes are the MAC prefixes of VRRP and HSRP virtual routers,
+// whose ARP sender address legitimately differs from the physical router's
+var virtualRouterPrefixes = []string{
+	"00:00:5e:00:01:", // VRRP (IPv4)
+	"00:00:5e:00:02:", // VRRP (IPv6)
+	"00:00:0c:07:ac:", // HSRP v1
+	"00:00:0c:9f:f",   // HSRP v2
+}
+
+// ARPMismatchConfig controls detection of ARP packets whose sender hardware
+// address differs from their Ethernet source, a classic spoofing indicator
+type ARPMismatchConfig struct {
+	Window    time.Duration   // Counting window
+	Threshold int             // Mismatches from one device within Window that raise an anomaly
+	Allow     map[string]bool // Ethernet source MACs, or "<source MAC>=<sender MAC>" pairs, whose mismatches are expected
+}
+
+// DefaultARPMismatchConfig returns the default ARP mismatch detection settings
+func DefaultARPMismatchConfig() ARPMismatchConfig {
+	return ARPMismatchConfig{Window: 10 * time.Minute, Threshold: 3}
+}
+
+// ParseARPMismatchAllow parses a comma-separated list of MACs and
+// "<source MAC>=<sender MAC>" pairs into ARPMismatchConfig.Allow
+func ParseARPMismatchAllow(list string) (map[string]bool, error) {
+	allow := make(map[string]bool)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var normalized []string
+		for _, mac := range strings.SplitN(entry, "=", 2) {
+			hw, err := net.ParseMAC(strings.TrimSpace(mac))
+			if err != nil || len(hw) != 6 {
+				return nil, fmt.Errorf("invalid MAC %q in %q", mac, entry)
+			}
+			normalized = append(normalized, hw.String())
+		}
+		allow[strings.Join(normalized, "=")] = true
+	}
+	return allow, nil
+}
+
+type arpMismatchState struct {
+	windowStart time.Time
+	count       int
+	patterns    []string // IDs of new patterns carrying a mismatch
+	alerted     bool
+}
+
+// arpMismatchDetector counts ARP sender mismatches per device. It is guarded
+// by nm.mu.
+type arpMismatchDetector struct {
+	config  ARPMismatchConfig
+	devices map[string]*arpMismatchState
+}
+
+func newARPMismatchDetector(config ARPMismatchConfig) *arpMismatchDetector {
+	return &arpMismatchDetector{config: config, devices: make(map[string]*arpMismatchState)}
+}
+
+// SetARPMismatchConfig replaces the ARP mismatch detection settings
+func (nm *NetworkMonitor) SetARPMismatchConfig(config ARPMismatchConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.arpMismatch.config = config
+}
+
+// isVirtualRouterMAC reports whether mac belongs to a VRRP or HSRP virtual router
+func isVirtualRouterMAC(mac string) bool {
+	for _, prefix := range virtualRouterPrefixes {
+		if strings.HasPrefix(mac, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// arpSenderMismatch returns the ARP sender hardware address of an ARP request
+// or reply when it differs from the Ethernet source, unless the difference is
+// expected. Must hold nm.mu.
+func (nm *NetworkMonitor) arpSenderMismatch(evt *models.NetworkEvent, srcMAC string) string {
+	if evt.EventType != models.EVENT_TYPE_ARP || (evt.ArpOp != 1 && evt.ArpOp != 2) {
+		return ""
+	}
+	sender := utils.MacToString(evt.ArpSha)
+	if sender == srcMAC {
+		return ""
+	}
+	if isVirtualRouterMAC(sender) || isVirtualRouterMAC(srcMAC) {
+		return ""
+	}
+	allow := nm.arpMismatch.config.Allow
+	if allow[srcMAC] || allow[srcMAC+"="+sender] {
+		return ""
+	}
+	return sender
+}
+
+// observeARPMismatch counts an ARP sender mismatch of a device and raises a
+// HIGH anomaly once the threshold is reached within the window. patternID is
+// the new pattern of the event, if any. Must hold nm.mu.
+func (nm *NetworkMonitor) observeARPMismatch(device *models.DeviceInfo, srcMAC, sender, claimedIP, patternID string, now time.Time) *models.Anomaly {
+	d := nm.arpMismatch
+	config := d.config
+	device.ARPMismatches++
+
+	state := d.devices[device.ID]
+	if state == nil || now.Sub(state.windowStart) > config.Window {
+		if len(d.devices) >= arpMismatchMaxDevices {
+			for id, s := range d.devices {
+				if now.Sub(s.windowStart) > config.Window {
+					delete(d.devices, id)
+				}
+			}
+		}
+		state = &arpMismatchState{windowStart: now}
+		d.devices[device.ID] = state
+	}
+	state.count++
+	if patternID != "" && len(state.patterns) < maxAnomalyPatterns {
+		state.patterns = append(state.patterns, patternID)
+	}
+	if state.alerted || state.count < config.Threshold {
+		return nil
+	}
+	state.alerted = true
+
+	return nm.raiseLinkedAnomaly("ARP_SENDER_MISMATCH", models.SeverityHigh, device.ID,
+		map[string]string{
+			"device":      srcMAC,
+			"device_name": device.Name,
+			"count":       strconv.Itoa(state.count),
+			"claimed_ip":  claimedIP,
+			"sender_mac":  sender,
+			"elapsed":     now.Sub(state.windowStart).Round(time.Second).String(),
+		},
+		map[string]string{
+			"ethernet_mac":    srcMAC,
+			"ethernet_vendor": nm.lookupVendor(srcMAC),
+			"sender_mac":      sender,
+			"sender_vendor":   nm.lookupVendor(sender),
+			"claimed_ip":      claimedIP,
+			"count":           strconv.Itoa(state.count),
+			"window":          config.Window.String(),
+		}, state.patterns)
+}
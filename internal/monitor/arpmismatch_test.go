@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// arpEvent returns an ARP packet of the given operation sent from src whose
+// sender hardware address is sender, claiming ip
+func arpEvent(t *testing.T, src, sender, ip string, op uint16) *models.NetworkEvent {
+	t.Helper()
+	evt := arpReply(t, src, ip)
+	evt.ArpOp = op
+	evt.ArpSha = testMAC(t, sender)
+	return evt
+}
+
+// A sender address other than the Ethernet source is a mismatch, unless
+// either belongs to a VRRP or HSRP virtual router or the allow list expects it
+func TestARPSenderMismatch(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	nm.SetARPMismatchConfig(ARPMismatchConfig{
+		Window: DefaultARPMismatchConfig().Window, Threshold: 3,
+		Allow: map[string]bool{"02:00:00:00:00:0c": true, "02:00:00:00:00:0d=02:00:00:00:00:0e": true},
+	})
+	const host, other = "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+
+	tests := []struct {
+		name   string
+		evt    *models.NetworkEvent
+		srcMAC string
+		want   string
+	}{
+		{"reply matching", arpEvent(t, host, host, "192.168.1.10", 2), host, ""},
+		{"request matching", arpEvent(t, host, host, "192.168.1.10", 1), host, ""},
+		{"reply mismatch", arpEvent(t, host, other, "192.168.1.10", 2), host, other},
+		{"request mismatch", arpEvent(t, host, other, "192.168.1.10", 1), host, other},
+		{"other operation", arpEvent(t, host, other, "192.168.1.10", 3), host, ""},
+		{"not ARP", tcpEvent(t, host, "192.168.1.10", "192.168.1.1", 22), host, ""},
+		{"VRRP sender", arpEvent(t, host, "00:00:5e:00:01:07", "192.168.1.1", 2), host, ""},
+		{"VRRP IPv6 sender", arpEvent(t, host, "00:00:5e:00:02:07", "192.168.1.1", 2), host, ""},
+		{"HSRP v1 source", arpEvent(t, "00:00:0c:07:ac:01", other, "192.168.1.1", 2), "00:00:0c:07:ac:01", ""},
+		{"HSRP v2 sender", arpEvent(t, host, "00:00:0c:9f:f0:01", "192.168.1.1", 2), host, ""},
+		{"near VRRP prefix", arpEvent(t, host, "00:00:5e:00:03:07", "192.168.1.1", 2), host, "00:00:5e:00:03:07"},
+		{"allowed source", arpEvent(t, "02:00:00:00:00:0c", other, "192.168.1.12", 2), "02:00:00:00:00:0c", ""},
+		{"allowed pair", arpEvent(t, "02:00:00:00:00:0d", "02:00:00:00:00:0e", "192.168.1.13", 2), "02:00:00:00:00:0d", ""},
+		{"pair of another sender", arpEvent(t, "02:00:00:00:00:0d", other, "192.168.1.13", 2), "02:00:00:00:00:0d", other},
+	}
+	nm.lockAll()
+	defer nm.unlockAll()
+	for _, tt := range tests {
+		if got := nm.arpSenderMismatch(tt.evt, tt.srcMAC); got != tt.want {
+			t.Errorf("%s: mismatch = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Mismatches of a device are counted, and the threshold within the window
+// raises a single HIGH anomaly; virtual router replies are never counted
+func TestARPMismatchAnomaly(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	nm.SetARPMismatchConfig(ARPMismatchConfig{Window: DefaultARPMismatchConfig().Window, Threshold: 3})
+	const attacker, gateway, router = "02:00:00:00:00:0a", "02:00:00:00:00:01", "02:00:00:00:00:02"
+
+	for range 3 {
+		nm.TrackEvent(arpEvent(t, router, "00:00:5e:00:01:07", "192.168.1.1", 2))
+	}
+	nm.TrackEvent(arpEvent(t, attacker, attacker, "192.168.1.10", 2))
+	for range 2 {
+		nm.TrackEvent(arpEvent(t, attacker, gateway, "192.168.1.1", 2))
+	}
+	if n, _ := countAnomalies(nm, "ARP_SENDER_MISMATCH"); n != 0 {
+		t.Fatalf("%d anomalies below the threshold, want 0", n)
+	}
+	for range 2 {
+		nm.TrackEvent(arpEvent(t, attacker, gateway, "192.168.1.1", 2))
+	}
+
+	if device, _ := nm.GetDevice(router); device.ARPMismatches != 0 {
+		t.Errorf("virtual router counted %d mismatches, want 0", device.ARPMismatches)
+	}
+	if device, _ := nm.GetDevice(attacker); device.ARPMismatches != 4 {
+		t.Errorf("attacker counted %d mismatches, want 4", device.ARPMismatches)
+	}
+	n, anomaly := countAnomalies(nm, "ARP_SENDER_MISMATCH")
+	if n != 1 {
+		t.Fatalf("%d anomalies, want 1", n)
+	}
+	if anomaly.Severity != models.SeverityHigh || anomaly.DeviceID != attacker ||
+		anomaly.Details["sender_mac"] != gateway || anomaly.Details["claimed_ip"] != "192.168.1.1" ||
+		anomaly.Details["count"] != "3" {
+		t.Errorf("anomaly = %s for %s, details %v", anomaly.Severity, anomaly.DeviceID, anomaly.Details)
+	}
+}
@@ -0,0 +1,440 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// AvailabilityKeyPrefix prefixes the persisted availability of critical
+// devices in the database.
+const AvailabilityKeyPrefix = "uptime:"
+
+const (
+	availabilityDevicePrefix = AvailabilityKeyPrefix + "device:"
+	availabilityHeartbeatKey = AvailabilityKeyPrefix + "heartbeat" // Last time cerberus was known to run
+)
+
+// availabilityInterval is how often critical devices are checked for silence
+// and the heartbeat is written
+const availabilityInterval = 10 * time.Second
+
+// AvailabilityHistory is how long availability transitions are kept, and the
+// longest window availability is reported over
+const AvailabilityHistory = 90 * 24 * time.Hour
+
+// AvailabilityConfig controls the availability tracking of critical devices,
+// those marked critical in the inventory
+type AvailabilityConfig struct {
+	Absence time.Duration // Silence after which a device counts as down since its last traffic
+	Grace   time.Duration // Time down after which an anomaly is raised
+}
+
+// DefaultAvailabilityConfig returns the default availability settings. A
+// device silent for less than Absence, say asleep, had no outage.
+func DefaultAvailabilityConfig() AvailabilityConfig {
+	return AvailabilityConfig{Absence: 2 * time.Minute, Grace: 5 * time.Minute}
+}
+
+// availabilityTrack is the availability of one critical device
+type availabilityTrack struct {
+	transitions []models.AvailabilityTransition // Oldest first
+	lastSeen    time.Time                       // Last traffic, or when tracking (re)started
+	alerted     bool                            // An anomaly was raised for the current outage
+	alertID     string                          // Its ID, empty if it was muted
+	dirty       bool                            // Transitions changed since the last persist
+}
+
+// state returns the current state of a track, "" before its first transition
+func (t *availabilityTrack) state() string {
+	if len(t.transitions) == 0 {
+		return ""
+	}
+	return t.transitions[len(t.transitions)-1].State
+}
+
+// transition moves a track to a state. Transitions never go back in time, and
+// one at the same time as the previous replaces it.
+func (t *availabilityTrack) transition(state string, since time.Time) {
+	t.dirty = true
+	if n := len(t.transitions); n > 0 && !since.After(t.transitions[n-1].Since) {
+		t.transitions[n-1].State = state
+		return
+	}
+	t.transitions = append(t.transitions, models.AvailabilityTransition{State: state, Since: since})
+}
+
+// availabilityTracker follows the presence of critical devices. It is guarded
+// by nm.mu.
+type availabilityTracker struct {
+	config    AvailabilityConfig
+	tracks    map[string]*availabilityTrack // By device ID
+	forgotten []string                      // Device IDs whose persisted track awaits deletion
+}
+
+func newAvailabilityTracker(config AvailabilityConfig) *availabilityTracker {
+	return &availabilityTracker{config: config, tracks: make(map[string]*availabilityTrack)}
+}
+
+// renameDevice moves the track of a routed device absorbed into a local one,
+// unless the local one has its own
+func (t *availabilityTracker) renameDevice(from, to string) {
+	track := t.tracks[from]
+	if track == nil {
+		return
+	}
+	delete(t.tracks, from)
+	t.forgotten = append(t.forgotten, from)
+	if t.tracks[to] == nil {
+		track.dirty = true
+		t.tracks[to] = track
+	}
+}
+
+// SetAvailabilityConfig replaces the availability settings
+func (nm *NetworkMonitor) SetAvailabilityConfig(config AvailabilityConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.availability.config = config
+}
+
+// loadAvailability reads the persisted availability of critical devices. The
+// time since cerberus last ran is unknown rather than down, and silence is
+// measured from now on.
+func (nm *NetworkMonitor) loadAvailability(now time.Time) {
+	heartbeat := now
+	nm.db.View(func(tx *buntdb.Tx) error {
+		if value, err := tx.Get(availabilityHeartbeatKey); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil && t.Before(now) {
+				heartbeat = t
+			}
+		}
+		return tx.AscendRange("", availabilityDevicePrefix, availabilityDevicePrefix+"~", func(key, value string) bool {
+			var transitions []models.AvailabilityTransition
+			if json.Unmarshal([]byte(value), &transitions) == nil && len(transitions) > 0 {
+				id := strings.TrimPrefix(key, availabilityDevicePrefix)
+				nm.availability.tracks[id] = &availabilityTrack{transitions: transitions, lastSeen: now}
+			}
+			return true
+		})
+	})
+
+	for _, track := range nm.availability.tracks {
+		if track.state() != models.AvailabilityUnknown {
+			track.transition(models.AvailabilityUnknown, heartbeat)
+		}
+	}
+}
+
+// observePresence records traffic from a device, bringing a critical device
+// back up. Must hold nm.mu.
+func (nm *NetworkMonitor) observePresence(device *models.DeviceInfo, now time.Time) {
+	if !device.Critical {
+		return
+	}
+	track := nm.availability.tracks[device.ID]
+	if track == nil {
+		track = &availabilityTrack{}
+		nm.availability.tracks[device.ID] = track
+	}
+	track.lastSeen = now
+	if track.state() == models.AvailabilityUp {
+		return
+	}
+
+	var downSince time.Time
+	if track.state() == models.AvailabilityDown {
+		downSince = track.transitions[len(track.transitions)-1].Since
+	}
+	track.transition(models.AvailabilityUp, now)
+
+	// Only outages that were announced get a recovery
+	if !track.alerted {
+		return
+	}
+	track.alerted = false
+	details := map[string]string{
+		"down_since": downSince.Format(time.RFC3339),
+		"outage":     now.Sub(downSince).Round(time.Second).String(),
+	}
+	if track.alertID != "" {
+		details["anomaly"] = track.alertID
+	}
+	track.alertID = ""
+	nm.raiseAnomaly("DEVICE_RECOVERED", models.SeverityInfo, device.ID,
+		map[string]string{
+			"label":       availabilityLabel(device.ID, device),
+			"device_name": device.Name,
+			"outage":      details["outage"],
+		},
+		details)
+}
+
+// availabilityTick marks silent critical devices down, raises an anomaly for
+// outages longer than the grace period and persists the transitions, every
+// availabilityInterval
+func (nm *NetworkMonitor) availabilityTick(now time.Time) {
+	nm.lockAll()
+	tracker := nm.availability
+	config := tracker.config
+
+	// Critical devices not seen since startup are tracked from now on
+	for _, id := range nm.inventory.criticalIDs() {
+		if tracker.tracks[id] == nil {
+			tracker.tracks[id] = &availabilityTrack{lastSeen: now}
+		}
+	}
+
+	dirty := make(map[string][]models.AvailabilityTransition)
+	removed := tracker.forgotten
+	tracker.forgotten = nil
+	for id, track := range tracker.tracks {
+		// Uncached devices keep their track until they are seen again
+		if device, ok := nm.Cache.Peek(id); ok && !device.Critical {
+			delete(tracker.tracks, id)
+			removed = append(removed, id)
+			continue
+		}
+
+		if track.state() != models.AvailabilityDown && now.Sub(track.lastSeen) >= config.Absence {
+			track.transition(models.AvailabilityDown, track.lastSeen)
+		}
+		if track.state() == models.AvailabilityDown && !track.alerted {
+			downSince := track.transitions[len(track.transitions)-1].Since
+			if now.Sub(downSince) >= config.Grace {
+				track.alerted = true
+				device, _ := nm.Cache.Peek(id)
+				anomaly := nm.raiseAnomaly("DEVICE_UNAVAILABLE", models.SeverityHigh, id,
+					map[string]string{
+						"label":      availabilityLabel(id, device),
+						"down_since": downSince.Format(time.RFC3339),
+					},
+					map[string]string{
+						"down_since": downSince.Format(time.RFC3339),
+						"grace":      config.Grace.String(),
+					})
+				if anomaly != nil {
+					track.alertID = anomaly.ID
+				}
+			}
+		}
+
+		pruneTransitions(track, now.Add(-AvailabilityHistory))
+		if track.dirty {
+			track.dirty = false
+			dirty[id] = append([]models.AvailabilityTransition(nil), track.transitions...)
+		}
+	}
+	nm.unlockAll()
+
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		for id, transitions := range dirty {
+			data, err := json.Marshal(transitions)
+			if err != nil {
+				return err
+			}
+			if _, _, err := tx.Set(availabilityDevicePrefix+id, string(data), nil); err != nil {
+				return err
+			}
+		}
+		for _, id := range removed {
+			if _, err := tx.Delete(availabilityDevicePrefix + id); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+				return err
+			}
+		}
+		_, _, err := tx.Set(availabilityHeartbeatKey, now.UTC().Format(time.RFC3339Nano), nil)
+		return err
+	})
+	if err != nil {
+		nm.Stats.FailedPersists.Add(1)
+	}
+}
+
+// availabilityLabel names a critical device in anomalies: its ID, followed by
+// its name or vendor when known
+func availabilityLabel(id string, device *models.DeviceInfo) string {
+	if device == nil || deviceLabel(device) == "" {
+		return id
+	}
+	return fmt.Sprintf("%s (%s)", id, deviceLabel(device))
+}
+
+// pruneTransitions drops the transitions older than cutoff, keeping the one
+// giving the state at cutoff
+func pruneTransitions(track *availabilityTrack, cutoff time.Time) {
+	keep := 0
+	for keep+1 < len(track.transitions) && !track.transitions[keep+1].Since.After(cutoff) {
+		keep++
+	}
+	if keep > 0 {
+		track.transitions = append([]models.AvailabilityTransition(nil), track.transitions[keep:]...)
+		track.dirty = true
+	}
+}
+
+// criticalIDs returns the device IDs of the critical inventory entries with a
+// MAC. Entries known only by IP are matched once the device is seen.
+func (inv *Inventory) criticalIDs() []string {
+	if inv == nil {
+		return nil
+	}
+	var ids []string
+	for mac, entry := range inv.byMAC {
+		if entry.Critical {
+			ids = append(ids, mac)
+		}
+	}
+	return ids
+}
+
+// summarizeAvailability computes the availability over [from, to) from the
+// transitions of a device, oldest first
+func summarizeAvailability(transitions []models.AvailabilityTransition, from, to time.Time) models.Availability {
+	summary := models.Availability{From: from, To: to, State: models.AvailabilityUnknown}
+	if n := len(transitions); n > 0 {
+		summary.State = transitions[n-1].State
+	}
+
+	// The state before the first transition is unknown
+	state, start := models.AvailabilityUnknown, from
+	var outage time.Duration
+	var inOutage bool
+	add := func(state string, begin, end time.Time) {
+		if end.After(to) {
+			end = to
+		}
+		if begin.Before(from) {
+			begin = from
+		}
+		if !end.After(begin) {
+			return
+		}
+		d := end.Sub(begin).Seconds()
+		switch state {
+		case models.AvailabilityUp:
+			summary.UpSeconds += d
+		case models.AvailabilityDown:
+			summary.DownSeconds += d
+		default:
+			summary.UnknownSeconds += d
+		}
+	}
+	closeOutage := func() {
+		if inOutage {
+			summary.Outages++
+			summary.LongestOutageSeconds = max(summary.LongestOutageSeconds, outage.Seconds())
+		}
+		inOutage, outage = false, 0
+	}
+
+	for _, t := range transitions {
+		if t.Since.After(from) {
+			add(state, start, t.Since)
+			if state == models.AvailabilityDown {
+				outage += clipDuration(start, t.Since, from, to)
+			}
+		}
+		if t.Since.After(start) {
+			start = t.Since
+		}
+		if !t.Since.Before(to) {
+			break
+		}
+		if t.State == models.AvailabilityDown && t.Since.Before(to) {
+			inOutage = true
+		} else if t.State == models.AvailabilityUp {
+			closeOutage()
+		}
+		if t.Since.After(from) || len(summary.Transitions) == 0 {
+			summary.Transitions = append(summary.Transitions, t)
+		} else {
+			summary.Transitions[0] = t
+		}
+		state = t.State
+	}
+	if start.Before(to) {
+		add(state, start, to)
+		if state == models.AvailabilityDown {
+			outage += clipDuration(start, to, from, to)
+		}
+	}
+	closeOutage()
+
+	if known := summary.UpSeconds + summary.DownSeconds; known > 0 {
+		uptime := summary.UpSeconds / known * 100
+		summary.UptimePercent = &uptime
+	}
+	return summary
+}
+
+// clipDuration returns the length of [begin, end) within [from, to)
+func clipDuration(begin, end, from, to time.Time) time.Duration {
+	if begin.Before(from) {
+		begin = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(begin) {
+		return 0
+	}
+	return end.Sub(begin)
+}
+
+// DeviceAvailability returns the availability of a critical device over the
+// window ending now. Devices that aren't critical aren't tracked.
+func (nm *NetworkMonitor) DeviceAvailability(id string, window time.Duration) (models.Availability, bool) {
+	now := time.Now()
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	track := nm.availability.tracks[id]
+	if track == nil {
+		return models.Availability{}, false
+	}
+	return nm.availabilityOf(id, track, now.Add(-window), now), true
+}
+
+// Availability returns the availability of every critical device over the
+// window ending now, without their transitions, least available first
+func (nm *NetworkMonitor) Availability(window time.Duration) []models.Availability {
+	now := time.Now()
+	nm.mu.RLock()
+	summaries := make([]models.Availability, 0, len(nm.availability.tracks))
+	for id, track := range nm.availability.tracks {
+		summary := nm.availabilityOf(id, track, now.Add(-window), now)
+		summary.Transitions = nil
+		summaries = append(summaries, summary)
+	}
+	nm.mu.RUnlock()
+
+	uptime := func(a models.Availability) float64 {
+		if a.UptimePercent == nil {
+			return 101 // Never known sorts last
+		}
+		return *a.UptimePercent
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if ui, uj := uptime(summaries[i]), uptime(summaries[j]); ui != uj {
+			return ui < uj
+		}
+		return summaries[i].DeviceID < summaries[j].DeviceID
+	})
+	return summaries
+}
+
+// availabilityOf summarizes a track. Must hold nm.mu.
+func (nm *NetworkMonitor) availabilityOf(id string, track *availabilityTrack, from, to time.Time) models.Availability {
+	summary := summarizeAvailability(track.transitions, from, to)
+	summary.DeviceID = id
+	if device, ok := nm.Cache.Peek(id); ok {
+		summary.Name = device.Name
+	}
+	return summary
+}
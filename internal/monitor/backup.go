@@ -0,0 +1,149 @@
+package monitor
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/version"
+)
+
+// BackupDatabaseName is the name of the database snapshot in a backup archive.
+// Extracting it into an empty data directory restores the backup.
+const BackupDatabaseName = "network.db"
+
+// ErrCorruptDatabase is returned by NewNetworkMonitor when the database file
+// exists but can't be parsed, such as after a power loss mid-write
+var ErrCorruptDatabase = errors.New("corrupt database")
+
+// SetAsideDatabase renames a database file to <path>.corrupt-<timestamp> so a
+// fresh one can be created in its place, returning the new name
+func SetAsideDatabase(dbPath string) (string, error) {
+	aside := fmt.Sprintf("%s.corrupt-%s", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(dbPath, aside); err != nil {
+		return "", err
+	}
+	return aside, nil
+}
+
+// Flush writes every cached device and the pending patterns and anomalies to
+// the database now, the same pass that runs periodically and on shutdown
+func (nm *NetworkMonitor) Flush() (models.FlushResult, error) {
+	return nm.persistDevices()
+}
+
+// Backup flushes pending state and writes a tar.gz archive holding a
+// consistent snapshot of the database (devices, patterns, anomalies,
+// suppressions, ...) and a manifest. Event processing goes on meanwhile; only
+// database writes wait while the snapshot is taken.
+func (nm *NetworkMonitor) Backup(w io.Writer) (models.BackupManifest, error) {
+	var manifest models.BackupManifest
+	dir := filepath.Dir(nm.dbPath)
+	if nm.readOnly {
+		// Nothing is pending, and the data directory may not be writable
+		dir = ""
+	} else if _, err := nm.Flush(); err != nil {
+		return manifest, fmt.Errorf("flush before backup failed: %w", err)
+	}
+
+	// The snapshot goes to a file next to the database first, so the
+	// database isn't held while a slow client downloads it
+	snapshot, err := os.CreateTemp(dir, ".backup-*.db")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
+
+	createdAt := time.Now().UTC()
+	if err := nm.db.Save(snapshot); err != nil {
+		return manifest, fmt.Errorf("database snapshot failed: %w", err)
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return manifest, err
+	}
+	devices, keys, err := verifySnapshot(snapshot)
+	if err != nil {
+		return manifest, err
+	}
+	size, err := snapshot.Seek(0, io.SeekEnd)
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		return manifest, err
+	}
+
+	manifest = models.BackupManifest{
+		CreatedAt: createdAt,
+		Version:   version.Version,
+		Devices:   devices,
+		Keys:      keys,
+		Database:  BackupDatabaseName,
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	if err := archive.WriteHeader(&tar.Header{
+		Name:    "manifest.json",
+		Mode:    0644,
+		Size:    int64(len(manifestData)),
+		ModTime: createdAt,
+	}); err != nil {
+		return manifest, err
+	}
+	if _, err := archive.Write(manifestData); err != nil {
+		return manifest, err
+	}
+	if err := archive.WriteHeader(&tar.Header{
+		Name:    BackupDatabaseName,
+		Mode:    0644,
+		Size:    size,
+		ModTime: createdAt,
+	}); err != nil {
+		return manifest, err
+	}
+	if _, err := io.Copy(archive, snapshot); err != nil {
+		return manifest, err
+	}
+	if err := archive.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// verifySnapshot loads a database snapshot the way a restore would and counts
+// its devices and entries
+func verifySnapshot(r io.Reader) (devices, keys int, err error) {
+	db, err := buntdb.Open(":memory:")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	if err := db.Load(r); err != nil {
+		return 0, 0, fmt.Errorf("database snapshot does not load: %w", err)
+	}
+	err = db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("", func(key, value string) bool {
+			keys++
+			if isDeviceKey(key) {
+				devices++
+			}
+			return true
+		})
+	})
+	return devices, keys, err
+}
@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/messages"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Baselined metrics
+const (
+	MetricPacketRate  = "packet_rate"  // Packets per second
+	MetricPatternRate = "pattern_rate" // New communication patterns per minute
+)
+
+// baselineAlpha is the EWMA smoothing factor once a baseline is warm
+const baselineAlpha = 0.05
+
+// minStdDev keeps near-constant baselines from turning tiny changes into huge z-scores
+var minStdDev = map[string]float64{
+	MetricPacketRate:  1,
+	MetricPatternRate: 1,
+}
+
+// BaselineConfig controls statistical anomaly detection
+type BaselineConfig struct {
+	Interval time.Duration // Sampling interval
+	ZScore   float64       // Deviation that raises an anomaly
+	Warmup   int           // Samples collected before alerting
+}
+
+// rollingStat is an exponentially weighted mean and variance
+type rollingStat struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+func (r *rollingStat) update(value float64) {
+	r.samples++
+
+	// Plain running mean while warming up, then exponential decay
+	alpha := max(baselineAlpha, 1/float64(r.samples))
+	diff := value - r.mean
+	incr := alpha * diff
+	r.mean += incr
+	r.variance = (1 - alpha) * (r.variance + diff*incr)
+}
+
+func (r *rollingStat) zscore(value, floor float64) float64 {
+	return (value - r.mean) / max(math.Sqrt(r.variance), floor)
+}
+
+type baselineState struct {
+	stats    map[string]*rollingStat
+	alerting map[string]bool // Metrics currently above threshold, alerted once per excursion
+}
+
+func newBaselineState() *baselineState {
+	return &baselineState{
+		stats: map[string]*rollingStat{
+			MetricPacketRate:  {},
+			MetricPatternRate: {},
+		},
+		alerting: make(map[string]bool),
+	}
+}
+
+// baselineTracker owns the baselines; it is only used by the sampling goroutine
+type baselineTracker struct {
+	nm      *NetworkMonitor
+	config  BaselineConfig
+	global  *baselineState
+	devices map[string]*baselineState
+}
+
+// StartBaselineMonitor samples per-device and global packet and new-pattern
+// rates every interval and raises an anomaly when one deviates from its rolling
+// baseline by more than the configured z-score
+func (nm *NetworkMonitor) StartBaselineMonitor(config BaselineConfig) {
+	nm.lockAll()
+	nm.windowPackets = make(map[string]int)
+	nm.windowPatterns = make(map[string]int)
+	nm.windowPatternIDs = make(map[string][]string)
+	nm.unlockAll()
+
+	tracker := &baselineTracker{
+		nm:      nm,
+		config:  config,
+		global:  newBaselineState(),
+		devices: make(map[string]*baselineState),
+	}
+
+	last := time.Now()
+	nm.startWorker(config.Interval, func(now time.Time) {
+		tracker.sample(now.Sub(last))
+		last = now
+	})
+}
+
+func (t *baselineTracker) sample(elapsed time.Duration) {
+	nm := t.nm
+
+	nm.lockAll()
+	packets := nm.windowPackets
+	patterns := nm.windowPatterns
+	patternIDs := nm.windowPatternIDs
+	nm.windowPackets = make(map[string]int, len(packets))
+	nm.windowPatterns = make(map[string]int, len(patterns))
+	nm.windowPatternIDs = make(map[string][]string, len(patternIDs))
+	nm.unlockAll()
+
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return
+	}
+
+	for id := range packets {
+		if t.devices[id] == nil {
+			t.devices[id] = newBaselineState()
+		}
+	}
+
+	totalPackets, totalPatterns := 0, 0
+	var allPatternIDs []string
+	for id, state := range t.devices {
+		// Devices that left the cache stop being baselined
+		if packets[id] == 0 && !nm.Cache.Contains(id) {
+			delete(t.devices, id)
+			continue
+		}
+
+		totalPackets += packets[id]
+		totalPatterns += patterns[id]
+		if len(allPatternIDs) < maxAnomalyPatterns {
+			allPatternIDs = append(allPatternIDs, patternIDs[id]...)
+		}
+		t.check(id, state, MetricPacketRate, float64(packets[id])/seconds, nil)
+		t.check(id, state, MetricPatternRate, float64(patterns[id])*60/seconds, patternIDs[id])
+	}
+
+	t.check("", t.global, MetricPacketRate, float64(totalPackets)/seconds, nil)
+	t.check("", t.global, MetricPatternRate, float64(totalPatterns)*60/seconds, allPatternIDs)
+}
+
+// check scores a sample against its baseline, raising an anomaly on the first
+// sample of an excursion, then folds the sample into the baseline. patternIDs
+// are the new patterns behind the sample, if any.
+func (t *baselineTracker) check(deviceID string, state *baselineState, metric string, value float64, patternIDs []string) {
+	stat := state.stats[metric]
+	defer stat.update(value)
+
+	if stat.samples < t.config.Warmup {
+		return
+	}
+
+	z := stat.zscore(value, minStdDev[metric])
+	if z < t.config.ZScore {
+		state.alerting[metric] = false
+		return
+	}
+	if state.alerting[metric] {
+		return
+	}
+	state.alerting[metric] = true
+
+	severity := models.SeverityMedium
+	if z >= 2*t.config.ZScore {
+		severity = models.SeverityHigh
+	}
+
+	anomalyType := "PACKET_RATE_SPIKE"
+	if metric == MetricPatternRate {
+		anomalyType = "PATTERN_RATE_SPIKE"
+	}
+
+	scope := messages.ScopeNetwork
+	if deviceID != "" {
+		scope = messages.ScopeDevice
+	}
+
+	t.nm.raiseLinkedAnomaly(anomalyType, severity, deviceID,
+		map[string]string{
+			"scope":   t.nm.Messages().Render(scope, map[string]string{"device": deviceID}),
+			"value":   strconv.FormatFloat(value, 'f', 1, 64),
+			"mean":    strconv.FormatFloat(stat.mean, 'f', 1, 64),
+			"stddev":  strconv.FormatFloat(math.Sqrt(stat.variance), 'f', 1, 64),
+			"z_score": strconv.FormatFloat(z, 'f', 1, 64),
+		},
+		map[string]string{
+			"metric":   metric,
+			"value":    strconv.FormatFloat(value, 'f', 2, 64),
+			"mean":     strconv.FormatFloat(stat.mean, 'f', 2, 64),
+			"stddev":   strconv.FormatFloat(math.Sqrt(stat.variance), 'f', 2, 64),
+			"z_score":  strconv.FormatFloat(z, 'f', 2, 64),
+			"samples":  strconv.Itoa(stat.samples),
+			"interval": t.config.Interval.String(),
+		}, patternIDs)
+}
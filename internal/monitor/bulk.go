@@ -0,0 +1,323 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// bulkBatchSize is how many records are read per database transaction, which
+// bounds memory use and how long a bulk read blocks persistence
+const bulkBatchSize = 500
+
+// BulkQuery selects persisted records for bulk export
+type BulkQuery struct {
+	Since  time.Time // Zero means unbounded
+	Until  time.Time // Exclusive; zero means unbounded
+	After  string    // Resume after this key (continuation token)
+	Shard  int       // Index of the shard to return, 0 <= Shard < Shards
+	Shards int       // Number of shards; 0 or 1 disables sharding
+	Limit  int       // Maximum records to return; 0 means unlimited
+
+	Annotated bool   // Only patterns carrying annotations
+	Evidence  string // Only patterns classified on this evidence, see models.EvidencePortHeuristic
+	Origin    string // Only patterns of the monitoring host (models.OriginSelf) or of the network (OriginNetwork)
+	Traffic   string // Only patterns of this traffic type, see models.TrafficTypes
+	Interface string // Only patterns, or devices, seen on this interface
+	Snapshots bool   // Add the state snapshots of each device as "snapshots"
+}
+
+// OriginNetwork selects the patterns not of the monitoring host in BulkQuery
+const OriginNetwork = "network"
+
+// BulkResult describes the outcome of a bulk read
+type BulkResult struct {
+	Count        int
+	Continuation string // Key of the last record examined
+	Complete     bool   // False when stopped by Limit
+}
+
+// ShardOf returns the shard a device ID belongs to
+func ShardOf(deviceID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(deviceID)))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func (q BulkQuery) inShard(deviceID string) bool {
+	return q.Shards <= 1 || ShardOf(deviceID, q.Shards) == q.Shard
+}
+
+func (q BulkQuery) inRange(ts time.Time) bool {
+	if !q.Since.IsZero() && ts.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !ts.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// BulkDevices streams persisted devices whose last_seen falls in the query
+// range, and that were seen on the query interface if any
+func (nm *NetworkMonitor) BulkDevices(ctx context.Context, q BulkQuery, emit func(value string) error) (BulkResult, error) {
+	if q.Snapshots {
+		emitDevice := emit
+		emit = func(value string) error {
+			return emitDevice(nm.withSnapshots(value))
+		}
+	}
+	return nm.bulkScan(ctx, q, "", deviceKeyEnd, func(key, value string) bool {
+		var device struct {
+			LastSeen   time.Time `json:"last_seen"`
+			Interface  string    `json:"interface"`
+			Interfaces []string  `json:"interfaces"`
+		}
+		if !isDeviceKey(key) || json.Unmarshal([]byte(value), &device) != nil {
+			return false
+		}
+		if q.Interface != "" && device.Interface != q.Interface && !slices.Contains(device.Interfaces, q.Interface) {
+			return false
+		}
+		return q.inShard(key) && q.inRange(device.LastSeen)
+	}, emit)
+}
+
+// BulkPatterns streams persisted communication patterns observed in the query range
+func (nm *NetworkMonitor) BulkPatterns(ctx context.Context, q BulkQuery, emit func(value string) error) (BulkResult, error) {
+	start := PatternKeyPrefix
+	if !q.Since.IsZero() {
+		start = patternKeyAt(q.Since)
+	}
+	end := PatternKeyPrefix + "~" // Sorts after every pattern key
+	if !q.Until.IsZero() {
+		end = patternKeyAt(q.Until)
+	}
+
+	return nm.bulkScan(ctx, q, start, end, func(key, value string) bool {
+		if q.Shards <= 1 && !q.Annotated && q.Evidence == "" && q.Origin == "" && q.Traffic == "" && q.Interface == "" {
+			return true
+		}
+		var pattern struct {
+			DeviceID    string            `json:"device_id"`
+			SrcMAC      string            `json:"src_mac"`
+			Evidence    string            `json:"evidence"`
+			Origin      string            `json:"origin"`
+			TrafficType string            `json:"traffic_type"`
+			Interface   string            `json:"interface"`
+			Annotations []json.RawMessage `json:"annotations"`
+		}
+		if json.Unmarshal([]byte(value), &pattern) != nil {
+			return false
+		}
+		if q.Annotated && len(pattern.Annotations) == 0 {
+			return false
+		}
+		if q.Evidence != "" && pattern.Evidence != q.Evidence {
+			return false
+		}
+		if q.Origin != "" && (pattern.Origin == models.OriginSelf) != (q.Origin == models.OriginSelf) {
+			return false
+		}
+		if q.Traffic != "" && pattern.TrafficType != q.Traffic {
+			return false
+		}
+		if q.Interface != "" && pattern.Interface != q.Interface {
+			return false
+		}
+		if pattern.DeviceID == "" {
+			pattern.DeviceID = pattern.SrcMAC
+		}
+		return q.inShard(pattern.DeviceID)
+	}, emit)
+}
+
+// withSnapshots adds the snapshots of a persisted device to its record
+func (nm *NetworkMonitor) withSnapshots(value string) string {
+	var device struct {
+		ID  string `json:"id"`
+		MAC string `json:"mac"`
+	}
+	body, ok := strings.CutSuffix(value, "}")
+	if !ok || json.Unmarshal([]byte(value), &device) != nil {
+		return value
+	}
+	if device.ID == "" {
+		device.ID = device.MAC
+	}
+	snapshots, _ := nm.DeviceSnapshots(device.ID)
+	data, err := json.Marshal(snapshots)
+	if err != nil {
+		return value
+	}
+	return body + `,"snapshots":` + string(data) + "}"
+}
+
+// bulkScan walks keys in [start, end) in batches, emitting matching values.
+// Each batch is read in its own transaction so persistence is never blocked
+// for long and memory stays bounded regardless of the result size.
+func (nm *NetworkMonitor) bulkScan(ctx context.Context, q BulkQuery, start, end string,
+	match func(key, value string) bool, emit func(value string) error) (BulkResult, error) {
+
+	result := BulkResult{Continuation: q.After}
+	cursor := start
+	if q.After > cursor {
+		cursor = q.After
+	}
+
+	type record struct{ key, value string }
+	batch := make([]record, 0, bulkBatchSize)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		batch = batch[:0]
+		err := nm.db.View(func(tx *buntdb.Tx) error {
+			return tx.AscendRange("", cursor, end, func(key, value string) bool {
+				if key == q.After {
+					return true // Exclusive resume point
+				}
+				batch = append(batch, record{key, value})
+				return len(batch) < bulkBatchSize
+			})
+		})
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			result.Complete = true
+			return result, nil
+		}
+
+		for _, rec := range batch {
+			if q.Limit > 0 && result.Count >= q.Limit {
+				return result, nil
+			}
+			result.Continuation = rec.key
+
+			if !match(rec.key, rec.value) {
+				continue
+			}
+			if err := emit(rec.value); err != nil {
+				return result, err
+			}
+			result.Count++
+		}
+
+		// Continue strictly after the last key read
+		cursor = batch[len(batch)-1].key + "\x00"
+	}
+}
+
+// syncIndexSlack widens the last_seen index lookup of a device sync. The
+// index compares timestamps as text, which only follows time order for equal
+// UTC offsets, so the lookup starts early and candidates are compared as times.
+const syncIndexSlack = 48 * time.Hour
+
+// SyncCursor marks how far a device sync has progressed, in (last_seen, ID)
+// order. The zero cursor starts from the beginning.
+type SyncCursor struct {
+	LastSeen time.Time
+	ID       string
+}
+
+func (c SyncCursor) before(lastSeen time.Time, id string) bool {
+	return c.LastSeen.Before(lastSeen) || (c.LastSeen.Equal(lastSeen) && c.ID < id)
+}
+
+// SyncResult describes the outcome of a device sync
+type SyncResult struct {
+	Count    int
+	Cursor   SyncCursor // Position after the last device sent
+	Complete bool       // False when stopped by the limit
+}
+
+// SyncDevices streams persisted devices updated after the cursor, oldest
+// last_seen first. A device updated again later moves past the cursor and is
+// sent by a later sync, so periodic syncs from the returned cursor see every
+// change at least once.
+func (nm *NetworkMonitor) SyncDevices(ctx context.Context, after SyncCursor, limit int, emit func(value string) error) (SyncResult, error) {
+	result := SyncResult{Cursor: after}
+
+	type candidate struct {
+		id       string
+		lastSeen time.Time
+	}
+	var candidates []candidate
+	collect := func(key, value string) bool {
+		if !isDeviceKey(key) {
+			return true // Not a device
+		}
+		var device struct {
+			LastSeen time.Time `json:"last_seen"`
+		}
+		if json.Unmarshal([]byte(value), &device) == nil && after.before(device.LastSeen, key) {
+			candidates = append(candidates, candidate{key, device.LastSeen})
+		}
+		return true
+	}
+	err := nm.db.View(func(tx *buntdb.Tx) error {
+		if after.LastSeen.IsZero() {
+			return ascendDevices(tx, collect)
+		}
+		pivot, _ := json.Marshal(map[string]time.Time{"last_seen": after.LastSeen.Add(-syncIndexSlack)})
+		return tx.AscendGreaterOrEqual("last_seen", string(pivot), collect)
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if !a.lastSeen.Equal(b.lastSeen) {
+			return a.lastSeen.Before(b.lastSeen)
+		}
+		return a.id < b.id
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	} else {
+		result.Complete = true
+	}
+
+	// Devices are read again in batches, so the stream carries their latest state
+	for start := 0; start < len(candidates); start += bulkBatchSize {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		batch := candidates[start:min(start+bulkBatchSize, len(candidates))]
+		values := make([]string, len(batch))
+		err := nm.db.View(func(tx *buntdb.Tx) error {
+			for i, c := range batch {
+				value, err := tx.Get(c.id)
+				if err != nil && err != buntdb.ErrNotFound {
+					return err
+				}
+				values[i] = value
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for i, c := range batch {
+			if values[i] != "" {
+				if err := emit(values[i]); err != nil {
+					return result, err
+				}
+				result.Count++
+			}
+			result.Cursor = SyncCursor{LastSeen: c.lastSeen, ID: c.id}
+		}
+	}
+	return result, nil
+}
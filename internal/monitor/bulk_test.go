@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+func TestIsDeviceKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"02:00:00:00:00:0a":            true,
+		"02:00:00:00:00:0A":            true,
+		"ip:203.0.113.9":               true,
+		"ip:2001:db8::1":               true,
+		"ip:":                          false,
+		"ip:printer":                   false,
+		"02:00:00:00:00":               false,
+		"02:00:00:00:00:0a:ff":         false,
+		"0200.0000.000a":               false, // A MAC, but not as device IDs are written
+		"alerts:02:00:00:00:00:0a":     false,
+		"pattern:00000000000000000001": false,
+		"vendor:aliases":               false,
+		"":                             false,
+	} {
+		if got := isDeviceKey(key); got != want {
+			t.Errorf("isDeviceKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+// seedVendor is the vendor of the seeded records, as the IEEE registers it
+const seedVendor = "SAMSUNG ELECTRONICS CO., LTD"
+
+// seedDeviceKeys writes three devices an hour apart from t0, and records of
+// other kinds that hold device-like fields under keys sorting among them
+func seedDeviceKeys(t *testing.T, db *buntdb.DB, t0 time.Time) {
+	t.Helper()
+	records := map[string]time.Time{
+		"02:00:00:00:00:01": t0,
+		"02:00:00:00:00:02": t0.Add(time.Hour),
+		"ip:203.0.113.9":    t0.Add(2 * time.Hour),
+		// Not devices, though they sort before "pattern:" or are in the
+		// last_seen index
+		"0config":                     t0.Add(time.Hour),
+		"alerts:02:00:00:00:00:03":    t0.Add(time.Hour),
+		"ab:cd":                       t0.Add(time.Hour),
+		"ip:printer":                  t0.Add(time.Hour),
+		"transient:02:00:00:00:00:04": t0.Add(time.Hour),
+	}
+	err := db.Update(func(tx *buntdb.Tx) error {
+		for key, lastSeen := range records {
+			data, _ := json.Marshal(map[string]any{"id": key, "mac": key, "last_seen": lastSeen, "vendor": seedVendor})
+			if _, _, err := tx.Set(key, string(data), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deviceIDs collects the IDs of the devices emitted by a bulk read
+func deviceIDs(ids *[]string) func(value string) error {
+	return func(value string) error {
+		var device struct{ ID string }
+		if err := json.Unmarshal([]byte(value), &device); err != nil {
+			return err
+		}
+		*ids = append(*ids, device.ID)
+		return nil
+	}
+}
+
+// Device scans return the devices alone, whatever other records sort among
+// them, within windows that include their start and exclude their end
+func TestBulkDevicesWindow(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	t0 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	seedDeviceKeys(t, nm.db, t0)
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		want         []string
+	}{
+		{"all", time.Time{}, time.Time{}, []string{"02:00:00:00:00:01", "02:00:00:00:00:02", "ip:203.0.113.9"}},
+		{"from the second", t0.Add(time.Hour), time.Time{}, []string{"02:00:00:00:00:02", "ip:203.0.113.9"}},
+		{"just after the second", t0.Add(time.Hour + time.Nanosecond), time.Time{}, []string{"ip:203.0.113.9"}},
+		{"until the second", time.Time{}, t0.Add(time.Hour), []string{"02:00:00:00:00:01"}},
+		{"the second alone", t0.Add(time.Hour), t0.Add(2 * time.Hour), []string{"02:00:00:00:00:02"}},
+		{"empty", t0.Add(time.Hour), t0.Add(time.Hour), nil},
+	}
+	for _, tt := range tests {
+		var ids []string
+		result, err := nm.BulkDevices(context.Background(), BulkQuery{Since: tt.since, Until: tt.until}, deviceIDs(&ids))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(ids, tt.want) || result.Count != len(tt.want) || !result.Complete {
+			t.Errorf("%s: devices %v (%+v), want %v", tt.name, ids, result, tt.want)
+		}
+	}
+}
+
+// Reindexing vendors rewrites the devices alone
+func TestReindexVendorsSkipsOtherRecords(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	seedDeviceKeys(t, nm.db, time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+
+	result, err := nm.ReindexVendors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 3 || result.Changed != 3 {
+		t.Errorf("reindex = %+v, want 3 devices checked and changed", result)
+	}
+	nm.db.View(func(tx *buntdb.Tx) error {
+		for key, want := range map[string]string{"02:00:00:00:00:02": "Samsung", "alerts:02:00:00:00:00:03": seedVendor, "ab:cd": seedVendor} {
+			var record struct{ Vendor string }
+			value, _ := tx.Get(key)
+			if json.Unmarshal([]byte(value), &record); record.Vendor != want {
+				t.Errorf("%s vendor = %q, want %q", key, record.Vendor, want)
+			}
+		}
+		return nil
+	})
+}
+
+// Syncs from the start and from a cursor, which use the last_seen index,
+// return the devices alone in last_seen order
+func TestSyncDevicesSkipsOtherRecords(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	t0 := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	seedDeviceKeys(t, nm.db, t0)
+
+	var ids []string
+	result, err := nm.SyncDevices(context.Background(), SyncCursor{}, 0, deviceIDs(&ids))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"02:00:00:00:00:01", "02:00:00:00:00:02", "ip:203.0.113.9"}; !slices.Equal(ids, want) {
+		t.Errorf("synced %v, want %v", ids, want)
+	}
+
+	ids = nil
+	cursor := SyncCursor{LastSeen: t0, ID: "02:00:00:00:00:01"}
+	if _, err := nm.SyncDevices(context.Background(), cursor, 0, deviceIDs(&ids)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"02:00:00:00:00:02", "ip:203.0.113.9"}; !slices.Equal(ids, want) {
+		t.Errorf("synced after the first %v, want %v", ids, want)
+	}
+	if result.Cursor != (SyncCursor{LastSeen: t0.Add(2 * time.Hour), ID: "ip:203.0.113.9"}) {
+		t.Errorf("cursor = %+v", result.Cursor)
+	}
+}
+
+// A database read back, whether to serve it or to verify a backup of it,
+// holds its devices alone
+func TestReadDevicesSkipsOtherRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cerberus.db")
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedDeviceKeys(t, nm.db, time.Now().Add(-3*time.Hour))
+	var snapshot bytes.Buffer
+	if err := nm.db.Save(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	nm.Close()
+
+	if devices, _, err := verifySnapshot(&snapshot); err != nil || devices != 3 {
+		t.Errorf("verifySnapshot counted %d devices (%v), want 3", devices, err)
+	}
+
+	nm, err = NewReadOnlyNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	ids := nm.Cache.Keys()
+	slices.Sort(ids)
+	if want := []string{"02:00:00:00:00:01", "02:00:00:00:00:02", "ip:203.0.113.9"}; !slices.Equal(ids, want) {
+		t.Errorf("cached %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,131 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// ErrNoCaptureControl is returned when the capture configuration can't be
+// changed because no kernel-side filter is attached
+var ErrNoCaptureControl = errors.New("capture configuration is not available")
+
+// ErrInvalidCaptureConfig is wrapped by errors about the requested capture
+// configuration itself
+var ErrInvalidCaptureConfig = errors.New("invalid capture config")
+
+// CaptureControl writes and reads back the kernel-side capture configuration
+type CaptureControl interface {
+	Apply(config models.CaptureConfig) error
+	Active() (models.CaptureConfig, error)
+	Suppressed() (map[string]uint64, error)
+}
+
+// SetCaptureControl connects the monitor to the kernel-side capture filter,
+// writing the ignore list to it
+func (nm *NetworkMonitor) SetCaptureControl(control CaptureControl) {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+	nm.capture = control
+	nm.syncIgnore()
+}
+
+// ApplyCaptureConfig changes what the eBPF program captures and what
+// TrackEvent accepts. The ring buffer readers keep running; events already
+// queued under the previous configuration are filtered in userspace.
+func (nm *NetworkMonitor) ApplyCaptureConfig(config models.CaptureConfig) (*models.CaptureStatus, error) {
+	types, err := utils.ParseEventTypes(strings.Join(config.Events, ","))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+	}
+	if _, err := utils.EncodeSubnetFilter(config.Subnets); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+	}
+	if _, err := utils.EncodePayloadLimits(config.PayloadBytes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+	}
+	if _, err := utils.EncodeEventPayloadLimits(config.EventPayloadBytes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+	}
+	if _, err := utils.EncodeFlowConfig(config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+	}
+
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	if nm.capture == nil {
+		return nil, ErrNoCaptureControl
+	}
+	if err := nm.capture.Apply(config); err != nil {
+		return nil, err
+	}
+	nm.captureEvents = types
+	nm.updateEnabledEvents()
+	return nm.captureStatus()
+}
+
+// CaptureStatus returns the capture configuration active in the kernel, or
+// ErrNoCaptureControl
+func (nm *NetworkMonitor) CaptureStatus() (*models.CaptureStatus, error) {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+	return nm.captureStatus()
+}
+
+// captureStatus must hold nm.captureMu
+func (nm *NetworkMonitor) captureStatus() (*models.CaptureStatus, error) {
+	if nm.capture == nil {
+		return nil, ErrNoCaptureControl
+	}
+
+	config, err := nm.capture.Active()
+	if err != nil {
+		return nil, err
+	}
+	suppressed, err := nm.capture.Suppressed()
+	if err != nil {
+		return nil, err
+	}
+	return &models.CaptureStatus{
+		Config:     config,
+		Suppressed: suppressed,
+		RingBuffer: nm.ringBufferUsage(config.EventPayloadBytes),
+	}, nil
+}
+
+// ringBufferUsage estimates the event ring buffer traffic of each event type
+// from the packet counters, assuming every event carried its full payload
+// limit. Returns nil when the BPF object doesn't report its limits.
+func (nm *NetworkMonitor) ringBufferUsage(limits map[string]int) map[string]models.RingBufferUsage {
+	if limits == nil {
+		return nil
+	}
+
+	counts := nm.Stats.Snapshot()
+	events := map[uint8]uint64{
+		models.EVENT_TYPE_ARP:  counts.ArpPackets,
+		models.EVENT_TYPE_TCP:  counts.TcpPackets,
+		models.EVENT_TYPE_UDP:  counts.UdpPackets,
+		models.EVENT_TYPE_ICMP: counts.IcmpPackets,
+		models.EVENT_TYPE_DNS:  counts.DnsPackets,
+		models.EVENT_TYPE_HTTP: counts.HttpPackets,
+		models.EVENT_TYPE_TLS:  counts.TlsPackets,
+	}
+
+	usage := make(map[string]models.RingBufferUsage, len(events))
+	for t, n := range events {
+		name := models.EventTypeNames[t]
+		limit := uint64(limits[name])
+		usage[name] = models.RingBufferUsage{
+			Events:       n,
+			Bytes:        n * (utils.EventHeaderSize + limit),
+			PayloadBytes: n * limit,
+			SavedBytes:   n * (utils.EventPayloadMax - limit),
+		}
+	}
+	return usage
+}
@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// fakeCapture stands in for the kernel-side filter, keeping the encoded
+// event_filter map the way the eBPF program would read it
+type fakeCapture struct {
+	filter     map[uint8]uint8
+	suppressed map[string]uint64
+}
+
+func (c *fakeCapture) Apply(config models.CaptureConfig) error {
+	values, err := utils.EncodeEventFilter(config)
+	if err != nil {
+		return err
+	}
+	c.filter = values
+	return nil
+}
+
+func (c *fakeCapture) Active() (models.CaptureConfig, error) {
+	return utils.DecodeEventFilter(c.filter), nil
+}
+
+func (c *fakeCapture) Suppressed() (map[string]uint64, error) {
+	return c.suppressed, nil
+}
+
+// udpEvent returns a UDP datagram from a device to dst:port
+func udpEvent(t testing.TB, mac, src, dst string, port uint16) *models.NetworkEvent {
+	evt := tcpEvent(t, mac, src, dst, port)
+	evt.EventType = models.EVENT_TYPE_UDP
+	evt.Protocol = 17
+	evt.TCPFlags = 0
+	return evt
+}
+
+// The capture configuration changes while events keep flowing, and TrackEvent
+// drops those of types it disabled
+func TestApplyCaptureConfigAtRuntime(t *testing.T) {
+	nm := newTestMonitor(t, 100)
+	if _, err := nm.ApplyCaptureConfig(models.CaptureConfig{Events: []string{"tcp"}}); !errors.Is(err, ErrNoCaptureControl) {
+		t.Fatalf("ApplyCaptureConfig without capture control: %v", err)
+	}
+
+	control := &fakeCapture{suppressed: map[string]uint64{"UDP": 7}}
+	nm.SetCaptureControl(control)
+
+	status, err := nm.ApplyCaptureConfig(models.CaptureConfig{Events: []string{"arp", "tcp", "dns"}, TCPControlOnly: true})
+	if err != nil {
+		t.Fatalf("ApplyCaptureConfig: %v", err)
+	}
+	if len(status.Config.Events) != 3 || !status.Config.TCPControlOnly || status.Suppressed["UDP"] != 7 {
+		t.Errorf("capture status %+v", status)
+	}
+
+	const mac = "02:00:00:00:00:0a"
+	nm.TrackEvent(udpEvent(t, mac, "192.168.1.10", "192.168.1.1", 5353))
+	if got := nm.Stats.FilteredPackets.Load(); got != 1 {
+		t.Errorf("%d filtered packets after a UDP event with UDP disabled, want 1", got)
+	}
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.10", 443))
+	if got := pendingPatternCount(nm); got != 1 {
+		t.Errorf("%d new patterns after a TCP event, want 1", got)
+	}
+
+	if _, err := nm.ApplyCaptureConfig(models.CaptureConfig{Events: []string{"udp", "tcp"}}); err != nil {
+		t.Fatalf("ApplyCaptureConfig: %v", err)
+	}
+	nm.TrackEvent(udpEvent(t, mac, "192.168.1.10", "192.168.1.1", 5353))
+	if got := nm.Stats.FilteredPackets.Load(); got != 1 {
+		t.Errorf("UDP event filtered after enabling UDP")
+	}
+	if got := pendingPatternCount(nm); got != 2 {
+		t.Errorf("%d new patterns after enabling UDP, want 2", got)
+	}
+
+	for _, config := range []models.CaptureConfig{
+		{Events: []string{"smtp"}},
+		{Events: []string{","}},
+		{Subnets: []string{"10.0.0.0"}},
+	} {
+		if _, err := nm.ApplyCaptureConfig(config); !errors.Is(err, ErrInvalidCaptureConfig) {
+			t.Errorf("ApplyCaptureConfig(%+v) = %v, want ErrInvalidCaptureConfig", config, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// ChurnKeyPrefix prefixes the persisted hourly churn counts in the database.
+const ChurnKeyPrefix = "stats:churn:"
+
+// churnKeyFormat names the hour of a churn key; it sorts chronologically
+const churnKeyFormat = "2006-01-02T15"
+
+const (
+	churnInterval    = time.Minute          // How often devices are checked for inactivity and the counts persisted
+	churnBurstWindow = time.Hour            // Window new devices are counted over for a burst
+	maxBurstDevices  = 50                   // New devices listed in a burst anomaly
+	ChurnHistory     = 400 * 24 * time.Hour // How long hourly churn counts are kept
+)
+
+// Churn granularities
+const (
+	ChurnHourly = "hour"
+	ChurnDaily  = "day"
+)
+
+// ErrUnknownGranularity is returned for a churn granularity other than
+// ChurnHourly and ChurnDaily
+var ErrUnknownGranularity = errors.New("unknown granularity")
+
+// ChurnConfig controls the device churn counts
+type ChurnConfig struct {
+	Inactive time.Duration // Silence after which a device has left
+	Burst    int           // New devices within an hour that raise an anomaly (0 disables)
+}
+
+// DefaultChurnConfig returns the default churn settings
+func DefaultChurnConfig() ChurnConfig {
+	return ChurnConfig{Inactive: 24 * time.Hour, Burst: 20}
+}
+
+type churnJoin struct {
+	at time.Time
+	id string
+}
+
+// churnTracker counts devices joining, leaving and coming back per hour. It is
+// guarded by nm.mu.
+type churnTracker struct {
+	config    ChurnConfig
+	active    map[string]time.Time // Device ID -> last seen, for devices that haven't left
+	current   models.ChurnPoint    // The hour in progress
+	finished  []models.ChurnPoint  // Closed hours awaiting the next persist
+	joins     []churnJoin          // New devices within churnBurstWindow, oldest first
+	alertedAt time.Time            // Latest burst anomaly
+}
+
+func newChurnTracker(config ChurnConfig) *churnTracker {
+	return &churnTracker{config: config, active: make(map[string]time.Time)}
+}
+
+// SetChurnConfig replaces the churn settings. Devices inactive by the new
+// setting aren't counted as leaving now.
+func (nm *NetworkMonitor) SetChurnConfig(config ChurnConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.churn.config = config
+
+	now := time.Now()
+	for id, lastSeen := range nm.churn.active {
+		if now.Sub(lastSeen) >= config.Inactive {
+			delete(nm.churn.active, id)
+		}
+	}
+}
+
+func churnKey(start time.Time) string {
+	return ChurnKeyPrefix + start.UTC().Format(churnKeyFormat)
+}
+
+// loadChurn resumes the counts of the current hour and finds the devices that
+// haven't left from their last seen time. Devices that went inactive while
+// cerberus wasn't running aren't counted as leaving.
+func (nm *NetworkMonitor) loadChurn(now time.Time) {
+	c := nm.churn
+	c.current = models.ChurnPoint{Start: now.UTC().Truncate(time.Hour)}
+	cutoff := now.Add(-c.config.Inactive)
+
+	nm.db.View(func(tx *buntdb.Tx) error {
+		if value, err := tx.Get(churnKey(c.current.Start)); err == nil {
+			json.Unmarshal([]byte(value), &c.current)
+		}
+		return ascendDevices(tx, func(key, value string) bool {
+			var device struct {
+				LastSeen time.Time `json:"last_seen"`
+			}
+			if json.Unmarshal([]byte(value), &device) == nil && device.LastSeen.After(cutoff) {
+				c.active[key] = device.LastSeen
+			}
+			return true
+		})
+	})
+}
+
+// observeChurn records traffic from a device. Must hold nm.mu.
+func (nm *NetworkMonitor) observeChurn(deviceID string, isNew bool, now time.Time) {
+	c := nm.churn
+	nm.rollChurn(now)
+
+	_, active := c.active[deviceID]
+	c.active[deviceID] = now
+	switch {
+	case isNew:
+		c.current.Joined++
+		c.joins = append(c.joins, churnJoin{at: now, id: deviceID})
+		nm.checkDeviceBurst(now)
+	case !active:
+		c.current.Reactivated++
+	}
+}
+
+// checkDeviceBurst raises an anomaly when unusually many devices joined within
+// churnBurstWindow, at most once per window. Must hold nm.mu.
+func (nm *NetworkMonitor) checkDeviceBurst(now time.Time) {
+	c := nm.churn
+	drop := 0
+	for drop < len(c.joins) && now.Sub(c.joins[drop].at) > churnBurstWindow {
+		drop++
+	}
+	c.joins = c.joins[drop:]
+
+	if c.config.Burst <= 0 || len(c.joins) < c.config.Burst || now.Sub(c.alertedAt) < churnBurstWindow {
+		return
+	}
+	c.alertedAt = now
+
+	ids := make([]string, 0, min(len(c.joins), maxBurstDevices))
+	for _, join := range c.joins[:min(len(c.joins), maxBurstDevices)] {
+		ids = append(ids, join.id)
+	}
+	nm.raiseAnomaly("NEW_DEVICE_BURST", models.SeverityInfo, "",
+		map[string]string{
+			"count":  strconv.Itoa(len(c.joins)),
+			"window": churnBurstWindow.String(),
+		},
+		map[string]string{
+			"count":   strconv.Itoa(len(c.joins)),
+			"window":  churnBurstWindow.String(),
+			"devices": strings.Join(ids, ","),
+		})
+}
+
+// sweepChurn counts the devices silent for the inactivity period as leaving.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) sweepChurn(now time.Time) {
+	c := nm.churn
+	for id, lastSeen := range c.active {
+		if now.Sub(lastSeen) >= c.config.Inactive {
+			delete(c.active, id)
+			c.current.Left++
+		}
+	}
+}
+
+// rollChurn closes the hours that ended before now. Must hold nm.mu.
+func (nm *NetworkMonitor) rollChurn(now time.Time) {
+	c := nm.churn
+	// Hours without a worker tick, e.g. after the clock jumped, are skipped
+	if now.Sub(c.current.Start) > ChurnHistory {
+		c.current = models.ChurnPoint{Start: now.UTC().Truncate(time.Hour)}
+		return
+	}
+	for end := c.current.Start.Add(time.Hour); !now.Before(end); end = end.Add(time.Hour) {
+		nm.sweepChurn(end)
+		c.current.Active = len(c.active)
+		c.finished = append(c.finished, c.current)
+		c.current = models.ChurnPoint{Start: end}
+	}
+}
+
+// churnTick counts inactive devices and persists the hours, every
+// churnInterval
+func (nm *NetworkMonitor) churnTick(now time.Time) {
+	nm.lockAll()
+	nm.rollChurn(now)
+	nm.sweepChurn(now)
+	c := nm.churn
+	c.current.Active = len(c.active)
+	points := append(c.finished, c.current)
+	c.finished = nil
+	nm.unlockAll()
+
+	cutoff := churnKey(now.Add(-ChurnHistory))
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		for _, point := range points {
+			data, err := json.Marshal(point)
+			if err != nil {
+				return err
+			}
+			if _, _, err := tx.Set(churnKey(point.Start), string(data), nil); err != nil {
+				return err
+			}
+		}
+
+		var expired []string
+		tx.AscendRange("", ChurnKeyPrefix, cutoff, func(key, _ string) bool {
+			expired = append(expired, key)
+			return true
+		})
+		for _, key := range expired {
+			tx.Delete(key)
+		}
+		return nil
+	})
+	if err != nil {
+		nm.Stats.FailedPersists.Add(1)
+	}
+}
+
+// ChurnSeries returns the device churn per hour or day (UTC) over the window
+// ending now, oldest first. The last point is the period in progress.
+func (nm *NetworkMonitor) ChurnSeries(granularity string, window time.Duration) ([]models.ChurnPoint, error) {
+	if granularity != ChurnHourly && granularity != ChurnDaily {
+		return nil, fmt.Errorf("%w %q, expected %s or %s", ErrUnknownGranularity, granularity, ChurnHourly, ChurnDaily)
+	}
+	now := time.Now()
+	from := now.Add(-window).UTC().Truncate(time.Hour)
+	if granularity == ChurnDaily {
+		from = now.Add(-window).UTC().Truncate(24 * time.Hour)
+	}
+
+	nm.lockAll()
+	nm.rollChurn(now)
+	current := nm.churn.current
+	current.Active = len(nm.churn.active)
+	pending := append([]models.ChurnPoint(nil), nm.churn.finished...)
+	nm.unlockAll()
+
+	var hours []models.ChurnPoint
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", churnKey(from), ChurnKeyPrefix+"~", func(key, value string) bool {
+			var point models.ChurnPoint
+			if json.Unmarshal([]byte(value), &point) == nil && point.Start.Before(current.Start) {
+				hours = append(hours, point)
+			}
+			return true
+		})
+	})
+	// Hours closed since the last persist replace their stored partial counts
+	for _, point := range pending {
+		if n := len(hours); n > 0 && hours[n-1].Start.Equal(point.Start) {
+			hours = hours[:n-1]
+		}
+		if !point.Start.Before(from) {
+			hours = append(hours, point)
+		}
+	}
+	hours = append(hours, current)
+
+	if granularity == ChurnHourly {
+		return hours, nil
+	}
+	return churnDays(hours), nil
+}
+
+// churnDays sums hourly churn into days. The active count of a day is that at
+// its end, or now for today.
+func churnDays(hours []models.ChurnPoint) []models.ChurnPoint {
+	var days []models.ChurnPoint
+	for _, hour := range hours {
+		start := hour.Start.Truncate(24 * time.Hour)
+		if n := len(days); n == 0 || !days[n-1].Start.Equal(start) {
+			days = append(days, models.ChurnPoint{Start: start})
+		}
+		day := &days[len(days)-1]
+		day.Joined += hour.Joined
+		day.Left += hour.Left
+		day.Reactivated += hour.Reactivated
+		day.Active = hour.Active
+	}
+	return days
+}
+
+// LatestChurn returns the churn of the current hour and day so far
+func (nm *NetworkMonitor) LatestChurn() models.ChurnSummary {
+	var summary models.ChurnSummary
+	if hours, err := nm.ChurnSeries(ChurnHourly, 24*time.Hour); err == nil {
+		summary.Hour = hours[len(hours)-1]
+		days := churnDays(hours)
+		summary.Day = days[len(days)-1]
+	}
+	return summary
+}
@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Every classification carries the evidence it rests on
+func TestClassifyEventEvidence(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+
+	event := func(eventType uint8, port uint16, flags uint8, payload string) *models.NetworkEvent {
+		evt := tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", port)
+		evt.EventType, evt.TCPFlags, evt.L7Payload = eventType, flags, []byte(payload)
+		if eventType == models.EVENT_TYPE_UDP || eventType == models.EVENT_TYPE_DNS {
+			evt.Protocol = 17
+		}
+		return evt
+	}
+	arp := func(src, dst string, op uint16) *models.NetworkEvent {
+		return &models.NetworkEvent{EventType: models.EVENT_TYPE_ARP, SrcIP: beIP(t, src), DstIP: beIP(t, dst), ArpOp: op}
+	}
+	icmp := func(icmpType uint8) *models.NetworkEvent {
+		return &models.NetworkEvent{EventType: models.EVENT_TYPE_ICMP, Protocol: 1, ICMPType: icmpType}
+	}
+
+	tests := []struct {
+		name     string
+		evt      *models.NetworkEvent
+		traffic  models.TrafficType
+		evidence string
+		class    string
+	}{
+		{"https port", event(models.EVENT_TYPE_TCP, 443, 0x10, ""), models.TrafficTCPHTTPS, models.EvidencePortHeuristic, models.ServiceClassPort},
+		{"ssh port over syn", event(models.EVENT_TYPE_TCP, 22, 0x02, ""), models.TrafficTCPSSH, models.EvidencePortHeuristic, models.ServiceClassPort},
+		{"syn", event(models.EVENT_TYPE_TCP, 5000, 0x02, ""), models.TrafficTCPSYN, models.EvidenceFlagBased, models.ServiceClassPort},
+		{"syn-ack", event(models.EVENT_TYPE_TCP, 5000, 0x12, ""), models.TrafficTCPSYNACK, models.EvidenceFlagBased, models.ServiceClassPort},
+		{"rst", event(models.EVENT_TYPE_TCP, 5000, 0x04, ""), models.TrafficTCPRST, models.EvidenceFlagBased, models.ServiceClassPort},
+		{"no flags", event(models.EVENT_TYPE_TCP, 5000, 0, ""), models.TrafficTCPCustom, models.EvidenceFallback, models.ServiceClassPort},
+		{"ntp port", event(models.EVENT_TYPE_UDP, 123, 0, ""), models.TrafficUDPNTP, models.EvidencePortHeuristic, models.ServiceClassPort},
+		{"udp", event(models.EVENT_TYPE_UDP, 40001, 0, ""), models.TrafficUDPCustom, models.EvidenceFallback, models.ServiceClassUnknown},
+		{"dns query", event(models.EVENT_TYPE_DNS, 53, 0, "\x12\x34\x01\x00"), models.TrafficDNSQuery, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"dns response", event(models.EVENT_TYPE_DNS, 53, 0, "\x12\x34\x81\x80"), models.TrafficDNSResponse, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"dns without payload", event(models.EVENT_TYPE_DNS, 53, 0, ""), models.TrafficDNSQuery, models.EvidenceFallback, models.ServiceClassPort},
+		{"http get", event(models.EVENT_TYPE_HTTP, 80, 0x18, "GET / HTTP/1.1"), models.TrafficHTTPGET, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"http post", event(models.EVENT_TYPE_HTTP, 8080, 0x18, "POST /login"), models.TrafficHTTPPOST, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"http other", event(models.EVENT_TYPE_HTTP, 80, 0x18, "PUT /file"), models.TrafficHTTPRequest, models.EvidenceFallback, models.ServiceClassPort},
+		{"client hello", event(models.EVENT_TYPE_TLS, 443, 0x18, "\x16\x03\x01\x00\x10\x01"), models.TrafficTLSClientHello, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"server hello", event(models.EVENT_TYPE_TLS, 443, 0x18, "\x16\x03\x03\x00\x10\x02"), models.TrafficTLSServerHello, models.EvidenceL7Detected, models.ServiceClassL7},
+		{"tls record", event(models.EVENT_TYPE_TLS, 443, 0x18, "\x17\x03\x03"), models.TrafficTLSHandshake, models.EvidenceFallback, models.ServiceClassPort},
+		{"arp probe", arp("0.0.0.0", "192.168.1.20", 1), models.TrafficARPProbe, models.EvidenceHeaderField, models.ServiceClassProtocol},
+		{"arp announce", arp("192.168.1.20", "192.168.1.20", 1), models.TrafficARPAnnounce, models.EvidenceHeaderField, models.ServiceClassProtocol},
+		{"arp reply", arp("192.168.1.20", "192.168.1.10", 2), models.TrafficARPReply, models.EvidenceHeaderField, models.ServiceClassProtocol},
+		{"arp unknown op", arp("192.168.1.20", "192.168.1.10", 9), models.TrafficARPRequest, models.EvidenceFallback, models.ServiceClassProtocol},
+		{"echo request", icmp(8), models.TrafficICMPEchoRequest, models.EvidenceHeaderField, models.ServiceClassProtocol},
+		{"icmp other", icmp(42), models.TrafficICMPCustom, models.EvidenceFallback, models.ServiceClassProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcIP, dstIP := utils.IPFromBEUint32(tt.evt.SrcIP).String(), utils.IPFromBEUint32(tt.evt.DstIP).String()
+			traffic, evidence, _, _, class, _ := nm.classifyEvent(tt.evt, srcIP, dstIP)
+			if traffic != tt.traffic || evidence != tt.evidence || class != tt.class {
+				t.Errorf("classified %s on %s (%s), want %s on %s (%s)", traffic, evidence, class, tt.traffic, tt.evidence, tt.class)
+			}
+		})
+	}
+}
+
+// Devices count their events per evidence, patterns keep theirs, and bulk
+// exports filter on it
+func TestEvidenceTracked(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 443))
+	syn := tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 5000)
+	syn.TCPFlags = 0x02
+	nm.TrackEvent(syn)
+
+	device, ok := nm.GetDevice(mac)
+	if !ok {
+		t.Fatal("device not tracked")
+	}
+	if device.EvidenceCounts[models.EvidencePortHeuristic] != 2 || device.EvidenceCounts[models.EvidenceFlagBased] != 1 {
+		t.Errorf("evidence counts = %v, want 2 port-heuristic and 1 flag-based", device.EvidenceCounts)
+	}
+
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for evidence, want := range map[string]int{models.EvidencePortHeuristic: 1, models.EvidenceFlagBased: 1, models.EvidenceL7Detected: 0} {
+		var patterns []models.CommunicationPattern
+		_, err := nm.BulkPatterns(context.Background(), BulkQuery{Evidence: evidence}, func(value string) error {
+			var pattern models.CommunicationPattern
+			if err := json.Unmarshal([]byte(value), &pattern); err != nil {
+				return err
+			}
+			patterns = append(patterns, pattern)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patterns) != want {
+			t.Errorf("%d %s patterns, want %d", len(patterns), evidence, want)
+		}
+		for _, pattern := range patterns {
+			if pattern.Evidence != evidence {
+				t.Errorf("pattern %+v listed for evidence %s", pattern, evidence)
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Contact kinds queried with Contacts
+const (
+	ContactDestination = "destination" // Destination IP
+	ContactDomain      = "domain"      // DNS query, HTTP Host header or TLS SNI
+	ContactPort        = "port"        // TCP or UDP destination port
+)
+
+// ContactHistory is how long a device is remembered to have reached a
+// destination, domain or port after it last did
+const ContactHistory = 30 * 24 * time.Hour
+
+// contactMaxPorts bounds the ports recorded per device and destination
+const contactMaxPorts = 16
+
+// ErrInvalidContactQuery is returned by Contacts for a malformed value
+var ErrInvalidContactQuery = errors.New("invalid contact query")
+
+type contactKey struct {
+	kind  string
+	value string
+}
+
+type contact struct {
+	count     int
+	firstSeen time.Time
+	lastSeen  time.Time
+	via       []string
+	ports     []uint16
+}
+
+// contactIndex maps every destination IP, domain and destination port to the
+// devices that reached it, so "who touched this IP" doesn't scan every
+// device. It is guarded by nm.mu.
+type contactIndex struct {
+	contacts map[contactKey]map[string]*contact // Key -> device ID -> contact
+}
+
+func newContactIndex() *contactIndex {
+	return &contactIndex{contacts: make(map[contactKey]map[string]*contact)}
+}
+
+// normalizeDomain lowers a domain and drops its trailing dot
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+// transport returns the transport protocol of a pattern protocol, or "" for
+// protocols without ports
+func transport(protocol string) string {
+	switch protocol {
+	case "TCP", "HTTP", "TLS":
+		return "TCP"
+	case "UDP", "DNS":
+		return "UDP"
+	}
+	return ""
+}
+
+// observe counts count contacts of a device with a key at the given time
+func (idx *contactIndex) observe(key contactKey, deviceID, via string, port uint16, count int, at time.Time) {
+	if key.value == "" {
+		return
+	}
+	devices := idx.contacts[key]
+	if devices == nil {
+		devices = make(map[string]*contact)
+		idx.contacts[key] = devices
+	}
+	c := devices[deviceID]
+	if c == nil {
+		c = &contact{firstSeen: at, lastSeen: at}
+		devices[deviceID] = c
+	}
+
+	c.count += count
+	if at.Before(c.firstSeen) {
+		c.firstSeen = at
+	}
+	if at.After(c.lastSeen) {
+		c.lastSeen = at
+	}
+	if via != "" && !slices.Contains(c.via, via) {
+		c.via = append(c.via, via)
+	}
+	if port != 0 && len(c.ports) < contactMaxPorts && !slices.Contains(c.ports, port) {
+		c.ports = append(c.ports, port)
+	}
+}
+
+// observeTraffic records a device reaching dstIP on dstPort, and the domain it
+// named on the way, if any. via is how the domain was named: dns or tls.
+func (idx *contactIndex) observeTraffic(deviceID, dstIP string, dstPort uint16, protocol, domain, via string, at time.Time) {
+	proto := transport(protocol)
+	if dstIP != "0.0.0.0" {
+		idx.observe(contactKey{ContactDestination, dstIP}, deviceID, proto, dstPort, 1, at)
+	}
+	if proto != "" && dstPort != 0 {
+		idx.observe(contactKey{ContactPort, strconv.Itoa(int(dstPort))}, deviceID, proto, 0, 1, at)
+	}
+	if domain != "" {
+		idx.observe(contactKey{ContactDomain, normalizeDomain(domain)}, deviceID, via, 0, 1, at)
+	}
+}
+
+// observePattern records a persisted pattern as one contact
+func (idx *contactIndex) observePattern(pattern *models.CommunicationPattern) {
+	var domain, via string
+	switch pattern.Protocol {
+	case "DNS":
+		domain, via = pattern.L7Info, "dns"
+	case "TLS":
+		domain, via = pattern.L7Info, "tls"
+	}
+	idx.observeTraffic(pattern.DeviceID, pattern.DstIP, pattern.DstPort, pattern.Protocol, domain, via, pattern.Timestamp)
+}
+
+// removeDevice drops every contact of a device
+func (idx *contactIndex) removeDevice(deviceID string) {
+	for key, devices := range idx.contacts {
+		delete(devices, deviceID)
+		if len(devices) == 0 {
+			delete(idx.contacts, key)
+		}
+	}
+}
+
+// renameDevice moves the contacts of one device identity onto another
+func (idx *contactIndex) renameDevice(from, to string) {
+	for _, devices := range idx.contacts {
+		c := devices[from]
+		if c == nil {
+			continue
+		}
+		delete(devices, from)
+
+		merged := devices[to]
+		if merged == nil {
+			devices[to] = c
+			continue
+		}
+		merged.count += c.count
+		if c.firstSeen.Before(merged.firstSeen) {
+			merged.firstSeen = c.firstSeen
+		}
+		if c.lastSeen.After(merged.lastSeen) {
+			merged.lastSeen = c.lastSeen
+		}
+		for _, via := range c.via {
+			if !slices.Contains(merged.via, via) {
+				merged.via = append(merged.via, via)
+			}
+		}
+		for _, port := range c.ports {
+			if len(merged.ports) < contactMaxPorts && !slices.Contains(merged.ports, port) {
+				merged.ports = append(merged.ports, port)
+			}
+		}
+	}
+}
+
+// prune forgets contacts last seen more than ContactHistory ago
+func (idx *contactIndex) prune(now time.Time) {
+	for key, devices := range idx.contacts {
+		for id, c := range devices {
+			if now.Sub(c.lastSeen) > ContactHistory {
+				delete(devices, id)
+			}
+		}
+		if len(devices) == 0 {
+			delete(idx.contacts, key)
+		}
+	}
+}
+
+// size returns the number of device contacts held
+func (idx *contactIndex) size() int {
+	n := 0
+	for _, devices := range idx.contacts {
+		n += len(devices)
+	}
+	return n
+}
+
+// loadContacts seeds the contact index with the patterns persisted within
+// ContactHistory, so a restart doesn't forget who reached what
+func (nm *NetworkMonitor) loadContacts() {
+	start := patternKeyAt(time.Now().Add(-ContactHistory))
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", start, PatternKeyPrefix+"~", func(key, value string) bool {
+			var pattern models.CommunicationPattern
+			if json.Unmarshal([]byte(value), &pattern) == nil && pattern.DeviceID != "" {
+				nm.contacts.observePattern(&pattern)
+			}
+			return true
+		})
+	})
+}
+
+// parseContactValue validates and normalizes the value of a contact query
+func parseContactValue(kind, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case ContactDestination:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", fmt.Errorf("%w: %q is not an IP address", ErrInvalidContactQuery, value)
+		}
+		return ip.String(), nil
+	case ContactDomain:
+		domain := normalizeDomain(value)
+		if domain == "" || strings.ContainsAny(domain, " /") {
+			return "", fmt.Errorf("%w: %q is not a domain", ErrInvalidContactQuery, value)
+		}
+		return domain, nil
+	case ContactPort:
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("%w: %q is not a port", ErrInvalidContactQuery, value)
+		}
+		return strconv.Itoa(port), nil
+	}
+	return "", fmt.Errorf("%w: unknown kind %q", ErrInvalidContactQuery, kind)
+}
+
+// Contacts returns the devices that reached a destination IP, domain or
+// destination port, most recent first. Contacts last seen before since are
+// left out when since is set. With subdomains, a domain query also matches
+// every name under it.
+func (nm *NetworkMonitor) Contacts(kind, value string, since time.Time, subdomains bool) (*models.ContactQuery, error) {
+	value, err := parseContactValue(kind, value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.ContactQuery{Kind: kind, Value: value, Contacts: []models.DeviceContact{}}
+	if !since.IsZero() {
+		result.Since = &since
+	}
+	devices := make(map[string]bool)
+
+	nm.mu.RLock()
+	collect := func(key contactKey) {
+		for id, c := range nm.contacts.contacts[key] {
+			if c.lastSeen.Before(since) {
+				continue
+			}
+			found := models.DeviceContact{
+				DeviceID:  id,
+				Count:     c.count,
+				FirstSeen: c.firstSeen,
+				LastSeen:  c.lastSeen,
+				Via:       slices.Clone(c.via),
+				Ports:     slices.Clone(c.ports),
+			}
+			if key.value != value {
+				found.Domain = key.value
+			}
+			if device, ok := nm.Cache.Peek(id); ok {
+				found.IP, found.Name = device.IP, device.Name
+			}
+			devices[id] = true
+			result.Contacts = append(result.Contacts, found)
+		}
+	}
+	if kind == ContactDomain && subdomains {
+		for key := range nm.contacts.contacts {
+			if key.kind == ContactDomain && (key.value == value || strings.HasSuffix(key.value, "."+value)) {
+				collect(key)
+			}
+		}
+	} else {
+		collect(contactKey{kind, value})
+	}
+	nm.mu.RUnlock()
+
+	// Devices no longer cached are read back for their address and name
+	for i := range result.Contacts {
+		found := &result.Contacts[i]
+		if found.IP != "" {
+			continue
+		}
+		if device := nm.loadDevice(found.DeviceID); device != nil {
+			found.IP, found.Name = device.IP, device.Name
+		}
+	}
+
+	sort.Slice(result.Contacts, func(i, j int) bool {
+		a, b := result.Contacts[i], result.Contacts[j]
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.After(b.LastSeen)
+		}
+		if a.DeviceID != b.DeviceID {
+			return a.DeviceID < b.DeviceID
+		}
+		return a.Domain < b.Domain
+	})
+	result.Devices = len(devices)
+	return result, nil
+}
@@ -0,0 +1,326 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// MaxDecisionAudit bounds how long a decision audit runs
+const MaxDecisionAudit = 24 * time.Hour
+
+// DefaultDecisionAudit is how long a decision audit runs when not told
+const DefaultDecisionAudit = time.Hour
+
+// maxDecisions bounds the decisions kept per device; the oldest is dropped
+// first
+const maxDecisions = 100
+
+// maxAuditedDevices bounds the devices decisions are kept for; an audit of
+// the whole network stops recording new devices beyond it
+const maxAuditedDevices = 500
+
+// ErrAuditNotFound is returned when stopping an audit that is not running
+var ErrAuditNotFound = errors.New("no decision audit running")
+
+// Outcomes of a detector on a pattern
+const (
+	OutcomeSkipped    = "skipped"     // The detector does not apply to the pattern
+	OutcomeNotMatched = "not_matched" // It evaluated the pattern and its condition did not hold
+	OutcomeMatched    = "matched"     // Its condition held
+)
+
+// Reasons for verdicts that hold whatever the detector
+const (
+	reasonDropped     = "anomaly dropped: device muted or monitoring host excluded"
+	reasonExempt      = "exempted by a suppression rule"
+	reasonNotExternal = "destination not external"
+	reasonExcluded    = "monitoring host traffic excluded"
+)
+
+// verdict is the outcome of a detector on one new pattern. It is built on
+// every pattern, so it holds only constants and numbers; it is turned into a
+// models.DetectorDecision only for audited devices.
+type verdict struct {
+	outcome   string
+	reason    string
+	value     float64 // What was compared with threshold, when compared is set
+	threshold float64
+	compared  bool
+	anomaly   *models.Anomaly
+}
+
+func skipped(reason string) verdict {
+	return verdict{outcome: OutcomeSkipped, reason: reason}
+}
+
+func notMatched(reason string) verdict {
+	return verdict{outcome: OutcomeNotMatched, reason: reason}
+}
+
+// compare returns a verdict with the values its condition compared
+func (v verdict) compare(value, threshold float64) verdict {
+	v.value, v.threshold, v.compared = value, threshold, true
+	return v
+}
+
+// raised returns the verdict of a detector whose condition held, explaining a
+// nil anomaly as dropped
+func raised(reason string, anomaly *models.Anomaly) verdict {
+	if anomaly == nil {
+		reason = reasonDropped
+	}
+	return verdict{outcome: OutcomeMatched, reason: reason, anomaly: anomaly}
+}
+
+// patternCheck is what detectors see of a new pattern. They may annotate or
+// complete the pattern.
+type patternCheck struct {
+	device   *models.DeviceInfo
+	pattern  *models.CommunicationPattern
+	external bool
+	exempt   bool
+	excluded bool
+	threats  verdict // Threat lists are matched on every event, before the pattern exists
+	now      time.Time
+}
+
+type patternDetector struct {
+	name string
+	run  func(nm *NetworkMonitor, check *patternCheck) verdict
+}
+
+// patternDetectors judge every new pattern, in order. A detector of patterns
+// registers here, which also gets its verdicts into decision audits.
+var patternDetectors = []patternDetector{
+	{"threat_intel", func(nm *NetworkMonitor, check *patternCheck) verdict { return check.threats }},
+	{"fleet", (*NetworkMonitor).detectFleet},
+	{"direct_ip", (*NetworkMonitor).detectDirectIP},
+	{"egress", (*NetworkMonitor).detectEgress},
+	{"novel_destination", (*NetworkMonitor).detectNovelDestination},
+	{"first_contact", (*NetworkMonitor).detectFirstContact},
+}
+
+// detectPattern runs the pattern detectors on a new pattern, annotating it
+// with the anomalies they raise, and records their verdicts for audited
+// devices. Must hold nm.mu.
+func (nm *NetworkMonitor) detectPattern(check *patternCheck, suppressed *suppressionRule) {
+	var decision *models.Decision
+	if nm.auditing.Load() {
+		decision = nm.auditedDecision(check, suppressed)
+	}
+
+	for _, detector := range patternDetectors {
+		v := detector.run(nm, check)
+		if v.anomaly != nil {
+			check.pattern.Annotations, _ = addAnnotation(check.pattern.Annotations,
+				models.Annotation{Type: models.AnnotationAnomaly, ID: v.anomaly.ID})
+		}
+		if decision != nil {
+			decision.Detectors = append(decision.Detectors, v.decision(detector.name))
+		}
+	}
+
+	if decision != nil {
+		nm.decisions.record(check.device.ID, decision)
+	}
+}
+
+// decision describes a verdict
+func (v verdict) decision(detector string) models.DetectorDecision {
+	d := models.DetectorDecision{Detector: detector, Outcome: v.outcome, Reason: v.reason}
+	if v.compared {
+		value, threshold := v.value, v.threshold
+		d.Value, d.Threshold = &value, &threshold
+	}
+	if v.anomaly != nil {
+		d.AnomalyID = v.anomaly.ID
+	}
+	return d
+}
+
+// decisionAudit keeps the verdicts of the detectors on the patterns of
+// audited devices. It is guarded by nm.mu; nm.auditing tells whether any
+// audit may be running without taking it.
+type decisionAudit struct {
+	global    time.Time            // The whole network is audited until then
+	devices   map[string]time.Time // Device ID -> audited until
+	decisions map[string][]models.Decision
+}
+
+func newDecisionAudit() *decisionAudit {
+	return &decisionAudit{
+		devices:   make(map[string]time.Time),
+		decisions: make(map[string][]models.Decision),
+	}
+}
+
+// until returns when the audit of a device ends, zero when not audited
+func (a *decisionAudit) until(deviceID string, now time.Time) time.Time {
+	until := a.devices[deviceID]
+	if a.global.After(until) {
+		until = a.global
+	}
+	if !until.After(now) {
+		return time.Time{}
+	}
+	return until
+}
+
+// active reports whether any audit is still running
+func (a *decisionAudit) active(now time.Time) bool {
+	if a.global.After(now) {
+		return true
+	}
+	for id, until := range a.devices {
+		if until.After(now) {
+			return true
+		}
+		delete(a.devices, id)
+	}
+	return false
+}
+
+// record keeps a decision for a device, dropping its oldest beyond
+// maxDecisions
+func (a *decisionAudit) record(deviceID string, decision *models.Decision) {
+	decisions, ok := a.decisions[deviceID]
+	if !ok && len(a.decisions) >= maxAuditedDevices {
+		return
+	}
+	if len(decisions) >= maxDecisions {
+		decisions = append(decisions[:0], decisions[len(decisions)-maxDecisions+1:]...)
+	}
+	a.decisions[deviceID] = append(decisions, *decision)
+}
+
+// auditedDecision returns the decision to fill for a new pattern of an
+// audited device, or nil. It notices when every audit has ended. Must hold
+// nm.mu.
+func (nm *NetworkMonitor) auditedDecision(check *patternCheck, suppressed *suppressionRule) *models.Decision {
+	if !nm.decisions.active(check.now) {
+		nm.auditing.Store(false)
+		return nil
+	}
+	if nm.decisions.until(check.device.ID, check.now).IsZero() {
+		return nil
+	}
+
+	pattern := check.pattern
+	decision := &models.Decision{
+		PatternID:   pattern.ID,
+		DstIP:       pattern.DstIP,
+		DstPort:     pattern.DstPort,
+		Protocol:    pattern.Protocol,
+		TrafficType: pattern.TrafficType,
+		L7Info:      pattern.L7Info,
+		External:    check.external,
+		Excluded:    check.excluded,
+		Timestamp:   check.now,
+	}
+	if suppressed != nil {
+		decision.Suppression = suppressed.ID
+		decision.SuppressionMode = suppressed.Mode
+	}
+	return decision
+}
+
+// AuditDecisions records the verdicts of the detectors on the new patterns of
+// a device, or of every device when id is empty, for ttl (at most
+// MaxDecisionAudit). An audit already running is extended or shortened.
+func (nm *NetworkMonitor) AuditDecisions(id string, ttl time.Duration) (models.DecisionAudit, error) {
+	if ttl <= 0 || ttl > MaxDecisionAudit {
+		return models.DecisionAudit{}, fmt.Errorf("invalid ttl: expected a positive duration up to %s", MaxDecisionAudit)
+	}
+	if id != "" {
+		device, err := nm.muteTarget(id)
+		if err != nil {
+			return models.DecisionAudit{}, err
+		}
+		id = device
+	}
+
+	now := time.Now()
+	nm.lockAll()
+	defer nm.unlockAll()
+	if id == "" {
+		nm.decisions.global = now.Add(ttl)
+	} else {
+		nm.decisions.devices[id] = now.Add(ttl)
+	}
+	nm.auditing.Store(true)
+	return nm.decisionAuditLocked(now), nil
+}
+
+// StopDecisionAudit ends the audit of a device, or of the whole network when
+// id is empty. The decisions recorded are kept.
+func (nm *NetworkMonitor) StopDecisionAudit(id string) error {
+	if id != "" {
+		device, err := nm.muteTarget(id)
+		if err != nil {
+			return err
+		}
+		id = device
+	}
+
+	now := time.Now()
+	nm.lockAll()
+	defer nm.unlockAll()
+	if id == "" {
+		if !nm.decisions.global.After(now) {
+			return ErrAuditNotFound
+		}
+		nm.decisions.global = time.Time{}
+	} else {
+		if until, ok := nm.decisions.devices[id]; !ok || !until.After(now) {
+			return ErrAuditNotFound
+		}
+		delete(nm.decisions.devices, id)
+	}
+	nm.auditing.Store(nm.decisions.active(now))
+	return nil
+}
+
+// DecisionAudit returns the audits running
+func (nm *NetworkMonitor) DecisionAudit() models.DecisionAudit {
+	nm.lockAll()
+	defer nm.unlockAll()
+	return nm.decisionAuditLocked(time.Now())
+}
+
+// decisionAuditLocked describes the audits running. Must hold nm.mu.
+func (nm *NetworkMonitor) decisionAuditLocked(now time.Time) models.DecisionAudit {
+	audit := models.DecisionAudit{Devices: []models.DeviceAudit{}}
+	if nm.decisions.global.After(now) {
+		global := nm.decisions.global
+		audit.Global = &global
+	}
+	for id, until := range nm.decisions.devices {
+		if until.After(now) {
+			audit.Devices = append(audit.Devices, models.DeviceAudit{Device: id, Until: until})
+		}
+	}
+	sort.Slice(audit.Devices, func(i, j int) bool { return audit.Devices[i].Device < audit.Devices[j].Device })
+	return audit
+}
+
+// DeviceDecisions returns the decisions recorded for a device, newest first,
+// and when its audit ends (nil when not audited)
+func (nm *NetworkMonitor) DeviceDecisions(id string) models.DeviceDecisions {
+	now := time.Now()
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	recorded := nm.decisions.decisions[id]
+	result := models.DeviceDecisions{Device: id, Decisions: make([]models.Decision, 0, len(recorded))}
+	if until := nm.decisions.until(id, now); !until.IsZero() {
+		result.AuditedUntil = &until
+	}
+	for i := len(recorded) - 1; i >= 0; i-- {
+		result.Decisions = append(result.Decisions, recorded[i])
+	}
+	return result
+}
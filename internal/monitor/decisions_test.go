@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// An audit of one device records, for each of its new patterns, the verdict
+// of every detector with the value and threshold it compared: here the fleet
+// detector adopting at 2 devices of a vendor and the direct-IP detector at 3
+// unresolved destinations. Devices not audited record nothing.
+func TestDecisionAudit(t *testing.T) {
+	nm := newTestMonitor(t, 64)
+	nm.SetFleetConfig(FleetConfig{MinDevices: 2, Window: time.Hour})
+	nm.SetDirectIPConfig(DirectIPConfig{Window: 10 * time.Minute, MaxUnresolved: 3})
+
+	audited, other := "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+	nm.TrackEvent(tcpEvent(t, audited, "192.168.1.10", "192.168.1.20", 445))
+	nm.TrackEvent(tcpEvent(t, other, "192.168.1.11", "192.168.1.20", 445))
+
+	// Both devices are past their learning periods, as is their vendor
+	nm.lockAll()
+	for _, id := range []string{audited, other} {
+		device, ok := nm.Cache.Peek(id)
+		if !ok {
+			nm.unlockAll()
+			t.Fatalf("device %s not tracked", id)
+		}
+		device.Vendor = "Acme"
+		device.FirstSeen = device.FirstSeen.Add(-2 * time.Hour)
+	}
+	nm.fleet.groups["acme"] = &fleetGroup{created: time.Now().Add(-2 * time.Hour), destinations: make(map[string]*fleetDestination)}
+	nm.unlockAll()
+
+	if _, err := nm.AuditDecisions(audited, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		outcome          string
+		value, threshold float64 // Unset when the detector compared nothing
+		anomaly          bool
+	}
+	steps := []struct {
+		mac, dst        string
+		fleet, directIP want
+	}{
+		{audited, "192.168.1.30", want{outcome: OutcomeSkipped}, want{outcome: OutcomeSkipped}},
+		{audited, "151.101.1.1", want{OutcomeNotMatched, 1, 2, false}, want{OutcomeNotMatched, 1, 3, false}},
+		{audited, "151.101.1.2", want{OutcomeNotMatched, 1, 2, false}, want{OutcomeNotMatched, 2, 3, false}},
+		{audited, "151.101.1.3", want{OutcomeNotMatched, 1, 2, false}, want{OutcomeMatched, 3, 3, true}},
+		{other, "151.101.1.4", want{}, want{}},
+		{audited, "151.101.1.4", want{OutcomeMatched, 2, 2, true}, want{OutcomeNotMatched, 4, 6, false}},
+	}
+	addresses := map[string]string{audited: "192.168.1.10", other: "192.168.1.11"}
+	for _, step := range steps {
+		nm.TrackEvent(tcpEvent(t, step.mac, addresses[step.mac], step.dst, 443))
+	}
+
+	if got := nm.DeviceDecisions(other); len(got.Decisions) != 0 || got.AuditedUntil != nil {
+		t.Errorf("device not audited has %d decisions, audited until %v", len(got.Decisions), got.AuditedUntil)
+	}
+	recorded := nm.DeviceDecisions(audited)
+	if recorded.AuditedUntil == nil {
+		t.Error("audited device has no audit end")
+	}
+	var expected []want
+	var dsts []string
+	for i := len(steps) - 1; i >= 0; i-- { // Newest first
+		if steps[i].mac == audited {
+			expected = append(expected, steps[i].fleet, steps[i].directIP)
+			dsts = append(dsts, steps[i].dst)
+		}
+	}
+	if len(recorded.Decisions) != len(dsts) {
+		t.Fatalf("%d decisions recorded, want %d", len(recorded.Decisions), len(dsts))
+	}
+	for i, decision := range recorded.Decisions {
+		if decision.DstIP != dsts[i] || decision.PatternID == "" || len(decision.Detectors) != len(patternDetectors) {
+			t.Errorf("decision %d: %s, pattern %q, %d detectors", i, decision.DstIP, decision.PatternID, len(decision.Detectors))
+			continue
+		}
+		for j, name := range []string{"fleet", "direct_ip"} {
+			var got *models.DetectorDecision
+			for k := range decision.Detectors {
+				if decision.Detectors[k].Detector == name {
+					got = &decision.Detectors[k]
+				}
+			}
+			w := expected[2*i+j]
+			switch {
+			case got == nil:
+				t.Errorf("%s: no %s verdict", decision.DstIP, name)
+			case got.Outcome != w.outcome || got.Reason == "":
+				t.Errorf("%s: %s %s (%s), want %s", decision.DstIP, name, got.Outcome, got.Reason, w.outcome)
+			case w.outcome != OutcomeSkipped && (got.Value == nil || got.Threshold == nil || *got.Value != w.value || *got.Threshold != w.threshold):
+				t.Errorf("%s: %s compared %v with %v, want %v with %v", decision.DstIP, name, got.Value, got.Threshold, w.value, w.threshold)
+			case w.outcome == OutcomeSkipped && (got.Value != nil || got.Threshold != nil):
+				t.Errorf("%s: skipped %s compared values", decision.DstIP, name)
+			case (got.AnomalyID != "") != w.anomaly:
+				t.Errorf("%s: %s anomaly %q, want one %v", decision.DstIP, name, got.AnomalyID, w.anomaly)
+			}
+		}
+	}
+
+	// Once stopped, the audit keeps what it recorded and records no more
+	if err := nm.StopDecisionAudit(audited); err != nil {
+		t.Fatal(err)
+	}
+	nm.TrackEvent(tcpEvent(t, audited, "192.168.1.10", "151.101.1.5", 443))
+	if got := nm.DeviceDecisions(audited); len(got.Decisions) != len(dsts) || got.AuditedUntil != nil {
+		t.Errorf("after stopping: %d decisions, audited until %v", len(got.Decisions), got.AuditedUntil)
+	}
+	if err := nm.StopDecisionAudit(audited); err != ErrAuditNotFound {
+		t.Errorf("stopping again = %v, want ErrAuditNotFound", err)
+	}
+}
@@ -0,0 +1,233 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Device fields whose changes are reported in device change events
+const (
+	ChangeIP               = "ip"
+	ChangeSubnet           = "subnet"
+	ChangeVendor           = "vendor"
+	ChangeOS               = "os"
+	ChangeDeviceType       = "device_type"
+	ChangeThreatPortAccess = "threat_port_access" // Reported when a device first reaches a known-dangerous port
+	ChangeTransient        = "transient"
+)
+
+// DefaultDeviceChangeDebounce is how long a device must stay unchanged before
+// its accumulated changes are reported
+const DefaultDeviceChangeDebounce = 30 * time.Second
+
+// deviceChangeMaxDelay bounds how many debounce periods changes of a device
+// that keeps changing are held back
+const deviceChangeMaxDelay = 10
+
+// deviceChangeSeq numbers device change events
+var deviceChangeSeq atomic.Uint64
+
+// deviceState is the part of a device whose changes are reported. It is
+// captured for every event, so it holds no maps or formatted values.
+type deviceState struct {
+	ip, subnet, vendor, os, deviceType string
+	threatPorts                        int
+	transient                          bool
+}
+
+// snapshotDevice captures the reported fields of a device
+func snapshotDevice(device *models.DeviceInfo) deviceState {
+	return deviceState{
+		ip:          device.IP,
+		subnet:      device.Subnet,
+		vendor:      device.Vendor,
+		os:          DeviceOS(device),
+		deviceType:  DeviceType(device),
+		threatPorts: device.ThreatPortAccess,
+		transient:   device.Transient,
+	}
+}
+
+// diff returns the fields that changed from s to current. Only the first
+// threat port access counts, later ones are not a change of state.
+func (s deviceState) diff(current deviceState) map[string]models.FieldChange {
+	if s.threatPorts > 0 {
+		current.threatPorts = s.threatPorts
+	}
+	if s == current {
+		return nil
+	}
+
+	changes := make(map[string]models.FieldChange)
+	add := func(field, old, value string) {
+		if old != value {
+			changes[field] = models.FieldChange{Old: old, New: value}
+		}
+	}
+	add(ChangeIP, s.ip, current.ip)
+	add(ChangeSubnet, s.subnet, current.subnet)
+	add(ChangeVendor, s.vendor, current.vendor)
+	add(ChangeOS, s.os, current.os)
+	add(ChangeDeviceType, s.deviceType, current.deviceType)
+	add(ChangeThreatPortAccess, strconv.Itoa(s.threatPorts), strconv.Itoa(current.threatPorts))
+	add(ChangeTransient, strconv.FormatBool(s.transient), strconv.FormatBool(current.transient))
+	return changes
+}
+
+type pendingChange struct {
+	change      *models.DeviceUpdate
+	lastChanged time.Time
+}
+
+// deviceChanges holds the changes of each device until it settles, so rapid
+// repeated changes (an IP flapping during DHCP renewal) are reported once
+type deviceChanges struct {
+	debounce time.Duration             // Guarded by nm.mu
+	pending  map[string]*pendingChange // Device ID -> changes, guarded by nm.mu
+
+	mu        sync.Mutex
+	subs      map[chan *models.DeviceUpdate]struct{}
+	ipChanges []*models.IPChange // Oldest first, at most ipChangeLog
+}
+
+func newDeviceChanges() *deviceChanges {
+	return &deviceChanges{
+		debounce: DefaultDeviceChangeDebounce,
+		pending:  make(map[string]*pendingChange),
+		subs:     make(map[chan *models.DeviceUpdate]struct{}),
+	}
+}
+
+// SetDeviceChangeDebounce sets how long a device must stay unchanged before
+// its changes are reported
+func (nm *NetworkMonitor) SetDeviceChangeDebounce(debounce time.Duration) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.changes.debounce = debounce
+}
+
+// recordDeviceChanges merges changes to a device into its pending event. A
+// field changed back to its reported value is dropped. Must hold nm.mu.
+func (nm *NetworkMonitor) recordDeviceChanges(deviceID string, changes map[string]models.FieldChange, now time.Time) {
+	if len(changes) == 0 {
+		return
+	}
+
+	pending := nm.changes.pending[deviceID]
+	if pending == nil {
+		pending = &pendingChange{change: &models.DeviceUpdate{
+			DeviceID:     deviceID,
+			DeviceUUID:   nm.uuids.device(deviceID),
+			Changes:      make(map[string]models.FieldChange),
+			FirstChanged: now,
+		}}
+		nm.changes.pending[deviceID] = pending
+	}
+	pending.lastChanged = now
+
+	for field, change := range changes {
+		if earlier, ok := pending.change.Changes[field]; ok {
+			change.Old = earlier.Old
+		}
+		if change.Old == change.New {
+			delete(pending.change.Changes, field)
+		} else {
+			pending.change.Changes[field] = change
+		}
+	}
+}
+
+// reportSettledDeviceChanges reports the changes of devices that have
+// settled, every second
+func (nm *NetworkMonitor) reportSettledDeviceChanges(now time.Time) {
+	for _, change := range nm.settledDeviceChanges(now) {
+		nm.expectDeviceChange(change)
+		nm.notifyDeviceChange(change)
+		if ipChange := nm.ipChangeOf(change); ipChange != nil {
+			nm.notifyIPChange(ipChange)
+		}
+	}
+}
+
+// settledDeviceChanges removes and returns the pending changes of devices
+// unchanged for the debounce period, or held back for too long
+func (nm *NetworkMonitor) settledDeviceChanges(now time.Time) []*models.DeviceUpdate {
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	debounce := nm.changes.debounce
+	var settled []*models.DeviceUpdate
+	for deviceID, pending := range nm.changes.pending {
+		if now.Sub(pending.lastChanged) < debounce &&
+			now.Sub(pending.change.FirstChanged) < deviceChangeMaxDelay*debounce {
+			continue
+		}
+		delete(nm.changes.pending, deviceID)
+		if len(pending.change.Changes) == 0 {
+			continue // Everything changed back
+		}
+		pending.change.ID = fmt.Sprintf("c-%d", deviceChangeSeq.Add(1))
+		pending.change.Timestamp = now
+		settled = append(settled, pending.change)
+	}
+	sort.Slice(settled, func(i, j int) bool {
+		return settled[i].FirstChanged.Before(settled[j].FirstChanged)
+	})
+	return settled
+}
+
+// SubscribeDeviceChanges returns a channel receiving every device change
+// reported from now on, and a function that ends the subscription
+func (nm *NetworkMonitor) SubscribeDeviceChanges() (<-chan *models.DeviceUpdate, func()) {
+	sub := make(chan *models.DeviceUpdate, 16)
+
+	nm.changes.mu.Lock()
+	nm.changes.subs[sub] = struct{}{}
+	nm.changes.mu.Unlock()
+
+	unsubscribe := func() {
+		nm.changes.mu.Lock()
+		defer nm.changes.mu.Unlock()
+		if _, ok := nm.changes.subs[sub]; ok {
+			delete(nm.changes.subs, sub)
+			close(sub)
+		}
+	}
+	return sub, unsubscribe
+}
+
+func (nm *NetworkMonitor) notifyDeviceChange(change *models.DeviceUpdate) {
+	nm.changes.mu.Lock()
+	for sub := range nm.changes.subs {
+		// Slow subscribers miss changes rather than hold back the others
+		select {
+		case sub <- change:
+		default:
+		}
+	}
+	nm.changes.mu.Unlock()
+
+	if change.ExpectedBy != "" {
+		fmt.Printf("[EXPECTED] %s changed, announced by %s\n", change.DeviceID, change.ExpectedBy)
+		return
+	}
+	if !nm.emit(SinkDeviceChange, change) {
+		return
+	}
+	fields := make([]string, 0, len(change.Changes))
+	for field := range change.Changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for i, field := range fields {
+		fields[i] = fmt.Sprintf("%s %s → %s", field, change.Changes[field].Old, change.Changes[field].New)
+	}
+	fmt.Printf("[CHANGED] %s: %s\n", change.DeviceID, strings.Join(fields, ", "))
+}
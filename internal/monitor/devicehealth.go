@@ -0,0 +1,441 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Factors of the device health score
+const (
+	HealthConnections  = "failed_connections" // External TCP handshakes refused or unanswered
+	HealthNXDomain     = "nxdomain"           // DNS answers saying the name doesn't exist
+	HealthResets       = "resets"             // TCP segments sent that were resets
+	HealthRoaming      = "roaming"            // Changes of the interface its traffic is seen on
+	HealthAvailability = "availability"       // Uptime of critical devices
+)
+
+const (
+	healthInterval    = time.Minute // How often health is recomputed
+	healthBuckets     = 6           // Buckets the window rotates in
+	healthMaxClients  = 65536       // Bounds the DNS clients counted at once
+	healthNXDomain    = 3           // DNS response code of names that don't exist
+	healthMinActive   = 3           // Intervals with traffic before roaming is measured
+	healthGoodScore   = 90          // Score from which a device is good
+	healthPoorScore   = 60          // Score below which a device is poor
+	healthRoamsWorst  = 12.0        // Roams within the window that take every roaming point
+	healthRoamsNormal = 2.0         // Roams within the window taking no point
+)
+
+// healthFactor scores one factor: no penalty at or better than normal, the
+// full weight at or worse than worst, linearly in between. It is measured
+// from minSamples on.
+type healthFactor struct {
+	weight     float64
+	normal     float64
+	worst      float64
+	minSamples int
+}
+
+// healthFactors are the weights and thresholds of ScoreHealth; the weights
+// sum to 100
+var healthFactors = map[string]healthFactor{
+	HealthConnections:  {weight: 35, normal: 0.05, worst: 0.5, minSamples: 5},
+	HealthNXDomain:     {weight: 20, normal: 0.2, worst: 0.6, minSamples: 10},
+	HealthResets:       {weight: 15, normal: 0.05, worst: 0.25, minSamples: 50},
+	HealthRoaming:      {weight: 15, normal: healthRoamsNormal, worst: healthRoamsWorst, minSamples: healthMinActive},
+	HealthAvailability: {weight: 15, normal: 99, worst: 90, minSamples: 1},
+}
+
+// DeviceHealthConfig controls device health scoring
+type DeviceHealthConfig struct {
+	Window time.Duration // Traffic health is measured over
+}
+
+// DefaultDeviceHealthConfig returns the default device health settings
+func DefaultDeviceHealthConfig() DeviceHealthConfig {
+	return DeviceHealthConfig{Window: time.Hour}
+}
+
+// HealthInputs are what a device health score is computed from, counted over
+// the health window
+type HealthInputs struct {
+	Handshakes       int      // External TCP handshakes it started
+	FailedHandshakes int      // Of those, refused or unanswered
+	DNSAnswers       int      // DNS responses it received
+	NXDomains        int      // Of those, for names that don't exist
+	TCPSegments      int      // TCP segments it sent
+	Resets           int      // Of those, resets
+	ActiveIntervals  int      // Health intervals it sent traffic in
+	Roams            int      // Changes of the interface most of its traffic was seen on
+	Uptime           *float64 // Availability percentage of a critical device, nil otherwise
+}
+
+// ScoreHealth computes the health of a device: 100 less the penalty of each
+// factor measured over enough samples. A device is unknown while none but
+// roaming is, as an idle device isn't known to be healthy.
+func ScoreHealth(in HealthInputs) models.DeviceHealth {
+	health := models.DeviceHealth{State: models.DeviceHealthUnknown, Factors: []models.HealthFactor{}}
+	measured := false
+	add := func(name string, value float64, samples int, detail string) {
+		factor := healthFactors[name]
+		if samples < factor.minSamples {
+			return
+		}
+		severity := math.Max(0, math.Min(1, (value-factor.normal)/(factor.worst-factor.normal)))
+		health.Factors = append(health.Factors, models.HealthFactor{
+			Name:    name,
+			Value:   math.Round(value*1000) / 1000,
+			Samples: samples,
+			Penalty: math.Round(factor.weight*severity*10) / 10,
+			Detail:  detail,
+		})
+		measured = measured || name != HealthRoaming
+	}
+
+	add(HealthConnections, ratio(in.FailedHandshakes, in.Handshakes), in.Handshakes,
+		fmt.Sprintf("%d of %d external TCP handshakes refused or unanswered", in.FailedHandshakes, in.Handshakes))
+	add(HealthNXDomain, ratio(in.NXDomains, in.DNSAnswers), in.DNSAnswers,
+		fmt.Sprintf("%d of %d DNS answers for names that don't exist", in.NXDomains, in.DNSAnswers))
+	add(HealthResets, ratio(in.Resets, in.TCPSegments), in.TCPSegments,
+		fmt.Sprintf("%d of %d TCP segments sent were resets", in.Resets, in.TCPSegments))
+	add(HealthRoaming, float64(in.Roams), in.ActiveIntervals,
+		fmt.Sprintf("moved between interfaces %d times over %d active intervals", in.Roams, in.ActiveIntervals))
+	if in.Uptime != nil {
+		add(HealthAvailability, *in.Uptime, 1, fmt.Sprintf("up %.2f%% of the time", *in.Uptime))
+	}
+	if !measured {
+		return health
+	}
+
+	total := 100.0
+	for _, factor := range health.Factors {
+		total -= factor.Penalty
+	}
+	score := int(math.Max(0, math.Round(total)))
+	health.Score = &score
+	switch {
+	case score >= healthGoodScore:
+		health.State = models.DeviceHealthGood
+	case score >= healthPoorScore:
+		health.State = models.DeviceHealthDegraded
+	default:
+		health.State = models.DeviceHealthPoor
+	}
+	return health
+}
+
+func ratio(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// healthCounts are the events of one bucket of the health window
+type healthCounts struct {
+	handshakes, failedHandshakes int
+	answers, nxdomains           int
+	segments, resets             int
+	active, roams                int
+}
+
+func (c *healthCounts) add(o healthCounts) {
+	c.handshakes += o.handshakes
+	c.failedHandshakes += o.failedHandshakes
+	c.answers += o.answers
+	c.nxdomains += o.nxdomains
+	c.segments += o.segments
+	c.resets += o.resets
+	c.active += o.active
+	c.roams += o.roams
+}
+
+// healthWindow is the health window of a device or DNS client, a bucket per
+// healthBuckets-th of it
+type healthWindow [healthBuckets]healthCounts
+
+func (w *healthWindow) sum() healthCounts {
+	var total healthCounts
+	for _, counts := range w {
+		total.add(counts)
+	}
+	return total
+}
+
+type deviceHealthState struct {
+	window     healthWindow
+	interfaces map[string]int // Events per interface since the last interval
+	dominant   string         // Interface most of its traffic was last seen on
+}
+
+// deviceHealthTracker counts the events device health is scored from. DNS
+// answers are counted by client IP, as responses carry no client MAC. It is
+// guarded by nm.mu.
+type deviceHealthTracker struct {
+	config  DeviceHealthConfig
+	devices map[string]*deviceHealthState // By device ID
+	clients map[string]*healthWindow      // By IP
+	bucket  int
+	rotated time.Time
+}
+
+func newDeviceHealthTracker(config DeviceHealthConfig) *deviceHealthTracker {
+	return &deviceHealthTracker{
+		config:  config,
+		devices: make(map[string]*deviceHealthState),
+		clients: make(map[string]*healthWindow),
+		rotated: time.Now(),
+	}
+}
+
+// SetDeviceHealthConfig replaces the device health settings
+func (nm *NetworkMonitor) SetDeviceHealthConfig(config DeviceHealthConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.health.config = config
+}
+
+// device returns the state of a device, creating it
+func (t *deviceHealthTracker) device(id string) *deviceHealthState {
+	state := t.devices[id]
+	if state == nil {
+		state = &deviceHealthState{interfaces: make(map[string]int)}
+		t.devices[id] = state
+	}
+	return state
+}
+
+// observeHealth counts packets a device sent on an interface; reset tells
+// whether it was a TCP reset, for TCP segments. Must hold nm.mu.
+func (nm *NetworkMonitor) observeHealth(deviceID, iface string, packets int, tcp, reset bool) {
+	state := nm.health.device(deviceID)
+	if iface != "" {
+		state.interfaces[iface] += packets
+	}
+	if tcp {
+		counts := &state.window[nm.health.bucket]
+		counts.segments += packets
+		if reset {
+			counts.resets++
+		}
+	}
+}
+
+// observeHealthHandshake counts an external handshake a device started and
+// whether it completed. Must hold nm.mu.
+func (nm *NetworkMonitor) observeHealthHandshake(deviceID string, completed bool) {
+	counts := &nm.health.device(deviceID).window[nm.health.bucket]
+	counts.handshakes++
+	if !completed {
+		counts.failedHandshakes++
+	}
+}
+
+// observeHealthDNS counts a DNS answer to a local client
+func (nm *NetworkMonitor) observeHealthDNS(evt *models.DNSQueryEvent) {
+	if len(evt.Data) < 12 || !utils.DNSIsResponse(evt.Data) {
+		return
+	}
+	client := utils.IPFromBEUint32(evt.DstIP)
+	nxdomain := evt.Data[3]&0x0f == healthNXDomain
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	if nm.isExternalIP(client) {
+		return
+	}
+	t := nm.health
+	window := t.clients[client.String()]
+	if window == nil {
+		if len(t.clients) >= healthMaxClients {
+			return
+		}
+		window = &healthWindow{}
+		t.clients[client.String()] = window
+	}
+	window[t.bucket].answers++
+	if nxdomain {
+		window[t.bucket].nxdomains++
+	}
+}
+
+// renameDevice moves the counts of a routed device absorbed into a local one
+func (t *deviceHealthTracker) renameDevice(from, to string) {
+	state := t.devices[from]
+	if state == nil {
+		return
+	}
+	delete(t.devices, from)
+	into := t.device(to)
+	for i := range state.window {
+		into.window[i].add(state.window[i])
+	}
+	for iface, count := range state.interfaces {
+		into.interfaces[iface] += count
+	}
+}
+
+// closeInterval counts the intervals devices were active in and the changes
+// of the interface most of their traffic was seen on. Ties go to the first
+// name, so a device captured on two interfaces at once doesn't roam.
+func (t *deviceHealthTracker) closeInterval() {
+	for _, state := range t.devices {
+		if len(state.interfaces) == 0 {
+			continue
+		}
+		dominant, most := "", 0
+		for iface, count := range state.interfaces {
+			if count > most || (count == most && iface < dominant) {
+				dominant, most = iface, count
+			}
+		}
+		counts := &state.window[t.bucket]
+		counts.active++
+		if state.dominant != "" && dominant != state.dominant {
+			counts.roams++
+		}
+		state.dominant = dominant
+		clear(state.interfaces)
+	}
+}
+
+// rotate starts the next bucket once the current one has covered its share
+// of the window, forgetting devices and clients without counts left
+func (t *deviceHealthTracker) rotate(now time.Time) {
+	if now.Sub(t.rotated) < t.config.Window/healthBuckets {
+		return
+	}
+	t.rotated = now
+	t.bucket = (t.bucket + 1) % healthBuckets
+	for id, state := range t.devices {
+		state.window[t.bucket] = healthCounts{}
+		if state.window.sum() == (healthCounts{}) {
+			delete(t.devices, id)
+		}
+	}
+	for ip, window := range t.clients {
+		window[t.bucket] = healthCounts{}
+		if window.sum() == (healthCounts{}) {
+			delete(t.clients, ip)
+		}
+	}
+}
+
+// healthInputs gathers what the health of a device is scored from. Must hold
+// nm.mu.
+func (nm *NetworkMonitor) healthInputs(device *models.DeviceInfo, now time.Time) HealthInputs {
+	t := nm.health
+	var counts healthCounts
+	if state := t.devices[device.ID]; state != nil {
+		counts = state.window.sum()
+	}
+	if window := t.clients[device.IP]; window != nil {
+		dns := window.sum()
+		counts.answers, counts.nxdomains = dns.answers, dns.nxdomains
+	}
+	in := HealthInputs{
+		Handshakes:       counts.handshakes,
+		FailedHandshakes: counts.failedHandshakes,
+		DNSAnswers:       counts.answers,
+		NXDomains:        counts.nxdomains,
+		TCPSegments:      counts.segments,
+		Resets:           counts.resets,
+		ActiveIntervals:  counts.active,
+		Roams:            counts.roams,
+	}
+	if track := nm.availability.tracks[device.ID]; track != nil {
+		in.Uptime = summarizeAvailability(track.transitions, now.Add(-t.config.Window), now).UptimePercent
+	}
+	return in
+}
+
+// healthTick closes the interval, rotates the window when due and rescores
+// every cached device, in batches so events aren't held up meanwhile
+func (nm *NetworkMonitor) healthTick(now time.Time) {
+	nm.lockAll()
+	nm.health.closeInterval()
+	nm.health.rotate(now)
+	window := nm.health.config.Window.String()
+	nm.unlockAll()
+
+	ids := nm.Cache.Keys()
+	for start := 0; start < len(ids); start += snapshotBatch {
+		nm.lockAll()
+		for _, id := range ids[start:min(start+snapshotBatch, len(ids))] {
+			if device, ok := nm.Cache.Peek(id); ok {
+				health := ScoreHealth(nm.healthInputs(device, now))
+				health.Window, health.ComputedAt = window, now
+				device.Health = &health
+			}
+		}
+		nm.unlockAll()
+	}
+}
+
+// cloneHealth returns a copy of the health of a device, or nil
+func cloneHealth(health *models.DeviceHealth) *models.DeviceHealth {
+	if health == nil {
+		return nil
+	}
+	clone := *health
+	if health.Score != nil {
+		score := *health.Score
+		clone.Score = &score
+	}
+	clone.Factors = append([]models.HealthFactor(nil), health.Factors...)
+	return &clone
+}
+
+// HealthState returns the health state of a device, unknown until scored
+func HealthState(device *models.DeviceInfo) string {
+	if device.Health == nil {
+		return models.DeviceHealthUnknown
+	}
+	return device.Health.State
+}
+
+// SortByHealth orders devices least healthy first, unknown ones last
+func SortByHealth(devices []*models.DeviceInfo) {
+	score := func(device *models.DeviceInfo) int {
+		if device.Health == nil || device.Health.Score == nil {
+			return 101
+		}
+		return *device.Health.Score
+	}
+	sort.SliceStable(devices, func(i, j int) bool { return score(devices[i]) < score(devices[j]) })
+}
+
+// DevicesNeedingAttention returns up to limit devices whose health is
+// degraded or poor, least healthy first
+func DevicesNeedingAttention(devices []*models.DeviceInfo, limit int) []models.DeviceAttention {
+	var unhealthy []*models.DeviceInfo
+	for _, device := range devices {
+		if state := HealthState(device); state == models.DeviceHealthDegraded || state == models.DeviceHealthPoor {
+			unhealthy = append(unhealthy, device)
+		}
+	}
+	SortByHealth(unhealthy)
+
+	attention := make([]models.DeviceAttention, 0, min(len(unhealthy), limit))
+	for _, device := range unhealthy[:min(len(unhealthy), limit)] {
+		attention = append(attention, models.DeviceAttention{
+			ID:     device.ID,
+			Name:   device.Name,
+			IP:     device.IP,
+			Health: *device.Health,
+		})
+	}
+	return attention
+}
+
+// ValidHealthState reports whether a state is one of models.DeviceHealthStates
+func ValidHealthState(state string) bool {
+	return slices.Contains(models.DeviceHealthStates, state)
+}
@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// The weights of the health factors take at most 100 points together
+func TestHealthFactorWeights(t *testing.T) {
+	total := 0.0
+	for _, factor := range healthFactors {
+		total += factor.weight
+	}
+	if total != 100 {
+		t.Errorf("weights sum to %v, want 100", total)
+	}
+	for _, name := range []string{HealthConnections, HealthNXDomain, HealthResets, HealthRoaming, HealthAvailability} {
+		if _, ok := healthFactors[name]; !ok {
+			t.Errorf("factor %s has no weight", name)
+		}
+	}
+}
+
+// ScoreHealth on pinned inputs: each factor's penalty, the score and the
+// state at the good and poor boundaries, and unknown while nothing but
+// roaming has enough samples. A change of weights or thresholds shows here.
+func TestScoreHealth(t *testing.T) {
+	uptime := func(percent float64) *float64 { return &percent }
+	tests := []struct {
+		name      string
+		in        HealthInputs
+		state     string
+		score     int                // Unset while unknown
+		penalties map[string]float64 // Of the factors measured
+	}{
+		{name: "idle", state: models.DeviceHealthUnknown},
+		{
+			name:  "too few samples",
+			in:    HealthInputs{Handshakes: 4, FailedHandshakes: 4, DNSAnswers: 9, NXDomains: 9, TCPSegments: 49, Resets: 49, ActiveIntervals: 2, Roams: 2},
+			state: models.DeviceHealthUnknown,
+		},
+		{
+			name:      "roaming only",
+			in:        HealthInputs{ActiveIntervals: 6, Roams: 12},
+			state:     models.DeviceHealthUnknown,
+			penalties: map[string]float64{HealthRoaming: 15},
+		},
+		{
+			name:      "healthy",
+			in:        HealthInputs{Handshakes: 100, FailedHandshakes: 5, DNSAnswers: 50, NXDomains: 10, TCPSegments: 1000, Resets: 50, ActiveIntervals: 10, Roams: 2},
+			state:     models.DeviceHealthGood,
+			score:     100,
+			penalties: map[string]float64{HealthConnections: 0, HealthNXDomain: 0, HealthResets: 0, HealthRoaming: 0},
+		},
+		{
+			name:      "good at 90",
+			in:        HealthInputs{DNSAnswers: 10, NXDomains: 4},
+			state:     models.DeviceHealthGood,
+			score:     90,
+			penalties: map[string]float64{HealthNXDomain: 10},
+		},
+		{
+			name:      "degraded",
+			in:        HealthInputs{Handshakes: 20, FailedHandshakes: 5, DNSAnswers: 20, NXDomains: 8, TCPSegments: 100, Resets: 5},
+			state:     models.DeviceHealthDegraded,
+			score:     74,
+			penalties: map[string]float64{HealthConnections: 15.6, HealthNXDomain: 10, HealthResets: 0},
+		},
+		{
+			name:      "degraded at 60",
+			in:        HealthInputs{Handshakes: 5, FailedHandshakes: 5, DNSAnswers: 10, NXDomains: 3},
+			state:     models.DeviceHealthDegraded,
+			score:     60,
+			penalties: map[string]float64{HealthConnections: 35, HealthNXDomain: 5},
+		},
+		{
+			name:      "poor",
+			in:        HealthInputs{Handshakes: 10, FailedHandshakes: 10, DNSAnswers: 10, NXDomains: 10, TCPSegments: 50, Resets: 25, ActiveIntervals: 6, Roams: 7},
+			state:     models.DeviceHealthPoor,
+			score:     23,
+			penalties: map[string]float64{HealthConnections: 35, HealthNXDomain: 20, HealthResets: 15, HealthRoaming: 7.5},
+		},
+		{
+			name:      "critical device mostly up",
+			in:        HealthInputs{Uptime: uptime(95)},
+			state:     models.DeviceHealthGood,
+			score:     93,
+			penalties: map[string]float64{HealthAvailability: 6.7},
+		},
+		{
+			name:      "critical device often down",
+			in:        HealthInputs{Uptime: uptime(80)},
+			state:     models.DeviceHealthDegraded,
+			score:     85,
+			penalties: map[string]float64{HealthAvailability: 15},
+		},
+		{
+			name:      "critical device always up",
+			in:        HealthInputs{Uptime: uptime(100)},
+			state:     models.DeviceHealthGood,
+			score:     100,
+			penalties: map[string]float64{HealthAvailability: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := ScoreHealth(tt.in)
+			if health.State != tt.state {
+				t.Errorf("state = %s, want %s", health.State, tt.state)
+			}
+			switch {
+			case tt.state == models.DeviceHealthUnknown && health.Score != nil:
+				t.Errorf("unknown health scored %d", *health.Score)
+			case tt.state != models.DeviceHealthUnknown && (health.Score == nil || *health.Score != tt.score):
+				t.Errorf("score = %v, want %d", health.Score, tt.score)
+			}
+			penalties := make(map[string]float64)
+			for _, factor := range health.Factors {
+				penalties[factor.Name] = factor.Penalty
+				if factor.Detail == "" {
+					t.Errorf("factor %s has no detail", factor.Name)
+				}
+			}
+			if len(penalties) != 0 || len(tt.penalties) != 0 {
+				if !maps.Equal(penalties, tt.penalties) {
+					t.Errorf("penalties = %v, want %v", penalties, tt.penalties)
+				}
+			}
+		})
+	}
+}
+
+// The factors carry the ratio they measured and the samples it is over
+func TestScoreHealthFactor(t *testing.T) {
+	health := ScoreHealth(HealthInputs{Handshakes: 3, FailedHandshakes: 1, DNSAnswers: 30, NXDomains: 10})
+	if len(health.Factors) != 1 {
+		t.Fatalf("factors = %+v, want the NXDOMAIN one only", health.Factors)
+	}
+	want := models.HealthFactor{
+		Name:    HealthNXDomain,
+		Value:   0.333,
+		Samples: 30,
+		Penalty: 6.7,
+		Detail:  "10 of 30 DNS answers for names that don't exist",
+	}
+	if health.Factors[0] != want {
+		t.Errorf("factor = %+v, want %+v", health.Factors[0], want)
+	}
+}
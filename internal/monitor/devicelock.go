@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"hash/maphash"
+	"sync"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// deviceStripes is how many locks share out the cached devices
+const deviceStripes = 64
+
+var deviceLockSeed = maphash.MakeSeed()
+
+// deviceLocks guard the fields of cached devices, each lock a stripe of
+// devices chosen by ID.
+//
+// Fields of a cached device are only written holding nm.mu for writing and
+// the device's stripe. The event path (TrackEvent and the other Track
+// methods) locks the stripes of the devices it updates; every other writer
+// locks them all with lockAll. A device can therefore be read holding either
+// nm.mu for reading or just its stripe. The API reads devices holding just
+// their stripe, copying shared settings such as the risk weights under a
+// brief read lock first, and finds devices by IP through ipIndex, so no API
+// request holds nm.mu while it walks the cache.
+//
+// Only the holder of nm.mu takes more than one stripe, so stripes never
+// deadlock between themselves. A stripe is never held while waiting for nm.mu.
+type deviceLocks [deviceStripes]sync.Mutex
+
+// stripe returns the lock of a device
+func (l *deviceLocks) stripe(id string) *sync.Mutex {
+	return &l[maphash.String(deviceLockSeed, id)%deviceStripes]
+}
+
+// lockDevice locks the fields of a device and returns the function unlocking
+// them
+func (nm *NetworkMonitor) lockDevice(id string) func() {
+	mu := nm.deviceLocks.stripe(id)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockOtherDevice locks the fields of a device while those of held are
+// locked already, which may share its stripe. Must hold nm.mu.
+func (nm *NetworkMonitor) lockOtherDevice(held, id string) func() {
+	mu := nm.deviceLocks.stripe(id)
+	if mu == nm.deviceLocks.stripe(held) {
+		return func() {}
+	}
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockAll locks nm.mu for writing along with every device
+func (nm *NetworkMonitor) lockAll() {
+	nm.mu.Lock()
+	for i := range nm.deviceLocks {
+		nm.deviceLocks[i].Lock()
+	}
+}
+
+// unlockAll releases the locks taken by lockAll
+func (nm *NetworkMonitor) unlockAll() {
+	for i := range nm.deviceLocks {
+		nm.deviceLocks[i].Unlock()
+	}
+	nm.mu.Unlock()
+}
+
+// eachDevice calls fn with every cached device, holding only the device's
+// stripe, so that scanning the whole cache doesn't stall event processing.
+// fn must not keep the device.
+func (nm *NetworkMonitor) eachDevice(fn func(id string, device *models.DeviceInfo)) {
+	// The cache synchronizes itself
+	for _, id := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Peek(id)
+		if !ok {
+			continue
+		}
+		unlock := nm.lockDevice(id)
+		fn(id, device)
+		unlock()
+	}
+}
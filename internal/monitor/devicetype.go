@@ -0,0 +1,219 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Device types recognized from protocol behavior
+const (
+	DeviceTypePrinter   = "Printer"
+	DeviceTypeCamera    = "IP Camera"
+	DeviceTypeVoIPPhone = "VoIP Phone"
+)
+
+// deviceTypeMinScore is the evidence needed before a type is assigned at all
+const deviceTypeMinScore = 3.0
+
+// cloudUploadMinSize is the frame size from which a packet to an external
+// destination counts as camera-like upload traffic
+const cloudUploadMinSize = 1000
+
+// rtpPortMin and rtpPortMax bound the port range most phones send RTP from
+const (
+	rtpPortMin = 16384
+	rtpPortMax = 32767
+)
+
+type deviceTypeSignature struct {
+	name       string
+	deviceType string
+	weight     float64
+	supporting bool // Only counted for devices that already have stronger evidence
+}
+
+// tcpServerSignatures maps ports a device serves over TCP to a device type.
+// A device serves a port when it sends from it to an ephemeral port.
+var tcpServerSignatures = map[uint16]deviceTypeSignature{
+	631:  {"IPP server (TCP 631)", DeviceTypePrinter, 3, false},
+	9100: {"raw print server (TCP 9100)", DeviceTypePrinter, 3, false},
+	515:  {"LPD server (TCP 515)", DeviceTypePrinter, 2, false},
+	554:  {"RTSP server (TCP 554)", DeviceTypeCamera, 3, false},
+}
+
+// udpServerSignatures maps ports a device answers from over UDP to a device type
+var udpServerSignatures = map[uint16]deviceTypeSignature{
+	161: {"SNMP agent (UDP 161)", DeviceTypePrinter, 0.5, true}, // Network gear runs agents too
+}
+
+var (
+	sipSignature         = deviceTypeSignature{"SIP (port 5060/5061)", DeviceTypeVoIPPhone, 3, false}
+	wsDiscoverySignature = deviceTypeSignature{"ONVIF WS-Discovery (UDP 3702)", DeviceTypeCamera, 1, true} // Windows and printers use it too
+	rtpSignature         = deviceTypeSignature{"RTP media (UDP 16384-32767)", DeviceTypeVoIPPhone, 1, true}
+	cloudUploadSignature = deviceTypeSignature{"large packets to external hosts", DeviceTypeCamera, 0.5, true}
+)
+
+// observeDeviceType records any device type evidence carried by an event.
+// external reports whether the destination is outside the monitored networks.
+func observeDeviceType(device *models.DeviceInfo, evt *models.NetworkEvent, external bool) {
+	var sig deviceTypeSignature
+	var ok bool
+
+	switch evt.EventType {
+	case models.EVENT_TYPE_TCP, models.EVENT_TYPE_HTTP, models.EVENT_TYPE_TLS:
+		switch {
+		case isSIPPort(evt.SrcPort) || isSIPPort(evt.DstPort):
+			sig, ok = sipSignature, true
+		case evt.DstPort >= 1024:
+			sig, ok = tcpServerSignatures[evt.SrcPort]
+		}
+		if !ok && external && evt.PacketLen >= cloudUploadMinSize {
+			sig, ok = cloudUploadSignature, true
+		}
+
+	case models.EVENT_TYPE_UDP:
+		switch {
+		case isSIPPort(evt.SrcPort) || isSIPPort(evt.DstPort):
+			sig, ok = sipSignature, true
+		case evt.SrcPort == 3702 || evt.DstPort == 3702:
+			sig, ok = wsDiscoverySignature, true
+		case isRTPPort(evt.SrcPort) && isRTPPort(evt.DstPort):
+			sig, ok = rtpSignature, true
+		case evt.DstPort >= 1024:
+			sig, ok = udpServerSignatures[evt.SrcPort]
+		}
+	}
+	if !ok || (sig.supporting && device.DeviceType == nil) {
+		return
+	}
+
+	if device.DeviceType == nil {
+		device.DeviceType = &models.DeviceTypeGuess{}
+	}
+	guess := device.DeviceType
+
+	found := false
+	for i := range guess.Evidence {
+		if guess.Evidence[i].Signal == sig.name {
+			guess.Evidence[i].Count++
+			found = true
+			break
+		}
+	}
+	if !found {
+		guess.Evidence = append(guess.Evidence, models.DeviceTypeEvidence{
+			Signal: sig.name,
+			Type:   sig.deviceType,
+			Weight: sig.weight,
+			Count:  1,
+		})
+	}
+
+	guess.UpdatedAt = time.Now()
+	updateDeviceType(guess)
+}
+
+func isSIPPort(port uint16) bool {
+	return port == 5060 || port == 5061
+}
+
+func isRTPPort(port uint16) bool {
+	return port >= rtpPortMin && port <= rtpPortMax && port%2 == 0
+}
+
+// updateDeviceType re-evaluates a guess from its evidence. Like OS guesses,
+// an established type only changes when contradicting evidence is
+// osSwitchRatio times stronger.
+func updateDeviceType(guess *models.DeviceTypeGuess) {
+	scores := make(map[string]float64)
+	total := 0.0
+	for _, ev := range guess.Evidence {
+		points := ev.Weight * float64(min(ev.Count, osEvidenceSaturation))
+		scores[ev.Type] += points
+		total += points
+	}
+
+	types := make([]string, 0, len(scores))
+	for t := range scores {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	if len(types) == 0 {
+		return
+	}
+
+	best := types[0]
+	for _, t := range types[1:] {
+		if scores[t] > scores[best] {
+			best = t
+		}
+	}
+
+	switch {
+	case scores[best] < deviceTypeMinScore && guess.Type == "":
+		return
+	case guess.Type == "" || guess.Type == best:
+		guess.Type = best
+	case scores[best] >= osSwitchRatio*scores[guess.Type]:
+		guess.Type = best
+	}
+
+	score := scores[guess.Type]
+	share := score / total
+	switch {
+	case score >= 10 && share >= 0.8:
+		guess.Confidence = models.ConfidenceHigh
+	case score >= 6 && share >= 0.6:
+		guess.Confidence = models.ConfidenceMedium
+	default:
+		guess.Confidence = models.ConfidenceLow
+	}
+}
+
+// mergeDeviceType folds the device type evidence of src into dst
+func mergeDeviceType(dst, src *models.DeviceInfo) {
+	if src.DeviceType == nil {
+		return
+	}
+	if dst.DeviceType == nil {
+		dst.DeviceType = cloneDeviceType(src.DeviceType)
+		return
+	}
+
+	for _, ev := range src.DeviceType.Evidence {
+		found := false
+		for i := range dst.DeviceType.Evidence {
+			if dst.DeviceType.Evidence[i].Signal == ev.Signal {
+				dst.DeviceType.Evidence[i].Count += ev.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst.DeviceType.Evidence = append(dst.DeviceType.Evidence, ev)
+		}
+	}
+	if src.DeviceType.UpdatedAt.After(dst.DeviceType.UpdatedAt) {
+		dst.DeviceType.UpdatedAt = src.DeviceType.UpdatedAt
+	}
+	updateDeviceType(dst.DeviceType)
+}
+
+func cloneDeviceType(guess *models.DeviceTypeGuess) *models.DeviceTypeGuess {
+	if guess == nil {
+		return nil
+	}
+	clone := *guess
+	clone.Evidence = append([]models.DeviceTypeEvidence(nil), guess.Evidence...)
+	return &clone
+}
+
+// DeviceType returns the recognized type of a device, or "" without one
+func DeviceType(device *models.DeviceInfo) string {
+	if device.DeviceType == nil {
+		return ""
+	}
+	return device.DeviceType.Type
+}
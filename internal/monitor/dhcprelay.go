@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// MaxCircuitHistory bounds the switch ports remembered per device; the least
+// recently seen one is dropped first
+const MaxCircuitHistory = 5
+
+// DHCP ports: relays and servers talk on the server port, clients send from
+// the client port
+const (
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+)
+
+// observeDHCPRelay attributes the client of a relayed DHCP message to the
+// switch port named by the relay agent information, and reports devices that
+// moved to another port. The client is found by its hardware address, or for
+// routed devices by the address it holds or is assigned; clients not in the
+// cache are skipped. Must hold nm.mu and the stripe of the sender, see
+// deviceLocks.
+func (nm *NetworkMonitor) observeDHCPRelay(evt *models.NetworkEvent, sender string, now time.Time) {
+	if evt.EventType != models.EVENT_TYPE_UDP || (evt.SrcPort != dhcpServerPort && evt.DstPort != dhcpServerPort) {
+		return
+	}
+	msg := utils.ParseDHCP(evt.L7Payload)
+	if msg == nil || !msg.Relayed() {
+		return
+	}
+
+	device := nm.dhcpClient(msg)
+	if device == nil {
+		return
+	}
+	defer nm.lockOtherDevice(sender, device.ID)()
+	attribution := models.CircuitAttribution{
+		CircuitID: utils.RenderAgentID(msg.CircuitID),
+		RemoteID:  utils.RenderAgentID(msg.RemoteID),
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+	if !msg.RelayIP.IsUnspecified() {
+		attribution.Relay = msg.RelayIP.String()
+	}
+
+	previous, moved := recordCircuit(device, attribution)
+	if !moved {
+		return
+	}
+	nm.raiseAnomaly("DEVICE_MOVED", models.SeverityInfo, device.ID,
+		map[string]string{
+			"device_name": device.Name,
+			"from":        circuitLabel(previous),
+			"to":          circuitLabel(attribution),
+		},
+		map[string]string{
+			"circuit_id":          attribution.CircuitID,
+			"remote_id":           attribution.RemoteID,
+			"relay":               attribution.Relay,
+			"previous_circuit_id": previous.CircuitID,
+			"previous_remote_id":  previous.RemoteID,
+			"previous_last_seen":  previous.LastSeen.Format(time.RFC3339),
+		})
+}
+
+// dhcpClient returns the cached device a DHCP message is about, or nil. Must
+// hold nm.mu.
+func (nm *NetworkMonitor) dhcpClient(msg *utils.DHCPMessage) *models.DeviceInfo {
+	if device, ok := nm.Cache.Peek(msg.ClientMAC); ok {
+		return device
+	}
+	for _, ip := range []net.IP{msg.ClientIP, msg.YourIP} {
+		if ip.IsUnspecified() || !nm.isRoutedIP(ip) {
+			continue
+		}
+		if device, ok := nm.Cache.Peek(routedDeviceID(ip.String())); ok {
+			return device
+		}
+	}
+	return nil
+}
+
+// recordCircuit makes attribution the current switch port of a device. It
+// returns the port the device was on and true when it moved; a device
+// returning to an earlier port extends its old attribution.
+func recordCircuit(device *models.DeviceInfo, attribution models.CircuitAttribution) (models.CircuitAttribution, bool) {
+	n := len(device.Circuits)
+	if n > 0 && samePort(device.Circuits[n-1], attribution) {
+		current := &device.Circuits[n-1]
+		current.LastSeen = attribution.LastSeen
+		if attribution.Relay != "" {
+			current.Relay = attribution.Relay
+		}
+		return models.CircuitAttribution{}, false
+	}
+
+	for i, held := range device.Circuits {
+		if samePort(held, attribution) {
+			attribution.FirstSeen = held.FirstSeen
+			device.Circuits = append(device.Circuits[:i], device.Circuits[i+1:]...)
+			break
+		}
+	}
+	var previous models.CircuitAttribution
+	if n > 0 {
+		previous = device.Circuits[len(device.Circuits)-1]
+	}
+	device.Circuits = append(device.Circuits, attribution)
+	if len(device.Circuits) > MaxCircuitHistory {
+		device.Circuits = append([]models.CircuitAttribution(nil), device.Circuits[len(device.Circuits)-MaxCircuitHistory:]...)
+	}
+	return previous, n > 0
+}
+
+// mergeCircuits adds the switch ports of src into dst, keeping the most
+// recently seen one current
+func mergeCircuits(dst, src *models.DeviceInfo) {
+	if len(src.Circuits) == 0 {
+		return
+	}
+	merged := append([]models.CircuitAttribution(nil), dst.Circuits...)
+	for _, attribution := range src.Circuits {
+		i := slices.IndexFunc(merged, func(held models.CircuitAttribution) bool { return samePort(held, attribution) })
+		if i < 0 {
+			merged = append(merged, attribution)
+			continue
+		}
+		if attribution.FirstSeen.Before(merged[i].FirstSeen) {
+			merged[i].FirstSeen = attribution.FirstSeen
+		}
+		if attribution.LastSeen.After(merged[i].LastSeen) {
+			merged[i].LastSeen = attribution.LastSeen
+			merged[i].Relay = attribution.Relay
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].LastSeen.Before(merged[j].LastSeen) })
+	if len(merged) > MaxCircuitHistory {
+		merged = merged[len(merged)-MaxCircuitHistory:]
+	}
+	dst.Circuits = merged
+}
+
+// samePort reports whether two attributions name the same switch port. The
+// relay may differ: a port keeps its IDs whichever relay forwards it.
+func samePort(a, b models.CircuitAttribution) bool {
+	return a.CircuitID == b.CircuitID && a.RemoteID == b.RemoteID
+}
+
+// circuitLabel names a switch port by its circuit ID and, when known, the
+// remote ID of its switch
+func circuitLabel(attribution models.CircuitAttribution) string {
+	if attribution.RemoteID == "" {
+		return attribution.CircuitID
+	}
+	if attribution.CircuitID == "" {
+		return "@" + attribution.RemoteID
+	}
+	return attribution.CircuitID + " @ " + attribution.RemoteID
+}
+
+// CurrentCircuit returns the switch port a device was last attributed to
+func CurrentCircuit(device *models.DeviceInfo) (models.CircuitAttribution, bool) {
+	if len(device.Circuits) == 0 {
+		return models.CircuitAttribution{}, false
+	}
+	return device.Circuits[len(device.Circuits)-1], true
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Diff compares the persisted inventory between from and to. Devices first
+// seen in [from, to) were added. Devices last seen in the equally long period
+// before from were removed, since they went silent at from. Last-seen times
+// only move forward, so for a range in the past a device that went silent and
+// came back after to is not listed as removed. At most patternLimit new
+// patterns are returned (0 means no limit).
+func (nm *NetworkMonitor) Diff(ctx context.Context, from, to time.Time, patternLimit int) (*models.InventoryDiff, error) {
+	diff := &models.InventoryDiff{
+		From:           from,
+		To:             to,
+		AddedDevices:   []models.DeviceChange{},
+		RemovedDevices: []models.DeviceChange{},
+		NewPatterns:    []*models.CommunicationPattern{},
+	}
+	removedSince := from.Add(-to.Sub(from))
+
+	devices := func(key, _ string) bool { return isDeviceKey(key) }
+	_, err := nm.bulkScan(ctx, BulkQuery{}, "", deviceKeyEnd, devices, func(value string) error {
+		var device models.DeviceChange
+		if json.Unmarshal([]byte(value), &device) != nil {
+			return nil
+		}
+		if device.ID == "" {
+			device.ID = device.MAC
+		}
+		switch {
+		case !device.FirstSeen.Before(from) && device.FirstSeen.Before(to):
+			diff.AddedDevices = append(diff.AddedDevices, device)
+		case !device.LastSeen.Before(removedSince) && device.LastSeen.Before(from):
+			diff.RemovedDevices = append(diff.RemovedDevices, device)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	q := BulkQuery{Since: from, Until: to, Limit: patternLimit}
+	result, err := nm.BulkPatterns(ctx, q, func(value string) error {
+		var pattern models.CommunicationPattern
+		if err := json.Unmarshal([]byte(value), &pattern); err != nil {
+			return nil
+		}
+		diff.NewPatterns = append(diff.NewPatterns, &pattern)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	diff.PatternsTruncated = !result.Complete
+
+	sort.Slice(diff.AddedDevices, func(i, j int) bool {
+		return diff.AddedDevices[i].FirstSeen.Before(diff.AddedDevices[j].FirstSeen)
+	})
+	sort.Slice(diff.RemovedDevices, func(i, j int) bool {
+		return diff.RemovedDevices[i].LastSeen.Before(diff.RemovedDevices[j].LastSeen)
+	})
+	return diff, nil
+}
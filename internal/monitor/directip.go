@@ -0,0 +1,225 @@
+package monitor
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// directIPLearningPeriod is how long a new device only learns: destinations it
+// contacts meanwhile are its baseline and never count as unresolved
+const directIPLearningPeriod = time.Hour
+
+// directIPMaxResolutions bounds the (client, answer) pairs remembered at once
+const directIPMaxResolutions = 100000
+
+// directIPMaxDevices bounds the devices with a counting window at once
+const directIPMaxDevices = 10000
+
+// directIPProtocols are the pattern protocols of connections that normally
+// follow a name lookup
+var directIPProtocols = map[string]bool{"TCP": true, "HTTP": true, "TLS": true}
+
+// DirectIPConfig controls detection of connections to IPs a device never resolved
+type DirectIPConfig struct {
+	Window        time.Duration // How long a DNS answer vouches for its IPs, and the counting window
+	MaxUnresolved int           // Unresolved external destinations within Window that raise an anomaly (MEDIUM at twice as many)
+	Allow         []*net.IPNet  // Destinations never counted as unresolved (CDNs, hardcoded services)
+}
+
+// DefaultDirectIPConfig returns the default direct-IP detection settings
+func DefaultDirectIPConfig() DirectIPConfig {
+	return DirectIPConfig{Window: 10 * time.Minute, MaxUnresolved: 20}
+}
+
+type dnsResolutionKey struct {
+	client string // IP the answer was sent to
+	addr   string // IP in the answer
+}
+
+type directIPState struct {
+	windowStart  time.Time
+	destinations map[string]bool // Unresolved destinations this window, capped at twice MaxUnresolved
+	patterns     []string        // IDs of their patterns
+	severity     string          // Severity alerted this window, if any
+}
+
+// directIPDetector remembers which IPs each client had resolved and flags
+// devices that keep connecting to external IPs without looking them up. It is
+// guarded by nm.mu.
+type directIPDetector struct {
+	config      DirectIPConfig
+	resolutions map[dnsResolutionKey]time.Time // Time of the latest answer
+	devices     map[string]*directIPState
+}
+
+func newDirectIPDetector(config DirectIPConfig) *directIPDetector {
+	return &directIPDetector{
+		config:      config,
+		resolutions: make(map[dnsResolutionKey]time.Time),
+		devices:     make(map[string]*directIPState),
+	}
+}
+
+// SetDirectIPConfig replaces the direct-IP detection settings
+func (nm *NetworkMonitor) SetDirectIPConfig(config DirectIPConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.directIP.config = config
+}
+
+// TrackDNSResponse records the addresses a DNS response resolved for its client
+func (nm *NetworkMonitor) TrackDNSResponse(evt *models.DNSQueryEvent) {
+	nm.observeUplinkDNS(evt)
+	nm.observeHealthDNS(evt)
+
+	addrs := utils.DNSAnswerAddrs(evt.Data)
+	if len(addrs) == 0 {
+		return
+	}
+	client := utils.IPFromBEUint32(evt.DstIP).String()
+	now := time.Now()
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	d := nm.directIP
+	for _, addr := range addrs {
+		key := dnsResolutionKey{client: client, addr: addr.String()}
+		if _, ok := d.resolutions[key]; !ok && len(d.resolutions) >= directIPMaxResolutions {
+			d.prune(now)
+			if len(d.resolutions) >= directIPMaxResolutions {
+				return
+			}
+		}
+		d.resolutions[key] = now
+	}
+}
+
+// prune forgets answers older than the window
+func (d *directIPDetector) prune(now time.Time) {
+	for key, resolved := range d.resolutions {
+		if now.Sub(resolved) > d.config.Window {
+			delete(d.resolutions, key)
+		}
+	}
+}
+
+// observeDirectIP reports whether the device resolved the external destination
+// of a new connection pattern within the window. Unless exempt, it counts the
+// result and raises an anomaly when too many destinations in a window were
+// never looked up. Must hold nm.mu.
+func (nm *NetworkMonitor) observeDirectIP(device *models.DeviceInfo, srcIP, dstIP, patternID string, exempt bool, now time.Time) (bool, verdict) {
+	d := nm.directIP
+	config := d.config
+
+	resolvedAt, ok := d.resolutions[dnsResolutionKey{client: srcIP, addr: dstIP}]
+	resolved := ok && now.Sub(resolvedAt) <= config.Window
+	if exempt {
+		return resolved, skipped(reasonExempt)
+	}
+	if resolved {
+		device.ResolvedPatterns++
+		return true, notMatched("destination resolved through DNS within the window")
+	}
+	device.DirectIPPatterns++
+
+	if now.Sub(device.FirstSeen) < directIPLearningPeriod {
+		return false, skipped("device still learning")
+	}
+	if ip := net.ParseIP(dstIP); ip != nil {
+		for _, allowed := range config.Allow {
+			if allowed.Contains(ip) {
+				return false, skipped("destination allowed")
+			}
+		}
+	}
+
+	state := d.devices[device.ID]
+	if state == nil || now.Sub(state.windowStart) > config.Window {
+		if len(d.devices) >= directIPMaxDevices {
+			d.pruneDevices(now)
+		}
+		state = &directIPState{windowStart: now, destinations: make(map[string]bool)}
+		d.devices[device.ID] = state
+	}
+	if len(state.destinations) >= 2*config.MaxUnresolved || state.destinations[dstIP] {
+		return false, notMatched("destination already counted or window full").
+			compare(float64(len(state.destinations)), float64(2*config.MaxUnresolved))
+	}
+	state.destinations[dstIP] = true
+	if patternID != "" && len(state.patterns) < maxAnomalyPatterns {
+		state.patterns = append(state.patterns, patternID)
+	}
+
+	count := len(state.destinations)
+	threshold := config.MaxUnresolved // The next one to cross
+	if count > threshold {
+		threshold *= 2
+	}
+	severity := ""
+	switch {
+	case len(state.destinations) == 2*config.MaxUnresolved:
+		severity = models.SeverityMedium
+	case len(state.destinations) == config.MaxUnresolved:
+		severity = models.SeverityLow
+	}
+	if severity == "" {
+		return false, notMatched("too few unresolved destinations within the window").
+			compare(float64(count), float64(threshold))
+	}
+	if state.severity == severity {
+		return false, notMatched("already alerted at this severity within the window").
+			compare(float64(count), float64(threshold))
+	}
+	state.severity = severity
+
+	destinations := make([]string, 0, len(state.destinations))
+	for destination := range state.destinations {
+		destinations = append(destinations, destination)
+	}
+	sort.Strings(destinations)
+
+	anomaly := nm.raiseLinkedAnomaly("DIRECT_IP_CONNECTIONS", severity, device.ID,
+		map[string]string{
+			"device_name": device.Name,
+			"count":       strconv.Itoa(len(destinations)),
+			"elapsed":     now.Sub(state.windowStart).Round(time.Second).String(),
+		},
+		map[string]string{
+			"count":        strconv.Itoa(len(destinations)),
+			"destinations": strings.Join(destinations, ","),
+			"window":       config.Window.String(),
+		}, state.patterns)
+	return false, raised("unresolved destinations within the window reached the threshold", anomaly).
+		compare(float64(count), float64(threshold))
+}
+
+// detectDirectIP is the pattern detector of connections to external IPs never
+// looked up. It records on the pattern whether its destination was resolved.
+func (nm *NetworkMonitor) detectDirectIP(check *patternCheck) verdict {
+	pattern := check.pattern
+	if !check.external {
+		return skipped(reasonNotExternal)
+	}
+	if !directIPProtocols[pattern.Protocol] {
+		return skipped("protocol not checked")
+	}
+	resolved, v := nm.observeDirectIP(check.device, pattern.SrcIP, pattern.DstIP, pattern.ID, check.exempt, check.now)
+	pattern.Resolved = &resolved
+	return v
+}
+
+// pruneDevices forgets devices whose window has ended
+func (d *directIPDetector) pruneDevices(now time.Time) {
+	for id, state := range d.devices {
+		if now.Sub(state.windowStart) > d.config.Window {
+			delete(d.devices, id)
+		}
+	}
+}
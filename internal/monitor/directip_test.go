@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+var directIPStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// dnsResponse returns a DNS response sent to client answering addrs, as the
+// kernel hands it over
+func dnsResponse(t *testing.T, client string, addrs ...string) *models.DNSQueryEvent {
+	t.Helper()
+	msg := make([]byte, 12)
+	msg[2] = 0x81 // Response, recursion desired
+	binary.BigEndian.PutUint16(msg[6:8], uint16(len(addrs)))
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr).To4()
+		if ip == nil {
+			t.Fatalf("invalid IPv4 address %q", addr)
+		}
+		msg = append(msg, 0, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4) // Root name, A, IN, TTL 60
+		msg = append(msg, ip...)
+	}
+	return &models.DNSQueryEvent{SrcIP: beIP(t, "192.168.1.1"), DstIP: beIP(t, client), Data: msg}
+}
+
+// directIPDevice returns a device past its direct-IP learning period
+func directIPDevice(mac string) *models.DeviceInfo {
+	return &models.DeviceInfo{ID: mac, MAC: mac, FirstSeen: directIPStart.Add(-2 * directIPLearningPeriod)}
+}
+
+// checkDirectIP runs the direct-IP detector on a new TLS pattern from src to
+// dst, returning the pattern as the detector marked it
+func checkDirectIP(nm *NetworkMonitor, device *models.DeviceInfo, src, dst string, at time.Time) (*models.CommunicationPattern, verdict) {
+	pattern := &models.CommunicationPattern{ID: "p-" + dst, DeviceID: device.ID, SrcIP: src, DstIP: dst, Protocol: "TLS"}
+	nm.lockAll()
+	defer nm.unlockAll()
+	return pattern, nm.detectDirectIP(&patternCheck{device: device, pattern: pattern, external: true, now: at})
+}
+
+// A DNS answer vouches for its addresses to the client it was sent to, for
+// the window: patterns within it are marked resolved, later ones and those
+// of other clients aren't, and pruning forgets the expired answers
+func TestDirectIPResolution(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	window := 10 * time.Minute
+	nm.SetDirectIPConfig(DirectIPConfig{Window: window, MaxUnresolved: 20})
+	nm.TrackDNSResponse(dnsResponse(t, "192.168.1.10", "203.0.113.5", "203.0.113.6"))
+
+	// Answers are stamped with the wall clock; move them to the simulated one
+	nm.lockAll()
+	if n := len(nm.directIP.resolutions); n != 2 {
+		t.Errorf("%d resolutions, want 2", n)
+	}
+	for key := range nm.directIP.resolutions {
+		nm.directIP.resolutions[key] = directIPStart
+	}
+	nm.unlockAll()
+
+	laptop, phone := directIPDevice("02:00:00:00:00:0a"), directIPDevice("02:00:00:00:00:0b")
+	tests := []struct {
+		name   string
+		device *models.DeviceInfo
+		src    string
+		dst    string
+		after  time.Duration // Since the answer
+		want   bool
+	}{
+		{"resolved", laptop, "192.168.1.10", "203.0.113.5", time.Minute, true},
+		{"end of the window", laptop, "192.168.1.10", "203.0.113.6", window, true},
+		{"never resolved", laptop, "192.168.1.10", "203.0.113.7", time.Minute, false},
+		{"answer expired", laptop, "192.168.1.10", "203.0.113.5", window + time.Second, false},
+		{"answer of another client", phone, "192.168.1.11", "203.0.113.5", time.Minute, false},
+	}
+	for _, tt := range tests {
+		pattern, v := checkDirectIP(nm, tt.device, tt.src, tt.dst, directIPStart.Add(tt.after))
+		if pattern.Resolved == nil || *pattern.Resolved != tt.want {
+			t.Errorf("%s: resolved = %v (%s), want %v", tt.name, pattern.Resolved, v.reason, tt.want)
+		}
+	}
+	if laptop.ResolvedPatterns != 2 || laptop.DirectIPPatterns != 2 || phone.ResolvedPatterns != 0 || phone.DirectIPPatterns != 1 {
+		t.Errorf("laptop %d resolved and %d direct, phone %d and %d, want 2 and 2, 0 and 1",
+			laptop.ResolvedPatterns, laptop.DirectIPPatterns, phone.ResolvedPatterns, phone.DirectIPPatterns)
+	}
+
+	// Connections that don't follow lookups are left unmarked
+	udp := &models.CommunicationPattern{SrcIP: "192.168.1.10", DstIP: "203.0.113.5", Protocol: "UDP"}
+	nm.lockAll()
+	v := nm.detectDirectIP(&patternCheck{device: laptop, pattern: udp, external: true, now: directIPStart})
+	nm.unlockAll()
+	if v.outcome != OutcomeSkipped || udp.Resolved != nil {
+		t.Errorf("UDP pattern = %s, resolved %v, want skipped and unmarked", v.outcome, udp.Resolved)
+	}
+
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.directIP.prune(directIPStart.Add(window))
+	if n := len(nm.directIP.resolutions); n != 2 {
+		t.Errorf("%d resolutions left at the end of the window, want 2", n)
+	}
+	nm.directIP.prune(directIPStart.Add(window + time.Second))
+	if n := len(nm.directIP.resolutions); n != 0 {
+		t.Errorf("%d resolutions left after the window, want 0", n)
+	}
+}
+
+// Each device counts unresolved destinations in its own window, starting
+// when it reaches the first one: LOW at MaxUnresolved, MEDIUM at twice as
+// many, and again in the next window
+func TestDirectIPWindows(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	window := 10 * time.Minute
+	nm.SetDirectIPConfig(DirectIPConfig{Window: window, MaxUnresolved: 2})
+	laptop, phone := directIPDevice("02:00:00:00:00:0a"), directIPDevice("02:00:00:00:00:0b")
+
+	tests := []struct {
+		device *models.DeviceInfo
+		dst    string
+		after  time.Duration // Since the start
+		want   string
+	}{
+		{laptop, "203.0.113.1", 0, OutcomeNotMatched},
+		{laptop, "203.0.113.2", time.Minute, OutcomeMatched}, // LOW
+		{phone, "203.0.113.1", 2 * time.Minute, OutcomeNotMatched},
+		{laptop, "203.0.113.1", 3 * time.Minute, OutcomeNotMatched}, // Already counted
+		{laptop, "203.0.113.3", 3 * time.Minute, OutcomeNotMatched},
+		{laptop, "203.0.113.4", 4 * time.Minute, OutcomeMatched},    // MEDIUM
+		{laptop, "203.0.113.5", 5 * time.Minute, OutcomeNotMatched}, // Window full
+		// The laptop's window ended at 10 minutes, the phone's at 12
+		{laptop, "203.0.113.5", 11 * time.Minute, OutcomeNotMatched},
+		{laptop, "203.0.113.6", 12 * time.Minute, OutcomeMatched},
+		{phone, "203.0.113.2", 12 * time.Minute, OutcomeMatched},
+	}
+	src := map[*models.DeviceInfo]string{laptop: "192.168.1.10", phone: "192.168.1.11"}
+	for i, tt := range tests {
+		if _, v := checkDirectIP(nm, tt.device, src[tt.device], tt.dst, directIPStart.Add(tt.after)); v.outcome != tt.want {
+			t.Errorf("%d: %s to %s = %s (%s), want %s", i, tt.device.ID, tt.dst, v.outcome, v.reason, tt.want)
+		}
+	}
+
+	var raised []string
+	for _, anomaly := range nm.RecentAnomalies() {
+		if anomaly.Type == "DIRECT_IP_CONNECTIONS" {
+			raised = append(raised, anomaly.DeviceID+" "+anomaly.Severity+" "+anomaly.Details["destinations"])
+		}
+	}
+	want := []string{
+		laptop.ID + " LOW 203.0.113.1,203.0.113.2",
+		laptop.ID + " MEDIUM 203.0.113.1,203.0.113.2,203.0.113.3,203.0.113.4",
+		laptop.ID + " LOW 203.0.113.5,203.0.113.6",
+		phone.ID + " LOW 203.0.113.1,203.0.113.2",
+	}
+	if len(raised) != len(want) {
+		t.Fatalf("anomalies %q, want %q", raised, want)
+	}
+	for _, expected := range want {
+		if !slices.Contains(raised, expected) {
+			t.Errorf("no anomaly %q in %q", expected, raised)
+		}
+	}
+
+	// The phone's window has ended, the laptop's second one hasn't
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.directIP.pruneDevices(directIPStart.Add(12*time.Minute + time.Second))
+	if _, ok := nm.directIP.devices[phone.ID]; ok || len(nm.directIP.devices) != 1 {
+		t.Errorf("%d windows left, phone's kept %v, want the laptop's alone", len(nm.directIP.devices), ok)
+	}
+}
@@ -0,0 +1,234 @@
+package monitor
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// dnsEntropyMinLength is the shortest subdomain whose entropy is judged;
+// shorter names are too small for the estimate to mean anything
+const dnsEntropyMinLength = 24
+
+// dnsTunnelMaxTracked bounds the (device, parent domain) pairs tracked at once
+const dnsTunnelMaxTracked = 10000
+
+// genericSLDs are second-level labels under country TLDs that are not
+// registrable on their own (example.co.uk is a parent, co.uk is not)
+var genericSLDs = map[string]bool{
+	"ac": true, "co": true, "com": true, "edu": true, "gov": true, "net": true, "org": true,
+}
+
+// DNSTunnelConfig controls DNS tunneling detection
+type DNSTunnelConfig struct {
+	MaxLabelLength int           // Longer labels make a query suspicious
+	MaxEntropy     float64       // Higher subdomain entropy (bits/char) makes a query suspicious
+	MaxSuspicious  int           // Suspicious queries under one parent domain that raise an anomaly
+	MaxSubdomains  int           // Unique subdomains under one parent domain that raise an anomaly
+	Window         time.Duration // Window the counts are taken over
+}
+
+// DefaultDNSTunnelConfig returns the default DNS tunneling detection settings
+func DefaultDNSTunnelConfig() DNSTunnelConfig {
+	return DNSTunnelConfig{
+		MaxLabelLength: 50,
+		MaxEntropy:     4.0,
+		MaxSuspicious:  10,
+		MaxSubdomains:  100,
+		Window:         5 * time.Minute,
+	}
+}
+
+type dnsTunnelKey struct {
+	deviceID string
+	parent   string
+}
+
+type dnsTunnelState struct {
+	windowStart time.Time
+	lastSeen    time.Time
+	subdomains  map[string]bool // Unique subdomains this window, capped at MaxSubdomains
+	suspicious  int             // Suspicious queries this window
+	sample      string          // Most recent suspicious name
+	alerted     bool            // Alerted once per episode, until the pair goes idle
+}
+
+// dnsTunnelDetector flags devices whose queries under one parent domain look
+// like data encoded into names. It is guarded by nm.mu.
+type dnsTunnelDetector struct {
+	config DNSTunnelConfig
+	states map[dnsTunnelKey]*dnsTunnelState
+}
+
+func newDNSTunnelDetector(config DNSTunnelConfig) *dnsTunnelDetector {
+	return &dnsTunnelDetector{
+		config: config,
+		states: make(map[dnsTunnelKey]*dnsTunnelState),
+	}
+}
+
+// SetDNSTunnelConfig replaces the DNS tunneling detection settings
+func (nm *NetworkMonitor) SetDNSTunnelConfig(config DNSTunnelConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.dnsTunnel.config = config
+}
+
+// registeredDomain splits a query name into its registrable domain and the
+// subdomain labels below it, if any
+func registeredDomain(labels []string) (domain string, sub []string, ok bool) {
+	n := 2
+	if len(labels) >= 3 && len(labels[len(labels)-1]) == 2 && genericSLDs[labels[len(labels)-2]] {
+		n = 3
+	}
+	if len(labels) < n {
+		return "", nil, false
+	}
+	return strings.Join(labels[len(labels)-n:], "."), labels[:len(labels)-n], true
+}
+
+// parentDomain splits a query name into its registrable parent domain and the
+// subdomain labels below it. ok is false for names without a subdomain.
+func parentDomain(labels []string) (parent string, sub []string, ok bool) {
+	parent, sub, ok = registeredDomain(labels)
+	if !ok || len(sub) == 0 {
+		return "", nil, false
+	}
+	return parent, sub, true
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// TrackDNSQuery checks a captured DNS query for tunneling indicators and
+// attributes it to its sender
+func (nm *NetworkMonitor) TrackDNSQuery(evt *models.DNSQueryEvent) {
+	nm.observeUplinkDNS(evt)
+
+	labels := utils.DNSQuestionLabels(evt.Data)
+	// Reverse lookups have long, unique names by design
+	if len(labels) == 0 || labels[len(labels)-1] == "arpa" {
+		return
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	now := time.Now()
+	deviceID, _ := nm.identify(utils.MacToString(evt.SrcMac), utils.IPFromBEUint32(evt.SrcIP), models.EVENT_TYPE_DNS)
+	nm.scoreDomain(deviceID, labels, now)
+
+	parent, sub, ok := parentDomain(labels)
+	if !ok {
+		return
+	}
+	d := nm.dnsTunnel
+	config := d.config
+
+	subdomain := strings.Join(sub, ".")
+	longest := 0
+	for _, label := range sub {
+		longest = max(longest, len(label))
+	}
+	compact := strings.Join(sub, "")
+	entropy := 0.0
+	if len(compact) >= dnsEntropyMinLength {
+		entropy = shannonEntropy(compact)
+	}
+	longLabel := longest > config.MaxLabelLength
+	highEntropy := entropy > config.MaxEntropy
+
+	key := dnsTunnelKey{deviceID, parent}
+	state := d.states[key]
+	if state == nil || now.Sub(state.lastSeen) > config.Window {
+		if len(d.states) >= dnsTunnelMaxTracked {
+			d.prune(now)
+		}
+		state = &dnsTunnelState{windowStart: now, subdomains: make(map[string]bool)}
+		d.states[key] = state
+	} else if now.Sub(state.windowStart) > config.Window {
+		state.windowStart = now
+		state.subdomains = make(map[string]bool)
+		state.suspicious = 0
+	}
+	state.lastSeen = now
+
+	if len(state.subdomains) < config.MaxSubdomains {
+		state.subdomains[subdomain] = true
+	}
+	if longLabel || highEntropy {
+		state.suspicious++
+		state.sample = strings.Join(labels, ".")
+		if device, tracked := nm.Cache.Peek(deviceID); tracked {
+			unlock := nm.lockDevice(deviceID)
+			device.SuspiciousDNSQueries++
+			unlock()
+		}
+	}
+
+	var indicators []string
+	if state.suspicious >= config.MaxSuspicious {
+		indicators = append(indicators, "suspicious_names")
+	}
+	if len(state.subdomains) >= config.MaxSubdomains {
+		indicators = append(indicators, "unique_subdomains")
+	}
+	if len(indicators) == 0 || state.alerted {
+		return
+	}
+	state.alerted = true
+
+	severity := models.SeverityMedium
+	if len(indicators) > 1 {
+		severity = models.SeverityHigh
+	}
+
+	sample := state.sample
+	if sample == "" {
+		sample = strings.Join(labels, ".")
+	}
+
+	nm.raiseAnomaly("DNS_TUNNELING", severity, deviceID,
+		map[string]string{
+			"suspicious_queries": strconv.Itoa(state.suspicious),
+			"unique_subdomains":  strconv.Itoa(len(state.subdomains)),
+			"parent_domain":      parent,
+			"elapsed":            now.Sub(state.windowStart).Round(time.Second).String(),
+		},
+		map[string]string{
+			"parent_domain":      parent,
+			"indicators":         strings.Join(indicators, ","),
+			"suspicious_queries": strconv.Itoa(state.suspicious),
+			"unique_subdomains":  strconv.Itoa(len(state.subdomains)),
+			"sample":             sample,
+			"window":             config.Window.String(),
+		})
+}
+
+// prune forgets pairs idle for longer than the window
+func (d *dnsTunnelDetector) prune(now time.Time) {
+	for key, state := range d.states {
+		if now.Sub(state.lastSeen) > d.config.Window {
+			delete(d.states, key)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Heuristics that add to a device's suspicious-domain score
+const (
+	HeuristicDGA            = "dga"             // Registered name looks algorithmically generated
+	HeuristicLongLabel      = "long_label"      // A label is unusually long
+	HeuristicManySubdomains = "many_subdomains" // Many unique subdomains under one domain
+)
+
+// dgaMinLength is the shortest label judged by dgaScore; shorter names
+// don't hold enough characters to tell random from abbreviated
+const dgaMinLength = 8
+
+// domainScoreMaxTracked bounds the devices scored at once, and
+// domainScoreMaxNames the names and subdomains remembered per device
+const (
+	domainScoreMaxTracked = 10000
+	domainScoreMaxNames   = 1000
+)
+
+// domainScoreSamples is how many sample names an anomaly lists
+const domainScoreSamples = 5
+
+// builtinDomainAllow are parents of high-entropy names that are legitimate:
+// CDN and cloud hostnames built from content hashes or resource IDs
+var builtinDomainAllow = []string{
+	"1e100.net",
+	"akamaiedge.net",
+	"akamaihd.net",
+	"akamaized.net",
+	"amazonaws.com",
+	"azureedge.net",
+	"azurefd.net",
+	"cloudapp.net",
+	"cloudflare.net",
+	"cloudfront.net",
+	"edgekey.net",
+	"edgesuite.net",
+	"fastly.net",
+	"fbcdn.net",
+	"githubusercontent.com",
+	"googleusercontent.com",
+	"googlevideo.com",
+	"gvt1.com",
+	"llnwd.net",
+	"nflxvideo.net",
+	"trafficmanager.net",
+	"windows.net",
+}
+
+// ErrInvalidDomain is wrapped by errors about allowlist entries
+var ErrInvalidDomain = errors.New("invalid domain")
+
+// DomainScoreConfig controls suspicious-domain scoring
+type DomainScoreConfig struct {
+	Threshold      float64       // Score that raises an anomaly
+	HalfLife       time.Duration // Time after which a score has decayed by half
+	MinDGAScore    float64       // dgaScore (0-1) from which a name counts as generated
+	MaxLabelLength int           // Longer labels count as long
+	MaxSubdomains  int           // Unique subdomains under one domain that count as many
+	Allow          []string      // Domains, with their subdomains, never scored; added to the built-in list
+}
+
+// DefaultDomainScoreConfig returns the default suspicious-domain settings
+func DefaultDomainScoreConfig() DomainScoreConfig {
+	return DomainScoreConfig{
+		Threshold:      10,
+		HalfLife:       15 * time.Minute,
+		MinDGAScore:    0.65,
+		MaxLabelLength: 40,
+		MaxSubdomains:  100,
+	}
+}
+
+// domainScore is the decaying score of one device
+type domainScore struct {
+	score      float64
+	updated    time.Time
+	names      map[string]bool            // Names already scored, so repeats add nothing
+	subdomains map[string]map[string]bool // Unique subdomains per parent domain
+	fired      map[string]int             // Times each heuristic added to the score
+	samples    []string                   // Most recent scored names with their heuristic
+	alerted    bool                       // Until the score decays to half the threshold
+}
+
+// domainScorer accumulates suspicious DNS names per device. It is guarded by
+// nm.mu.
+type domainScorer struct {
+	config DomainScoreConfig
+	allow  map[string]bool // Built-in and configured allowlist
+	scores map[string]*domainScore
+}
+
+func newDomainScorer(config DomainScoreConfig) *domainScorer {
+	d := &domainScorer{scores: make(map[string]*domainScore)}
+	d.configure(config)
+	return d
+}
+
+func (d *domainScorer) configure(config DomainScoreConfig) {
+	d.config = config
+	d.allow = make(map[string]bool, len(builtinDomainAllow)+len(config.Allow))
+	for _, domain := range builtinDomainAllow {
+		d.allow[domain] = true
+	}
+	for _, domain := range config.Allow {
+		d.allow[domain] = true
+	}
+}
+
+// SetDomainScoreConfig replaces the suspicious-domain settings. Allowlist
+// entries are normalized; an invalid one is an error wrapping
+// ErrInvalidDomain.
+func (nm *NetworkMonitor) SetDomainScoreConfig(config DomainScoreConfig) error {
+	allow, err := normalizeDomains(config.Allow)
+	if err != nil {
+		return err
+	}
+	config.Allow = allow
+
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.domainScores.configure(config)
+	return nil
+}
+
+// SetDomainAllowlist replaces the configured allowlist, keeping the other
+// suspicious-domain settings
+func (nm *NetworkMonitor) SetDomainAllowlist(domains []string) (models.DomainAllowlist, error) {
+	allow, err := normalizeDomains(domains)
+	if err != nil {
+		return models.DomainAllowlist{}, err
+	}
+
+	nm.lockAll()
+	defer nm.unlockAll()
+	config := nm.domainScores.config
+	config.Allow = allow
+	nm.domainScores.configure(config)
+	return nm.domainScores.allowlist(), nil
+}
+
+// DomainAllowlist returns the domains exempt from suspicious-domain scoring
+func (nm *NetworkMonitor) DomainAllowlist() models.DomainAllowlist {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	return nm.domainScores.allowlist()
+}
+
+func (d *domainScorer) allowlist() models.DomainAllowlist {
+	return models.DomainAllowlist{
+		BuiltIn:    append([]string{}, builtinDomainAllow...),
+		Configured: append([]string{}, d.config.Allow...),
+	}
+}
+
+// normalizeDomains lowercases domains and strips wildcard prefixes and
+// trailing dots, e.g. "*.Example.com." becomes "example.com"
+func normalizeDomains(domains []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		domain = strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+		if domain == "" {
+			continue
+		}
+		for _, label := range strings.Split(domain, ".") {
+			if label == "" || len(label) > 63 || strings.Trim(label, "abcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidDomain, domain)
+			}
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			normalized = append(normalized, domain)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// allowed reports whether a name, given as labels, is or is under an
+// allowlisted domain
+func (d *domainScorer) allowed(labels []string) bool {
+	for i := range labels {
+		if d.allow[strings.Join(labels[i:], ".")] {
+			return true
+		}
+	}
+	return false
+}
+
+// isHashLabel reports whether a label is a hex content hash (MD5, SHA-1,
+// SHA-256) or a UUID, which CDNs and cloud services put in hostnames
+func isHashLabel(label string) bool {
+	switch len(label) {
+	case 32, 40, 64:
+		return strings.Trim(label, "0123456789abcdef") == ""
+	case 36:
+		return label[8] == '-' && label[13] == '-' && label[18] == '-' && label[23] == '-' &&
+			strings.Trim(label, "0123456789abcdef-") == ""
+	}
+	return false
+}
+
+// dgaScore rates how algorithmically generated a single label looks, from 0
+// (pronounceable, like "wikipedia") to 1 (random, like "xjw8fk2lqa"). It
+// combines character diversity, scarce vowels, long consonant runs, digits
+// interleaved with letters, and length.
+func dgaScore(label string) float64 {
+	if len(label) < dgaMinLength {
+		return 0
+	}
+
+	var letters, vowels, digits, run, longestRun, switches int
+	prevDigit := false
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			letters++
+			if strings.IndexByte("aeiouy", c) >= 0 {
+				vowels++
+				run = 0
+			} else {
+				run++
+				longestRun = max(longestRun, run)
+			}
+			if i > 0 && prevDigit {
+				switches++
+			}
+			prevDigit = false
+		case c >= '0' && c <= '9':
+			digits++
+			run = 0
+			if i > 0 && !prevDigit {
+				switches++
+			}
+			prevDigit = true
+		default:
+			run = 0
+			prevDigit = false
+		}
+	}
+
+	// Diversity is relative to the most a label of this length can reach
+	diversity := shannonEntropy(label) / math.Log2(float64(min(len(label), 36)))
+	score := 0.3 * clamp01((diversity-0.8)/0.15)
+	if letters > 0 {
+		score += 0.3 * clamp01((0.35-float64(vowels)/float64(letters))/0.2)
+	}
+	score += 0.25 * clamp01(float64(longestRun-3)/3)
+	if letters > 0 && digits > 0 {
+		score += 0.25 * clamp01(float64(switches-1)/3)
+	}
+	score += 0.1 * clamp01(float64(len(label)-10)/10)
+	return min(score, 1)
+}
+
+func clamp01(x float64) float64 {
+	return min(max(x, 0), 1)
+}
+
+// domainHeuristics returns the points a name adds to its sender's score and
+// the heuristic responsible, or 0 if the name is unremarkable. parent and sub
+// are as returned by parentDomain; the registered label is the first label of
+// parent.
+func domainHeuristics(config DomainScoreConfig, parent string, sub []string) (float64, string) {
+	registered, _, _ := strings.Cut(parent, ".")
+	if !isHashLabel(registered) {
+		if score := dgaScore(registered); score >= config.MinDGAScore {
+			return 2 * score, HeuristicDGA
+		}
+	}
+	for _, label := range sub {
+		if len(label) > config.MaxLabelLength && !isHashLabel(label) {
+			return 1, HeuristicLongLabel
+		}
+	}
+	return 0, ""
+}
+
+// scoreDomain adds a queried name to its sender's score and raises a
+// SUSPICIOUS_DOMAINS anomaly when the score crosses the threshold. Must hold
+// nm.mu.
+func (nm *NetworkMonitor) scoreDomain(deviceID string, labels []string, now time.Time) {
+	d := nm.domainScores
+	config := d.config
+	if config.Threshold <= 0 || d.allowed(labels) {
+		return
+	}
+	parent, sub, ok := registeredDomain(labels)
+	if !ok {
+		return
+	}
+	name := strings.Join(labels, ".")
+
+	state := d.scores[deviceID]
+	if state == nil {
+		if len(d.scores) >= domainScoreMaxTracked {
+			d.prune(now)
+		}
+		state = &domainScore{
+			updated:    now,
+			names:      make(map[string]bool),
+			subdomains: make(map[string]map[string]bool),
+			fired:      make(map[string]int),
+		}
+		d.scores[deviceID] = state
+	}
+	state.decay(now, config.HalfLife)
+	if state.score < config.Threshold/2 {
+		state.alerted = false
+	}
+	// Once the score has faded, earlier names may count again
+	if state.score < 0.1 && len(state.fired) > 0 {
+		state.score = 0
+		clear(state.names)
+		clear(state.subdomains)
+		clear(state.fired)
+		state.samples = nil
+	}
+	if state.names[name] {
+		return
+	}
+	if len(state.names) < domainScoreMaxNames {
+		state.names[name] = true
+	}
+
+	points, heuristic := domainHeuristics(config, parent, sub)
+
+	// Unique subdomains count once per domain, when they reach the limit
+	if len(sub) > 0 && config.MaxSubdomains > 0 {
+		subdomains := state.subdomains[parent]
+		if subdomains == nil && len(state.subdomains) < domainScoreMaxNames {
+			subdomains = make(map[string]bool)
+			state.subdomains[parent] = subdomains
+		}
+		if subdomains != nil && len(subdomains) < config.MaxSubdomains {
+			subdomains[strings.Join(sub, ".")] = true
+			if len(subdomains) == config.MaxSubdomains {
+				points += config.Threshold / 2
+				heuristic = HeuristicManySubdomains
+			}
+		}
+	}
+	if points == 0 {
+		return
+	}
+
+	state.score += points
+	state.fired[heuristic]++
+	state.samples = append(state.samples, name+" ("+heuristic+")")
+	if len(state.samples) > domainScoreSamples {
+		state.samples = state.samples[1:]
+	}
+	if state.score < config.Threshold || state.alerted {
+		return
+	}
+	state.alerted = true
+
+	var heuristics []string
+	for h := range state.fired {
+		heuristics = append(heuristics, h)
+	}
+	sort.Strings(heuristics)
+	fired := make([]string, len(heuristics))
+	for i, h := range heuristics {
+		fired[i] = h + "=" + strconv.Itoa(state.fired[h])
+	}
+
+	nm.raiseAnomaly("SUSPICIOUS_DOMAINS", models.SeverityMedium, deviceID,
+		map[string]string{
+			"score":      strconv.FormatFloat(state.score, 'f', 1, 64),
+			"heuristics": strings.Join(heuristics, ", "),
+			"domain":     name,
+		},
+		map[string]string{
+			"score":      strconv.FormatFloat(state.score, 'f', 1, 64),
+			"threshold":  strconv.FormatFloat(config.Threshold, 'f', 1, 64),
+			"heuristic":  heuristic,
+			"heuristics": strings.Join(fired, ","),
+			"samples":    strings.Join(state.samples, ", "),
+		})
+}
+
+// decay brings a score to now, halving it every halfLife
+func (s *domainScore) decay(now time.Time, halfLife time.Duration) {
+	if elapsed := now.Sub(s.updated); elapsed > 0 && halfLife > 0 {
+		s.score *= math.Exp2(-float64(elapsed) / float64(halfLife))
+	}
+	s.updated = now
+}
+
+// prune forgets devices whose score has decayed to almost nothing. Their
+// remembered names go too, so repeats count again.
+func (d *domainScorer) prune(now time.Time) {
+	for deviceID, state := range d.scores {
+		state.decay(now, d.config.HalfLife)
+		if state.score < 0.1 {
+			delete(d.scores, deviceID)
+		}
+	}
+}
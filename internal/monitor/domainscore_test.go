@@ -0,0 +1,203 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Generated names score high, names of real services and words low, and
+// labels too short to tell not at all
+func TestDGAScore(t *testing.T) {
+	benign := []string{"wikipedia", "facebook", "microsoft", "googleapis", "stackoverflow", "instagram", "cloudflare", "bankofamerica", "washingtonpost"}
+	generated := []string{"xjw8fk2lqa", "qzkfhwpxvtrmb", "a8f3k2m9x7q1", "kq3v9z7xwp", "ppzktvhmrq", "h7gd9s2kfq8z", "mxzqkwtrplvb", "9s8d7f6g5h4j"}
+	for _, label := range benign {
+		if score := dgaScore(label); score >= 0.45 {
+			t.Errorf("dgaScore(%q) = %.2f, want below 0.45", label, score)
+		}
+	}
+	for _, label := range generated {
+		if score := dgaScore(label); score < 0.8 {
+			t.Errorf("dgaScore(%q) = %.2f, want at least 0.8", label, score)
+		}
+	}
+	for _, label := range []string{"netflix", "x7kq2z", "kwyjibo"} {
+		if score := dgaScore(label); score != 0 {
+			t.Errorf("dgaScore(%q) = %.2f, want 0 below %d characters", label, score, dgaMinLength)
+		}
+	}
+}
+
+// Each name fires at most one heuristic: a generated registered label first,
+// then an overlong subdomain label; content hashes and UUIDs fire neither
+func TestDomainHeuristics(t *testing.T) {
+	config := DefaultDomainScoreConfig()
+	sha256 := strings.Repeat("9f86d081884c7d65", 4)
+	tests := []struct {
+		name      string
+		heuristic string
+	}{
+		// DGA
+		{"xjw8fk2lqa.com", HeuristicDGA},
+		{"www.qzkfhwpxvtrmb.net", HeuristicDGA},
+		{"abcd.h7gd9s2kfq8z.co.uk", HeuristicDGA}, // Under a generic second-level domain
+		// Tunneling
+		{"mfrggzdfmztwq2lknnwg23tnmfrggzdfmztwq2lknnwg23tn.t.example.com", HeuristicLongLabel},
+		{"nbswy3dpeb3w64tmmqqgc3tomqqgk3tdn5wgk3lsmuqgc3dmovzsa.example.com", HeuristicLongLabel},
+		// Long labels
+		{strings.Repeat("a", 41) + ".example.com", HeuristicLongLabel},
+		{strings.Repeat("a", 40) + ".example.com", ""},
+		// CDN hashes
+		{"d41d8cd98f00b204e9800998ecf8427e.example.com", ""},
+		{sha256 + ".blob.example.net", ""},
+		{"da39a3ee5e6b4b0d3255bfef95601890afd80709.com", ""},
+		{"3f2504e0-4f89-11d3-9a0c-0305e82c3301.example.net", ""},
+		// Benign
+		{"www.wikipedia.org", ""},
+		{"login.microsoftonline.com", ""},
+		{"news.bbc.co.uk", ""},
+	}
+	for _, tt := range tests {
+		parent, sub, ok := registeredDomain(strings.Split(tt.name, "."))
+		if !ok {
+			t.Fatalf("%s: no registered domain", tt.name)
+		}
+		points, heuristic := domainHeuristics(config, parent, sub)
+		if heuristic != tt.heuristic {
+			t.Errorf("%s: heuristic %q, want %q", tt.name, heuristic, tt.heuristic)
+		}
+		var want float64
+		switch heuristic {
+		case HeuristicDGA:
+			registered, _, _ := strings.Cut(parent, ".")
+			want = 2 * dgaScore(registered)
+		case HeuristicLongLabel:
+			want = 1
+		}
+		if points != want {
+			t.Errorf("%s: %.2f points, want %.2f", tt.name, points, want)
+		}
+	}
+}
+
+// Allowlisted domains cover themselves and their subdomains, not names
+// merely ending the same way; configured entries are normalized
+func TestDomainAllowed(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	config := DefaultDomainScoreConfig()
+	config.Allow = []string{"*.Corp.Example.", "telemetry.vendor.example"}
+	if err := nm.SetDomainScoreConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if got := nm.DomainAllowlist().Configured; !slices.Equal(got, []string{"corp.example", "telemetry.vendor.example"}) {
+		t.Errorf("configured allowlist = %v", got)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"d41d8cd98f00b204e9800998ecf8427e.cloudfront.net", true},
+		{"cloudfront.net", true},
+		{"r3---sn-4g5e6nze.googlevideo.com", true},
+		{"xjw8fk2lqa.corp.example", true},
+		{"a.b.corp.example", true},
+		{"eu.telemetry.vendor.example", true},
+		{"vendor.example", false},
+		{"evilcloudfront.net", false},
+		{"cloudfront.net.xjw8fk2lqa.com", false},
+		{"example", false},
+	}
+	nm.lockAll()
+	defer nm.unlockAll()
+	for _, tt := range tests {
+		if got := nm.domainScores.allowed(strings.Split(tt.name, ".")); got != tt.want {
+			t.Errorf("allowed(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	for _, domain := range []string{"bad..example", "under score!.example", strings.Repeat("a", 64) + ".example"} {
+		if _, err := normalizeDomains([]string{domain}); !errors.Is(err, ErrInvalidDomain) {
+			t.Errorf("normalizeDomains(%q) = %v, want %v", domain, err, ErrInvalidDomain)
+		}
+	}
+}
+
+// A device's score adds up the heuristics its names fire, each name once
+// until the score fades, and crossing the threshold raises one anomaly
+// naming them; allowlisted names are never scored
+func TestScoreDomain(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	config := DefaultDomainScoreConfig()
+	config.MaxSubdomains = 5
+	if err := nm.SetDomainScoreConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	const infected, browser = "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	score := func(device string, names ...string) float64 {
+		t.Helper()
+		nm.lockAll()
+		defer nm.unlockAll()
+		for _, name := range names {
+			nm.scoreDomain(device, strings.Split(name, "."), now)
+		}
+		if state := nm.domainScores.scores[device]; state != nil {
+			return state.score
+		}
+		return 0
+	}
+
+	// Tunneling: unique subdomains under one domain count once, at the limit
+	var tunnel []string
+	for i := range 5 {
+		tunnel = append(tunnel, fmt.Sprintf("q%d.t.tunnel.example", i))
+	}
+	if got := score(infected, tunnel[:4]...); got != 0 {
+		t.Errorf("score below the subdomain limit = %.2f, want 0", got)
+	}
+	if got := score(infected, tunnel[4]); got != config.Threshold/2 {
+		t.Errorf("score at the subdomain limit = %.2f, want %.2f", got, config.Threshold/2)
+	}
+	long := strings.Repeat("mfrggzdfmztwq2lk", 3)
+	got := score(infected, long+".exfil.example", long+"x.exfil.example", long+"y.exfil.example")
+	if want := config.Threshold/2 + 3; got != want {
+		t.Errorf("score after long labels = %.2f, want %.2f", got, want)
+	}
+	dga := 2 * dgaScore("xjw8fk2lqa")
+	if got := score(infected, "xjw8fk2lqa.com", "xjw8fk2lqa.com"); got != config.Threshold/2+3+dga {
+		t.Errorf("score after a repeated generated name = %.2f, want it counted once", got)
+	}
+	if n, _ := countAnomalies(nm, "SUSPICIOUS_DOMAINS"); n != 0 {
+		t.Fatalf("%d anomalies below the threshold", n)
+	}
+	score(infected, "qzkfhwpxvtrmb.net", "a8f3k2m9x7q1.org")
+
+	n, anomaly := countAnomalies(nm, "SUSPICIOUS_DOMAINS")
+	if n != 1 {
+		t.Fatalf("%d anomalies, want 1", n)
+	}
+	if anomaly.DeviceID != infected || anomaly.Details["heuristic"] != HeuristicDGA ||
+		anomaly.Details["heuristics"] != "dga=2,long_label=3,many_subdomains=1" {
+		t.Errorf("anomaly for %s, details %v", anomaly.DeviceID, anomaly.Details)
+	}
+
+	// Once the score has faded, the same names count again
+	now = now.Add(12 * config.HalfLife)
+	if got := score(infected, "xjw8fk2lqa.com"); got != dga {
+		t.Errorf("score of a generated name after fading = %.2f, want %.2f", got, dga)
+	}
+
+	// CDN hostnames and allowlisted generated names add nothing
+	for i := range 20 {
+		score(browser, fmt.Sprintf("%032x.cloudfront.net", i), fmt.Sprintf("xjw8fk2lq%d.azureedge.net", i))
+	}
+	nm.lockAll()
+	defer nm.unlockAll()
+	if _, ok := nm.domainScores.scores[browser]; ok {
+		t.Error("allowlisted names scored")
+	}
+}
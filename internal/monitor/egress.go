@@ -0,0 +1,398 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// EgressConfig requires the external traffic of tagged devices to go through
+// a local proxy or VPN gateway. A device reaching an external destination
+// other than a required endpoint connects directly, against its policy.
+type EgressConfig struct {
+	Policies []models.EgressPolicy // By device tag, as DeviceTags returns them
+	Allow    []string              // Direct traffic that is never a violation, as PROTO/port
+	Window   time.Duration         // Window direct connections are counted in
+}
+
+// DefaultEgressConfig returns the default egress settings. No device is
+// subject to a policy until configured.
+func DefaultEgressConfig() EgressConfig {
+	return EgressConfig{Allow: []string{"UDP/123"}, Window: time.Hour}
+}
+
+// egressFile is the layout of the file read by LoadEgressFile
+type egressFile struct {
+	Egress struct {
+		Window   string                          `json:"window"`
+		Allow    []string                        `json:"allow"`
+		Policies map[string]egressPolicyFileItem `json:"policies"`
+	} `json:"egress"`
+}
+
+type egressPolicyFileItem struct {
+	Endpoints []string `json:"endpoints"`
+	MaxDirect int      `json:"max_direct"`
+}
+
+// LoadEgressFile reads the egress policies by device tag from the "egress"
+// section of a JSON file. Settings left out keep their defaults.
+func LoadEgressFile(path string) (EgressConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return EgressConfig{}, err
+	}
+	defer f.Close()
+
+	var file egressFile
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return EgressConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := DefaultEgressConfig()
+	if file.Egress.Window != "" {
+		window, err := time.ParseDuration(file.Egress.Window)
+		if err != nil || window <= 0 {
+			return EgressConfig{}, fmt.Errorf("%s: invalid window %q: expected a positive duration such as 1h", path, file.Egress.Window)
+		}
+		config.Window = window
+	}
+	if file.Egress.Allow != nil {
+		config.Allow = file.Egress.Allow
+	}
+	for tag, policy := range file.Egress.Policies {
+		config.Policies = append(config.Policies, models.EgressPolicy{
+			Tag:       tag,
+			Endpoints: policy.Endpoints,
+			MaxDirect: policy.MaxDirect,
+		})
+	}
+	if _, err := compileEgress(config); err != nil {
+		return EgressConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// egressEndpoint is a proxy or VPN endpoint parsed for matching
+type egressEndpoint struct {
+	network *net.IPNet
+	port    uint16 // 0 for any port
+}
+
+type egressPolicy struct {
+	models.EgressPolicy
+	endpoints []egressEndpoint
+}
+
+// egressDetector classifies the external traffic of devices subject to an
+// egress policy. It is guarded by nm.mu.
+type egressDetector struct {
+	config   EgressConfig
+	policies map[string]*egressPolicy // By tag
+	allow    map[string]bool          // PROTO/port
+}
+
+func newEgressDetector() *egressDetector {
+	return &egressDetector{config: DefaultEgressConfig()}
+}
+
+// compileEgress validates an egress configuration and parses it for matching
+func compileEgress(config EgressConfig) (*egressDetector, error) {
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("invalid egress window %s: expected a positive duration", config.Window)
+	}
+	allow, err := ParsePortWatch(strings.Join(config.Allow, ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress allow list: %w", err)
+	}
+	d := &egressDetector{
+		config:   config,
+		policies: make(map[string]*egressPolicy, len(config.Policies)),
+		allow:    make(map[string]bool, len(allow)),
+	}
+	for _, port := range allow {
+		d.allow[port] = true
+	}
+	d.config.Allow = allow
+	for _, policy := range config.Policies {
+		tag := strings.ToLower(strings.TrimSpace(policy.Tag))
+		if tag == "" {
+			return nil, fmt.Errorf("egress policy without a tag")
+		}
+		if _, ok := d.policies[tag]; ok {
+			return nil, fmt.Errorf("egress policy %s: defined twice", tag)
+		}
+		if len(policy.Endpoints) == 0 {
+			return nil, fmt.Errorf("egress policy %s: no endpoints", tag)
+		}
+		if policy.MaxDirect < 0 {
+			return nil, fmt.Errorf("egress policy %s: max_direct must not be negative", tag)
+		}
+		compiled := &egressPolicy{EgressPolicy: policy}
+		compiled.Tag = tag
+		for _, endpoint := range policy.Endpoints {
+			parsed, err := parseEgressEndpoint(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("egress policy %s: %w", tag, err)
+			}
+			compiled.endpoints = append(compiled.endpoints, parsed)
+		}
+		d.policies[tag] = compiled
+	}
+	return d, nil
+}
+
+// parseEgressEndpoint parses an IP or CIDR with an optional port, such as
+// 192.168.1.9:3128, 10.8.0.0/24 or [2001:db8::1]:51820
+func parseEgressEndpoint(s string) (egressEndpoint, error) {
+	var endpoint egressEndpoint
+	host := strings.TrimSpace(s)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return endpoint, fmt.Errorf("invalid endpoint %q: bad port", s)
+		}
+		host, endpoint.port = h, uint16(n)
+	}
+	if _, network, err := net.ParseCIDR(host); err == nil {
+		endpoint.network = network
+		return endpoint, nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return endpoint, fmt.Errorf("invalid endpoint %q: expected an IP or CIDR with an optional port", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	endpoint.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	return endpoint, nil
+}
+
+func (e egressEndpoint) matches(ip net.IP, port uint16) bool {
+	return (e.port == 0 || e.port == port) && e.network.Contains(ip)
+}
+
+// SetEgressConfig replaces the egress policies. The counters of devices whose
+// policy changed are kept.
+func (nm *NetworkMonitor) SetEgressConfig(config EgressConfig) error {
+	d, err := compileEgress(config)
+	if err != nil {
+		return err
+	}
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.egress = d
+	return nil
+}
+
+// policy returns the egress policy of a device. When several of its tags have
+// one, the most restrictive applies: the fewest direct connections tolerated,
+// then the fewest endpoints, then the first tag in order.
+func (d *egressDetector) policy(device *models.DeviceInfo) *egressPolicy {
+	if len(d.policies) == 0 {
+		return nil
+	}
+	var strictest *egressPolicy
+	for _, tag := range DeviceTags(device) {
+		policy, ok := d.policies[tag]
+		if !ok {
+			continue
+		}
+		if strictest == nil || policy.MaxDirect < strictest.MaxDirect ||
+			policy.MaxDirect == strictest.MaxDirect && len(policy.endpoints) < len(strictest.endpoints) {
+			strictest = policy
+		}
+	}
+	return strictest
+}
+
+// detectEgress is the pattern detector of devices bypassing their required
+// proxy or VPN. It counts the patterns of a device subject to a policy that
+// reach a required endpoint, and those reaching other external destinations
+// directly; more direct ones within a window than the policy tolerates make
+// the device non-compliant and raise a MEDIUM anomaly. Traffic to internal
+// destinations, such as DNS to the local resolver, and allowed ports are not
+// counted. Must hold nm.mu.
+func (nm *NetworkMonitor) detectEgress(check *patternCheck) verdict {
+	d := nm.egress
+	if len(d.policies) == 0 {
+		return skipped("no egress policies")
+	}
+	switch {
+	case check.exempt:
+		return skipped(reasonExempt)
+	case check.excluded:
+		return skipped(reasonExcluded)
+	}
+	device, pattern := check.device, check.pattern
+	policy := d.policy(device)
+	if policy == nil {
+		return skipped("no policy for the device's tags")
+	}
+	ip := net.ParseIP(pattern.DstIP)
+	if ip == nil {
+		return skipped("destination not an IP")
+	}
+
+	egress := device.Egress
+	if egress == nil {
+		egress = &models.EgressCompliance{Status: models.EgressCompliant, WindowStart: check.now}
+		device.Egress = egress
+	}
+	egress.Policy = policy.Tag
+	if check.now.Sub(egress.WindowStart) > d.config.Window {
+		egress.WindowStart, egress.WindowDirect = check.now, 0
+		egress.Status = models.EgressCompliant
+	}
+
+	for _, endpoint := range policy.endpoints {
+		if endpoint.matches(ip, pattern.DstPort) {
+			egress.Compliant++
+			return notMatched("destination is a required endpoint")
+		}
+	}
+	if !check.external {
+		return skipped(reasonNotExternal)
+	}
+	if d.allow[patternPortKey(pattern)] {
+		return skipped("port allowed")
+	}
+
+	destination := net.JoinHostPort(pattern.DstIP, strconv.Itoa(int(pattern.DstPort)))
+	now := check.now
+	egress.Direct++
+	egress.WindowDirect++
+	egress.LastDirect, egress.LastDirectAt = destination, &now
+
+	if egress.WindowDirect <= policy.MaxDirect {
+		return notMatched("direct connections within the window tolerated").
+			compare(float64(egress.WindowDirect), float64(policy.MaxDirect))
+	}
+	if egress.Status == models.EgressNonCompliant {
+		return notMatched("already non-compliant within the window").
+			compare(float64(egress.WindowDirect), float64(policy.MaxDirect))
+	}
+	egress.Status = models.EgressNonCompliant
+
+	var patterns []string
+	if pattern.ID != "" {
+		patterns = []string{pattern.ID}
+	}
+	anomaly := nm.raiseLinkedAnomaly("EGRESS_POLICY_VIOLATION", models.SeverityMedium, device.ID,
+		map[string]string{
+			"device_name": device.Name,
+			"count":       strconv.Itoa(egress.WindowDirect),
+			"policy":      policy.Tag,
+			"destination": destination,
+			"elapsed":     now.Sub(egress.WindowStart).Round(time.Second).String(),
+		},
+		map[string]string{
+			"policy":     policy.Tag,
+			"endpoints":  strings.Join(policy.Endpoints, ","),
+			"max_direct": strconv.Itoa(policy.MaxDirect),
+			"window":     d.config.Window.String(),
+		}, patterns)
+	return raised("direct connections within the window exceed the policy", anomaly).
+		compare(float64(egress.WindowDirect), float64(policy.MaxDirect))
+}
+
+// patternPortKey returns the PROTO/port of a pattern, as ParsePortWatch
+// writes them
+func patternPortKey(pattern *models.CommunicationPattern) string {
+	switch pattern.Protocol {
+	case "TCP", "HTTP", "TLS":
+		return "TCP/" + strconv.Itoa(int(pattern.DstPort))
+	case "UDP", "DNS":
+		return "UDP/" + strconv.Itoa(int(pattern.DstPort))
+	}
+	return pattern.Protocol
+}
+
+// mergeEgress adds the egress counters of src into dst, keeping the latest
+// window
+func mergeEgress(dst, src *models.DeviceInfo) {
+	if src.Egress == nil {
+		return
+	}
+	if dst.Egress == nil {
+		egress := *src.Egress
+		dst.Egress = &egress
+		return
+	}
+	merged := *dst.Egress
+	merged.Compliant += src.Egress.Compliant
+	merged.Direct += src.Egress.Direct
+	if src.Egress.WindowStart.After(merged.WindowStart) {
+		merged.Policy, merged.Status = src.Egress.Policy, src.Egress.Status
+		merged.WindowStart, merged.WindowDirect = src.Egress.WindowStart, src.Egress.WindowDirect
+	}
+	if src.Egress.LastDirectAt != nil && (merged.LastDirectAt == nil || src.Egress.LastDirectAt.After(*merged.LastDirectAt)) {
+		merged.LastDirect, merged.LastDirectAt = src.Egress.LastDirect, src.Egress.LastDirectAt
+	}
+	dst.Egress = &merged
+}
+
+// EgressStatus returns the egress compliance status of a device, "" when it
+// was never subject to a policy
+func EgressStatus(device *models.DeviceInfo) string {
+	if device.Egress == nil {
+		return ""
+	}
+	return device.Egress.Status
+}
+
+// EgressSummary returns the egress policies and the compliance of the
+// devices subject to one
+func (nm *NetworkMonitor) EgressSummary() models.EgressSummary {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	d := nm.egress
+	summary := models.EgressSummary{
+		Window:    d.config.Window.String(),
+		Allow:     append([]string{}, d.config.Allow...),
+		Policies:  make([]models.EgressPolicy, 0, len(d.policies)),
+		Violators: []models.EgressViolator{},
+	}
+	for _, policy := range d.policies {
+		summary.Policies = append(summary.Policies, policy.EgressPolicy)
+	}
+	sort.Slice(summary.Policies, func(i, j int) bool { return summary.Policies[i].Tag < summary.Policies[j].Tag })
+
+	for _, key := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Peek(key)
+		if !ok || device.Egress == nil {
+			continue
+		}
+		summary.Devices++
+		if device.Egress.Status != models.EgressNonCompliant {
+			summary.Compliant++
+			continue
+		}
+		summary.NonCompliant++
+		summary.Violators = append(summary.Violators, models.EgressViolator{
+			Device: device.ID,
+			Name:   device.Name,
+			Egress: *device.Egress,
+		})
+	}
+	sort.Slice(summary.Violators, func(i, j int) bool {
+		a, b := summary.Violators[i], summary.Violators[j]
+		if a.Egress.WindowDirect != b.Egress.WindowDirect {
+			return a.Egress.WindowDirect > b.Egress.WindowDirect
+		}
+		return a.Device < b.Device
+	})
+	return summary
+}
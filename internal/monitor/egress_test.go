@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// A device with several tags that have conflicting policies gets the most
+// restrictive: the fewest direct connections tolerated, then the fewest
+// endpoints, then the first tag in order
+func TestEgressPolicyInheritance(t *testing.T) {
+	policies := []models.EgressPolicy{
+		{Tag: "Owner:Kids", Endpoints: []string{"192.168.1.9:3128", "10.8.0.1"}, MaxDirect: 5},
+		{Tag: "type:tablet", Endpoints: []string{"192.168.1.9:3128", "10.8.0.1", "10.8.0.2"}, MaxDirect: 1},
+		{Tag: "critical", Endpoints: []string{"10.8.0.1:51820"}, MaxDirect: 1},
+		{Tag: "location:lab", Endpoints: []string{"10.8.0.0/24"}, MaxDirect: 1},
+		{Tag: "vendor:acme", Endpoints: []string{"192.168.1.9:3128"}, MaxDirect: 0},
+	}
+	d, err := compileEgress(EgressConfig{Policies: policies, Window: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		device models.DeviceInfo
+		want   string // Tag of the policy applied, "" for none
+	}{
+		{"no tag with a policy", models.DeviceInfo{Owner: "parents", Vendor: "Other"}, ""},
+		{"one tag", models.DeviceInfo{Owner: "kids"}, "owner:kids"},
+		{"fewer direct connections", models.DeviceInfo{Owner: "kids", DeviceType: &models.DeviceTypeGuess{Type: "tablet"}}, "type:tablet"},
+		{"fewer endpoints", models.DeviceInfo{Owner: "kids", DeviceType: &models.DeviceTypeGuess{Type: "tablet"}, Critical: true}, "critical"},
+		{"tie broken by tag order", models.DeviceInfo{Critical: true, Location: "lab"}, "critical"},
+		{"no direct connection at all", models.DeviceInfo{Owner: "kids", Critical: true, Vendor: "ACME"}, "vendor:acme"},
+	}
+	for _, tt := range tests {
+		policy := d.policy(&tt.device)
+		got := ""
+		if policy != nil {
+			got = policy.Tag
+		}
+		if got != tt.want {
+			t.Errorf("%s: policy %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Through TrackEvent, a device under two conflicting policies is held to the
+// stricter one: its lax policy's proxy no longer counts as compliant and the
+// second direct connection is a violation. DNS to the local resolver and NTP
+// are never counted.
+func TestDetectEgressConflictingPolicies(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	err := nm.SetEgressConfig(EgressConfig{
+		Policies: []models.EgressPolicy{
+			{Tag: "owner:kids", Endpoints: []string{"151.101.9.9:3128"}, MaxDirect: 10},
+			{Tag: "location:lab", Endpoints: []string{"185.10.0.1:51820"}, MaxDirect: 1},
+		},
+		Allow:  []string{"UDP/123"},
+		Window: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac, ip := "02:00:00:00:00:0a", "192.168.1.10"
+	nm.TrackEvent(tcpEvent(t, mac, ip, "192.168.1.20", 445))
+	nm.lockAll()
+	if device, ok := nm.Cache.Peek(mac); ok {
+		device.Owner, device.Location = "kids", "lab"
+	}
+	nm.unlockAll()
+
+	dns := udpEvent(t, mac, ip, "192.168.1.1", 53)
+	dns.EventType = models.EVENT_TYPE_DNS
+	for _, evt := range []*models.NetworkEvent{
+		udpEvent(t, mac, ip, "185.10.0.1", 51820), // The strict policy's VPN: compliant
+		dns,
+		udpEvent(t, mac, ip, "151.101.1.123", 123),
+		tcpEvent(t, mac, ip, "151.101.9.9", 3128), // The lax policy's proxy: direct
+		tcpEvent(t, mac, ip, "151.101.1.1", 443),  // Second direct: violation
+		tcpEvent(t, mac, ip, "151.101.1.2", 443),  // Already non-compliant
+	} {
+		nm.TrackEvent(evt)
+	}
+
+	device, ok := nm.GetDevice(mac)
+	if !ok || device.Egress == nil {
+		t.Fatalf("device %v has no egress compliance", device)
+	}
+	got := *device.Egress
+	if got.Policy != "location:lab" || got.Status != models.EgressNonCompliant ||
+		got.Compliant != 1 || got.Direct != 3 || got.WindowDirect != 3 || got.LastDirect != "151.101.1.2:443" {
+		t.Errorf("egress %+v, want lab policy non-compliant with 1 compliant and 3 direct", got)
+	}
+	if status := EgressStatus(device); status != models.EgressNonCompliant {
+		t.Errorf("EgressStatus = %q", status)
+	}
+
+	var violations []*models.Anomaly
+	for _, anomaly := range nm.RecentAnomalies() {
+		if anomaly.Type == "EGRESS_POLICY_VIOLATION" {
+			violations = append(violations, anomaly)
+		}
+	}
+	if len(violations) != 1 {
+		t.Fatalf("%d violations raised, want 1", len(violations))
+	}
+	if v := violations[0]; v.DeviceID != mac || v.Params["policy"] != "location:lab" || v.Details["max_direct"] != "1" || v.Params["count"] != "2" {
+		t.Errorf("violation params %v details %v", v.Params, v.Details)
+	}
+}
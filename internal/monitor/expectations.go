@@ -0,0 +1,392 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// ExpectationKeyPrefix prefixes persisted expectations in the database.
+const ExpectationKeyPrefix = "planned:"
+
+// ExpectationRetention is how long an expectation is kept after its window
+// ends, so one that never matched can still be noticed
+const ExpectationRetention = 7 * 24 * time.Hour
+
+// maxExpectationMatches bounds the matches listed per expectation; Matched
+// counts them all
+const maxExpectationMatches = 50
+
+// ErrExpectationNotFound is returned when cancelling an unknown expectation
+var ErrExpectationNotFound = errors.New("expectation not found")
+
+// expectationSeq parses the sequence number out of an "e-<n>" ID
+func expectationSeq(id string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(id, "e-"), 10, 64)
+	return n
+}
+
+// expectationOptions makes a persisted expectation expire a retention period
+// after its window
+func expectationOptions(e *models.Expectation, now time.Time) *buntdb.SetOptions {
+	return &buntdb.SetOptions{Expires: true, TTL: e.End.Add(ExpectationRetention).Sub(now)}
+}
+
+// expectationState derives the state of an expectation as of now
+func expectationState(e *models.Expectation, now time.Time) string {
+	switch {
+	case now.Before(e.Start):
+		return models.ExpectationPending
+	case now.Before(e.End):
+		return models.ExpectationActive
+	case e.Matched > 0:
+		return models.ExpectationFulfilled
+	}
+	return models.ExpectationUnmatched
+}
+
+// expectationCovers reports whether at falls within the window of an
+// expectation, its start included and its end excluded
+func expectationCovers(e *models.Expectation, at time.Time) bool {
+	return !at.Before(e.Start) && at.Before(e.End)
+}
+
+// normalizeOUI turns an OUI or MAC prefix such as "50-C7-BF" or "50c7bf" into
+// the lowercase colon-separated form device MACs use
+func normalizeOUI(oui string) (string, error) {
+	digits := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(strings.TrimSpace(oui)))
+	if len(digits) == 0 || len(digits)%2 != 0 || len(digits) > 12 {
+		return "", fmt.Errorf("invalid oui %q: expected a MAC prefix such as 50:c7:bf", oui)
+	}
+	parts := make([]string, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		if _, err := strconv.ParseUint(digits[i:i+2], 16, 8); err != nil {
+			return "", fmt.Errorf("invalid oui %q: expected a MAC prefix such as 50:c7:bf", oui)
+		}
+		parts = append(parts, digits[i:i+2])
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+// expectsAddress reports whether a device ID, MAC or IP is one of the
+// addresses an infrastructure_change expectation covers
+func expectsAddress(e *models.Expectation, addr string) bool {
+	if addr == "" {
+		return false
+	}
+	addr = strings.TrimPrefix(addr, "ip:")
+	for _, a := range e.Addresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// expectsNewDevice reports whether an expectation announces a new device
+func expectsNewDevice(e *models.Expectation, device *models.DeviceInfo) bool {
+	switch e.Type {
+	case models.ExpectNewDevice:
+		if e.OUI != "" && !strings.HasPrefix(strings.ToLower(device.MAC), e.OUI) {
+			return false
+		}
+		if e.Vendor != "" {
+			vendor := strings.ToLower(e.Vendor)
+			if !strings.HasPrefix(strings.ToLower(device.Vendor), vendor) &&
+				!strings.HasPrefix(strings.ToLower(device.RawVendor), vendor) {
+				return false
+			}
+		}
+		return true
+	case models.ExpectInfraChange:
+		// A replaced server shows up as a new device on the role's IP
+		return expectsAddress(e, device.MAC) || expectsAddress(e, device.IP)
+	}
+	return false
+}
+
+// expectsDeviceChange reports whether an expectation announces a device change
+func expectsDeviceChange(e *models.Expectation, change *models.DeviceUpdate) bool {
+	ip, ipChanged := change.Changes[ChangeIP]
+	switch e.Type {
+	case models.ExpectIPChange:
+		return ipChanged && change.DeviceID == e.Device
+	case models.ExpectInfraChange:
+		return expectsAddress(e, change.DeviceID) ||
+			ipChanged && (expectsAddress(e, ip.Old) || expectsAddress(e, ip.New))
+	}
+	return false
+}
+
+// expectsAnomaly reports whether an expectation announces an anomaly: one
+// raised for, or naming, a device of an infrastructure role
+func expectsAnomaly(e *models.Expectation, anomaly *models.Anomaly) bool {
+	if e.Type != models.ExpectInfraChange {
+		return false
+	}
+	if expectsAddress(e, anomaly.DeviceID) {
+		return true
+	}
+	for _, value := range anomaly.Details {
+		if expectsAddress(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadExpectations reads the persisted expectations
+func (nm *NetworkMonitor) loadExpectations() {
+	nm.expectations = make(map[string]*models.Expectation)
+
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", ExpectationKeyPrefix, ExpectationKeyPrefix+"~", func(key, value string) bool {
+			var e models.Expectation
+			if json.Unmarshal([]byte(value), &e) != nil || e.ID == "" {
+				return true
+			}
+			nm.expectations[e.ID] = &e
+			nm.expectationSeq = max(nm.expectationSeq, expectationSeq(e.ID))
+			return true
+		})
+	})
+}
+
+// AddExpectation validates, persists and activates an expectation. The ID,
+// creation time, addresses and matches of e are ignored; a zero start means
+// now.
+func (nm *NetworkMonitor) AddExpectation(e models.Expectation) (models.Expectation, error) {
+	now := time.Now()
+	e.CreatedAt = now
+	e.Addresses = nil
+	e.Matched = 0
+	e.Matches = nil
+	e.State = ""
+	if e.Start.IsZero() {
+		e.Start = now
+	}
+	if !e.End.After(e.Start) {
+		return models.Expectation{}, fmt.Errorf("end must be after start")
+	}
+	if !e.End.After(now) {
+		return models.Expectation{}, fmt.Errorf("end must be in the future")
+	}
+	e.Vendor = strings.TrimSpace(e.Vendor)
+	e.Role = strings.ToLower(strings.TrimSpace(e.Role))
+
+	switch e.Type {
+	case models.ExpectNewDevice:
+		if e.Role != "" || e.Device != "" {
+			return models.Expectation{}, fmt.Errorf("new_device takes vendor and oui only")
+		}
+		if e.OUI != "" {
+			oui, err := normalizeOUI(e.OUI)
+			if err != nil {
+				return models.Expectation{}, err
+			}
+			e.OUI = oui
+		}
+	case models.ExpectInfraChange:
+		if e.Vendor != "" || e.OUI != "" || e.Device != "" {
+			return models.Expectation{}, fmt.Errorf("infrastructure_change takes a role only")
+		}
+		if _, known := roleExemptions[e.Role]; !known {
+			return models.Expectation{}, fmt.Errorf("unknown role %q: expected %s, %s or %s", e.Role, RoleDNS, RoleNTP, RoleMonitoring)
+		}
+		nm.mu.RLock()
+		for addr, role := range nm.portShare.config.Roles {
+			if role == e.Role {
+				e.Addresses = append(e.Addresses, addr)
+			}
+		}
+		nm.mu.RUnlock()
+		if len(e.Addresses) == 0 {
+			return models.Expectation{}, fmt.Errorf("no device has role %q, see -infra-roles", e.Role)
+		}
+		sort.Strings(e.Addresses)
+	case models.ExpectIPChange:
+		if e.Vendor != "" || e.OUI != "" || e.Role != "" {
+			return models.Expectation{}, fmt.Errorf("ip_change takes a device only")
+		}
+		if e.Device == "" {
+			return models.Expectation{}, fmt.Errorf("ip_change needs a device")
+		}
+		device, err := nm.muteTarget(e.Device)
+		if err != nil {
+			return models.Expectation{}, err
+		}
+		e.Device = device
+	default:
+		return models.Expectation{}, fmt.Errorf("invalid type %q: expected %s, %s or %s",
+			e.Type, models.ExpectNewDevice, models.ExpectInfraChange, models.ExpectIPChange)
+	}
+
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	nm.expectationSeq++
+	e.ID = fmt.Sprintf("e-%d", nm.expectationSeq)
+
+	data, _ := json.Marshal(&e)
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(ExpectationKeyPrefix+e.ID, string(data), expectationOptions(&e, now))
+		return err
+	})
+	if err != nil {
+		return models.Expectation{}, fmt.Errorf("failed to persist expectation: %w", err)
+	}
+
+	nm.expectations[e.ID] = &e
+	e.State = expectationState(&e, now)
+	return e, nil
+}
+
+// CancelExpectation removes an expectation. Events it already marked stay
+// marked.
+func (nm *NetworkMonitor) CancelExpectation(id string) error {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	if _, ok := nm.expectations[id]; !ok {
+		return ErrExpectationNotFound
+	}
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(ExpectationKeyPrefix + id)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete expectation: %w", err)
+	}
+	delete(nm.expectations, id)
+	return nil
+}
+
+// Expectations returns the expectations, oldest first, optionally only those
+// in one state. Expectations are kept for ExpectationRetention after their
+// window ends.
+func (nm *NetworkMonitor) Expectations(state string) []models.Expectation {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	now := time.Now()
+	expectations := []models.Expectation{}
+	for id, e := range nm.expectations {
+		if !now.Before(e.End.Add(ExpectationRetention)) {
+			delete(nm.expectations, id)
+			continue
+		}
+		listed := *e
+		listed.Matches = append([]models.ExpectationMatch(nil), e.Matches...)
+		listed.State = expectationState(e, now)
+		if state == "" || listed.State == state {
+			expectations = append(expectations, listed)
+		}
+	}
+	sort.Slice(expectations, func(i, j int) bool {
+		return expectationSeq(expectations[i].ID) < expectationSeq(expectations[j].ID)
+	})
+	return expectations
+}
+
+// expect finds the oldest expectation covering an event at a time, records
+// the match and returns its ID, or "" if none does. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) expect(at time.Time, match models.ExpectationMatch, expects func(*models.Expectation) bool) string {
+	var best *models.Expectation
+	for _, e := range nm.expectations {
+		if !expectationCovers(e, at) || !expects(e) {
+			continue
+		}
+		if best == nil || expectationSeq(e.ID) < expectationSeq(best.ID) {
+			best = e
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	best.Matched++
+	if len(best.Matches) < maxExpectationMatches {
+		match.At = at
+		best.Matches = append(best.Matches, match)
+	}
+	nm.expectationHits = true
+	return best.ID
+}
+
+// expectNewDevice marks a new device expected if an expectation announced
+// it. It is safe to call while holding nm.mu.
+func (nm *NetworkMonitor) expectNewDevice(device *models.DeviceInfo, now time.Time) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	match := models.ExpectationMatch{Kind: models.MatchNewDevice, ID: device.ID, DeviceID: device.ID}
+	device.ExpectedBy = nm.expect(now, match, func(e *models.Expectation) bool {
+		return expectsNewDevice(e, device)
+	})
+}
+
+// expectDeviceChange marks a settled device change expected if an expectation
+// announced it
+func (nm *NetworkMonitor) expectDeviceChange(change *models.DeviceUpdate) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	match := models.ExpectationMatch{Kind: models.MatchDeviceChange, ID: change.ID, DeviceID: change.DeviceID}
+	change.ExpectedBy = nm.expect(change.FirstChanged, match, func(e *models.Expectation) bool {
+		return expectsDeviceChange(e, change)
+	})
+}
+
+// expectAnomaly marks a new anomaly expected, and acknowledges it, if an
+// expectation announced it. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) expectAnomaly(anomaly *models.Anomaly) {
+	match := models.ExpectationMatch{Kind: models.MatchAnomaly, ID: anomaly.ID, DeviceID: anomaly.DeviceID}
+	anomaly.ExpectedBy = nm.expect(anomaly.Timestamp, match, func(e *models.Expectation) bool {
+		return expectsAnomaly(e, anomaly)
+	})
+	if anomaly.ExpectedBy != "" {
+		anomaly.Ack = &models.AnomalyAck{At: anomaly.Timestamp, Comment: "expected by " + anomaly.ExpectedBy}
+	}
+}
+
+// pendingExpectations returns copies of the expectations to persist if
+// matches were recorded since the last persist. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) pendingExpectations() []models.Expectation {
+	if !nm.expectationHits {
+		return nil
+	}
+	nm.expectationHits = false
+	expectations := make([]models.Expectation, 0, len(nm.expectations))
+	for _, e := range nm.expectations {
+		copied := *e
+		copied.Matches = append([]models.ExpectationMatch(nil), e.Matches...)
+		expectations = append(expectations, copied)
+	}
+	return expectations
+}
+
+// writeExpectations persists the matches of expectations that still exist
+func writeExpectations(tx *buntdb.Tx, expectations []models.Expectation, now time.Time) error {
+	for i := range expectations {
+		e := &expectations[i]
+		key := ExpectationKeyPrefix + e.ID
+		if _, err := tx.Get(key); err != nil {
+			continue // Cancelled or expired since the snapshot
+		}
+		data, _ := json.Marshal(e)
+		if _, _, err := tx.Set(key, string(data), expectationOptions(e, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
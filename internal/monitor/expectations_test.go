@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+var expectationStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// A window includes its start and excludes its end; the state follows it,
+// and after it depends on whether anything matched
+func TestExpectationWindow(t *testing.T) {
+	end := expectationStart.Add(2 * time.Hour)
+	tests := []struct {
+		name    string
+		at      time.Time
+		matched uint64
+		covers  bool
+		state   string
+	}{
+		{"before the start", expectationStart.Add(-time.Nanosecond), 0, false, models.ExpectationPending},
+		{"at the start", expectationStart, 0, true, models.ExpectationActive},
+		{"within", expectationStart.Add(time.Hour), 1, true, models.ExpectationActive},
+		{"just before the end", end.Add(-time.Nanosecond), 0, true, models.ExpectationActive},
+		{"at the end, unmatched", end, 0, false, models.ExpectationUnmatched},
+		{"at the end, matched", end, 1, false, models.ExpectationFulfilled},
+		{"after the end, matched", end.Add(24 * time.Hour), 3, false, models.ExpectationFulfilled},
+	}
+	for _, tt := range tests {
+		e := &models.Expectation{Start: expectationStart, End: end, Matched: tt.matched}
+		if got := expectationCovers(e, tt.at); got != tt.covers {
+			t.Errorf("%s: covers = %v, want %v", tt.name, got, tt.covers)
+		}
+		if got := expectationState(e, tt.at); got != tt.state {
+			t.Errorf("%s: state = %s, want %s", tt.name, got, tt.state)
+		}
+	}
+}
+
+// OUIs are accepted in the usual notations and compared in the form device
+// MACs use
+func TestNormalizeOUI(t *testing.T) {
+	for _, oui := range []string{"50:c7:bf", "50-C7-BF", "50c7bf", " 50.C7.BF "} {
+		if got, err := normalizeOUI(oui); err != nil || got != "50:c7:bf" {
+			t.Errorf("normalizeOUI(%q) = %q, %v, want 50:c7:bf", oui, got, err)
+		}
+	}
+	if got, err := normalizeOUI("50c7bf01"); err != nil || got != "50:c7:bf:01" {
+		t.Errorf("normalizeOUI(50c7bf01) = %q, %v, want a longer prefix kept", got, err)
+	}
+	for _, oui := range []string{"", "5", "50c7b", "50:c7:bg", "50c7bf0011223344"} {
+		if got, err := normalizeOUI(oui); err == nil {
+			t.Errorf("normalizeOUI(%q) = %q, want an error", oui, got)
+		}
+	}
+}
+
+// A new_device expectation takes an OUI in any notation and a vendor prefix
+// matching the canonical or the registered vendor name; every criterion set
+// must match
+func TestExpectsNewDevice(t *testing.T) {
+	tplink := &models.DeviceInfo{ID: "50:c7:bf:00:00:01", MAC: "50:c7:bf:00:00:01", IP: "192.168.1.20", Vendor: "TP-Link", RawVendor: "TP-LINK TECHNOLOGIES CO.,LTD."}
+	apple := &models.DeviceInfo{ID: "00:03:93:00:00:02", MAC: "00:03:93:00:00:02", IP: "192.168.1.53", Vendor: "Apple", RawVendor: "Apple, Inc."}
+	tests := []struct {
+		name   string
+		e      models.Expectation
+		device *models.DeviceInfo
+		want   bool
+	}{
+		{"anything", models.Expectation{Type: models.ExpectNewDevice}, apple, true},
+		{"OUI", models.Expectation{Type: models.ExpectNewDevice, OUI: "50-C7-BF"}, tplink, true},
+		{"OUI without separators", models.Expectation{Type: models.ExpectNewDevice, OUI: "50c7bf"}, tplink, true},
+		{"other OUI", models.Expectation{Type: models.ExpectNewDevice, OUI: "50:c7:bf"}, apple, false},
+		{"vendor", models.Expectation{Type: models.ExpectNewDevice, Vendor: "tp-link"}, tplink, true},
+		{"vendor prefix", models.Expectation{Type: models.ExpectNewDevice, Vendor: "TP"}, tplink, true},
+		{"raw vendor prefix", models.Expectation{Type: models.ExpectNewDevice, Vendor: "tp-link technologies"}, tplink, true},
+		{"raw vendor only", models.Expectation{Type: models.ExpectNewDevice, Vendor: "apple, inc"}, apple, true},
+		{"vendor within the name", models.Expectation{Type: models.ExpectNewDevice, Vendor: "Link"}, tplink, false},
+		{"other vendor", models.Expectation{Type: models.ExpectNewDevice, Vendor: "Apple"}, tplink, false},
+		{"OUI and vendor", models.Expectation{Type: models.ExpectNewDevice, OUI: "50:c7:bf", Vendor: "TP-Link"}, tplink, true},
+		{"OUI but not vendor", models.Expectation{Type: models.ExpectNewDevice, OUI: "50:c7:bf", Vendor: "Apple"}, tplink, false},
+		{"infrastructure IP", models.Expectation{Type: models.ExpectInfraChange, Addresses: []string{"192.168.1.53"}}, apple, true},
+		{"infrastructure MAC", models.Expectation{Type: models.ExpectInfraChange, Addresses: []string{"50:c7:bf:00:00:01"}}, tplink, true},
+		{"other infrastructure", models.Expectation{Type: models.ExpectInfraChange, Addresses: []string{"192.168.1.53"}}, tplink, false},
+		{"IP change", models.Expectation{Type: models.ExpectIPChange, Device: apple.ID}, apple, false},
+	}
+	for _, tt := range tests {
+		if tt.e.OUI != "" {
+			oui, err := normalizeOUI(tt.e.OUI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.e.OUI = oui
+		}
+		if got := expectsNewDevice(&tt.e, tt.device); got != tt.want {
+			t.Errorf("%s: expects %s = %v, want %v", tt.name, tt.device.ID, got, tt.want)
+		}
+	}
+}
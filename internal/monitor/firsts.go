@@ -0,0 +1,197 @@
+package monitor
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// FirstContactConfig controls the anomaly raised when a long-known device
+// reaches outside the LAN for the first time
+type FirstContactConfig struct {
+	MinAge time.Duration // Time since a device was first seen from which its first external contact raises an anomaly (0 disables)
+}
+
+// DefaultFirstContactConfig returns the default first external contact
+// settings
+func DefaultFirstContactConfig() FirstContactConfig {
+	return FirstContactConfig{MinAge: 30 * 24 * time.Hour}
+}
+
+// SetFirstContactConfig replaces the first external contact settings
+func (nm *NetworkMonitor) SetFirstContactConfig(config FirstContactConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.firstContact = config
+}
+
+// reachMilestone records a milestone of a device unless it reached one of the
+// same kind and value before, and returns the recorded one or nil. Devices
+// seen before milestones were recorded start recording them now.
+func reachMilestone(device *models.DeviceInfo, milestone models.Milestone) *models.Milestone {
+	if device.Firsts == nil {
+		device.Firsts = &models.DeviceFirsts{Since: milestone.At}
+	}
+	for _, reached := range device.Firsts.Milestones {
+		if reached.Kind == milestone.Kind && reached.Value == milestone.Value {
+			return nil
+		}
+	}
+	device.Firsts.Milestones = append(device.Firsts.Milestones, milestone)
+	return &device.Firsts.Milestones[len(device.Firsts.Milestones)-1]
+}
+
+// cloneFirsts returns a copy of the milestones of a device, or nil
+func cloneFirsts(firsts *models.DeviceFirsts) *models.DeviceFirsts {
+	if firsts == nil {
+		return nil
+	}
+	clone := *firsts
+	clone.Milestones = append([]models.Milestone(nil), firsts.Milestones...)
+	return &clone
+}
+
+// mergeFirsts folds the milestones of src into dst, keeping the earlier of
+// those both reached. The merged milestones are only complete since the later
+// of both starts.
+func mergeFirsts(dst, src *models.DeviceInfo) {
+	if src.Firsts == nil {
+		return
+	}
+	if dst.Firsts == nil {
+		dst.Firsts = cloneFirsts(src.Firsts)
+		return
+	}
+	if src.Firsts.Since.After(dst.Firsts.Since) {
+		dst.Firsts.Since = src.Firsts.Since
+	}
+	for _, milestone := range src.Firsts.Milestones {
+		i := slices.IndexFunc(dst.Firsts.Milestones, func(reached models.Milestone) bool {
+			return reached.Kind == milestone.Kind && reached.Value == milestone.Value
+		})
+		switch {
+		case i < 0:
+			dst.Firsts.Milestones = append(dst.Firsts.Milestones, milestone)
+		case milestone.At.Before(dst.Firsts.Milestones[i].At):
+			dst.Firsts.Milestones[i] = milestone
+		}
+	}
+	sort.SliceStable(dst.Firsts.Milestones, func(i, j int) bool {
+		return dst.Firsts.Milestones[i].At.Before(dst.Firsts.Milestones[j].At)
+	})
+}
+
+// DeviceFirsts returns the milestones of a device, which has none recorded
+// until its first pattern
+func (nm *NetworkMonitor) DeviceFirsts(id string) (*models.DeviceFirsts, bool) {
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+	if device.Firsts == nil {
+		return &models.DeviceFirsts{Since: device.FirstSeen, Milestones: []models.Milestone{}}, true
+	}
+	return cloneFirsts(device.Firsts), true
+}
+
+// detectFirstContact is the pattern detector of milestones. It records the
+// first pattern of each protocol of a device, its first external one and,
+// with a GeoIP database, its first to each country and autonomous system. It
+// raises a MEDIUM anomaly when a device known for at least MinAge reaches
+// outside the LAN for the first time, as a printer suddenly calling home
+// would. Milestones are persisted with the device and the anomaly in one
+// transaction, so a restart neither loses nor repeats them.
+func (nm *NetworkMonitor) detectFirstContact(check *patternCheck) verdict {
+	pattern := check.pattern
+	milestone := models.Milestone{
+		At:          check.now,
+		Destination: pattern.DstIP,
+		Port:        pattern.DstPort,
+		Protocol:    pattern.Protocol,
+		PatternID:   pattern.ID,
+	}
+	protocol := milestone
+	protocol.Kind, protocol.Value = models.MilestoneProtocol, pattern.Protocol
+	reachMilestone(check.device, protocol)
+
+	if !check.external {
+		return skipped(reasonNotExternal)
+	}
+	if geo, ok := nm.geoLookup(pattern.DstIP); ok {
+		milestone.Geo = &geo
+		if geo.Country != "" {
+			country := milestone
+			country.Kind, country.Value = models.MilestoneCountry, geo.Country
+			reachMilestone(check.device, country)
+		}
+		asn := milestone
+		asn.Kind, asn.Value = models.MilestoneASN, strconv.FormatUint(uint64(geo.ASN), 10)
+		reachMilestone(check.device, asn)
+	}
+	milestone.Kind = models.MilestoneExternalContact
+	first := reachMilestone(check.device, milestone)
+	switch {
+	case first == nil:
+		return notMatched("device contacted external destinations before")
+	case check.exempt:
+		return skipped(reasonExempt)
+	case check.excluded:
+		return skipped(reasonExcluded)
+	case check.device.ExternalPatterns > 1:
+		// Counted before milestones were recorded for the device
+		return notMatched("device contacted external destinations before")
+	case nm.firstContact.MinAge <= 0:
+		return notMatched("first external contact recorded, detection disabled")
+	}
+	age := check.now.Sub(check.device.FirstSeen)
+	if age < nm.firstContact.MinAge {
+		return notMatched("first external contact of a device younger than the minimum age").
+			compare(age.Hours(), nm.firstContact.MinAge.Hours())
+	}
+
+	destination := pattern.DstIP
+	if pattern.DstPort != 0 {
+		destination = net.JoinHostPort(pattern.DstIP, strconv.Itoa(int(pattern.DstPort)))
+	}
+	var patterns []string
+	if pattern.ID != "" {
+		patterns = []string{pattern.ID}
+	}
+	details := map[string]string{
+		"destination": pattern.DstIP,
+		"port":        strconv.Itoa(int(pattern.DstPort)),
+		"protocol":    pattern.Protocol,
+		"first_seen":  check.device.FirstSeen.Format(time.RFC3339),
+		"min_age":     nm.firstContact.MinAge.String(),
+	}
+	if geo := first.Geo; geo != nil {
+		details["asn"] = strconv.FormatUint(uint64(geo.ASN), 10)
+		if geo.Country != "" {
+			details["country"] = geo.Country
+		}
+	}
+	anomaly := nm.raiseLinkedAnomaly("FIRST_EXTERNAL_CONTACT", models.SeverityMedium, check.device.ID,
+		map[string]string{
+			"destination": destination,
+			"protocol":    pattern.Protocol,
+			"age":         formatAge(age),
+		}, details, patterns)
+	if anomaly != nil {
+		first.AnomalyID = anomaly.ID
+	}
+	return raised("first external contact of a device older than the minimum age", anomaly).
+		compare(age.Hours(), nm.firstContact.MinAge.Hours())
+}
+
+// formatAge renders the time a device has been known for, in days past two
+func formatAge(age time.Duration) string {
+	if age >= 48*time.Hour {
+		return strconv.Itoa(int(age/(24*time.Hour))) + " days"
+	}
+	return age.Round(time.Minute).String()
+}
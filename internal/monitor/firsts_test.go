@@ -0,0 +1,214 @@
+package monitor
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// firstsGeoIP locates two destinations in the US under AS 13335, one in
+// Australia and one in an AS of unknown country
+const firstsGeoIP = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.4.0\t1.0.7.255\t38803\tAU\tWPL-AS-AP\n" +
+	"5.10.64.0\t5.10.64.255\t64496\tZZ\tUNKNOWN-COUNTRY\n"
+
+// milestoneKeys lists the milestones of a device as kind=value
+func milestoneKeys(t *testing.T, nm *NetworkMonitor, id string) []string {
+	t.Helper()
+	firsts, ok := nm.DeviceFirsts(id)
+	if !ok {
+		t.Fatalf("device %s not tracked", id)
+	}
+	var keys []string
+	for _, milestone := range firsts.Milestones {
+		keys = append(keys, milestone.Kind+"="+milestone.Value)
+	}
+	return keys
+}
+
+// backdate makes a device first seen age ago
+func backdate(t *testing.T, nm *NetworkMonitor, id string, age time.Duration) {
+	t.Helper()
+	nm.lockAll()
+	defer nm.unlockAll()
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		t.Fatalf("device %s not tracked", id)
+	}
+	device.FirstSeen = time.Now().Add(-age)
+	device.Firsts.Since = device.FirstSeen
+}
+
+// firstContacts returns the FIRST_EXTERNAL_CONTACT anomalies raised
+func firstContacts(nm *NetworkMonitor) []*models.Anomaly {
+	var raised []*models.Anomaly
+	for _, anomaly := range nm.RecentAnomalies() {
+		if anomaly.Type == "FIRST_EXTERNAL_CONTACT" {
+			raised = append(raised, anomaly)
+		}
+	}
+	return raised
+}
+
+// The first external contact of a device known for the minimum age raises
+// an anomaly; that of a brand-new device, or with the detection disabled,
+// only records the milestone. Later contacts raise nothing.
+func TestFirstContactAge(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		minAge time.Duration
+		raised bool
+	}{
+		{"old device", 180 * 24 * time.Hour, 30 * 24 * time.Hour, true},
+		{"at the minimum age", 30*24*time.Hour + time.Minute, 30 * 24 * time.Hour, true},
+		{"brand-new device", 0, 30 * 24 * time.Hour, false},
+		{"younger than the minimum age", 29 * 24 * time.Hour, 30 * 24 * time.Hour, false},
+		{"disabled", 180 * 24 * time.Hour, 0, false},
+	}
+	mac := "02:00:00:00:00:0a"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newTestMonitor(t, 16)
+			nm.SetFirstContactConfig(FirstContactConfig{MinAge: tt.minAge})
+			nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 631))
+			backdate(t, nm, mac, tt.age)
+
+			nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.7", 443))
+			nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.8", 443))
+
+			if keys := milestoneKeys(t, nm, mac); !slices.Equal(keys, []string{"protocol=TCP", "external_contact="}) {
+				t.Errorf("milestones = %v", keys)
+			}
+			raised := firstContacts(nm)
+			if len(raised) > 1 || (len(raised) == 1) != tt.raised {
+				t.Fatalf("%d anomalies raised, want raised %v", len(raised), tt.raised)
+			}
+			firsts, _ := nm.DeviceFirsts(mac)
+			external := firsts.Milestones[1]
+			if external.Destination != "203.0.113.7" || external.Port != 443 || external.PatternID == "" {
+				t.Errorf("external contact milestone = %+v", external)
+			}
+			if !tt.raised {
+				if external.AnomalyID != "" {
+					t.Errorf("milestone links anomaly %s", external.AnomalyID)
+				}
+				return
+			}
+			anomaly := raised[0]
+			if anomaly.Severity != models.SeverityMedium || anomaly.DeviceID != mac || external.AnomalyID != anomaly.ID ||
+				anomaly.Details["destination"] != "203.0.113.7" || !slices.Equal(anomaly.Patterns, []string{external.PatternID}) {
+				t.Errorf("anomaly %+v for milestone %+v", anomaly, external)
+			}
+		})
+	}
+}
+
+// With a GeoIP database, the first contact with each country and AS is a
+// milestone carrying the destination's location, recorded once even across
+// a restart, which doesn't raise the first external contact again either
+func TestFirstsGeoIP(t *testing.T) {
+	geoIP, err := databases.ParseGeoIPDatabase(strings.NewReader(firstsGeoIP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.SetGeoIP(geoIP)
+	nm.SetFirstContactConfig(FirstContactConfig{MinAge: time.Hour})
+
+	mac := "02:00:00:00:00:0a"
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 631))
+	backdate(t, nm, mac, 24*time.Hour)
+	for _, dst := range []string{"1.0.0.5", "1.0.0.9", "1.0.4.1", "5.10.64.1", "198.51.100.7"} {
+		nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", dst, 443))
+	}
+
+	want := []string{"protocol=TCP", "country=US", "asn=13335", "external_contact=", "country=AU", "asn=38803", "asn=64496"}
+	if keys := milestoneKeys(t, nm, mac); !slices.Equal(keys, want) {
+		t.Fatalf("milestones = %v, want %v", keys, want)
+	}
+	firsts, _ := nm.DeviceFirsts(mac)
+	for _, milestone := range firsts.Milestones[1:] {
+		if milestone.Geo == nil || milestone.Geo.ASN == 0 {
+			t.Errorf("%s=%s has no location", milestone.Kind, milestone.Value)
+		}
+	}
+	if geo := firsts.Milestones[1].Geo; geo.Country != "US" || geo.ASN != 13335 || geo.ASOrg != "CLOUDFLARENET" || firsts.Milestones[1].Destination != "1.0.0.5" {
+		t.Errorf("first US milestone = %+v at %+v", firsts.Milestones[1], geo)
+	}
+	raised := firstContacts(nm)
+	if len(raised) != 1 || raised[0].Details["country"] != "US" || raised[0].Details["asn"] != "13335" {
+		t.Fatalf("raised %+v, want one anomaly located in AS 13335", raised)
+	}
+
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	nm, err = NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	nm.SetGeoIP(geoIP)
+	nm.SetFirstContactConfig(FirstContactConfig{MinAge: time.Hour})
+
+	for _, dst := range []string{"1.0.0.20", "1.0.5.1", "203.0.113.9"} {
+		nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", dst, 8443))
+	}
+	if keys := milestoneKeys(t, nm, mac); !slices.Equal(keys, want) {
+		t.Errorf("milestones after a restart = %v, want %v", keys, want)
+	}
+	// The anomaly raised before the restart is the only one
+	if again := firstContacts(nm); len(again) != 1 || again[0].ID != raised[0].ID {
+		t.Errorf("%d first external contacts after a restart, want %s only", len(again), raised[0].ID)
+	}
+	firsts, _ = nm.DeviceFirsts(mac)
+	if external := firsts.Milestones[3]; external.AnomalyID != raised[0].ID {
+		t.Errorf("external contact links anomaly %q after a restart, want %s", external.AnomalyID, raised[0].ID)
+	}
+}
+
+// Merging keeps the earlier of the milestones both devices reached, adds
+// those only one reached, and is complete from the later of their starts
+func TestMergeFirsts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	dst := &models.DeviceInfo{Firsts: &models.DeviceFirsts{Since: day(1), Milestones: []models.Milestone{
+		{Kind: models.MilestoneProtocol, Value: "TCP", At: day(1), Destination: "a"},
+		{Kind: models.MilestoneExternalContact, At: day(9), Destination: "a"},
+	}}}
+	src := &models.DeviceInfo{Firsts: &models.DeviceFirsts{Since: day(3), Milestones: []models.Milestone{
+		{Kind: models.MilestoneProtocol, Value: "TCP", At: day(3), Destination: "b"},
+		{Kind: models.MilestoneExternalContact, At: day(4), Destination: "b"},
+		{Kind: models.MilestoneCountry, Value: "US", At: day(4), Destination: "b"},
+	}}}
+	mergeFirsts(dst, src)
+
+	want := []string{"protocol TCP a", "external_contact  b", "country US b"}
+	var got []string
+	for _, milestone := range dst.Firsts.Milestones {
+		got = append(got, milestone.Kind+" "+milestone.Value+" "+milestone.Destination)
+	}
+	if !slices.Equal(got, want) || !dst.Firsts.Since.Equal(day(3)) {
+		t.Errorf("merged %v since %v, want %v since %v", got, dst.Firsts.Since, want, day(3))
+	}
+
+	// A device without milestones takes a copy of the other's
+	empty := &models.DeviceInfo{}
+	mergeFirsts(empty, src)
+	src.Firsts.Milestones[0].Destination = "changed"
+	if len(empty.Firsts.Milestones) != 3 || empty.Firsts.Milestones[0].Destination != "b" {
+		t.Errorf("merged into an empty device: %+v", empty.Firsts)
+	}
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// fleetLearningPeriod is how long a vendor group only learns destinations
+// before new ones can raise fleet anomalies
+const fleetLearningPeriod = time.Hour
+
+// fleetUbiquitousVendors is how many different vendors must reach a destination
+// before it is considered shared infrastructure (CDNs, NTP, public DNS) and ignored
+const fleetUbiquitousVendors = 4
+
+// fleetMaxDestinations bounds the destinations remembered per vendor group
+const fleetMaxDestinations = 5000
+
+// fleetStaleAfter is how long an unused destination is kept when pruning
+const fleetStaleAfter = 7 * 24 * time.Hour
+
+// FleetConfig controls vendor-wide (fleet) anomaly detection
+type FleetConfig struct {
+	MinDevices int           // Devices of one vendor that must adopt a destination
+	Window     time.Duration // Time in which they must adopt it
+}
+
+// DefaultFleetConfig returns the default fleet detection settings
+func DefaultFleetConfig() FleetConfig {
+	return FleetConfig{MinDevices: 3, Window: 10 * time.Minute}
+}
+
+type fleetAdopter struct {
+	firstContact time.Time
+	pattern      string // ID of the pattern of the first contact, if any
+}
+
+type fleetDestination struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	devices   map[string]fleetAdopter // device ID -> first contact
+	novel     bool                    // First seen after the group finished learning
+	alerted   bool
+}
+
+type fleetGroup struct {
+	created      time.Time
+	destinations map[string]*fleetDestination
+}
+
+// fleetDetector groups devices by normalized vendor and flags destinations that
+// many devices of one vendor start contacting at once, e.g. after a firmware
+// update. It is guarded by nm.mu.
+type fleetDetector struct {
+	config  FleetConfig
+	groups  map[string]*fleetGroup
+	vendors map[string]map[string]bool // destination -> vendors contacting it
+}
+
+func newFleetDetector(config FleetConfig) *fleetDetector {
+	return &fleetDetector{
+		config:  config,
+		groups:  make(map[string]*fleetGroup),
+		vendors: make(map[string]map[string]bool),
+	}
+}
+
+// SetFleetConfig replaces the fleet detection settings
+func (nm *NetworkMonitor) SetFleetConfig(config FleetConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.fleet.config = config
+}
+
+// observeFleet records that a device contacted an external destination (an IP
+// or a domain) and raises a fleet anomaly when the destination newly spreads
+// across enough devices of the same vendor. patternID identifies the pattern
+// of the contact, if any. Must hold nm.mu.
+func (nm *NetworkMonitor) observeFleet(device *models.DeviceInfo, destination, patternID string, now time.Time) verdict {
+	vendor := databases.NormalizeVendor(device.Vendor)
+	if vendor == "" || destination == "" {
+		return skipped("vendor unknown")
+	}
+	f := nm.fleet
+
+	vendors := f.vendors[destination]
+	if vendors == nil {
+		vendors = make(map[string]bool)
+		f.vendors[destination] = vendors
+	}
+	if len(vendors) < fleetUbiquitousVendors {
+		vendors[vendor] = true
+	}
+
+	group := f.groups[vendor]
+	if group == nil {
+		group = &fleetGroup{created: now, destinations: make(map[string]*fleetDestination)}
+		f.groups[vendor] = group
+	}
+
+	dest := group.destinations[destination]
+	if dest == nil {
+		if len(group.destinations) >= fleetMaxDestinations {
+			f.prune(group, now)
+		}
+		dest = &fleetDestination{
+			firstSeen: now,
+			devices:   make(map[string]fleetAdopter),
+			novel:     now.Sub(group.created) >= fleetLearningPeriod,
+		}
+		group.destinations[destination] = dest
+	}
+	dest.lastSeen = now
+	if _, seen := dest.devices[device.ID]; !seen {
+		dest.devices[device.ID] = fleetAdopter{firstContact: now, pattern: patternID}
+	}
+
+	switch {
+	case !dest.novel:
+		return notMatched("destination contacted while the vendor's devices were learning")
+	case dest.alerted:
+		return notMatched("destination already alerted")
+	case len(vendors) >= fleetUbiquitousVendors:
+		return notMatched("destination contacted by devices of many vendors")
+	}
+
+	// Count only devices that adopted the destination within the window
+	var adopters []string
+	for id, adopter := range dest.devices {
+		if adopter.firstContact.Sub(dest.firstSeen) <= f.config.Window {
+			adopters = append(adopters, id)
+		}
+	}
+	if len(adopters) < f.config.MinDevices {
+		return notMatched("too few devices of the vendor adopted the destination within the window").
+			compare(float64(len(adopters)), float64(f.config.MinDevices))
+	}
+	dest.alerted = true
+	sort.Strings(adopters)
+
+	var patterns []string
+	for _, id := range adopters {
+		if pattern := dest.devices[id].pattern; pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	severity := models.SeverityMedium
+	if len(adopters) >= 2*f.config.MinDevices {
+		severity = models.SeverityHigh
+	}
+
+	anomaly := nm.raiseLinkedAnomaly("FLEET_NEW_DESTINATION", severity, "",
+		map[string]string{
+			"count":       strconv.Itoa(len(adopters)),
+			"vendor":      device.Vendor,
+			"destination": destination,
+			"elapsed":     now.Sub(dest.firstSeen).Round(time.Second).String(),
+		},
+		map[string]string{
+			"vendor":      vendor,
+			"destination": destination,
+			"devices":     strings.Join(adopters, ","),
+			"count":       strconv.Itoa(len(adopters)),
+			"first_seen":  dest.firstSeen.Format(time.RFC3339),
+			"window":      f.config.Window.String(),
+		}, patterns)
+	return raised("enough devices of the vendor adopted the destination within the window", anomaly).
+		compare(float64(len(adopters)), float64(f.config.MinDevices))
+}
+
+// detectFleet is the pattern detector of destinations spreading across a
+// vendor's devices
+func (nm *NetworkMonitor) detectFleet(check *patternCheck) verdict {
+	switch {
+	case !check.external:
+		return skipped(reasonNotExternal)
+	case check.exempt:
+		return skipped(reasonExempt)
+	case check.excluded:
+		return skipped(reasonExcluded)
+	}
+	return nm.observeFleet(check.device, check.pattern.DstIP, check.pattern.ID, check.now)
+}
+
+// prune forgets destinations a group has not contacted recently
+func (f *fleetDetector) prune(group *fleetGroup, now time.Time) {
+	for destination, dest := range group.destinations {
+		if now.Sub(dest.lastSeen) > fleetStaleAfter {
+			delete(group.destinations, destination)
+		}
+	}
+}
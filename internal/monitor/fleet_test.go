@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// fleetStart is when the synthetic fleets are first seen
+var fleetStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// vendorFleet returns n devices of a vendor, as a firmware-updated fleet of
+// smart plugs would be
+func vendorFleet(vendor string, oui byte, n int) []*models.DeviceInfo {
+	devices := make([]*models.DeviceInfo, n)
+	for i := range devices {
+		mac := fmt.Sprintf("02:00:00:%02x:00:%02x", oui, i)
+		devices[i] = &models.DeviceInfo{ID: mac, MAC: mac, Vendor: vendor}
+	}
+	return devices
+}
+
+// fleetContact runs the fleet detector on a device reaching destination at at
+func fleetContact(nm *NetworkMonitor, device *models.DeviceInfo, destination string, at time.Time) verdict {
+	nm.lockAll()
+	defer nm.unlockAll()
+	return nm.observeFleet(device, destination, "", at)
+}
+
+// learnFleet has every device of a fleet reach destinations while the
+// vendor's group is learning, and returns when learning is over
+func learnFleet(nm *NetworkMonitor, fleet []*models.DeviceInfo, destinations ...string) time.Time {
+	for _, device := range fleet {
+		for _, destination := range destinations {
+			fleetContact(nm, device, destination, fleetStart)
+		}
+	}
+	return fleetStart.Add(fleetLearningPeriod)
+}
+
+// A destination new to the vendor's devices raises one anomaly once
+// MinDevices of them adopt it within the window, and none when it was known
+// during learning or adopted too slowly
+func TestFleetNewDestination(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	fleet := vendorFleet("Espressif Inc.", 0xe5, 8)
+	learned := learnFleet(nm, fleet, "198.51.100.1", "pool.ntp.org")
+
+	tests := []struct {
+		device      int
+		destination string
+		after       time.Duration // Since learning ended
+		want        string
+	}{
+		// Known during learning, however many devices reach it
+		{5, "198.51.100.1", time.Minute, OutcomeNotMatched},
+		{6, "pool.ntp.org", time.Minute, OutcomeNotMatched},
+		// Three devices within ten minutes
+		{0, "203.0.113.66", 0, OutcomeNotMatched},
+		{1, "203.0.113.66", 4 * time.Minute, OutcomeNotMatched},
+		{2, "203.0.113.66", 9 * time.Minute, OutcomeMatched},
+		{3, "203.0.113.66", 9 * time.Minute, OutcomeNotMatched}, // Already alerted
+		// Adopted one device at a time, each outside the window of the first
+		{0, "203.0.113.77", 0, OutcomeNotMatched},
+		{1, "203.0.113.77", 11 * time.Minute, OutcomeNotMatched},
+		{2, "203.0.113.77", 22 * time.Minute, OutcomeNotMatched},
+		{3, "203.0.113.77", 33 * time.Minute, OutcomeNotMatched},
+	}
+	for i, tt := range tests {
+		v := fleetContact(nm, fleet[tt.device], tt.destination, learned.Add(tt.after))
+		if v.outcome != tt.want {
+			t.Errorf("%d: device %d to %s = %s (%s), want %s", i, tt.device, tt.destination, v.outcome, v.reason, tt.want)
+		}
+	}
+
+	n, anomaly := countAnomalies(nm, "FLEET_NEW_DESTINATION")
+	if n != 1 {
+		t.Fatalf("%d fleet anomalies, want 1", n)
+	}
+	want := map[string]string{
+		"vendor":      "espressif",
+		"destination": "203.0.113.66",
+		"devices":     "02:00:00:e5:00:00,02:00:00:e5:00:01,02:00:00:e5:00:02",
+		"count":       "3",
+	}
+	for key, value := range want {
+		if anomaly.Details[key] != value {
+			t.Errorf("details[%s] = %q, want %q", key, anomaly.Details[key], value)
+		}
+	}
+	if anomaly.Severity != models.SeverityMedium {
+		t.Errorf("severity %s, want %s", anomaly.Severity, models.SeverityMedium)
+	}
+}
+
+// Destinations reached by devices of fleetUbiquitousVendors vendors are shared
+// infrastructure such as CDNs: a fleet adopting one raises nothing, while one
+// reached by fewer vendors still does
+func TestFleetCommonDestination(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	fleet := vendorFleet("Espressif Inc.", 0xe5, 4)
+	learned := learnFleet(nm, fleet, "198.51.100.1")
+
+	others := []*models.DeviceInfo{
+		{ID: "02:00:00:0a:00:01", Vendor: "Apple, Inc."},
+		{ID: "02:00:00:0b:00:01", Vendor: "SAMSUNG ELECTRONICS CO., LTD"},
+		{ID: "02:00:00:0c:00:01", Vendor: "Intel Corporate"},
+		{ID: "02:00:00:0d:00:01", Vendor: "Unknown"}, // Not a vendor
+	}
+	for _, device := range others {
+		fleetContact(nm, device, "151.101.1.1", learned)
+	}
+	for _, device := range others[:2] {
+		fleetContact(nm, device, "151.101.2.2", learned)
+	}
+
+	for i, device := range fleet[:3] {
+		at := learned.Add(time.Duration(i) * time.Minute)
+		if v := fleetContact(nm, device, "151.101.1.1", at); v.outcome != OutcomeNotMatched {
+			t.Errorf("device %d to the CDN = %s (%s), want not matched", i, v.outcome, v.reason)
+		} else if i == 2 && v.reason != "destination contacted by devices of many vendors" {
+			t.Errorf("CDN not matched because %s", v.reason)
+		}
+		want := OutcomeNotMatched
+		if i == 2 {
+			want = OutcomeMatched
+		}
+		if v := fleetContact(nm, device, "151.101.2.2", at); v.outcome != want {
+			t.Errorf("device %d to a destination of three vendors = %s (%s), want %s", i, v.outcome, v.reason, want)
+		}
+	}
+
+	n, anomaly := countAnomalies(nm, "FLEET_NEW_DESTINATION")
+	if n != 1 || anomaly.Details["destination"] != "151.101.2.2" {
+		t.Errorf("%d fleet anomalies (%v), want one for 151.101.2.2", n, anomaly)
+	}
+
+	// Devices of an unknown vendor form no fleet
+	if v := fleetContact(nm, others[3], "203.0.113.99", learned); v.outcome != OutcomeSkipped {
+		t.Errorf("unknown vendor = %s, want skipped", v.outcome)
+	}
+}
+
+// A fleet twice MinDevices strong within the window raises a high severity
+// anomaly
+func TestFleetSeverity(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	fleet := vendorFleet("Tp-Link Corporation Limited", 0x7a, 4)
+	learned := learnFleet(nm, fleet, "198.51.100.1")
+
+	// The first devices reach it while the detector requires more of them
+	nm.SetFleetConfig(FleetConfig{MinDevices: 5, Window: 10 * time.Minute})
+	for i, device := range fleet[:3] {
+		fleetContact(nm, device, "203.0.113.5", learned.Add(time.Duration(i)*time.Minute))
+	}
+	nm.SetFleetConfig(FleetConfig{MinDevices: 2, Window: 10 * time.Minute})
+	if v := fleetContact(nm, fleet[3], "203.0.113.5", learned.Add(5*time.Minute)); v.outcome != OutcomeMatched {
+		t.Fatalf("fourth device = %s (%s), want matched", v.outcome, v.reason)
+	}
+	if _, anomaly := countAnomalies(nm, "FLEET_NEW_DESTINATION"); anomaly.Severity != models.SeverityHigh {
+		t.Errorf("severity %s, want %s", anomaly.Severity, models.SeverityHigh)
+	}
+}
@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// maxFlowStats bounds the flows tracked per device; the least recently seen
+// makes room for a new one
+const maxFlowStats = 256
+
+// flowKey names a TCP flow in DeviceInfo.FlowStats
+func flowKey(srcIP string, srcPort uint16, dstIP string, dstPort uint16) string {
+	return fmt.Sprintf("TCP:%s:%d->%s:%d", srcIP, srcPort, dstIP, dstPort)
+}
+
+// recordFlow adds packets of length bytes in total to a flow of a device.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) recordFlow(device *models.DeviceInfo, key string, packets, length uint64, now time.Time) {
+	if device.FlowStats == nil {
+		device.FlowStats = make(map[string]*models.FlowStats)
+	}
+	stats := device.FlowStats[key]
+	if stats == nil {
+		if len(device.FlowStats) >= maxFlowStats {
+			oldest := ""
+			for k, s := range device.FlowStats {
+				if oldest == "" || s.LastSeen.Before(device.FlowStats[oldest].LastSeen) {
+					oldest = k
+				}
+			}
+			delete(device.FlowStats, oldest)
+		}
+		stats = &models.FlowStats{FirstSeen: now}
+		device.FlowStats[key] = stats
+	}
+	stats.PacketCount += int(packets)
+	stats.ByteCount += int(length)
+	stats.LastSeen = now
+}
+
+// TrackFlowSummary adds the packets of an established TCP flow counted in the
+// kernel to the byte accounting of its device: flow statistics, packet sizes,
+// group and per-port traffic. The flow's first packet and its control
+// segments arrive as events, so patterns, connections and classification
+// come from TrackEvent; a summary of a device no longer cached only counts in
+// the totals.
+func (nm *NetworkMonitor) TrackFlowSummary(evt *models.FlowSummaryEvent) {
+	if utils.ValidateFlowSummaryEvent(evt) != nil {
+		nm.Stats.InvalidEvents.Add(1)
+		return
+	}
+
+	srcMAC := utils.MacToString(evt.SrcMac)
+	src := utils.IPFromBEUint32(evt.SrcIP)
+	dst := utils.IPFromBEUint32(evt.DstIP)
+	srcIP, dstIP := src.String(), dst.String()
+	packets := uint64(evt.Packets)
+	// Only the total is known, so every packet counts at the average size
+	size := uint16(min(evt.Bytes/packets, 0xffff))
+	now := time.Now()
+
+	nm.ifaces.RecordEvent(int(evt.IfIndex), packets, evt.Bytes, now)
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if nm.enabledEvents != nil && !nm.enabledEvents[models.EVENT_TYPE_TCP] {
+		nm.Stats.FilteredPackets.Add(1)
+		return
+	}
+	nm.Stats.FlowSummaries.Add(1)
+
+	deviceID, _ := nm.identify(srcMAC, src, models.EVENT_TYPE_TCP)
+	if nm.self.observe(deviceID, srcMAC, srcIP) {
+		nm.Stats.SelfPackets.Add(packets)
+	} else {
+		nm.Stats.FlowPackets.Add(packets)
+		nm.Stats.FlowBytes.Add(evt.Bytes)
+		nm.Stats.PacketSizes[sizeBucket(size)].Add(packets)
+	}
+	if nm.windowPackets != nil {
+		nm.windowPackets[deviceID] += int(packets)
+	}
+	nm.observeUtilization(evt.IfIndex, deviceID, evt.Bytes)
+	nm.observeHealth(deviceID, nm.interfaceName(evt.IfIndex), int(packets), true, false)
+
+	device, ok := nm.Cache.Get(deviceID)
+	if !ok {
+		return
+	}
+	defer nm.lockDevice(deviceID)()
+	device.LastSeen = now
+	recordActivity(device, now)
+	recordPacketSizes(device, size, packets)
+
+	nm.recordFlow(device, flowKey(srcIP, evt.SrcPort, dstIP, evt.DstPort), packets, evt.Bytes, now)
+	nm.groups.observe(device, packets, evt.Bytes, dstIP, nm.isExternalIP(dst), now)
+	nm.countPorts(device, "TCP/"+strconv.Itoa(int(evt.DstPort)), packets, evt.Bytes, now)
+}
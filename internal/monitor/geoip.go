@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"net"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// SetGeoIP sets the database external destinations are located with; nil
+// leaves them without country and autonomous system
+func (nm *NetworkMonitor) SetGeoIP(db *databases.GeoIPDatabase) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.geoIP = db
+}
+
+// GeoLookup returns the country and autonomous system of an IP address, if a
+// GeoIP database is loaded and knows it
+func (nm *NetworkMonitor) GeoLookup(ip string) (models.GeoInfo, bool) {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	return nm.geoLookup(ip)
+}
+
+// geoLookup is GeoLookup for callers holding nm.mu
+func (nm *NetworkMonitor) geoLookup(ip string) (models.GeoInfo, bool) {
+	if nm.geoIP == nil {
+		return models.GeoInfo{}, false
+	}
+	return nm.geoIP.Lookup(net.ParseIP(ip))
+}
@@ -0,0 +1,249 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Device groupings accepted by GroupStats
+const (
+	GroupByVendor  = "vendor"
+	GroupByNetwork = "network" // Local subnet, see DeviceInfo.Subnet
+)
+
+// groupings lists every grouping devices are indexed under
+var groupings = []string{GroupByVendor, GroupByNetwork}
+
+// GroupHistory is how far back the traffic of device groups is kept
+const GroupHistory = 7 * 24 * time.Hour
+
+// groupBucketWidth is the granularity of group traffic, and so of windows
+const groupBucketWidth = time.Hour
+
+// groupMaxDestinations bounds the destinations counted per group and bucket;
+// the traffic of later ones still adds to the totals
+const groupMaxDestinations = 64
+
+// groupTopDestinations is how many destinations GroupStats lists per group
+const groupTopDestinations = 10
+
+// ErrUnknownGrouping is returned by GroupStats for a grouping devices aren't
+// indexed under
+var ErrUnknownGrouping = errors.New("unknown grouping")
+
+type groupBucket struct {
+	start        time.Time
+	packets      uint64
+	bytes        uint64
+	external     uint64            // Bytes to external destinations
+	destinations map[string]uint64 // Destination IP -> packets
+}
+
+type deviceGroup struct {
+	members map[string]bool
+	buckets []*groupBucket // Oldest first
+}
+
+// groupIndex keeps the members of every device group up to date as devices
+// change, so group statistics don't scan every device, along with the hourly
+// traffic of each group. A group outlives its last member until its traffic
+// ages out. It is guarded by nm.mu.
+type groupIndex struct {
+	groups   map[string]map[string]*deviceGroup // Grouping -> group name -> group
+	memberOf map[string]map[string]string       // Device ID -> grouping -> group name
+}
+
+func newGroupIndex() *groupIndex {
+	g := &groupIndex{
+		groups:   make(map[string]map[string]*deviceGroup),
+		memberOf: make(map[string]map[string]string),
+	}
+	for _, grouping := range groupings {
+		g.groups[grouping] = make(map[string]*deviceGroup)
+	}
+	return g
+}
+
+// groupName returns the group of a device under a grouping
+func groupName(device *models.DeviceInfo, grouping string) string {
+	var name string
+	switch grouping {
+	case GroupByVendor:
+		name = device.Vendor
+	case GroupByNetwork:
+		name = device.Subnet
+	}
+	if name == "" {
+		return "Unknown"
+	}
+	return name
+}
+
+// group returns a group, creating it if needed
+func (g *groupIndex) group(grouping, name string) *deviceGroup {
+	group := g.groups[grouping][name]
+	if group == nil {
+		group = &deviceGroup{members: make(map[string]bool)}
+		g.groups[grouping][name] = group
+	}
+	return group
+}
+
+// observe moves a device to its current groups and adds packets of length
+// bytes in total to dstIP to their traffic
+func (g *groupIndex) observe(device *models.DeviceInfo, packets, length uint64, dstIP string, external bool, now time.Time) {
+	memberships := g.memberOf[device.ID]
+	if memberships == nil {
+		memberships = make(map[string]string, len(groupings))
+		g.memberOf[device.ID] = memberships
+	}
+
+	start := now.Truncate(groupBucketWidth)
+	for _, grouping := range groupings {
+		name := groupName(device, grouping)
+		if previous, ok := memberships[grouping]; ok && previous != name {
+			delete(g.groups[grouping][previous].members, device.ID)
+		}
+		memberships[grouping] = name
+		group := g.group(grouping, name)
+		group.members[device.ID] = true
+
+		var bucket *groupBucket
+		if n := len(group.buckets); n > 0 && group.buckets[n-1].start.Equal(start) {
+			bucket = group.buckets[n-1]
+		} else {
+			bucket = &groupBucket{start: start, destinations: make(map[string]uint64)}
+			group.buckets = append(group.buckets, bucket)
+			g.expire(grouping, name, now)
+		}
+		bucket.packets += packets
+		bucket.bytes += length
+		if external {
+			bucket.external += length
+		}
+		if dstIP != "" && dstIP != "0.0.0.0" {
+			if _, ok := bucket.destinations[dstIP]; ok || len(bucket.destinations) < groupMaxDestinations {
+				bucket.destinations[dstIP] += packets
+			}
+		}
+	}
+}
+
+// expire drops the traffic of a group older than GroupHistory, and the group
+// itself once it has neither members nor traffic left
+func (g *groupIndex) expire(grouping, name string, now time.Time) {
+	group := g.groups[grouping][name]
+	cutoff := now.Add(-GroupHistory)
+	i := 0
+	for i < len(group.buckets) && !group.buckets[i].start.Add(groupBucketWidth).After(cutoff) {
+		i++
+	}
+	group.buckets = group.buckets[i:]
+	if len(group.members) == 0 && len(group.buckets) == 0 {
+		delete(g.groups[grouping], name)
+	}
+}
+
+// removeDevice takes a device out of its groups; their traffic is kept
+func (g *groupIndex) removeDevice(id string) {
+	for grouping, name := range g.memberOf[id] {
+		delete(g.groups[grouping][name].members, id)
+	}
+	delete(g.memberOf, id)
+}
+
+// GroupStats aggregates devices by vendor or network: current and active
+// members, then the traffic and unacknowledged recent anomalies of the last
+// window (at most GroupHistory, counted in whole hours). Groups whose devices
+// have all left are still listed while they have traffic in the window. Each
+// device belongs to exactly one group per grouping. Groups are ordered by
+// members, then name.
+func (nm *NetworkMonitor) GroupStats(grouping string, window time.Duration) ([]models.GroupStats, error) {
+	if !slices.Contains(groupings, grouping) {
+		return nil, fmt.Errorf("%w %q: expected %s or %s", ErrUnknownGrouping, grouping, GroupByVendor, GroupByNetwork)
+	}
+	if window <= 0 || window > GroupHistory {
+		window = GroupHistory
+	}
+	now := time.Now()
+	since := now.Add(-window)
+	anomalies := nm.RecentAnomalies()
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	var stats []models.GroupStats
+	index := make(map[string]int)
+	for name := range nm.groups.groups[grouping] {
+		nm.groups.expire(grouping, name, now)
+		group, ok := nm.groups.groups[grouping][name]
+		if !ok {
+			continue
+		}
+
+		s := models.GroupStats{Group: name, Devices: len(group.members)}
+		for id := range group.members {
+			if device, ok := nm.Cache.Peek(id); ok && now.Sub(device.LastSeen) <= activityActiveWindow {
+				s.Active++
+			}
+		}
+		destinations := make(map[string]uint64)
+		for _, bucket := range group.buckets {
+			if !bucket.start.Add(groupBucketWidth).After(since) {
+				continue
+			}
+			s.Packets += bucket.packets
+			s.Bytes += bucket.bytes
+			s.ExternalBytes += bucket.external
+			for destination, packets := range bucket.destinations {
+				destinations[destination] += packets
+			}
+		}
+		if s.Devices == 0 && s.Packets == 0 {
+			continue
+		}
+		s.TopDestinations = topGroupDestinations(destinations)
+		index[name] = len(stats)
+		stats = append(stats, s)
+	}
+
+	for _, anomaly := range anomalies {
+		if anomaly.Ack != nil || anomaly.Timestamp.Before(since) {
+			continue
+		}
+		if i, ok := index[nm.groups.memberOf[anomaly.DeviceID][grouping]]; ok {
+			stats[i].UnackedAnomalies++
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Devices != stats[j].Devices {
+			return stats[i].Devices > stats[j].Devices
+		}
+		return stats[i].Group < stats[j].Group
+	})
+	return stats, nil
+}
+
+// topGroupDestinations returns the destinations with the most packets
+func topGroupDestinations(destinations map[string]uint64) []models.GroupDestination {
+	top := make([]models.GroupDestination, 0, len(destinations))
+	for destination, packets := range destinations {
+		top = append(top, models.GroupDestination{Destination: destination, Packets: packets})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Packets != top[j].Packets {
+			return top[i].Packets > top[j].Packets
+		}
+		return top[i].Destination < top[j].Destination
+	})
+	if len(top) > groupTopDestinations {
+		top = top[:groupTopDestinations]
+	}
+	return top
+}
@@ -0,0 +1,157 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// groupStatsByName returns the group statistics of a grouping by group name
+func groupStatsByName(t *testing.T, nm *NetworkMonitor, grouping string, window time.Duration) map[string]models.GroupStats {
+	t.Helper()
+	stats, err := nm.GroupStats(grouping, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]models.GroupStats, len(stats))
+	for _, s := range stats {
+		byName[s.Group] = s
+	}
+	return byName
+}
+
+// observeGroups records traffic of a device in its groups, as tracking does
+func observeGroups(nm *NetworkMonitor, device *models.DeviceInfo, packets, length uint64, dstIP string, external bool, at time.Time) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.groups.observe(device, packets, length, dstIP, external, at)
+}
+
+// A device is a member of one group per grouping, so its traffic counts in
+// its vendor and its network alike; moving to another group keeps the
+// traffic it already sent in the old one
+func TestGroupMembership(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	now := time.Now()
+	phone := &models.DeviceInfo{ID: "02:00:00:00:00:0a", Vendor: "Apple", Subnet: "192.168.1.0/24", LastSeen: now}
+	laptop := &models.DeviceInfo{ID: "02:00:00:00:00:0b", Vendor: "Apple", Subnet: "192.168.2.0/24", LastSeen: now}
+	printer := &models.DeviceInfo{ID: "02:00:00:00:00:0c", Vendor: "HP", Subnet: "192.168.1.0/24", LastSeen: now.Add(-time.Hour)}
+	unnamed := &models.DeviceInfo{ID: "02:00:00:00:00:0d", LastSeen: now}
+	for _, device := range []*models.DeviceInfo{phone, laptop, printer, unnamed} {
+		nm.Cache.Add(device.ID, device)
+	}
+	observeGroups(nm, phone, 10, 1000, "203.0.113.5", true, now)
+	observeGroups(nm, laptop, 5, 500, "192.168.1.1", false, now)
+	observeGroups(nm, printer, 2, 200, "192.168.1.5", false, now)
+	observeGroups(nm, unnamed, 1, 100, "0.0.0.0", false, now)
+
+	type want struct {
+		devices, active          int
+		packets, bytes, external uint64
+	}
+	check := func(when, grouping string, wants map[string]want) {
+		t.Helper()
+		stats := groupStatsByName(t, nm, grouping, time.Hour)
+		if len(stats) != len(wants) {
+			t.Errorf("%s: %d %s groups, want %d: %+v", when, len(stats), grouping, len(wants), stats)
+		}
+		for name, w := range wants {
+			s := stats[name]
+			got := want{s.Devices, s.Active, s.Packets, s.Bytes, s.ExternalBytes}
+			if got != w {
+				t.Errorf("%s: %s group %q = %+v, want %+v", when, grouping, name, got, w)
+			}
+		}
+	}
+	check("first seen", GroupByVendor, map[string]want{
+		"Apple":   {2, 2, 15, 1500, 1000},
+		"HP":      {1, 0, 2, 200, 0},
+		"Unknown": {1, 1, 1, 100, 0},
+	})
+	check("first seen", GroupByNetwork, map[string]want{
+		"192.168.1.0/24": {2, 1, 12, 1200, 1000},
+		"192.168.2.0/24": {1, 1, 5, 500, 0},
+		"Unknown":        {1, 1, 1, 100, 0},
+	})
+	if s := groupStatsByName(t, nm, GroupByVendor, time.Hour)["Apple"]; len(s.TopDestinations) != 2 ||
+		s.TopDestinations[0] != (models.GroupDestination{Destination: "203.0.113.5", Packets: 10}) {
+		t.Errorf("Apple destinations = %+v, want 203.0.113.5 first", s.TopDestinations)
+	}
+
+	// The phone joins the other network; its vendor group is unchanged
+	phone.Subnet = "192.168.2.0/24"
+	observeGroups(nm, phone, 1, 100, "203.0.113.5", true, now)
+	check("after moving", GroupByNetwork, map[string]want{
+		"192.168.1.0/24": {1, 0, 12, 1200, 1000},
+		"192.168.2.0/24": {2, 2, 6, 600, 100},
+		"Unknown":        {1, 1, 1, 100, 0},
+	})
+	check("after moving", GroupByVendor, map[string]want{
+		"Apple":   {2, 2, 16, 1600, 1100},
+		"HP":      {1, 0, 2, 200, 0},
+		"Unknown": {1, 1, 1, 100, 0},
+	})
+
+	// A group whose devices have left is listed while it has traffic
+	nm.lockAll()
+	nm.groups.removeDevice(printer.ID)
+	nm.unlockAll()
+	check("after removal", GroupByVendor, map[string]want{
+		"Apple":   {2, 2, 16, 1600, 1100},
+		"HP":      {0, 0, 2, 200, 0},
+		"Unknown": {1, 1, 1, 100, 0},
+	})
+
+	if _, err := nm.GroupStats("owner", time.Hour); !errors.Is(err, ErrUnknownGrouping) {
+		t.Errorf("grouping by owner: %v, want %v", err, ErrUnknownGrouping)
+	}
+}
+
+// Traffic counts within the window in whole hours, and traffic older than
+// GroupHistory is dropped, along with groups left with neither members nor
+// traffic
+func TestGroupStatsWindow(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	now := time.Now()
+	phone := &models.DeviceInfo{ID: "02:00:00:00:00:0a", Vendor: "Apple", LastSeen: now}
+	router := &models.DeviceInfo{ID: "02:00:00:00:00:0b", Vendor: "Cisco", LastSeen: now.Add(-8 * 24 * time.Hour)}
+	observeGroups(nm, router, 1, 100, "203.0.113.5", true, router.LastSeen)
+	for _, ago := range []time.Duration{8 * 24 * time.Hour, 30 * time.Hour, 3 * time.Hour, 0} {
+		observeGroups(nm, phone, 1, 100, "203.0.113.5", true, now.Add(-ago))
+	}
+	nm.lockAll()
+	nm.groups.removeDevice(router.ID)
+	nm.unlockAll()
+
+	tests := []struct {
+		window time.Duration
+		want   uint64
+	}{
+		{time.Hour, 1},
+		{2 * time.Hour, 1},
+		{24 * time.Hour, 2},
+		{48 * time.Hour, 3},
+		{0, 3}, // GroupHistory
+		{30 * 24 * time.Hour, 3},
+	}
+	for _, tt := range tests {
+		stats := groupStatsByName(t, nm, GroupByVendor, tt.window)
+		if got := stats["Apple"].Packets; got != tt.want {
+			t.Errorf("packets within %v = %d, want %d", tt.window, got, tt.want)
+		}
+		if s, ok := stats["Cisco"]; ok {
+			t.Errorf("within %v, expired group listed: %+v", tt.window, s)
+		}
+	}
+
+	nm.lockAll()
+	defer nm.unlockAll()
+	if n := len(nm.groups.groups[GroupByVendor]["Apple"].buckets); n != 3 {
+		t.Errorf("%d hourly buckets kept, want 3", n)
+	}
+	if _, ok := nm.groups.groups[GroupByVendor]["Cisco"]; ok {
+		t.Error("group with neither members nor traffic kept")
+	}
+}
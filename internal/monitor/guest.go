@@ -0,0 +1,227 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// ForgottenKeyPrefix prefixes the summaries of forgotten transient devices in
+// the database.
+const ForgottenKeyPrefix = "transient:"
+
+// transientSweepInterval is how often inactive transient devices are looked for
+const transientSweepInterval = 5 * time.Minute
+
+// forgottenTopDomains bounds the DNS domains kept in a forgotten device summary
+const forgottenTopDomains = 5
+
+// GuestConfig marks networks whose devices are transient, such as guest Wi-Fi
+// where most devices visit once. Devices first seen on a guest network are
+// flagged transient until they show up on another network, and forgotten after
+// a period of inactivity.
+type GuestConfig struct {
+	Interfaces []string      // Interfaces carrying only guest traffic
+	Subnets    []*net.IPNet  // Guest subnets, for interfaces shared with trusted networks
+	Expiry     time.Duration // Inactivity after which a transient device is forgotten (0 keeps them)
+	Archive    bool          // Keep a compact summary of each forgotten device
+	Notify     bool          // Announce new transient devices like any other new device
+}
+
+// DefaultGuestConfig returns the default guest network settings. No network is
+// a guest network until configured.
+func DefaultGuestConfig() GuestConfig {
+	return GuestConfig{Expiry: 24 * time.Hour, Archive: true}
+}
+
+// SetGuestConfig replaces the guest network settings
+func (nm *NetworkMonitor) SetGuestConfig(config GuestConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.guest = config
+}
+
+// onGuestNetwork reports whether traffic from ip on an interface comes from a
+// guest network. Must hold nm.mu.
+func (nm *NetworkMonitor) onGuestNetwork(ifIndex uint32, ip string) bool {
+	if len(nm.guest.Interfaces) > 0 && slices.Contains(nm.guest.Interfaces, utils.IfIndexToName(ifIndex)) {
+		return true
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, subnet := range nm.guest.Subnets {
+			if subnet.Contains(parsed) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transientSweep forgets inactive transient devices, every
+// transientSweepInterval
+func (nm *NetworkMonitor) transientSweep(now time.Time) {
+	nm.forgetTransientDevices(now)
+}
+
+// forgetTransientDevices deletes the transient devices inactive for longer
+// than the guest expiry, from the cache and the database, archiving a summary
+// of each if configured. It returns the devices forgotten.
+func (nm *NetworkMonitor) forgetTransientDevices(now time.Time) ([]models.ForgottenDevice, error) {
+	nm.mu.RLock()
+	config := nm.guest
+	retention := nm.patternRetention
+	nm.mu.RUnlock()
+	if config.Expiry <= 0 {
+		return nil, nil
+	}
+	cutoff := now.Add(-config.Expiry)
+	expired := func(device *models.DeviceInfo) bool {
+		return device.Transient && device.LastSeen.Before(cutoff)
+	}
+
+	// Persisted devices, some of which the cache holds newer copies of
+	var stored []*models.DeviceInfo
+	err := nm.db.View(func(tx *buntdb.Tx) error {
+		return ascendDevices(tx, func(key, value string) bool {
+			var device models.DeviceInfo
+			if json.Unmarshal([]byte(value), &device) == nil && expired(&device) {
+				if device.ID == "" {
+					device.ID = key
+				}
+				stored = append(stored, &device)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var forgotten []*models.DeviceInfo
+	cached := make(map[string]bool)
+	nm.lockAll()
+	for _, id := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Peek(id)
+		if !ok {
+			continue
+		}
+		cached[id] = true
+		if expired(device) {
+			nm.Cache.Remove(id)
+			forgotten = append(forgotten, device)
+		}
+	}
+	for _, device := range stored {
+		// Cached devices were judged on their newer copy above
+		if !cached[device.ID] && !nm.Cache.Contains(device.ID) {
+			forgotten = append(forgotten, device)
+		}
+	}
+	for _, device := range forgotten {
+		delete(nm.seenDirty, device.ID)
+		nm.groups.removeDevice(device.ID)
+		delete(nm.portShare.devices, device.ID)
+		nm.contacts.removeDevice(device.ID)
+	}
+	nm.unlockAll()
+	if len(forgotten) == 0 {
+		return nil, nil
+	}
+
+	var opts *buntdb.SetOptions
+	if retention > 0 {
+		opts = &buntdb.SetOptions{Expires: true, TTL: retention}
+	}
+	summaries := make([]models.ForgottenDevice, 0, len(forgotten))
+	tombstones := make(map[string]string)
+	for _, device := range forgotten {
+		nm.searchIndex.removeDevice(device.ID)
+		summaries = append(summaries, summarizeForgotten(device, now))
+		maps.Copy(tombstones, nm.uuids.forget(device))
+	}
+
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		if err := writeUUIDs(tx, tombstones); err != nil {
+			return err
+		}
+		for i, device := range forgotten {
+			if _, err := tx.Delete(device.ID); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+			if err := deleteSeenPatterns(tx, device.ID); err != nil {
+				return err
+			}
+			if !config.Archive {
+				continue
+			}
+			data, _ := json.Marshal(&summaries[i])
+			if _, _, err := tx.Set(forgottenKey(summaries[i]), string(data), opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return summaries, err
+}
+
+// forgottenKey orders forgotten device summaries by the time they were forgotten
+func forgottenKey(summary models.ForgottenDevice) string {
+	return fmt.Sprintf("%s%020d:%s", ForgottenKeyPrefix, summary.ForgottenAt.UnixNano(), summary.ID)
+}
+
+// summarizeForgotten condenses a transient device into what is kept once it
+// is forgotten
+func summarizeForgotten(device *models.DeviceInfo, now time.Time) models.ForgottenDevice {
+	domains := make([]string, 0, len(device.DNSDomains))
+	for domain := range device.DNSDomains {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if device.DNSDomains[domains[i]] != device.DNSDomains[domains[j]] {
+			return device.DNSDomains[domains[i]] > device.DNSDomains[domains[j]]
+		}
+		return domains[i] < domains[j]
+	})
+	if len(domains) > forgottenTopDomains {
+		domains = domains[:forgottenTopDomains]
+	}
+
+	return models.ForgottenDevice{
+		ID:          device.ID,
+		UUID:        device.UUID,
+		MAC:         device.MAC,
+		IP:          device.IP,
+		Vendor:      device.Vendor,
+		Interface:   device.Interface,
+		OS:          DeviceOS(device),
+		FirstSeen:   device.FirstSeen,
+		LastSeen:    device.LastSeen,
+		ForgottenAt: now,
+		Connections: device.TCPConnections + device.UDPConnections,
+		TopDomains:  domains,
+	}
+}
+
+// ForgottenDevices returns the summaries of forgotten transient devices,
+// most recently forgotten first, up to limit
+func (nm *NetworkMonitor) ForgottenDevices(limit int) ([]models.ForgottenDevice, error) {
+	summaries := []models.ForgottenDevice{}
+	err := nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.DescendRange("", ForgottenKeyPrefix+"~", ForgottenKeyPrefix, func(key, value string) bool {
+			var summary models.ForgottenDevice
+			if json.Unmarshal([]byte(value), &summary) == nil {
+				summaries = append(summaries, summary)
+			}
+			return len(summaries) < limit
+		})
+	})
+	return summaries, err
+}
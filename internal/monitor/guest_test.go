@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Devices first seen on the guest subnet are transient until they show up on
+// another network; the sweep forgets those inactive for longer than the
+// expiry, cached or persisted, and archives a summary of each
+func TestForgetTransientDevices(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	_, guestNet, _ := net.ParseCIDR("192.168.50.0/24")
+	nm.SetGuestConfig(GuestConfig{Subnets: []*net.IPNet{guestNet}, Expiry: 24 * time.Hour, Archive: true})
+
+	visitor, laptop, phone := "02:00:00:00:00:0a", "02:00:00:00:00:0b", "02:00:00:00:00:0c"
+	nm.TrackEvent(tcpEvent(t, visitor, "192.168.50.10", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, visitor, "192.168.50.10", "203.0.113.6", 443))
+	nm.TrackEvent(tcpEvent(t, laptop, "192.168.1.10", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, phone, "192.168.50.11", "203.0.113.5", 443))
+	transient := func(id string) bool {
+		device, ok := nm.GetDevice(id)
+		return ok && device.Transient
+	}
+	if !transient(visitor) || transient(laptop) || !transient(phone) {
+		t.Errorf("transient: visitor %v, laptop %v, phone %v, want true, false, true",
+			transient(visitor), transient(laptop), transient(phone))
+	}
+
+	// The phone joins the trusted network and becomes a permanent device
+	nm.TrackEvent(tcpEvent(t, phone, "192.168.1.11", "203.0.113.5", 443))
+	if transient(phone) {
+		t.Error("phone still transient after showing up on the trusted network")
+	}
+	nm.TrackEvent(tcpEvent(t, phone, "192.168.50.11", "203.0.113.5", 443))
+	if transient(phone) {
+		t.Error("promoted phone transient again back on the guest network")
+	}
+
+	nm.lockAll()
+	device, _ := nm.Cache.Peek(visitor)
+	device.DNSDomains = map[string]int{"cdn.example": 2, "wifi.example": 9}
+	lastSeen := device.LastSeen
+	nm.unlockAll()
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second visitor only in the database, evicted long ago
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0d", "192.168.50.12", "203.0.113.5", 443))
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	nm.Cache.Remove("02:00:00:00:00:0d")
+
+	if forgotten, err := nm.forgetTransientDevices(lastSeen.Add(23 * time.Hour)); err != nil || len(forgotten) != 0 {
+		t.Fatalf("before the expiry: forgot %v, %v", forgotten, err)
+	}
+	sweep := lastSeen.Add(25 * time.Hour)
+	forgotten, err := nm.forgetTransientDevices(sweep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, summary := range forgotten {
+		ids = append(ids, summary.ID)
+	}
+	slices.Sort(ids)
+	if want := []string{visitor, "02:00:00:00:00:0d"}; !slices.Equal(ids, want) {
+		t.Fatalf("forgot %v, want %v", ids, want)
+	}
+
+	for id, want := range map[string]bool{visitor: false, laptop: true, phone: true} {
+		if _, ok := nm.GetDevice(id); ok != want {
+			t.Errorf("%s cached %v after the sweep, want %v", id, ok, want)
+		}
+	}
+	for _, id := range []string{visitor, "02:00:00:00:00:0d"} {
+		if nm.loadDevice(id) != nil {
+			t.Errorf("%s still persisted after the sweep", id)
+		}
+	}
+
+	archived, err := nm.ForgottenDevices(10)
+	if err != nil || len(archived) != 2 {
+		t.Fatalf("archived %d summaries, %v, want 2", len(archived), err)
+	}
+	var summary models.ForgottenDevice
+	for _, s := range archived {
+		if s.ID == visitor {
+			summary = s
+		}
+	}
+	if summary.IP != "192.168.50.10" || summary.Connections != 2 || !summary.ForgottenAt.Equal(sweep) ||
+		!slices.Equal(summary.TopDomains, []string{"wifi.example", "cdn.example"}) {
+		t.Errorf("visitor summary = %+v", summary)
+	}
+
+	// Without archiving, expired devices leave nothing behind
+	nm.SetGuestConfig(GuestConfig{Subnets: []*net.IPNet{guestNet}, Expiry: time.Hour})
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0e", "192.168.50.13", "203.0.113.5", 443))
+	if forgotten, err := nm.forgetTransientDevices(time.Now().Add(2 * time.Hour)); err != nil || len(forgotten) != 1 {
+		t.Fatalf("forgot %v, %v, want the new visitor", forgotten, err)
+	}
+	if archived, _ := nm.ForgottenDevices(10); len(archived) != 2 {
+		t.Errorf("%d summaries archived, want still 2", len(archived))
+	}
+}
+
+// A summary keeps the identity of a device, its connection count and its
+// most queried domains, ties by name
+func TestSummarizeForgotten(t *testing.T) {
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	device := &models.DeviceInfo{
+		ID: "02:00:00:00:00:0a", UUID: "6f1c", MAC: "02:00:00:00:00:0a", IP: "192.168.50.10",
+		Vendor: "Apple", Interface: "wlan1", FirstSeen: now.Add(-2 * time.Hour), LastSeen: now.Add(-time.Hour),
+		TCPConnections: 7, UDPConnections: 3, ICMPPackets: 4,
+		DNSDomains: map[string]int{"a.example": 1, "b.example": 5, "c.example": 5, "d.example": 3, "e.example": 2, "f.example": 2},
+	}
+	summary := summarizeForgotten(device, now)
+	if summary.ID != device.ID || summary.UUID != "6f1c" || summary.Vendor != "Apple" || summary.Interface != "wlan1" ||
+		!summary.FirstSeen.Equal(device.FirstSeen) || !summary.LastSeen.Equal(device.LastSeen) || !summary.ForgottenAt.Equal(now) {
+		t.Errorf("summary = %+v", summary)
+	}
+	if summary.Connections != 10 {
+		t.Errorf("connections = %d, want TCP and UDP events only", summary.Connections)
+	}
+	if want := []string{"b.example", "c.example", "d.example", "e.example", "f.example"}; !slices.Equal(summary.TopDomains, want) {
+		t.Errorf("top domains = %v, want %v", summary.TopDomains, want)
+	}
+}
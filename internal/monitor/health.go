@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/version"
+)
+
+// recordPersistResult tracks persistence failures and recoveries. Capture keeps
+// running in memory either way; a failure streak is reported once when it
+// starts and once when writes succeed again.
+func (nm *NetworkMonitor) recordPersistResult(err error) {
+	now := time.Now()
+
+	nm.persistMu.Lock()
+	status := &nm.persistence
+	wasHealthy := status.Healthy
+	var failingSince time.Time
+	if status.FailingSince != nil {
+		failingSince = *status.FailingSince
+	}
+
+	if err != nil {
+		status.Healthy = false
+		status.LastError = err.Error()
+		status.FailedWrites++
+		if wasHealthy {
+			status.FailingSince = &now
+		}
+	} else {
+		status.Healthy = true
+		status.LastSuccess = &now
+		status.LastError = ""
+		status.FailingSince = nil
+	}
+	nm.persistMu.Unlock()
+
+	if err != nil {
+		nm.Stats.FailedPersists.Add(1)
+	}
+
+	switch {
+	case err != nil && wasHealthy:
+		fmt.Printf("ERROR: persisting devices failed, continuing in memory only: %v\n", err)
+		nm.raiseAnomaly("PERSISTENCE_FAILED", models.SeverityHigh, "",
+			map[string]string{"error": err.Error()},
+			map[string]string{"error": err.Error()})
+	case err == nil && !wasHealthy:
+		duration := now.Sub(failingSince).Round(time.Second)
+		fmt.Printf("Persistence recovered after %s\n", duration)
+		nm.raiseAnomaly("PERSISTENCE_RECOVERED", models.SeverityInfo, "",
+			map[string]string{"duration": duration.String()},
+			map[string]string{"duration": duration.String()})
+	}
+}
+
+// PersistenceStatus returns the current state of database persistence
+func (nm *NetworkMonitor) PersistenceStatus() models.PersistenceStatus {
+	nm.persistMu.Lock()
+	defer nm.persistMu.Unlock()
+	return nm.persistence
+}
+
+// Health reports whether cerberus is fully operational or running degraded
+func (nm *NetworkMonitor) Health() models.HealthStatus {
+	health := models.HealthStatus{
+		Status:      models.HealthOK,
+		Persistence: nm.PersistenceStatus(),
+		Version:     version.Version,
+		Timestamp:   time.Now(),
+	}
+
+	capture, captureErr := nm.CaptureStatus()
+	if captureErr == nil {
+		health.Capture = capture
+	}
+
+	nm.mu.RLock()
+	health.DefensiveMode = nm.defensive != nil
+	nm.mu.RUnlock()
+	var silent []string
+	for _, iface := range nm.ifaces.List() {
+		if iface.Degraded {
+			silent = append(silent, iface.Name)
+		}
+	}
+
+	health.ReadOnly = nm.readOnly
+	health.Database = nm.DatabaseIncident()
+	if incident := health.Database; incident != nil {
+		health.Reasons = append(health.Reasons, fmt.Sprintf("database was corrupt at startup, moved to %s: %d entries recovered, %d bytes of history lost",
+			incident.MovedTo, incident.RecoveredKeys, incident.LostBytes))
+	}
+	if !health.Persistence.Healthy {
+		health.Reasons = append(health.Reasons, "persistence failing: "+health.Persistence.LastError)
+	}
+	if health.DefensiveMode {
+		health.Reasons = append(health.Reasons, "resource limits exceeded: defensive mode active")
+	}
+	for _, name := range silent {
+		health.Reasons = append(health.Reasons, "interface "+name+" silent while its link is up")
+	}
+	if captureErr != nil && !errors.Is(captureErr, ErrNoCaptureControl) {
+		health.Reasons = append(health.Reasons, "capture config unreadable: "+captureErr.Error())
+	}
+	if len(health.Reasons) > 0 {
+		health.Status = models.HealthDegraded
+	}
+
+	return health
+}
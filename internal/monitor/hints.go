@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// riskColors suggests a display color per risk level
+var riskColors = map[string]string{
+	RiskLevelLow:    "#2e7d32",
+	RiskLevelMedium: "#f9a825",
+	RiskLevelHigh:   "#c62828",
+}
+
+// typeIcons maps inferred device types to icon keys
+var typeIcons = map[string]string{
+	DeviceTypePrinter:   models.IconPrinter,
+	DeviceTypeCamera:    models.IconCamera,
+	DeviceTypeVoIPPhone: models.IconPhone,
+}
+
+// vendorIcon maps vendors whose devices are nearly all of one kind to an icon
+// key. pattern is matched as words of the normalized vendor, see
+// databases.NormalizeVendor.
+type vendorIcon struct {
+	pattern string
+	icon    string
+}
+
+// vendorIcons is checked in order. Vendors making many kinds of devices
+// (Apple, Samsung, HP) are left to the OS guess.
+var vendorIcons = []vendorIcon{
+	{"hikvision", models.IconCamera},
+	{"dahua", models.IconCamera},
+	{"axis communications", models.IconCamera},
+	{"reolink", models.IconCamera},
+	{"amcrest", models.IconCamera},
+	{"arlo", models.IconCamera},
+	{"wyze", models.IconCamera},
+	{"brother", models.IconPrinter},
+	{"seiko epson", models.IconPrinter},
+	{"canon", models.IconPrinter},
+	{"lexmark", models.IconPrinter},
+	{"xerox", models.IconPrinter},
+	{"kyocera", models.IconPrinter},
+	{"cisco", models.IconRouter},
+	{"ubiquiti", models.IconRouter},
+	{"tp-link", models.IconRouter},
+	{"netgear", models.IconRouter},
+	{"mikrotik", models.IconRouter},
+	{"routerboard", models.IconRouter},
+	{"juniper", models.IconRouter},
+	{"aruba", models.IconRouter},
+	{"ruckus", models.IconRouter},
+	{"fortinet", models.IconRouter},
+	{"zyxel", models.IconRouter},
+	{"roku", models.IconTV},
+	{"vizio", models.IconTV},
+	{"hisense", models.IconTV},
+	{"tcl", models.IconTV},
+	{"sonos", models.IconSpeaker},
+	{"bose", models.IconSpeaker},
+	{"espressif", models.IconIoT},
+	{"tuya", models.IconIoT},
+	{"shelly", models.IconIoT},
+	{"nintendo", models.IconGameConsole},
+	{"sony interactive", models.IconGameConsole},
+}
+
+// osIcons maps guessed operating systems to icon keys
+var osIcons = map[string]string{
+	OSWindows:       models.IconLaptop,
+	OSApple:         models.IconLaptop,
+	OSNetworkDevice: models.IconRouter,
+	OSEmbedded:      models.IconIoT,
+}
+
+// iconNouns name the kind of device of an icon in labels
+var iconNouns = map[string]string{
+	models.IconPhone:       "phone",
+	models.IconLaptop:      "computer",
+	models.IconTV:          "TV",
+	models.IconCamera:      "camera",
+	models.IconPrinter:     "printer",
+	models.IconRouter:      "network device",
+	models.IconSpeaker:     "speaker",
+	models.IconServer:      "server",
+	models.IconIoT:         "smart device",
+	models.IconGameConsole: "game console",
+}
+
+// ValidIcon reports whether an icon key is one of models.Icons
+func ValidIcon(icon string) bool {
+	return slices.Contains(models.Icons, icon)
+}
+
+// DeviceIcon returns the icon key of a device and what chose it: its own icon,
+// else its inferred type, the monitoring host, its vendor or its guessed OS
+func DeviceIcon(device *models.DeviceInfo) (icon, source string) {
+	if device.Icon != "" {
+		return device.Icon, models.IconSourceOverride
+	}
+	if icon, ok := typeIcons[DeviceType(device)]; ok {
+		return icon, models.IconSourceType
+	}
+	if device.Self {
+		return models.IconServer, models.IconSourceType
+	}
+	if vendor := databases.NormalizeVendor(device.Vendor); vendor != "" {
+		padded := " " + vendor + " "
+		for _, rule := range vendorIcons {
+			if strings.Contains(padded, " "+rule.pattern+" ") {
+				return rule.icon, models.IconSourceVendor
+			}
+		}
+	}
+	if icon, ok := osIcons[DeviceOS(device)]; ok {
+		return icon, models.IconSourceOS
+	}
+	return models.IconUnknown, models.IconSourceDefault
+}
+
+// DeviceHints suggests how a UI presents a device with a risk score
+func DeviceHints(device *models.DeviceInfo, riskScore int) models.DeviceHints {
+	icon, source := DeviceIcon(device)
+	level := RiskLevel(riskScore)
+	return models.DeviceHints{
+		Icon:       icon,
+		IconSource: source,
+		Color:      riskColors[level],
+		RiskScore:  riskScore,
+		RiskLevel:  level,
+		Label:      hintLabel(device, icon),
+	}
+}
+
+// hintLabel names a device: its inventory name, else its vendor and kind,
+// else its address
+func hintLabel(device *models.DeviceInfo, icon string) string {
+	if device.Name != "" {
+		return device.Name
+	}
+	kind := DeviceType(device)
+	if kind == "" {
+		kind = iconNouns[icon]
+	}
+	// Registered names carry their legal form after a comma
+	vendor, _, _ := strings.Cut(device.Vendor, ",")
+	if databases.NormalizeVendor(vendor) == "" {
+		vendor = ""
+	}
+	switch {
+	case vendor != "" && kind != "":
+		return vendor + " " + kind
+	case vendor != "":
+		return vendor + " device"
+	case kind != "":
+		return strings.ToUpper(kind[:1]) + kind[1:]
+	case device.IP != "":
+		return device.IP
+	}
+	return device.ID
+}
+
+// DeviceHints returns the presentation hints of a device, scored with the
+// configured risk weights
+func (nm *NetworkMonitor) DeviceHints(device *models.DeviceInfo) models.DeviceHints {
+	nm.mu.RLock()
+	weights := nm.riskWeights
+	nm.mu.RUnlock()
+	return DeviceHints(device, ScoreDevice(device, weights).Score)
+}
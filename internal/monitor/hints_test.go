@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// releasedIcons are the icon keys clients were given. They may be added to,
+// never renamed, removed or reordered.
+var releasedIcons = []string{
+	"unknown", "phone", "laptop", "tv", "camera", "printer",
+	"router", "speaker", "server", "iot", "game_console",
+}
+
+// The icon enum only grows, every key the tables give is in it and has a noun
+// for labels, and the README lists each one
+func TestIconEnum(t *testing.T) {
+	if len(models.Icons) < len(releasedIcons) || !slices.Equal(models.Icons[:len(releasedIcons)], releasedIcons) {
+		t.Errorf("Icons = %v, want it to start with the released %v", models.Icons, releasedIcons)
+	}
+	seen := make(map[string]bool)
+	for _, icon := range models.Icons {
+		if seen[icon] {
+			t.Errorf("icon %s listed twice", icon)
+		}
+		seen[icon] = true
+		if _, ok := iconNouns[icon]; !ok && icon != models.IconUnknown {
+			t.Errorf("icon %s has no noun for labels", icon)
+		}
+	}
+
+	var mapped []string
+	for _, icon := range typeIcons {
+		mapped = append(mapped, icon)
+	}
+	for _, icon := range osIcons {
+		mapped = append(mapped, icon)
+	}
+	for _, rule := range vendorIcons {
+		mapped = append(mapped, rule.icon)
+		if rule.pattern != strings.ToLower(strings.TrimSpace(rule.pattern)) {
+			t.Errorf("vendor pattern %q is not normalized", rule.pattern)
+		}
+	}
+	for _, icon := range mapped {
+		if !ValidIcon(icon) {
+			t.Errorf("tables map to %q, not in Icons", icon)
+		}
+	}
+
+	readme, err := os.ReadFile("../../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, section, _ := strings.Cut(string(readme), "#### Device Hints")
+	section, _, _ = strings.Cut(section, "\n#### ")
+	for _, icon := range models.Icons {
+		if !strings.Contains(section, "`"+icon+"`") {
+			t.Errorf("README Device Hints does not list icon %s", icon)
+		}
+	}
+}
+
+func TestDeviceIcon(t *testing.T) {
+	camera := &models.DeviceTypeGuess{Type: DeviceTypeCamera}
+	windows := &models.OSGuess{OS: OSWindows}
+	tests := []struct {
+		name   string
+		device models.DeviceInfo
+		icon   string
+		source string
+	}{
+		{"nothing known", models.DeviceInfo{Vendor: "Unknown"}, models.IconUnknown, models.IconSourceDefault},
+		{"override first", models.DeviceInfo{Icon: models.IconTV, DeviceType: camera, Vendor: "Sonos, Inc."}, models.IconTV, models.IconSourceOverride},
+		{"type over vendor", models.DeviceInfo{DeviceType: camera, Vendor: "Sonos, Inc."}, models.IconCamera, models.IconSourceType},
+		{"printer type", models.DeviceInfo{DeviceType: &models.DeviceTypeGuess{Type: DeviceTypePrinter}}, models.IconPrinter, models.IconSourceType},
+		{"VoIP phone", models.DeviceInfo{DeviceType: &models.DeviceTypeGuess{Type: DeviceTypeVoIPPhone}}, models.IconPhone, models.IconSourceType},
+		{"unmapped type", models.DeviceInfo{DeviceType: &models.DeviceTypeGuess{Type: "Toaster"}, OSGuess: windows}, models.IconLaptop, models.IconSourceOS},
+		{"monitoring host", models.DeviceInfo{Self: true, Vendor: "Cisco Systems, Inc"}, models.IconServer, models.IconSourceType},
+		{"camera vendor", models.DeviceInfo{Vendor: "Hangzhou Hikvision Digital Technology Co.,Ltd."}, models.IconCamera, models.IconSourceVendor},
+		{"printer vendor", models.DeviceInfo{Vendor: "Brother Industries, Ltd.", OSGuess: windows}, models.IconPrinter, models.IconSourceVendor},
+		{"multi-word vendor", models.DeviceInfo{Vendor: "Sony Interactive Entertainment Inc."}, models.IconGameConsole, models.IconSourceVendor},
+		{"other part of a vendor", models.DeviceInfo{Vendor: "Sony Corporation"}, models.IconUnknown, models.IconSourceDefault},
+		{"hyphenated vendor", models.DeviceInfo{Vendor: "TP-LINK TECHNOLOGIES CO.,LTD."}, models.IconRouter, models.IconSourceVendor},
+		{"whole words only", models.DeviceInfo{Vendor: "Canonical Ltd", OSGuess: &models.OSGuess{OS: OSLinux}}, models.IconUnknown, models.IconSourceDefault},
+		{"vendor of many kinds", models.DeviceInfo{Vendor: "Apple, Inc.", OSGuess: &models.OSGuess{OS: OSApple}}, models.IconLaptop, models.IconSourceOS},
+		{"embedded OS", models.DeviceInfo{OSGuess: &models.OSGuess{OS: OSEmbedded}}, models.IconIoT, models.IconSourceOS},
+		{"network device OS", models.DeviceInfo{OSGuess: &models.OSGuess{OS: OSNetworkDevice}}, models.IconRouter, models.IconSourceOS},
+	}
+	for _, tt := range tests {
+		icon, source := DeviceIcon(&tt.device)
+		if icon != tt.icon || source != tt.source {
+			t.Errorf("%s: DeviceIcon = %s from %s, want %s from %s", tt.name, icon, source, tt.icon, tt.source)
+		}
+	}
+}
+
+// Hints color the risk level and label a device by name, else vendor and
+// kind, else address
+func TestDeviceHints(t *testing.T) {
+	tests := []struct {
+		name   string
+		device models.DeviceInfo
+		risk   int
+		color  string
+		level  string
+		label  string
+	}{
+		{"named", models.DeviceInfo{Name: "Front door", Vendor: "Hangzhou Hikvision Digital Technology Co.,Ltd."}, 0, "#2e7d32", RiskLevelLow, "Front door"},
+		{"vendor and type", models.DeviceInfo{Vendor: "Brother Industries, Ltd.", DeviceType: &models.DeviceTypeGuess{Type: DeviceTypePrinter}}, 29, "#2e7d32", RiskLevelLow, "Brother Industries Printer"},
+		{"vendor and icon", models.DeviceInfo{Vendor: "Sonos, Inc."}, 30, "#f9a825", RiskLevelMedium, "Sonos speaker"},
+		{"vendor only", models.DeviceInfo{Vendor: "Acme Widgets"}, 59, "#f9a825", RiskLevelMedium, "Acme Widgets device"},
+		{"kind only", models.DeviceInfo{Vendor: "Unknown", OSGuess: &models.OSGuess{OS: OSWindows}}, 60, "#c62828", RiskLevelHigh, "Computer"},
+		{"address", models.DeviceInfo{ID: "ip:10.1.2.3", IP: "10.1.2.3", Vendor: "Routed"}, 100, "#c62828", RiskLevelHigh, "10.1.2.3"},
+		{"ID", models.DeviceInfo{ID: "02:00:00:00:00:0a"}, 0, "#2e7d32", RiskLevelLow, "02:00:00:00:00:0a"},
+	}
+	for _, tt := range tests {
+		hints := DeviceHints(&tt.device, tt.risk)
+		if hints.Color != tt.color || hints.RiskLevel != tt.level || hints.RiskScore != tt.risk || hints.Label != tt.label {
+			t.Errorf("%s: hints %+v, want color %s, level %s, label %q", tt.name, hints, tt.color, tt.level, tt.label)
+		}
+	}
+}
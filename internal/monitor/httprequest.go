@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// TrackHTTPRequest records the Host header of a captured HTTP request against
+// its sender
+func (nm *NetworkMonitor) TrackHTTPRequest(evt *models.HTTPRequestEvent) {
+	host := utils.HTTPHostHeader(evt.Data)
+	if host == "" {
+		return
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	deviceID, _ := nm.identify(utils.MacToString(evt.SrcMac), utils.IPFromBEUint32(evt.SrcIP), models.EVENT_TYPE_HTTP)
+	device, tracked := nm.Cache.Peek(deviceID)
+	if !tracked {
+		return
+	}
+	defer nm.lockDevice(deviceID)()
+
+	if device.HTTPHostHeaders == nil {
+		device.HTTPHostHeaders = make(map[string]int)
+	}
+	if device.HTTPHostHeaders[host] == 0 {
+		host = nm.l7Strings.intern(host)
+		nm.searchIndex.add(SearchGroupHTTPHost, "http_host_headers", host, deviceID)
+	}
+	device.HTTPHostHeaders[host]++
+	now := time.Now()
+	nm.contacts.observe(contactKey{ContactDomain, normalizeDomain(host)}, deviceID, "http", 0, 1, now)
+	nm.matchThreats(deviceID, nil, false, hostDomain(host), "http", now)
+}
@@ -0,0 +1,246 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// ErrInterfaceNotFound is returned for an interface cerberus doesn't know of
+var ErrInterfaceNotFound = errors.New("interface not found")
+
+// InterfaceCaptureControl is the optional part of a CaptureControl that
+// configures interfaces apart from each other
+type InterfaceCaptureControl interface {
+	// ApplyInterface replaces the global settings of an interface; nil
+	// restores them
+	ApplyInterface(ifindex int, config *models.InterfaceCapture) error
+	// ActiveInterface returns nil for an interface using the global settings
+	ActiveInterface(ifindex int) (*models.InterfaceCapture, error)
+}
+
+// interfaceCaptureFile is the layout of the file read by
+// LoadInterfaceCaptureFile
+type interfaceCaptureFile struct {
+	Interfaces map[string]models.InterfaceCapture `json:"interfaces"`
+}
+
+// LoadInterfaceCaptureFile reads the capture settings of interfaces by name
+// from the "interfaces" section of a JSON file
+func LoadInterfaceCaptureFile(path string) (map[string]models.InterfaceCapture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file interfaceCaptureFile
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Interfaces) > utils.InterfaceCaptureMax {
+		return nil, fmt.Errorf("%s: at most %d interfaces can have their own capture settings", path, utils.InterfaceCaptureMax)
+	}
+	for name, config := range file.Interfaces {
+		if _, err := utils.EncodeInterfaceCapture(config); err != nil {
+			return nil, fmt.Errorf("%s: interface %s: %w", path, name, err)
+		}
+	}
+	return file.Interfaces, nil
+}
+
+// SetInterfaceCaptures sets the capture settings of interfaces by name, used
+// as they are attached. Must be called before capture starts.
+func (nm *NetworkMonitor) SetInterfaceCaptures(configs map[string]models.InterfaceCapture) {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	nm.interfaceCapture = make(map[string]models.InterfaceCapture, len(configs))
+	for name, config := range configs {
+		nm.interfaceCapture[name] = config
+	}
+}
+
+// InterfaceAttached writes the capture settings configured for an interface
+// the program was just attached to. An interface without settings of its own
+// is cleared, as its ifindex may have been used by another interface while
+// the maps stayed pinned. It also reads the link speed of the interface, and
+// adds the counters the previous run persisted for it on its first attach.
+func (nm *NetworkMonitor) InterfaceAttached(ifindex int, name string) error {
+	if status, ok := nm.ifaces.Get(ifindex); ok {
+		nm.refreshLinkSpeed(status)
+	}
+	nm.restoreInterfaceCounters(ifindex, name)
+
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	control, ok := nm.capture.(InterfaceCaptureControl)
+	if !ok {
+		return nil
+	}
+	if config, ok := nm.interfaceCapture[name]; ok {
+		return control.ApplyInterface(ifindex, &config)
+	}
+	return control.ApplyInterface(ifindex, nil)
+}
+
+// InterfaceDetached clears the kernel-side capture settings of an interface
+// the program was detached from
+func (nm *NetworkMonitor) InterfaceDetached(ifindex int) error {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	if control, ok := nm.capture.(InterfaceCaptureControl); ok {
+		return control.ApplyInterface(ifindex, nil)
+	}
+	return nil
+}
+
+// SetInterfaceCapture changes the capture settings of an interface at runtime,
+// or restores the global ones if config is nil. An attached interface is
+// updated in the kernel at once; the settings also apply when it is attached
+// again. They last until restart.
+func (nm *NetworkMonitor) SetInterfaceCapture(name string, config *models.InterfaceCapture) (*models.InterfaceDetail, error) {
+	if config != nil {
+		if _, err := utils.EncodeInterfaceCapture(*config); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCaptureConfig, err)
+		}
+	}
+	status, ok := nm.interfaceByName(name)
+	if !ok {
+		return nil, ErrInterfaceNotFound
+	}
+
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	control, ok := nm.capture.(InterfaceCaptureControl)
+	if !ok {
+		return nil, ErrNoCaptureControl
+	}
+	if config != nil {
+		if _, exists := nm.interfaceCapture[name]; !exists && len(nm.interfaceCapture) >= utils.InterfaceCaptureMax {
+			return nil, fmt.Errorf("%w: at most %d interfaces can have their own capture settings", ErrInvalidCaptureConfig, utils.InterfaceCaptureMax)
+		}
+	}
+	if status.Attached {
+		if err := control.ApplyInterface(status.Index, config); err != nil {
+			return nil, err
+		}
+	}
+	if nm.interfaceCapture == nil {
+		nm.interfaceCapture = make(map[string]models.InterfaceCapture)
+	}
+	if config != nil {
+		nm.interfaceCapture[name] = *config
+	} else {
+		delete(nm.interfaceCapture, name)
+	}
+	nm.updateEnabledEvents()
+	return nm.interfaceDetail(status)
+}
+
+// InterfaceDetail returns the state of an interface with the capture settings
+// in effect on it
+func (nm *NetworkMonitor) InterfaceDetail(name string) (*models.InterfaceDetail, error) {
+	status, ok := nm.interfaceByName(name)
+	if !ok {
+		return nil, ErrInterfaceNotFound
+	}
+
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+	return nm.interfaceDetail(status)
+}
+
+// interfaceDetail reads the settings of an attached interface back from the
+// kernel; those of a detached one are what it gets once attached again. Must
+// hold nm.captureMu.
+func (nm *NetworkMonitor) interfaceDetail(status models.InterfaceStatus) (*models.InterfaceDetail, error) {
+	if nm.capture == nil {
+		return nil, ErrNoCaptureControl
+	}
+
+	detail := &models.InterfaceDetail{InterfaceStatus: status, CaptureScope: models.CaptureScopeGlobal}
+	var own *models.InterfaceCapture
+	if control, ok := nm.capture.(InterfaceCaptureControl); ok && status.Attached {
+		active, err := control.ActiveInterface(status.Index)
+		if err != nil {
+			return nil, err
+		}
+		own = active
+	} else if config, ok := nm.interfaceCapture[status.Name]; ok {
+		// Fill in the effective lengths as the kernel would report them
+		value, err := utils.EncodeInterfaceCapture(config)
+		if err != nil {
+			return nil, err
+		}
+		decoded := utils.DecodeInterfaceCapture(value)
+		own = &decoded
+	}
+	if own != nil {
+		detail.Capture, detail.CaptureScope = *own, models.CaptureScopeInterface
+		return detail, nil
+	}
+
+	global, err := nm.capture.Active()
+	if err != nil {
+		return nil, err
+	}
+	detail.Capture = models.InterfaceCapture{
+		Events:            global.Events,
+		TCPControlOnly:    global.TCPControlOnly,
+		PayloadBytes:      global.PayloadBytes,
+		EventPayloadBytes: global.EventPayloadBytes,
+		Sample:            1,
+	}
+	return detail, nil
+}
+
+// interfaceByName finds an interface of the registry by name
+func (nm *NetworkMonitor) interfaceByName(name string) (models.InterfaceStatus, bool) {
+	for _, status := range nm.ifaces.List() {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return models.InterfaceStatus{}, false
+}
+
+// updateEnabledEvents lets TrackEvent accept the event types enabled
+// globally or on any interface, since the kernel already filtered them per
+// interface. Must hold nm.captureMu.
+func (nm *NetworkMonitor) updateEnabledEvents() {
+	if nm.captureEvents == nil {
+		return // No capture configuration applied yet
+	}
+	enabled := make(map[uint8]bool)
+	for _, t := range nm.captureEvents {
+		enabled[t] = true
+	}
+	for _, config := range nm.interfaceCapture {
+		types, err := utils.ParseEventTypes(strings.Join(config.Events, ","))
+		if err != nil {
+			continue // Validated when set
+		}
+		for _, t := range types {
+			enabled[t] = true
+		}
+	}
+
+	types := make([]uint8, 0, len(enabled))
+	for t := range enabled {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	nm.SetEnabledEvents(types)
+}
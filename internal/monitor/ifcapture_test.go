@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// fakeInterfaceCapture adds the iface_capture map to fakeCapture, keyed and
+// encoded as the kernel keeps it
+type fakeInterfaceCapture struct {
+	fakeCapture
+	ifaces map[uint32]utils.InterfaceCaptureValue
+}
+
+func (c *fakeInterfaceCapture) ApplyInterface(ifindex int, config *models.InterfaceCapture) error {
+	key, err := utils.InterfaceCaptureKey(ifindex)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		delete(c.ifaces, key)
+		return nil
+	}
+	value, err := utils.EncodeInterfaceCapture(*config)
+	if err != nil {
+		return err
+	}
+	c.ifaces[key] = value
+	return nil
+}
+
+func (c *fakeInterfaceCapture) ActiveInterface(ifindex int) (*models.InterfaceCapture, error) {
+	value, ok := c.ifaces[uint32(ifindex)]
+	if !ok {
+		return nil, nil
+	}
+	config := utils.DecodeInterfaceCapture(value)
+	return &config, nil
+}
+
+// newInterfaceCaptureMonitor returns a monitor with an attached eth0 (index
+// 2), a detached eth1 (index 3) and the fake control
+func newInterfaceCaptureMonitor(t *testing.T) (*NetworkMonitor, *fakeInterfaceCapture) {
+	t.Helper()
+	nm := newTestMonitor(t, 16)
+	control := &fakeInterfaceCapture{ifaces: make(map[uint32]utils.InterfaceCaptureValue)}
+	nm.SetCaptureControl(control)
+	if _, err := nm.ApplyCaptureConfig(models.CaptureConfig{Events: []string{"arp", "tcp"}}); err != nil {
+		t.Fatal(err)
+	}
+	nm.ifaces.Update(2, func(s *models.InterfaceStatus) { s.Name, s.Attached = "eth0", true })
+	nm.ifaces.Update(3, func(s *models.InterfaceStatus) { s.Name = "eth1" })
+	return nm, control
+}
+
+// Settings changed at runtime reach the kernel at once for an attached
+// interface, and on attach for a detached one; clearing them restores the
+// global settings
+func TestSetInterfaceCapture(t *testing.T) {
+	nm, control := newInterfaceCaptureMonitor(t)
+	iot := &models.InterfaceCapture{Events: []string{"dns", "http", "tls"}, PayloadBytes: map[string]int{"HTTP": 512}}
+	syn := &models.InterfaceCapture{Events: []string{"tcp"}, TCPControlOnly: true, Sample: 4}
+
+	detail, err := nm.SetInterfaceCapture("eth0", iot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := control.ifaces[2]; !ok || detail.CaptureScope != models.CaptureScopeInterface {
+		t.Errorf("eth0 not written to the kernel: %+v", detail)
+	}
+	if detail.Capture.PayloadBytes["HTTP"] != 512 || detail.Capture.Sample != 1 {
+		t.Errorf("eth0 reports %+v", detail.Capture)
+	}
+
+	detail, err = nm.SetInterfaceCapture("eth1", syn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := control.ifaces[3]; ok {
+		t.Error("detached eth1 written to the kernel")
+	}
+	if detail.CaptureScope != models.CaptureScopeInterface || !detail.Capture.TCPControlOnly || detail.Capture.Sample != 4 {
+		t.Errorf("eth1 reports %+v", detail)
+	}
+	if err := nm.InterfaceAttached(3, "eth1"); err != nil {
+		t.Fatal(err)
+	}
+	if value := control.ifaces[3]; value.Sample != 4 || value.EventFilter[models.EVENT_TYPE_TCP] != utils.EventFilterControlOnly {
+		t.Errorf("eth1 attached with %+v", value)
+	}
+
+	// Events enabled on any interface get through TrackEvent
+	nm.mu.RLock()
+	for _, eventType := range []uint8{models.EVENT_TYPE_ARP, models.EVENT_TYPE_TCP, models.EVENT_TYPE_DNS, models.EVENT_TYPE_TLS} {
+		if !nm.enabledEvents[eventType] {
+			t.Errorf("event type %d not enabled", eventType)
+		}
+	}
+	if nm.enabledEvents[models.EVENT_TYPE_UDP] {
+		t.Error("UDP enabled though no interface captures it")
+	}
+	nm.mu.RUnlock()
+
+	detail, err = nm.SetInterfaceCapture("eth0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := control.ifaces[2]; ok || detail.CaptureScope != models.CaptureScopeGlobal {
+		t.Errorf("eth0 not cleared: %+v", detail)
+	}
+	if len(detail.Capture.Events) != 2 || detail.Capture.Sample != 1 {
+		t.Errorf("eth0 reports %+v, want the global settings", detail.Capture)
+	}
+
+	// An interface attached without settings of its own, or detached, is
+	// cleared in case its ifindex was reused
+	control.ifaces[4] = utils.InterfaceCaptureValue{Sample: 9}
+	if err := nm.InterfaceAttached(4, "wlan0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nm.InterfaceDetached(3); err != nil {
+		t.Fatal(err)
+	}
+	if len(control.ifaces) != 0 {
+		t.Errorf("entries left: %v", control.ifaces)
+	}
+}
+
+func TestSetInterfaceCaptureErrors(t *testing.T) {
+	nm, control := newInterfaceCaptureMonitor(t)
+	if _, err := nm.SetInterfaceCapture("eth9", &models.InterfaceCapture{}); !errors.Is(err, ErrInterfaceNotFound) {
+		t.Errorf("unknown interface: %v", err)
+	}
+	if _, err := nm.SetInterfaceCapture("eth0", &models.InterfaceCapture{Sample: -1}); !errors.Is(err, ErrInvalidCaptureConfig) {
+		t.Errorf("bad sample: %v", err)
+	}
+	if len(control.ifaces) != 0 {
+		t.Errorf("a rejected setting was written: %v", control.ifaces)
+	}
+
+	// The map holds settings for so many interfaces
+	for i := range utils.InterfaceCaptureMax {
+		nm.ifaces.Update(10+i, func(s *models.InterfaceStatus) { s.Name = "veth" + string(rune('A'+i)) })
+	}
+	for i := range utils.InterfaceCaptureMax {
+		if _, err := nm.SetInterfaceCapture("veth"+string(rune('A'+i)), &models.InterfaceCapture{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := nm.SetInterfaceCapture("eth0", &models.InterfaceCapture{}); !errors.Is(err, ErrInvalidCaptureConfig) {
+		t.Errorf("one interface too many: %v", err)
+	}
+	if _, err := nm.SetInterfaceCapture("vethA", &models.InterfaceCapture{Sample: 2}); err != nil {
+		t.Errorf("changing a configured interface at the limit: %v", err)
+	}
+
+	without := newTestMonitor(t, 16)
+	without.SetCaptureControl(&fakeCapture{})
+	without.ifaces.Update(2, func(s *models.InterfaceStatus) { s.Name = "eth0" })
+	if _, err := without.SetInterfaceCapture("eth0", &models.InterfaceCapture{}); !errors.Is(err, ErrNoCaptureControl) {
+		t.Errorf("without per-interface control: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// IgnoreKeyPrefix prefixes persisted ignore list entries in the database.
+const IgnoreKeyPrefix = "unwatched:"
+
+// ErrIgnoreNotFound is returned when deleting an unknown ignore list entry
+var ErrIgnoreNotFound = errors.New("ignore entry not found")
+
+// IgnoreControl is the optional part of a CaptureControl that drops the
+// traffic of the ignore list in the kernel
+type IgnoreControl interface {
+	// SyncIgnore replaces the entries in the kernel
+	SyncIgnore(entries []models.IgnoreEntry) error
+	// IgnoreCounters returns the events dropped per entry ID
+	IgnoreCounters() (map[string]uint64, error)
+}
+
+// ignoreRule is an ignore list entry compiled for TrackEvent
+type ignoreRule struct {
+	models.IgnoreEntry
+	seq       uint64 // Creation order
+	network   *net.IPNet
+	dataPlane bool
+	hits      *atomic.Uint64 // Events dropped in userspace
+}
+
+// ignoreSet is the ignore list as TrackEvent reads it. It is replaced, never
+// changed.
+type ignoreSet struct {
+	rules []*ignoreRule // Creation order
+	macs  map[string]*ignoreRule
+	cidrs []*ignoreRule
+}
+
+func newIgnoreSet(rules []*ignoreRule) *ignoreSet {
+	sort.Slice(rules, func(i, j int) bool { return rules[i].seq < rules[j].seq })
+	s := &ignoreSet{rules: rules, macs: make(map[string]*ignoreRule)}
+	for _, rule := range rules {
+		if rule.network != nil {
+			s.cidrs = append(s.cidrs, rule)
+		} else {
+			s.macs[rule.MAC] = rule
+		}
+	}
+	return s
+}
+
+// entries returns the entries in creation order
+func (s *ignoreSet) entries() []models.IgnoreEntry {
+	entries := make([]models.IgnoreEntry, len(s.rules))
+	for i, rule := range s.rules {
+		entries[i] = rule.IgnoreEntry
+	}
+	return entries
+}
+
+// ignoredEvent reports whether the ignore list drops an event, counting it
+// under the entry. The kernel already dropped most such events; this catches
+// the rest, such as replayed ones or those of BPF objects predating the list.
+func (nm *NetworkMonitor) ignoredEvent(evt *models.NetworkEvent, srcMAC string) bool {
+	set := nm.ignore.Load()
+	if set == nil || len(set.rules) == 0 {
+		return false
+	}
+
+	rule := set.macs[srcMAC]
+	if rule == nil && evt.EventType != models.EVENT_TYPE_ARP && len(set.cidrs) > 0 {
+		// As in the kernel, the destination wins over the source
+		for _, ip := range []uint32{evt.DstIP, evt.SrcIP} {
+			addr := utils.IPFromBEUint32(ip)
+			for _, cidr := range set.cidrs {
+				if cidr.network.Contains(addr) {
+					rule = cidr
+					break
+				}
+			}
+			if rule != nil {
+				break
+			}
+		}
+	}
+	controlPlane := evt.EventType == models.EVENT_TYPE_ARP || evt.EventType == models.EVENT_TYPE_DNS
+	if rule == nil || (controlPlane && rule.dataPlane) {
+		return false
+	}
+	rule.hits.Add(1)
+	return true
+}
+
+// newIgnoreRule validates an entry, normalizing its address and scope
+func newIgnoreRule(entry models.IgnoreEntry) (*ignoreRule, error) {
+	entry.MAC, entry.CIDR = strings.TrimSpace(entry.MAC), strings.TrimSpace(entry.CIDR)
+	if (entry.MAC == "") == (entry.CIDR == "") {
+		return nil, fmt.Errorf("an ignore entry needs either a mac or a cidr")
+	}
+	if entry.Scope == "" {
+		entry.Scope = models.IgnoreScopeAll
+	}
+	if _, err := utils.EncodeIgnoreScope(entry.Scope); err != nil {
+		return nil, err
+	}
+
+	rule := &ignoreRule{hits: new(atomic.Uint64)}
+	if entry.MAC != "" {
+		key, err := utils.EncodeIgnoreMAC(entry.MAC)
+		if err != nil {
+			return nil, err
+		}
+		entry.MAC = utils.MacToString(key.MAC)
+	} else {
+		if _, err := utils.EncodeIgnoreCIDR(entry.CIDR); err != nil {
+			return nil, err
+		}
+		_, rule.network, _ = net.ParseCIDR(entry.CIDR)
+		entry.CIDR = rule.network.String()
+	}
+	entry.Suppressed = 0
+	rule.IgnoreEntry = entry
+	rule.dataPlane = entry.Scope == models.IgnoreScopeDataPlane
+	rule.seq, _ = strconv.ParseUint(strings.TrimPrefix(entry.ID, "i-"), 10, 64)
+	return rule, nil
+}
+
+// loadIgnoreList reads the persisted ignore list
+func (nm *NetworkMonitor) loadIgnoreList() {
+	var rules []*ignoreRule
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", IgnoreKeyPrefix, IgnoreKeyPrefix+"~", func(key, value string) bool {
+			var entry models.IgnoreEntry
+			if json.Unmarshal([]byte(value), &entry) != nil {
+				return true
+			}
+			if rule, err := newIgnoreRule(entry); err == nil {
+				rules = append(rules, rule)
+				nm.ignoreSeq = max(nm.ignoreSeq, rule.seq)
+			}
+			return true
+		})
+	})
+	nm.ignore.Store(newIgnoreSet(rules))
+}
+
+// syncIgnore writes the ignore list to the kernel, if the capture control
+// can hold it. Must hold nm.captureMu.
+func (nm *NetworkMonitor) syncIgnore() {
+	nm.ignoreErr = ""
+	control, ok := nm.capture.(IgnoreControl)
+	if !ok {
+		return
+	}
+	if err := control.SyncIgnore(nm.ignore.Load().entries()); err != nil {
+		nm.ignoreErr = err.Error()
+	}
+}
+
+// ignoreInKernel reports whether the kernel holds the ignore list. Must hold
+// nm.captureMu.
+func (nm *NetworkMonitor) ignoreInKernel() (IgnoreControl, bool) {
+	control, ok := nm.capture.(IgnoreControl)
+	return control, ok && nm.ignoreErr == ""
+}
+
+// AddIgnore validates, persists and applies an ignore list entry, in the
+// kernel too when capturing. Its ID, creation time and counter are ignored.
+func (nm *NetworkMonitor) AddIgnore(entry models.IgnoreEntry) (models.IgnoreEntry, error) {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	seq := nm.ignoreSeq + 1
+	entry.ID = fmt.Sprintf("i-%d", seq)
+	entry.CreatedAt = time.Now()
+	rule, err := newIgnoreRule(entry)
+	if err != nil {
+		return models.IgnoreEntry{}, err
+	}
+
+	set := nm.ignore.Load()
+	if len(set.rules) >= utils.IgnoreMax {
+		return models.IgnoreEntry{}, fmt.Errorf("at most %d entries can be ignored", utils.IgnoreMax)
+	}
+	for _, existing := range set.rules {
+		if existing.MAC == rule.MAC && existing.CIDR == rule.CIDR {
+			return models.IgnoreEntry{}, fmt.Errorf("%s%s is already ignored by %s", rule.MAC, rule.CIDR, existing.ID)
+		}
+	}
+
+	data, _ := json.Marshal(rule.IgnoreEntry)
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(IgnoreKeyPrefix+rule.ID, string(data), nil)
+		return err
+	})
+	if err != nil {
+		return models.IgnoreEntry{}, fmt.Errorf("failed to persist ignore entry: %w", err)
+	}
+
+	nm.ignoreSeq = seq
+	nm.ignore.Store(newIgnoreSet(append(set.rules[:len(set.rules):len(set.rules)], rule)))
+	nm.syncIgnore()
+	return rule.IgnoreEntry, nil
+}
+
+// DeleteIgnore removes an ignore list entry, in the kernel too when capturing
+func (nm *NetworkMonitor) DeleteIgnore(id string) error {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	set := nm.ignore.Load()
+	rules := make([]*ignoreRule, 0, len(set.rules))
+	for _, rule := range set.rules {
+		if rule.ID != id {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(set.rules) {
+		return ErrIgnoreNotFound
+	}
+
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(IgnoreKeyPrefix + id)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete ignore entry: %w", err)
+	}
+
+	nm.ignore.Store(newIgnoreSet(rules))
+	nm.syncIgnore()
+	return nil
+}
+
+// IgnoreList returns the ignore list, oldest first, with the events each
+// entry dropped since startup in the kernel and in userspace
+func (nm *NetworkMonitor) IgnoreList() (models.IgnoreList, error) {
+	nm.captureMu.Lock()
+	defer nm.captureMu.Unlock()
+
+	list := models.IgnoreList{Entries: []models.IgnoreEntry{}, KernelError: nm.ignoreErr}
+	var kernel map[string]uint64
+	if control, ok := nm.ignoreInKernel(); ok {
+		counters, err := control.IgnoreCounters()
+		if err != nil {
+			return models.IgnoreList{}, err
+		}
+		kernel, list.Kernel = counters, true
+	}
+	for _, rule := range nm.ignore.Load().rules {
+		entry := rule.IgnoreEntry
+		entry.Suppressed = kernel[rule.ID] + rule.hits.Load()
+		list.Entries = append(list.Entries, entry)
+	}
+	return list, nil
+}
@@ -0,0 +1,60 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Events the kernel let through are dropped by TrackEvent as it would have:
+// by source MAC, then destination, then source address, with data plane
+// entries letting ARP and DNS through
+func TestIgnoredEvent(t *testing.T) {
+	mac := "02:00:00:00:00:0a"
+	events := map[string]func() *models.NetworkEvent{
+		"tcp": func() *models.NetworkEvent { return tcpEvent(t, mac, "192.168.1.10", "151.101.1.5", 443) },
+		"reply": func() *models.NetworkEvent {
+			return tcpEvent(t, "02:00:00:00:00:01", "151.101.1.5", "192.168.1.10", 40000)
+		},
+		"dns": func() *models.NetworkEvent {
+			evt := udpEvent(t, mac, "192.168.1.10", "192.168.1.1", 53)
+			evt.EventType = models.EVENT_TYPE_DNS
+			return evt
+		},
+		"arp": func() *models.NetworkEvent { return arpReply(t, mac, "192.168.1.10") },
+	}
+	tests := []struct {
+		name    string
+		entry   models.IgnoreEntry
+		dropped []string
+	}{
+		{"device", models.IgnoreEntry{MAC: mac}, []string{"tcp", "dns", "arp"}},
+		{"device, data plane", models.IgnoreEntry{MAC: mac, Scope: models.IgnoreScopeDataPlane}, []string{"tcp"}},
+		{"CDN", models.IgnoreEntry{CIDR: "151.101.0.0/16"}, []string{"tcp", "reply"}},
+		{"device address", models.IgnoreEntry{CIDR: "192.168.1.10/32"}, []string{"tcp", "reply", "dns"}},
+		{"device address, data plane", models.IgnoreEntry{CIDR: "192.168.1.10/32", Scope: models.IgnoreScopeDataPlane}, []string{"tcp", "reply"}},
+		{"elsewhere", models.IgnoreEntry{CIDR: "10.0.0.0/8"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newTestMonitor(t, 16)
+			entry, err := nm.AddIgnore(tt.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, event := range events {
+				filtered := nm.Stats.FilteredPackets.Load()
+				nm.TrackEvent(event())
+				dropped := nm.Stats.FilteredPackets.Load() > filtered
+				if want := slices.Contains(tt.dropped, name); dropped != want {
+					t.Errorf("%s dropped %v, want %v", name, dropped, want)
+				}
+			}
+			list, err := nm.IgnoreList()
+			if err != nil || len(list.Entries) != 1 || list.Entries[0].ID != entry.ID || list.Entries[0].Suppressed != uint64(len(tt.dropped)) {
+				t.Errorf("IgnoreList = %+v, %v, want %d suppressed", list, err, len(tt.dropped))
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// interfaceReattachGrace is how long a re-attached interface gets to produce
+// events again before it is reported silent
+const interfaceReattachGrace = time.Minute
+
+// InterfaceWatchConfig controls detection of attached interfaces that stop
+// producing events while their link is still up
+type InterfaceWatchConfig struct {
+	Silence   time.Duration           // Silence after which an interface is degraded
+	MinEvents uint64                  // Events an interface must produce before it is watched
+	Reattach  func(ifindex int) error // Tried once per silence before alerting; nil disables
+}
+
+// DefaultInterfaceWatchConfig returns the default interface watch settings
+func DefaultInterfaceWatchConfig() InterfaceWatchConfig {
+	return InterfaceWatchConfig{Silence: 10 * time.Minute, MinEvents: 100}
+}
+
+type watchedInterface struct {
+	name         string
+	attachedAt   time.Time  // Silence is measured from here until the first event
+	reattachedAt *time.Time // Set once the re-attach of this silence was tried
+}
+
+// Interfaces returns the registry of attached interfaces, which the attach
+// code keeps up to date and the API reads
+func (nm *NetworkMonitor) Interfaces() *ifaces.Registry {
+	return nm.ifaces
+}
+
+// UseInterfaces replaces the interface registry with one shared with
+// something else, such as the registry an api-only process mirrors the
+// writer's into. Must be called before capture starts and before the API
+// serves the monitor.
+func (nm *NetworkMonitor) UseInterfaces(registry *ifaces.Registry) {
+	nm.ifaces = registry
+}
+
+// WatchInterfaces starts checking the attached interfaces (ifindex -> name)
+// for silence in the background
+func (nm *NetworkMonitor) WatchInterfaces(attached map[int]string, config InterfaceWatchConfig) {
+	now := time.Now()
+
+	nm.lockAll()
+	nm.interfaces = make(map[uint32]*watchedInterface, len(attached))
+	for index, name := range attached {
+		nm.interfaces[uint32(index)] = &watchedInterface{name: name, attachedAt: now}
+	}
+	nm.unlockAll()
+
+	interval := min(max(config.Silence/4, time.Second), 30*time.Second)
+	nm.startWorker(interval, func(now time.Time) {
+		nm.checkInterfaces(config, now)
+	})
+}
+
+// checkInterfaces degrades interfaces that went silent after producing
+// traffic, re-attaching them once first when configured, and reports
+// recoveries
+func (nm *NetworkMonitor) checkInterfaces(config InterfaceWatchConfig, now time.Time) {
+	var reattach []uint32
+
+	nm.lockAll()
+	for index, iface := range nm.interfaces {
+		status, ok := nm.ifaces.Get(int(index))
+		if !ok {
+			continue
+		}
+		lastEvent := iface.attachedAt
+		if status.LastEvent != nil {
+			lastEvent = *status.LastEvent
+		}
+		silence := now.Sub(lastEvent)
+
+		if silence < config.Silence {
+			if status.DegradedSince != nil {
+				duration := now.Sub(*status.DegradedSince).Round(time.Second)
+				fmt.Printf("Interface %s is producing events again after %s\n", iface.name, duration)
+				nm.raiseAnomaly("INTERFACE_RECOVERED", models.SeverityInfo, "",
+					map[string]string{"interface": iface.name, "duration": duration.String()},
+					map[string]string{"interface": iface.name, "duration": duration.String()})
+				nm.ifaces.Update(int(index), func(s *models.InterfaceStatus) {
+					s.Degraded = false
+					s.DegradedSince = nil
+				})
+			}
+			iface.reattachedAt = nil
+			continue
+		}
+
+		// Unused interfaces never arm, and a link that is down explains the silence
+		if status.Events < config.MinEvents || status.Degraded || !status.LinkUp {
+			continue
+		}
+
+		if config.Reattach != nil && iface.reattachedAt == nil {
+			reattach = append(reattach, index)
+			continue
+		}
+		if iface.reattachedAt != nil && status.ReattachError == "" && now.Sub(*iface.reattachedAt) < interfaceReattachGrace {
+			continue
+		}
+
+		nm.ifaces.Update(int(index), func(s *models.InterfaceStatus) {
+			s.Degraded = true
+			s.DegradedSince = &now
+		})
+		details := map[string]string{
+			"interface":  iface.name,
+			"ifindex":    strconv.Itoa(int(index)),
+			"last_event": lastEvent.Format(time.RFC3339),
+			"events":     strconv.FormatUint(status.Events, 10),
+		}
+		if iface.reattachedAt != nil {
+			details["reattach"] = "succeeded"
+			if status.ReattachError != "" {
+				details["reattach"] = "failed: " + status.ReattachError
+			}
+		}
+		fmt.Printf("WARNING: interface %s has produced no events for %s while its link is up\n",
+			iface.name, silence.Round(time.Second))
+		nm.raiseAnomaly("INTERFACE_SILENT", models.SeverityMedium, "",
+			map[string]string{"interface": iface.name, "silence": silence.Round(time.Second).String()},
+			details)
+	}
+	nm.unlockAll()
+
+	// Re-attaching talks to the kernel, so it runs without holding nm.mu
+	for _, index := range reattach {
+		nm.mu.RLock()
+		name := nm.interfaces[index].name
+		nm.mu.RUnlock()
+
+		fmt.Printf("Interface %s is silent, re-attaching\n", name)
+		err := config.Reattach(int(index))
+		if err != nil {
+			fmt.Printf("Re-attaching to %s failed: %v\n", name, err)
+		} else {
+			fmt.Printf("Re-attached to %s\n", name)
+		}
+
+		nm.lockAll()
+		nm.interfaces[index].reattachedAt = &now
+		nm.unlockAll()
+		nm.ifaces.Update(int(index), func(s *models.InterfaceStatus) {
+			s.Reattaches++
+			s.ReattachError = ""
+			if err != nil {
+				s.ReattachError = err.Error()
+			}
+		})
+	}
+}
+
+// InterfaceStatuses returns the state of every interface in the registry, by
+// name
+func (nm *NetworkMonitor) InterfaceStatuses() []models.InterfaceStatus {
+	return nm.ifaces.List()
+}
+
+// interfaceName returns the name of an interface by index, resolving it once.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) interfaceName(index uint32) string {
+	if iface, ok := nm.interfaces[index]; ok {
+		return iface.name
+	}
+	name, ok := nm.ifNames[index]
+	if !ok {
+		name = utils.IfIndexToName(index)
+		nm.ifNames[index] = name
+	}
+	return name
+}
+
+// recordDeviceInterface adds the interface a device's traffic was seen on to
+// its sorted set
+func recordDeviceInterface(device *models.DeviceInfo, name string) {
+	if i, found := slices.BinarySearch(device.Interfaces, name); !found {
+		device.Interfaces = slices.Insert(device.Interfaces, i, name)
+	}
+}
+
+// mergeDeviceInterfaces adds the interfaces of src to those of dst
+func mergeDeviceInterfaces(dst, src *models.DeviceInfo) {
+	for _, name := range src.Interfaces {
+		recordDeviceInterface(dst, name)
+	}
+}
+
+// SeenOnInterface reports whether a device's traffic was seen on an
+// interface. Devices persisted before interfaces were collected only know
+// the one they were first seen on.
+func SeenOnInterface(device *models.DeviceInfo, name string) bool {
+	return device.Interface == name || slices.Contains(device.Interfaces, name)
+}
+
+// InterfaceNames returns the sorted names of the interfaces in the registry
+func (nm *NetworkMonitor) InterfaceNames() []string {
+	var names []string
+	for _, status := range nm.ifaces.List() {
+		names = append(names, status.Name)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
@@ -0,0 +1,69 @@
+package monitor
+
+import "github.com/zrougamed/cerberus/internal/models"
+
+// DefaultL7InternSize is the default number of distinct L7 strings interned
+const DefaultL7InternSize = 100000
+
+// internTable shares one copy of each distinct L7 string (DNS domain, HTTP
+// host, TLS SNI) between the device maps that use it as a key. Once full, new
+// strings are kept as they are. It is guarded by nm.mu.
+type internTable struct {
+	limit   int
+	strings map[string]string
+	stats   models.InternStats
+}
+
+func newInternTable(limit int) *internTable {
+	return &internTable{limit: limit, strings: make(map[string]string)}
+}
+
+// intern returns the shared copy of s, adding s if there is room
+func (t *internTable) intern(s string) string {
+	if shared, ok := t.strings[s]; ok {
+		t.stats.Shared++
+		t.stats.SavedBytes += uint64(len(s))
+		return shared
+	}
+	if len(t.strings) < t.limit {
+		t.strings[s] = s
+		t.stats.Entries++
+		t.stats.Bytes += uint64(len(s))
+	}
+	return s
+}
+
+// internKeys returns a count map keyed by the shared copies of its keys
+func (t *internTable) internKeys(counts map[string]int) map[string]int {
+	if len(counts) == 0 {
+		return counts
+	}
+	interned := make(map[string]int, len(counts))
+	for key, count := range counts {
+		interned[t.intern(key)] = count
+	}
+	return interned
+}
+
+// internDevice shares the L7 strings of a device loaded from the database
+func (t *internTable) internDevice(device *models.DeviceInfo) {
+	device.DNSDomains = t.internKeys(device.DNSDomains)
+	device.HTTPHosts = t.internKeys(device.HTTPHosts)
+	device.TLSSNIs = t.internKeys(device.TLSSNIs)
+	device.HTTPHostHeaders = t.internKeys(device.HTTPHostHeaders)
+}
+
+// SetL7InternSize sets how many distinct L7 strings are interned; 0 disables
+// interning. Strings interned so far stay shared.
+func (nm *NetworkMonitor) SetL7InternSize(size int) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.l7Strings.limit = size
+}
+
+// L7InternStats reports how much memory L7 string interning saves
+func (nm *NetworkMonitor) L7InternStats() models.InternStats {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	return nm.l7Strings.stats
+}
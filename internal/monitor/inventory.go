@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// InventoryEntry is a known device from an existing asset inventory (CMDB)
+type InventoryEntry struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Location string `json:"location"`
+	Critical bool   `json:"critical"` // Track availability, see AvailabilityConfig
+	Icon     string `json:"icon"`     // Icon key of its hints, one of models.Icons
+}
+
+// Inventory matches devices to known devices by MAC, or else by IP
+type Inventory struct {
+	byMAC map[string]*InventoryEntry
+	byIP  map[string]*InventoryEntry
+}
+
+// LoadInventory reads known devices from a JSON file (an array of entries) or
+// a CSV file with a header row naming the mac, ip, name, owner, location,
+// critical and icon columns; other columns are ignored. Each entry needs a MAC
+// or an IP.
+func LoadInventory(path string) (*Inventory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []InventoryEntry
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else if entries, err = readInventoryCSV(file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	inventory := &Inventory{
+		byMAC: make(map[string]*InventoryEntry),
+		byIP:  make(map[string]*InventoryEntry),
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.MAC == "" && entry.IP == "" {
+			return nil, fmt.Errorf("%s: entry %d has neither a MAC nor an IP", path, i+1)
+		}
+		if entry.Icon = strings.ToLower(entry.Icon); entry.Icon != "" && !ValidIcon(entry.Icon) {
+			return nil, fmt.Errorf("%s: entry %d: unknown icon %q", path, i+1, entry.Icon)
+		}
+		if entry.MAC != "" {
+			mac, err := net.ParseMAC(strings.TrimSpace(entry.MAC))
+			if err != nil || len(mac) != 6 {
+				return nil, fmt.Errorf("%s: entry %d: invalid MAC %q", path, i+1, entry.MAC)
+			}
+			entry.MAC = mac.String()
+			if inventory.byMAC[entry.MAC] != nil {
+				return nil, fmt.Errorf("%s: entry %d: duplicate MAC %s", path, i+1, entry.MAC)
+			}
+			inventory.byMAC[entry.MAC] = entry
+		}
+		if entry.IP != "" {
+			ip := net.ParseIP(strings.TrimSpace(entry.IP))
+			if ip == nil {
+				return nil, fmt.Errorf("%s: entry %d: invalid IP %q", path, i+1, entry.IP)
+			}
+			entry.IP = ip.String()
+			if inventory.byIP[entry.IP] != nil {
+				return nil, fmt.Errorf("%s: entry %d: duplicate IP %s", path, i+1, entry.IP)
+			}
+			inventory.byIP[entry.IP] = entry
+		}
+	}
+	return inventory, nil
+}
+
+func readInventoryCSV(r io.Reader) ([]InventoryEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	_, hasMAC := columns["mac"]
+	_, hasIP := columns["ip"]
+	if !hasMAC && !hasIP {
+		return nil, errors.New("header has neither a mac nor an ip column")
+	}
+
+	var entries []InventoryEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		critical := false
+		if v := field("critical"); v != "" {
+			if critical, err = parseInventoryBool(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid critical value %q", len(entries)+2, v)
+			}
+		}
+		entries = append(entries, InventoryEntry{
+			MAC:      field("mac"),
+			IP:       field("ip"),
+			Name:     field("name"),
+			Owner:    field("owner"),
+			Location: field("location"),
+			Critical: critical,
+			Icon:     field("icon"),
+		})
+	}
+}
+
+// parseInventoryBool parses a yes/no column
+func parseInventoryBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes", "y":
+		return true, nil
+	case "no", "n":
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+// Len returns the number of known devices
+func (inv *Inventory) Len() int {
+	seen := len(inv.byMAC)
+	for _, entry := range inv.byIP {
+		if entry.MAC == "" {
+			seen++
+		}
+	}
+	return seen
+}
+
+// match returns the known device a device is, if any. The MAC of a routed
+// device is its router's, so only its IP is matched.
+func (inv *Inventory) match(device *models.DeviceInfo) *InventoryEntry {
+	if inv == nil {
+		return nil
+	}
+	if entry := inv.byMAC[device.MAC]; entry != nil && !device.Routed {
+		return entry
+	}
+	return inv.byIP[device.IP]
+}
+
+// SetInventory replaces the known devices and enriches the cached devices
+func (nm *NetworkMonitor) SetInventory(inventory *Inventory) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.inventory = inventory
+
+	for _, id := range nm.Cache.Keys() {
+		if device, ok := nm.Cache.Peek(id); ok {
+			nm.enrichDevice(device)
+		}
+	}
+}
+
+// enrichDevice sets the name, owner, location, criticality and icon of a
+// device from the inventory, clearing them when it no longer matches. Must
+// hold nm.mu.
+func (nm *NetworkMonitor) enrichDevice(device *models.DeviceInfo) {
+	var entry InventoryEntry
+	if known := nm.inventory.match(device); known != nil {
+		entry = *known
+	}
+	device.Name = entry.Name
+	device.Owner = entry.Owner
+	device.Location = entry.Location
+	device.Critical = entry.Critical
+	device.Icon = entry.Icon
+	nm.searchIndex.indexInventory(device)
+}
+
+// deviceLabel names a device in notifications: its inventory name, or else
+// its vendor
+func deviceLabel(device *models.DeviceInfo) string {
+	if device.Name != "" {
+		return device.Name
+	}
+	return device.Vendor
+}
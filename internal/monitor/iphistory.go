@@ -0,0 +1,142 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// MaxIPHistory bounds the addresses remembered per device; the least recently
+// held one is dropped first
+const MaxIPHistory = 10
+
+// ipChangeLog bounds the IP changes kept for IPChanges
+const ipChangeLog = 1000
+
+// recordIPLease extends the lease of the current IP of a device, or starts
+// one. A device returning to an earlier address extends its old lease.
+func recordIPLease(device *models.DeviceInfo, now time.Time) {
+	if device.IP == "" || device.IP == "0.0.0.0" {
+		return
+	}
+	if n := len(device.IPHistory); n > 0 && device.IPHistory[n-1].IP == device.IP {
+		device.IPHistory[n-1].LastSeen = now
+		return
+	}
+
+	lease := models.IPLease{IP: device.IP, FirstSeen: now, LastSeen: now}
+	for i, held := range device.IPHistory {
+		if held.IP == device.IP {
+			lease.FirstSeen = held.FirstSeen
+			device.IPHistory = append(device.IPHistory[:i], device.IPHistory[i+1:]...)
+			break
+		}
+	}
+	device.IPHistory = append(device.IPHistory, lease)
+	if len(device.IPHistory) > MaxIPHistory {
+		device.IPHistory = append([]models.IPLease(nil), device.IPHistory[len(device.IPHistory)-MaxIPHistory:]...)
+	}
+}
+
+// mergeIPHistory adds the leases of src into dst
+func mergeIPHistory(dst, src *models.DeviceInfo) {
+	if len(src.IPHistory) == 0 {
+		return
+	}
+	leases := make(map[string]models.IPLease, len(dst.IPHistory)+len(src.IPHistory))
+	for _, history := range [][]models.IPLease{dst.IPHistory, src.IPHistory} {
+		for _, lease := range history {
+			if held, ok := leases[lease.IP]; ok {
+				if held.FirstSeen.Before(lease.FirstSeen) {
+					lease.FirstSeen = held.FirstSeen
+				}
+				if held.LastSeen.After(lease.LastSeen) {
+					lease.LastSeen = held.LastSeen
+				}
+			}
+			leases[lease.IP] = lease
+		}
+	}
+
+	merged := make([]models.IPLease, 0, len(leases))
+	for _, lease := range leases {
+		merged = append(merged, lease)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].LastSeen.Before(merged[j].LastSeen) })
+	if len(merged) > MaxIPHistory {
+		merged = merged[len(merged)-MaxIPHistory:]
+	}
+	dst.IPHistory = merged
+}
+
+// ipChangeOf returns the IP change a settled device change reports, or nil.
+// A device getting its first address, after an ARP probe, didn't change IP.
+func (nm *NetworkMonitor) ipChangeOf(change *models.DeviceUpdate) *models.IPChange {
+	field, ok := change.Changes[ChangeIP]
+	if !ok || field.Old == "" || field.Old == "0.0.0.0" {
+		return nil
+	}
+
+	ipChange := &models.IPChange{
+		ID:           change.ID,
+		DeviceID:     change.DeviceID,
+		DeviceUUID:   change.DeviceUUID,
+		OldIP:        field.Old,
+		NewIP:        field.New,
+		FirstChanged: change.FirstChanged,
+		Timestamp:    change.Timestamp,
+		ExpectedBy:   change.ExpectedBy,
+	}
+
+	if device, ok := nm.Cache.Peek(change.DeviceID); ok {
+		unlock := nm.lockDevice(change.DeviceID)
+		ipChange.Name = device.Name
+		unlock()
+	}
+	for _, id := range nm.devicesAt(field.Old) {
+		if id != change.DeviceID {
+			ipChange.OldIPHeldBy = id
+			break
+		}
+	}
+	return ipChange
+}
+
+// notifyIPChange logs and announces an IP change
+func (nm *NetworkMonitor) notifyIPChange(ipChange *models.IPChange) {
+	nm.changes.mu.Lock()
+	if len(nm.changes.ipChanges) >= ipChangeLog {
+		nm.changes.ipChanges = nm.changes.ipChanges[1:]
+	}
+	nm.changes.ipChanges = append(nm.changes.ipChanges, ipChange)
+	nm.changes.mu.Unlock()
+
+	// The device change line already shows the new address
+	if ipChange.ExpectedBy != "" {
+		return
+	}
+	if nm.emit(SinkDeviceIPChanged, ipChange) && ipChange.OldIPHeldBy != "" {
+		fmt.Printf("[IP REUSED] %s, left by %s, is now held by %s\n",
+			ipChange.OldIP, ipChange.DeviceID, ipChange.OldIPHeldBy)
+	}
+}
+
+// IPChanges returns the most recent IP changes since startup, newest first,
+// optionally only those of one device. limit caps the result if positive.
+func (nm *NetworkMonitor) IPChanges(deviceID string, limit int) []models.IPChange {
+	nm.changes.mu.Lock()
+	defer nm.changes.mu.Unlock()
+
+	changes := []models.IPChange{}
+	for i := len(nm.changes.ipChanges) - 1; i >= 0; i-- {
+		if limit > 0 && len(changes) >= limit {
+			break
+		}
+		if change := nm.changes.ipChanges[i]; deviceID == "" || change.DeviceID == deviceID {
+			changes = append(changes, *change)
+		}
+	}
+	return changes
+}
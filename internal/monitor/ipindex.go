@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"hash/maphash"
+	"slices"
+	"sync"
+)
+
+// ipIndexShards is how many locks share out the IP index
+const ipIndexShards = 64
+
+var ipIndexSeed = maphash.MakeSeed()
+
+// ipIndex maps each IP to the cached devices that claimed it, so that finding
+// the holder of an address takes a shard lock instead of scanning the cache
+// under nm.mu. It synchronizes itself, each shard of IPs under its own lock.
+//
+// Claims are recorded holding the device's stripe, which is taken before any
+// shard. They go stale as devices move or leave the cache: devicesAt skips
+// and drops those, and maintenance prunes the rest.
+type ipIndex [ipIndexShards]ipIndexShard
+
+type ipIndexShard struct {
+	mu  sync.Mutex
+	ids map[string][]string // IP -> device IDs, in claim order
+}
+
+// shard returns the shard of an IP
+func (x *ipIndex) shard(ip string) *ipIndexShard {
+	return &x[maphash.String(ipIndexSeed, ip)%ipIndexShards]
+}
+
+// claim records that a device holds ip. The unspecified address is no one's.
+func (x *ipIndex) claim(ip, id string) {
+	if ip == "" || ip == "0.0.0.0" {
+		return
+	}
+	s := x.shard(ip)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[string][]string)
+	}
+	if !slices.Contains(s.ids[ip], id) {
+		s.ids[ip] = append(s.ids[ip], id)
+	}
+}
+
+// forget drops the claim of a device on ip
+func (x *ipIndex) forget(ip, id string) {
+	s := x.shard(ip)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := slices.DeleteFunc(s.ids[ip], func(claimant string) bool { return claimant == id })
+	if len(ids) == 0 {
+		delete(s.ids, ip)
+	} else {
+		s.ids[ip] = ids
+	}
+}
+
+// claimants returns the devices that claimed ip, in claim order
+func (x *ipIndex) claimants(ip string) []string {
+	s := x.shard(ip)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.ids[ip])
+}
+
+// prune drops the claims holds rejects and returns how many it dropped
+func (x *ipIndex) prune(holds func(ip, id string) bool) int {
+	dropped := 0
+	for i := range x {
+		s := &x[i]
+		s.mu.Lock()
+		for ip, ids := range s.ids {
+			kept := slices.DeleteFunc(ids, func(id string) bool { return !holds(ip, id) })
+			dropped += len(ids) - len(kept)
+			if len(kept) == 0 {
+				delete(s.ids, ip)
+			} else {
+				s.ids[ip] = kept
+			}
+		}
+		s.mu.Unlock()
+	}
+	return dropped
+}
+
+// holdsIP reports whether a cached device holds ip. Must hold its stripe.
+func (nm *NetworkMonitor) holdsIP(ip, id string) bool {
+	device, ok := nm.Cache.Peek(id)
+	return ok && device.IP == ip
+}
+
+// devicesAt returns the cached devices holding ip, in the order they claimed
+// it, dropping the stale claims met. It locks each claimant's stripe in turn,
+// so it must be called without nm.mu or any stripe.
+func (nm *NetworkMonitor) devicesAt(ip string) []string {
+	var ids []string
+	for _, id := range nm.ipIndex.claimants(ip) {
+		unlock := nm.lockDevice(id)
+		if nm.holdsIP(ip, id) {
+			ids = append(ids, id)
+		} else {
+			nm.ipIndex.forget(ip, id)
+		}
+		unlock()
+	}
+	return ids
+}
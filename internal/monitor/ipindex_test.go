@@ -0,0 +1,52 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+)
+
+// Devices are found by the IP they hold now: a device moving away releases
+// its address to the next one claiming it, and claims of devices that left
+// the cache are dropped on lookup and by maintenance
+func TestDevicesAt(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	laptop, phone := "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+	check := func(when, ip string, want ...string) {
+		t.Helper()
+		if got := nm.devicesAt(ip); !slices.Equal(got, want) {
+			t.Errorf("%s: devices at %s = %v, want %v", when, ip, got, want)
+		}
+	}
+
+	nm.TrackEvent(tcpEvent(t, laptop, "192.168.1.5", "192.168.1.1", 53))
+	nm.TrackEvent(tcpEvent(t, phone, "192.168.1.6", "192.168.1.1", 53))
+	check("first seen", "192.168.1.5", laptop)
+	check("first seen", "192.168.1.6", phone)
+
+	nm.TrackEvent(tcpEvent(t, laptop, "192.168.1.7", "192.168.1.1", 53))
+	nm.TrackEvent(tcpEvent(t, phone, "192.168.1.5", "192.168.1.1", 53))
+	check("after moving", "192.168.1.5", phone)
+	check("after moving", "192.168.1.6")
+	check("after moving", "192.168.1.7", laptop)
+
+	for id, want := range map[string]string{
+		"192.168.1.7":  laptop,
+		"192.168.1.99": "ip:192.168.1.99",
+	} {
+		if got, err := nm.muteTarget(id); err != nil || got != want {
+			t.Errorf("muteTarget(%s) = %q, %v, want %q", id, got, err, want)
+		}
+	}
+
+	// A stale claim, as a device leaving the cache behind leaves
+	nm.ipIndex.claim("192.168.1.8", laptop)
+	nm.Cache.Remove(phone)
+	check("evicted", "192.168.1.5")
+	nm.lockAll()
+	dropped := nm.ipIndex.prune(nm.holdsIP)
+	nm.unlockAll()
+	if dropped != 1 || len(nm.ipIndex.claimants("192.168.1.8")) != 0 {
+		t.Errorf("maintenance dropped %d claims, want the stale one", dropped)
+	}
+	check("after maintenance", "192.168.1.7", laptop)
+}
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Maintenance triggers
+const (
+	MaintenanceOnDemand  = "on_demand"
+	MaintenanceScheduled = "scheduled"
+)
+
+// MaintenanceConfig controls housekeeping
+type MaintenanceConfig struct {
+	Interval   time.Duration // How often maintenance runs; 0 only runs it on demand
+	L7MapLimit int           // Entries kept in each per-device DNS, HTTP and TLS map; 0 keeps them all
+}
+
+// DefaultMaintenanceConfig returns the default housekeeping settings
+func DefaultMaintenanceConfig() MaintenanceConfig {
+	return MaintenanceConfig{
+		Interval:   6 * time.Hour,
+		L7MapLimit: 500,
+	}
+}
+
+// maintenanceState serializes maintenance runs and keeps the latest report
+type maintenanceState struct {
+	mu     sync.Mutex // Held for a whole run
+	config MaintenanceConfig
+	last   *models.MaintenanceReport
+}
+
+func newMaintenance(config MaintenanceConfig) *maintenanceState {
+	return &maintenanceState{config: config}
+}
+
+// StartMaintenance sets the housekeeping settings and runs maintenance every
+// config.Interval, if set
+func (nm *NetworkMonitor) StartMaintenance(config MaintenanceConfig) {
+	nm.maintenance.mu.Lock()
+	nm.maintenance.config = config
+	nm.maintenance.mu.Unlock()
+	if config.Interval <= 0 {
+		return
+	}
+
+	nm.startWorker(config.Interval, func(time.Time) {
+		report := nm.RunMaintenance(MaintenanceScheduled)
+		if len(report.Errors) > 0 {
+			fmt.Printf("Warning: maintenance finished with errors: %v\n", report.Errors)
+		}
+	})
+}
+
+// LastMaintenance returns the report of the latest maintenance run, or nil
+func (nm *NetworkMonitor) LastMaintenance() *models.MaintenanceReport {
+	nm.maintenance.mu.Lock()
+	defer nm.maintenance.mu.Unlock()
+	return nm.maintenance.last
+}
+
+// RunMaintenance forgets expired transient devices, trims per-device L7 maps,
+// prunes detector state and compacts the database, reporting what each step
+// reclaimed. A failed step doesn't stop the others. Runs are serialized.
+func (nm *NetworkMonitor) RunMaintenance(trigger string) models.MaintenanceReport {
+	nm.maintenance.mu.Lock()
+	defer nm.maintenance.mu.Unlock()
+
+	now := time.Now()
+	report := models.MaintenanceReport{StartedAt: now, Trigger: trigger}
+	fail := func(step string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", step, err))
+	}
+
+	forgotten, err := nm.forgetTransientDevices(now)
+	report.ForgottenDevices = len(forgotten)
+	if err != nil {
+		fail("forget transient devices", err)
+	}
+
+	nm.lockAll()
+	report.L7EntriesTrimmed = nm.trimL7Maps(nm.maintenance.config.L7MapLimit)
+	report.DetectorEntries = nm.pruneDetectors(now) + nm.ipIndex.prune(nm.holdsIP)
+	nm.unlockAll()
+
+	report.DBBytesBefore = fileSize(nm.dbPath)
+	if err := nm.db.Shrink(); err != nil && !errors.Is(err, buntdb.ErrShrinkInProcess) {
+		fail("compact database", err)
+	}
+	report.DBBytesAfter = fileSize(nm.dbPath)
+
+	report.DurationMs = float64(time.Since(now).Microseconds()) / 1000
+	nm.maintenance.last = &report
+	return report
+}
+
+// trimL7Maps keeps the limit most frequent entries of each per-device L7 map
+// and returns the entries dropped. Must hold nm.mu.
+func (nm *NetworkMonitor) trimL7Maps(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+
+	trimmed := 0
+	for _, id := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Peek(id)
+		if !ok {
+			continue
+		}
+		dropped := 0
+		for _, counts := range []map[string]int{device.DNSDomains, device.HTTPHosts, device.TLSSNIs, device.HTTPHostHeaders} {
+			dropped += trimCounts(counts, limit)
+		}
+		if dropped > 0 {
+			// Re-indexed so search stops finding the dropped names
+			nm.searchIndex.removeDevice(device.ID)
+			nm.searchIndex.indexDevice(device)
+			trimmed += dropped
+		}
+	}
+	return trimmed
+}
+
+// trimCounts deletes all but the limit highest counts, ties broken by key,
+// and returns how many it deleted
+func trimCounts(counts map[string]int, limit int) int {
+	if len(counts) <= limit {
+		return 0
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[limit:] {
+		delete(counts, key)
+	}
+	return len(keys) - limit
+}
+
+// pruneDetectors drops detector state that has aged out of its window and
+// returns the entries dropped. Must hold nm.mu.
+func (nm *NetworkMonitor) pruneDetectors(now time.Time) int {
+	before := len(nm.dnsTunnel.states) + len(nm.domainScores.scores) +
+		len(nm.directIP.resolutions) + len(nm.directIP.devices) + len(nm.arpRequests) +
+		len(nm.portShare.devices) + nm.contacts.size() + len(nm.threatAlerts)
+
+	nm.dnsTunnel.prune(now)
+	nm.domainScores.prune(now)
+	nm.directIP.prune(now)
+	nm.directIP.pruneDevices(now)
+	nm.portShare.prune(now)
+	nm.contacts.prune(now)
+	nm.pruneThreatAlerts(now)
+	for key, sent := range nm.arpRequests {
+		if now.Sub(sent) > arpReplyWindow {
+			delete(nm.arpRequests, key)
+		}
+	}
+
+	after := len(nm.dnsTunnel.states) + len(nm.domainScores.scores) +
+		len(nm.directIP.resolutions) + len(nm.directIP.devices) + len(nm.arpRequests) +
+		len(nm.portShare.devices) + nm.contacts.size() + len(nm.threatAlerts)
+	return before - after
+}
+
+// fileSize returns the size of a file, or 0 if it can't be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -2,309 +2,893 @@ package monitor
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/messages"
 	"github.com/zrougamed/cerberus/internal/models"
 	"github.com/zrougamed/cerberus/internal/network"
+	"github.com/zrougamed/cerberus/internal/threatintel"
 	"github.com/zrougamed/cerberus/internal/utils"
+	"github.com/zrougamed/cerberus/internal/utils/orderedset"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/tidwall/buntdb"
 )
 
+// MaxTargets bounds the destination IPs remembered per device; the least
+// recently contacted one is dropped first
+const MaxTargets = 20
+
 type NetworkMonitor struct {
-	Cache          *lru.Cache[string, *models.DeviceInfo]
-	db             *buntdb.DB
-	ouiDB          map[string]string
-	serviceDB      map[uint16]*models.ServiceInfo
-	mu             sync.RWMutex
-	newDeviceChan  chan *models.DeviceInfo
-	newPatternChan chan *models.CommunicationPattern
-	localSubnet    *net.IPNet
-	Stats          struct {
-		TotalPackets uint64
-		ArpPackets   uint64
-		TcpPackets   uint64
-		UdpPackets   uint64
-		IcmpPackets  uint64
-		DnsPackets   uint64
-		HttpPackets  uint64
-		TlsPackets   uint64
+	Cache            *lru.Cache[string, *models.DeviceInfo]
+	db               *buntdb.DB
+	ouiDB            map[string]string
+	vendors          *databases.VendorAliases
+	serviceDB        *databases.ServiceDatabase
+	mu               sync.RWMutex
+	deviceLocks      deviceLocks // Guard device fields along with nm.mu
+	ipIndex          ipIndex     // Cached devices by IP, synchronizes itself
+	newDeviceChan    chan *models.DeviceInfo
+	newPatternChan   chan *models.CommunicationPattern
+	anomalyChan      chan *models.Anomaly
+	anomalyMu        sync.Mutex
+	catalog          atomic.Pointer[messages.Catalog] // Templates of anomaly descriptions, nil for the built-in ones
+	anomalies        []*models.Anomaly
+	anomalySubs      map[chan *models.Anomaly]struct{}
+	annotations      map[string][]models.Annotation // Pattern ID -> annotations awaiting the next persist, guarded by anomalyMu
+	pendingAnomalies []pendingAnomaly               // Raised or acknowledged anomalies awaiting the next persist, guarded by anomalyMu
+	anomalyRetention time.Duration                  // How long persisted anomalies are kept (0 = forever), guarded by anomalyMu
+	acked            map[string]ackedCondition      // Anomaly condition -> latest acknowledgement, guarded by anomalyMu
+	ackWindow        time.Duration                  // How long an acknowledgement mutes its condition, guarded by anomalyMu
+	mutes            map[string]*models.DeviceMute  // Device ID -> mute, guarded by anomalyMu
+	expectations     map[string]*models.Expectation // Expectation ID -> expectation, guarded by anomalyMu
+	expectationSeq   uint64                         // Guarded by anomalyMu
+	expectationHits  bool                           // Matches recorded since the last persist, guarded by anomalyMu
+	windowPackets    map[string]int                 // Per-device packets since the last baseline sample
+	windowPatterns   map[string]int                 // Per-device new patterns since the last baseline sample
+	windowPatternIDs map[string][]string            // Per-device IDs of the first of those patterns
+	localSubnet      *net.IPNet
+	topology         *network.NetworkTopology
+	routedSubnets    []*net.IPNet   // Remote segments whose devices are keyed on IP
+	autoRouted       bool           // Treat private IPs outside all local subnets as routed
+	enabledEvents    map[uint8]bool // nil means every event type is tracked
+	riskWeights      RiskWeights
+	cacheSize        int
+	dbPath           string
+	readOnly         bool                         // The database was loaded into memory and is never written
+	interfaces       map[uint32]*watchedInterface // Watched interfaces by ifindex, nil until watched
+	ifNames          map[uint32]string            // Names of the other interfaces events came from
+	ifaces           *ifaces.Registry
+	arpRequests      map[arpRequestKey]time.Time // Unanswered ARP requests
+	l7Strings        *internTable
+	resourceMu       sync.Mutex
+	resourceUsage    models.ResourceUsage
+	defensive        *defensiveState // non-nil while resource limits forced defensive mode
+	searchIndex      *searchIndex
+	ja3Fingerprints  map[string]*ja3Entry
+	ja3Blocklist     map[string]string // JA3 hash -> description
+	fleet            *fleetDetector
+	dnsTunnel        *dnsTunnelDetector
+	domainScores     *domainScorer
+	directIP         *directIPDetector
+	egress           *egressDetector
+	novel            *novelDestinationDetector
+	utilization      *utilizationTracker
+	health           *deviceHealthTracker
+	arpMismatch      *arpMismatchDetector
+	portShare        *portShareDetector
+	contacts         *contactIndex
+	threatIntel      *threatintel.Feed
+	geoIP            *databases.GeoIPDatabase
+	threatAlerts     map[threatAlertKey]time.Time // Latest alert per device and list entry
+	groups           *groupIndex
+	inventory        *Inventory
+	changes          *deviceChanges
+	uplink           *uplinkEstimator
+	availability     *availabilityTracker
+	self             *selfHost
+	churn            *churnTracker
+	addresses        *addressIndex
+	snapshots        *snapshotTracker
+	maintenance      *maintenanceState
+	guest            GuestConfig
+	firstContact     FirstContactConfig
+	pendingPatterns  []pendingPattern // New patterns awaiting the next persist
+	seenDirty        map[string]bool  // Devices whose seen-pattern set changed since the last persist
+	patternRetention time.Duration    // How long persisted patterns are kept (0 = forever)
+	suppressions     map[string]*suppressionRule
+	suppressionSeq   uint64
+	suppressionHits  bool // Hit counters changed since the last persist
+	persistMu        sync.Mutex
+	persistence      models.PersistenceStatus
+	dbIncident       *models.DatabaseIncident // Guarded by persistMu
+	uuids            *uuidIndex
+	alerts           *alertRouter
+	anomalyFilters   *anomalyFilters
+	decisions        *decisionAudit
+	auditing         atomic.Bool // A decision audit may be running; read without nm.mu
+	captureMu        sync.Mutex  // Serializes capture config changes
+	capture          CaptureControl
+	captureEvents    []uint8                            // Event types enabled globally; guarded by captureMu
+	ignore           atomic.Pointer[ignoreSet]          // Replaced under captureMu
+	ignoreSeq        uint64                             // Guarded by captureMu
+	ignoreErr        string                             // Why the kernel doesn't hold the ignore list; guarded by captureMu
+	interfaceCapture map[string]models.InterfaceCapture // Capture settings by interface name; guarded by captureMu
+	sink             atomic.Pointer[eventSink]
+	closing          chan struct{}  // Closed by Close to stop the periodic workers
+	workers          sync.WaitGroup // Periodic workers still running
+	patternNotify    atomic.Pointer[PatternNotifyConfig]
+	patternSubs      *patternSubscribers
+	Stats            PacketStats
+}
+
+// PacketStats counts processed events. The counters are updated without
+// holding nm.mu, so readers take a Snapshot instead of locking.
+type PacketStats struct {
+	TotalPackets    atomic.Uint64
+	ArpPackets      atomic.Uint64
+	TcpPackets      atomic.Uint64
+	UdpPackets      atomic.Uint64
+	IcmpPackets     atomic.Uint64
+	DnsPackets      atomic.Uint64
+	HttpPackets     atomic.Uint64
+	TlsPackets      atomic.Uint64
+	FilteredPackets atomic.Uint64
+	InvalidEvents   atomic.Uint64 // Events dropped by utils.ValidateNetworkEvent
+	FlowSummaries   atomic.Uint64 // Flow summaries tracked, see TrackFlowSummary
+	FlowPackets     atomic.Uint64 // Packets reported by flow summaries instead of events
+	FlowBytes       atomic.Uint64
+	FailedPersists  atomic.Uint64
+	DroppedPatterns atomic.Uint64              // New patterns dropped before they could be persisted
+	SelfPackets     atomic.Uint64              // Packets of the monitoring host, left out of the other counters
+	PacketSizes     [sizeBuckets]atomic.Uint64 // Packets per sizeBucket
+}
+
+// PacketCounts is a copy of PacketStats at one point in time
+type PacketCounts struct {
+	TotalPackets    uint64
+	ArpPackets      uint64
+	TcpPackets      uint64
+	UdpPackets      uint64
+	IcmpPackets     uint64
+	DnsPackets      uint64
+	HttpPackets     uint64
+	TlsPackets      uint64
+	FilteredPackets uint64
+	InvalidEvents   uint64
+	FlowSummaries   uint64
+	FlowPackets     uint64
+	FlowBytes       uint64
+	FailedPersists  uint64
+	DroppedPatterns uint64
+	SelfPackets     uint64
+	PacketSizes     models.SizeHistogram
+}
+
+// Snapshot reads every counter. Counters are read one by one, so a snapshot
+// taken while events are processed may be off by the events in flight.
+func (s *PacketStats) Snapshot() PacketCounts {
+	counts := PacketCounts{
+		TotalPackets:    s.TotalPackets.Load(),
+		ArpPackets:      s.ArpPackets.Load(),
+		TcpPackets:      s.TcpPackets.Load(),
+		UdpPackets:      s.UdpPackets.Load(),
+		IcmpPackets:     s.IcmpPackets.Load(),
+		DnsPackets:      s.DnsPackets.Load(),
+		HttpPackets:     s.HttpPackets.Load(),
+		TlsPackets:      s.TlsPackets.Load(),
+		FilteredPackets: s.FilteredPackets.Load(),
+		InvalidEvents:   s.InvalidEvents.Load(),
+		FlowSummaries:   s.FlowSummaries.Load(),
+		FlowPackets:     s.FlowPackets.Load(),
+		FlowBytes:       s.FlowBytes.Load(),
+		FailedPersists:  s.FailedPersists.Load(),
+		DroppedPatterns: s.DroppedPatterns.Load(),
+		SelfPackets:     s.SelfPackets.Load(),
+	}
+	for bucket := range s.PacketSizes {
+		addPacketSizes(&counts.PacketSizes, bucket, s.PacketSizes[bucket].Load())
+	}
+	return counts
+}
+
+// countEvent increments the counter of an event type
+func (s *PacketStats) countEvent(eventType uint8) {
+	switch eventType {
+	case models.EVENT_TYPE_ARP:
+		s.ArpPackets.Add(1)
+	case models.EVENT_TYPE_TCP:
+		s.TcpPackets.Add(1)
+	case models.EVENT_TYPE_UDP:
+		s.UdpPackets.Add(1)
+	case models.EVENT_TYPE_ICMP:
+		s.IcmpPackets.Add(1)
+	case models.EVENT_TYPE_DNS:
+		s.DnsPackets.Add(1)
+	case models.EVENT_TYPE_HTTP:
+		s.HttpPackets.Add(1)
+	case models.EVENT_TYPE_TLS:
+		s.TlsPackets.Add(1)
 	}
 }
 
 func NewNetworkMonitor(cacheSize int, dbPath string) (*NetworkMonitor, error) {
-	cache, err := lru.New[string, *models.DeviceInfo](cacheSize)
+	db, err := buntdb.Open(dbPath)
+	if errors.Is(err, buntdb.ErrInvalid) {
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptDatabase, dbPath, err)
+	}
 	if err != nil {
 		return nil, err
 	}
+	return newNetworkMonitor(cacheSize, db, dbPath, false)
+}
 
-	db, err := buntdb.Open(dbPath)
+// NewReadOnlyNetworkMonitor serves the state persisted in a database file,
+// such as a copy taken after an incident, without ever writing to it. The
+// file is loaded into memory; nothing is persisted and the periodic workers
+// don't run, so the monitor is meant for the API only, not for capture.
+func NewReadOnlyNetworkMonitor(cacheSize int, dbPath string) (*NetworkMonitor, error) {
+	db, err := openReadOnly(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	nm, err := newNetworkMonitor(cacheSize, db, dbPath, true)
+	if err != nil {
+		return nil, err
+	}
+	// Without capture nothing else brings devices into the cache
+	nm.preloadDevices()
+	return nm, nil
+}
+
+func newNetworkMonitor(cacheSize int, db *buntdb.DB, dbPath string, readOnly bool) (*NetworkMonitor, error) {
+	cache, err := lru.New[string, *models.DeviceInfo](cacheSize)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	db.CreateIndex("mac", "*", buntdb.IndexJSON("mac"))
 	db.CreateIndex("last_seen", "*", buntdb.IndexJSON("last_seen"))
 
-	localSubnet := network.DetectLocalSubnet()
+	topology, err := network.DetectNetworkTopology()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	nm := &NetworkMonitor{
-		Cache:          cache,
-		db:             db,
-		ouiDB:          databases.LoadOUIDatabase(),
-		serviceDB:      databases.LoadServiceDatabase(),
-		newDeviceChan:  make(chan *models.DeviceInfo, 100),
-		newPatternChan: make(chan *models.CommunicationPattern, 1000),
-		localSubnet:    localSubnet,
+	serviceDB, err := databases.NewServiceDatabase(false)
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
-	go nm.persistWorker()
+	nm := &NetworkMonitor{
+		Cache:            cache,
+		db:               db,
+		ouiDB:            databases.LoadOUIDatabase(),
+		vendors:          databases.NewVendorAliases(),
+		serviceDB:        serviceDB,
+		riskWeights:      DefaultRiskWeights(),
+		cacheSize:        cacheSize,
+		dbPath:           dbPath,
+		readOnly:         readOnly,
+		searchIndex:      newSearchIndex(),
+		ja3Fingerprints:  make(map[string]*ja3Entry),
+		arpRequests:      make(map[arpRequestKey]time.Time),
+		seenDirty:        make(map[string]bool),
+		l7Strings:        newInternTable(DefaultL7InternSize),
+		patternRetention: DefaultPatternRetention,
+		anomalyRetention: DefaultAnomalyRetention,
+		ackWindow:        DefaultAckWindow,
+		fleet:            newFleetDetector(DefaultFleetConfig()),
+		dnsTunnel:        newDNSTunnelDetector(DefaultDNSTunnelConfig()),
+		domainScores:     newDomainScorer(DefaultDomainScoreConfig()),
+		directIP:         newDirectIPDetector(DefaultDirectIPConfig()),
+		egress:           newEgressDetector(),
+		novel:            newNovelDestinationDetector(DefaultNovelDestinationConfig()),
+		utilization:      newUtilizationTracker(DefaultUtilizationConfig()),
+		health:           newDeviceHealthTracker(DefaultDeviceHealthConfig()),
+		arpMismatch:      newARPMismatchDetector(DefaultARPMismatchConfig()),
+		portShare:        newPortShareDetector(DefaultPortShareConfig()),
+		contacts:         newContactIndex(),
+		uuids:            newUUIDIndex(),
+		alerts:           newAlertRouter(),
+		anomalyFilters:   newAnomalyFilters(),
+		threatAlerts:     make(map[threatAlertKey]time.Time),
+		decisions:        newDecisionAudit(),
+		ifaces:           ifaces.NewRegistry(),
+		ifNames:          make(map[uint32]string),
+		groups:           newGroupIndex(),
+		changes:          newDeviceChanges(),
+		patternSubs:      newPatternSubscribers(),
+		uplink:           newUplinkEstimator(DefaultUplinkConfig()),
+		availability:     newAvailabilityTracker(DefaultAvailabilityConfig()),
+		self:             newSelfHost(DefaultSelfConfig()),
+		churn:            newChurnTracker(DefaultChurnConfig()),
+		addresses:        newAddressIndex(DefaultFreeAddressConfig()),
+		snapshots:        newSnapshotTracker(DefaultSnapshotConfig()),
+		maintenance:      newMaintenance(DefaultMaintenanceConfig()),
+		guest:            DefaultGuestConfig(),
+		firstContact:     DefaultFirstContactConfig(),
+		persistence:      models.PersistenceStatus{Healthy: true},
+		newDeviceChan:    make(chan *models.DeviceInfo, 100),
+		newPatternChan:   make(chan *models.CommunicationPattern, 1000),
+		anomalyChan:      make(chan *models.Anomaly, 100),
+		closing:          make(chan struct{}),
+		localSubnet:      topology.PrimarySubnet,
+		topology:         topology,
+	}
+	nm.SetPatternNotifyConfig(DefaultPatternNotifyConfig())
+	nm.loadSuppressions()
+	nm.loadAlertRoutes()
+	nm.loadAnomalyFilters()
+	nm.loadIgnoreList()
+	nm.loadMutes()
+	nm.loadExpectations()
+	nm.loadVendorAliases()
+	nm.loadAnomalies()
+	nm.loadContacts()
+	nm.loadAvailability(time.Now())
+	nm.refreshSelf(time.Now())
+	nm.loadChurn(time.Now())
+	nm.loadNovelDestinations(time.Now())
+	nm.loadInterfaceCounters()
+	nm.loadAddresses()
+	nm.loadSnapshots()
+	nm.loadUUIDs()
+
+	// Without capture, they would only find every device gone quiet
+	if !readOnly {
+		nm.startWorker(30*time.Second, func(time.Time) { nm.persistDevices() })
+		nm.startWorker(transientSweepInterval, nm.transientSweep)
+		nm.startWorker(time.Second, nm.reportSettledDeviceChanges)
+		nm.startWorker(uplinkInterval, nm.uplinkTick)
+		nm.startWorker(availabilityInterval, nm.availabilityTick)
+		nm.startWorker(selfRefreshInterval, nm.refreshSelf)
+		nm.startWorker(churnInterval, nm.churnTick)
+		nm.startWorker(novelPersistInterval, nm.novelDestinationTick)
+		nm.startWorker(utilizationInterval, nm.utilizationTick)
+		nm.startWorker(healthInterval, nm.healthTick)
+	}
 	go nm.newDeviceNotifier()
 	go nm.newPatternNotifier()
+	go nm.anomalyNotifier()
 
 	return nm, nil
 }
 
+// Close stops the periodic workers, writes pending state to the database and
+// closes it. Events must no longer be tracked once Close is called.
 func (nm *NetworkMonitor) Close() error {
+	close(nm.closing)
+	nm.workers.Wait()
+
+	// Before the channels close, as a failed pass raises an anomaly
+	nm.persistDevices()
+	if !nm.readOnly {
+		nm.novelDestinationTick(time.Now())
+		nm.persistInterfaceCounters()
+	}
+
 	close(nm.newDeviceChan)
 	close(nm.newPatternChan)
+	close(nm.anomalyChan)
 	return nm.db.Close()
 }
 
-func (nm *NetworkMonitor) classifyTCPTraffic(srcIP, dstIP string, srcPort, dstPort uint16, tcpFlags uint8) models.TrafficType {
+// SetEnabledEvents restricts tracking to the given event types.
+// Events of any other type are dropped at the start of TrackEvent.
+func (nm *NetworkMonitor) SetEnabledEvents(types []uint8) {
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	nm.enabledEvents = make(map[uint8]bool, len(types))
+	for _, t := range types {
+		nm.enabledEvents[t] = true
+	}
+}
+
+// SetRoutedSubnets configures which source networks are reached through a router.
+// Devices in those networks (and, when auto is set, any private IP outside the
+// local subnets) are identified by IP instead of by the router's MAC.
+func (nm *NetworkMonitor) SetRoutedSubnets(subnets []*net.IPNet, auto bool) {
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	nm.routedSubnets = subnets
+	nm.autoRouted = auto
+}
+
+// SetTrustedNetworks declares home networks beyond the detected local subnets
+// and private ranges, such as remote offices reached over a VPN. Traffic to
+// them is not classified external. Must be called before events are tracked.
+func (nm *NetworkMonitor) SetTrustedNetworks(networks []*net.IPNet) {
+	nm.topology.TrustedNetworks = networks
+}
+
+// isRoutedIP reports whether traffic from ip arrives through a router rather
+// than from an L2-adjacent host, in which case its source MAC is the router's
+func (nm *NetworkMonitor) isRoutedIP(ip net.IP) bool {
+	if ip.IsUnspecified() {
+		return false
+	}
+	for _, subnet := range nm.routedSubnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return nm.autoRouted && nm.topology.IsPrivateIP(ip) && !nm.topology.IsLocalIP(ip)
+}
+
+// isExternalIP reports whether ip is a unicast address outside every local,
+// private and trusted range
+func (nm *NetworkMonitor) isExternalIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.Equal(net.IPv4bcast) {
+		return false
+	}
+	return nm.topology.ClassifyIP(ip) == "EXTERNAL"
+}
+
+// routedDeviceID returns the identity used for a device keyed on its IP
+func routedDeviceID(ip string) string {
+	return "ip:" + ip
+}
+
+// Devices are persisted under their ID alone: a MAC address, or a routed
+// identity. Every other record has a prefix of its own, which may sort among
+// them, so device scans stop at deviceKeyEnd and check each key with
+// isDeviceKey rather than trusting the order of the prefixes.
+const deviceKeyEnd = "ip;" // Sorts after every MAC and routed identity
+
+// isDeviceKey reports whether a database key is that of a device
+func isDeviceKey(key string) bool {
+	if ip, ok := strings.CutPrefix(key, "ip:"); ok {
+		return net.ParseIP(ip) != nil
+	}
+	if len(key) != len("00:00:00:00:00:00") {
+		return false
+	}
+	_, err := net.ParseMAC(key)
+	return err == nil
+}
+
+// ascendDevices calls iterator with each persisted device in key order, until
+// it returns false
+func ascendDevices(tx *buntdb.Tx, iterator func(key, value string) bool) error {
+	return tx.AscendRange("", "", deviceKeyEnd, func(key, value string) bool {
+		return !isDeviceKey(key) || iterator(key, value)
+	})
+}
+
+// identify returns the device ID of a sender and whether it is routed.
+// Devices behind a router all carry the router's MAC, so they are keyed on IP.
+// ARP never crosses a router, so it always belongs to the sender's MAC.
+func (nm *NetworkMonitor) identify(srcMAC string, srcIP net.IP, eventType uint8) (string, bool) {
+	if eventType != models.EVENT_TYPE_ARP && nm.isRoutedIP(srcIP) {
+		return routedDeviceID(srcIP.String()), true
+	}
+	return srcMAC, false
+}
+
+// Topology returns the network topology detected at startup
+func (nm *NetworkMonitor) Topology() *network.NetworkTopology {
+	return nm.topology
+}
+
+// StatsReport returns the packet counters with the device and L7 string
+// table totals
+func (nm *NetworkMonitor) StatsReport() models.StatsReport {
+	counts := nm.Stats.Snapshot()
+	var payloadBytes map[string]int
+	if status, err := nm.CaptureStatus(); err == nil {
+		payloadBytes = status.Config.PayloadBytes
+	}
+	return models.StatsReport{
+		TotalDevices:    nm.Cache.Len(),
+		TotalPackets:    counts.TotalPackets,
+		SelfPackets:     counts.SelfPackets,
+		ArpPackets:      counts.ArpPackets,
+		TcpPackets:      counts.TcpPackets,
+		UdpPackets:      counts.UdpPackets,
+		IcmpPackets:     counts.IcmpPackets,
+		DnsPackets:      counts.DnsPackets,
+		HttpPackets:     counts.HttpPackets,
+		TlsPackets:      counts.TlsPackets,
+		FilteredPackets: counts.FilteredPackets,
+		InvalidEvents:   counts.InvalidEvents,
+		FlowSummaries:   counts.FlowSummaries,
+		FlowPackets:     counts.FlowPackets,
+		FlowBytes:       counts.FlowBytes,
+		PayloadBytes:    payloadBytes,
+		FailedPersists:  counts.FailedPersists,
+		EnabledEvents:   nm.EnabledEventNames(),
+		Subnets:         nm.SubnetStats(),
+		L7Intern:        nm.L7InternStats(),
+		PacketSizes:     counts.PacketSizes,
+		AlertRoutes:     nm.AlertRouteStats(),
+		Interfaces:      nm.InterfaceStats(),
+	}
+}
+
+// EnabledEventNames returns the names of the event types currently tracked
+func (nm *NetworkMonitor) EnabledEventNames() []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	var names []string
+	for t := uint8(models.EVENT_TYPE_ARP); t <= models.EVENT_TYPE_TLS; t++ {
+		if nm.enabledEvents == nil || nm.enabledEvents[t] {
+			names = append(names, models.EventTypeNames[t])
+		}
+	}
+	return names
+}
+
+// The classify functions return a traffic type along with the evidence it was
+// derived from, see models.EvidencePortHeuristic
+
+func (nm *NetworkMonitor) classifyTCPTraffic(srcIP, dstIP string, srcPort, dstPort uint16, tcpFlags uint8) (models.TrafficType, string) {
 	// Check well-known services by port
 	// TODO: Expand this list to include more services
 	switch dstPort {
 	case 80:
-		return models.TrafficTCPHTTP
+		return models.TrafficTCPHTTP, models.EvidencePortHeuristic
 	case 443:
-		return models.TrafficTCPHTTPS
+		return models.TrafficTCPHTTPS, models.EvidencePortHeuristic
 	case 22:
-		return models.TrafficTCPSSH
+		return models.TrafficTCPSSH, models.EvidencePortHeuristic
 	}
 
 	// Check TCP flags
 	if tcpFlags&0x02 != 0 && tcpFlags&0x10 == 0 {
-		return models.TrafficTCPSYN
+		return models.TrafficTCPSYN, models.EvidenceFlagBased
 	} else if tcpFlags&0x02 != 0 && tcpFlags&0x10 != 0 {
-		return models.TrafficTCPSYNACK
+		return models.TrafficTCPSYNACK, models.EvidenceFlagBased
 	} else if tcpFlags&0x01 != 0 {
-		return models.TrafficTCPFIN
+		return models.TrafficTCPFIN, models.EvidenceFlagBased
 	} else if tcpFlags&0x04 != 0 {
-		return models.TrafficTCPRST
+		return models.TrafficTCPRST, models.EvidenceFlagBased
 	} else if tcpFlags&0x10 != 0 {
-		return models.TrafficTCPACK
+		return models.TrafficTCPACK, models.EvidenceFlagBased
 	}
 
-	return models.TrafficTCPCustom
+	return models.TrafficTCPCustom, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) classifyUDPTraffic(srcIP, dstIP string, srcPort, dstPort uint16) models.TrafficType {
+func (nm *NetworkMonitor) classifyUDPTraffic(srcIP, dstIP string, srcPort, dstPort uint16) (models.TrafficType, string) {
 	if dstPort == 53 || srcPort == 53 {
-		return models.TrafficUDPDNS
+		return models.TrafficUDPDNS, models.EvidencePortHeuristic
 	} else if dstPort == 67 || dstPort == 68 {
-		return models.TrafficUDPDHCP
+		return models.TrafficUDPDHCP, models.EvidencePortHeuristic
 	} else if dstPort == 123 {
-		return models.TrafficUDPNTP
+		return models.TrafficUDPNTP, models.EvidencePortHeuristic
 	} else if dstPort == 161 || dstPort == 162 {
-		return models.TrafficUDPSNMP
+		return models.TrafficUDPSNMP, models.EvidencePortHeuristic
 	}
-	return models.TrafficUDPCustom
+	return models.TrafficUDPCustom, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) classifyARPTraffic(srcIP, dstIP string, op uint16) models.TrafficType {
+func (nm *NetworkMonitor) classifyARPTraffic(srcIP, dstIP string, op uint16) (models.TrafficType, string) {
 	if srcIP == "0.0.0.0" {
-		return models.TrafficARPProbe
+		return models.TrafficARPProbe, models.EvidenceHeaderField
 	}
 	if srcIP == dstIP {
-		return models.TrafficARPAnnounce
+		return models.TrafficARPAnnounce, models.EvidenceHeaderField
 	}
 	if op == 1 {
-		return models.TrafficARPRequest
+		return models.TrafficARPRequest, models.EvidenceHeaderField
 	} else if op == 2 {
-		return models.TrafficARPReply
+		return models.TrafficARPReply, models.EvidenceHeaderField
 	}
-	return models.TrafficARPRequest
+	return models.TrafficARPRequest, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) classifyICMPTraffic(icmpType, icmpCode uint8) models.TrafficType {
+func (nm *NetworkMonitor) classifyICMPTraffic(icmpType, icmpCode uint8) (models.TrafficType, string) {
 	switch icmpType {
 	case 0:
-		return models.TrafficICMPEchoReply
+		return models.TrafficICMPEchoReply, models.EvidenceHeaderField
 	case 3:
-		return models.TrafficICMPDestUnreach
+		return models.TrafficICMPDestUnreach, models.EvidenceHeaderField
 	case 5:
-		return models.TrafficICMPRedirect
+		return models.TrafficICMPRedirect, models.EvidenceHeaderField
 	case 8:
-		return models.TrafficICMPEchoRequest
+		return models.TrafficICMPEchoRequest, models.EvidenceHeaderField
 	case 11:
-		return models.TrafficICMPTimeExceeded
+		return models.TrafficICMPTimeExceeded, models.EvidenceHeaderField
 	default:
-		return models.TrafficICMPCustom
+		return models.TrafficICMPCustom, models.EvidenceFallback
 	}
 }
 
-func (nm *NetworkMonitor) classifyDNSTraffic(payload [32]byte) models.TrafficType {
+func (nm *NetworkMonitor) classifyDNSTraffic(payload []byte) (models.TrafficType, string) {
 	// DNS queries have QR bit = 0, responses have QR bit = 1
 	// Flags are in bytes 2-3, QR is the first bit of byte 2
-	if len(payload) >= 3 {
+	if len(payload) >= 4 {
 		flags := uint16(payload[2])<<8 | uint16(payload[3])
 		if flags&0x8000 != 0 {
-			return models.TrafficDNSResponse
+			return models.TrafficDNSResponse, models.EvidenceL7Detected
 		}
+		return models.TrafficDNSQuery, models.EvidenceL7Detected
 	}
-	return models.TrafficDNSQuery
+	return models.TrafficDNSQuery, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) classifyHTTPTraffic(payload [32]byte) models.TrafficType {
+func (nm *NetworkMonitor) classifyHTTPTraffic(payload []byte) (models.TrafficType, string) {
 	str := string(payload[:])
 	if strings.HasPrefix(str, "GET ") {
-		return models.TrafficHTTPGET
+		return models.TrafficHTTPGET, models.EvidenceL7Detected
 	} else if strings.HasPrefix(str, "POST ") {
-		return models.TrafficHTTPPOST
+		return models.TrafficHTTPPOST, models.EvidenceL7Detected
 	}
-	return models.TrafficHTTPRequest
+	return models.TrafficHTTPRequest, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) classifyTLSTraffic(payload [32]byte) models.TrafficType {
+func (nm *NetworkMonitor) classifyTLSTraffic(payload []byte) (models.TrafficType, string) {
 	// TLS handshake record type 0x16, followed by version
 	if len(payload) >= 6 {
 		// Check for Client Hello (handshake type 0x01)
 		if payload[0] == 0x16 && payload[5] == 0x01 {
-			return models.TrafficTLSClientHello
+			return models.TrafficTLSClientHello, models.EvidenceL7Detected
 		}
 		// Check for Server Hello (handshake type 0x02)
 		if payload[0] == 0x16 && payload[5] == 0x02 {
-			return models.TrafficTLSServerHello
+			return models.TrafficTLSServerHello, models.EvidenceL7Detected
 		}
 	}
-	return models.TrafficTLSHandshake
-}
-
-func (nm *NetworkMonitor) getServiceName(port uint16, protocol string) string {
-	if svc, ok := nm.serviceDB[port]; ok && (svc.Protocol == protocol || svc.Protocol == "BOTH") {
-		return svc.Service
-	}
-	return fmt.Sprintf("%s/%d", protocol, port)
+	return models.TrafficTLSHandshake, models.EvidenceFallback
 }
 
-func (nm *NetworkMonitor) TrackEvent(evt *models.NetworkEvent) {
-	nm.mu.Lock()
-	defer nm.mu.Unlock()
-
-	nm.Stats.TotalPackets++
-
-	srcMAC := utils.MacToString(evt.SrcMac)
-	srcIP := utils.IntToIP(evt.SrcIP).String()
-	dstIP := utils.IntToIP(evt.DstIP).String()
-
-	var trafficType models.TrafficType
-	var service string
-	var protocol string
-	var l7Info string
-
+// classifyEvent derives the traffic type and the evidence behind it, protocol,
+// service and the class of its name, and L7 detail of an event. It only reads
+// immutable state, so it needs no lock.
+func (nm *NetworkMonitor) classifyEvent(evt *models.NetworkEvent, srcIP, dstIP string) (trafficType models.TrafficType, evidence, protocol, service, serviceClass, l7Info string) {
 	switch evt.EventType {
 	case models.EVENT_TYPE_ARP:
-		nm.Stats.ArpPackets++
-		trafficType = nm.classifyARPTraffic(srcIP, dstIP, evt.ArpOp)
+		trafficType, evidence = nm.classifyARPTraffic(srcIP, dstIP, evt.ArpOp)
 		protocol = "ARP"
-		service = string(trafficType)
+		service, serviceClass = string(trafficType), models.ServiceClassProtocol
 
 	case models.EVENT_TYPE_TCP:
-		nm.Stats.TcpPackets++
-		trafficType = nm.classifyTCPTraffic(srcIP, dstIP, evt.SrcPort, evt.DstPort, evt.TCPFlags)
+		trafficType, evidence = nm.classifyTCPTraffic(srcIP, dstIP, evt.SrcPort, evt.DstPort, evt.TCPFlags)
 		protocol = "TCP"
-		service = nm.getServiceName(evt.DstPort, "TCP")
+		service, serviceClass = nm.getServiceName(evt.DstPort, protocol, evidence)
 		l7Info = utils.GetL7Info(evt)
 
 	case models.EVENT_TYPE_UDP:
-		nm.Stats.UdpPackets++
-		trafficType = nm.classifyUDPTraffic(srcIP, dstIP, evt.SrcPort, evt.DstPort)
+		trafficType, evidence = nm.classifyUDPTraffic(srcIP, dstIP, evt.SrcPort, evt.DstPort)
 		protocol = "UDP"
-		service = nm.getServiceName(evt.DstPort, "UDP")
+		service, serviceClass = nm.getServiceName(evt.DstPort, protocol, evidence)
 		l7Info = utils.GetL7Info(evt)
 
 	case models.EVENT_TYPE_ICMP:
-		nm.Stats.IcmpPackets++
-		trafficType = nm.classifyICMPTraffic(evt.ICMPType, evt.ICMPCode)
+		trafficType, evidence = nm.classifyICMPTraffic(evt.ICMPType, evt.ICMPCode)
 		protocol = "ICMP"
-		service = string(trafficType)
+		service, serviceClass = string(trafficType), models.ServiceClassProtocol
 
 	case models.EVENT_TYPE_DNS:
-		nm.Stats.DnsPackets++
-		trafficType = nm.classifyDNSTraffic(evt.L7Payload)
+		trafficType, evidence = nm.classifyDNSTraffic(evt.L7Payload)
 		protocol = "DNS"
-		service = "DNS"
+		service, serviceClass = nm.getServiceName(evt.DstPort, protocol, evidence)
 		l7Info = utils.GetL7Info(evt)
 
 	case models.EVENT_TYPE_HTTP:
-		nm.Stats.HttpPackets++
-		trafficType = nm.classifyHTTPTraffic(evt.L7Payload)
+		trafficType, evidence = nm.classifyHTTPTraffic(evt.L7Payload)
 		protocol = "HTTP"
-		service = "HTTP"
+		service, serviceClass = nm.getServiceName(evt.DstPort, protocol, evidence)
 		l7Info = utils.GetL7Info(evt)
 
 	case models.EVENT_TYPE_TLS:
-		nm.Stats.TlsPackets++
-		trafficType = nm.classifyTLSTraffic(evt.L7Payload)
+		trafficType, evidence = nm.classifyTLSTraffic(evt.L7Payload)
 		protocol = "TLS"
-		service = "TLS"
+		service, serviceClass = nm.getServiceName(evt.DstPort, protocol, evidence)
 		l7Info = utils.GetL7Info(evt)
 	}
+	return trafficType, evidence, protocol, service, serviceClass, l7Info
+}
+
+// loadDevice reads a persisted device, or returns nil. It doesn't need nm.mu.
+func (nm *NetworkMonitor) loadDevice(id string) *models.DeviceInfo {
+	var device *models.DeviceInfo
+	nm.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(id)
+		if err == nil {
+			json.Unmarshal([]byte(val), &device)
+		}
+		return nil
+	})
+	if device != nil {
+		device.Targets.SetLimit(MaxTargets)
+	}
+	return device
+}
+
+// preloadDevices brings the most recently seen persisted devices into the
+// cache, as many as it holds, and indexes them for search
+func (nm *NetworkMonitor) preloadDevices() {
+	var devices []*models.DeviceInfo
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.Descend("last_seen", func(key, value string) bool {
+			if !isDeviceKey(key) {
+				return true
+			}
+			var device *models.DeviceInfo
+			if json.Unmarshal([]byte(value), &device) == nil && device != nil && device.ID != "" {
+				device.Targets.SetLimit(MaxTargets)
+				devices = append(devices, device)
+			}
+			return len(devices) < nm.cacheSize
+		})
+	})
+
+	nm.lockAll()
+	defer nm.unlockAll()
+	// Oldest first, so the most recent are the last to be evicted
+	for i := len(devices) - 1; i >= 0; i-- {
+		device := devices[i]
+		nm.l7Strings.internDevice(device)
+		nm.Cache.Add(device.ID, device)
+		nm.ipIndex.claim(device.IP, device.ID)
+		nm.searchIndex.indexDevice(device)
+	}
+}
+
+// getServiceName names the service of an event and returns the class of the
+// name. DNS, HTTP and TLS events are named after their protocol: as detected
+// when their payload was recognized, else as a guess from the port the kernel
+// picked them by. TCP and UDP events are looked up by destination port, and a
+// port no database names keeps its number.
+func (nm *NetworkMonitor) getServiceName(port uint16, protocol, evidence string) (string, string) {
+	switch protocol {
+	case "DNS", "HTTP", "TLS":
+		if evidence == models.EvidenceL7Detected {
+			return protocol, models.ServiceClassL7
+		}
+		return protocol, models.ServiceClassPort
+	}
+	return nm.lookupService(port, protocol)
+}
+
+func (nm *NetworkMonitor) TrackEvent(evt *models.NetworkEvent) {
+	// Corrupt or misaligned records would add nonsense devices and patterns
+	if utils.ValidateNetworkEvent(evt) != nil {
+		nm.Stats.InvalidEvents.Add(1)
+		return
+	}
+
+	// Decoding and classifying only read the event, so they run before
+	// taking nm.mu
+	srcMAC := utils.MacToString(evt.SrcMac)
+	if nm.ignoredEvent(evt, srcMAC) {
+		nm.Stats.FilteredPackets.Add(1)
+		return
+	}
+	srcIP := utils.IPFromBEUint32(evt.SrcIP).String()
+	dstIP := utils.IPFromBEUint32(evt.DstIP).String()
+	trafficType, evidence, protocol, service, serviceClass, l7Info := nm.classifyEvent(evt, srcIP, dstIP)
+	seenKey := protocol + ":" + srcIP + "->" + dstIP + ":" + strconv.Itoa(int(evt.DstPort)) + ":" + string(trafficType)
+
+	nm.ifaces.RecordEvent(int(evt.IfIndex), 1, uint64(evt.PacketLen), time.Now())
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	if nm.enabledEvents != nil && !nm.enabledEvents[evt.EventType] {
+		nm.Stats.FilteredPackets.Add(1)
+		return
+	}
+
+	deviceID, routed := nm.identify(srcMAC, utils.IPFromBEUint32(evt.SrcIP), evt.EventType)
+
+	// The monitoring host's own traffic doesn't count towards the network's
+	self := nm.self.observe(deviceID, srcMAC, srcIP)
+	excluded := self && nm.self.excludes(deviceID)
+	if self {
+		nm.Stats.SelfPackets.Add(1)
+	} else {
+		nm.Stats.TotalPackets.Add(1)
+		nm.Stats.countEvent(evt.EventType)
+		if evt.PacketLen > 0 {
+			nm.Stats.PacketSizes[sizeBucket(evt.PacketLen)].Add(1)
+		}
+	}
+
+	if nm.windowPackets != nil {
+		nm.windowPackets[deviceID]++
+	}
 
 	// Get or create device
-	device, found := nm.Cache.Get(srcMAC)
+	device, found := nm.Cache.Get(deviceID)
 	isNew := !found
 
 	if !found {
-		var dbDevice *models.DeviceInfo
-		nm.db.View(func(tx *buntdb.Tx) error {
-			val, err := tx.Get(srcMAC)
-			if err == nil {
-				json.Unmarshal([]byte(val), &dbDevice)
-				device = dbDevice
-				isNew = false
-			}
-			return nil
-		})
+		// Reading the database can be slow, so nm.mu is released meanwhile
+		nm.mu.Unlock()
+		dbDevice := nm.loadDevice(deviceID)
+		nm.mu.Lock()
+
+		// Another event may have brought the device into the cache meanwhile
+		if device, found = nm.Cache.Get(deviceID); !found && dbDevice != nil {
+			nm.l7Strings.internDevice(dbDevice)
+			device = dbDevice
+		}
+		isNew = !found && dbDevice == nil
 	}
 
+	// Readers copy the device holding only its stripe, see deviceLocks
+	defer nm.lockDevice(deviceID)()
+
 	if device == nil {
-		vendor := nm.lookupVendor(srcMAC)
+		// The MAC of a routed device is the router's, so its vendor says nothing
+		vendor, rawVendor := "Routed", ""
+		if !routed {
+			rawVendor = nm.lookupRawVendor(srcMAC)
+			vendor = nm.vendors.Canonical(rawVendor)
+		}
 		device = &models.DeviceInfo{
+			ID:                deviceID,
 			MAC:               srcMAC,
 			IP:                srcIP,
+			Routed:            routed,
 			Vendor:            vendor,
+			RawVendor:         rawVendor,
 			Interface:         utils.IfIndexToName(evt.IfIndex),
 			FirstSeen:         time.Now(),
 			LastSeen:          time.Now(),
-			Targets:           []string{},
-			Services:          make(map[string]int),
+			Targets:           orderedset.New[string](MaxTargets),
 			DNSDomains:        make(map[string]int),
 			HTTPHosts:         make(map[string]int),
 			TLSSNIs:           make(map[string]int),
 			SeenPatterns:      make(map[string]bool),
 			TrafficTypeCounts: make(map[models.TrafficType]int),
+			EvidenceCounts:    make(map[string]int),
 			FlowStats:         make(map[string]*models.FlowStats),
 		}
-		isNew = true
+		device.Firsts = &models.DeviceFirsts{Since: device.FirstSeen}
 	}
 
-	// Initialize maps if nil
-	if device.SeenPatterns == nil {
-		device.SeenPatterns = make(map[string]bool)
+	// Records persisted before IP-keyed identities existed are MAC-keyed
+	if device.ID == "" {
+		device.ID = device.MAC
 	}
+	if !found {
+		nm.uuids.ensure(device)
+	}
+
+	// Reloaded devices take up alias changes without reporting a change
+	if !found && !isNew {
+		nm.canonicalizeVendor(device)
+	}
+
+	// Meaningful changes to known devices are reported, see recordDeviceChanges
+	var before deviceState
+	if !isNew {
+		before = snapshotDevice(device)
+	}
+	// The vendor database may know the MAC of a reloaded device by now
+	if !found && !isNew && device.RawVendor == "Unknown" && !device.Routed {
+		device.RawVendor = nm.lookupRawVendor(srcMAC)
+		device.Vendor = nm.vendors.Canonical(device.RawVendor)
+	}
+
+	// Initialize maps if nil. SeenPatterns is loaded when first needed, see
+	// markSeen.
 	if device.TrafficTypeCounts == nil {
 		device.TrafficTypeCounts = make(map[models.TrafficType]int)
 	}
-	if device.Services == nil {
-		device.Services = make(map[string]int)
+	if device.EvidenceCounts == nil {
+		device.EvidenceCounts = make(map[string]int)
 	}
 	if device.FlowStats == nil {
 		device.FlowStats = make(map[string]*models.FlowStats)
@@ -321,28 +905,165 @@ func (nm *NetworkMonitor) TrackEvent(evt *models.NetworkEvent) {
 
 	// Update device info
 	device.LastSeen = time.Now()
-	if device.IP != srcIP && srcIP != "0.0.0.0" {
+	recordActivity(device, device.LastSeen)
+	recordPacketSize(device, evt.PacketLen)
+	ipChanged := device.IP != srcIP && srcIP != "0.0.0.0"
+	if ipChanged {
+		nm.ipIndex.forget(device.IP, deviceID)
 		device.IP = srcIP
 	}
+	recordIPLease(device, device.LastSeen)
+	nm.observeAddress(srcIP, device.LastSeen)
+	// An ARP probe claims its target before the address is used
+	if evt.EventType == models.EVENT_TYPE_ARP && srcIP == "0.0.0.0" {
+		nm.observeAddress(dstIP, device.LastSeen)
+	}
+	// Reloaded devices are re-evaluated too, the subnets and the inventory
+	// may have changed
+	if ipChanged || !found {
+		nm.ipIndex.claim(device.IP, deviceID)
+		device.Subnet = nm.subnetOf(device.IP)
+		nm.enrichDevice(device)
+	}
+
+	device.Self = self
+	iface := nm.interfaceName(evt.IfIndex)
+	recordDeviceInterface(device, iface)
+
+	// Devices first seen on a guest network stay transient until they show up
+	// on another one
+	onGuest := nm.onGuestNetwork(evt.IfIndex, device.IP)
+	promoted := false
+	if isNew {
+		device.Transient = onGuest
+	} else if device.Transient && !onGuest {
+		device.Transient = false
+		promoted = true
+	}
+
+	// A routed device that now shows up on the local segment is the same host
+	if !routed && srcIP != "0.0.0.0" && (isNew || ipChanged) {
+		nm.absorbRoutedDevice(device, srcIP)
+	}
+	nm.observePresence(device, device.LastSeen)
+	nm.observeChurn(deviceID, isNew, device.LastSeen)
+
+	// Devices entering the cache (new or reloaded) are indexed in full,
+	// afterwards only newly observed values are added
+	if !found {
+		nm.searchIndex.indexDevice(device)
+	} else if ipChanged {
+		nm.searchIndex.add(SearchGroupDevice, "ip", srcIP, deviceID)
+	}
+	nm.groups.observe(device, 1, uint64(evt.PacketLen), dstIP, nm.isExternalIP(utils.IPFromBEUint32(evt.DstIP)), device.LastSeen)
+	nm.observeUtilization(evt.IfIndex, deviceID, uint64(evt.PacketLen))
+	switch evt.EventType {
+	case models.EVENT_TYPE_TCP, models.EVENT_TYPE_HTTP, models.EVENT_TYPE_TLS:
+		nm.observeHealth(deviceID, iface, 1, true, evt.TCPFlags&tcpFlagRST != 0)
+	default:
+		nm.observeHealth(deviceID, iface, 1, false, false)
+	}
+	nm.observePorts(device, evt, device.LastSeen)
+	switch evt.EventType {
+	case models.EVENT_TYPE_TCP, models.EVENT_TYPE_HTTP, models.EVENT_TYPE_TLS:
+		nm.recordFlow(device, flowKey(srcIP, evt.SrcPort, dstIP, evt.DstPort), 1, uint64(evt.PacketLen), device.LastSeen)
+	}
 
 	device.TrafficTypeCounts[trafficType]++
-	device.Services[service]++
+	if evidence != "" {
+		device.EvidenceCounts[evidence]++
+	}
+	countService(&device.Services, serviceClass, service)
 
 	// Track L7 information
 	if l7Info != "" {
 		switch evt.EventType {
 		case models.EVENT_TYPE_DNS:
+			if device.DNSDomains[l7Info] == 0 {
+				l7Info = nm.l7Strings.intern(l7Info)
+				nm.searchIndex.add(SearchGroupDNSDomain, "dns_domains", l7Info, deviceID)
+			}
 			device.DNSDomains[l7Info]++
 			device.DNSQueries++
 		case models.EVENT_TYPE_HTTP:
+			if device.HTTPHosts[l7Info] == 0 {
+				l7Info = nm.l7Strings.intern(l7Info)
+				nm.searchIndex.add(SearchGroupHTTPHost, "http_hosts", l7Info, deviceID)
+			}
 			device.HTTPHosts[l7Info]++
 			device.HTTPRequests++
 		case models.EVENT_TYPE_TLS:
+			if device.TLSSNIs[l7Info] == 0 {
+				l7Info = nm.l7Strings.intern(l7Info)
+				nm.searchIndex.add(SearchGroupTLSSNI, "tls_snis", l7Info, deviceID)
+			}
 			device.TLSSNIs[l7Info]++
 			device.TLSConnections++
 		}
 	}
 
+	// ARP names addresses without reaching them, everything else is a contact
+	if evt.EventType != models.EVENT_TYPE_ARP {
+		var domain, via string
+		switch evt.EventType {
+		case models.EVENT_TYPE_DNS:
+			domain, via = l7Info, "dns"
+		case models.EVENT_TYPE_TLS:
+			domain, via = l7Info, "tls"
+		}
+		nm.contacts.observeTraffic(deviceID, dstIP, evt.DstPort, protocol, domain, via, device.LastSeen)
+	}
+
+	// Every event is matched against the threat lists; a new pattern carries
+	// the matches of the event that created it
+	var threats []models.Annotation
+	threatVerdict := skipped("ARP is not matched")
+	if evt.EventType != models.EVENT_TYPE_ARP {
+		var domain, via string
+		switch evt.EventType {
+		case models.EVENT_TYPE_DNS:
+			domain, via = l7Info, "dns"
+		case models.EVENT_TYPE_TLS:
+			domain, via = l7Info, "tls"
+		}
+		dst := utils.IPFromBEUint32(evt.DstIP)
+		threats, threatVerdict = nm.matchThreats(deviceID, dst, nm.isExternalIP(dst), domain, via, device.LastSeen)
+	}
+
+	// Queried domains are fleet destinations wherever they resolve to
+	if evt.EventType == models.EVENT_TYPE_DNS && l7Info != "" && !excluded {
+		nm.observeFleet(device, l7Info, "", device.LastSeen)
+	}
+
+	if evt.EventType == models.EVENT_TYPE_TLS {
+		if version := utils.TLSClientVersion(evt.L7Payload); version != 0 && version < 0x0303 {
+			device.DeprecatedTLS++
+		}
+	}
+
+	observePortBehavior(device, evt, device.LastSeen)
+	observeOS(device, evt)
+	previousType := DeviceType(device)
+	observeDeviceType(device, evt, nm.isExternalIP(utils.IPFromBEUint32(evt.DstIP)))
+	if deviceType := DeviceType(device); deviceType != previousType {
+		nm.searchIndex.add(SearchGroupDevice, "device_type", deviceType, deviceID)
+	}
+
+	// Handshakes with external destinations feed the uplink health estimate
+	if evt.EventType == models.EVENT_TYPE_TCP {
+		nm.observeUplinkTCP(evt, device.ID, device.LastSeen)
+	}
+
+	// Relayed DHCP messages name the switch port of their client, which is
+	// usually not the sender
+	if !excluded {
+		nm.observeDHCPRelay(evt, deviceID, device.LastSeen)
+	}
+
+	// An ARP sender address other than the Ethernet source may be spoofed
+	arpSender := nm.arpSenderMismatch(evt, srcMAC)
+	arpPatternID := ""
+
 	// Track connections
 	switch evt.EventType {
 	case models.EVENT_TYPE_TCP, models.EVENT_TYPE_HTTP, models.EVENT_TYPE_TLS:
@@ -357,180 +1078,671 @@ func (nm *NetworkMonitor) TrackEvent(evt *models.NetworkEvent) {
 		} else {
 			device.ReplyCount++
 		}
+		nm.trackARPLatency(device, srcIP, dstIP, trafficType, device.LastSeen)
 	}
 
 	// Track targets
-	if dstIP != "0.0.0.0" && !utils.Contains(device.Targets, dstIP) {
-		device.Targets = append(device.Targets, dstIP)
-		if len(device.Targets) > 20 {
-			device.Targets = device.Targets[1:]
-		}
+	if dstIP != "0.0.0.0" {
+		device.Targets.Add(dstIP)
 	}
 
 	// Check for new communication pattern
-	patternKey := fmt.Sprintf("%s:%s->%s:%d:%s", protocol, srcIP, dstIP, evt.DstPort, trafficType)
-	if !device.SeenPatterns[patternKey] {
-		device.SeenPatterns[patternKey] = true
+	if nm.markSeen(device, seenKey) {
+		var domain string
+		if evt.EventType == models.EVENT_TYPE_DNS {
+			domain = l7Info
+		}
+		suppressed := nm.suppress(deviceID, utils.IPFromBEUint32(evt.DstIP), evt.DstPort, protocol, domain, device.LastSeen)
+		exempt := suppressed != nil && suppressed.Mode == models.SuppressExempt
+
+		if nm.windowPatterns != nil && !exempt {
+			nm.windowPatterns[deviceID]++
+		}
+
+		if dstIP != "0.0.0.0" {
+			nm.searchIndex.add(SearchGroupDestination, "targets", dstIP, deviceID)
+		}
+
+		// Risk signals are counted once per unique pattern
+		external := nm.isExternalIP(utils.IPFromBEUint32(evt.DstIP))
+		if !exempt {
+			if nm.serviceDB.IsDangerous(evt.DstPort) {
+				device.ThreatPortAccess++
+			}
+			if external {
+				device.ExternalPatterns++
+				if evt.DstPort == 443 && dohResolvers[dstIP] {
+					device.DoHConnections++
+				}
+			}
+			if trafficType == models.TrafficARPRequest || trafficType == models.TrafficTCPSYN {
+				device.ScanPatterns++
+			}
+		}
 
 		// Get interface name from index
 		ifName := utils.IfIndexToName(evt.IfIndex)
 
 		pattern := &models.CommunicationPattern{
+			DeviceID:    deviceID,
+			DeviceUUID:  device.UUID,
 			SrcMAC:      srcMAC,
 			SrcIP:       srcIP,
 			DstIP:       dstIP,
 			DstPort:     evt.DstPort,
 			Protocol:    protocol,
 			TrafficType: trafficType,
+			Evidence:    evidence,
 			Service:     service,
 			Timestamp:   time.Now(),
 			L7Info:      l7Info,
 			Interface:   ifName,
 		}
+		if self {
+			pattern.Origin = models.OriginSelf
+		}
+		// Suppressed patterns are never persisted, so they can't be referenced
+		if suppressed == nil {
+			pattern.ID = patternKey(pattern.Timestamp)
+		}
 
-		select {
-		case nm.newPatternChan <- pattern:
-		default:
+		if nm.windowPatternIDs != nil && pattern.ID != "" && len(nm.windowPatternIDs[deviceID]) < maxAnomalyPatterns {
+			nm.windowPatternIDs[deviceID] = append(nm.windowPatternIDs[deviceID], pattern.ID)
+		}
+
+		for _, annotation := range threats {
+			pattern.Annotations, _ = addAnnotation(pattern.Annotations, annotation)
+		}
+
+		nm.detectPattern(&patternCheck{
+			device:   device,
+			pattern:  pattern,
+			external: external,
+			exempt:   exempt,
+			excluded: excluded,
+			threats:  threatVerdict,
+			now:      device.LastSeen,
+		}, suppressed)
+
+		if arpSender != "" {
+			pattern.Annotations, _ = addAnnotation(pattern.Annotations,
+				models.Annotation{Type: models.AnnotationARPMismatch, ID: arpSender})
+			arpPatternID = pattern.ID
+		}
+
+		if suppressed == nil {
+			nm.queuePattern(pattern)
+
+			if !excluded {
+				select {
+				case nm.newPatternChan <- pattern:
+				default:
+				}
+			}
 		}
 	}
 
+	if arpSender != "" {
+		nm.observeARPMismatch(device, srcMAC, arpSender, srcIP, arpPatternID, device.LastSeen)
+	}
+
+	if !isNew {
+		nm.recordDeviceChanges(deviceID, before.diff(snapshotDevice(device)), device.LastSeen)
+	}
+
 	// Update cache
-	nm.Cache.Add(srcMAC, device)
+	nm.Cache.Add(deviceID, device)
 
-	// Notify if new device
+	// Notify if new device. Transient devices are announced once promoted,
+	// unless configured to be announced like the others.
 	// TODO: add to syslog or alerting system
-	if isNew {
+	if isNew && (!device.Transient || nm.guest.Notify) || promoted && !nm.guest.Notify {
+		nm.expectNewDevice(device, device.LastSeen)
 		select {
-		case nm.newDeviceChan <- device:
+		// Copied, the notifier reads it while later events update the device
+		case nm.newDeviceChan <- cloneDevice(device):
 		default:
 		}
 	}
 }
 
-func (nm *NetworkMonitor) persistWorker() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+// absorbRoutedDevice folds an IP-keyed routed record into a local device that
+// now owns the same IP, so the host's history isn't split across two identities
+func (nm *NetworkMonitor) absorbRoutedDevice(device *models.DeviceInfo, ip string) {
+	routedID := routedDeviceID(ip)
+	defer nm.lockOtherDevice(device.ID, routedID)()
 
-	for range ticker.C {
-		nm.mu.RLock()
-		keys := nm.Cache.Keys()
-		nm.mu.RUnlock()
-
-		nm.db.Update(func(tx *buntdb.Tx) error {
-			for _, mac := range keys {
-				if device, ok := nm.Cache.Get(mac); ok {
-					data, _ := json.Marshal(device)
-					tx.Set(mac, string(data), nil)
-				}
+	routed, found := nm.Cache.Get(routedID)
+	if !found {
+		nm.db.View(func(tx *buntdb.Tx) error {
+			val, err := tx.Get(routedID)
+			if err == nil {
+				json.Unmarshal([]byte(val), &routed)
 			}
 			return nil
 		})
 	}
+	if routed == nil {
+		return
+	}
+
+	nm.uuids.absorb(device, routed)
+	nm.seenPatterns(device)
+	nm.seenPatterns(routed)
+	mergeDevices(device, routed)
+	nm.seenDirty[device.ID] = true
+	delete(nm.seenDirty, routedID)
+	nm.renameJA3Device(routedID, device.ID)
+	nm.searchIndex.removeDevice(routedID)
+	nm.searchIndex.indexDevice(device)
+	nm.groups.removeDevice(routedID)
+	delete(nm.portShare.devices, routedID)
+	nm.contacts.renameDevice(routedID, device.ID)
+	nm.availability.renameDevice(routedID, device.ID)
+	nm.health.renameDevice(routedID, device.ID)
+
+	nm.Cache.Remove(routedID)
+	nm.ipIndex.forget(routed.IP, routedID)
+	nm.db.Update(func(tx *buntdb.Tx) error {
+		tx.Delete(routedID)
+		return deleteSeenPatterns(tx, routedID)
+	})
+}
+
+// mergeDevices adds the counters and observations of src into dst
+func mergeDevices(dst, src *models.DeviceInfo) {
+	if src.FirstSeen.Before(dst.FirstSeen) {
+		dst.FirstSeen = src.FirstSeen
+	}
+	if src.LastSeen.After(dst.LastSeen) {
+		dst.LastSeen = src.LastSeen
+	}
+
+	dst.RequestCount += src.RequestCount
+	dst.ReplyCount += src.ReplyCount
+	dst.TCPConnections += src.TCPConnections
+	dst.UDPConnections += src.UDPConnections
+	dst.ICMPPackets += src.ICMPPackets
+	dst.DNSQueries += src.DNSQueries
+	dst.HTTPRequests += src.HTTPRequests
+	dst.TLSConnections += src.TLSConnections
+	dst.ThreatPortAccess += src.ThreatPortAccess
+	dst.ExternalPatterns += src.ExternalPatterns
+	dst.ScanPatterns += src.ScanPatterns
+	dst.DeprecatedTLS += src.DeprecatedTLS
+	dst.DoHConnections += src.DoHConnections
+
+	for _, target := range src.Targets.Values() {
+		if !dst.Targets.Contains(target) {
+			dst.Targets.Add(target)
+		}
+	}
+
+	mergeServices(&dst.Services, src.Services)
+	mergeCounts(dst.DNSDomains, src.DNSDomains)
+	mergeCounts(dst.HTTPHosts, src.HTTPHosts)
+	mergeCounts(dst.TLSSNIs, src.TLSSNIs)
+	mergeCounts(dst.TrafficTypeCounts, src.TrafficTypeCounts)
+	if len(src.EvidenceCounts) > 0 {
+		if dst.EvidenceCounts == nil {
+			dst.EvidenceCounts = make(map[string]int)
+		}
+		mergeCounts(dst.EvidenceCounts, src.EvidenceCounts)
+	}
+	mergeOSGuess(dst, src)
+	mergeDeviceType(dst, src)
+	mergeActivity(dst, src)
+	mergeIPHistory(dst, src)
+	mergeCircuits(dst, src)
+	mergeEgress(dst, src)
+	mergePacketSizes(dst, src)
+	mergePortBehavior(dst, src)
+	mergeFirsts(dst, src)
+	mergeDeviceInterfaces(dst, src)
+
+	dst.PartialTLSHellos += src.PartialTLSHellos
+	dst.SuspiciousDNSQueries += src.SuspiciousDNSQueries
+	dst.ResolvedPatterns += src.ResolvedPatterns
+	dst.DirectIPPatterns += src.DirectIPPatterns
+	dst.ARPMismatches += src.ARPMismatches
+	if len(src.TLSFingerprints) > 0 {
+		if dst.TLSFingerprints == nil {
+			dst.TLSFingerprints = make(map[string]int)
+		}
+		mergeCounts(dst.TLSFingerprints, src.TLSFingerprints)
+	}
+	if len(src.HTTPHostHeaders) > 0 {
+		if dst.HTTPHostHeaders == nil {
+			dst.HTTPHostHeaders = make(map[string]int)
+		}
+		mergeCounts(dst.HTTPHostHeaders, src.HTTPHostHeaders)
+	}
+
+	if len(src.SeenPatterns) > 0 && dst.SeenPatterns == nil {
+		dst.SeenPatterns = make(map[string]bool, len(src.SeenPatterns))
+	}
+	for key := range src.SeenPatterns {
+		dst.SeenPatterns[key] = true
+	}
+}
+
+func mergeCounts[K comparable](dst, src map[K]int) {
+	for k, v := range src {
+		dst[k] += v
+	}
+}
+
+// startWorker runs fn every interval in the background until the monitor is
+// closed. A run in progress when Close is called completes first.
+func (nm *NetworkMonitor) startWorker(interval time.Duration, fn func(now time.Time)) {
+	nm.workers.Add(1)
+	go func() {
+		defer nm.workers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-nm.closing:
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+}
+
+// persistDevices writes every cached device to the database, with the
+// patterns, anomalies and suppression hits pending since the last pass
+func (nm *NetworkMonitor) persistDevices() (models.FlushResult, error) {
+	if nm.readOnly {
+		return models.FlushResult{}, ErrReadOnly
+	}
+	start := time.Now()
+	nm.lockAll()
+	keys := nm.Cache.Keys()
+	snapshots := nm.takeSnapshots(start)
+	snapshotConfig := nm.snapshots.config
+	patterns := nm.pendingPatterns
+	nm.pendingPatterns = nil
+	retention := nm.patternRetention
+	var suppressions []models.Suppression
+	if nm.suppressionHits {
+		for _, rule := range nm.suppressions {
+			suppressions = append(suppressions, rule.Suppression)
+		}
+		nm.suppressionHits = false
+	}
+	// Taken together with the patterns so every annotated pattern is either in
+	// this batch or already persisted
+	nm.anomalyMu.Lock()
+	annotations := nm.annotations
+	nm.annotations = nil
+	anomalies := nm.pendingAnomalies
+	nm.pendingAnomalies = nil
+	anomalyRetention := nm.anomalyRetention
+	expectations := nm.pendingExpectations()
+	nm.anomalyMu.Unlock()
+	// With the devices, so every UUID they hold is indexed
+	uuids := nm.uuids.takePending()
+	seen := nm.takeSeenPatterns()
+	nm.unlockAll()
+
+	var patternOpts *buntdb.SetOptions
+	if retention > 0 {
+		patternOpts = &buntdb.SetOptions{Expires: true, TTL: retention}
+	}
+
+	// Encoded before the transaction: the event path may read the database
+	// while holding the stripe of a device
+	records := make(map[string]string, len(keys))
+	for _, mac := range keys {
+		if device, ok := nm.Cache.Get(mac); ok {
+			unlock := nm.lockDevice(mac)
+			data, _ := json.Marshal(device)
+			unlock()
+			records[mac] = string(data)
+		}
+	}
+
+	devices := 0
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		for _, mac := range keys {
+			if data, ok := records[mac]; ok {
+				if _, _, err := tx.Set(mac, data, nil); err != nil {
+					return err
+				}
+				devices++
+			}
+		}
+		if err := writeSuppressionHits(tx, suppressions, time.Now()); err != nil {
+			return err
+		}
+		if err := writeExpectations(tx, expectations, time.Now()); err != nil {
+			return err
+		}
+		if err := writeUUIDs(tx, uuids); err != nil {
+			return err
+		}
+		if err := writeSeenPatterns(tx, seen); err != nil {
+			return err
+		}
+		if err := writeSnapshots(tx, snapshots, snapshotConfig); err != nil {
+			return err
+		}
+		if err := writePatterns(tx, patterns, annotations, patternOpts); err != nil {
+			return err
+		}
+		return writeAnomalies(tx, anomalies, anomalyRetention)
+	})
+
+	if err != nil {
+		nm.requeuePatterns(patterns)
+		nm.requeueAnnotations(annotations)
+		nm.requeueAnomalies(anomalies)
+		nm.forgetSnapshots(snapshots)
+		nm.uuids.requeue(uuids)
+		nm.lockAll()
+		nm.requeueSeenPatterns(seen)
+		if len(suppressions) > 0 {
+			nm.suppressionHits = true
+		}
+		nm.unlockAll()
+		if len(expectations) > 0 {
+			nm.anomalyMu.Lock()
+			nm.expectationHits = true
+			nm.anomalyMu.Unlock()
+		}
+	}
+
+	nm.recordPersistResult(err)
+	if err != nil {
+		return models.FlushResult{}, err
+	}
+	now := time.Now()
+	return models.FlushResult{
+		Devices:     devices,
+		Patterns:    len(patterns),
+		Anomalies:   len(anomalies),
+		DurationMs:  float64(now.Sub(start).Microseconds()) / 1000,
+		CompletedAt: now,
+	}, nil
 }
 
 func (nm *NetworkMonitor) newDeviceNotifier() {
 	for device := range nm.newDeviceChan {
-		fmt.Printf("\nNEW DEVICE DETECTED!\n")
+		if device.ExpectedBy != "" {
+			fmt.Printf("[EXPECTED] new device %s (%s), announced by %s\n", device.ID, device.Vendor, device.ExpectedBy)
+			continue
+		}
+		if !nm.emit(SinkNewDevice, device) {
+			continue
+		}
+		if device.Name != "" {
+			fmt.Printf("\nNEW DEVICE DETECTED: %s\n", device.Name)
+		} else {
+			fmt.Printf("\nNEW DEVICE DETECTED!\n")
+		}
 		fmt.Printf("   MAC:     %s\n", device.MAC)
 		fmt.Printf("   IP:      %s\n", device.IP)
 		fmt.Printf("   Vendor:  %s\n", device.Vendor)
+		if device.Owner != "" {
+			fmt.Printf("   Owner:   %s\n", device.Owner)
+		}
+		if device.Location != "" {
+			fmt.Printf("   Location: %s\n", device.Location)
+		}
 		fmt.Printf("   First Seen: %s\n\n", device.FirstSeen.Format("2006-01-02 15:04:05"))
 	}
 }
 
 func (nm *NetworkMonitor) newPatternNotifier() {
-	for pattern := range nm.newPatternChan {
-		device, _ := nm.Cache.Get(pattern.SrcMAC)
+	throttle := newPatternThrottle(time.Now())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		vendor := "Unknown"
-		if device != nil {
-			vendor = device.Vendor
+	for {
+		select {
+		case pattern, ok := <-nm.newPatternChan:
+			if !ok {
+				nm.notifyPatternSummaries(throttle.rotate(time.Now(), *nm.patternNotify.Load(), true))
+				return
+			}
+			nm.publishPattern(pattern)
+			if throttle.allow(pattern.DeviceID, *nm.patternNotify.Load()) {
+				nm.notifyPattern(pattern)
+			}
+		case now := <-ticker.C:
+			nm.notifyPatternSummaries(throttle.rotate(now, *nm.patternNotify.Load(), false))
 		}
+	}
+}
 
-		l7Suffix := ""
-		if pattern.L7Info != "" {
-			l7Suffix = fmt.Sprintf(" [%s]", pattern.L7Info)
+// notifyPatternSummaries reports the new patterns whose notifications were throttled
+func (nm *NetworkMonitor) notifyPatternSummaries(summaries []models.PatternSummary) {
+	for _, summary := range summaries {
+		summary.DeviceUUID = nm.uuids.device(summary.DeviceID)
+		if !nm.emit(SinkPatternSummary, &summary) {
+			continue
 		}
+		fmt.Printf("[%s] %d more new patterns in the last %s not shown\n",
+			summary.DeviceID, summary.Suppressed, summary.To.Sub(summary.From).Round(time.Second))
+	}
+}
 
-		// Add interface name to output
-		ifPrefix := ""
-		if pattern.Interface != "" {
-			ifPrefix = fmt.Sprintf("[%s] ", pattern.Interface)
-		}
+func (nm *NetworkMonitor) notifyPattern(pattern *models.CommunicationPattern) {
+	if !nm.emit(SinkPattern, pattern) {
+		return
+	}
 
-		if pattern.DstPort > 0 {
-			fmt.Printf("%s[%s] %s (%s) [%s] → %s:%d (%s)%s\n",
-				ifPrefix,
-				pattern.Protocol,
-				pattern.SrcIP,
-				pattern.SrcMAC,
-				vendor,
-				pattern.DstIP,
-				pattern.DstPort,
-				pattern.Service,
-				l7Suffix,
-			)
-		} else {
-			fmt.Printf("%s[%s] %s (%s) [%s] → %s (%s)%s\n",
-				ifPrefix,
-				pattern.Protocol,
-				pattern.SrcIP,
-				pattern.SrcMAC,
-				vendor,
-				pattern.DstIP,
-				pattern.Service,
-				l7Suffix,
-			)
-		}
+	vendor := "Unknown"
+	if device, ok := nm.Cache.Get(pattern.DeviceID); ok {
+		unlock := nm.lockDevice(pattern.DeviceID)
+		vendor = deviceLabel(device)
+		unlock()
 	}
-}
 
-func (nm *NetworkMonitor) lookupVendor(mac string) string {
-	parts := strings.Split(strings.ToUpper(mac), ":")
-	if len(parts) < 3 {
-		return "Unknown"
+	l7Suffix := ""
+	if pattern.L7Info != "" {
+		l7Suffix = fmt.Sprintf(" [%s]", pattern.L7Info)
 	}
-	oui := strings.Join(parts[:3], ":")
 
-	if vendor, ok := nm.ouiDB[oui]; ok {
-		return vendor
+	// Add interface name to output
+	ifPrefix := ""
+	if pattern.Interface != "" {
+		ifPrefix = fmt.Sprintf("[%s] ", pattern.Interface)
+	}
+
+	if pattern.DstPort > 0 {
+		fmt.Printf("%s[%s] %s (%s) [%s] → %s:%d (%s)%s\n",
+			ifPrefix,
+			pattern.Protocol,
+			pattern.SrcIP,
+			pattern.SrcMAC,
+			vendor,
+			pattern.DstIP,
+			pattern.DstPort,
+			pattern.Service,
+			l7Suffix,
+		)
+	} else {
+		fmt.Printf("%s[%s] %s (%s) [%s] → %s (%s)%s\n",
+			ifPrefix,
+			pattern.Protocol,
+			pattern.SrcIP,
+			pattern.SrcMAC,
+			vendor,
+			pattern.DstIP,
+			pattern.Service,
+			l7Suffix,
+		)
 	}
-	return "Unknown"
 }
 
-func (nm *NetworkMonitor) GetStats() map[string]*models.DeviceInfo {
-	nm.mu.RLock()
-	defer nm.mu.RUnlock()
+// snapshotBatch is how many devices are updated per nm.mu lock by the
+// workers rescoring every device
+const snapshotBatch = 256
 
-	stats := make(map[string]*models.DeviceInfo)
-	for _, mac := range nm.Cache.Keys() {
-		if device, ok := nm.Cache.Get(mac); ok {
-			stats[mac] = device
+// GetDevice returns a copy of a tracked device that is safe to use while
+// TrackEvent keeps updating the original
+func (nm *NetworkMonitor) GetDevice(id string) (*models.DeviceInfo, bool) {
+	device, ok := nm.Cache.Get(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+	return cloneDevice(device), true
+}
+
+// ListDevices returns copies of every tracked device. Each copy is
+// consistent, though the list spans several instants.
+func (nm *NetworkMonitor) ListDevices() []*models.DeviceInfo {
+	devices := make([]*models.DeviceInfo, 0, nm.Cache.Len())
+	nm.eachDevice(func(_ string, device *models.DeviceInfo) {
+		devices = append(devices, cloneDevice(device))
+	})
+	return devices
+}
+
+// DeviceInternals returns the seen-pattern keys and flow statistics of a
+// device, which are omitted from its regular JSON form
+func (nm *NetworkMonitor) DeviceInternals(id string) ([]string, map[string]models.FlowStats, bool) {
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, nil, false
+	}
+
+	unlock := nm.lockDevice(id)
+	var patterns []string
+	loaded := device.SeenPatterns != nil
+	for key := range device.SeenPatterns {
+		patterns = append(patterns, key)
+	}
+	flows := make(map[string]models.FlowStats, len(device.FlowStats))
+	for key, stats := range device.FlowStats {
+		flows[key] = *stats
+	}
+	unlock()
+
+	// Unloaded sets are read from the database without holding the device
+	if !loaded {
+		for key := range nm.loadSeenPatterns(id) {
+			patterns = append(patterns, key)
 		}
 	}
+	if patterns == nil {
+		patterns = []string{}
+	}
+	sort.Strings(patterns)
+
+	return patterns, flows, true
+}
+
+// cloneDevice deep-copies the exported state of a device
+func cloneDevice(device *models.DeviceInfo) *models.DeviceInfo {
+	clone := *device
+	clone.Targets = device.Targets.Clone()
+	clone.FormerIDs = append([]string(nil), device.FormerIDs...)
+	clone.IPHistory = append([]models.IPLease(nil), device.IPHistory...)
+	clone.Circuits = append([]models.CircuitAttribution(nil), device.Circuits...)
+	if device.Egress != nil {
+		egress := *device.Egress
+		clone.Egress = &egress
+	}
+	clone.Services = cloneServices(device.Services)
+	clone.DNSDomains = maps.Clone(device.DNSDomains)
+	clone.HTTPHosts = maps.Clone(device.HTTPHosts)
+	clone.TLSSNIs = maps.Clone(device.TLSSNIs)
+	clone.TrafficTypeCounts = maps.Clone(device.TrafficTypeCounts)
+	clone.EvidenceCounts = maps.Clone(device.EvidenceCounts)
+	clone.OSGuess = cloneOSGuess(device.OSGuess)
+	clone.DeviceType = cloneDeviceType(device.DeviceType)
+	if device.ARPLatency != nil {
+		latency := *device.ARPLatency
+		clone.ARPLatency = &latency
+	}
+	clone.TLSFingerprints = maps.Clone(device.TLSFingerprints)
+	clone.HTTPHostHeaders = maps.Clone(device.HTTPHostHeaders)
+	if device.Activity != nil {
+		activity := *device.Activity
+		clone.Activity = &activity
+	}
+	if device.PacketSizes != nil {
+		sizes := *device.PacketSizes
+		clone.PacketSizes = &sizes
+	}
+	clone.PortBehavior = clonePortBehavior(device.PortBehavior)
+	clone.Firsts = cloneFirsts(device.Firsts)
+	clone.Health = cloneHealth(device.Health)
+	clone.Interfaces = append([]string(nil), device.Interfaces...)
+	clone.SeenPatterns = nil
+	clone.FlowStats = nil
+	return &clone
+}
+
+// GetStats returns copies of every tracked device by ID
+func (nm *NetworkMonitor) GetStats() map[string]*models.DeviceInfo {
+	devices := nm.ListDevices()
+	stats := make(map[string]*models.DeviceInfo, len(devices))
+	for _, device := range devices {
+		stats[device.ID] = device
+	}
 	return stats
 }
 
 func (nm *NetworkMonitor) PrintStats() {
 	stats := nm.GetStats()
+	counts := nm.Stats.Snapshot()
 
 	fmt.Printf("\n╔═══════════════════════════════════════════════════════════════╗\n")
 	fmt.Printf("║              NETWORK STATISTICS SUMMARY                       ║\n")
 	fmt.Printf("╠═══════════════════════════════════════════════════════════════╣\n")
 	fmt.Printf("║ Total Devices: %-46d ║\n", len(stats))
-	fmt.Printf("║ Total Packets: %-46d ║\n", nm.Stats.TotalPackets)
-	fmt.Printf("║   - ARP:  %-51d ║\n", nm.Stats.ArpPackets)
-	fmt.Printf("║   - TCP:  %-51d ║\n", nm.Stats.TcpPackets)
-	fmt.Printf("║   - UDP:  %-51d ║\n", nm.Stats.UdpPackets)
-	fmt.Printf("║   - ICMP: %-51d ║\n", nm.Stats.IcmpPackets)
-	fmt.Printf("║   - DNS:  %-51d ║\n", nm.Stats.DnsPackets)
-	fmt.Printf("║   - HTTP: %-51d ║\n", nm.Stats.HttpPackets)
-	fmt.Printf("║   - TLS:  %-51d ║\n", nm.Stats.TlsPackets)
+	fmt.Printf("║ Total Packets: %-46d ║\n", counts.TotalPackets)
+	fmt.Printf("║   - ARP:  %-51d ║\n", counts.ArpPackets)
+	fmt.Printf("║   - TCP:  %-51d ║\n", counts.TcpPackets)
+	fmt.Printf("║   - UDP:  %-51d ║\n", counts.UdpPackets)
+	fmt.Printf("║   - ICMP: %-51d ║\n", counts.IcmpPackets)
+	fmt.Printf("║   - DNS:  %-51d ║\n", counts.DnsPackets)
+	fmt.Printf("║   - HTTP: %-51d ║\n", counts.HttpPackets)
+	fmt.Printf("║   - TLS:  %-51d ║\n", counts.TlsPackets)
+	fmt.Printf("║ Enabled Events: %-45s ║\n", strings.Join(nm.EnabledEventNames(), ","))
+	fmt.Printf("║ Filtered Packets: %-43d ║\n", counts.FilteredPackets)
+	if counts.InvalidEvents > 0 {
+		fmt.Printf("║ Invalid Events: %-45d ║\n", counts.InvalidEvents)
+	}
+	if persistence := nm.PersistenceStatus(); !persistence.Healthy {
+		fmt.Printf("║ Persistence: %-48s ║\n", fmt.Sprintf("FAILING (%d failed writes)", persistence.FailedWrites))
+	}
+	if usage := nm.ResourceUsage(); !usage.Timestamp.IsZero() {
+		resources := fmt.Sprintf("RSS %dMB | %d goroutines | %d FDs | DB %dMB",
+			usage.RSSBytes/(1024*1024), usage.Goroutines, usage.OpenFDs, usage.DBBytes/(1024*1024))
+		if usage.DefensiveMode {
+			resources += " | DEFENSIVE"
+		}
+		fmt.Printf("║ Resources: %-50s ║\n", resources)
+	}
 	fmt.Printf("╚═══════════════════════════════════════════════════════════════╝\n\n")
 
-	for mac, device := range stats {
-		fmt.Printf("┌─ Device: %s\n", mac)
+	for id, device := range stats {
+		fmt.Printf("┌─ Device: %s\n", id)
 		fmt.Printf("│  IP: %s | Vendor: %s\n", device.IP, device.Vendor)
+		if device.Routed {
+			fmt.Printf("│  Routed via: %s\n", device.MAC)
+		}
+		if device.OSGuess != nil {
+			fmt.Printf("│  OS: %s (%s confidence)\n", device.OSGuess.OS, device.OSGuess.Confidence)
+		}
+		if deviceType := DeviceType(device); deviceType != "" {
+			fmt.Printf("│  Type: %s (%s confidence)\n", deviceType, device.DeviceType.Confidence)
+		}
+
+		risk := ScoreDevice(device, nm.riskWeights)
+		fmt.Printf("│  Risk Score: %d/100", risk.Score)
+		for _, factor := range risk.Factors {
+			fmt.Printf(" %s(+%.1f)", factor.Name, factor.Points)
+		}
+		fmt.Println()
 		fmt.Printf("│  ARP: Req=%d Reply=%d | TCP: %d | UDP: %d | ICMP: %d\n",
 			device.RequestCount, device.ReplyCount, device.TCPConnections,
 			device.UDPConnections, device.ICMPPackets)
@@ -559,10 +1771,10 @@ func (nm *NetworkMonitor) PrintStats() {
 			fmt.Printf("│  TLS Connections: %d\n", device.TLSConnections)
 		}
 
-		if len(device.Services) > 0 {
+		if services := device.Services.Totals(); len(services) > 0 {
 			fmt.Printf("│  Top Services: ")
 			count := 0
-			for svc, cnt := range device.Services {
+			for svc, cnt := range services {
 				if count >= 5 {
 					break
 				}
@@ -576,8 +1788,8 @@ func (nm *NetworkMonitor) PrintStats() {
 			device.FirstSeen.Format("15:04:05"),
 			device.LastSeen.Format("15:04:05"))
 
-		if len(device.Targets) > 0 {
-			fmt.Printf("│  Recent Targets: %v\n", device.Targets[max(0, len(device.Targets)-3):])
+		if device.Targets.Len() > 0 {
+			fmt.Printf("│  Recent Targets: %v\n", device.Targets.Last(3))
 		}
 		fmt.Println("└─")
 	}
@@ -0,0 +1,216 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// newTestMonitor returns a monitor over an in-memory database, closed when
+// the test ends
+func newTestMonitor(t testing.TB, cacheSize int) *NetworkMonitor {
+	t.Helper()
+	nm, err := NewNetworkMonitor(cacheSize, ":memory:")
+	if err != nil {
+		t.Fatalf("NewNetworkMonitor: %v", err)
+	}
+	t.Cleanup(func() { nm.Close() })
+	return nm
+}
+
+// beIP encodes a dotted IPv4 address the way events carry it
+func beIP(t testing.TB, ip string) uint32 {
+	t.Helper()
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		t.Fatalf("invalid IPv4 address %q", ip)
+	}
+	return binary.BigEndian.Uint32(parsed)
+}
+
+// testMAC parses a MAC address for an event
+func testMAC(t testing.TB, mac string) [6]byte {
+	t.Helper()
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		t.Fatalf("invalid MAC %q", mac)
+	}
+	return [6]byte(hw)
+}
+
+// tcpEvent returns an established TCP segment from a device to dst:port
+func tcpEvent(t testing.TB, mac, src, dst string, port uint16) *models.NetworkEvent {
+	t.Helper()
+	return &models.NetworkEvent{
+		EventType: models.EVENT_TYPE_TCP,
+		SrcMac:    testMAC(t, mac),
+		DstMac:    testMAC(t, "02:00:00:00:00:01"),
+		SrcIP:     beIP(t, src),
+		DstIP:     beIP(t, dst),
+		SrcPort:   40000,
+		DstPort:   port,
+		Protocol:  6,
+		TCPFlags:  0x10,
+		IfIndex:   1,
+		IPTTL:     64,
+		PacketLen: 60,
+	}
+}
+
+// pendingPatternCount returns how many new patterns await the next persist
+func pendingPatternCount(nm *NetworkMonitor) int {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+	return len(nm.pendingPatterns)
+}
+
+// benchmarkDevices is how many devices the concurrency benchmarks track
+const benchmarkDevices = 4000
+
+// deviceEvents returns one TCP event per device, each device talking to a
+// handful of destinations so that most events match a known pattern
+func deviceEvents(t testing.TB, devices int) []*models.NetworkEvent {
+	t.Helper()
+	events := make([]*models.NetworkEvent, 0, devices*4)
+	for dst := range 4 {
+		for i := range devices {
+			mac := net.HardwareAddr{0x02, 0x10, 0, 0, byte(i >> 8), byte(i)}.String()
+			src := net.IPv4(10, 1, byte(i>>8), byte(i)).String()
+			events = append(events, tcpEvent(t, mac, src, net.IPv4(10, 2, 0, byte(dst+1)).String(), 443))
+		}
+	}
+	return events
+}
+
+// BenchmarkTrackEvent measures event throughput with and without API clients
+// reading every device as fast as they can, alternating between the device
+// list and the stats report
+func BenchmarkTrackEvent(b *testing.B) {
+	reads := []func(nm *NetworkMonitor){
+		func(nm *NetworkMonitor) { nm.GetStats() },
+		func(nm *NetworkMonitor) { nm.StatsReport() },
+	}
+	for _, readers := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			nm := newTestMonitor(b, 2*benchmarkDevices)
+			events := deviceEvents(b, benchmarkDevices)
+			for _, evt := range events {
+				nm.TrackEvent(evt)
+			}
+
+			stop := make(chan struct{})
+			var listings atomic.Int64
+			var wg sync.WaitGroup
+			for range readers {
+				wg.Go(func() {
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						reads[i%len(reads)](nm)
+						listings.Add(1)
+					}
+				})
+			}
+
+			b.ResetTimer()
+			for i := range b.N {
+				nm.TrackEvent(events[i%len(events)])
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+			if readers > 0 {
+				b.ReportMetric(float64(listings.Load())/b.Elapsed().Seconds(), "listings/s")
+			}
+		})
+	}
+}
+
+// TestConcurrentReadersAndEvents runs API-style readers against the event
+// path and the periodic workers; run with -race
+func TestConcurrentReadersAndEvents(t *testing.T) {
+	nm := newTestMonitor(t, 64)
+	events := deviceEvents(t, 100) // More devices than the cache holds
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	loop := func(pause time.Duration, fn func()) {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(pause):
+				}
+				fn()
+			}
+		})
+	}
+	// API readers and the periodic workers, far more often than they would run
+	loop(100*time.Microsecond, func() { nm.GetStats() })
+	loop(100*time.Microsecond, func() { nm.GetDevice("02:10:00:00:00:01") })
+	loop(100*time.Microsecond, func() { nm.UnknownPorts() })
+	loop(100*time.Microsecond, func() { nm.devicesTagged([]string{"subnet:10.1.0.0/16"}) })
+	loop(100*time.Microsecond, func() { nm.DevicesByRisk() })
+	loop(time.Millisecond, func() { nm.healthTick(time.Now()) })
+	loop(time.Millisecond, func() {
+		if _, err := nm.persistDevices(); err != nil {
+			t.Errorf("persistDevices: %v", err)
+		}
+	})
+
+	for i := range 2 * len(events) {
+		evt := *events[i%len(events)]
+		evt.DstPort = uint16(1 + i%7)
+		nm.TrackEvent(&evt)
+		nm.TrackFlowSummary(&models.FlowSummaryEvent{
+			SrcMac:   evt.SrcMac,
+			SrcIP:    evt.SrcIP,
+			DstIP:    evt.DstIP,
+			SrcPort:  evt.SrcPort,
+			DstPort:  evt.DstPort,
+			Protocol: 6,
+			Reason:   models.FlowSummaryPackets,
+			IfIndex:  evt.IfIndex,
+			Packets:  10,
+			Bytes:    1500,
+		})
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := len(nm.GetStats()); got != 64 {
+		t.Errorf("GetStats returned %d devices, want the 64 cached", got)
+	}
+}
+
+// BenchmarkTrackEventTargets measures the event path of one device cycling
+// over as many destinations as it remembers, every pattern already known
+func BenchmarkTrackEventTargets(b *testing.B) {
+	nm := newTestMonitor(b, 16)
+	events := make([]*models.NetworkEvent, MaxTargets)
+	for i := range events {
+		events[i] = tcpEvent(b, "02:10:00:00:00:01", "10.1.0.1", net.IPv4(10, 2, 0, byte(i+1)).String(), 443)
+		nm.TrackEvent(events[i])
+	}
+	if device, _ := nm.GetDevice("02:10:00:00:00:01"); device == nil || device.Targets.Len() != MaxTargets {
+		b.Fatalf("device does not hold %d targets", MaxTargets)
+	}
+
+	b.ResetTimer()
+	for i := range b.N {
+		nm.TrackEvent(events[i%len(events)])
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+}
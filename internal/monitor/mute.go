@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// MuteKeyPrefix prefixes persisted device mutes in the database.
+const MuteKeyPrefix = "silenced:"
+
+// ErrMuteNotFound is returned when unmuting a device that isn't muted
+var ErrMuteNotFound = errors.New("device is not muted")
+
+// loadMutes reads the persisted device mutes
+func (nm *NetworkMonitor) loadMutes() {
+	nm.mutes = make(map[string]*models.DeviceMute)
+
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", MuteKeyPrefix, MuteKeyPrefix+"~", func(key, value string) bool {
+			var mute models.DeviceMute
+			if json.Unmarshal([]byte(value), &mute) == nil && mute.Device != "" {
+				nm.mutes[mute.Device] = &mute
+			}
+			return true
+		})
+	})
+}
+
+// muteTarget turns a MAC, a device ID or a bare IP into the ID of the device
+// to mute. A bare IP names the tracked device currently holding it, or else
+// the routed device keyed on it.
+func (nm *NetworkMonitor) muteTarget(id string) (string, error) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if mac, err := net.ParseMAC(id); err == nil && len(mac) == 6 {
+		return mac.String(), nil
+	}
+	if addr, ok := strings.CutPrefix(id, "ip:"); ok {
+		if net.ParseIP(addr) == nil {
+			return "", fmt.Errorf("invalid device %q: expected a MAC, an IP or ip:<addr>", id)
+		}
+		return id, nil
+	}
+	ip := net.ParseIP(id)
+	if ip == nil {
+		return "", fmt.Errorf("invalid device %q: expected a MAC, an IP or ip:<addr>", id)
+	}
+
+	if ids := nm.devicesAt(ip.String()); len(ids) > 0 {
+		return ids[0], nil
+	}
+	return routedDeviceID(ip.String()), nil
+}
+
+// MuteDevice drops every anomaly of a device from now on, until expiresAt if
+// set. Muting a muted device replaces its mute.
+func (nm *NetworkMonitor) MuteDevice(id, comment string, expiresAt *time.Time) (models.DeviceMute, error) {
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return models.DeviceMute{}, fmt.Errorf("expires_at must be in the future")
+	}
+	device, err := nm.muteTarget(id)
+	if err != nil {
+		return models.DeviceMute{}, err
+	}
+
+	mute := &models.DeviceMute{Device: device, Comment: comment, CreatedAt: now, ExpiresAt: expiresAt}
+	var opts *buntdb.SetOptions
+	if expiresAt != nil {
+		opts = &buntdb.SetOptions{Expires: true, TTL: expiresAt.Sub(now)}
+	}
+
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	data, _ := json.Marshal(mute)
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(MuteKeyPrefix+device, string(data), opts)
+		return err
+	})
+	if err != nil {
+		return models.DeviceMute{}, fmt.Errorf("failed to persist mute: %w", err)
+	}
+	nm.mutes[device] = mute
+	return *mute, nil
+}
+
+// UnmuteDevice lets the anomalies of a device through again
+func (nm *NetworkMonitor) UnmuteDevice(id string) error {
+	device, err := nm.muteTarget(id)
+	if err != nil {
+		return err
+	}
+
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	if nm.activeMute(device, time.Now()) == nil {
+		return ErrMuteNotFound
+	}
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(MuteKeyPrefix + device)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete mute: %w", err)
+	}
+	delete(nm.mutes, device)
+	return nil
+}
+
+// Mutes returns the active device mutes, oldest first
+func (nm *NetworkMonitor) Mutes() []models.DeviceMute {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	now := time.Now()
+	mutes := make([]models.DeviceMute, 0, len(nm.mutes))
+	for device := range nm.mutes {
+		if mute := nm.activeMute(device, now); mute != nil {
+			mutes = append(mutes, *mute)
+		}
+	}
+	sort.Slice(mutes, func(i, j int) bool { return mutes[i].CreatedAt.Before(mutes[j].CreatedAt) })
+	return mutes
+}
+
+// DeviceMute returns the active mute of a device, or nil
+func (nm *NetworkMonitor) DeviceMute(id string) *models.DeviceMute {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	mute := nm.activeMute(id, time.Now())
+	if mute == nil {
+		return nil
+	}
+	copied := *mute
+	return &copied
+}
+
+// activeMute returns the mute of a device unless it has expired, forgetting
+// expired ones. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) activeMute(device string, now time.Time) *models.DeviceMute {
+	mute := nm.mutes[device]
+	if mute == nil {
+		return nil
+	}
+	if mute.ExpiresAt != nil && !now.Before(*mute.ExpiresAt) {
+		delete(nm.mutes, device)
+		return nil
+	}
+	return mute
+}
+
+// dropMuted reports whether the anomalies of a device are muted, counting the
+// dropped anomaly. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) dropMuted(device string, now time.Time) bool {
+	if device == "" {
+		return false
+	}
+	mute := nm.activeMute(device, now)
+	if mute == nil {
+		return false
+	}
+	mute.Dropped++
+	dropped := now
+	mute.LastDropped = &dropped
+	return true
+}
@@ -0,0 +1,78 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// PatternNotifyConfig limits how many new-pattern notifications each device
+// gets, so a burst of benign patterns (an update hitting many CDN nodes)
+// doesn't flood the console or the event sink. Patterns over the limit are
+// still recorded; their notifications are summarized once the window ends.
+type PatternNotifyConfig struct {
+	PerDevice int           // Notifications per device per window (0 = unlimited)
+	Window    time.Duration // Length of each counting window
+}
+
+// DefaultPatternNotifyConfig returns the default new-pattern notification limit
+func DefaultPatternNotifyConfig() PatternNotifyConfig {
+	return PatternNotifyConfig{PerDevice: 20, Window: time.Minute}
+}
+
+// SetPatternNotifyConfig replaces the new-pattern notification limit
+func (nm *NetworkMonitor) SetPatternNotifyConfig(config PatternNotifyConfig) {
+	nm.patternNotify.Store(&config)
+}
+
+// patternThrottle counts the notifications of each device in the current
+// window. It is only used by the pattern notifier goroutine.
+type patternThrottle struct {
+	windowStart time.Time
+	sent        map[string]int
+	suppressed  map[string]int
+}
+
+func newPatternThrottle(now time.Time) *patternThrottle {
+	return &patternThrottle{
+		windowStart: now,
+		sent:        make(map[string]int),
+		suppressed:  make(map[string]int),
+	}
+}
+
+// allow reports whether a new pattern of the device is notified, counting it
+// either way
+func (t *patternThrottle) allow(deviceID string, config PatternNotifyConfig) bool {
+	if config.PerDevice <= 0 {
+		return true
+	}
+	if t.sent[deviceID] >= config.PerDevice {
+		t.suppressed[deviceID]++
+		return false
+	}
+	t.sent[deviceID]++
+	return true
+}
+
+// rotate ends the window if it has run its length, returning a summary for
+// every device that had notifications suppressed
+func (t *patternThrottle) rotate(now time.Time, config PatternNotifyConfig, force bool) []models.PatternSummary {
+	if !force && now.Sub(t.windowStart) < config.Window {
+		return nil
+	}
+
+	var summaries []models.PatternSummary
+	for deviceID, count := range t.suppressed {
+		summaries = append(summaries, models.PatternSummary{
+			DeviceID:   deviceID,
+			Suppressed: count,
+			From:       t.windowStart,
+			To:         now,
+		})
+	}
+	t.windowStart = now
+	clear(t.sent)
+	clear(t.suppressed)
+	return summaries
+}
@@ -0,0 +1,335 @@
+package monitor
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// NovelDestinationKey holds the persisted destinations seen and baseline of
+// the novel destination detector.
+const NovelDestinationKey = "stats:novel"
+
+const (
+	novelFilterBits      = 1 << 20            // Bits of each generation of the seen-set, 128KiB
+	novelFilterHashes    = 7                  // Bits set per destination; about 1% false positives at 100k destinations
+	novelRotation        = 7 * 24 * time.Hour // How long a generation of the seen-set takes destinations
+	novelPersistInterval = 10 * time.Minute   // How often the seen-set is persisted when it changed
+	novelTopDevices      = 5                  // Contributing devices listed in an anomaly
+	novelSamples         = 10                 // Destinations listed in an anomaly
+)
+
+// NovelDestinationConfig controls detection of spikes of never-seen external
+// destinations across the network
+type NovelDestinationConfig struct {
+	Window   time.Duration // Window novel destinations are counted in
+	Multiple float64       // Multiple of the baseline count that raises an anomaly (0 disables)
+	MinCount int           // Novel destinations within a window below which nothing is raised
+	Warmup   time.Duration // Learning before anomalies are raised: the seen-set over the first half, the baseline over the second
+}
+
+// DefaultNovelDestinationConfig returns the default novel destination
+// detection settings
+func DefaultNovelDestinationConfig() NovelDestinationConfig {
+	return NovelDestinationConfig{Window: 10 * time.Minute, Multiple: 3, MinCount: 50, Warmup: 6 * time.Hour}
+}
+
+// bloomFilter is a fixed-size Bloom filter of strings: it may wrongly report
+// a string as added, never the reverse
+type bloomFilter []byte
+
+func newBloomFilter() bloomFilter {
+	return make(bloomFilter, novelFilterBits/8)
+}
+
+// bloomBits calls fn with each bit of a value, by double hashing
+func bloomBits(value string, fn func(bit uint32) bool) bool {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	for i := uint32(0); i < novelFilterHashes; i++ {
+		if !fn((h1 + i*h2) % novelFilterBits) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bloomFilter) has(value string) bool {
+	return bloomBits(value, func(bit uint32) bool { return b[bit/8]&(1<<(bit%8)) != 0 })
+}
+
+func (b bloomFilter) add(value string) {
+	bloomBits(value, func(bit uint32) bool {
+		b[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// novelDestinationDetector counts the external destinations no device
+// contacted before and flags windows where far more appear than usual, as a
+// worm or a scan distributed over many devices would cause. Destinations seen
+// are kept in two generations of a Bloom filter: new ones go into the
+// current, the previous is still consulted, and they rotate every
+// novelRotation, so a destination is remembered between one and two
+// rotations after it was last counted. Until the seen-set has learned the
+// usual destinations, nearly all are novel, so the baseline is only counted
+// from half the warm-up on. It is guarded by nm.mu.
+type novelDestinationDetector struct {
+	config   NovelDestinationConfig
+	current  bloomFilter
+	previous bloomFilter
+	started  time.Time // When the seen-set started learning
+	rotated  time.Time
+	dirty    bool // Changed since the last persist
+	baseline rollingStat
+	counted  time.Duration // Window the baseline was counted over
+
+	windowStart  time.Time
+	count        int            // Novel destinations this window
+	devices      map[string]int // Device ID -> novel destinations it contacted this window
+	destinations []string       // The first novel destinations of this window
+	patterns     []string       // IDs of the patterns of the first ones
+	alerted      bool           // Raised this window
+}
+
+func newNovelDestinationDetector(config NovelDestinationConfig) *novelDestinationDetector {
+	return &novelDestinationDetector{
+		config:   config,
+		counted:  config.Window,
+		current:  newBloomFilter(),
+		previous: newBloomFilter(),
+		devices:  make(map[string]int),
+	}
+}
+
+// novelDestinationState is the persisted form of the detector
+type novelDestinationState struct {
+	Current  []byte        `json:"current"`
+	Previous []byte        `json:"previous"`
+	Started  time.Time     `json:"started"`
+	Rotated  time.Time     `json:"rotated"`
+	Window   time.Duration `json:"window"`
+	Mean     float64       `json:"mean"`
+	Variance float64       `json:"variance"`
+	Samples  int           `json:"samples"`
+}
+
+// SetNovelDestinationConfig replaces the novel destination detection
+// settings. The baseline is relearned when it was counted over another
+// window.
+func (nm *NetworkMonitor) SetNovelDestinationConfig(config NovelDestinationConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	d := nm.novel
+	d.config = config
+	if config.Window != d.counted {
+		d.baseline, d.counted = rollingStat{}, config.Window
+		d.resetWindow(time.Now())
+	}
+}
+
+// loadNovelDestinations resumes the destinations seen and the baseline from
+// the database, so a restart doesn't make every destination novel again
+func (nm *NetworkMonitor) loadNovelDestinations(now time.Time) {
+	d := nm.novel
+	d.started, d.rotated = now, now
+	d.resetWindow(now)
+
+	var state novelDestinationState
+	nm.db.View(func(tx *buntdb.Tx) error {
+		if value, err := tx.Get(NovelDestinationKey); err == nil {
+			json.Unmarshal([]byte(value), &state)
+		}
+		return nil
+	})
+	if len(state.Current) != novelFilterBits/8 || len(state.Previous) != novelFilterBits/8 {
+		return
+	}
+	d.current, d.previous = state.Current, state.Previous
+	d.started, d.rotated = state.Started, state.Rotated
+	if state.Window > 0 {
+		d.baseline = rollingStat{mean: state.Mean, variance: state.Variance, samples: state.Samples}
+		d.counted = state.Window
+	}
+}
+
+// novelDestinationTick rotates the seen-set when due and persists it when it
+// changed, every novelPersistInterval
+func (nm *NetworkMonitor) novelDestinationTick(now time.Time) {
+	nm.lockAll()
+	d := nm.novel
+	nm.rollNovelDestinations(now)
+	if now.Sub(d.rotated) >= novelRotation {
+		d.previous, d.current, d.rotated = d.current, newBloomFilter(), now
+		d.dirty = true
+	}
+	if !d.dirty {
+		nm.unlockAll()
+		return
+	}
+	state := novelDestinationState{
+		Current:  append([]byte(nil), d.current...),
+		Previous: append([]byte(nil), d.previous...),
+		Started:  d.started,
+		Rotated:  d.rotated,
+		Window:   d.counted,
+		Mean:     d.baseline.mean,
+		Variance: d.baseline.variance,
+		Samples:  d.baseline.samples,
+	}
+	d.dirty = false
+	nm.unlockAll()
+
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = nm.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(NovelDestinationKey, string(data), nil)
+			return err
+		})
+	}
+	if err != nil {
+		nm.Stats.FailedPersists.Add(1)
+		nm.lockAll()
+		d.dirty = true
+		nm.unlockAll()
+	}
+}
+
+// rollNovelDestinations folds the windows that ended before now into the
+// baseline, those without novel destinations as zero, once the seen-set has
+// learned. Windows beyond a day, e.g. after the clock jumped, are skipped.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) rollNovelDestinations(now time.Time) {
+	d := nm.novel
+	elapsed := int(now.Sub(d.windowStart) / d.config.Window)
+	if elapsed <= 0 {
+		return
+	}
+	if !d.windowStart.Before(d.learnedAt()) {
+		d.baseline.update(float64(d.count))
+		for range min(elapsed, int(24*time.Hour/d.config.Window)) - 1 {
+			d.baseline.update(0)
+		}
+		d.dirty = true
+	}
+	d.resetWindow(d.windowStart.Add(time.Duration(elapsed) * d.config.Window))
+}
+
+// resetWindow starts a counting window
+func (d *novelDestinationDetector) resetWindow(start time.Time) {
+	d.windowStart = start
+	d.count = 0
+	d.devices = make(map[string]int)
+	d.destinations = nil
+	d.patterns = nil
+	d.alerted = false
+}
+
+// learnedAt returns when the seen-set has learned the usual destinations
+func (d *novelDestinationDetector) learnedAt() time.Time {
+	return d.started.Add(d.config.Warmup / 2)
+}
+
+// warmupSamples is how many windows the baseline learns before alerting
+func (d *novelDestinationDetector) warmupSamples() int {
+	learning := d.config.Warmup - d.config.Warmup/2
+	return int((learning + d.config.Window - 1) / d.config.Window)
+}
+
+// detectNovelDestination is the pattern detector of spikes of destinations
+// never seen on the network. It counts the destination of a new external
+// pattern when no device contacted it before, and raises a MEDIUM anomaly
+// once per window when the count exceeds Multiple times its baseline.
+func (nm *NetworkMonitor) detectNovelDestination(check *patternCheck) verdict {
+	switch {
+	case !check.external:
+		return skipped(reasonNotExternal)
+	case check.exempt:
+		return skipped(reasonExempt)
+	case check.excluded:
+		return skipped(reasonExcluded)
+	}
+
+	d := nm.novel
+	nm.rollNovelDestinations(check.now)
+	destination := check.pattern.DstIP
+	if d.current.has(destination) {
+		return notMatched("destination seen before")
+	}
+	d.current.add(destination)
+	d.dirty = true
+	if d.previous.has(destination) {
+		return notMatched("destination seen before")
+	}
+
+	d.count++
+	d.devices[check.device.ID]++
+	if len(d.destinations) < novelSamples {
+		d.destinations = append(d.destinations, destination)
+	}
+	if len(d.patterns) < maxAnomalyPatterns {
+		d.patterns = append(d.patterns, check.pattern.ID)
+	}
+
+	switch {
+	case d.config.Multiple <= 0:
+		return notMatched("novel destination counted, detection disabled")
+	case d.baseline.samples < d.warmupSamples():
+		return notMatched("novel destination counted while the baseline warms up")
+	case d.alerted:
+		return notMatched("novel destination counted, spike already alerted this window")
+	}
+	threshold := max(d.config.Multiple*d.baseline.mean, float64(d.config.MinCount))
+	if float64(d.count) < threshold {
+		return notMatched("novel destinations within the baseline").compare(float64(d.count), threshold)
+	}
+	d.alerted = true
+
+	anomaly := nm.raiseLinkedAnomaly("NOVEL_DESTINATION_SPIKE", models.SeverityMedium, "",
+		map[string]string{
+			"count":       strconv.Itoa(d.count),
+			"devices":     strconv.Itoa(len(d.devices)),
+			"elapsed":     check.now.Sub(d.windowStart).Round(time.Second).String(),
+			"baseline":    strconv.FormatFloat(d.baseline.mean, 'f', 1, 64),
+			"window":      d.config.Window.String(),
+			"destination": d.destinations[0],
+		},
+		map[string]string{
+			"count":        strconv.Itoa(d.count),
+			"threshold":    strconv.FormatFloat(threshold, 'f', 1, 64),
+			"baseline":     strconv.FormatFloat(d.baseline.mean, 'f', 2, 64),
+			"window":       d.config.Window.String(),
+			"window_start": d.windowStart.Format(time.RFC3339),
+			"top_devices":  strings.Join(d.topDevices(), ","),
+			"destinations": strings.Join(d.destinations, ","),
+		}, d.patterns)
+	return raised("novel destinations exceeded the baseline", anomaly).compare(float64(d.count), threshold)
+}
+
+// topDevices returns the devices that contacted the most novel destinations
+// this window, as id:count, most first
+func (d *novelDestinationDetector) topDevices() []string {
+	ids := make([]string, 0, len(d.devices))
+	for id := range d.devices {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if d.devices[ids[i]] != d.devices[ids[j]] {
+			return d.devices[ids[i]] > d.devices[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	top := make([]string, 0, novelTopDevices)
+	for _, id := range ids[:min(len(ids), novelTopDevices)] {
+		top = append(top, id+":"+strconv.Itoa(d.devices[id]))
+	}
+	return top
+}
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// novelTraffic feeds patterns to the novel destination detector at chosen
+// times, as TrackEvent would, and keeps the anomalies it raised
+type novelTraffic struct {
+	t         *testing.T
+	nm        *NetworkMonitor
+	anomalies []*models.Anomaly
+}
+
+// observe runs the detector on an external pattern of a device and returns
+// its outcome
+func (n *novelTraffic) observe(now time.Time, deviceID, destination string) string {
+	n.t.Helper()
+	n.nm.lockAll()
+	defer n.nm.unlockAll()
+	v := n.nm.detectNovelDestination(&patternCheck{
+		device:   &models.DeviceInfo{ID: deviceID},
+		pattern:  &models.CommunicationPattern{ID: patternKey(now), DeviceID: deviceID, DstIP: destination},
+		external: true,
+		now:      now,
+	})
+	if v.anomaly != nil {
+		n.anomalies = append(n.anomalies, v.anomaly)
+	}
+	return v.outcome
+}
+
+// window sends the usual traffic of a window starting at start: 10 devices
+// reaching the same 10 destinations, and 5 destinations never seen before
+func (n *novelTraffic) window(start time.Time, index int) {
+	for i := range 10 {
+		n.observe(start.Add(time.Duration(i)*time.Second), fmt.Sprintf("dev-%d", i), fmt.Sprintf("151.101.0.%d", i))
+	}
+	for i := range 5 {
+		n.observe(start.Add(time.Minute+time.Duration(i)*time.Second), fmt.Sprintf("dev-%d", i), fmt.Sprintf("151.102.%d.%d", index, i))
+	}
+}
+
+// The detector stays silent through its warm-up, even on a burst of novel
+// destinations, then learns a baseline of usual windows and raises one
+// anomaly for a scan spread over 40 devices contacting 3 new hosts each.
+// The seen-set and baseline survive a restart.
+func TestNovelDestinationSpike(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm, err := NewNetworkMonitor(64, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NovelDestinationConfig{Window: 10 * time.Minute, Multiple: 3, MinCount: 20, Warmup: 2 * time.Hour}
+	nm.SetNovelDestinationConfig(config)
+	start := time.Now().Add(-3 * time.Hour).Truncate(config.Window)
+	nm.lockAll()
+	nm.loadNovelDestinations(start)
+	nm.unlockAll()
+	traffic := &novelTraffic{t: t, nm: nm}
+
+	// Warm-up: the first hour learns the seen-set, the second the baseline.
+	// A burst in each half is counted but raises nothing.
+	windows := int(config.Warmup / config.Window)
+	for w := range windows {
+		at := start.Add(time.Duration(w) * config.Window)
+		traffic.window(at, w)
+		if w == 1 || w == windows-1 {
+			for i := range 100 {
+				traffic.observe(at.Add(2*time.Minute), fmt.Sprintf("dev-%d", i%10), fmt.Sprintf("151.103.%d.%d", w, i))
+			}
+		}
+	}
+	if len(traffic.anomalies) != 0 {
+		t.Fatalf("%d anomalies raised during warm-up: %s", len(traffic.anomalies), traffic.anomalies[0].Description)
+	}
+
+	// Usual windows after warm-up stay within the baseline
+	for w := windows; w < windows+3; w++ {
+		traffic.window(start.Add(time.Duration(w)*config.Window), w)
+	}
+	if len(traffic.anomalies) != 0 {
+		t.Fatalf("anomaly raised on usual traffic: %s", traffic.anomalies[0].Description)
+	}
+	if outcome := traffic.observe(start.Add(time.Duration(windows+3)*config.Window), "dev-0", "151.101.0.1"); outcome != OutcomeNotMatched {
+		t.Errorf("usual destination: %s", outcome)
+	}
+
+	// The scan: 40 devices each reaching 3 never-seen hosts, with usual traffic
+	scan := start.Add(time.Duration(windows+3) * config.Window)
+	traffic.window(scan, windows+3)
+	for device := range 40 {
+		for host := range 3 {
+			traffic.observe(scan.Add(3*time.Minute+time.Duration(device)*time.Second),
+				fmt.Sprintf("infected-%02d", device), fmt.Sprintf("198.18.%d.%d", device, host))
+		}
+	}
+	if len(traffic.anomalies) != 1 {
+		t.Fatalf("%d anomalies raised by the scan, want 1", len(traffic.anomalies))
+	}
+	anomaly := traffic.anomalies[0]
+	if anomaly.Type != "NOVEL_DESTINATION_SPIKE" || anomaly.Severity != models.SeverityMedium || anomaly.DeviceID != "" {
+		t.Errorf("anomaly %s %s on %q", anomaly.Type, anomaly.Severity, anomaly.DeviceID)
+	}
+	// Raised as the count reaches the threshold, 3 times a baseline that
+	// includes the burst of its warm-up
+	threshold, _ := strconv.ParseFloat(anomaly.Details["threshold"], 64)
+	if count, _ := strconv.Atoi(anomaly.Params["count"]); threshold <= float64(config.MinCount) || count != int(math.Ceil(threshold)) {
+		t.Errorf("raised at %s of threshold %s", anomaly.Params["count"], anomaly.Details["threshold"])
+	}
+	top := strings.Split(anomaly.Details["top_devices"], ",")
+	if len(top) != novelTopDevices || len(strings.Split(anomaly.Details["destinations"], ",")) != novelSamples {
+		t.Errorf("top devices %q, destinations %q", anomaly.Details["top_devices"], anomaly.Details["destinations"])
+	}
+	if !strings.Contains(anomaly.Details["destinations"], "198.18.0.0") {
+		t.Errorf("scanned hosts not sampled: %s", anomaly.Details["destinations"])
+	}
+
+	// A restart resumes the seen-set and baseline: no warm-up, no spike
+	nm.lockAll()
+	samples := nm.novel.baseline.samples
+	nm.unlockAll()
+	if err := nm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	nm, err = NewNetworkMonitor(64, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	nm.SetNovelDestinationConfig(config)
+	traffic = &novelTraffic{t: t, nm: nm}
+	now := time.Now()
+	if outcome := traffic.observe(now, "infected-00", "198.18.0.1"); outcome != OutcomeNotMatched {
+		t.Errorf("destination seen before the restart: %s", outcome)
+	}
+	nm.lockAll()
+	resumed := nm.novel.baseline.samples
+	warmup := resumed < nm.novel.warmupSamples()
+	nm.unlockAll()
+	if resumed < samples || warmup {
+		t.Errorf("baseline of %d samples resumed with %d, warming up %v", samples, resumed, warmup)
+	}
+}
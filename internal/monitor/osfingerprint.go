@@ -0,0 +1,299 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// OS families reported by passive fingerprinting
+const (
+	OSWindows       = "Windows"
+	OSLinux         = "Linux"
+	OSApple         = "macOS/iOS"
+	OSUnixLike      = "Unix-like"
+	OSNetworkDevice = "Network Device"
+	OSEmbedded      = "Embedded/Legacy" // From source port behavior, see observePortBehavior
+	OSUnknown       = "Unknown"
+)
+
+// osEvidenceSaturation caps how many observations of one signal count toward a score,
+// so a chatty signal can't drown out the others
+const osEvidenceSaturation = 5
+
+// osSwitchRatio is how much stronger contradicting evidence must be before an
+// established guess changes
+const osSwitchRatio = 2.0
+
+// osRefinements lists the families a vague guess may be refined to without
+// needing contradicting evidence to outweigh it
+var osRefinements = map[string][]string{
+	OSUnixLike: {OSLinux, OSApple},
+}
+
+type osSignature struct {
+	os     string
+	weight float64
+}
+
+type synKey struct {
+	ttl    uint8 // Initial TTL bucket
+	window uint16
+}
+
+// synSignatures maps the initial TTL and window size of a SYN to an OS family
+var synSignatures = map[synKey]osSignature{
+	{128, 64240}: {OSWindows, 3},       // Windows 10/11
+	{128, 65535}: {OSWindows, 3},       // Windows 7/8
+	{128, 8192}:  {OSWindows, 3},       // Windows Vista/Server 2008
+	{64, 64240}:  {OSLinux, 3},         // Linux 4.x+
+	{64, 29200}:  {OSLinux, 3},         // Linux 3.x
+	{64, 14600}:  {OSLinux, 2},         // Linux 2.6
+	{64, 5840}:   {OSLinux, 2},         // Linux 2.4/2.6
+	{64, 65535}:  {OSApple, 3},         // macOS, iOS
+	{255, 4128}:  {OSNetworkDevice, 3}, // Cisco IOS
+}
+
+// ttlSignatures are used when a SYN's window size matches no signature
+var ttlSignatures = map[uint8]osSignature{
+	64:  {OSUnixLike, 1},
+	128: {OSWindows, 1},
+	255: {OSNetworkDevice, 1},
+}
+
+// dhcpSignatures maps the DHCP parameter request list (option 55) of a client,
+// in the order it asks, to an OS family. Each DHCP client asks for its own
+// options in its own order, so the list identifies the stack well.
+var dhcpSignatures = map[string]osSignature{
+	"1,3,6,15,31,33,43,44,46,47,119,121,249,252": {OSWindows, 3},       // Windows 10/11
+	"1,15,3,6,44,46,47,31,33,121,249,43,252":     {OSWindows, 3},       // Windows 7/8
+	"1,15,3,6,44,46,47,31,33,121,249,43":         {OSWindows, 3},       // Windows 7/Server 2008 R2
+	"1,121,3,6,15,119,252,95,44,46":              {OSApple, 3},         // macOS
+	"1,121,3,6,15,119,252":                       {OSApple, 3},         // iOS
+	"1,3,6,15,119,252":                           {OSApple, 2},         // Older macOS/iOS
+	"1,28,2,3,15,6,119,12,44,47,26,121,42":       {OSLinux, 3},         // ISC dhclient
+	"1,3,6,12,15,28,42,119,121":                  {OSLinux, 2},         // systemd-networkd
+	"1,3,6,15,26,28,51,58,59,43":                 {OSLinux, 2},         // Android
+	"1,6,15,44,3,33,150,43":                      {OSNetworkDevice, 3}, // Cisco IOS
+}
+
+// udpPortSignatures maps protocols whose presence hints at an OS family
+var udpPortSignatures = map[uint16]struct {
+	name string
+	osSignature
+}{
+	137:  {"NetBIOS name service", osSignature{OSWindows, 2}},
+	138:  {"NetBIOS datagram service", osSignature{OSWindows, 2}},
+	5353: {"mDNS", osSignature{OSApple, 0.5}}, // Also sent by Avahi and Windows 10+
+}
+
+// initialTTL rounds an observed TTL up to the common initial value it most
+// likely started from
+func initialTTL(ttl uint8) uint8 {
+	switch {
+	case ttl <= 64:
+		return 64
+	case ttl <= 128:
+		return 128
+	default:
+		return 255
+	}
+}
+
+// observeOS records any OS fingerprinting evidence carried by an event
+func observeOS(device *models.DeviceInfo, evt *models.NetworkEvent) {
+	var signal string
+	var sig osSignature
+
+	switch evt.EventType {
+	case models.EVENT_TYPE_TCP:
+		// Only connection-opening SYNs carry the sender's defaults
+		if evt.TCPFlags&0x12 != 0x02 || evt.IPTTL == 0 {
+			return
+		}
+		ttl := initialTTL(evt.IPTTL)
+
+		var ok bool
+		if sig, ok = synSignatures[synKey{ttl, evt.TCPWindow}]; ok {
+			signal = fmt.Sprintf("SYN ttl=%d window=%d", ttl, evt.TCPWindow)
+		} else if sig, ok = ttlSignatures[ttl]; ok {
+			signal = fmt.Sprintf("SYN ttl=%d", ttl)
+		} else {
+			return
+		}
+
+	case models.EVENT_TYPE_UDP:
+		if evt.SrcPort == dhcpClientPort && evt.DstPort == dhcpServerPort {
+			var ok bool
+			if signal, sig, ok = dhcpOSSignature(evt.L7Payload); !ok {
+				return
+			}
+			break
+		}
+		hint, ok := udpPortSignatures[evt.DstPort]
+		if !ok {
+			return
+		}
+		signal = hint.name
+		sig = hint.osSignature
+
+	default:
+		return
+	}
+
+	addOSEvidence(device, signal, sig)
+}
+
+// dhcpOSSignature returns the signal and signature of the parameter request
+// list of a DHCP client message sent by the client itself. Relayed copies are
+// sent from the server port, so they never reach here as the relay's own.
+func dhcpOSSignature(payload []byte) (string, osSignature, bool) {
+	msg := utils.ParseDHCP(payload)
+	if msg == nil || msg.Op != 1 || len(msg.ParamList) == 0 {
+		return "", osSignature{}, false
+	}
+	options := make([]string, len(msg.ParamList))
+	for i, code := range msg.ParamList {
+		options[i] = strconv.Itoa(int(code))
+	}
+	list := strings.Join(options, ",")
+	sig, ok := dhcpSignatures[list]
+	return "DHCP options " + list, sig, ok
+}
+
+// addOSEvidence counts an observation of a fingerprinting signal and
+// re-evaluates the guess
+func addOSEvidence(device *models.DeviceInfo, signal string, sig osSignature) {
+	if device.OSGuess == nil {
+		device.OSGuess = &models.OSGuess{}
+	}
+	guess := device.OSGuess
+
+	found := false
+	for i := range guess.Evidence {
+		if guess.Evidence[i].Signal == signal {
+			guess.Evidence[i].Count++
+			found = true
+			break
+		}
+	}
+	if !found {
+		guess.Evidence = append(guess.Evidence, models.OSEvidence{
+			Signal: signal,
+			OS:     sig.os,
+			Weight: sig.weight,
+			Count:  1,
+		})
+	}
+
+	guess.UpdatedAt = time.Now()
+	updateOSGuess(guess)
+}
+
+// updateOSGuess re-evaluates a guess from its evidence. Guesses are sticky: an
+// established OS only changes when contradicting evidence is osSwitchRatio times stronger.
+func updateOSGuess(guess *models.OSGuess) {
+	scores := make(map[string]float64)
+	total := 0.0
+	for _, ev := range guess.Evidence {
+		points := ev.Weight * float64(min(ev.Count, osEvidenceSaturation))
+		scores[ev.OS] += points
+		total += points
+	}
+	if total == 0 {
+		return
+	}
+
+	families := make([]string, 0, len(scores))
+	for os := range scores {
+		families = append(families, os)
+	}
+	sort.Strings(families)
+
+	best := families[0]
+	for _, os := range families[1:] {
+		if scores[os] > scores[best] {
+			best = os
+		}
+	}
+
+	switch {
+	case guess.OS == "" || guess.OS == best:
+		guess.OS = best
+	case isOSRefinement(guess.OS, best):
+		guess.OS = best
+	case scores[best] >= osSwitchRatio*scores[guess.OS]:
+		guess.OS = best
+	}
+
+	score := scores[guess.OS]
+	share := score / total
+	switch {
+	case score >= 10 && share >= 0.8:
+		guess.Confidence = models.ConfidenceHigh
+	case score >= 4 && share >= 0.6:
+		guess.Confidence = models.ConfidenceMedium
+	default:
+		guess.Confidence = models.ConfidenceLow
+	}
+}
+
+func isOSRefinement(from, to string) bool {
+	for _, os := range osRefinements[from] {
+		if os == to {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeOSGuess folds the OS evidence of src into dst
+func mergeOSGuess(dst, src *models.DeviceInfo) {
+	if src.OSGuess == nil {
+		return
+	}
+	if dst.OSGuess == nil {
+		dst.OSGuess = cloneOSGuess(src.OSGuess)
+		return
+	}
+
+	for _, ev := range src.OSGuess.Evidence {
+		found := false
+		for i := range dst.OSGuess.Evidence {
+			if dst.OSGuess.Evidence[i].Signal == ev.Signal {
+				dst.OSGuess.Evidence[i].Count += ev.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst.OSGuess.Evidence = append(dst.OSGuess.Evidence, ev)
+		}
+	}
+	if src.OSGuess.UpdatedAt.After(dst.OSGuess.UpdatedAt) {
+		dst.OSGuess.UpdatedAt = src.OSGuess.UpdatedAt
+	}
+	updateOSGuess(dst.OSGuess)
+}
+
+func cloneOSGuess(guess *models.OSGuess) *models.OSGuess {
+	if guess == nil {
+		return nil
+	}
+	clone := *guess
+	clone.Evidence = append([]models.OSEvidence(nil), guess.Evidence...)
+	return &clone
+}
+
+// DeviceOS returns the OS family of a device, or OSUnknown without evidence
+func DeviceOS(device *models.DeviceInfo) string {
+	if device.OSGuess == nil || device.OSGuess.OS == "" {
+		return OSUnknown
+	}
+	return device.OSGuess.OS
+}
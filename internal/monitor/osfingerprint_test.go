@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+const fingerprintMAC = "02:00:00:00:00:0a"
+
+// synEvent returns a connection-opening SYN sent with ttl and window
+func synEvent(t *testing.T, ttl uint8, window uint16) *models.NetworkEvent {
+	t.Helper()
+	evt := tcpEvent(t, fingerprintMAC, "10.0.0.10", "203.0.113.5", 443)
+	evt.TCPFlags, evt.IPTTL, evt.TCPWindow = 0x02, ttl, window
+	return evt
+}
+
+// dhcpEvent returns a DHCP message broadcast from srcPort, its parameter
+// request list asking for params
+func dhcpEvent(t *testing.T, op uint8, srcPort uint16, params ...byte) *models.NetworkEvent {
+	t.Helper()
+	evt := udpEvent(t, fingerprintMAC, "0.0.0.0", "255.255.255.255", dhcpServerPort)
+	evt.SrcPort = srcPort
+
+	payload := make([]byte, 240, 300)
+	payload[0], payload[1], payload[2] = op, 1, 6
+	mac := testMAC(t, fingerprintMAC)
+	copy(payload[28:34], mac[:])
+	copy(payload[236:240], []byte{99, 130, 83, 99})
+	payload = append(payload, 53, 1, 1, 55, byte(len(params)))
+	payload = append(payload, params...)
+	evt.L7Payload = append(payload, 255)
+	return evt
+}
+
+// TestObserveOS feeds the signals of each OS family, alone, repeated and
+// mixed, and checks the guess and its confidence
+func TestObserveOS(t *testing.T) {
+	repeat := func(n int, evt *models.NetworkEvent) []*models.NetworkEvent {
+		events := make([]*models.NetworkEvent, n)
+		for i := range events {
+			events[i] = evt
+		}
+		return events
+	}
+	win10 := []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252}
+	win7 := []byte{1, 15, 3, 6, 44, 46, 47, 31, 33, 121, 249, 43}
+	macOS := []byte{1, 121, 3, 6, 15, 119, 252, 95, 44, 46}
+	iOS := []byte{1, 121, 3, 6, 15, 119, 252}
+	dhclient := []byte{1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42}
+	android := []byte{1, 3, 6, 15, 26, 28, 51, 58, 59, 43}
+	cisco := []byte{1, 6, 15, 44, 3, 33, 150, 43}
+
+	tests := []struct {
+		name       string
+		events     []*models.NetworkEvent
+		os         string
+		confidence string
+		evidence   int
+	}{
+		// Initial TTL and window size of a SYN
+		{"Windows 10 SYN", []*models.NetworkEvent{synEvent(t, 117, 64240)}, OSWindows, models.ConfidenceLow, 1},
+		{"Windows 7 SYN", []*models.NetworkEvent{synEvent(t, 128, 65535)}, OSWindows, models.ConfidenceLow, 1},
+		{"Linux SYN", []*models.NetworkEvent{synEvent(t, 61, 29200)}, OSLinux, models.ConfidenceLow, 1},
+		{"Linux 2.6 SYN", []*models.NetworkEvent{synEvent(t, 64, 5840)}, OSLinux, models.ConfidenceLow, 1},
+		{"Apple SYN", []*models.NetworkEvent{synEvent(t, 64, 65535)}, OSApple, models.ConfidenceLow, 1},
+		{"Cisco SYN", []*models.NetworkEvent{synEvent(t, 250, 4128)}, OSNetworkDevice, models.ConfidenceLow, 1},
+
+		// DHCP parameter request lists
+		{"Windows 10 DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, win10...)}, OSWindows, models.ConfidenceLow, 1},
+		{"Windows 7 DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, win7...)}, OSWindows, models.ConfidenceLow, 1},
+		{"macOS DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, macOS...)}, OSApple, models.ConfidenceLow, 1},
+		{"iOS DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, iOS...)}, OSApple, models.ConfidenceLow, 1},
+		{"dhclient DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, dhclient...)}, OSLinux, models.ConfidenceLow, 1},
+		{"Android DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, android...)}, OSLinux, models.ConfidenceLow, 1},
+		{"Cisco DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, cisco...)}, OSNetworkDevice, models.ConfidenceLow, 1},
+
+		// Protocol presence
+		{"NetBIOS", []*models.NetworkEvent{udpEvent(t, fingerprintMAC, "10.0.0.10", "10.0.0.255", 137)}, OSWindows, models.ConfidenceLow, 1},
+
+		// Confidence grows with agreeing evidence
+		{"repeated SYN", repeat(2, synEvent(t, 64, 29200)), OSLinux, models.ConfidenceMedium, 1},
+		{"saturated SYN and DHCP",
+			append(repeat(8, synEvent(t, 128, 64240)), dhcpEvent(t, 1, dhcpClientPort, win10...)),
+			OSWindows, models.ConfidenceHigh, 2},
+
+		// Ambiguous signals make weak guesses or none
+		{"TTL only", []*models.NetworkEvent{synEvent(t, 64, 1024)}, OSUnixLike, models.ConfidenceLow, 1},
+		{"TTL only refined by DHCP",
+			[]*models.NetworkEvent{synEvent(t, 64, 1024), dhcpEvent(t, 1, dhcpClientPort, dhclient...)},
+			OSLinux, models.ConfidenceLow, 2},
+		{"mDNS only", []*models.NetworkEvent{udpEvent(t, fingerprintMAC, "10.0.0.10", "224.0.0.251", 5353)}, OSApple, models.ConfidenceLow, 1},
+		{"mDNS outweighed", []*models.NetworkEvent{
+			udpEvent(t, fingerprintMAC, "10.0.0.10", "224.0.0.251", 5353),
+			synEvent(t, 128, 64240),
+		}, OSWindows, models.ConfidenceLow, 2},
+		{"conflicting SYN and DHCP", []*models.NetworkEvent{
+			synEvent(t, 64, 65535),
+			dhcpEvent(t, 1, dhcpClientPort, dhclient...),
+		}, OSApple, models.ConfidenceLow, 2},
+		{"unknown DHCP list", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort, 1, 3, 6)}, OSUnknown, "", 0},
+		{"DHCP without list", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpClientPort)}, OSUnknown, "", 0},
+		{"DHCP server reply", []*models.NetworkEvent{dhcpEvent(t, 2, dhcpServerPort, win10...)}, OSUnknown, "", 0},
+		{"relayed DHCP", []*models.NetworkEvent{dhcpEvent(t, 1, dhcpServerPort, win10...)}, OSUnknown, "", 0},
+		{"SYN-ACK", []*models.NetworkEvent{func() *models.NetworkEvent {
+			evt := synEvent(t, 128, 64240)
+			evt.TCPFlags = 0x12
+			return evt
+		}()}, OSUnknown, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := &models.DeviceInfo{}
+			for _, evt := range tt.events {
+				observeOS(device, evt)
+			}
+			if got := DeviceOS(device); got != tt.os {
+				t.Errorf("DeviceOS = %q, want %q", got, tt.os)
+			}
+			if device.OSGuess == nil {
+				if tt.evidence != 0 {
+					t.Errorf("no guess, want %d evidence", tt.evidence)
+				}
+				return
+			}
+			if device.OSGuess.Confidence != tt.confidence || len(device.OSGuess.Evidence) != tt.evidence {
+				t.Errorf("confidence %q with %d evidence, want %q with %d",
+					device.OSGuess.Confidence, len(device.OSGuess.Evidence), tt.confidence, tt.evidence)
+			}
+		})
+	}
+}
+
+// An established guess only switches when contradicting evidence is
+// osSwitchRatio times stronger
+func TestOSGuessSticky(t *testing.T) {
+	device := &models.DeviceInfo{}
+	apple := synEvent(t, 64, 65535)
+	linux := dhcpEvent(t, 1, dhcpClientPort, 1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42)
+
+	observeOS(device, apple)
+	// Twice as strong after the second list
+	for i, want := range []string{OSApple, OSLinux} {
+		observeOS(device, linux)
+		if got := DeviceOS(device); got != want {
+			t.Errorf("after %d DHCP lists: %q, want %q", i+1, got, want)
+		}
+	}
+}
+
+// A signature table entry names a known family with a positive weight
+func TestOSSignatureTables(t *testing.T) {
+	families := map[string]bool{OSWindows: true, OSLinux: true, OSApple: true, OSUnixLike: true, OSNetworkDevice: true}
+	check := func(table, key string, sig osSignature) {
+		if !families[sig.os] || sig.weight <= 0 {
+			t.Errorf("%s %s = %+v", table, key, sig)
+		}
+	}
+	for key, sig := range synSignatures {
+		check("SYN", fmt.Sprint(key), sig)
+	}
+	for ttl, sig := range ttlSignatures {
+		check("TTL", fmt.Sprint(ttl), sig)
+	}
+	for list, sig := range dhcpSignatures {
+		check("DHCP", list, sig)
+	}
+}
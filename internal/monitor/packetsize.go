@@ -0,0 +1,70 @@
+package monitor
+
+import "github.com/zrougamed/cerberus/internal/models"
+
+// sizeBuckets is the number of packet size histogram buckets
+const sizeBuckets = 4
+
+// sizeBucket returns the histogram bucket of a frame length: under 64 bytes,
+// 64-511, 512-1514 and over 1514
+func sizeBucket(length uint16) int {
+	switch {
+	case length < 64:
+		return 0
+	case length < 512:
+		return 1
+	case length <= 1514:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// addPacketSizes adds n packets to a bucket of a histogram
+func addPacketSizes(h *models.SizeHistogram, bucket int, n uint64) {
+	switch bucket {
+	case 0:
+		h.Under64 += n
+	case 1:
+		h.To511 += n
+	case 2:
+		h.To1514 += n
+	default:
+		h.Over1514 += n
+	}
+}
+
+// recordPacketSize adds one packet to the device's size histogram. Events of
+// BPF objects that don't report the length are left out.
+func recordPacketSize(device *models.DeviceInfo, length uint16) {
+	if length == 0 {
+		return
+	}
+	if device.PacketSizes == nil {
+		device.PacketSizes = &models.SizeHistogram{}
+	}
+	addPacketSizes(device.PacketSizes, sizeBucket(length), 1)
+}
+
+// recordPacketSizes adds n packets of the same length to the device's size
+// histogram
+func recordPacketSizes(device *models.DeviceInfo, length uint16, n uint64) {
+	if device.PacketSizes == nil {
+		device.PacketSizes = &models.SizeHistogram{}
+	}
+	addPacketSizes(device.PacketSizes, sizeBucket(length), n)
+}
+
+// mergePacketSizes adds the size histogram of src into dst
+func mergePacketSizes(dst, src *models.DeviceInfo) {
+	if src.PacketSizes == nil {
+		return
+	}
+	if dst.PacketSizes == nil {
+		dst.PacketSizes = &models.SizeHistogram{}
+	}
+	dst.PacketSizes.Under64 += src.PacketSizes.Under64
+	dst.PacketSizes.To511 += src.PacketSizes.To511
+	dst.PacketSizes.To1514 += src.PacketSizes.To1514
+	dst.PacketSizes.Over1514 += src.PacketSizes.Over1514
+}
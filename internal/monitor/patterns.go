@@ -0,0 +1,176 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// PatternKeyPrefix prefixes persisted communication patterns in the database.
+const PatternKeyPrefix = "pattern:"
+
+// DefaultPatternRetention is how long persisted patterns are kept by default
+const DefaultPatternRetention = 90 * 24 * time.Hour
+
+// maxPendingPatterns bounds the patterns buffered between persists
+const maxPendingPatterns = 100000
+
+// maxPatternAnnotations bounds the annotations stored on one pattern
+const maxPatternAnnotations = 16
+
+// maxPendingAnnotations bounds the patterns with annotations awaiting the next persist
+const maxPendingAnnotations = 10000
+
+// maxAnomalyPatterns bounds the contributing patterns referenced by one anomaly
+const maxAnomalyPatterns = 20
+
+var patternSeq atomic.Uint64
+
+type pendingPattern struct {
+	key     string
+	pattern *models.CommunicationPattern
+}
+
+// patternKey orders persisted patterns by time; the sequence keeps keys unique
+func patternKey(ts time.Time) string {
+	return fmt.Sprintf("%s%020d:%06d", PatternKeyPrefix, ts.UnixNano(), patternSeq.Add(1)%1000000)
+}
+
+// patternKeyAt returns the smallest pattern key at or after ts
+func patternKeyAt(ts time.Time) string {
+	return fmt.Sprintf("%s%020d", PatternKeyPrefix, ts.UnixNano())
+}
+
+// SetPatternRetention sets how long persisted patterns are kept; 0 keeps them forever
+func (nm *NetworkMonitor) SetPatternRetention(retention time.Duration) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.patternRetention = retention
+}
+
+// queuePattern buffers a new pattern for the next persist. Must hold nm.mu.
+func (nm *NetworkMonitor) queuePattern(pattern *models.CommunicationPattern) {
+	if len(nm.pendingPatterns) >= maxPendingPatterns {
+		nm.pendingPatterns = nm.pendingPatterns[1:]
+		nm.Stats.DroppedPatterns.Add(1)
+	}
+	nm.pendingPatterns = append(nm.pendingPatterns, pendingPattern{
+		key:     pattern.ID,
+		pattern: pattern,
+	})
+}
+
+// addAnnotation appends an annotation unless the pattern already carries it or
+// is full, reporting whether it was added
+func addAnnotation(annotations []models.Annotation, annotation models.Annotation) ([]models.Annotation, bool) {
+	if len(annotations) >= maxPatternAnnotations || slices.Contains(annotations, annotation) {
+		return annotations, false
+	}
+	return append(annotations, annotation), true
+}
+
+// queueAnnotation records an annotation for a pattern, applied to its database
+// record on the next persist. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) queueAnnotation(patternID string, annotation models.Annotation) {
+	if nm.annotations == nil {
+		nm.annotations = make(map[string][]models.Annotation)
+	}
+	annotations, ok := nm.annotations[patternID]
+	if !ok && len(nm.annotations) >= maxPendingAnnotations {
+		return
+	}
+	nm.annotations[patternID], _ = addAnnotation(annotations, annotation)
+}
+
+// requeueAnnotations puts annotations from a failed persist back
+func (nm *NetworkMonitor) requeueAnnotations(pending map[string][]models.Annotation) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	for patternID, annotations := range pending {
+		for _, annotation := range annotations {
+			nm.queueAnnotation(patternID, annotation)
+		}
+	}
+}
+
+// writePatterns persists new patterns and applies pending annotations, both to
+// patterns in this batch and to ones persisted earlier
+func writePatterns(tx *buntdb.Tx, patterns []pendingPattern, annotations map[string][]models.Annotation, opts *buntdb.SetOptions) error {
+	applied := make(map[string]bool)
+
+	for _, pending := range patterns {
+		pattern := *pending.pattern
+		for _, annotation := range annotations[pending.key] {
+			pattern.Annotations, _ = addAnnotation(pattern.Annotations, annotation)
+		}
+		applied[pending.key] = true
+
+		data, _ := json.Marshal(&pattern)
+		if _, _, err := tx.Set(pending.key, string(data), opts); err != nil {
+			return err
+		}
+	}
+
+	for key, pending := range annotations {
+		if applied[key] {
+			continue
+		}
+
+		value, err := tx.Get(key)
+		if err == buntdb.ErrNotFound {
+			continue // Expired, or dropped before it was persisted
+		}
+		if err != nil {
+			return err
+		}
+
+		var pattern models.CommunicationPattern
+		if json.Unmarshal([]byte(value), &pattern) != nil {
+			continue
+		}
+		changed := false
+		for _, annotation := range pending {
+			var added bool
+			pattern.Annotations, added = addAnnotation(pattern.Annotations, annotation)
+			changed = changed || added
+		}
+		if !changed {
+			continue
+		}
+
+		// Keep the remaining retention rather than restarting it
+		var keep *buntdb.SetOptions
+		if ttl, err := tx.TTL(key); err == nil && ttl > 0 {
+			keep = &buntdb.SetOptions{Expires: true, TTL: ttl}
+		}
+		data, _ := json.Marshal(&pattern)
+		if _, _, err := tx.Set(key, string(data), keep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requeuePatterns puts patterns from a failed persist back ahead of newer ones
+func (nm *NetworkMonitor) requeuePatterns(patterns []pendingPattern) {
+	if len(patterns) == 0 {
+		return
+	}
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	merged := append(patterns, nm.pendingPatterns...)
+	if overflow := len(merged) - maxPendingPatterns; overflow > 0 {
+		merged = merged[overflow:]
+		nm.Stats.DroppedPatterns.Add(uint64(overflow))
+	}
+	nm.pendingPatterns = merged
+}
@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"sync"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils/ring"
+)
+
+// maxRecentPatterns bounds the new patterns kept to replay to subscribers
+const maxRecentPatterns = 1000
+
+// patternSubscribers receives new patterns as they are recorded. Each
+// subscriber's match runs before a pattern is queued to it, so patterns it
+// doesn't want cost it nothing more.
+type patternSubscribers struct {
+	mu     sync.Mutex
+	subs   map[chan *models.CommunicationPattern]func(*models.CommunicationPattern) bool
+	recent *ring.Buffer[*models.CommunicationPattern] // The latest patterns published
+}
+
+func newPatternSubscribers() *patternSubscribers {
+	return &patternSubscribers{
+		subs:   make(map[chan *models.CommunicationPattern]func(*models.CommunicationPattern) bool),
+		recent: ring.New[*models.CommunicationPattern](maxRecentPatterns),
+	}
+}
+
+// SubscribePatterns returns a channel receiving every new pattern from now on
+// that match accepts (all if nil), and a function that ends the subscription.
+// match may change its answer over time; it must not block. With history, it
+// also returns the latest patterns published (oldest first), whatever match
+// says; none is missed or repeated in between.
+func (nm *NetworkMonitor) SubscribePatterns(match func(*models.CommunicationPattern) bool, history bool) ([]*models.CommunicationPattern, <-chan *models.CommunicationPattern, func()) {
+	sub := make(chan *models.CommunicationPattern, 64)
+
+	nm.patternSubs.mu.Lock()
+	var recent []*models.CommunicationPattern
+	if history {
+		recent = nm.patternSubs.recent.Values()
+	}
+	nm.patternSubs.subs[sub] = match
+	nm.patternSubs.mu.Unlock()
+
+	unsubscribe := func() {
+		nm.patternSubs.mu.Lock()
+		defer nm.patternSubs.mu.Unlock()
+		if _, ok := nm.patternSubs.subs[sub]; ok {
+			delete(nm.patternSubs.subs, sub)
+			close(sub)
+		}
+	}
+	return recent, sub, unsubscribe
+}
+
+// publishPattern hands a new pattern to the subscribers accepting it and
+// keeps it for replay. Unlike console notifications, subscribers aren't
+// throttled.
+func (nm *NetworkMonitor) publishPattern(pattern *models.CommunicationPattern) {
+	nm.patternSubs.mu.Lock()
+	defer nm.patternSubs.mu.Unlock()
+	nm.patternSubs.recent.Add(pattern)
+	for sub, match := range nm.patternSubs.subs {
+		if match != nil && !match(pattern) {
+			continue
+		}
+		// Slow subscribers miss patterns rather than hold back the others
+		select {
+		case sub <- pattern:
+		default:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	"math"
+	"math/bits"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// portMinFlows is how many flows a device needs before its source port
+// behavior is assessed
+const portMinFlows = 32
+
+// portWindowFlows is the length of a distinct-port window, in flows
+const portWindowFlows = 256
+
+// portSketchBits is the size of the linear-counting bitmap, well above
+// portWindowFlows so estimates stay close, as a power of two
+const (
+	portSketchShift = 10
+	portSketchBits  = 1 << portSketchShift
+)
+
+// portSmoothing is the weight of each flow in the running shares once a
+// device has enough flows; before that every flow weighs the same
+const portSmoothing = 1.0 / portMinFlows
+
+// portSequentialStep is the largest step above the previous port that counts
+// as sequential. Sequential stacks step by one per connection; a few
+// connections of other programs may come in between.
+const portSequentialStep = 8
+
+// Shares from which the ports of a device are assessed sequential or reused
+const (
+	portSequentialShare = 0.5
+	portReuseShare      = 0.5
+)
+
+// sequentialPortsSignature is the OS evidence of highly sequential source
+// ports, counted once per window while they stay sequential
+var sequentialPortsSignature = osSignature{OSEmbedded, 2}
+
+// observePortBehavior counts the source port of a flow a device opens: a TCP
+// SYN, or a DNS query. It takes constant time.
+func observePortBehavior(device *models.DeviceInfo, evt *models.NetworkEvent, now time.Time) {
+	if evt.SrcPort == 0 || evt.SrcPort == evt.DstPort {
+		return // Not an ephemeral port
+	}
+
+	switch {
+	case evt.EventType == models.EVENT_TYPE_TCP && evt.TCPFlags&0x12 == 0x02:
+	case (evt.EventType == models.EVENT_TYPE_UDP || evt.EventType == models.EVENT_TYPE_DNS) && evt.DstPort == 53:
+	default:
+		return
+	}
+
+	if device.PortBehavior == nil {
+		device.PortBehavior = &models.PortBehavior{}
+	}
+	if scorePortFlow(device.PortBehavior, evt.SrcPort) {
+		addOSEvidence(device, "sequential source ports", sequentialPortsSignature)
+	}
+	device.PortBehavior.UpdatedAt = now
+}
+
+// scorePortFlow adds a flow from port to the statistics. It reports whether a
+// window just closed with the ports assessed sequential.
+func scorePortFlow(b *models.PortBehavior, port uint16) bool {
+	b.Flows++
+	b.WindowFlows++
+	weight := max(portSmoothing, 1/float64(b.Flows))
+
+	// Comparing with every recent port rather than the last one keeps the
+	// ports of a sequential stack recognizable between those of others
+	sequential, reused := 0.0, 0.0
+	for _, recent := range b.Recent[:min(b.Flows-1, len(b.Recent))] {
+		if step := port - recent; step >= 1 && step <= portSequentialStep {
+			sequential = 1
+		}
+		if recent == port {
+			reused = 1
+		}
+	}
+	b.Sequentiality += weight * (sequential - b.Sequentiality)
+	b.ReuseRate += weight * (reused - b.ReuseRate)
+	b.Recent[b.RecentNext] = port
+	b.RecentNext = (b.RecentNext + 1) % len(b.Recent)
+
+	bit := hashPort(port) >> (32 - portSketchShift)
+	b.Sketch[bit/64] |= 1 << (bit % 64)
+
+	closed := false
+	if b.WindowFlows >= portWindowFlows {
+		b.DistinctPorts = distinctPorts(&b.Sketch)
+		b.Sketch = [len(b.Sketch)]uint64{}
+		b.WindowFlows = 0
+		closed = true
+	} else if b.Flows < portWindowFlows {
+		b.DistinctPorts = distinctPorts(&b.Sketch)
+	}
+
+	b.Assessment = assessPorts(b)
+	return closed && b.Assessment == models.PortsSequential
+}
+
+// hashPort mixes the bits of a port so that neighboring ports land on
+// unrelated bits, as linear counting assumes
+func hashPort(port uint16) uint32 {
+	h := uint32(port)
+	h ^= h >> 16
+	h *= 0x7feb352d
+	h ^= h >> 15
+	h *= 0x846ca68b
+	h ^= h >> 16
+	return h
+}
+
+// distinctPorts estimates the distinct ports set in a linear-counting bitmap
+func distinctPorts(sketch *[16]uint64) int {
+	set := 0
+	for _, word := range sketch {
+		set += bits.OnesCount64(word)
+	}
+	empty := portSketchBits - set
+	if empty == 0 {
+		empty = 1 // Saturated; the estimate is a lower bound
+	}
+	return int(math.Round(float64(portSketchBits) * math.Log(float64(portSketchBits)/float64(empty))))
+}
+
+// assessPorts classifies the source port behavior of a device from its
+// statistics
+func assessPorts(b *models.PortBehavior) string {
+	switch {
+	case b.Flows < portMinFlows:
+		return models.PortsInsufficientData
+	case b.Sequentiality >= portSequentialShare:
+		return models.PortsSequential
+	case b.ReuseRate >= portReuseShare:
+		return models.PortsReused
+	}
+	return models.PortsRandomized
+}
+
+// clonePortBehavior returns a copy of port statistics, or nil
+func clonePortBehavior(b *models.PortBehavior) *models.PortBehavior {
+	if b == nil {
+		return nil
+	}
+	clone := *b
+	return &clone
+}
+
+// mergePortBehavior keeps the port statistics of whichever device counted
+// more flows; streaming statistics can't be combined
+func mergePortBehavior(dst, src *models.DeviceInfo) {
+	if src.PortBehavior != nil && (dst.PortBehavior == nil || src.PortBehavior.Flows > dst.PortBehavior.Flows) {
+		dst.PortBehavior = clonePortBehavior(src.PortBehavior)
+	}
+}
+
+// DevicePortBehavior returns the source port statistics of a device, which
+// report insufficient data until it opened enough flows
+func (nm *NetworkMonitor) DevicePortBehavior(id string) (*models.PortBehavior, bool) {
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+	if device.PortBehavior == nil {
+		return &models.PortBehavior{Assessment: models.PortsInsufficientData}, true
+	}
+	behavior := clonePortBehavior(device.PortBehavior)
+	behavior.Assessment = assessPorts(behavior)
+	return behavior, true
+}
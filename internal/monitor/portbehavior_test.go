@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// sequentialPorts returns n ports counting up, as a sequential stack picks them
+func sequentialPorts(n int) []uint16 {
+	ports := make([]uint16, n)
+	for i := range ports {
+		ports[i] = uint16(49152 + i)
+	}
+	return ports
+}
+
+// randomPorts returns n ports picked at random, seeded so the statistics are
+// reproducible
+func randomPorts(rng *rand.Rand, n int) []uint16 {
+	ports := make([]uint16, n)
+	for i := range ports {
+		ports[i] = uint16(32768 + rng.IntN(28232)) // Linux's default ephemeral range
+	}
+	return ports
+}
+
+// mixedPorts interleaves a sequential stack with a randomizing one: the
+// sequential stack opens sequential flows out of every run of of
+func mixedPorts(rng *rand.Rand, n, sequential, of int) []uint16 {
+	ports := randomPorts(rng, n)
+	next := uint16(10000)
+	for i := range ports {
+		if i%of < sequential {
+			ports[i] = next
+			next++
+		}
+	}
+	return ports
+}
+
+// scorePorts scores a sequence of flows, returning the statistics and the
+// windows closed with the ports sequential
+func scorePorts(ports []uint16) (*models.PortBehavior, int) {
+	b := &models.PortBehavior{}
+	windows := 0
+	for _, port := range ports {
+		if scorePortFlow(b, port) {
+			windows++
+		}
+	}
+	return b, windows
+}
+
+// Sequences are assessed from their sequentiality and reuse, and the distinct
+// ports of each window are estimated within a few percent
+func TestScorePortFlow(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	reusedDNS := make([]uint16, 300)
+	for i := range reusedDNS {
+		reusedDNS[i] = 5353
+	}
+	// Two ports in turn, as a stack reusing a small pool would
+	pool := make([]uint16, 300)
+	for i := range pool {
+		pool[i] = uint16(40000 + i%2*1000)
+	}
+
+	tests := []struct {
+		name                 string
+		ports                []uint16
+		assessment           string
+		minSeq, maxSeq       float64
+		minReuse, maxReuse   float64
+		minDistinct, maxDist int
+		windows              int // Windows closed with the ports sequential
+	}{
+		{"sequential", sequentialPorts(600), models.PortsSequential, 0.99, 1, 0, 0, 240, 272, 2},
+		{"random", randomPorts(rng, 600), models.PortsRandomized, 0, 0.05, 0, 0.05, 240, 272, 0},
+		{"mixed, mostly sequential", mixedPorts(rng, 600, 2, 3), models.PortsSequential, 0.55, 0.8, 0, 0.05, 240, 272, 2},
+		{"mixed, mostly random", mixedPorts(rng, 600, 1, 4), models.PortsRandomized, 0.1, 0.4, 0, 0.05, 240, 272, 0},
+		{"one port", reusedDNS, models.PortsReused, 0, 0, 0.99, 1, 1, 1, 0},
+		{"small pool", pool, models.PortsReused, 0, 0, 0.99, 1, 2, 2, 0},
+		{"too few", sequentialPorts(portMinFlows - 1), models.PortsInsufficientData, 0.9, 1, 0, 0, portMinFlows - 2, portMinFlows, 0},
+		{"just enough", sequentialPorts(portMinFlows), models.PortsSequential, 0.9, 1, 0, 0, portMinFlows - 1, portMinFlows + 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, windows := scorePorts(tt.ports)
+			if b.Assessment != tt.assessment {
+				t.Errorf("assessment = %q, want %q (%+v)", b.Assessment, tt.assessment, b)
+			}
+			if b.Sequentiality < tt.minSeq || b.Sequentiality > tt.maxSeq {
+				t.Errorf("sequentiality = %.3f, want %.2f to %.2f", b.Sequentiality, tt.minSeq, tt.maxSeq)
+			}
+			if b.ReuseRate < tt.minReuse || b.ReuseRate > tt.maxReuse {
+				t.Errorf("reuse = %.3f, want %.2f to %.2f", b.ReuseRate, tt.minReuse, tt.maxReuse)
+			}
+			if b.DistinctPorts < tt.minDistinct || b.DistinctPorts > tt.maxDist {
+				t.Errorf("distinct = %d, want %d to %d", b.DistinctPorts, tt.minDistinct, tt.maxDist)
+			}
+			if windows != tt.windows {
+				t.Errorf("%d sequential windows, want %d", windows, tt.windows)
+			}
+			if b.Flows != len(tt.ports) || b.WindowFlows != len(tt.ports)%portWindowFlows {
+				t.Errorf("flows = %d (%d in the window)", b.Flows, b.WindowFlows)
+			}
+		})
+	}
+}
+
+// A stack that turns to randomizing is assessed randomized within a few
+// windows, as recent flows weigh more
+func TestScorePortFlowRecovers(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	b, _ := scorePorts(sequentialPorts(1000))
+	for _, port := range randomPorts(rng, 4*portMinFlows) {
+		scorePortFlow(b, port)
+	}
+	if b.Assessment != models.PortsRandomized || b.Sequentiality > 0.1 {
+		t.Errorf("after randomizing: %q, sequentiality %.3f", b.Assessment, b.Sequentiality)
+	}
+}
+
+// Only the flows a device opens are counted, and sequential windows count as
+// embedded stack evidence
+func TestObservePortBehavior(t *testing.T) {
+	mac := "02:00:00:00:00:0a"
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	event := func(eventType uint8, srcPort, dstPort uint16, flags uint8) *models.NetworkEvent {
+		evt := tcpEvent(t, mac, "192.168.1.10", "192.168.1.1", dstPort)
+		evt.EventType, evt.SrcPort, evt.TCPFlags = eventType, srcPort, flags
+		return evt
+	}
+
+	tests := []struct {
+		name    string
+		evt     *models.NetworkEvent
+		counted bool
+	}{
+		{"syn", event(models.EVENT_TYPE_TCP, 40000, 443, 0x02), true},
+		{"syn-ack", event(models.EVENT_TYPE_TCP, 443, 40000, 0x12), false},
+		{"ack", event(models.EVENT_TYPE_TCP, 40000, 443, 0x10), false},
+		{"dns query", event(models.EVENT_TYPE_DNS, 40000, 53, 0), true},
+		{"udp dns query", event(models.EVENT_TYPE_UDP, 40000, 53, 0), true},
+		{"dns response", event(models.EVENT_TYPE_DNS, 53, 40000, 0), false},
+		{"other udp", event(models.EVENT_TYPE_UDP, 40000, 123, 0), false},
+		{"same ports", event(models.EVENT_TYPE_UDP, 53, 53, 0), false},
+		{"no port", event(models.EVENT_TYPE_TCP, 0, 443, 0x02), false},
+	}
+	for _, tt := range tests {
+		device := &models.DeviceInfo{ID: mac}
+		observePortBehavior(device, tt.evt, now)
+		if counted := device.PortBehavior != nil && device.PortBehavior.Flows == 1; counted != tt.counted {
+			t.Errorf("%s counted %v, want %v", tt.name, counted, tt.counted)
+		}
+	}
+
+	device := &models.DeviceInfo{ID: mac}
+	for _, port := range sequentialPorts(2 * portWindowFlows) {
+		observePortBehavior(device, event(models.EVENT_TYPE_TCP, port, 443, 0x02), now)
+	}
+	if device.OSGuess == nil || len(device.OSGuess.Evidence) != 1 || device.OSGuess.Evidence[0].Count != 2 || device.OSGuess.Evidence[0].OS != OSEmbedded {
+		t.Errorf("OS guess = %+v, want two windows of embedded evidence", device.OSGuess)
+	}
+	if !device.PortBehavior.UpdatedAt.Equal(now) {
+		t.Errorf("updated at %v", device.PortBehavior.UpdatedAt)
+	}
+}
+
+// Devices without flows report insufficient data rather than nothing
+func TestDevicePortBehavior(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 443))
+	if b, ok := nm.DevicePortBehavior(mac); !ok || b.Assessment != models.PortsInsufficientData || b.Flows != 0 {
+		t.Errorf("without flows = %+v, %v", b, ok)
+	}
+	for _, port := range sequentialPorts(portMinFlows) {
+		evt := tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 443)
+		evt.SrcPort, evt.TCPFlags = port, 0x02
+		nm.TrackEvent(evt)
+	}
+	if b, ok := nm.DevicePortBehavior(mac); !ok || b.Assessment != models.PortsSequential || b.Flows != portMinFlows {
+		t.Errorf("after %d connections = %+v, %v", portMinFlows, b, ok)
+	}
+	if _, ok := nm.DevicePortBehavior("02:00:00:00:00:ff"); ok {
+		t.Error("port behavior of an unknown device")
+	}
+}
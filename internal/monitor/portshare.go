@@ -0,0 +1,414 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// PortHistory is how far back the per-port traffic of each device is kept, and
+// the longest window DevicePorts reports
+const PortHistory = time.Hour
+
+// portBucketWidth is the granularity of per-port traffic, and so of windows
+const portBucketWidth = time.Minute
+
+// portMaxDevices bounds the devices with per-port traffic at once
+const portMaxDevices = 10000
+
+// portMaxKeys bounds the ports counted per device and bucket; the traffic of
+// later ones still adds to the totals
+const portMaxKeys = 256
+
+// icmpHeaderBytes is the Ethernet, option-less IPv4 and ICMP header length; the
+// rest of an ICMP frame is payload
+const icmpHeaderBytes = 14 + 20 + 8
+
+// portICMP is the port key of ICMP traffic, which has no ports
+const portICMP = "ICMP"
+
+// Infrastructure roles, whose own service traffic is exempt from port share
+// detection
+const (
+	RoleDNS        = "dns"        // Resolver: TCP/53 and UDP/53
+	RoleNTP        = "ntp"        // Time server: UDP/123
+	RoleMonitoring = "monitoring" // Pings other hosts: ICMP share and payload volume
+)
+
+// roleExemptions lists the ports each infrastructure role is exempt for
+var roleExemptions = map[string][]string{
+	RoleDNS:        {"UDP/53", "TCP/53"},
+	RoleNTP:        {"UDP/123"},
+	RoleMonitoring: {portICMP},
+}
+
+// PortShareConfig controls detection of tunneling over normally minor ports
+type PortShareConfig struct {
+	Window       time.Duration     // Recent traffic judged, against the rest of PortHistory
+	MinBytes     uint64            // Devices sending less within Window, or before it, aren't judged
+	MaxShare     float64           // Share of a watched port in the bytes of Window that raises an anomaly...
+	MinorShare   float64           // ...when its share before Window was at most this
+	Watch        []string          // Ports judged, as PROTO/port or ICMP
+	ICMPMaxBytes uint64            // ICMP payload bytes within Window that raise an anomaly (0 disables)
+	Roles        map[string]string // Device MAC or IP -> infrastructure role
+}
+
+// DefaultPortShareConfig returns the default port share detection settings
+func DefaultPortShareConfig() PortShareConfig {
+	return PortShareConfig{
+		Window:       5 * time.Minute,
+		MinBytes:     256 << 10,
+		MaxShare:     0.5,
+		MinorShare:   0.1,
+		Watch:        []string{"UDP/53", "TCP/53", "UDP/123", portICMP},
+		ICMPMaxBytes: 1 << 20,
+	}
+}
+
+// ParsePortWatch parses a comma-separated list of ports such as
+// "UDP/53,TCP/53,ICMP"
+func ParsePortWatch(s string) ([]string, error) {
+	var ports []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		if item == portICMP {
+			ports = append(ports, item)
+			continue
+		}
+		proto, port, ok := strings.Cut(item, "/")
+		n, err := strconv.ParseUint(port, 10, 16)
+		if !ok || (proto != "TCP" && proto != "UDP") || err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port %q: expected TCP/<port>, UDP/<port> or ICMP", item)
+		}
+		ports = append(ports, proto+"/"+strconv.FormatUint(n, 10))
+	}
+	return ports, nil
+}
+
+// ParseInfraRoles parses a comma-separated list of <MAC or IP>=<role> pairs,
+// such as "10.0.0.53=dns,aa:bb:cc:dd:ee:ff=monitoring"
+func ParseInfraRoles(s string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, role, ok := strings.Cut(item, "=")
+		role = strings.ToLower(strings.TrimSpace(role))
+		if !ok {
+			return nil, fmt.Errorf("invalid role %q: expected <MAC or IP>=<role>", item)
+		}
+		if _, known := roleExemptions[role]; !known {
+			return nil, fmt.Errorf("unknown role %q: expected %s, %s or %s", role, RoleDNS, RoleNTP, RoleMonitoring)
+		}
+		addr = strings.TrimSpace(addr)
+		if mac, err := net.ParseMAC(addr); err == nil && len(mac) == 6 {
+			roles[mac.String()] = role
+		} else if ip := net.ParseIP(addr); ip != nil {
+			roles[ip.String()] = role
+		} else {
+			return nil, fmt.Errorf("invalid device %q: expected a MAC or an IP", addr)
+		}
+	}
+	return roles, nil
+}
+
+type portCount struct {
+	packets uint64
+	bytes   uint64
+}
+
+type portBucket struct {
+	start       time.Time
+	total       portCount
+	icmpPayload uint64
+	ports       map[string]*portCount
+}
+
+type portProfile struct {
+	buckets []*portBucket   // Oldest first
+	alerted map[string]bool // Ports alerted this episode, and portICMP payload volume
+}
+
+// portShareDetector keeps the per-port traffic of each device in one-minute
+// buckets, and flags devices whose traffic suddenly goes mostly to a port that
+// used to carry little of it, or that send an unusual volume of ICMP payload.
+// Each device is judged when a bucket closes. It is guarded by nm.mu.
+type portShareDetector struct {
+	config  PortShareConfig
+	devices map[string]*portProfile
+}
+
+func newPortShareDetector(config PortShareConfig) *portShareDetector {
+	return &portShareDetector{
+		config:  config,
+		devices: make(map[string]*portProfile),
+	}
+}
+
+// SetPortShareConfig replaces the port share detection settings
+func (nm *NetworkMonitor) SetPortShareConfig(config PortShareConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.portShare.config = config
+}
+
+// portKey returns the destination port of an event, or "" for ARP
+func portKey(evt *models.NetworkEvent) string {
+	switch evt.EventType {
+	case models.EVENT_TYPE_TCP, models.EVENT_TYPE_HTTP, models.EVENT_TYPE_TLS:
+		return "TCP/" + strconv.Itoa(int(evt.DstPort))
+	case models.EVENT_TYPE_UDP, models.EVENT_TYPE_DNS:
+		return "UDP/" + strconv.Itoa(int(evt.DstPort))
+	case models.EVENT_TYPE_ICMP:
+		return portICMP
+	}
+	return ""
+}
+
+// deviceRole returns the infrastructure role of a device, by MAC or else IP
+func (d *portShareDetector) deviceRole(device *models.DeviceInfo) string {
+	if role, ok := d.config.Roles[device.MAC]; ok {
+		return role
+	}
+	return d.config.Roles[device.IP]
+}
+
+// observePorts adds a packet to the per-port traffic of its device, judging
+// the device first when the packet opens a new bucket. Must hold nm.mu.
+func (nm *NetworkMonitor) observePorts(device *models.DeviceInfo, evt *models.NetworkEvent, now time.Time) {
+	if key := portKey(evt); key != "" {
+		nm.countPorts(device, key, 1, uint64(evt.PacketLen), now)
+	}
+}
+
+// countPorts adds packets of length bytes in total to the traffic of a port
+// key of a device, as observePorts does. Must hold nm.mu.
+func (nm *NetworkMonitor) countPorts(device *models.DeviceInfo, key string, packets, length uint64, now time.Time) {
+	d := nm.portShare
+
+	profile := d.devices[device.ID]
+	if profile == nil {
+		if len(d.devices) >= portMaxDevices {
+			d.prune(now)
+		}
+		profile = &portProfile{alerted: make(map[string]bool)}
+		d.devices[device.ID] = profile
+	}
+
+	start := now.Truncate(portBucketWidth)
+	var bucket *portBucket
+	if n := len(profile.buckets); n > 0 && profile.buckets[n-1].start.Equal(start) {
+		bucket = profile.buckets[n-1]
+	} else {
+		if n > 0 {
+			nm.judgePorts(device, profile, start)
+		}
+		bucket = &portBucket{start: start, ports: make(map[string]*portCount)}
+		profile.buckets = append(profile.buckets, bucket)
+		profile.expire(now)
+	}
+
+	bucket.total.packets += packets
+	bucket.total.bytes += length
+	if key == portICMP && length > packets*icmpHeaderBytes {
+		bucket.icmpPayload += length - packets*icmpHeaderBytes
+	}
+	count := bucket.ports[key]
+	if count == nil {
+		if len(bucket.ports) >= portMaxKeys {
+			return
+		}
+		count = &portCount{}
+		bucket.ports[key] = count
+	}
+	count.packets += packets
+	count.bytes += length
+}
+
+// judgePorts compares the shares of the watched ports in the window ending at
+// end with their shares before it, and checks the ICMP payload volume. Each
+// condition is alerted once, until it clears. Must hold nm.mu.
+func (nm *NetworkMonitor) judgePorts(device *models.DeviceInfo, profile *portProfile, end time.Time) {
+	config := nm.portShare.config
+	since := end.Add(-config.Window)
+
+	var recent, before portCount
+	var icmpPayload uint64
+	recentPorts := make(map[string]uint64)
+	beforePorts := make(map[string]uint64)
+	for _, bucket := range profile.buckets {
+		if !bucket.start.Before(end) {
+			continue
+		}
+		if bucket.start.Before(since) {
+			before.bytes += bucket.total.bytes
+			for key, count := range bucket.ports {
+				beforePorts[key] += count.bytes
+			}
+			continue
+		}
+		recent.bytes += bucket.total.bytes
+		icmpPayload += bucket.icmpPayload
+		for key, count := range bucket.ports {
+			recentPorts[key] += count.bytes
+		}
+	}
+
+	exempt := make(map[string]bool)
+	role := nm.portShare.deviceRole(device)
+	for _, port := range roleExemptions[role] {
+		exempt[port] = true
+	}
+
+	// The ICMP payload volume is judged even without a profile to compare with
+	if config.ICMPMaxBytes > 0 && !exempt[portICMP] && icmpPayload > config.ICMPMaxBytes {
+		if !profile.alerted["icmp_payload"] {
+			profile.alerted["icmp_payload"] = true
+			nm.raiseAnomaly("ICMP_PAYLOAD_VOLUME", models.SeverityMedium, device.ID,
+				map[string]string{
+					"device_name": device.Name,
+					"bytes":       strconv.FormatUint(icmpPayload, 10),
+					"window":      config.Window.String(),
+				},
+				map[string]string{
+					"icmp_payload_bytes": strconv.FormatUint(icmpPayload, 10),
+					"max_bytes":          strconv.FormatUint(config.ICMPMaxBytes, 10),
+					"window":             config.Window.String(),
+				})
+		}
+	} else {
+		delete(profile.alerted, "icmp_payload")
+	}
+
+	// Quiet devices, and devices without enough history, aren't judged
+	if recent.bytes < config.MinBytes || before.bytes < config.MinBytes {
+		for _, port := range config.Watch {
+			delete(profile.alerted, port)
+		}
+		return
+	}
+	for _, port := range config.Watch {
+		share := float64(recentPorts[port]) / float64(recent.bytes)
+		if exempt[port] || share < config.MaxShare {
+			delete(profile.alerted, port)
+			continue
+		}
+		previous := float64(beforePorts[port]) / float64(before.bytes)
+		if previous > config.MinorShare || profile.alerted[port] {
+			continue
+		}
+		profile.alerted[port] = true
+		nm.raiseAnomaly("PORT_SHARE_SHIFT", models.SeverityMedium, device.ID,
+			map[string]string{
+				"device_name":    device.Name,
+				"share":          strconv.FormatFloat(share*100, 'f', 0, 64),
+				"port":           port,
+				"window":         config.Window.String(),
+				"previous_share": strconv.FormatFloat(previous*100, 'f', 0, 64),
+			},
+			map[string]string{
+				"port":           port,
+				"share":          strconv.FormatFloat(share, 'f', 3, 64),
+				"previous_share": strconv.FormatFloat(previous, 'f', 3, 64),
+				"bytes":          strconv.FormatUint(recentPorts[port], 10),
+				"window":         config.Window.String(),
+			})
+	}
+}
+
+// expire drops the buckets older than PortHistory
+func (p *portProfile) expire(now time.Time) {
+	cutoff := now.Add(-PortHistory)
+	i := 0
+	for i < len(p.buckets) && !p.buckets[i].start.Add(portBucketWidth).After(cutoff) {
+		i++
+	}
+	p.buckets = p.buckets[i:]
+}
+
+// prune forgets devices without traffic within PortHistory
+func (d *portShareDetector) prune(now time.Time) {
+	for id, profile := range d.devices {
+		profile.expire(now)
+		if len(profile.buckets) == 0 {
+			delete(d.devices, id)
+		}
+	}
+}
+
+// DevicePorts returns what a device sent to each destination port within the
+// last window (at most PortHistory, counted in whole minutes; the detection
+// window when not positive), and false for devices that aren't tracked
+func (nm *NetworkMonitor) DevicePorts(id string, window time.Duration) (*models.PortDistribution, bool) {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	if window <= 0 {
+		window = nm.portShare.config.Window
+	}
+	window = min(window, PortHistory)
+	now := time.Now()
+	since := now.Add(-window)
+
+	dist := &models.PortDistribution{
+		DeviceID: id,
+		Window:   window.String(),
+		From:     since,
+		To:       now,
+		Role:     nm.portShare.deviceRole(device),
+		Ports:    []models.PortTraffic{},
+	}
+	ports := make(map[string]*portCount)
+	if profile := nm.portShare.devices[id]; profile != nil {
+		for _, bucket := range profile.buckets {
+			if !bucket.start.Add(portBucketWidth).After(since) {
+				continue
+			}
+			dist.Packets += bucket.total.packets
+			dist.Bytes += bucket.total.bytes
+			dist.ICMPPayloadBytes += bucket.icmpPayload
+			for key, count := range bucket.ports {
+				sum := ports[key]
+				if sum == nil {
+					sum = &portCount{}
+					ports[key] = sum
+				}
+				sum.packets += count.packets
+				sum.bytes += count.bytes
+			}
+		}
+	}
+
+	for key, count := range ports {
+		traffic := models.PortTraffic{Port: key, Packets: count.packets, Bytes: count.bytes}
+		if dist.Bytes > 0 {
+			traffic.Share = float64(count.bytes) / float64(dist.Bytes)
+		}
+		dist.Ports = append(dist.Ports, traffic)
+	}
+	sort.Slice(dist.Ports, func(i, j int) bool {
+		a, b := dist.Ports[i], dist.Ports[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Packets != b.Packets {
+			return a.Packets > b.Packets
+		}
+		return a.Port < b.Port
+	})
+	return dist, true
+}
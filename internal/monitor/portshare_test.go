@@ -0,0 +1,200 @@
+package monitor
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// portDevice is the device whose port traffic the tests feed
+func portDevice() *models.DeviceInfo {
+	return &models.DeviceInfo{ID: "02:00:00:00:00:0a", MAC: "02:00:00:00:00:0a", IP: "192.168.1.10", Name: "laptop"}
+}
+
+// sendPorts counts a minute of traffic per entry of minutes, each mapping
+// ports to the bytes sent to them, starting at start
+func sendPorts(nm *NetworkMonitor, device *models.DeviceInfo, start time.Time, minutes []map[string]uint64) time.Time {
+	nm.lockAll()
+	defer nm.unlockAll()
+	at := start
+	for _, ports := range minutes {
+		for key, bytes := range ports {
+			nm.countPorts(device, key, max(bytes/1000, 1), bytes, at)
+		}
+		at = at.Add(portBucketWidth)
+	}
+	return at
+}
+
+// repeatMinutes returns n minutes of the same traffic
+func repeatMinutes(n int, ports map[string]uint64) []map[string]uint64 {
+	minutes := make([]map[string]uint64, n)
+	for i := range minutes {
+		minutes[i] = ports
+	}
+	return minutes
+}
+
+// countAnomalies counts the anomalies of a type raised so far
+func countAnomalies(nm *NetworkMonitor, anomalyType string) (n int, last *models.Anomaly) {
+	for _, anomaly := range nm.RecentAnomalies() {
+		if anomaly.Type == anomalyType {
+			n++
+			last = anomaly
+		}
+	}
+	return n, last
+}
+
+var portStart = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// Web traffic that suddenly goes mostly to DNS is flagged once per episode
+func TestPortShareShift(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	device := portDevice()
+	web := map[string]uint64{"TCP/443": 1 << 20, "UDP/53": 4 << 10}
+	tunnel := map[string]uint64{"TCP/443": 64 << 10, "UDP/53": 1 << 20}
+
+	at := sendPorts(nm, device, portStart, repeatMinutes(20, web))
+	if n, _ := countAnomalies(nm, "PORT_SHARE_SHIFT"); n != 0 {
+		t.Fatalf("%d anomalies for steady traffic", n)
+	}
+	at = sendPorts(nm, device, at, repeatMinutes(6, tunnel))
+	n, anomaly := countAnomalies(nm, "PORT_SHARE_SHIFT")
+	if n != 1 || anomaly.Details["port"] != "UDP/53" || anomaly.DeviceID != device.ID {
+		t.Fatalf("%d anomalies, last %+v, want one for UDP/53", n, anomaly)
+	}
+
+	// Not again while the shift lasts, but again after it cleared
+	at = sendPorts(nm, device, at, repeatMinutes(5, tunnel))
+	if n, _ := countAnomalies(nm, "PORT_SHARE_SHIFT"); n != 1 {
+		t.Errorf("%d anomalies while the shift lasts, want 1", n)
+	}
+	at = sendPorts(nm, device, at, repeatMinutes(60, web))
+	sendPorts(nm, device, at, repeatMinutes(6, tunnel))
+	if n, _ := countAnomalies(nm, "PORT_SHARE_SHIFT"); n != 2 {
+		t.Errorf("%d anomalies after a second shift, want 2", n)
+	}
+}
+
+// A port that always carried much of the traffic, or a device too quiet or
+// too new to judge, raises nothing
+func TestPortShareNoShift(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after map[string]uint64
+		history       int
+	}{
+		{"major port", map[string]uint64{"TCP/443": 1 << 20, "UDP/53": 512 << 10}, map[string]uint64{"UDP/53": 1 << 20}, 20},
+		{"unwatched port", map[string]uint64{"TCP/443": 1 << 20}, map[string]uint64{"UDP/5353": 1 << 20}, 20},
+		{"quiet", map[string]uint64{"TCP/443": 1 << 10}, map[string]uint64{"UDP/53": 1 << 10}, 20},
+		{"no history", nil, map[string]uint64{"UDP/53": 1 << 20}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newTestMonitor(t, 16)
+			device := portDevice()
+			at := sendPorts(nm, device, portStart, repeatMinutes(tt.history, tt.before))
+			sendPorts(nm, device, at, repeatMinutes(6, tt.after))
+			if n, anomaly := countAnomalies(nm, "PORT_SHARE_SHIFT"); n != 0 {
+				t.Errorf("%d anomalies, last %+v", n, anomaly)
+			}
+		})
+	}
+}
+
+// Large ICMP payloads are flagged, except from monitoring hosts, and DNS
+// servers may send mostly DNS
+func TestPortShareRoles(t *testing.T) {
+	web := map[string]uint64{"TCP/443": 1 << 20, "UDP/53": 4 << 10}
+	dns := map[string]uint64{"TCP/443": 64 << 10, "UDP/53": 1 << 20}
+	// 1000 pings of 1000 bytes a minute: 958 bytes of payload each
+	pings := map[string]uint64{"TCP/443": 64 << 10, portICMP: 1000 * 1000}
+
+	tests := []struct {
+		name        string
+		roles       map[string]string
+		after       map[string]uint64
+		anomaly     string
+		wantAnomaly bool
+	}{
+		{"icmp volume", nil, pings, "ICMP_PAYLOAD_VOLUME", true},
+		{"monitoring host", map[string]string{"02:00:00:00:00:0a": RoleMonitoring}, pings, "ICMP_PAYLOAD_VOLUME", false},
+		{"dns server by IP", map[string]string{"192.168.1.10": RoleDNS}, dns, "PORT_SHARE_SHIFT", false},
+		{"ntp server", map[string]string{"192.168.1.10": RoleNTP}, dns, "PORT_SHARE_SHIFT", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newTestMonitor(t, 16)
+			config := DefaultPortShareConfig()
+			config.Roles = tt.roles
+			nm.SetPortShareConfig(config)
+			device := portDevice()
+			at := sendPorts(nm, device, portStart, repeatMinutes(20, web))
+			sendPorts(nm, device, at, repeatMinutes(6, tt.after))
+			if n, _ := countAnomalies(nm, tt.anomaly); (n > 0) != tt.wantAnomaly {
+				t.Errorf("%d %s anomalies, want any: %v", n, tt.anomaly, tt.wantAnomaly)
+			}
+		})
+	}
+}
+
+func TestDevicePorts(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	device := portDevice()
+	nm.Cache.Add(device.ID, device)
+
+	// Two minutes ago and now, and one before the hour of history
+	now := time.Now()
+	sendPorts(nm, device, now.Add(-PortHistory-2*portBucketWidth), repeatMinutes(1, map[string]uint64{"TCP/22": 1 << 20}))
+	sendPorts(nm, device, now.Add(-2*portBucketWidth), repeatMinutes(1, map[string]uint64{"TCP/443": 3000, portICMP: 1000}))
+	sendPorts(nm, device, now, repeatMinutes(1, map[string]uint64{"TCP/443": 3000, "UDP/53": 2000, "UDP/123": 1000}))
+
+	dist, ok := nm.DevicePorts(device.ID, 10*time.Minute)
+	if !ok {
+		t.Fatal("device not tracked")
+	}
+	want := []models.PortTraffic{
+		{Port: "TCP/443", Packets: 6, Bytes: 6000, Share: 0.6},
+		{Port: "UDP/53", Packets: 2, Bytes: 2000, Share: 0.2},
+		{Port: portICMP, Packets: 1, Bytes: 1000, Share: 0.1},
+		{Port: "UDP/123", Packets: 1, Bytes: 1000, Share: 0.1},
+	}
+	if dist.Bytes != 10000 || dist.Packets != 10 || !reflect.DeepEqual(dist.Ports, want) {
+		t.Errorf("ports = %d bytes %+v, want 10000 bytes %+v", dist.Bytes, dist.Ports, want)
+	}
+	if dist.ICMPPayloadBytes != 1000-icmpHeaderBytes {
+		t.Errorf("ICMP payload %d, want %d", dist.ICMPPayloadBytes, 1000-icmpHeaderBytes)
+	}
+
+	if _, ok := nm.DevicePorts("02:00:00:00:00:ff", 0); ok {
+		t.Error("unknown device reported")
+	}
+}
+
+func TestParsePortWatch(t *testing.T) {
+	ports, err := ParsePortWatch(" udp/53, TCP/0053 ,icmp,")
+	if err != nil || !reflect.DeepEqual(ports, []string{"UDP/53", "TCP/53", portICMP}) {
+		t.Errorf("ParsePortWatch = %v, %v", ports, err)
+	}
+	for _, s := range []string{"SCTP/53", "UDP/0", "UDP/70000", "UDP", "53"} {
+		if _, err := ParsePortWatch(s); err == nil {
+			t.Errorf("ParsePortWatch(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParseInfraRoles(t *testing.T) {
+	roles, err := ParseInfraRoles("10.0.0.53=DNS, AA:BB:CC:DD:EE:FF=monitoring,")
+	want := map[string]string{"10.0.0.53": RoleDNS, "aa:bb:cc:dd:ee:ff": RoleMonitoring}
+	if err != nil || !reflect.DeepEqual(roles, want) {
+		t.Errorf("ParseInfraRoles = %v, %v, want %v", roles, err, want)
+	}
+	for _, s := range []string{"10.0.0.53", "10.0.0.53=router", "printer=dns"} {
+		if _, err := ParseInfraRoles(s); err == nil {
+			t.Errorf("ParseInfraRoles(%q) succeeded, want an error", s)
+		}
+	}
+}
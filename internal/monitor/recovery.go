@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// ErrReadOnly is returned by operations that would write to a database opened
+// read-only
+var ErrReadOnly = errors.New("database is read-only")
+
+// RecoverDatabase moves a corrupt database file aside, as SetAsideDatabase
+// does, and salvages the entries written before the damage into a fresh file
+// in its place. buntdb itself only recovers a file cut off mid-write; damage
+// further in, such as a block of garbage after a power loss, fails the whole
+// file. cause is the error opening it. Without anything to salvage, no file
+// is left in place and the database starts empty.
+func RecoverDatabase(dbPath string, cause error) (*models.DatabaseIncident, error) {
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	aside, err := SetAsideDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	incident := &models.DatabaseIncident{
+		At:        time.Now(),
+		Error:     cause.Error(),
+		MovedTo:   aside,
+		LostBytes: int64(len(data)),
+	}
+	valid := validPrefix(data)
+	if valid == 0 {
+		return incident, nil
+	}
+	_, keys, err := verifySnapshot(bytes.NewReader(data[:valid]))
+	if err != nil {
+		return incident, nil // Well-formed but not loadable, nothing to salvage
+	}
+	if err := os.WriteFile(dbPath, data[:valid], 0644); err != nil {
+		os.Remove(dbPath)
+		return incident, nil
+	}
+	incident.RecoveredKeys = keys
+	incident.RecoveredBytes = int64(valid)
+	incident.LostBytes -= int64(valid)
+	return incident, nil
+}
+
+// validPrefix returns the length of the complete commands at the start of a
+// buntdb file, which is a series of RESP arrays of bulk strings
+func validPrefix(data []byte) int {
+	valid, pos := 0, 0
+	for pos < len(data) {
+		// buntdb skips NUL bytes between commands
+		if data[pos] == 0 {
+			pos++
+			valid = pos
+			continue
+		}
+		parts, next, ok := respLength(data, pos, '*')
+		if !ok || parts <= 0 {
+			break
+		}
+		pos = next
+		for i := 0; i < parts && ok; i++ {
+			var size int
+			size, pos, ok = respLength(data, pos, '$')
+			end := pos + size + 2
+			ok = ok && size >= 0 && end <= len(data) && data[end-2] == '\r' && data[end-1] == '\n'
+			pos = end
+		}
+		if !ok {
+			break
+		}
+		valid = pos
+	}
+	return valid
+}
+
+// respLength reads a RESP length line such as "*3\r\n" at pos, returning the
+// length and the position after the line
+func respLength(data []byte, pos int, prefix byte) (int, int, bool) {
+	if pos >= len(data) || data[pos] != prefix {
+		return 0, 0, false
+	}
+	end := bytes.Index(data[pos:], []byte("\r\n"))
+	if end < 0 {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(string(data[pos+1 : pos+end]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return n, pos + end + 2, true
+}
+
+// openReadOnly loads a database file into memory, leaving the file untouched.
+// A file cut off mid-write loads up to the cut, as buntdb would open it.
+func openReadOnly(dbPath string) (*buntdb.DB, error) {
+	file, err := os.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db, err := buntdb.Open(":memory:")
+	if err != nil {
+		return nil, err
+	}
+	err = db.Load(file)
+	if errors.Is(err, buntdb.ErrInvalid) {
+		db.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrCorruptDatabase, dbPath, err)
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ReadOnly reports whether the monitor serves a database opened read-only
+func (nm *NetworkMonitor) ReadOnly() bool {
+	return nm.readOnly
+}
+
+// SetDatabaseIncident records that the database was found corrupt at startup,
+// so /health reports it until the next restart
+func (nm *NetworkMonitor) SetDatabaseIncident(incident *models.DatabaseIncident) {
+	nm.persistMu.Lock()
+	defer nm.persistMu.Unlock()
+	nm.dbIncident = incident
+}
+
+// DatabaseIncident returns the database incident of this startup, or nil
+func (nm *NetworkMonitor) DatabaseIncident() *models.DatabaseIncident {
+	nm.persistMu.Lock()
+	defer nm.persistMu.Unlock()
+	return nm.dbIncident
+}
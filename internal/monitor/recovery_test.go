@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// setCommand returns the line buntdb appends to its file for a write
+func setCommand(key, value string) string {
+	return fmt.Sprintf("*3\r\n$3\r\nset\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(value), value)
+}
+
+// Two writes before the damage and one after
+var (
+	beforeDamage = setCommand("alpha", "1") + setCommand("beta", "2")
+	afterDamage  = setCommand("gamma", "3")
+)
+
+func writeDatabase(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "network.db")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkKeys fails unless the monitor's database holds exactly want of the
+// test keys
+func checkKeys(t *testing.T, nm *NetworkMonitor, want ...string) {
+	t.Helper()
+	var got []string
+	nm.db.View(func(tx *buntdb.Tx) error {
+		for _, key := range []string{"alpha", "beta", "gamma"} {
+			if _, err := tx.Get(key); err == nil {
+				got = append(got, key)
+			}
+		}
+		return nil
+	})
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keys %v, want %v", got, want)
+	}
+}
+
+func TestValidPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"empty", "", 0},
+		{"whole", beforeDamage + afterDamage, len(beforeDamage + afterDamage)},
+		{"cut in a command", beforeDamage + afterDamage[:10], len(beforeDamage)},
+		{"cut in a length", beforeDamage + "*3\r", len(beforeDamage)},
+		{"padding", beforeDamage + "\x00\x00" + afterDamage, len(beforeDamage+afterDamage) + 2},
+		{"garbage", beforeDamage + "#garbage\r\n" + afterDamage, len(beforeDamage)},
+		{"wrong size", beforeDamage + "*1\r\n$9\r\nset\r\n", len(beforeDamage)},
+		{"garbage first", "garbage" + beforeDamage, 0},
+	}
+	for _, tt := range tests {
+		if got := validPrefix([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: validPrefix = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// A file cut off mid-write opens as it is, up to the cut
+func TestOpenTruncatedDatabase(t *testing.T) {
+	path := writeDatabase(t, beforeDamage+afterDamage[:len(afterDamage)-3])
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatalf("NewNetworkMonitor = %v, want the cut recovered", err)
+	}
+	defer nm.Close()
+	checkKeys(t, nm, "alpha", "beta")
+}
+
+// A corrupt file fails to open, and recovering it keeps the file aside
+// untouched and salvages what came before the damage
+func TestRecoverDatabase(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		recovered []string
+		valid     int
+	}{
+		{"garbage inside", beforeDamage + "#garbage\r\n" + afterDamage, []string{"alpha", "beta"}, len(beforeDamage)},
+		{"garbage first", "\xff\xfe" + beforeDamage, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeDatabase(t, tt.data)
+			_, err := NewNetworkMonitor(16, path)
+			if !errors.Is(err, ErrCorruptDatabase) {
+				t.Fatalf("NewNetworkMonitor = %v, want ErrCorruptDatabase", err)
+			}
+
+			incident, err := RecoverDatabase(path, err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Base(incident.MovedTo)
+			if filepath.Dir(incident.MovedTo) != filepath.Dir(path) || !strings.HasPrefix(name, "network.db.corrupt-") {
+				t.Errorf("moved to %s", incident.MovedTo)
+			}
+			if kept, err := os.ReadFile(incident.MovedTo); err != nil || string(kept) != tt.data {
+				t.Errorf("the corrupt file was not kept as it was: %v", err)
+			}
+			want := models.DatabaseIncident{
+				RecoveredKeys:  len(tt.recovered),
+				RecoveredBytes: int64(tt.valid),
+				LostBytes:      int64(len(tt.data) - tt.valid),
+			}
+			if incident.RecoveredKeys != want.RecoveredKeys || incident.RecoveredBytes != want.RecoveredBytes ||
+				incident.LostBytes != want.LostBytes || incident.Error == "" || incident.At.IsZero() {
+				t.Errorf("incident = %+v, want %+v", incident, want)
+			}
+			if _, err := os.Stat(path); (err == nil) != (tt.valid > 0) {
+				t.Errorf("salvaged file present: %v, want %v", err == nil, tt.valid > 0)
+			}
+
+			nm, err := NewNetworkMonitor(16, path)
+			if err != nil {
+				t.Fatalf("reopening after recovery: %v", err)
+			}
+			defer nm.Close()
+			checkKeys(t, nm, tt.recovered...)
+
+			nm.SetDatabaseIncident(incident)
+			health := nm.Health()
+			if health.Status != models.HealthDegraded || health.Database != incident {
+				t.Errorf("health = %+v, want the incident reported", health)
+			}
+		})
+	}
+}
+
+// Read-only opens never write: a cut-off file loads up to the cut and a
+// corrupt one fails, both left as they were
+func TestReadOnlyCorruptDatabase(t *testing.T) {
+	cut := beforeDamage + afterDamage[:10]
+	path := writeDatabase(t, cut)
+	nm, err := NewReadOnlyNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, nm, "alpha", "beta")
+	if _, err := nm.Flush(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Flush = %v, want ErrReadOnly", err)
+	}
+	nm.Close()
+	if data, _ := os.ReadFile(path); string(data) != cut {
+		t.Error("the cut-off file was changed")
+	}
+
+	corrupt := beforeDamage + "#garbage\r\n" + afterDamage
+	path = writeDatabase(t, corrupt)
+	if _, err := NewReadOnlyNetworkMonitor(16, path); !errors.Is(err, ErrCorruptDatabase) {
+		t.Errorf("NewReadOnlyNetworkMonitor = %v, want ErrCorruptDatabase", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != corrupt {
+		t.Error("the corrupt file was changed")
+	}
+	if matches, _ := filepath.Glob(path + ".corrupt-*"); len(matches) != 0 {
+		t.Errorf("read-only open moved the file: %v", matches)
+	}
+}
@@ -0,0 +1,264 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Resource names accepted in a limits spec
+const (
+	ResourceRSSMB      = "rss_mb"
+	ResourceGoroutines = "goroutines"
+	ResourceOpenFDs    = "fds"
+	ResourceDBMB       = "db_mb"
+)
+
+// minDefensiveCacheSize is the smallest the device cache is shrunk to in defensive mode
+const minDefensiveCacheSize = 100
+
+// ResourceLimit holds the soft (warn) and hard (act) thresholds for a resource.
+// A zero threshold disables that check.
+type ResourceLimit struct {
+	Soft float64
+	Hard float64
+}
+
+// ResourceLimits maps resource names to their limits
+type ResourceLimits map[string]ResourceLimit
+
+// defensiveState remembers what was changed when entering defensive mode
+type defensiveState struct {
+	enteredAt     time.Time
+	enabledEvents map[uint8]bool
+}
+
+// lightProfileEvents are the only event types tracked in defensive mode
+var lightProfileEvents = []uint8{models.EVENT_TYPE_ARP, models.EVENT_TYPE_DNS}
+
+// ParseResourceLimits parses a spec such as "rss_mb=300:400,fds=800:1000"
+func ParseResourceLimits(spec string) (ResourceLimits, error) {
+	limits := ResourceLimits{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid resource limit %q (expected name=soft:hard)", item)
+		}
+
+		name = strings.TrimSpace(name)
+		switch name {
+		case ResourceRSSMB, ResourceGoroutines, ResourceOpenFDs, ResourceDBMB:
+		default:
+			return nil, fmt.Errorf("unknown resource %q", name)
+		}
+
+		softStr, hardStr, _ := strings.Cut(value, ":")
+		var limit ResourceLimit
+		var err error
+		if softStr != "" {
+			if limit.Soft, err = strconv.ParseFloat(softStr, 64); err != nil {
+				return nil, fmt.Errorf("invalid soft limit for %s: %q", name, softStr)
+			}
+		}
+		if hardStr != "" {
+			if limit.Hard, err = strconv.ParseFloat(hardStr, 64); err != nil {
+				return nil, fmt.Errorf("invalid hard limit for %s: %q", name, hardStr)
+			}
+		}
+		if limit.Soft > 0 && limit.Hard > 0 && limit.Hard < limit.Soft {
+			return nil, fmt.Errorf("hard limit for %s is below its soft limit", name)
+		}
+
+		limits[name] = limit
+	}
+
+	return limits, nil
+}
+
+// StartResourceMonitor samples cerberus's own resource usage every interval,
+// warning on soft limits and entering defensive mode on hard limits
+func (nm *NetworkMonitor) StartResourceMonitor(interval time.Duration, limits ResourceLimits) {
+	warned := make(map[string]bool)
+	nm.startWorker(interval, func(time.Time) {
+		usage := nm.sampleResources()
+		nm.checkResourceLimits(usage, limits, warned)
+	})
+}
+
+// ResourceUsage returns the most recent resource sample
+func (nm *NetworkMonitor) ResourceUsage() models.ResourceUsage {
+	nm.resourceMu.Lock()
+	defer nm.resourceMu.Unlock()
+	return nm.resourceUsage
+}
+
+func (nm *NetworkMonitor) sampleResources() models.ResourceUsage {
+	usage := models.ResourceUsage{
+		RSSBytes:   readRSS(),
+		Goroutines: runtime.NumGoroutine(),
+		OpenFDs:    countOpenFDs(),
+		Timestamp:  time.Now(),
+	}
+
+	if info, err := os.Stat(nm.dbPath); err == nil {
+		usage.DBBytes = info.Size()
+	}
+
+	usage.CachedDevices = nm.Cache.Len()
+	nm.eachDevice(func(_ string, device *models.DeviceInfo) {
+		usage.SeenPatterns += len(device.SeenPatterns)
+	})
+	nm.mu.RLock()
+	usage.DefensiveMode = nm.defensive != nil
+	nm.mu.RUnlock()
+
+	nm.resourceMu.Lock()
+	nm.resourceUsage = usage
+	nm.resourceMu.Unlock()
+
+	return usage
+}
+
+func (nm *NetworkMonitor) checkResourceLimits(usage models.ResourceUsage, limits ResourceLimits, warned map[string]bool) {
+	values := map[string]float64{
+		ResourceRSSMB:      float64(usage.RSSBytes) / (1024 * 1024),
+		ResourceGoroutines: float64(usage.Goroutines),
+		ResourceOpenFDs:    float64(usage.OpenFDs),
+		ResourceDBMB:       float64(usage.DBBytes) / (1024 * 1024),
+	}
+
+	belowSoft := true
+	var hardReason string
+
+	for name, limit := range limits {
+		value := values[name]
+
+		if limit.Soft > 0 && value > limit.Soft {
+			belowSoft = false
+			if !warned[name] {
+				fmt.Printf("WARNING: %s at %.0f exceeds soft limit %.0f\n", name, value, limit.Soft)
+				warned[name] = true
+			}
+		} else {
+			warned[name] = false
+		}
+
+		if limit.Hard > 0 && value > limit.Hard && hardReason == "" {
+			belowSoft = false
+			hardReason = fmt.Sprintf("%s %.0f > %.0f", name, value, limit.Hard)
+		}
+	}
+
+	if hardReason != "" {
+		nm.enterDefensiveMode(hardReason)
+	} else if belowSoft {
+		nm.exitDefensiveMode()
+	}
+}
+
+// enterDefensiveMode sheds memory and load: it persists and shrinks the device
+// cache, unloads seen-pattern sets and switches to the light monitoring
+// profile. The sets are persisted first and read back as their devices report
+// again, so no known pattern is reported as new afterwards.
+func (nm *NetworkMonitor) enterDefensiveMode(reason string) {
+	nm.mu.RLock()
+	already := nm.defensive != nil
+	nm.mu.RUnlock()
+	if already {
+		return
+	}
+
+	// Evicted devices must not lose their counters, nor any device its
+	// seen-pattern set
+	_, err := nm.persistDevices()
+
+	nm.lockAll()
+	newSize := max(minDefensiveCacheSize, nm.Cache.Len()/2)
+	evictedDevices := nm.Cache.Resize(newSize)
+
+	evictedPatterns := 0
+	if err == nil {
+		evictedPatterns = nm.unloadSeenPatterns()
+	}
+
+	nm.defensive = &defensiveState{
+		enteredAt:     time.Now(),
+		enabledEvents: nm.enabledEvents,
+	}
+	nm.enabledEvents = make(map[uint8]bool, len(lightProfileEvents))
+	for _, t := range lightProfileEvents {
+		nm.enabledEvents[t] = true
+	}
+	nm.unlockAll()
+
+	runtime.GC()
+
+	nm.raiseAnomaly("RESOURCE_DEFENSIVE_MODE", models.SeverityMedium, "",
+		map[string]string{"time": time.Now().Format("15:04"), "reason": reason},
+		map[string]string{
+			"reason":           reason,
+			"cache_size":       strconv.Itoa(newSize),
+			"evicted_devices":  strconv.Itoa(evictedDevices),
+			"evicted_patterns": strconv.Itoa(evictedPatterns),
+			"profile":          "light (ARP, DNS)",
+		})
+}
+
+// exitDefensiveMode restores the cache size and the event types tracked before
+// defensive mode was entered
+func (nm *NetworkMonitor) exitDefensiveMode() {
+	nm.lockAll()
+	state := nm.defensive
+	if state == nil {
+		nm.unlockAll()
+		return
+	}
+	nm.Cache.Resize(nm.cacheSize)
+	nm.enabledEvents = state.enabledEvents
+	nm.defensive = nil
+	nm.unlockAll()
+
+	duration := time.Since(state.enteredAt).Round(time.Second)
+	nm.raiseAnomaly("RESOURCE_DEFENSIVE_MODE_EXIT", models.SeverityInfo, "",
+		map[string]string{"time": time.Now().Format("15:04"), "duration": duration.String()},
+		map[string]string{"duration": duration.String()})
+}
+
+// readRSS returns the resident set size of this process (Linux only)
+func readRSS() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// countOpenFDs returns the number of open file descriptors (Linux only)
+func countOpenFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
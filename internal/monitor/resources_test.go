@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+func TestParseResourceLimits(t *testing.T) {
+	limits, err := ParseResourceLimits("rss_mb=300:400, fds=:1000")
+	if err != nil {
+		t.Fatalf("ParseResourceLimits: %v", err)
+	}
+	if got := limits[ResourceRSSMB]; got != (ResourceLimit{Soft: 300, Hard: 400}) {
+		t.Errorf("rss_mb = %+v", got)
+	}
+	if got := limits[ResourceOpenFDs]; got != (ResourceLimit{Hard: 1000}) {
+		t.Errorf("fds = %+v", got)
+	}
+
+	for _, spec := range []string{"rss_mb", "cpu=1:2", "fds=10:5", "db_mb=x:1"} {
+		if _, err := ParseResourceLimits(spec); err == nil {
+			t.Errorf("ParseResourceLimits(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// Crossing a hard limit enters defensive mode and coming back under every
+// soft limit leaves it, each recorded as an anomaly
+func TestResourceLimitsDefensiveMode(t *testing.T) {
+	nm := newTestMonitor(t, 200)
+	limits := ResourceLimits{ResourceGoroutines: {Soft: 10, Hard: 20}}
+	warned := make(map[string]bool)
+
+	nm.checkResourceLimits(resourceUsage(15), limits, warned)
+	if !warned[ResourceGoroutines] {
+		t.Error("soft limit crossed without a warning")
+	}
+	if nm.ResourceUsage().DefensiveMode || nm.defensive != nil {
+		t.Fatal("entered defensive mode below the hard limit")
+	}
+
+	nm.checkResourceLimits(resourceUsage(25), limits, warned)
+	if nm.defensive == nil {
+		t.Fatal("hard limit crossed without entering defensive mode")
+	}
+	if len(nm.enabledEvents) != len(lightProfileEvents) {
+		t.Errorf("defensive mode tracks %d event types, want the %d of the light profile", len(nm.enabledEvents), len(lightProfileEvents))
+	}
+
+	// Still above the soft limit: defensive mode holds
+	nm.checkResourceLimits(resourceUsage(15), limits, warned)
+	if nm.defensive == nil {
+		t.Fatal("left defensive mode above the soft limit")
+	}
+
+	nm.checkResourceLimits(resourceUsage(5), limits, warned)
+	if nm.defensive != nil {
+		t.Fatal("stayed in defensive mode below every soft limit")
+	}
+	if nm.enabledEvents != nil {
+		t.Errorf("event types not restored: %v", nm.enabledEvents)
+	}
+
+	var types []string
+	for _, anomaly := range nm.pendingAnomalies {
+		types = append(types, anomaly.anomaly.Type)
+	}
+	want := []string{"RESOURCE_DEFENSIVE_MODE", "RESOURCE_DEFENSIVE_MODE_EXIT"}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("anomalies %v, want %v", types, want)
+	}
+}
+
+// Devices keep the patterns they reported across defensive mode, whether they
+// stayed in the cache or were evicted and reloaded
+func TestDefensiveModeKeepsSeenPatterns(t *testing.T) {
+	nm := newTestMonitor(t, 200)
+	const devices = 150
+	report := func() {
+		for i := range devices {
+			mac := fmt.Sprintf("02:00:00:00:%02x:%02x", i/256, i%256)
+			src := fmt.Sprintf("192.168.%d.%d", 1+i/250, 1+i%250)
+			for port := uint16(1); port <= 3; port++ {
+				nm.TrackEvent(tcpEvent(t, mac, src, "203.0.113.10", 8000+port))
+			}
+		}
+	}
+
+	report()
+	if got := pendingPatternCount(nm); got != devices*3 {
+		t.Fatalf("%d new patterns, want %d", got, devices*3)
+	}
+	nm.enterDefensiveMode("test")
+	if nm.Cache.Len() >= devices {
+		t.Fatalf("cache holds %d devices, want it shrunk below %d", nm.Cache.Len(), devices)
+	}
+	for _, id := range nm.Cache.Keys() {
+		if device, _ := nm.Cache.Peek(id); device.SeenPatterns != nil {
+			t.Fatalf("seen patterns of %s still loaded in defensive mode", id)
+		}
+	}
+	nm.exitDefensiveMode()
+
+	report()
+	if got := pendingPatternCount(nm); got != 0 {
+		t.Errorf("%d known patterns reported again as new after defensive mode", got)
+	}
+
+	// A pattern never seen is still new
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:00", "192.168.1.1", "203.0.113.10", 9999))
+	if got := pendingPatternCount(nm); got != 1 {
+		t.Errorf("%d new patterns after a new destination port, want 1", got)
+	}
+}
+
+// The resource monitor runs as a worker, which Close stops
+func TestResourceMonitorStopsOnClose(t *testing.T) {
+	nm, err := NewNetworkMonitor(100, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nm.StartResourceMonitor(time.Millisecond, ResourceLimits{})
+	time.Sleep(10 * time.Millisecond)
+	if nm.ResourceUsage().Timestamp.IsZero() {
+		t.Error("no resource sample taken")
+	}
+
+	closed := make(chan error)
+	go func() { closed <- nm.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the resource monitor")
+	}
+}
+
+func resourceUsage(goroutines int) (usage models.ResourceUsage) {
+	usage.Goroutines = goroutines
+	return usage
+}
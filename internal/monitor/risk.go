@@ -0,0 +1,203 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Risk factor names, used as keys in RiskWeights
+const (
+	RiskThreatPort    = "threat_port"
+	RiskFanOut        = "fan_out"
+	RiskDeprecatedTLS = "deprecated_tls"
+	RiskDoH           = "doh"
+	RiskScan          = "scan"
+	RiskRandomizedMAC = "randomized_mac"
+)
+
+// Risk levels of risk scores
+const (
+	RiskLevelLow    = "low"
+	RiskLevelMedium = "medium"
+	RiskLevelHigh   = "high"
+)
+
+// RiskLevel ranks a risk score: high from 60, medium from 30
+func RiskLevel(score int) string {
+	switch {
+	case score >= 60:
+		return RiskLevelHigh
+	case score >= 30:
+		return RiskLevelMedium
+	}
+	return RiskLevelLow
+}
+
+// RiskWeights holds the maximum points each factor can add to a risk score
+type RiskWeights map[string]float64
+
+// riskSaturation is the signal value at which a factor contributes its full weight
+var riskSaturation = map[string]float64{
+	RiskThreatPort:    3,
+	RiskFanOut:        50,
+	RiskDeprecatedTLS: 5,
+	RiskDoH:           1,
+	RiskScan:          50,
+	RiskRandomizedMAC: 1,
+}
+
+// dohResolvers lists well-known public DNS-over-HTTPS resolver addresses
+var dohResolvers = map[string]bool{
+	"1.1.1.1":         true, // Cloudflare
+	"1.0.0.1":         true,
+	"8.8.8.8":         true, // Google
+	"8.8.4.4":         true,
+	"9.9.9.9":         true, // Quad9
+	"149.112.112.112": true,
+	"208.67.222.222":  true, // OpenDNS
+	"208.67.220.220":  true,
+	"94.140.14.14":    true, // AdGuard
+	"94.140.15.15":    true,
+}
+
+// DefaultRiskWeights returns the built-in weights, which sum to 100
+func DefaultRiskWeights() RiskWeights {
+	return RiskWeights{
+		RiskThreatPort:    30,
+		RiskFanOut:        15,
+		RiskDeprecatedTLS: 15,
+		RiskDoH:           10,
+		RiskScan:          20,
+		RiskRandomizedMAC: 10,
+	}
+}
+
+// ParseRiskWeights overrides the default weights from a spec such as "scan=40,doh=0"
+func ParseRiskWeights(spec string) (RiskWeights, error) {
+	weights := DefaultRiskWeights()
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid risk weight %q (expected name=value)", item)
+		}
+		name = strings.TrimSpace(name)
+		if _, known := weights[name]; !known {
+			return nil, fmt.Errorf("unknown risk factor %q", name)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", name, value)
+		}
+		weights[name] = weight
+	}
+
+	return weights, nil
+}
+
+// SetRiskWeights replaces the weights used for device risk scoring
+func (nm *NetworkMonitor) SetRiskWeights(weights RiskWeights) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.riskWeights = weights
+}
+
+// ScoreDevice computes a composite risk score for a device
+func ScoreDevice(device *models.DeviceInfo, weights RiskWeights) *models.RiskScore {
+	randomized := 0
+	if !device.Routed && utils.IsRandomizedMAC(device.MAC) {
+		randomized = 1
+	}
+
+	signals := []struct {
+		name   string
+		value  int
+		detail string
+	}{
+		{RiskThreatPort, device.ThreatPortAccess, fmt.Sprintf("%d destination(s) on known-dangerous ports", device.ThreatPortAccess)},
+		{RiskFanOut, device.ExternalPatterns, fmt.Sprintf("%d unique external communication patterns", device.ExternalPatterns)},
+		{RiskDeprecatedTLS, device.DeprecatedTLS, fmt.Sprintf("%d Client Hello(s) offering TLS older than 1.2", device.DeprecatedTLS)},
+		{RiskDoH, device.DoHConnections, fmt.Sprintf("%d connection(s) to DNS-over-HTTPS resolvers", device.DoHConnections)},
+		{RiskScan, device.ScanPatterns, fmt.Sprintf("%d unique ARP/SYN probe patterns", device.ScanPatterns)},
+		{RiskRandomizedMAC, randomized, "locally administered (randomized) MAC address"},
+	}
+
+	score := &models.RiskScore{
+		ID:      device.ID,
+		Factors: []models.RiskFactor{},
+	}
+
+	total := 0.0
+	for _, signal := range signals {
+		if signal.value == 0 || weights[signal.name] == 0 {
+			continue
+		}
+
+		points := weights[signal.name] * math.Min(1, float64(signal.value)/riskSaturation[signal.name])
+		points = math.Round(points*10) / 10
+		total += points
+
+		score.Factors = append(score.Factors, models.RiskFactor{
+			Name:   signal.name,
+			Points: points,
+			Detail: signal.detail,
+		})
+	}
+
+	score.Score = int(math.Min(100, math.Round(total)))
+	return score
+}
+
+// DeviceRiskScore computes the risk score of a tracked device
+func (nm *NetworkMonitor) DeviceRiskScore(id string) (*models.RiskScore, bool) {
+	nm.mu.RLock()
+	weights := nm.riskWeights
+	nm.mu.RUnlock()
+
+	device, ok := nm.Cache.Get(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+	return ScoreDevice(device, weights), true
+}
+
+// DevicesByRisk returns copies of the tracked devices ordered by descending
+// risk score. Each device is scored and copied holding only its stripe.
+func (nm *NetworkMonitor) DevicesByRisk() []*models.DeviceInfo {
+	nm.mu.RLock()
+	weights := nm.riskWeights
+	nm.mu.RUnlock()
+
+	type scored struct {
+		device *models.DeviceInfo
+		score  int
+	}
+
+	var list []scored
+	nm.eachDevice(func(_ string, device *models.DeviceInfo) {
+		list = append(list, scored{cloneDevice(device), ScoreDevice(device, weights).Score})
+	})
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].score > list[j].score
+	})
+
+	devices := make([]*models.DeviceInfo, len(list))
+	for i, s := range list {
+		devices[i] = s.device
+	}
+	return devices
+}
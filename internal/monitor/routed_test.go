@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+// A stream mixing local devices with hosts behind a router keys the routed
+// hosts on their IP, leaves the router's MAC to the router's own traffic, and
+// folds a routed host into its MAC once it answers ARP on the local segment
+func TestRoutedAndLocalDevices(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	_, routed, _ := net.ParseCIDR("10.9.0.0/16")
+	nm.SetRoutedSubnets([]*net.IPNet{routed}, false)
+	const router, laptop, host = "02:00:00:00:00:01", "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+
+	stream := []struct{ mac, src, dst string }{
+		{laptop, "10.0.0.10", "203.0.113.5"},
+		{router, "10.9.0.5", "203.0.113.5"},
+		{router, "10.0.0.1", "203.0.113.9"},
+		{router, "10.9.0.6", "203.0.113.7"},
+		{laptop, "10.0.0.10", "203.0.113.6"},
+		{router, "10.9.0.5", "203.0.113.8"},
+	}
+	for _, evt := range stream {
+		nm.TrackEvent(tcpEvent(t, evt.mac, evt.src, evt.dst, 443))
+	}
+
+	var ids []string
+	for _, device := range nm.ListDevices() {
+		ids = append(ids, device.ID)
+	}
+	slices.Sort(ids)
+	want := []string{router, laptop, "ip:10.9.0.5", "ip:10.9.0.6"}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("devices = %v, want %v", ids, want)
+	}
+	tests := []struct {
+		id, ip   string
+		patterns int
+	}{
+		{laptop, "10.0.0.10", 2},
+		{router, "10.0.0.1", 1},
+		{"ip:10.9.0.5", "10.9.0.5", 2},
+		{"ip:10.9.0.6", "10.9.0.6", 1},
+	}
+	for _, tt := range tests {
+		device, ok := nm.GetDevice(tt.id)
+		if !ok {
+			t.Errorf("device %s not tracked", tt.id)
+			continue
+		}
+		if device.IP != tt.ip || device.ExternalPatterns != tt.patterns {
+			t.Errorf("%s = %s with %d patterns, want %s with %d", tt.id, device.IP, device.ExternalPatterns, tt.ip, tt.patterns)
+		}
+	}
+
+	// The host answers ARP on the local segment: its routed record is absorbed
+	nm.TrackEvent(arpReply(t, host, "10.9.0.5"))
+	if _, ok := nm.GetDevice("ip:10.9.0.5"); ok {
+		t.Error("absorbed routed device still tracked")
+	}
+	device, ok := nm.GetDevice(host)
+	if !ok || device.IP != "10.9.0.5" || device.ExternalPatterns != 2 {
+		t.Fatalf("host = %+v, want 10.9.0.5 with the routed patterns", device)
+	}
+	for _, id := range []string{router, laptop, "ip:10.9.0.6"} {
+		if _, ok := nm.GetDevice(id); !ok {
+			t.Errorf("device %s lost in the merge", id)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package monitor
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Search result groups
+const (
+	SearchGroupDevice      = "device"
+	SearchGroupDNSDomain   = "dns_domain"
+	SearchGroupHTTPHost    = "http_host"
+	SearchGroupTLSSNI      = "tls_sni"
+	SearchGroupDestination = "destination"
+)
+
+// MinSearchQueryLength is the shortest query Search accepts
+const MinSearchQueryLength = 3
+
+// ErrSearchQueryTooShort is returned for queries shorter than MinSearchQueryLength
+var ErrSearchQueryTooShort = errors.New("search query must be at least 3 characters")
+
+var searchGroupOrder = []string{
+	SearchGroupDevice,
+	SearchGroupDNSDomain,
+	SearchGroupHTTPHost,
+	SearchGroupTLSSNI,
+	SearchGroupDestination,
+}
+
+type searchKey struct {
+	group string
+	field string
+	value string
+}
+
+// searchIndex maps every distinct searchable value to the devices it belongs to.
+// Queries scan distinct values (a few thousand domains network-wide) rather than
+// every device's maps, and values are lowered once at insertion time.
+type searchIndex struct {
+	mu      sync.RWMutex
+	entries map[searchKey]*searchEntry
+}
+
+type searchEntry struct {
+	lowered string
+	devices map[string]bool
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{entries: make(map[searchKey]*searchEntry)}
+}
+
+func (idx *searchIndex) add(group, field, value, deviceID string) {
+	if value == "" {
+		return
+	}
+	key := searchKey{group, field, value}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.entries[key]
+	if !ok {
+		entry = &searchEntry{lowered: strings.ToLower(value), devices: make(map[string]bool)}
+		idx.entries[key] = entry
+	}
+	entry.devices[deviceID] = true
+}
+
+// removeDevice drops every reference to a device identity
+func (idx *searchIndex) removeDevice(deviceID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for key, entry := range idx.entries {
+		delete(entry.devices, deviceID)
+		if len(entry.devices) == 0 {
+			delete(idx.entries, key)
+		}
+	}
+}
+
+// indexDevice adds every searchable value a device currently holds
+func (idx *searchIndex) indexDevice(device *models.DeviceInfo) {
+	idx.add(SearchGroupDevice, "mac", device.MAC, device.ID)
+	idx.add(SearchGroupDevice, "ip", device.IP, device.ID)
+	idx.add(SearchGroupDevice, "vendor", device.Vendor, device.ID)
+	idx.add(SearchGroupDevice, "device_type", DeviceType(device), device.ID)
+	idx.indexInventory(device)
+
+	for domain := range device.DNSDomains {
+		idx.add(SearchGroupDNSDomain, "dns_domains", domain, device.ID)
+	}
+	for host := range device.HTTPHosts {
+		idx.add(SearchGroupHTTPHost, "http_hosts", host, device.ID)
+	}
+	for host := range device.HTTPHostHeaders {
+		idx.add(SearchGroupHTTPHost, "http_host_headers", host, device.ID)
+	}
+	for sni := range device.TLSSNIs {
+		idx.add(SearchGroupTLSSNI, "tls_snis", sni, device.ID)
+	}
+	for _, target := range device.Targets.Values() {
+		idx.add(SearchGroupDestination, "targets", target, device.ID)
+	}
+}
+
+// indexInventory adds the inventory name, owner and location of a device
+func (idx *searchIndex) indexInventory(device *models.DeviceInfo) {
+	idx.add(SearchGroupDevice, "name", device.Name, device.ID)
+	idx.add(SearchGroupDevice, "owner", device.Owner, device.ID)
+	idx.add(SearchGroupDevice, "location", device.Location, device.ID)
+}
+
+// search returns case-insensitive substring matches grouped by type,
+// keeping at most limit matches per group
+func (idx *searchIndex) search(query string, limit int) []models.SearchGroup {
+	query = strings.ToLower(query)
+
+	idx.mu.RLock()
+	groups := make(map[string]*models.SearchGroup)
+	for key, entry := range idx.entries {
+		if !strings.Contains(entry.lowered, query) {
+			continue
+		}
+
+		group, ok := groups[key.group]
+		if !ok {
+			group = &models.SearchGroup{Type: key.group, Matches: []models.SearchMatch{}}
+			groups[key.group] = group
+		}
+
+		for deviceID := range entry.devices {
+			group.Total++
+			group.Matches = append(group.Matches, models.SearchMatch{
+				DeviceID: deviceID,
+				Field:    key.field,
+				Value:    key.value,
+			})
+		}
+	}
+	idx.mu.RUnlock()
+
+	results := []models.SearchGroup{}
+	for _, name := range searchGroupOrder {
+		group, ok := groups[name]
+		if !ok {
+			continue
+		}
+
+		sort.Slice(group.Matches, func(i, j int) bool {
+			a, b := group.Matches[i], group.Matches[j]
+			if a.Value != b.Value {
+				return a.Value < b.Value
+			}
+			return a.DeviceID < b.DeviceID
+		})
+		if len(group.Matches) > limit {
+			group.Matches = group.Matches[:limit]
+		}
+		results = append(results, *group)
+	}
+	return results
+}
+
+// Search finds devices, domains, hosts, SNIs and destinations containing query
+func (nm *NetworkMonitor) Search(query string, limit int) (*models.SearchResults, error) {
+	query = strings.TrimSpace(query)
+	if len(query) < MinSearchQueryLength {
+		return nil, ErrSearchQueryTooShort
+	}
+
+	return &models.SearchResults{
+		Query:  query,
+		Groups: nm.searchIndex.search(query, limit),
+	}, nil
+}
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// SeenKeyPrefix prefixes the persisted seen-pattern set of each device, which
+// keeps the patterns it already reported known once the device leaves the
+// cache.
+const SeenKeyPrefix = "seen:"
+
+// markSeen records a pattern of a device as reported and returns whether it
+// was new. Must hold nm.mu.
+func (nm *NetworkMonitor) markSeen(device *models.DeviceInfo, key string) bool {
+	seen := nm.seenPatterns(device)
+	if seen[key] {
+		return false
+	}
+	seen[key] = true
+	nm.seenDirty[device.ID] = true
+	return true
+}
+
+// seenPatterns returns the seen-pattern set of a device, loading it from the
+// database when the device was reloaded or its set unloaded. Must hold nm.mu.
+func (nm *NetworkMonitor) seenPatterns(device *models.DeviceInfo) map[string]bool {
+	if device.SeenPatterns == nil {
+		device.SeenPatterns = nm.loadSeenPatterns(device.ID)
+	}
+	return device.SeenPatterns
+}
+
+// loadSeenPatterns reads the persisted seen-pattern set of a device, empty if
+// it has none
+func (nm *NetworkMonitor) loadSeenPatterns(id string) map[string]bool {
+	seen := make(map[string]bool)
+	nm.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get(SeenKeyPrefix + id)
+		if err != nil {
+			return nil
+		}
+		var keys []string
+		if json.Unmarshal([]byte(val), &keys) == nil {
+			for _, key := range keys {
+				seen[key] = true
+			}
+		}
+		return nil
+	})
+	return seen
+}
+
+// takeSeenPatterns returns the seen-pattern sets changed since the last
+// persist, by device ID, and clears their changed flags. Must hold nm.mu.
+func (nm *NetworkMonitor) takeSeenPatterns() map[string][]string {
+	if len(nm.seenDirty) == 0 {
+		return nil
+	}
+	sets := make(map[string][]string, len(nm.seenDirty))
+	for id := range nm.seenDirty {
+		device, ok := nm.Cache.Peek(id)
+		if !ok || device.SeenPatterns == nil {
+			// Evicted before its set was persisted
+			continue
+		}
+		keys := make([]string, 0, len(device.SeenPatterns))
+		for key := range device.SeenPatterns {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		sets[id] = keys
+	}
+	clear(nm.seenDirty)
+	return sets
+}
+
+// requeueSeenPatterns flags sets again after a failed persist. Must hold nm.mu.
+func (nm *NetworkMonitor) requeueSeenPatterns(sets map[string][]string) {
+	for id := range sets {
+		nm.seenDirty[id] = true
+	}
+}
+
+// unloadSeenPatterns drops the in-memory seen-pattern sets of the cached
+// devices, which must have been persisted, and returns how many patterns they
+// held. Each set is read back the next time its device reports a pattern.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) unloadSeenPatterns() int {
+	unloaded := 0
+	for _, id := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Peek(id)
+		if !ok || device.SeenPatterns == nil || nm.seenDirty[id] {
+			continue
+		}
+		unloaded += len(device.SeenPatterns)
+		device.SeenPatterns = nil
+	}
+	return unloaded
+}
+
+// writeSeenPatterns persists seen-pattern sets by device ID
+func writeSeenPatterns(tx *buntdb.Tx, sets map[string][]string) error {
+	for id, keys := range sets {
+		data, _ := json.Marshal(keys)
+		if _, _, err := tx.Set(SeenKeyPrefix+id, string(data), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSeenPatterns removes the persisted seen-pattern set of a device
+func deleteSeenPatterns(tx *buntdb.Tx, id string) error {
+	if _, err := tx.Delete(SeenKeyPrefix + id); err != nil && err != buntdb.ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/network"
+)
+
+// selfRefreshInterval is how often the host's own addresses are re-read, so
+// interfaces coming up or changing address are recognized
+const selfRefreshInterval = 30 * time.Second
+
+// SelfConfig controls how traffic of the host running cerberus is handled.
+// Its own DNS lookups, database downloads and SSH sessions are otherwise
+// reported like those of any other device.
+type SelfConfig struct {
+	Detect  bool // Recognize the host's MACs and IPs and flag its device self
+	Exclude bool // Keep its traffic out of anomaly detectors and new-pattern notifications
+}
+
+// DefaultSelfConfig returns the default self-traffic settings
+func DefaultSelfConfig() SelfConfig {
+	return SelfConfig{Detect: true, Exclude: true}
+}
+
+// selfHost is what is known of the monitoring host. It has its own lock, as
+// anomalies are raised with and without nm.mu held; it is taken last.
+type selfHost struct {
+	mu      sync.Mutex
+	config  SelfConfig
+	macs    map[string]bool
+	ips     map[string]bool
+	devices map[string]bool // IDs of the devices whose traffic is the host's
+}
+
+func newSelfHost(config SelfConfig) *selfHost {
+	return &selfHost{
+		config:  config,
+		macs:    make(map[string]bool),
+		ips:     make(map[string]bool),
+		devices: make(map[string]bool),
+	}
+}
+
+// setAddresses replaces the host's addresses, returning whether they changed
+func (s *selfHost) setAddresses(macs, ips []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := len(macs) != len(s.macs) || len(ips) != len(s.ips)
+	next := make(map[string]bool, len(macs))
+	for _, mac := range macs {
+		mac = strings.ToLower(mac)
+		changed = changed || !s.macs[mac]
+		next[mac] = true
+	}
+	s.macs = next
+	next = make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		changed = changed || !s.ips[ip]
+		next[ip] = true
+	}
+	s.ips = next
+	return changed
+}
+
+// observe reports whether traffic from a MAC and IP is the host's, and
+// records whether the device sending it is the host
+func (s *selfHost) observe(deviceID, mac, ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	self := s.config.Detect && (s.macs[mac] || s.ips[ip])
+	if self {
+		s.devices[deviceID] = true
+	} else {
+		delete(s.devices, deviceID)
+	}
+	return self
+}
+
+// excludes reports whether a device's traffic is kept out of detectors and
+// notifications
+func (s *selfHost) excludes(deviceID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.Exclude && s.devices[deviceID]
+}
+
+// SetSelfConfig replaces the self-traffic settings
+func (nm *NetworkMonitor) SetSelfConfig(config SelfConfig) {
+	nm.self.mu.Lock()
+	defer nm.self.mu.Unlock()
+	nm.self.config = config
+	if !config.Detect {
+		clear(nm.self.devices)
+	}
+}
+
+// SelfAddresses returns the MACs and IPv4 addresses recognized as the
+// monitoring host's
+func (nm *NetworkMonitor) SelfAddresses() (macs, ips []string) {
+	nm.self.mu.Lock()
+	defer nm.self.mu.Unlock()
+	for mac := range nm.self.macs {
+		macs = append(macs, mac)
+	}
+	for ip := range nm.self.ips {
+		ips = append(ips, ip)
+	}
+	return macs, ips
+}
+
+// refreshSelf re-reads the host's addresses from its interfaces, every
+// selfRefreshInterval. A failed read keeps the previous addresses.
+func (nm *NetworkMonitor) refreshSelf(time.Time) {
+	macs, ips, err := network.HostAddresses()
+	if err != nil {
+		return
+	}
+	if nm.self.setAddresses(macs, ips) {
+		fmt.Printf("Monitoring host addresses: %s\n", strings.Join(append(macs, ips...), ", "))
+	}
+}
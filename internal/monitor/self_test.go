@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// A multi-homed host, with a MAC and an address on each of two monitored
+// subnets
+var (
+	selfMACs = []string{"02:00:00:00:00:f1", "02:00:00:00:00:F2"}
+	selfIPs  = []string{"192.168.1.2", "10.0.0.2"}
+)
+
+// originPatterns returns the IDs of the devices whose patterns the bulk
+// export selects for an origin
+func originPatterns(t *testing.T, nm *NetworkMonitor, origin string) map[string]int {
+	t.Helper()
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	devices := make(map[string]int)
+	_, err := nm.BulkPatterns(context.Background(), BulkQuery{Origin: origin}, func(value string) error {
+		var pattern models.CommunicationPattern
+		if err := json.Unmarshal([]byte(value), &pattern); err != nil {
+			return err
+		}
+		devices[pattern.DeviceID]++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return devices
+}
+
+// Traffic from any of the host's interfaces is its own, whether recognized by
+// MAC or by IP, and is counted and labeled apart from the network's
+func TestSelfMultiHomed(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	nm.self.setAddresses(selfMACs, selfIPs)
+
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:f1", "192.168.1.2", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:f2", "10.0.0.2", "203.0.113.5", 443))
+	// A bridge or bond with a MAC of its own still sends from the host's IP
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:f3", "10.0.0.2", "198.51.100.7", 22))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0a", "192.168.1.10", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0b", "10.0.0.10", "203.0.113.5", 443))
+
+	for mac, want := range map[string]bool{
+		"02:00:00:00:00:f1": true,
+		"02:00:00:00:00:f2": true,
+		"02:00:00:00:00:f3": true,
+		"02:00:00:00:00:0a": false,
+		"02:00:00:00:00:0b": false,
+	} {
+		device, ok := nm.GetDevice(mac)
+		if !ok {
+			t.Fatalf("%s not tracked", mac)
+		}
+		if device.Self != want {
+			t.Errorf("%s self = %v, want %v", mac, device.Self, want)
+		}
+	}
+
+	counts := nm.Stats.Snapshot()
+	if counts.SelfPackets != 3 || counts.TotalPackets != 2 || counts.TcpPackets != 2 {
+		t.Errorf("counted %d self, %d total, %d TCP packets, want 3, 2 and 2", counts.SelfPackets, counts.TotalPackets, counts.TcpPackets)
+	}
+
+	self := originPatterns(t, nm, models.OriginSelf)
+	network := originPatterns(t, nm, OriginNetwork)
+	if len(self) != 3 || self["02:00:00:00:00:0a"] != 0 {
+		t.Errorf("self patterns from %v, want the host's three devices", self)
+	}
+	if len(network) != 2 || network["02:00:00:00:00:f1"] != 0 {
+		t.Errorf("network patterns from %v, want the two others", network)
+	}
+}
+
+// The host's anomalies are dropped unless it isn't excluded, and nothing is
+// the host's with detection off
+func TestSelfExclusion(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        SelfConfig
+		self, anomaly bool
+	}{
+		{"default", DefaultSelfConfig(), true, false},
+		{"included", SelfConfig{Detect: true}, true, true},
+		{"not detected", SelfConfig{Exclude: true}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newTestMonitor(t, 16)
+			nm.self.setAddresses(selfMACs, selfIPs)
+			nm.SetSelfConfig(tt.config)
+			mac := "02:00:00:00:00:f1"
+			nm.TrackEvent(tcpEvent(t, mac, "192.168.1.2", "203.0.113.5", 443))
+
+			if device, _ := nm.GetDevice(mac); device.Self != tt.self {
+				t.Errorf("self = %v, want %v", device.Self, tt.self)
+			}
+			anomaly := nm.raiseAnomaly("PORT_SCAN", models.SeverityLow, mac, nil, nil)
+			if (anomaly != nil) != tt.anomaly {
+				t.Errorf("anomaly raised: %v, want %v", anomaly != nil, tt.anomaly)
+			}
+		})
+	}
+}
+
+// Address changes are reported once, in any order and case
+func TestSelfSetAddresses(t *testing.T) {
+	s := newSelfHost(DefaultSelfConfig())
+	if !s.setAddresses(selfMACs, selfIPs) {
+		t.Error("first addresses not reported as a change")
+	}
+	if s.setAddresses([]string{"02:00:00:00:00:F1", "02:00:00:00:00:f2"}, []string{"10.0.0.2", "192.168.1.2"}) {
+		t.Error("same addresses reported as a change")
+	}
+	if !s.setAddresses(selfMACs[:1], selfIPs) {
+		t.Error("a removed interface not reported as a change")
+	}
+	if s.observe("02:00:00:00:00:f2", "02:00:00:00:00:f2", "10.0.0.9") {
+		t.Error("a removed MAC is still the host's")
+	}
+}
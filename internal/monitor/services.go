@@ -0,0 +1,139 @@
+package monitor
+
+import (
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// lookupService names the destination port of a TCP or UDP event from the
+// user-defined names, then the service database. A port neither names is
+// kept as TCP/<port> or UDP/<port>, so unknown ports can be counted.
+func (nm *NetworkMonitor) lookupService(port uint16, protocol string) (string, string) {
+	svc := nm.serviceDB.LookupBestEffort(port, protocol)
+	if svc.Description == databases.UnknownServiceDescription {
+		return svc.Service, models.ServiceClassUnknown
+	}
+	return svc.Service, models.ServiceClassPort
+}
+
+// LoadServiceNames reads user-defined service names, see
+// databases.ServiceDatabase.LoadOverrides. Must be called before capture
+// starts.
+func (nm *NetworkMonitor) LoadServiceNames(path string) (int, error) {
+	return nm.serviceDB.LoadOverrides(path)
+}
+
+// countService counts an event of a device under a service
+func countService(services *models.ServiceCounts, class, service string) {
+	counts := services.Class(class)
+	if counts == nil {
+		return
+	}
+	if *counts == nil {
+		*counts = make(map[string]int)
+	}
+	(*counts)[service]++
+}
+
+// mergeServices adds the service counts of src to dst
+func mergeServices(dst *models.ServiceCounts, src models.ServiceCounts) {
+	for _, class := range models.ServiceClasses {
+		from := *src.Class(class)
+		if len(from) == 0 {
+			continue
+		}
+		to := dst.Class(class)
+		if *to == nil {
+			*to = make(map[string]int)
+		}
+		mergeCounts(*to, from)
+	}
+}
+
+// cloneServices returns a deep copy of service counts
+func cloneServices(services models.ServiceCounts) models.ServiceCounts {
+	return models.ServiceCounts{
+		L7:       maps.Clone(services.L7),
+		Port:     maps.Clone(services.Port),
+		Protocol: maps.Clone(services.Protocol),
+		Unknown:  maps.Clone(services.Unknown),
+	}
+}
+
+// DeviceServices returns the services of a device with the class of each and
+// the events per class
+func (nm *NetworkMonitor) DeviceServices(id string) (*models.DeviceServices, bool) {
+	device, ok := nm.Cache.Peek(id)
+	if !ok {
+		return nil, false
+	}
+	defer nm.lockDevice(id)()
+
+	result := &models.DeviceServices{
+		DeviceID:    device.ID,
+		Services:    []models.ServiceEntry{},
+		ClassCounts: make(map[string]int, len(models.ServiceClasses)),
+	}
+	for _, class := range models.ServiceClasses {
+		result.ClassCounts[class] = 0
+		for service, count := range *device.Services.Class(class) {
+			result.Services = append(result.Services, models.ServiceEntry{Service: service, Class: class, Count: count})
+			result.ClassCounts[class] += count
+		}
+	}
+	sort.Slice(result.Services, func(i, j int) bool {
+		a, b := result.Services[i], result.Services[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Class < b.Class
+	})
+	return result, true
+}
+
+// UnknownPorts returns the destination ports no service database names, with
+// the events and devices counted to each across the tracked devices, most
+// frequent first
+func (nm *NetworkMonitor) UnknownPorts() []models.UnknownPort {
+	byName := make(map[string]*models.UnknownPort)
+
+	nm.eachDevice(func(_ string, device *models.DeviceInfo) {
+		for name, count := range device.Services.Unknown {
+			entry, ok := byName[name]
+			if !ok {
+				protocol, portText, _ := strings.Cut(name, "/")
+				port, err := strconv.ParseUint(portText, 10, 16)
+				if err != nil {
+					continue
+				}
+				entry = &models.UnknownPort{Protocol: protocol, Port: uint16(port)}
+				byName[name] = entry
+			}
+			entry.Events += count
+			entry.Devices++
+		}
+	})
+
+	ports := make([]models.UnknownPort, 0, len(byName))
+	for _, entry := range byName {
+		ports = append(ports, *entry)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Events != ports[j].Events {
+			return ports[i].Events > ports[j].Events
+		}
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	return ports
+}
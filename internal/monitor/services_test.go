@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Ports are named from the user-defined names first, then the service
+// database, and keep their number when neither names them
+func TestLookupService(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	path := filepath.Join(t.TempDir(), "services")
+	names := "# Lab services\n3306/tcp db-main\n\n40001/udp lab-sensor\n"
+	if err := os.WriteFile(path, []byte(names), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := nm.LoadServiceNames(path); err != nil || n != 2 {
+		t.Fatalf("LoadServiceNames = %d, %v", n, err)
+	}
+
+	tests := []struct {
+		port           uint16
+		protocol       string
+		service, class string
+	}{
+		{3306, "TCP", "DB-MAIN", models.ServiceClassPort},
+		{3306, "UDP", "MYSQL (TCP)", models.ServiceClassPort},
+		{443, "TCP", "HTTPS", models.ServiceClassPort},
+		{40001, "UDP", "LAB-SENSOR", models.ServiceClassPort},
+		{40001, "TCP", "TCP/40001", models.ServiceClassUnknown},
+		{40002, "UDP", "UDP/40002", models.ServiceClassUnknown},
+	}
+	for _, tt := range tests {
+		if service, class := nm.lookupService(tt.port, tt.protocol); service != tt.service || class != tt.class {
+			t.Errorf("%s/%d = %s (%s), want %s (%s)", tt.protocol, tt.port, service, class, tt.service, tt.class)
+		}
+	}
+
+	for _, bad := range []string{"3306 mysql\n", "0/tcp zero\n", "53/sctp dns\n", "70000/udp big\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := nm.LoadServiceNames(path); err == nil {
+			t.Errorf("LoadServiceNames accepted %q", bad)
+		}
+	}
+}
+
+// A device's events are counted under the class their service was named by:
+// payload recognition, the port, the protocol or nothing
+func TestDeviceServices(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+	dns := func(payload string) *models.NetworkEvent {
+		evt := udpEvent(t, mac, "192.168.1.10", "192.168.1.1", 53)
+		evt.EventType, evt.L7Payload = models.EVENT_TYPE_DNS, []byte(payload)
+		return evt
+	}
+	icmp := tcpEvent(t, mac, "192.168.1.10", "192.168.1.1", 1)
+	icmp.EventType, icmp.Protocol, icmp.ICMPType = models.EVENT_TYPE_ICMP, 1, 8
+	icmp.SrcPort, icmp.DstPort, icmp.TCPFlags = 0, 0, 0
+
+	for _, evt := range []*models.NetworkEvent{
+		dns("\x12\x34\x01\x00"),
+		dns("\x12\x34\x01\x00"),
+		dns(""),
+		tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 3306),
+		tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 40001),
+		tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 40001),
+		udpEvent(t, mac, "192.168.1.10", "192.168.1.20", 40001),
+		icmp,
+	} {
+		nm.TrackEvent(evt)
+	}
+
+	got, ok := nm.DeviceServices(mac)
+	if !ok {
+		t.Fatal("device not tracked")
+	}
+	want := &models.DeviceServices{
+		DeviceID: mac,
+		Services: []models.ServiceEntry{
+			{Service: "DNS", Class: models.ServiceClassL7, Count: 2},
+			{Service: "TCP/40001", Class: models.ServiceClassUnknown, Count: 2},
+			{Service: "DNS", Class: models.ServiceClassPort, Count: 1},
+			{Service: "ICMP_ECHO_REQUEST", Class: models.ServiceClassProtocol, Count: 1},
+			{Service: "MYSQL", Class: models.ServiceClassPort, Count: 1},
+			{Service: "UDP/40001", Class: models.ServiceClassUnknown, Count: 1},
+		},
+		ClassCounts: map[string]int{
+			models.ServiceClassL7:       2,
+			models.ServiceClassPort:     2,
+			models.ServiceClassProtocol: 1,
+			models.ServiceClassUnknown:  3,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("services =\n%+v\nwant\n%+v", got, want)
+	}
+	if _, ok := nm.DeviceServices("02:00:00:00:00:ff"); ok {
+		t.Error("services of an unknown device")
+	}
+}
+
+// Unknown ports are ranked across devices by their events, each device
+// counted once
+func TestUnknownPorts(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	for _, e := range []struct {
+		mac  string
+		port uint16
+	}{
+		{"02:00:00:00:00:0a", 40001},
+		{"02:00:00:00:00:0a", 40001},
+		{"02:00:00:00:00:0b", 40001},
+		{"02:00:00:00:00:0b", 40002},
+		{"02:00:00:00:00:0b", 443}, // Named, so not listed
+	} {
+		nm.TrackEvent(tcpEvent(t, e.mac, "192.168.1.10", "192.168.1.20", e.port))
+	}
+	nm.TrackEvent(udpEvent(t, "02:00:00:00:00:0c", "192.168.1.12", "192.168.1.20", 40002))
+
+	want := []models.UnknownPort{
+		{Protocol: "TCP", Port: 40001, Events: 3, Devices: 2},
+		{Protocol: "TCP", Port: 40002, Events: 1, Devices: 1},
+		{Protocol: "UDP", Port: 40002, Events: 1, Devices: 1},
+	}
+	if got := nm.UnknownPorts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unknown ports = %+v, want %+v", got, want)
+	}
+}
+
+// Devices persisted with the flat service map of earlier releases are read
+// into classes with their counts, and count on from there
+func TestServicesMigration(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	mac := "02:00:00:00:00:0a"
+	legacy := map[string]any{
+		"id":  mac,
+		"mac": mac,
+		"ip":  "192.168.1.10",
+		"services": map[string]int{
+			"DNS": 3, "HTTP": 1, "MYSQL": 2, "HTTPS": 5, "MYSQL (TCP)": 1,
+			"ARP_REPLY": 4, "ICMP_ECHO_REQUEST": 1,
+			"TCP/40001": 6, "UDP/40002": 1, "TCP/SOMETHING": 1,
+		},
+	}
+	data, _ := json.Marshal(legacy)
+	if err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(mac, string(data), nil)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := models.ServiceCounts{
+		L7:       map[string]int{"DNS": 3, "HTTP": 1},
+		Port:     map[string]int{"MYSQL": 2, "HTTPS": 5, "MYSQL (TCP)": 1, "TCP/SOMETHING": 1},
+		Protocol: map[string]int{"ARP_REPLY": 4, "ICMP_ECHO_REQUEST": 1},
+		Unknown:  map[string]int{"TCP/40001": 6, "UDP/40002": 1},
+	}
+	device := nm.loadDevice(mac)
+	if device == nil || !reflect.DeepEqual(device.Services, want) {
+		t.Fatalf("migrated services = %+v, want %+v", device, want)
+	}
+
+	// Written back in the new form, which reads back as it was
+	data, _ = json.Marshal(device.Services)
+	var again models.ServiceCounts
+	if err := json.Unmarshal(data, &again); err != nil || !reflect.DeepEqual(again, want) {
+		t.Errorf("round trip = %+v (%v), want %+v", again, err, want)
+	}
+
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "192.168.1.20", 40001))
+	services, _ := nm.DeviceServices(mac)
+	if services.ClassCounts[models.ServiceClassUnknown] != 8 || services.ClassCounts[models.ServiceClassPort] != 9 {
+		t.Errorf("class counts after an event = %v", services.ClassCounts)
+	}
+}
@@ -0,0 +1,46 @@
+package monitor
+
+// Record types passed to an EventSink
+const (
+	SinkPattern         = "pattern"
+	SinkPatternSummary  = "pattern_summary" // Throttled new patterns of a device, see PatternNotifyConfig
+	SinkNewDevice       = "new_device"
+	SinkDeviceChange    = "device_change"
+	SinkDeviceIPChanged = "device_ip_changed" // A device settled on a new IP, along with its device_change
+	SinkAnomaly         = "anomaly"
+	SinkAnomalyDigest   = "anomaly_digest" // Batched low-severity anomalies, emitted by export.Digest
+	SinkStats           = "stats"          // Periodic models.StatsReport, emitted by the caller
+)
+
+// EventSink receives what the console notifiers report: new patterns
+// (*models.CommunicationPattern) and summaries of throttled ones
+// (*models.PatternSummary), new devices (*models.DeviceInfo), changes to
+// known devices (*models.DeviceUpdate) and their IP changes
+// (*models.IPChange), and anomalies
+// (*models.Anomaly). Emit is called from several goroutines.
+type EventSink interface {
+	Emit(recordType string, data any)
+}
+
+type eventSink struct {
+	sink  EventSink
+	quiet bool // Replaces the console lines rather than adding to them
+}
+
+// SetEventSink sends notified events to sink. With quiet, the console lines
+// they would otherwise print are left out.
+func (nm *NetworkMonitor) SetEventSink(sink EventSink, quiet bool) {
+	nm.sink.Store(&eventSink{sink: sink, quiet: quiet})
+}
+
+// emit hands an event to the sink and to the alert routes it fires,
+// reporting whether the console line should still be printed
+func (nm *NetworkMonitor) emit(recordType string, data any) bool {
+	nm.routeAlert(recordType, data)
+	s := nm.sink.Load()
+	if s == nil {
+		return true
+	}
+	s.sink.Emit(recordType, data)
+	return !s.quiet
+}
@@ -0,0 +1,323 @@
+package monitor
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// SnapshotKeyPrefix prefixes device state snapshots in the database, followed
+// by the device ID and the time taken.
+const SnapshotKeyPrefix = "snapshot:"
+
+// snapshotKeyFormat names the time of a snapshot key; it sorts chronologically
+const snapshotKeyFormat = "20060102T150405Z"
+
+// maxSnapshotTop bounds the domains and services kept per snapshot
+const maxSnapshotTop = 10
+
+// snapshotRecent is the age up to which every snapshot is kept
+const snapshotRecent = 24 * time.Hour
+
+// SnapshotConfig controls the periodic device state snapshots. Snapshots are
+// thinned as they age: all are kept for a day, then one per day until Daily,
+// then one per week until Retention.
+type SnapshotConfig struct {
+	Interval  time.Duration // Between snapshots of a device active meanwhile (0 disables them)
+	Daily     time.Duration // Age up to which one snapshot per day is kept
+	Retention time.Duration // Age after which snapshots are deleted (0 keeps them forever)
+}
+
+// DefaultSnapshotConfig returns the default snapshot settings
+func DefaultSnapshotConfig() SnapshotConfig {
+	return SnapshotConfig{
+		Interval:  24 * time.Hour,
+		Daily:     30 * 24 * time.Hour,
+		Retention: 365 * 24 * time.Hour,
+	}
+}
+
+// snapshotTracker remembers when each device was last snapshotted. It is
+// guarded by nm.mu.
+type snapshotTracker struct {
+	config SnapshotConfig
+	taken  map[string]time.Time
+}
+
+func newSnapshotTracker(config SnapshotConfig) *snapshotTracker {
+	return &snapshotTracker{config: config, taken: make(map[string]time.Time)}
+}
+
+// SetSnapshotConfig replaces the snapshot settings
+func (nm *NetworkMonitor) SetSnapshotConfig(config SnapshotConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.snapshots.config = config
+}
+
+func snapshotKey(deviceID string, taken time.Time) string {
+	return SnapshotKeyPrefix + deviceID + ":" + taken.UTC().Format(snapshotKeyFormat)
+}
+
+// snapshotKeyTime splits a snapshot key into its device ID and time
+func snapshotKeyTime(key string) (string, time.Time, bool) {
+	rest := strings.TrimPrefix(key, SnapshotKeyPrefix)
+	if len(rest) < len(snapshotKeyFormat)+2 {
+		return "", time.Time{}, false
+	}
+	split := len(rest) - len(snapshotKeyFormat)
+	taken, err := time.Parse(snapshotKeyFormat, rest[split:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return rest[:split-1], taken, true
+}
+
+// loadSnapshots finds when each device was last snapshotted
+func (nm *NetworkMonitor) loadSnapshots() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", SnapshotKeyPrefix, SnapshotKeyPrefix+"~", func(key, _ string) bool {
+			if id, taken, ok := snapshotKeyTime(key); ok && taken.After(nm.snapshots.taken[id]) {
+				nm.snapshots.taken[id] = taken
+			}
+			return true
+		})
+	})
+}
+
+// summarizeSnapshot condenses the state of a device
+func summarizeSnapshot(device *models.DeviceInfo, weights RiskWeights, now time.Time) models.DeviceSnapshot {
+	return models.DeviceSnapshot{
+		DeviceID:  device.ID,
+		Taken:     now,
+		IP:        device.IP,
+		LastSeen:  device.LastSeen,
+		RiskScore: ScoreDevice(device, weights).Score,
+		Counters: map[string]int{
+			"request_count":      device.RequestCount,
+			"reply_count":        device.ReplyCount,
+			"tcp_connections":    device.TCPConnections,
+			"udp_connections":    device.UDPConnections,
+			"icmp_packets":       device.ICMPPackets,
+			"dns_queries":        device.DNSQueries,
+			"http_requests":      device.HTTPRequests,
+			"tls_connections":    device.TLSConnections,
+			"threat_port_access": device.ThreatPortAccess,
+			"external_patterns":  device.ExternalPatterns,
+			"scan_patterns":      device.ScanPatterns,
+			"patterns":           len(device.SeenPatterns),
+		},
+		TopDomains:  topNames(device.DNSDomains, maxSnapshotTop),
+		TopServices: topNames(device.Services.Totals(), maxSnapshotTop),
+	}
+}
+
+// topNames returns the n names with the highest counts, ties by name
+func topNames(counts map[string]int, n int) []string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names[:min(n, len(names))]
+}
+
+// takeSnapshots snapshots the cached devices seen since their last snapshot,
+// once the interval has passed. Must hold nm.mu.
+func (nm *NetworkMonitor) takeSnapshots(now time.Time) []models.DeviceSnapshot {
+	s := nm.snapshots
+	if s.config.Interval <= 0 {
+		return nil
+	}
+	var snapshots []models.DeviceSnapshot
+	for _, id := range nm.Cache.Keys() {
+		device, ok := nm.Cache.Get(id)
+		if !ok {
+			continue
+		}
+		last := s.taken[id]
+		if !device.LastSeen.After(last) || now.Sub(last) < s.config.Interval {
+			continue
+		}
+		snapshots = append(snapshots, summarizeSnapshot(device, nm.riskWeights, now))
+		s.taken[id] = now
+	}
+	return snapshots
+}
+
+// forgetSnapshots makes the devices of snapshots that failed to persist due
+// again
+func (nm *NetworkMonitor) forgetSnapshots(snapshots []models.DeviceSnapshot) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	for _, snapshot := range snapshots {
+		if nm.snapshots.taken[snapshot.DeviceID].Equal(snapshot.Taken) {
+			delete(nm.snapshots.taken, snapshot.DeviceID)
+		}
+	}
+}
+
+// writeSnapshots stores snapshots and thins the older ones of their devices
+func writeSnapshots(tx *buntdb.Tx, snapshots []models.DeviceSnapshot, config SnapshotConfig) error {
+	var opts *buntdb.SetOptions
+	if config.Retention > 0 {
+		opts = &buntdb.SetOptions{Expires: true, TTL: config.Retention}
+	}
+	for _, snapshot := range snapshots {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if _, _, err := tx.Set(snapshotKey(snapshot.DeviceID, snapshot.Taken), string(data), opts); err != nil {
+			return err
+		}
+
+		prefix := SnapshotKeyPrefix + snapshot.DeviceID + ":"
+		var taken []time.Time
+		tx.AscendRange("", prefix, prefix+"~", func(key, _ string) bool {
+			if _, at, ok := snapshotKeyTime(key); ok {
+				taken = append(taken, at)
+			}
+			return true
+		})
+		for _, at := range thinSnapshots(taken, snapshot.Taken, config) {
+			tx.Delete(snapshotKey(snapshot.DeviceID, at))
+		}
+	}
+	return nil
+}
+
+// thinSnapshots returns the snapshot times, oldest first, to delete as of now:
+// all but the latest of each day past a day old, of each week (from Monday)
+// past config.Daily, and all past config.Retention
+func thinSnapshots(taken []time.Time, now time.Time, config SnapshotConfig) []time.Time {
+	days := make(map[time.Time]bool)
+	weeks := make(map[time.Time]bool)
+	var drop []time.Time
+	for i := len(taken) - 1; i >= 0; i-- {
+		at := taken[i]
+		age := now.Sub(at)
+		var buckets map[time.Time]bool
+		var bucket time.Time
+		switch {
+		case age < snapshotRecent:
+			continue
+		case config.Retention > 0 && age >= config.Retention:
+			drop = append(drop, at)
+			continue
+		case age < config.Daily:
+			buckets, bucket = days, at.Truncate(24*time.Hour)
+		default:
+			// The zero time is a Monday, so weeks start on Mondays
+			buckets, bucket = weeks, at.Truncate(7*24*time.Hour)
+		}
+		if buckets[bucket] {
+			drop = append(drop, at)
+		} else {
+			buckets[bucket] = true
+		}
+	}
+	slices.Reverse(drop)
+	return drop
+}
+
+// DeviceSnapshots returns the snapshots of a device, oldest first, and
+// whether there are any
+func (nm *NetworkMonitor) DeviceSnapshots(id string) ([]models.DeviceSnapshot, bool) {
+	snapshots := []models.DeviceSnapshot{}
+	prefix := SnapshotKeyPrefix + id + ":"
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", prefix, prefix+"~", func(key, value string) bool {
+			var snapshot models.DeviceSnapshot
+			if json.Unmarshal([]byte(value), &snapshot) == nil {
+				snapshots = append(snapshots, snapshot)
+			}
+			return true
+		})
+	})
+	return snapshots, len(snapshots) > 0
+}
+
+// nearestSnapshot returns the index of the snapshot taken nearest to at; of two
+// as near, the earlier. snapshots must be oldest first and not empty.
+func nearestSnapshot(snapshots []models.DeviceSnapshot, at time.Time) int {
+	i := sort.Search(len(snapshots), func(i int) bool {
+		return !snapshots[i].Taken.Before(at)
+	})
+	if i == len(snapshots) {
+		return i - 1
+	}
+	if i > 0 && at.Sub(snapshots[i-1].Taken) <= snapshots[i].Taken.Sub(at) {
+		return i - 1
+	}
+	return i
+}
+
+// DeviceAsOf returns the snapshot of a device nearest to at, and how the
+// device changed since if it is tracked now. It reports false when the device
+// has no snapshots.
+func (nm *NetworkMonitor) DeviceAsOf(id string, at time.Time) (models.DeviceAsOf, bool) {
+	snapshots, ok := nm.DeviceSnapshots(id)
+	if !ok {
+		return models.DeviceAsOf{}, false
+	}
+	result := models.DeviceAsOf{
+		Requested: at,
+		Snapshot:  snapshots[nearestSnapshot(snapshots, at)],
+	}
+
+	nm.mu.RLock()
+	weights := nm.riskWeights
+	nm.mu.RUnlock()
+	if device, ok := nm.Cache.Get(id); ok {
+		unlock := nm.lockDevice(id)
+		current := summarizeSnapshot(device, weights, time.Now())
+		unlock()
+		result.Current = &current
+	}
+
+	if result.Current != nil {
+		delta := diffSnapshots(result.Snapshot, *result.Current)
+		result.Delta = &delta
+	}
+	return result, true
+}
+
+// diffSnapshots describes how a device changed from then to now
+func diffSnapshots(then, now models.DeviceSnapshot) models.SnapshotDelta {
+	delta := models.SnapshotDelta{
+		Counters:    make(map[string]int),
+		RiskScore:   now.RiskScore - then.RiskScore,
+		NewDomains:  []string{},
+		NewServices: []string{},
+	}
+	for name, value := range now.Counters {
+		if change := value - then.Counters[name]; change != 0 {
+			delta.Counters[name] = change
+		}
+	}
+	if now.IP != then.IP {
+		delta.IP = &models.FieldChange{Old: then.IP, New: now.IP}
+	}
+	for _, domain := range now.TopDomains {
+		if !slices.Contains(then.TopDomains, domain) {
+			delta.NewDomains = append(delta.NewDomains, domain)
+		}
+	}
+	for _, service := range now.TopServices {
+		if !slices.Contains(then.TopServices, service) {
+			delta.NewServices = append(delta.NewServices, service)
+		}
+	}
+	return delta
+}
@@ -0,0 +1,157 @@
+package monitor
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// The snapshot nearest to a time is picked, the earlier of two as near, and
+// times outside the snapshots get the first or the last
+func TestNearestSnapshot(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	snapshots := []models.DeviceSnapshot{
+		{Taken: day},
+		{Taken: day.Add(24 * time.Hour)},
+		{Taken: day.Add(48 * time.Hour)},
+	}
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{"long before", day.AddDate(-1, 0, 0), 0},
+		{"first", day, 0},
+		{"just after first", day.Add(time.Second), 0},
+		{"halfway", day.Add(12 * time.Hour), 0},
+		{"past halfway", day.Add(12*time.Hour + time.Nanosecond), 1},
+		{"just before second", day.Add(24*time.Hour - time.Second), 1},
+		{"second", day.Add(24 * time.Hour), 1},
+		{"last", day.Add(48 * time.Hour), 2},
+		{"long after", day.AddDate(1, 0, 0), 2},
+	}
+	for _, tt := range tests {
+		if got := nearestSnapshot(snapshots, tt.at); got != tt.want {
+			t.Errorf("%s: nearest = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+	if got := nearestSnapshot(snapshots[:1], day.Add(time.Hour)); got != 0 {
+		t.Errorf("nearest of one = %d", got)
+	}
+}
+
+// Snapshots are all kept for a day, then the latest of each UTC day until
+// Daily, then the latest of each week from Monday until Retention
+func TestThinSnapshots(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC) // A Wednesday
+	config := SnapshotConfig{Interval: time.Hour, Daily: 30 * 24 * time.Hour, Retention: 365 * 24 * time.Hour}
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.DateTime, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	kept := []string{
+		"2025-03-04 13:00:00", // Just within retention
+		"2026-01-18 23:00:00", // Latest of the week from Monday 12 January
+		"2026-01-19 00:00:00", // The next Monday
+		"2026-02-02 12:00:00", // Exactly Daily old: the latest of its week
+		"2026-02-02 18:00:00", // Just within Daily: the latest of its day
+		"2026-03-02 20:00:00",
+		"2026-03-03 12:00:00", // Exactly a day old: the latest of its day
+		"2026-03-03 18:00:00", // Within a day, all are kept
+		"2026-03-04 09:00:00",
+		"2026-03-04 11:00:00",
+	}
+	dropped := []string{
+		"2025-03-04 12:00:00", // Exactly Retention old
+		"2026-01-12 01:00:00",
+		"2026-02-02 08:00:00",
+		"2026-03-02 08:00:00",
+		"2026-03-03 06:00:00",
+	}
+	var taken, want []time.Time
+	for _, value := range append(slices.Clone(kept), dropped...) {
+		taken = append(taken, at(value))
+	}
+	for _, value := range dropped {
+		want = append(want, at(value))
+	}
+	slices.SortFunc(taken, time.Time.Compare)
+	slices.SortFunc(want, time.Time.Compare)
+
+	if got := thinSnapshots(taken, now, config); !slices.Equal(got, want) {
+		t.Errorf("dropped %v, want %v", got, want)
+	}
+
+	// Without retention the oldest stay too, thinned to one per week
+	config.Retention = 0
+	want = []time.Time{at("2025-03-04 12:00:00"), at("2026-01-12 01:00:00"), at("2026-02-02 08:00:00"), at("2026-03-02 08:00:00"), at("2026-03-03 06:00:00")}
+	if got := thinSnapshots(taken, now, config); !slices.Equal(got, want) {
+		t.Errorf("without retention dropped %v, want %v", got, want)
+	}
+}
+
+// Snapshot keys split back into the device and the time, whatever colons the
+// device ID holds
+func TestSnapshotKey(t *testing.T) {
+	taken := time.Date(2026, 3, 2, 9, 30, 15, 0, time.FixedZone("CET", 3600))
+	key := snapshotKey("02:00:00:00:00:01", taken)
+	if key != "snapshot:02:00:00:00:00:01:20260302T083015Z" {
+		t.Errorf("key = %q", key)
+	}
+	id, at, ok := snapshotKeyTime(key)
+	if !ok || id != "02:00:00:00:00:01" || !at.Equal(taken) {
+		t.Errorf("snapshotKeyTime = %q, %v, %v", id, at, ok)
+	}
+	for _, key := range []string{"snapshot:", "snapshot:x:2026", "snapshot:x:20260302T083015"} {
+		if _, _, ok := snapshotKeyTime(key); ok {
+			t.Errorf("snapshotKeyTime(%q) succeeded", key)
+		}
+	}
+}
+
+// The persist pass snapshots only devices active since their last snapshot,
+// and as-of answers compare the snapshot with the current state
+func TestSnapshotPersist(t *testing.T) {
+	nm := newTestMonitor(t, 16)
+	nm.SetSnapshotConfig(SnapshotConfig{Interval: time.Nanosecond, Daily: 30 * 24 * time.Hour})
+	mac := "02:00:00:00:00:0a"
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "203.0.113.5", 443))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0b", "192.168.1.11", "203.0.113.5", 443))
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, ok := nm.DeviceSnapshots(mac)
+	if !ok || len(snapshots) != 1 {
+		t.Fatalf("%d snapshots after the first pass, want 1", len(snapshots))
+	}
+	if snapshots[0].IP != "192.168.1.10" || snapshots[0].Counters["tcp_connections"] != 1 {
+		t.Errorf("snapshot = %+v", snapshots[0])
+	}
+
+	// Nothing was seen since, so the next pass takes none however late
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, _ := nm.DeviceSnapshots(mac); len(snapshots) != 1 {
+		t.Errorf("%d snapshots of an idle device, want 1", len(snapshots))
+	}
+	if _, ok := nm.DeviceSnapshots("02:00:00:00:00:ff"); ok {
+		t.Error("snapshots of an unknown device")
+	}
+
+	nm.TrackEvent(tcpEvent(t, mac, "192.168.1.10", "198.51.100.7", 22))
+	asOf, ok := nm.DeviceAsOf(mac, snapshots[0].Taken.Add(-time.Hour))
+	if !ok || !asOf.Snapshot.Taken.Equal(snapshots[0].Taken) {
+		t.Fatalf("DeviceAsOf = %+v, %v", asOf, ok)
+	}
+	if asOf.Current == nil || asOf.Delta == nil || asOf.Delta.Counters["tcp_connections"] != 1 || asOf.Delta.IP != nil {
+		t.Errorf("current %+v, delta %+v", asOf.Current, asOf.Delta)
+	}
+}
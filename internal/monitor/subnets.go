@@ -0,0 +1,96 @@
+package monitor
+
+import (
+	"net"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// OtherSubnet groups devices whose IP is in no detected local subnet
+const OtherSubnet = "other/routed"
+
+// localSubnets returns the detected local subnets in network form (the
+// interface addresses carry host bits), without duplicates
+func (nm *NetworkMonitor) localSubnets() []*net.IPNet {
+	seen := make(map[string]bool)
+	var subnets []*net.IPNet
+	for _, subnet := range nm.topology.LocalSubnets {
+		network := &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+		if !seen[network.String()] {
+			seen[network.String()] = true
+			subnets = append(subnets, network)
+		}
+	}
+	return subnets
+}
+
+// subnetOf returns the local subnet containing ip in CIDR form, the most
+// specific one if several overlap, or OtherSubnet. It returns "" for devices
+// without a known IP.
+func (nm *NetworkMonitor) subnetOf(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.IsUnspecified() {
+		return ""
+	}
+
+	var best *net.IPNet
+	for _, subnet := range nm.localSubnets() {
+		if !subnet.Contains(addr) {
+			continue
+		}
+		if best == nil || maskLength(subnet) > maskLength(best) {
+			best = subnet
+		}
+	}
+	if best == nil {
+		return OtherSubnet
+	}
+	return best.String()
+}
+
+func maskLength(subnet *net.IPNet) int {
+	ones, _ := subnet.Mask.Size()
+	return ones
+}
+
+// SubnetStats aggregates the tracked devices per local subnet. Every detected
+// subnet is listed, in detection order, followed by OtherSubnet. Devices are
+// counted holding only their stripe.
+func (nm *NetworkMonitor) SubnetStats() []models.SubnetStats {
+	nm.mu.RLock()
+	subnets := nm.localSubnets()
+	nm.mu.RUnlock()
+
+	var stats []models.SubnetStats
+	index := make(map[string]int)
+	for _, subnet := range append(subnets, nil) {
+		name := OtherSubnet
+		if subnet != nil {
+			name = subnet.String()
+		}
+		index[name] = len(stats)
+		stats = append(stats, models.SubnetStats{Subnet: name})
+	}
+
+	now := time.Now()
+	nm.eachDevice(func(_ string, device *models.DeviceInfo) {
+		i, ok := index[device.Subnet]
+		if !ok {
+			return
+		}
+
+		s := &stats[i]
+		s.Devices++
+		if now.Sub(device.LastSeen) <= activityActiveWindow {
+			s.Active++
+		}
+		if device.Self {
+			return // Its packets aren't the network's, see PacketStats.SelfPackets
+		}
+		for _, count := range device.TrafficTypeCounts {
+			s.Packets += count
+		}
+	})
+	return stats
+}
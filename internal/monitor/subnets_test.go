@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"net"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// setLocalSubnets replaces the detected local subnets, given as interface
+// addresses the way they are detected
+func setLocalSubnets(t testing.TB, nm *NetworkMonitor, cidrs ...string) {
+	t.Helper()
+	nm.topology.LocalSubnets = nil
+	for _, cidr := range cidrs {
+		ip, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subnet.IP = ip
+		nm.topology.LocalSubnets = append(nm.topology.LocalSubnets, subnet)
+	}
+}
+
+func TestSubnetOf(t *testing.T) {
+	nm := newTestMonitor(t, 100)
+	setLocalSubnets(t, nm, "192.168.2.1/24", "192.168.20.1/24", "10.0.0.1/8", "10.5.0.1/16")
+
+	tests := []struct {
+		ip, want string
+	}{
+		{"192.168.2.5", "192.168.2.0/24"},
+		// A string prefix of 192.168.2 would put it in 192.168.2.0/24
+		{"192.168.20.5", "192.168.20.0/24"},
+		{"192.168.200.5", OtherSubnet},
+		{"10.1.2.3", "10.0.0.0/8"},
+		// Overlapping subnets: the most specific one
+		{"10.5.6.7", "10.5.0.0/16"},
+		{"203.0.113.9", OtherSubnet},
+		{"0.0.0.0", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := nm.subnetOf(tt.ip); got != tt.want {
+			t.Errorf("subnetOf(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// Devices move with their IP and are counted per subnet, the detected ones
+// listed even when empty
+func TestSubnetStats(t *testing.T) {
+	nm := newTestMonitor(t, 100)
+	setLocalSubnets(t, nm, "192.168.2.1/24", "192.168.20.1/24", "192.168.30.1/24")
+
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0a", "192.168.2.5", "203.0.113.10", 443))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0a", "192.168.2.5", "203.0.113.10", 80))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0b", "192.168.20.5", "203.0.113.10", 443))
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0c", "172.16.0.5", "203.0.113.10", 443))
+
+	device, _ := nm.GetDevice("02:00:00:00:00:0b")
+	if device.Subnet != "192.168.20.0/24" {
+		t.Fatalf("device subnet %q, want 192.168.20.0/24", device.Subnet)
+	}
+
+	want := []models.SubnetStats{
+		{Subnet: "192.168.2.0/24", Devices: 1, Active: 1, Packets: 2},
+		{Subnet: "192.168.20.0/24", Devices: 1, Active: 1, Packets: 1},
+		{Subnet: "192.168.30.0/24"},
+		{Subnet: OtherSubnet, Devices: 1, Active: 1, Packets: 1},
+	}
+	checkSubnetStats(t, nm.SubnetStats(), want)
+
+	// The device takes a new IP in another subnet
+	nm.TrackEvent(tcpEvent(t, "02:00:00:00:00:0b", "192.168.30.7", "203.0.113.10", 443))
+	device, _ = nm.GetDevice("02:00:00:00:00:0b")
+	if device.Subnet != "192.168.30.0/24" {
+		t.Fatalf("device subnet %q after an IP change, want 192.168.30.0/24", device.Subnet)
+	}
+	want[1] = models.SubnetStats{Subnet: "192.168.20.0/24"}
+	want[2] = models.SubnetStats{Subnet: "192.168.30.0/24", Devices: 1, Active: 1, Packets: 2}
+	checkSubnetStats(t, nm.SubnetStats(), want)
+}
+
+func checkSubnetStats(t *testing.T, got, want []models.SubnetStats) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("subnet stats %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("subnet stats[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
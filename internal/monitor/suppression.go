@@ -0,0 +1,276 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// SuppressionKeyPrefix prefixes persisted suppression rules in the database.
+const SuppressionKeyPrefix = "suppression:"
+
+// ErrSuppressionNotFound is returned when deleting an unknown suppression
+var ErrSuppressionNotFound = errors.New("suppression not found")
+
+// transportProtocols maps the protocols of classified traffic to the transport
+// carrying it, so a "TCP" rule also covers HTTP and TLS patterns
+var transportProtocols = map[string]string{
+	"HTTP": "TCP",
+	"TLS":  "TCP",
+	"DNS":  "UDP",
+}
+
+var suppressionProtocols = map[string]bool{
+	"ARP": true, "ICMP": true, "TCP": true, "UDP": true, "DNS": true, "HTTP": true, "TLS": true,
+}
+
+// suppressionRule is a suppression with its destination parsed for matching
+type suppressionRule struct {
+	models.Suppression
+	destination *net.IPNet
+	seq         uint64 // Creation order, breaks specificity ties
+}
+
+func (r *suppressionRule) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// specificity is the number of criteria a rule sets
+func (r *suppressionRule) specificity() int {
+	n := 0
+	for _, set := range []bool{r.Device != "", r.destination != nil, r.Domain != "", r.Port != 0, r.Protocol != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *suppressionRule) matches(deviceID string, dstIP net.IP, dstPort uint16, protocol, domain string) bool {
+	if r.Device != "" && r.Device != deviceID {
+		return false
+	}
+	if r.destination != nil && !r.destination.Contains(dstIP) {
+		return false
+	}
+	if r.Domain != "" && domain != r.Domain && !strings.HasSuffix(domain, "."+r.Domain) {
+		return false
+	}
+	if r.Port != 0 && r.Port != dstPort {
+		return false
+	}
+	if r.Protocol != "" && r.Protocol != protocol && r.Protocol != transportProtocols[protocol] {
+		return false
+	}
+	return true
+}
+
+// newSuppressionRule validates and normalizes a suppression
+func newSuppressionRule(s models.Suppression) (*suppressionRule, error) {
+	s.Device = strings.ToLower(strings.TrimSpace(s.Device))
+	s.Domain = strings.Trim(strings.ToLower(strings.TrimSpace(s.Domain)), ".")
+	s.Protocol = strings.ToUpper(strings.TrimSpace(s.Protocol))
+	s.Destination = strings.TrimSpace(s.Destination)
+
+	rule := &suppressionRule{Suppression: s}
+
+	if s.Destination != "" {
+		if ip := net.ParseIP(s.Destination); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			rule.destination = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else if _, cidr, err := net.ParseCIDR(s.Destination); err == nil {
+			rule.destination = cidr
+		} else {
+			return nil, fmt.Errorf("invalid destination %q: expected an IP or CIDR", s.Destination)
+		}
+	}
+
+	if s.Protocol != "" && !suppressionProtocols[s.Protocol] {
+		return nil, fmt.Errorf("unknown protocol %q", s.Protocol)
+	}
+
+	switch s.Mode {
+	case "":
+		rule.Mode = models.SuppressHide
+	case models.SuppressHide, models.SuppressExempt:
+	default:
+		return nil, fmt.Errorf("invalid mode %q: expected %s or %s", s.Mode, models.SuppressHide, models.SuppressExempt)
+	}
+
+	if rule.specificity() == 0 {
+		return nil, fmt.Errorf("a suppression needs at least one of device, destination, domain, port or protocol")
+	}
+
+	return rule, nil
+}
+
+// suppressionSeq parses the sequence number out of an "s-<n>" ID
+func suppressionSeq(id string) uint64 {
+	n, _ := strconv.ParseUint(strings.TrimPrefix(id, "s-"), 10, 64)
+	return n
+}
+
+// suppressionOptions makes a persisted rule expire with the rule itself
+func suppressionOptions(s models.Suppression, now time.Time) *buntdb.SetOptions {
+	if s.ExpiresAt == nil {
+		return nil
+	}
+	return &buntdb.SetOptions{Expires: true, TTL: s.ExpiresAt.Sub(now)}
+}
+
+// loadSuppressions reads the persisted suppression rules
+func (nm *NetworkMonitor) loadSuppressions() {
+	nm.suppressions = make(map[string]*suppressionRule)
+
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendRange("", SuppressionKeyPrefix, SuppressionKeyPrefix+"~", func(key, value string) bool {
+			var s models.Suppression
+			if json.Unmarshal([]byte(value), &s) != nil {
+				return true
+			}
+			rule, err := newSuppressionRule(s)
+			if err != nil {
+				return true
+			}
+			rule.seq = suppressionSeq(s.ID)
+			nm.suppressions[s.ID] = rule
+			nm.suppressionSeq = max(nm.suppressionSeq, rule.seq)
+			return true
+		})
+	})
+}
+
+// AddSuppression validates, persists and activates a suppression rule. The ID,
+// creation time and hit counters of s are ignored.
+func (nm *NetworkMonitor) AddSuppression(s models.Suppression) (models.Suppression, error) {
+	now := time.Now()
+	s.CreatedAt = now
+	s.Hits = 0
+	s.LastHit = nil
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(now) {
+		return models.Suppression{}, fmt.Errorf("expires_at must be in the future")
+	}
+
+	rule, err := newSuppressionRule(s)
+	if err != nil {
+		return models.Suppression{}, err
+	}
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	nm.suppressionSeq++
+	rule.seq = nm.suppressionSeq
+	rule.ID = fmt.Sprintf("s-%d", rule.seq)
+
+	data, _ := json.Marshal(rule.Suppression)
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(SuppressionKeyPrefix+rule.ID, string(data), suppressionOptions(rule.Suppression, now))
+		return err
+	})
+	if err != nil {
+		return models.Suppression{}, fmt.Errorf("failed to persist suppression: %w", err)
+	}
+
+	nm.suppressions[rule.ID] = rule
+	return rule.Suppression, nil
+}
+
+// DeleteSuppression removes a suppression rule
+func (nm *NetworkMonitor) DeleteSuppression(id string) error {
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	if _, ok := nm.suppressions[id]; !ok {
+		return ErrSuppressionNotFound
+	}
+
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		_, err := tx.Delete(SuppressionKeyPrefix + id)
+		if err == buntdb.ErrNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete suppression: %w", err)
+	}
+
+	delete(nm.suppressions, id)
+	return nil
+}
+
+// Suppressions returns the active suppression rules with their hit counters,
+// oldest first
+func (nm *NetworkMonitor) Suppressions() []models.Suppression {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	now := time.Now()
+	rules := make([]*suppressionRule, 0, len(nm.suppressions))
+	for _, rule := range nm.suppressions {
+		if !rule.expired(now) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].seq < rules[j].seq })
+
+	suppressions := make([]models.Suppression, len(rules))
+	for i, rule := range rules {
+		suppressions[i] = rule.Suppression
+	}
+	return suppressions
+}
+
+// suppress finds the rule suppressing a new pattern and counts the hit. When
+// several rules match, the most specific wins, then the oldest. Must hold nm.mu.
+func (nm *NetworkMonitor) suppress(deviceID string, dstIP net.IP, dstPort uint16, protocol, domain string, now time.Time) *suppressionRule {
+	var best *suppressionRule
+	for id, rule := range nm.suppressions {
+		if rule.expired(now) {
+			delete(nm.suppressions, id)
+			continue
+		}
+		if !rule.matches(deviceID, dstIP, dstPort, protocol, domain) {
+			continue
+		}
+		if best == nil || rule.specificity() > best.specificity() ||
+			(rule.specificity() == best.specificity() && rule.seq < best.seq) {
+			best = rule
+		}
+	}
+
+	if best != nil {
+		best.Hits++
+		hit := now
+		best.LastHit = &hit
+		nm.suppressionHits = true
+	}
+	return best
+}
+
+// writeSuppressionHits persists the hit counters of rules that still exist
+func writeSuppressionHits(tx *buntdb.Tx, rules []models.Suppression, now time.Time) error {
+	for _, s := range rules {
+		key := SuppressionKeyPrefix + s.ID
+		if _, err := tx.Get(key); err != nil {
+			continue // Deleted or expired since the snapshot
+		}
+		data, _ := json.Marshal(s)
+		if _, _, err := tx.Set(key, string(data), suppressionOptions(s, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// When several suppressions match a pattern, the one setting the most
+// criteria wins, then the oldest; expired rules are ignored and every
+// suppressed pattern counts a hit on the winning rule, persisted by a flush
+func TestSuppressionPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const device = "02:00:00:00:00:0a"
+	expires := time.Now().Add(time.Hour)
+	for _, s := range []models.Suppression{
+		{Protocol: "tcp"},                          // s-1
+		{Destination: "203.0.113.0/24"},            // s-2
+		{Destination: "203.0.113.0/24", Port: 443}, // s-3
+		{Device: "02:00:00:00:00:0A", Destination: "203.0.113.5", Port: 443,
+			ExpiresAt: &expires}, // s-4
+		{Domain: "Example.com.", Mode: models.SuppressExempt}, // s-5
+		{Destination: "203.0.113.0/24", Port: 443},            // s-6, as specific as s-3 but newer
+	} {
+		if _, err := nm.AddSuppression(s); err != nil {
+			t.Fatalf("AddSuppression(%+v): %v", s, err)
+		}
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		device   string
+		dst      string
+		port     uint16
+		protocol string
+		domain   string
+		at       time.Time
+		want     string // Rule ID, "" for none
+	}{
+		{"device, host and port", device, "203.0.113.5", 443, "TCP", "", now, "s-4"},
+		{"other device: subnet and port, oldest of two", "02:00:00:00:00:0b", "203.0.113.5", 443, "TLS", "", now, "s-3"},
+		{"protocol and subnet tie", device, "203.0.113.5", 80, "HTTP", "", now, "s-1"},
+		{"subnet alone", device, "203.0.113.9", 53, "UDP", "", now, "s-2"},
+		{"subdomain", device, "198.51.100.1", 53, "DNS", "www.example.com", now, "s-5"},
+		{"suffix is not a subdomain", device, "198.51.100.1", 53, "DNS", "badexample.com", now, ""},
+		{"no rule", device, "198.51.100.1", 123, "UDP", "", now, ""},
+		{"expired rule ignored", device, "203.0.113.5", 443, "TCP", "", expires, "s-3"},
+		{"expired rule stays ignored", device, "203.0.113.5", 443, "TCP", "", now, "s-3"},
+	}
+	for _, tt := range tests {
+		nm.lockAll()
+		rule := nm.suppress(tt.device, net.ParseIP(tt.dst), tt.port, tt.protocol, tt.domain, tt.at)
+		nm.unlockAll()
+		got := ""
+		if rule != nil {
+			got = rule.ID
+		}
+		if got != tt.want {
+			t.Errorf("%s: suppressed by %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	wantHits := map[string]uint64{"s-1": 1, "s-2": 1, "s-3": 3, "s-5": 1, "s-6": 0}
+	checkHits := func(when string, suppressions []models.Suppression) {
+		t.Helper()
+		found := 0
+		for _, s := range suppressions {
+			want, ok := wantHits[s.ID]
+			if !ok {
+				continue
+			}
+			found++
+			if s.Hits != want || (s.LastHit != nil) != (want > 0) {
+				t.Errorf("%s: %s has %d hits (last %v), want %d", when, s.ID, s.Hits, s.LastHit, want)
+			}
+		}
+		if found != len(wantHits) {
+			t.Errorf("%s: %d of the rules, want %d", when, found, len(wantHits))
+		}
+	}
+	if n := len(nm.Suppressions()); n != len(wantHits) {
+		t.Errorf("%d suppressions, want %d without the expired one", n, len(wantHits))
+	}
+	checkHits("before the flush", nm.Suppressions())
+
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	nm.Close()
+	nm, err = NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nm.Close()
+	// s-4 is back: it only expired on the clock the test passed to suppress
+	checkHits("after a restart", nm.Suppressions())
+}
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/threatintel"
+)
+
+// threatRealert is how long a device stays quiet after an alert for a list
+// entry before matching it again raises another
+const threatRealert = 24 * time.Hour
+
+type threatAlertKey struct {
+	deviceID string
+	list     string
+	entry    string
+}
+
+// SetThreatIntel sets the threat lists external destinations and domains are
+// matched against; nil disables matching
+func (nm *NetworkMonitor) SetThreatIntel(feed *threatintel.Feed) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.threatIntel = feed
+}
+
+// ThreatLists returns the state of every threat list
+func (nm *NetworkMonitor) ThreatLists() []models.ThreatList {
+	nm.mu.RLock()
+	feed := nm.threatIntel
+	nm.mu.RUnlock()
+	if feed == nil {
+		return []models.ThreatList{}
+	}
+	return feed.Lists()
+}
+
+// hostDomain returns the domain of a Host header, without its port
+func hostDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return normalizeDomain(host)
+}
+
+// matchThreats matches an external destination and a domain the device named
+// against the threat lists. The first match of a list entry by a device within
+// threatRealert raises a HIGH anomaly. It returns the annotations for the
+// pattern of the event and the verdict on it. Must hold nm.mu.
+func (nm *NetworkMonitor) matchThreats(deviceID string, dst net.IP, external bool, domain, via string, now time.Time) ([]models.Annotation, verdict) {
+	if nm.threatIntel == nil {
+		return nil, skipped("no threat lists loaded")
+	}
+
+	var annotations []models.Annotation
+	result := notMatched("destination and domain not listed")
+	alert := func(match threatintel.Match, matched, kind string) {
+		annotations, _ = addAnnotation(annotations,
+			models.Annotation{Type: models.AnnotationThreat, ID: match.List + ":" + match.Entry})
+
+		key := threatAlertKey{deviceID, match.List, match.Entry}
+		if alerted, ok := nm.threatAlerts[key]; ok && now.Sub(alerted) < threatRealert {
+			if result.outcome != OutcomeMatched {
+				result = verdict{outcome: OutcomeMatched, reason: "listed, already alerted within 24h"}
+			}
+			return
+		}
+		nm.threatAlerts[key] = now
+
+		details := map[string]string{
+			"list":    match.List,
+			"entry":   match.Entry,
+			"matched": matched,
+		}
+		if via != "" && kind == "domain" {
+			details["via"] = via
+		}
+		anomaly := nm.raiseAnomaly("THREAT_INTEL_MATCH", models.SeverityHigh, deviceID,
+			map[string]string{"list": match.List, "kind": kind, "indicator": matched, "entry": match.Entry},
+			details)
+		if anomaly != nil {
+			annotations, _ = addAnnotation(annotations,
+				models.Annotation{Type: models.AnnotationAnomaly, ID: anomaly.ID})
+		}
+		if result.anomaly == nil {
+			result = raised("listed", anomaly)
+		}
+	}
+
+	if addr, ok := netip.AddrFromSlice(dst); ok && external {
+		if match, ok := nm.threatIntel.MatchIP(addr, now); ok {
+			alert(match, addr.Unmap().String(), "destination")
+		}
+	}
+	if domain = normalizeDomain(domain); domain != "" && strings.Contains(domain, ".") {
+		if match, ok := nm.threatIntel.MatchDomain(domain, now); ok {
+			alert(match, domain, "domain")
+		}
+	}
+	return annotations, result
+}
+
+// pruneThreatAlerts forgets alerts older than threatRealert
+func (nm *NetworkMonitor) pruneThreatAlerts(now time.Time) {
+	for key, alerted := range nm.threatAlerts {
+		if now.Sub(alerted) >= threatRealert {
+			delete(nm.threatAlerts, key)
+		}
+	}
+}
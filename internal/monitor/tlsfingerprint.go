@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// ja3Entry aggregates the sightings of one JA3 fingerprint
+type ja3Entry struct {
+	ja3     string
+	hellos  int
+	devices map[string]int // device ID -> ClientHellos
+}
+
+// LoadJA3Blocklist reads known-bad JA3 hashes from a file with one
+// "<md5> [description]" entry per line; blank lines and # comments are ignored
+func LoadJA3Blocklist(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocklist := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, description, _ := strings.Cut(strings.Replace(line, ",", " ", 1), " ")
+		hash = strings.ToLower(hash)
+		if len(hash) != 32 || strings.Trim(hash, "0123456789abcdef") != "" {
+			return nil, fmt.Errorf("%s:%d: invalid JA3 hash %q", path, lineNum, hash)
+		}
+		blocklist[hash] = strings.TrimSpace(description)
+	}
+
+	return blocklist, scanner.Err()
+}
+
+// SetJA3Blocklist replaces the known-bad JA3 fingerprints that raise anomalies
+func (nm *NetworkMonitor) SetJA3Blocklist(blocklist map[string]string) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.ja3Blocklist = blocklist
+}
+
+// TrackTLSHello fingerprints a captured ClientHello and attributes it to its sender
+func (nm *NetworkMonitor) TrackTLSHello(evt *models.TLSHelloEvent) {
+	ja3 := utils.ComputeJA3(evt.Data)
+	if ja3 == nil {
+		return
+	}
+
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	deviceID, _ := nm.identify(utils.MacToString(evt.SrcMac), utils.IPFromBEUint32(evt.SrcIP), models.EVENT_TYPE_TLS)
+	defer nm.lockDevice(deviceID)()
+	device, tracked := nm.Cache.Peek(deviceID)
+
+	if ja3.Partial {
+		if tracked {
+			device.PartialTLSHellos++
+		}
+		return
+	}
+
+	entry := nm.ja3Fingerprints[ja3.Hash]
+	if entry == nil {
+		entry = &ja3Entry{ja3: ja3.String, devices: make(map[string]int)}
+		nm.ja3Fingerprints[ja3.Hash] = entry
+	}
+	entry.hellos++
+	entry.devices[deviceID]++
+
+	if tracked {
+		if device.TLSFingerprints == nil {
+			device.TLSFingerprints = make(map[string]int)
+		}
+		device.TLSFingerprints[ja3.Hash]++
+	}
+
+	// Alert once per device and blocklisted fingerprint
+	if description, bad := nm.ja3Blocklist[ja3.Hash]; bad && entry.devices[deviceID] == 1 {
+		if description == "" {
+			description = "no description"
+		}
+		nm.raiseAnomaly("TLS_FINGERPRINT_BLOCKLISTED", models.SeverityHigh, deviceID,
+			map[string]string{"ja3_hash": ja3.Hash, "description": description},
+			map[string]string{
+				"ja3_hash":    ja3.Hash,
+				"ja3":         ja3.String,
+				"description": description,
+				"dst":         fmt.Sprintf("%s:%d", utils.IPFromBEUint32(evt.DstIP), evt.DstPort),
+			})
+	}
+}
+
+// renameJA3Device moves fingerprint sightings from one device identity to another
+func (nm *NetworkMonitor) renameJA3Device(from, to string) {
+	for _, entry := range nm.ja3Fingerprints {
+		if count, ok := entry.devices[from]; ok {
+			entry.devices[to] += count
+			delete(entry.devices, from)
+		}
+	}
+}
+
+// TLSFingerprints returns every JA3 fingerprint seen, most frequent first
+func (nm *NetworkMonitor) TLSFingerprints() []models.TLSFingerprint {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	fingerprints := make([]models.TLSFingerprint, 0, len(nm.ja3Fingerprints))
+	for hash, entry := range nm.ja3Fingerprints {
+		ids := make([]string, 0, len(entry.devices))
+		for id := range entry.devices {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		description, blocklisted := nm.ja3Blocklist[hash]
+		fingerprints = append(fingerprints, models.TLSFingerprint{
+			Hash:        hash,
+			JA3:         entry.ja3,
+			Hellos:      entry.hellos,
+			Devices:     len(ids),
+			DeviceIDs:   ids,
+			Blocklisted: blocklisted,
+			Description: description,
+		})
+	}
+
+	sort.Slice(fingerprints, func(i, j int) bool {
+		if fingerprints[i].Hellos != fingerprints[j].Hellos {
+			return fingerprints[i].Hellos > fingerprints[j].Hellos
+		}
+		return fingerprints[i].Hash < fingerprints[j].Hash
+	})
+	return fingerprints
+}
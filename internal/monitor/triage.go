@@ -0,0 +1,454 @@
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// AnomalyKeyPrefix prefixes persisted anomalies, which carry their triage
+// state.
+const AnomalyKeyPrefix = "triage:"
+
+// DefaultAnomalyRetention is how long persisted anomalies are kept by default
+const DefaultAnomalyRetention = 30 * 24 * time.Hour
+
+// DefaultAckWindow is how long an acknowledgement mutes notifications of the
+// same condition by default
+const DefaultAckWindow = 24 * time.Hour
+
+// maxPendingAnomalies bounds the anomalies awaiting the next persist
+const maxPendingAnomalies = 10000
+
+// maxAckedConditions bounds the acknowledged conditions remembered at once
+const maxAckedConditions = 10000
+
+// ErrAnomalyNotFound is returned when acknowledging an unknown anomaly
+var ErrAnomalyNotFound = errors.New("anomaly not found")
+
+// pendingAnomaly is an anomaly awaiting the next persist. Updates only rewrite
+// records that still exist, keeping their remaining retention; deletions
+// remove them.
+type pendingAnomaly struct {
+	anomaly *models.Anomaly
+	update  bool
+	deleted bool
+}
+
+// ackedCondition is the latest acknowledgement of an anomaly condition
+type ackedCondition struct {
+	id string
+	at time.Time
+}
+
+// anomalyCondition identifies repeats of the same condition: an anomaly type on
+// one device
+func anomalyCondition(anomaly *models.Anomaly) string {
+	return anomaly.Type + "|" + anomaly.DeviceID
+}
+
+// anomalySeqOf returns the sequence number in an anomaly ID
+func anomalySeqOf(id string) (uint64, bool) {
+	digits, ok := strings.CutPrefix(id, "a-")
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(digits, 10, 64)
+	return seq, err == nil
+}
+
+// anomalyKey orders persisted anomalies by the order they were raised
+func anomalyKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", AnomalyKeyPrefix, seq)
+}
+
+// SetAnomalyRetention sets how long persisted anomalies are kept; 0 keeps them forever
+func (nm *NetworkMonitor) SetAnomalyRetention(retention time.Duration) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+	nm.anomalyRetention = retention
+}
+
+// SetAckWindow sets how long after an acknowledgement new anomalies of the same
+// type on the same device are recorded without notification; 0 disables muting
+func (nm *NetworkMonitor) SetAckWindow(window time.Duration) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+	nm.ackWindow = window
+}
+
+// queueAnomaly buffers an anomaly for the next persist. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) queueAnomaly(anomaly *models.Anomaly, update bool) {
+	if len(nm.pendingAnomalies) >= maxPendingAnomalies {
+		nm.pendingAnomalies = nm.pendingAnomalies[1:]
+	}
+	nm.pendingAnomalies = append(nm.pendingAnomalies, pendingAnomaly{anomaly: anomaly, update: update})
+}
+
+// requeueAnomalies puts anomalies from a failed persist back ahead of newer ones
+func (nm *NetworkMonitor) requeueAnomalies(anomalies []pendingAnomaly) {
+	if len(anomalies) == 0 {
+		return
+	}
+
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+
+	merged := append(anomalies, nm.pendingAnomalies...)
+	if overflow := len(merged) - maxPendingAnomalies; overflow > 0 {
+		merged = merged[overflow:]
+	}
+	nm.pendingAnomalies = merged
+}
+
+// writeAnomalies persists new anomalies and triage updates to existing ones
+func writeAnomalies(tx *buntdb.Tx, anomalies []pendingAnomaly, retention time.Duration) error {
+	for _, pending := range anomalies {
+		seq, ok := anomalySeqOf(pending.anomaly.ID)
+		if !ok {
+			continue
+		}
+		key := anomalyKey(seq)
+
+		if pending.deleted {
+			if _, err := tx.Delete(key); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+			continue
+		}
+
+		var opts *buntdb.SetOptions
+		if pending.update {
+			ttl, err := tx.TTL(key)
+			if err == buntdb.ErrNotFound {
+				continue // Expired, or dropped before it was persisted
+			}
+			if err != nil {
+				return err
+			}
+			if ttl > 0 {
+				opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+			}
+		} else if retention > 0 {
+			opts = &buntdb.SetOptions{Expires: true, TTL: retention}
+		}
+
+		data, _ := json.Marshal(pending.anomaly)
+		if _, _, err := tx.Set(key, string(data), opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAnomalies restores the recent anomalies and acknowledgements, and moves
+// the ID sequence past every persisted anomaly
+func (nm *NetworkMonitor) loadAnomalies() {
+	var recent []*models.Anomaly
+	acked := make(map[string]ackedCondition)
+	var last uint64
+
+	nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.DescendRange("", AnomalyKeyPrefix+"~", AnomalyKeyPrefix, func(key, value string) bool {
+			var anomaly models.Anomaly
+			if json.Unmarshal([]byte(value), &anomaly) != nil {
+				return true
+			}
+			if seq, ok := anomalySeqOf(anomaly.ID); ok {
+				last = max(last, seq)
+			}
+			if len(recent) < maxRecentAnomalies {
+				recent = append(recent, &anomaly)
+			}
+			if anomaly.Ack != nil {
+				condition := anomalyCondition(&anomaly)
+				if prev, ok := acked[condition]; !ok || anomaly.Ack.At.After(prev.at) {
+					acked[condition] = ackedCondition{id: anomaly.ID, at: anomaly.Ack.At}
+				}
+			}
+			return true
+		})
+	})
+
+	// Newest first from the scan; memory keeps them oldest first
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	if last > anomalySeq.Load() {
+		anomalySeq.Store(last)
+	}
+
+	nm.anomalyMu.Lock()
+	nm.anomalies = recent
+	nm.acked = acked
+	nm.anomalyMu.Unlock()
+}
+
+// mutedBy returns the ID of the acknowledged anomaly whose window covers a new
+// anomaly of the same condition, if any. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) mutedBy(anomaly *models.Anomaly) string {
+	if nm.ackWindow <= 0 {
+		return ""
+	}
+	ack, ok := nm.acked[anomalyCondition(anomaly)]
+	if !ok || anomaly.Timestamp.Sub(ack.at) > nm.ackWindow {
+		return ""
+	}
+	return ack.id
+}
+
+// AckAnomaly acknowledges an anomaly. Later anomalies of the same type on the
+// same device are still recorded but not notified for the ack window.
+// Acknowledging an anomaly again returns it unchanged.
+func (nm *NetworkMonitor) AckAnomaly(id, comment string) (*models.Anomaly, error) {
+	anomaly, _, err := nm.updateAnomaly(id, func(acked *models.Anomaly) bool {
+		return nm.ack(acked, comment, time.Now())
+	})
+	return anomaly, err
+}
+
+// ack acknowledges an anomaly and mutes its condition, reporting false if it
+// was already acknowledged. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) ack(anomaly *models.Anomaly, comment string, now time.Time) bool {
+	if anomaly.Ack != nil {
+		return false
+	}
+	anomaly.Ack = &models.AnomalyAck{At: now, Comment: comment}
+
+	if nm.acked == nil {
+		nm.acked = make(map[string]ackedCondition)
+	}
+	condition := anomalyCondition(anomaly)
+	if _, ok := nm.acked[condition]; !ok && len(nm.acked) >= maxAckedConditions {
+		nm.pruneAcked(now)
+	}
+	nm.acked[condition] = ackedCondition{id: anomaly.ID, at: now}
+	return true
+}
+
+// lockAnomaly locks nm.anomalyMu and returns the current version of an
+// anomaly, from memory, including changes not yet persisted, or else from the
+// database. On error nm.anomalyMu is left unlocked.
+func (nm *NetworkMonitor) lockAnomaly(id string) (*models.Anomaly, error) {
+	var stored *models.Anomaly
+	for {
+		nm.anomalyMu.Lock()
+		current, ok := nm.recentAnomaly(id)
+		if !ok && stored != nil {
+			current, ok = stored, true
+		}
+		if ok {
+			if current == nil {
+				nm.anomalyMu.Unlock()
+				return nil, ErrAnomalyNotFound // Deleted
+			}
+			return current, nil
+		}
+		nm.anomalyMu.Unlock()
+
+		// Read outside the lock, then looked up in memory again in case it
+		// changed meanwhile
+		var err error
+		if stored, err = nm.loadAnomaly(id); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// updateAnomaly changes an anomaly with apply, which reports whether it
+// changed anything, and queues the change for the next persist. It returns
+// the anomaly as it stands and whether it changed. Records are shared with
+// readers, so apply changes a copy, under nm.anomalyMu: changes racing on the
+// same anomaly apply one after the other, and never to another anomaly.
+func (nm *NetworkMonitor) updateAnomaly(id string, apply func(*models.Anomaly) bool) (*models.Anomaly, bool, error) {
+	current, err := nm.lockAnomaly(id)
+	if err != nil {
+		return nil, false, err
+	}
+	defer nm.anomalyMu.Unlock()
+
+	updated := *current
+	if !apply(&updated) {
+		return current, false, nil
+	}
+	for i, recent := range nm.anomalies {
+		if recent.ID == id {
+			nm.anomalies[i] = &updated
+		}
+	}
+	nm.queueAnomaly(&updated, true)
+	return &updated, true, nil
+}
+
+// deleteAnomaly forgets an anomaly and queues the deletion of its record
+func (nm *NetworkMonitor) deleteAnomaly(id string) error {
+	current, err := nm.lockAnomaly(id)
+	if err != nil {
+		return err
+	}
+	defer nm.anomalyMu.Unlock()
+
+	nm.anomalies = slices.DeleteFunc(nm.anomalies, func(recent *models.Anomaly) bool { return recent.ID == id })
+	nm.queueAnomaly(current, false)
+	nm.pendingAnomalies[len(nm.pendingAnomalies)-1].deleted = true
+	return nil
+}
+
+// pruneAcked forgets acknowledgements whose window has ended, or all of them
+// if none has. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) pruneAcked(now time.Time) {
+	for condition, ack := range nm.acked {
+		if now.Sub(ack.at) > nm.ackWindow {
+			delete(nm.acked, condition)
+		}
+	}
+	if len(nm.acked) >= maxAckedConditions {
+		clear(nm.acked)
+	}
+}
+
+// findRecentAnomaly looks an anomaly up in memory, including ones not yet
+// persisted. It returns nil and true for an anomaly deleted since.
+func (nm *NetworkMonitor) findRecentAnomaly(id string) (*models.Anomaly, bool) {
+	nm.anomalyMu.Lock()
+	defer nm.anomalyMu.Unlock()
+	return nm.recentAnomaly(id)
+}
+
+// recentAnomaly is findRecentAnomaly. Must hold nm.anomalyMu.
+func (nm *NetworkMonitor) recentAnomaly(id string) (*models.Anomaly, bool) {
+	for _, anomaly := range nm.anomalies {
+		if anomaly.ID == id {
+			return anomaly, true
+		}
+	}
+	for i := len(nm.pendingAnomalies) - 1; i >= 0; i-- {
+		if pending := nm.pendingAnomalies[i]; pending.anomaly.ID == id {
+			if pending.deleted {
+				return nil, true
+			}
+			return pending.anomaly, true
+		}
+	}
+	return nil, false
+}
+
+// loadAnomaly reads a persisted anomaly
+func (nm *NetworkMonitor) loadAnomaly(id string) (*models.Anomaly, error) {
+	seq, ok := anomalySeqOf(id)
+	if !ok {
+		return nil, ErrAnomalyNotFound
+	}
+
+	var value string
+	err := nm.db.View(func(tx *buntdb.Tx) error {
+		var err error
+		value, err = tx.Get(anomalyKey(seq))
+		return err
+	})
+	if err == buntdb.ErrNotFound {
+		return nil, ErrAnomalyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var anomaly models.Anomaly
+	if err := json.Unmarshal([]byte(value), &anomaly); err != nil {
+		return nil, err
+	}
+	return &anomaly, nil
+}
+
+// AnomalyHistory pages through persisted and pending anomalies, newest first.
+// It returns up to limit anomalies raised before the one with ID before (all if
+// empty) that pass match, and the ID to pass as before for the next page, or ""
+// on the last one.
+func (nm *NetworkMonitor) AnomalyHistory(before string, limit int, match func(*models.Anomaly) bool) ([]*models.Anomaly, string, error) {
+	end := AnomalyKeyPrefix + "~"
+	if before != "" {
+		seq, ok := anomalySeqOf(before)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid anomaly ID %q", before)
+		}
+		end = anomalyKey(seq)
+	}
+
+	// Anomalies awaiting the next persist, latest version of each (nil once
+	// deleted), newest first
+	nm.anomalyMu.Lock()
+	latest := make(map[string]*models.Anomaly)
+	for _, pending := range nm.pendingAnomalies {
+		seq, ok := anomalySeqOf(pending.anomaly.ID)
+		if key := anomalyKey(seq); ok && key < end {
+			latest[key] = pending.anomaly
+			if pending.deleted {
+				latest[key] = nil
+			}
+		}
+	}
+	nm.anomalyMu.Unlock()
+	pendingKeys := make([]string, 0, len(latest))
+	for key := range latest {
+		pendingKeys = append(pendingKeys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(pendingKeys)))
+
+	var page []*models.Anomaly
+	next := ""
+	emit := func(anomaly *models.Anomaly) bool {
+		if anomaly == nil || !match(anomaly) {
+			return true
+		}
+		if len(page) == limit {
+			next = page[len(page)-1].ID
+			return false
+		}
+		page = append(page, anomaly)
+		return true
+	}
+
+	more := true
+	err := nm.db.View(func(tx *buntdb.Tx) error {
+		return tx.DescendRange("", end, AnomalyKeyPrefix, func(key, value string) bool {
+			if key == end {
+				return true // The before anomaly itself
+			}
+			for len(pendingKeys) > 0 && pendingKeys[0] > key {
+				if more = emit(latest[pendingKeys[0]]); !more {
+					return false
+				}
+				pendingKeys = pendingKeys[1:]
+			}
+			if pending, ok := latest[key]; ok {
+				pendingKeys = pendingKeys[1:]
+				more = emit(pending)
+				return more
+			}
+
+			var anomaly models.Anomaly
+			if json.Unmarshal([]byte(value), &anomaly) != nil {
+				return true
+			}
+			more = emit(&anomaly)
+			return more
+		})
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	for more && len(pendingKeys) > 0 {
+		more = emit(latest[pendingKeys[0]])
+		pendingKeys = pendingKeys[1:]
+	}
+
+	return page, next, nil
+}
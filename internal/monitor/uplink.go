@@ -0,0 +1,382 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// Signals of the uplink health score
+const (
+	UplinkConnections = "connection_failures" // External TCP handshakes refused or unanswered
+	UplinkDNS         = "dns_failures"        // SERVFAIL answers and timeouts from external resolvers
+	UplinkLatency     = "latency"             // External handshake round trips against their baseline
+)
+
+// uplinkWeights are the points each signal takes off the score at worst
+var uplinkWeights = map[string]float64{
+	UplinkConnections: 50,
+	UplinkDNS:         30,
+	UplinkLatency:     20,
+}
+
+const (
+	uplinkInterval      = time.Minute     // Length of each scored sample
+	uplinkHistory       = 24 * time.Hour  // Scored samples kept
+	uplinkTimeout       = 5 * time.Second // Handshakes and queries unanswered for longer failed
+	uplinkMaxPending    = 20000           // Bounds the handshakes and queries awaiting an answer
+	uplinkRecentSamples = 5               // Samples a drop is measured against
+	uplinkLatencyWorst  = 4.0             // Round trips this many times the baseline take every latency point
+	uplinkBaselineAlpha = 0.1             // Weight of a sample in the latency baseline
+	uplinkDNSRcodeFail  = 2               // SERVFAIL
+)
+
+// TCP flags of handshakes
+const (
+	tcpFlagSYN    = 0x02
+	tcpFlagRST    = 0x04
+	tcpFlagSYNACK = 0x12
+)
+
+// UplinkConfig controls the passive uplink health estimate
+type UplinkConfig struct {
+	MinDestinations int // Distinct failing destinations (or DNS names) before a signal counts
+	Drop            int // Score points below the recent level that raise an anomaly
+}
+
+// DefaultUplinkConfig returns the default uplink health settings
+func DefaultUplinkConfig() UplinkConfig {
+	return UplinkConfig{MinDestinations: 3, Drop: 30}
+}
+
+type uplinkHandshake struct {
+	client, clientPort, server, serverPort string
+}
+
+type uplinkQuery struct {
+	client, resolver string
+	id               uint16
+}
+
+type uplinkPending struct {
+	at     time.Time
+	target string // Destination IP, or queried name
+	device string
+}
+
+// uplinkSample accumulates the outcomes of one interval
+type uplinkSample struct {
+	succeeded   map[string]bool // Destinations with a completed handshake
+	failed      map[string]bool // Destinations with a refused or unanswered one
+	failDevices map[string]bool
+	rtts        []float64 // Handshake round trips in milliseconds
+	rttServers  map[string]bool
+	dnsAnswered int
+	dnsFailed   int
+	failedNames map[string]bool
+}
+
+func newUplinkSample() *uplinkSample {
+	return &uplinkSample{
+		succeeded:   make(map[string]bool),
+		failed:      make(map[string]bool),
+		failDevices: make(map[string]bool),
+		rttServers:  make(map[string]bool),
+		failedNames: make(map[string]bool),
+	}
+}
+
+// uplinkEstimator scores the health of the internet uplink from the traffic
+// crossing it. It is guarded by nm.mu.
+type uplinkEstimator struct {
+	config      UplinkConfig
+	handshakes  map[uplinkHandshake]uplinkPending
+	queries     map[uplinkQuery]uplinkPending
+	sample      *uplinkSample
+	rttBaseline float64 // Typical median round trip in milliseconds
+	latest      *models.UplinkPoint
+	history     []models.UplinkPoint
+	alertLevel  int // Level the score dropped from while alerting, 0 otherwise
+}
+
+func newUplinkEstimator(config UplinkConfig) *uplinkEstimator {
+	return &uplinkEstimator{
+		config:     config,
+		handshakes: make(map[uplinkHandshake]uplinkPending),
+		queries:    make(map[uplinkQuery]uplinkPending),
+		sample:     newUplinkSample(),
+	}
+}
+
+// SetUplinkConfig replaces the uplink health settings
+func (nm *NetworkMonitor) SetUplinkConfig(config UplinkConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.uplink.config = config
+}
+
+// observeUplinkTCP follows the handshakes of local devices with external
+// destinations. Must hold nm.mu.
+func (nm *NetworkMonitor) observeUplinkTCP(evt *models.NetworkEvent, deviceID string, now time.Time) {
+	u := nm.uplink
+	src, dst := utils.IPFromBEUint32(evt.SrcIP), utils.IPFromBEUint32(evt.DstIP)
+	srcPort, dstPort := strconv.Itoa(int(evt.SrcPort)), strconv.Itoa(int(evt.DstPort))
+
+	switch {
+	case evt.TCPFlags&tcpFlagSYNACK == tcpFlagSYN && nm.isExternalIP(dst) && !nm.isExternalIP(src):
+		key := uplinkHandshake{src.String(), srcPort, dst.String(), dstPort}
+		// Retransmitted SYNs keep the first attempt's time
+		if _, ok := u.handshakes[key]; !ok && len(u.handshakes) < uplinkMaxPending {
+			u.handshakes[key] = uplinkPending{at: now, target: key.server, device: deviceID}
+		}
+	case evt.TCPFlags&tcpFlagSYNACK == tcpFlagSYNACK && nm.isExternalIP(src):
+		key := uplinkHandshake{dst.String(), dstPort, src.String(), srcPort}
+		if pending, ok := u.handshakes[key]; ok {
+			delete(u.handshakes, key)
+			u.sample.succeeded[pending.target] = true
+			nm.observeHealthHandshake(pending.device, true)
+			u.sample.rtts = append(u.sample.rtts, float64(now.Sub(pending.at).Microseconds())/1000)
+			u.sample.rttServers[pending.target] = true
+		}
+	case evt.TCPFlags&tcpFlagRST != 0 && nm.isExternalIP(src):
+		key := uplinkHandshake{dst.String(), dstPort, src.String(), srcPort}
+		if pending, ok := u.handshakes[key]; ok {
+			delete(u.handshakes, key)
+			u.sample.failed[pending.target] = true
+			u.sample.failDevices[pending.device] = true
+			nm.observeHealthHandshake(pending.device, false)
+		}
+	}
+}
+
+// observeUplinkDNS matches queries to external resolvers with their answers
+func (nm *NetworkMonitor) observeUplinkDNS(evt *models.DNSQueryEvent) {
+	if len(evt.Data) < 12 {
+		return
+	}
+	src, dst := utils.IPFromBEUint32(evt.SrcIP), utils.IPFromBEUint32(evt.DstIP)
+	id := binary.BigEndian.Uint16(evt.Data[0:2])
+	response := utils.DNSIsResponse(evt.Data)
+	now := time.Now()
+
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	u := nm.uplink
+	if !response {
+		if !nm.isExternalIP(dst) || nm.isExternalIP(src) {
+			return
+		}
+		key := uplinkQuery{src.String(), dst.String(), id}
+		if _, ok := u.queries[key]; !ok && len(u.queries) < uplinkMaxPending {
+			name := strings.Join(utils.DNSQuestionLabels(evt.Data), ".")
+			u.queries[key] = uplinkPending{at: now, target: name}
+		}
+		return
+	}
+
+	key := uplinkQuery{dst.String(), src.String(), id}
+	pending, ok := u.queries[key]
+	if !ok {
+		return
+	}
+	delete(u.queries, key)
+	if evt.Data[3]&0x0f == uplinkDNSRcodeFail {
+		u.sample.dnsFailed++
+		u.sample.failedNames[pending.target] = true
+	} else {
+		u.sample.dnsAnswered++
+	}
+}
+
+// uplinkTick scores the uplink, once per uplinkInterval
+func (nm *NetworkMonitor) uplinkTick(now time.Time) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.scoreUplink(now)
+}
+
+// scoreUplink closes the current sample: unanswered handshakes and queries
+// fail, each signal is scored, and a sharp drop raises an anomaly. Must hold
+// nm.mu.
+func (nm *NetworkMonitor) scoreUplink(now time.Time) {
+	u := nm.uplink
+	s := u.sample
+	for key, pending := range u.handshakes {
+		if now.Sub(pending.at) > uplinkTimeout {
+			delete(u.handshakes, key)
+			s.failed[pending.target] = true
+			s.failDevices[pending.device] = true
+			nm.observeHealthHandshake(pending.device, false)
+		}
+	}
+	for key, pending := range u.queries {
+		if now.Sub(pending.at) > uplinkTimeout {
+			delete(u.queries, key)
+			s.dnsFailed++
+			s.failedNames[pending.target] = true
+		}
+	}
+	u.sample = newUplinkSample()
+
+	signals := u.signals(s)
+	if signals == nil {
+		return // No external traffic to judge by
+	}
+	score := 100.0
+	for _, signal := range signals {
+		score -= signal.Penalty
+	}
+	point := models.UplinkPoint{Time: now, Score: int(score + 0.5), Signals: signals}
+
+	cutoff := now.Add(-uplinkHistory)
+	i := 0
+	for i < len(u.history) && u.history[i].Time.Before(cutoff) {
+		i++
+	}
+	recent := u.history[i:]
+	u.history = append(recent, models.UplinkPoint{Time: point.Time, Score: point.Score})
+	u.latest = &point
+
+	if len(recent) >= uplinkRecentSamples {
+		level := 0
+		for _, p := range recent[len(recent)-uplinkRecentSamples:] {
+			level += p.Score
+		}
+		level /= uplinkRecentSamples
+		nm.checkUplinkDrop(point, level)
+	}
+}
+
+// signals scores each signal of a sample, or returns nil if the sample saw no
+// external handshake or query. A signal only takes points off once failures
+// span enough distinct destinations, so one dead service isn't mistaken for
+// a failing uplink.
+func (u *uplinkEstimator) signals(s *uplinkSample) []models.UplinkSignal {
+	destinations := len(s.succeeded)
+	var down []string
+	for destination := range s.failed {
+		if !s.succeeded[destination] {
+			down = append(down, destination)
+			destinations++
+		}
+	}
+	queries := s.dnsAnswered + s.dnsFailed
+	if destinations == 0 && queries == 0 {
+		return nil
+	}
+	minimum := u.config.MinDestinations
+
+	connections := models.UplinkSignal{Name: UplinkConnections}
+	if destinations > 0 {
+		connections.Value = float64(len(down)) / float64(destinations)
+		connections.Detail = fmt.Sprintf("%d of %d external destinations unreachable from %d devices",
+			len(down), destinations, len(s.failDevices))
+		if len(down) >= minimum {
+			connections.Penalty = uplinkWeights[UplinkConnections] * connections.Value
+		}
+	}
+
+	dns := models.UplinkSignal{Name: UplinkDNS}
+	if queries > 0 {
+		dns.Value = float64(s.dnsFailed) / float64(queries)
+		dns.Detail = fmt.Sprintf("%d of %d queries to external resolvers failed, for %d names",
+			s.dnsFailed, queries, len(s.failedNames))
+		if len(s.failedNames) >= minimum {
+			dns.Penalty = uplinkWeights[UplinkDNS] * dns.Value
+		}
+	}
+
+	latency := models.UplinkSignal{Name: UplinkLatency}
+	if len(s.rtts) > 0 {
+		sort.Float64s(s.rtts)
+		median := s.rtts[len(s.rtts)/2]
+		latency.Value = median
+		latency.Detail = fmt.Sprintf("median handshake %.1f ms to %d destinations", median, len(s.rttServers))
+		if u.rttBaseline > 0 {
+			latency.Detail += fmt.Sprintf(", baseline %.1f ms", u.rttBaseline)
+			if len(s.rttServers) >= minimum {
+				worse := (median/u.rttBaseline - 1) / (uplinkLatencyWorst - 1)
+				latency.Penalty = uplinkWeights[UplinkLatency] * min(max(worse, 0), 1)
+			}
+		}
+		// The baseline learns from normal samples only, or it would chase an outage
+		if len(s.rttServers) >= minimum && (u.rttBaseline == 0 || median <= 2*u.rttBaseline) {
+			if u.rttBaseline == 0 {
+				u.rttBaseline = median
+			} else {
+				u.rttBaseline += uplinkBaselineAlpha * (median - u.rttBaseline)
+			}
+		}
+	}
+
+	return []models.UplinkSignal{connections, dns, latency}
+}
+
+// checkUplinkDrop raises an anomaly the first time the score falls the
+// configured number of points below its recent level, naming the signal that
+// took the most points. Alerting resumes once the score recovers halfway.
+// Must hold nm.mu.
+func (nm *NetworkMonitor) checkUplinkDrop(point models.UplinkPoint, level int) {
+	u := nm.uplink
+	drop := u.config.Drop
+	if u.alertLevel > 0 {
+		if point.Score >= u.alertLevel-drop/2 {
+			u.alertLevel = 0
+		}
+		return
+	}
+	if drop <= 0 || level-point.Score < drop {
+		return
+	}
+	u.alertLevel = level
+
+	driver := point.Signals[0]
+	for _, signal := range point.Signals[1:] {
+		if signal.Penalty > driver.Penalty {
+			driver = signal
+		}
+	}
+	details := map[string]string{
+		"score":    strconv.Itoa(point.Score),
+		"previous": strconv.Itoa(level),
+		"signal":   driver.Name,
+	}
+	for _, signal := range point.Signals {
+		if signal.Detail != "" {
+			details[signal.Name] = signal.Detail
+		}
+	}
+	nm.raiseAnomaly("UPLINK_DEGRADED", models.SeverityInfo, "",
+		map[string]string{
+			"previous": strconv.Itoa(level),
+			"score":    strconv.Itoa(point.Score),
+			"signal":   strings.ReplaceAll(driver.Name, "_", " "),
+			"detail":   driver.Detail,
+		},
+		details)
+}
+
+// UplinkHealth returns the latest uplink health score with its signals, and
+// the scores of the last 24 hours
+func (nm *NetworkMonitor) UplinkHealth() models.UplinkHealth {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	u := nm.uplink
+	health := models.UplinkHealth{
+		Interval: uplinkInterval.String(),
+		History:  append([]models.UplinkPoint{}, u.history...),
+	}
+	if u.latest != nil {
+		latest := *u.latest
+		health.Latest = &latest
+	}
+	return health
+}
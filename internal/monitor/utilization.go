@@ -0,0 +1,357 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/ifaces"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// InterfaceCountersKey holds the persisted event, packet and byte counters of
+// the interfaces by name.
+const InterfaceCountersKey = "stats:interfaces"
+
+const (
+	utilizationInterval     = 10 * time.Second // Length of the shortest window, and how often utilization is computed
+	utilizationHistory      = 5 * time.Minute  // Length of the longest window
+	utilizationPersistTicks = 6                // Intervals between persists of the counters
+	utilizationTopDevices   = 5                // Contributing devices listed in an anomaly
+)
+
+// utilizationWindows are the windows of models.InterfaceUtilization
+var utilizationWindows = []time.Duration{utilizationInterval, time.Minute, utilizationHistory}
+
+// UtilizationConfig controls alerting on interfaces whose traffic stays close
+// to their link speed
+type UtilizationConfig struct {
+	Threshold float64       // Percent of the link speed over 10s that counts as saturated (0 disables)
+	Sustain   time.Duration // How long an interface stays above Threshold before an anomaly is raised
+}
+
+// DefaultUtilizationConfig returns the default interface utilization settings
+func DefaultUtilizationConfig() UtilizationConfig {
+	return UtilizationConfig{Threshold: 80, Sustain: 5 * time.Minute}
+}
+
+type utilizationSample struct {
+	at    time.Time
+	bytes uint64
+}
+
+// interfaceLoad is the recent traffic of one interface
+type interfaceLoad struct {
+	samples    []utilizationSample // Oldest first, covering utilizationHistory
+	aboveSince time.Time           // Zero while below the threshold
+	alerted    bool                // Raised since it went above
+	devices    map[string]uint64   // Device ID -> bytes it sent on the interface while above; nil while below
+}
+
+// interfaceCounters are the persisted counters of an interface
+type interfaceCounters struct {
+	Events  uint64 `json:"events"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// utilizationTracker computes the link utilization of the attached
+// interfaces from their byte counters, and keeps their counters across
+// restarts. It is guarded by nm.mu.
+type utilizationTracker struct {
+	config   UtilizationConfig
+	loads    map[uint32]*interfaceLoad
+	restored map[string]interfaceCounters // Persisted counters of the interfaces not attached yet, by name
+	ticks    int
+}
+
+func newUtilizationTracker(config UtilizationConfig) *utilizationTracker {
+	return &utilizationTracker{
+		config:   config,
+		loads:    make(map[uint32]*interfaceLoad),
+		restored: make(map[string]interfaceCounters),
+	}
+}
+
+// SetUtilizationConfig replaces the interface utilization alert settings
+func (nm *NetworkMonitor) SetUtilizationConfig(config UtilizationConfig) {
+	nm.lockAll()
+	defer nm.unlockAll()
+	nm.utilization.config = config
+}
+
+// loadInterfaceCounters reads the counters persisted by the previous run.
+// They are added to an interface when it is attached.
+func (nm *NetworkMonitor) loadInterfaceCounters() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		if value, err := tx.Get(InterfaceCountersKey); err == nil {
+			json.Unmarshal([]byte(value), &nm.utilization.restored)
+		}
+		return nil
+	})
+}
+
+// restoreInterfaceCounters adds the persisted counters of an interface once
+// it is attached, starting its utilization over so the jump doesn't count as
+// traffic
+func (nm *NetworkMonitor) restoreInterfaceCounters(ifindex int, name string) {
+	nm.lockAll()
+	defer nm.unlockAll()
+
+	t := nm.utilization
+	counters, ok := t.restored[name]
+	if !ok {
+		return
+	}
+	delete(t.restored, name)
+	nm.ifaces.RestoreCounters(ifindex, counters.Events, counters.Packets, counters.Bytes)
+	delete(t.loads, uint32(ifindex))
+}
+
+// observeUtilization accounts bytes a device sent on an interface, kept only
+// while the interface is above the threshold to name the devices saturating
+// it. Must hold nm.mu.
+func (nm *NetworkMonitor) observeUtilization(ifindex uint32, deviceID string, bytes uint64) {
+	if load := nm.utilization.loads[ifindex]; load != nil && load.devices != nil {
+		load.devices[deviceID] += bytes
+	}
+}
+
+// utilizationTick refreshes the link speed of the attached interfaces,
+// computes their utilization and raises an INFO anomaly when one stays above
+// the threshold, every utilizationInterval. The counters are persisted every
+// utilizationPersistTicks.
+func (nm *NetworkMonitor) utilizationTick(now time.Time) {
+	// Reading sysfs runs without holding nm.mu
+	for _, status := range nm.ifaces.List() {
+		if status.Attached {
+			nm.refreshLinkSpeed(status)
+		}
+	}
+
+	nm.lockAll()
+	t := nm.utilization
+	attached := make(map[uint32]bool)
+	for _, status := range nm.ifaces.List() {
+		if !status.Attached {
+			if status.Utilization != nil {
+				nm.ifaces.SetUtilization(status.Index, nil)
+			}
+			continue
+		}
+		index := uint32(status.Index)
+		attached[index] = true
+		load := t.loads[index]
+		if load == nil {
+			load = &interfaceLoad{}
+			t.loads[index] = load
+		}
+		load.add(now, status.Bytes)
+
+		utilization := load.utilization(now, status.SpeedMbps)
+		nm.checkUtilization(status, load, utilization, now)
+		nm.ifaces.SetUtilization(status.Index, utilization)
+	}
+	for index := range t.loads {
+		if !attached[index] {
+			delete(t.loads, index)
+		}
+	}
+	t.ticks++
+	persist := t.ticks%utilizationPersistTicks == 0
+	nm.unlockAll()
+
+	if persist {
+		nm.persistInterfaceCounters()
+	}
+}
+
+// refreshLinkSpeed reads the link speed and duplex of an interface, which
+// notifies subscribers when they changed
+func (nm *NetworkMonitor) refreshLinkSpeed(status models.InterfaceStatus) {
+	speed, duplex := ifaces.LinkSpeed(status.Name)
+	if speed == status.SpeedMbps && duplex == status.Duplex {
+		return
+	}
+	if speed > 0 {
+		link := strconv.Itoa(speed) + " Mb/s"
+		if duplex != "" {
+			link += " " + duplex + " duplex"
+		}
+		fmt.Printf("Interface %s link is %s\n", status.Name, link)
+	}
+	nm.ifaces.Update(status.Index, func(s *models.InterfaceStatus) {
+		s.SpeedMbps, s.Duplex = speed, duplex
+	})
+}
+
+// add samples the byte counter of an interface, dropping the samples no
+// window needs anymore
+func (load *interfaceLoad) add(now time.Time, bytes uint64) {
+	load.samples = append(load.samples, utilizationSample{at: now, bytes: bytes})
+	// The longest window needs one sample from before its start
+	cutoff := now.Add(-utilizationHistory + utilizationInterval/2)
+	drop := 0
+	for drop < len(load.samples)-1 && !load.samples[drop+1].at.After(cutoff) {
+		drop++
+	}
+	load.samples = load.samples[drop:]
+}
+
+// utilization returns the share of a link speed used over each window, nil
+// without a speed. A window is nil until samples cover it.
+func (load *interfaceLoad) utilization(now time.Time, speedMbps int) *models.InterfaceUtilization {
+	if speedMbps <= 0 {
+		return nil
+	}
+	latest := load.samples[len(load.samples)-1]
+	percents := make([]*float64, len(utilizationWindows))
+	for i, window := range utilizationWindows {
+		// The latest sample old enough for the window, allowing for late ticks
+		start := now.Add(-window + utilizationInterval/2)
+		j := sort.Search(len(load.samples), func(k int) bool { return load.samples[k].at.After(start) }) - 1
+		if j < 0 || latest.bytes < load.samples[j].bytes {
+			continue
+		}
+		elapsed := latest.at.Sub(load.samples[j].at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		bits := float64(latest.bytes-load.samples[j].bytes) * 8
+		percent := math.Round(bits/(elapsed*float64(speedMbps)*1e6)*1000) / 10
+		percents[i] = &percent
+	}
+
+	utilization := &models.InterfaceUtilization{Last10s: percents[0], Last1m: percents[1], Last5m: percents[2]}
+	if !load.aboveSince.IsZero() {
+		aboveSince := load.aboveSince
+		utilization.AboveSince = &aboveSince
+	}
+	return utilization
+}
+
+// checkUtilization tracks how long an interface stays above the threshold
+// and raises an INFO anomaly once per excursion after Sustain, naming the
+// devices that sent the most on it meanwhile. Must hold nm.mu.
+func (nm *NetworkMonitor) checkUtilization(status models.InterfaceStatus, load *interfaceLoad, utilization *models.InterfaceUtilization, now time.Time) {
+	config := nm.utilization.config
+	if config.Threshold <= 0 || utilization == nil || utilization.Last10s == nil || *utilization.Last10s < config.Threshold {
+		load.aboveSince, load.alerted, load.devices = time.Time{}, false, nil
+		if utilization != nil {
+			utilization.AboveSince = nil
+		}
+		return
+	}
+	if load.aboveSince.IsZero() {
+		load.aboveSince = now
+		load.devices = make(map[string]uint64)
+		aboveSince := now
+		utilization.AboveSince = &aboveSince
+	}
+	duration := now.Sub(load.aboveSince)
+	if load.alerted || duration < config.Sustain {
+		return
+	}
+	load.alerted = true
+
+	percent := strconv.FormatFloat(*utilization.Last10s, 'f', 1, 64)
+	details := map[string]string{
+		"interface":       status.Name,
+		"ifindex":         strconv.Itoa(status.Index),
+		"speed_mbps":      strconv.Itoa(status.SpeedMbps),
+		"threshold":       strconv.FormatFloat(config.Threshold, 'f', 1, 64),
+		"utilization_10s": percent,
+		"above_since":     load.aboveSince.Format(time.RFC3339),
+		"top_devices":     strings.Join(load.topDevices(), ","),
+	}
+	if status.Duplex != "" {
+		details["duplex"] = status.Duplex
+	}
+	for name, value := range map[string]*float64{"utilization_1m": utilization.Last1m, "utilization_5m": utilization.Last5m} {
+		if value != nil {
+			details[name] = strconv.FormatFloat(*value, 'f', 1, 64)
+		}
+	}
+	fmt.Printf("Interface %s has been at %s%% of its link speed for %s\n", status.Name, percent, duration.Round(time.Second))
+	nm.raiseAnomaly("INTERFACE_SATURATED", models.SeverityInfo, "",
+		map[string]string{
+			"interface": status.Name,
+			"percent":   percent,
+			"speed":     strconv.Itoa(status.SpeedMbps) + " Mb/s",
+			"duration":  duration.Round(time.Second).String(),
+		}, details)
+}
+
+// topDevices returns the devices that sent the most bytes on the interface
+// while above the threshold, as id:bytes, most first
+func (load *interfaceLoad) topDevices() []string {
+	ids := make([]string, 0, len(load.devices))
+	for id := range load.devices {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if load.devices[ids[i]] != load.devices[ids[j]] {
+			return load.devices[ids[i]] > load.devices[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	top := make([]string, 0, utilizationTopDevices)
+	for _, id := range ids[:min(len(ids), utilizationTopDevices)] {
+		top = append(top, id+":"+strconv.FormatUint(load.devices[id], 10))
+	}
+	return top
+}
+
+// persistInterfaceCounters writes the counters of every named interface,
+// adding those persisted for interfaces not attached this run
+func (nm *NetworkMonitor) persistInterfaceCounters() {
+	nm.mu.RLock()
+	counters := make(map[string]interfaceCounters, len(nm.utilization.restored))
+	for name, c := range nm.utilization.restored {
+		counters[name] = c
+	}
+	for _, status := range nm.ifaces.List() {
+		if status.Name == "" {
+			continue
+		}
+		c := counters[status.Name]
+		c.Events += status.Events
+		c.Packets += status.Packets
+		c.Bytes += status.Bytes
+		counters[status.Name] = c
+	}
+	nm.mu.RUnlock()
+
+	data, err := json.Marshal(counters)
+	if err == nil {
+		err = nm.db.Update(func(tx *buntdb.Tx) error {
+			_, _, err := tx.Set(InterfaceCountersKey, string(data), nil)
+			return err
+		})
+	}
+	if err != nil {
+		nm.Stats.FailedPersists.Add(1)
+	}
+}
+
+// InterfaceStats returns the traffic and utilization of every interface in
+// the registry, by name
+func (nm *NetworkMonitor) InterfaceStats() []models.InterfaceStats {
+	stats := []models.InterfaceStats{}
+	for _, status := range nm.ifaces.List() {
+		stats = append(stats, models.InterfaceStats{
+			Name:        status.Name,
+			SpeedMbps:   status.SpeedMbps,
+			Duplex:      status.Duplex,
+			Packets:     status.Packets,
+			Bytes:       status.Bytes,
+			Utilization: status.Utilization,
+		})
+	}
+	return stats
+}
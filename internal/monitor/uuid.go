@@ -0,0 +1,247 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// DeviceUUIDKeyPrefix prefixes the index of every device UUID ever handed
+// out: the ID of the device holding it, or of the device that absorbed it,
+// or nothing once the device was forgotten. Entries are never deleted, so a
+// UUID is never given to another device.
+const DeviceUUIDKeyPrefix = "uuid:"
+
+// ErrDeviceNotFound is returned for a UUID no tracked device holds
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrDeviceForgotten is returned for the UUID of a device that was forgotten
+var ErrDeviceForgotten = errors.New("device was forgotten")
+
+// uuidIndex maps device UUIDs to device IDs and back. Its lock is taken
+// last, after nm.mu or nm.anomalyMu.
+type uuidIndex struct {
+	mu       sync.Mutex
+	byUUID   map[string]string // UUID -> device ID, "" once forgotten
+	byDevice map[string]string // Device ID -> its own UUID
+	pending  map[string]string // byUUID entries not yet persisted
+}
+
+func newUUIDIndex() *uuidIndex {
+	return &uuidIndex{
+		byUUID:   make(map[string]string),
+		byDevice: make(map[string]string),
+		pending:  make(map[string]string),
+	}
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// set records the device a UUID refers to. Must hold idx.mu.
+func (idx *uuidIndex) set(uuid, deviceID string) {
+	if current, ok := idx.byUUID[uuid]; ok && current == deviceID {
+		return
+	}
+	idx.byUUID[uuid] = deviceID
+	idx.pending[uuid] = deviceID
+}
+
+// ensure gives a device a UUID if it has none yet, such as a new device or
+// one persisted before UUIDs existed, and indexes it
+func (idx *uuidIndex) ensure(device *models.DeviceInfo) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if device.UUID == "" {
+		device.UUID = idx.byDevice[device.ID] // Given before a failed write
+	}
+	if device.UUID == "" {
+		uuid := newUUID()
+		for _, taken := idx.byUUID[uuid]; taken; _, taken = idx.byUUID[uuid] {
+			uuid = newUUID()
+		}
+		device.UUID = uuid
+	}
+	idx.byDevice[device.ID] = device.UUID
+	idx.set(device.UUID, device.ID)
+}
+
+// absorb records that dst absorbed src: dst keeps its UUID and lists those of
+// src as former IDs, which keep resolving to dst
+func (idx *uuidIndex) absorb(dst, src *models.DeviceInfo) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, uuid := range append([]string{src.UUID}, src.FormerIDs...) {
+		if uuid == "" || uuid == dst.UUID {
+			continue
+		}
+		if !slices.Contains(dst.FormerIDs, uuid) {
+			dst.FormerIDs = append(dst.FormerIDs, uuid)
+		}
+		idx.set(uuid, dst.ID)
+	}
+	if idx.byDevice[src.ID] == src.UUID {
+		delete(idx.byDevice, src.ID)
+	}
+}
+
+// forget leaves a tombstone for the UUIDs of a forgotten device, returning
+// the entries changed so they can be persisted with the deletion
+func (idx *uuidIndex) forget(device *models.DeviceInfo) map[string]string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	tombstones := make(map[string]string)
+	for _, uuid := range append([]string{device.UUID}, device.FormerIDs...) {
+		if uuid != "" && idx.byUUID[uuid] == device.ID {
+			idx.set(uuid, "")
+			tombstones[uuid] = ""
+		}
+	}
+	delete(idx.byDevice, device.ID)
+	return tombstones
+}
+
+// device returns the UUID of a device, or "" if it has none yet
+func (idx *uuidIndex) device(deviceID string) string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.byDevice[deviceID]
+}
+
+// resolve returns the ID of the device a UUID refers to, "" if it was
+// forgotten, and whether the UUID was ever handed out
+func (idx *uuidIndex) resolve(uuid string) (string, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	deviceID, ok := idx.byUUID[strings.ToLower(uuid)]
+	return deviceID, ok
+}
+
+// takePending returns the entries to persist and forgets them
+func (idx *uuidIndex) takePending() map[string]string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if len(idx.pending) == 0 {
+		return nil
+	}
+	pending := idx.pending
+	idx.pending = make(map[string]string)
+	return pending
+}
+
+// requeue puts back entries a failed persist didn't write, unless newer ones
+// replaced them meanwhile
+func (idx *uuidIndex) requeue(entries map[string]string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for uuid, deviceID := range entries {
+		if _, ok := idx.pending[uuid]; !ok {
+			idx.pending[uuid] = deviceID
+		}
+	}
+}
+
+// writeUUIDs persists entries of the UUID index
+func writeUUIDs(tx *buntdb.Tx, entries map[string]string) error {
+	for uuid, deviceID := range entries {
+		if _, _, err := tx.Set(DeviceUUIDKeyPrefix+uuid, deviceID, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadUUIDs reads the UUID index and the UUIDs of the persisted devices.
+// Devices persisted before UUIDs existed are given one and written back,
+// unless the database is read-only.
+func (nm *NetworkMonitor) loadUUIDs() {
+	var missing []*models.DeviceInfo
+	nm.db.View(func(tx *buntdb.Tx) error {
+		tx.AscendRange("", DeviceUUIDKeyPrefix, DeviceUUIDKeyPrefix+"~", func(key, value string) bool {
+			nm.uuids.byUUID[strings.TrimPrefix(key, DeviceUUIDKeyPrefix)] = value
+			return true
+		})
+		return ascendDevices(tx, func(key, value string) bool {
+			var device models.DeviceInfo
+			if json.Unmarshal([]byte(value), &device) != nil {
+				return true
+			}
+			if device.ID == "" {
+				device.ID = key
+			}
+			if device.UUID == "" {
+				missing = append(missing, &device)
+				return true
+			}
+			nm.uuids.byDevice[device.ID] = device.UUID
+			if _, ok := nm.uuids.byUUID[device.UUID]; !ok {
+				nm.uuids.set(device.UUID, device.ID) // The index write was lost
+			}
+			return true
+		})
+	})
+	if len(missing) == 0 || nm.readOnly {
+		return
+	}
+
+	for _, device := range missing {
+		nm.uuids.ensure(device)
+	}
+	pending := nm.uuids.takePending()
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		for _, device := range missing {
+			data, _ := json.Marshal(device)
+			if _, _, err := tx.Set(device.ID, string(data), nil); err != nil {
+				return err
+			}
+		}
+		return writeUUIDs(tx, pending)
+	})
+	if err != nil {
+		// The devices get the same UUIDs back when next loaded
+		nm.uuids.requeue(pending)
+	}
+}
+
+// DeviceByUUID returns a copy of the device a UUID refers to, including a
+// former UUID of a device that absorbed another. The UUID of a forgotten
+// device returns ErrDeviceForgotten.
+func (nm *NetworkMonitor) DeviceByUUID(uuid string) (*models.DeviceInfo, error) {
+	deviceID, ok := nm.uuids.resolve(uuid)
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	if deviceID == "" {
+		return nil, ErrDeviceForgotten
+	}
+	device, ok := nm.GetDevice(deviceID)
+	if !ok {
+		return nil, ErrDeviceNotFound
+	}
+	return device, nil
+}
+
+// ResolveDeviceID returns the device ID a UUID refers to, or id itself if it
+// isn't a known UUID, so device routes accept either
+func (nm *NetworkMonitor) ResolveDeviceID(id string) string {
+	if deviceID, ok := nm.uuids.resolve(id); ok && deviceID != "" {
+		return deviceID
+	}
+	return id
+}
@@ -0,0 +1,139 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// routedUUIDMonitor returns a monitor routing 10.9.0.0/16 through a router
+func routedUUIDMonitor(t *testing.T, path string) *NetworkMonitor {
+	t.Helper()
+	nm, err := NewNetworkMonitor(16, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, routed, _ := net.ParseCIDR("10.9.0.0/16")
+	nm.SetRoutedSubnets([]*net.IPNet{routed}, false)
+	return nm
+}
+
+// deviceUUID returns the UUID of a tracked device
+func deviceUUID(t *testing.T, nm *NetworkMonitor, id string) string {
+	t.Helper()
+	device, ok := nm.GetDevice(id)
+	if !ok || device.UUID == "" {
+		t.Fatalf("device %s not tracked with a UUID", id)
+	}
+	return device.UUID
+}
+
+// arpReply returns an ARP reply from a device holding ip
+func arpReply(t *testing.T, mac, ip string) *models.NetworkEvent {
+	t.Helper()
+	evt := tcpEvent(t, mac, ip, "10.9.0.1", 1)
+	evt.EventType, evt.ArpOp = models.EVENT_TYPE_ARP, 2
+	evt.Protocol, evt.SrcPort, evt.DstPort, evt.TCPFlags = 0, 0, 0, 0
+	return evt
+}
+
+// A device absorbing a routed one keeps its UUID and resolves the absorbed
+// one; a routed record seen again afterwards is split off under a new UUID,
+// and all of it survives a restart
+func TestDeviceUUIDMergeAndSplit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm := routedUUIDMonitor(t, path)
+	const router, host = "02:00:00:00:00:01", "02:00:00:00:00:0a"
+	routedID := routedDeviceID("10.9.0.5")
+
+	nm.TrackEvent(tcpEvent(t, router, "10.9.0.5", "203.0.113.5", 443))
+	absorbed := deviceUUID(t, nm, routedID)
+
+	// The host answers ARP on the local segment: its MAC is learned
+	nm.TrackEvent(arpReply(t, host, "10.9.0.5"))
+	merged, _ := nm.GetDevice(host)
+	if merged.UUID == absorbed || !slices.Equal(merged.FormerIDs, []string{absorbed}) {
+		t.Fatalf("merged UUID %s, former %v, want its own and %s", merged.UUID, merged.FormerIDs, absorbed)
+	}
+	if _, ok := nm.GetDevice(routedID); ok {
+		t.Error("absorbed routed device still tracked")
+	}
+	for _, uuid := range []string{merged.UUID, absorbed} {
+		if device, err := nm.DeviceByUUID(uuid); err != nil || device.ID != host {
+			t.Errorf("DeviceByUUID(%s) = %v, %v, want %s", uuid, device, err, host)
+		}
+	}
+
+	// Traffic routed from the address again is another identity
+	nm.TrackEvent(tcpEvent(t, router, "10.9.0.5", "203.0.113.5", 443))
+	split := deviceUUID(t, nm, routedID)
+	if split == absorbed || split == merged.UUID {
+		t.Errorf("split routed device reuses UUID %s", split)
+	}
+	if nm.ResolveDeviceID(absorbed) != host || nm.ResolveDeviceID(split) != routedID {
+		t.Errorf("resolved %s and %s", nm.ResolveDeviceID(absorbed), nm.ResolveDeviceID(split))
+	}
+	if _, err := nm.DeviceByUUID("00000000-0000-4000-8000-000000000000"); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("unknown UUID: %v", err)
+	}
+
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	nm.Close()
+	nm = routedUUIDMonitor(t, path)
+	defer nm.Close()
+	for uuid, want := range map[string]string{merged.UUID: host, absorbed: host, split: routedID} {
+		if got := nm.ResolveDeviceID(uuid); got != want {
+			t.Errorf("after restart %s resolves to %s, want %s", uuid, got, want)
+		}
+	}
+}
+
+// A forgotten device leaves a tombstone for its UUIDs, former ones included,
+// and comes back under a new UUID
+func TestDeviceUUIDForgotten(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.db")
+	nm := routedUUIDMonitor(t, path)
+	_, guest, _ := net.ParseCIDR("10.9.0.0/24")
+	nm.SetGuestConfig(GuestConfig{Subnets: []*net.IPNet{guest}, Expiry: time.Hour})
+	const router, visitor = "02:00:00:00:00:01", "02:00:00:00:00:0b"
+
+	nm.TrackEvent(tcpEvent(t, router, "10.9.0.7", "203.0.113.5", 443))
+	former := deviceUUID(t, nm, routedDeviceID("10.9.0.7"))
+	nm.TrackEvent(arpReply(t, visitor, "10.9.0.7"))
+	device, _ := nm.GetDevice(visitor)
+	if !device.Transient || !slices.Contains(device.FormerIDs, former) {
+		t.Fatalf("visitor = transient %v, former %v", device.Transient, device.FormerIDs)
+	}
+	if _, err := nm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	forgotten, err := nm.forgetTransientDevices(time.Now().Add(2 * time.Hour))
+	if err != nil || len(forgotten) != 1 {
+		t.Fatalf("forgot %v (%v), want the visitor", forgotten, err)
+	}
+	for _, uuid := range []string{device.UUID, former} {
+		if _, err := nm.DeviceByUUID(uuid); !errors.Is(err, ErrDeviceForgotten) {
+			t.Errorf("DeviceByUUID(%s) = %v, want ErrDeviceForgotten", uuid, err)
+		}
+	}
+
+	nm.TrackEvent(arpReply(t, visitor, "10.9.0.7"))
+	if uuid := deviceUUID(t, nm, visitor); uuid == device.UUID || uuid == former {
+		t.Errorf("returning visitor got back UUID %s", uuid)
+	}
+
+	nm.Close()
+	nm = routedUUIDMonitor(t, path)
+	defer nm.Close()
+	if _, err := nm.DeviceByUUID(device.UUID); !errors.Is(err, ErrDeviceForgotten) {
+		t.Errorf("after restart: %v, want ErrDeviceForgotten", err)
+	}
+}
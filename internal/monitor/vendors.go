@@ -0,0 +1,148 @@
+package monitor
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/tidwall/buntdb"
+	"github.com/zrougamed/cerberus/internal/databases"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// VendorAliasesKey holds the configured vendor aliases in the database.
+const VendorAliasesKey = "vendor:aliases"
+
+// lookupRawVendor returns the organization the OUI of a MAC is registered to
+func (nm *NetworkMonitor) lookupRawVendor(mac string) string {
+	parts := strings.Split(strings.ToUpper(mac), ":")
+	if len(parts) < 3 {
+		return "Unknown"
+	}
+	oui := strings.Join(parts[:3], ":")
+
+	if vendor, ok := nm.ouiDB[oui]; ok {
+		return vendor
+	}
+	return "Unknown"
+}
+
+// lookupVendor returns the canonical brand of the vendor of a MAC
+func (nm *NetworkMonitor) lookupVendor(mac string) string {
+	return nm.vendors.Canonical(nm.lookupRawVendor(mac))
+}
+
+// CanonicalVendor returns the canonical brand of a vendor name, as stored in
+// DeviceInfo.Vendor
+func (nm *NetworkMonitor) CanonicalVendor(vendor string) string {
+	return nm.vendors.Canonical(vendor)
+}
+
+// canonicalizeVendor derives the vendor of a device from its registered name,
+// reporting whether it changed. Records persisted before raw names were kept
+// hold the registered name in Vendor. The device must not be shared or nm.mu
+// must be held.
+func (nm *NetworkMonitor) canonicalizeVendor(device *models.DeviceInfo) bool {
+	if device.Routed {
+		return false
+	}
+	if device.RawVendor == "" {
+		device.RawVendor = device.Vendor
+	}
+	vendor := nm.vendors.Canonical(device.RawVendor)
+	if vendor == device.Vendor {
+		return false
+	}
+	device.Vendor = vendor
+	return true
+}
+
+// loadVendorAliases restores the configured vendor aliases
+func (nm *NetworkMonitor) loadVendorAliases() {
+	nm.db.View(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(VendorAliasesKey)
+		if err != nil {
+			return nil
+		}
+		var aliases map[string]string
+		if json.Unmarshal([]byte(value), &aliases) == nil {
+			nm.vendors.SetConfigured(aliases)
+		}
+		return nil
+	})
+}
+
+// VendorAliases returns the built-in and configured vendor aliases
+func (nm *NetworkMonitor) VendorAliases() models.VendorAliases {
+	return models.VendorAliases{
+		BuiltIn:    databases.BuiltInVendorAliases(),
+		Configured: nm.vendors.Configured(),
+	}
+}
+
+// SetVendorAliases replaces and persists the configured vendor aliases, which
+// map vendor names in any variant to brands and take precedence over the
+// built-in ones. Devices already known keep their vendor until they are
+// reloaded or ReindexVendors runs.
+func (nm *NetworkMonitor) SetVendorAliases(aliases map[string]string) (models.VendorAliases, error) {
+	configured := nm.vendors.SetConfigured(aliases)
+	data, err := json.Marshal(configured)
+	if err != nil {
+		return models.VendorAliases{}, err
+	}
+	err = nm.db.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set(VendorAliasesKey, string(data), nil)
+		return err
+	})
+	if err != nil {
+		return models.VendorAliases{}, err
+	}
+	return nm.VendorAliases(), nil
+}
+
+// ReindexVendors re-canonicalizes the vendor of every known device, cached or
+// persisted, after the aliases changed
+func (nm *NetworkMonitor) ReindexVendors() (models.VendorReindex, error) {
+	var result models.VendorReindex
+
+	nm.lockAll()
+	cached := make(map[string]bool)
+	for _, id := range nm.Cache.Keys() {
+		if device, ok := nm.Cache.Get(id); ok {
+			cached[id] = true
+			result.Checked++
+			if nm.canonicalizeVendor(device) {
+				result.Changed++
+			}
+		}
+	}
+	nm.unlockAll()
+
+	// Cached devices are written by the next persist
+	err := nm.db.Update(func(tx *buntdb.Tx) error {
+		updates := make(map[string]string)
+		ascendDevices(tx, func(key, value string) bool {
+			if cached[key] {
+				return true
+			}
+			var device models.DeviceInfo
+			if json.Unmarshal([]byte(value), &device) != nil {
+				return true
+			}
+			result.Checked++
+			if nm.canonicalizeVendor(&device) {
+				if data, err := json.Marshal(&device); err == nil {
+					updates[key] = string(data)
+					result.Changed++
+				}
+			}
+			return true
+		})
+		for key, value := range updates {
+			if _, _, err := tx.Set(key, value, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
@@ -3,9 +3,12 @@ package network
 import (
 	"fmt"
 	"net"
+	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -30,6 +33,7 @@ type NetworkTopology struct {
 	PrivateRanges   []*net.IPNet
 	DockerNetworks  []*net.IPNet
 	VirtualNetworks []*net.IPNet
+	TrustedNetworks []*net.IPNet // User-declared home networks, e.g. remote offices over VPN
 }
 
 var (
@@ -367,6 +371,80 @@ func parseRanges(ranges []string) []*net.IPNet {
 	return result
 }
 
+// ParseCIDRList parses a comma-separated list of CIDRs (e.g. "10.20.0.0/16,10.30.0.0/16")
+func ParseCIDRList(list string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, r := range strings.Split(list, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", r, err)
+		}
+		result = append(result, ipnet)
+	}
+	return result, nil
+}
+
+// HostAddresses returns the MACs and IPv4 addresses of every up, non-loopback
+// interface of this host, virtual ones included
+func HostAddresses() (macs, ips []string, err error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to enumerate interfaces: %w", err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if len(iface.HardwareAddr) == 6 {
+			macs = append(macs, iface.HardwareAddr.String())
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				ips = append(ips, ipnet.IP.To4().String())
+			}
+		}
+	}
+	return macs, ips, nil
+}
+
+// neighborTable is the kernel's IPv4 neighbor (ARP) table on Linux
+const neighborTable = "/proc/net/arp"
+
+// NeighborIPs returns the IPv4 addresses with a resolved entry in the kernel
+// neighbor table. It is only available on Linux.
+func NeighborIPs() ([]string, error) {
+	data, err := os.ReadFile(neighborTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the neighbor table: %w", err)
+	}
+
+	var ips []string
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[1:] { // Skip the header
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		if err != nil || flags&0x2 == 0 { // ATF_COM: the entry is complete
+			continue
+		}
+		ips = append(ips, fields[0])
+	}
+	return ips, nil
+}
+
 // IsLocalIP checks if an IP is in local subnets
 func (topo *NetworkTopology) IsLocalIP(ip net.IP) bool {
 	for _, subnet := range topo.LocalSubnets {
@@ -387,6 +465,16 @@ func (topo *NetworkTopology) IsPrivateIP(ip net.IP) bool {
 	return false
 }
 
+// IsTrustedIP checks if an IP is in user-declared trusted networks
+func (topo *NetworkTopology) IsTrustedIP(ip net.IP) bool {
+	for _, ipnet := range topo.TrustedNetworks {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsDockerIP checks if an IP is in Docker networks
 func (topo *NetworkTopology) IsDockerIP(ip net.IP) bool {
 	for _, ipnet := range topo.DockerNetworks {
@@ -437,6 +525,9 @@ func (topo *NetworkTopology) ClassifyIP(ip net.IP) string {
 	if topo.IsLocalIP(ip) {
 		return "LOCAL"
 	}
+	if topo.IsTrustedIP(ip) {
+		return "TRUSTED"
+	}
 	if topo.IsPrivateIP(ip) {
 		return "PRIVATE"
 	}
@@ -508,3 +599,119 @@ func (topo *NetworkTopology) PrintTopology() {
 	fmt.Println("╚══════════════════════════════════════════════════════════╝")
 	fmt.Println("Flags: P=Private, V=Virtual, D=Docker")
 }
+
+// TopologySummary is the detected topology and the networks traffic to which
+// isn't considered external
+type TopologySummary struct {
+	DefaultGateway  string   `json:"default_gateway,omitempty"`
+	PrimarySubnet   string   `json:"primary_subnet,omitempty"`
+	LocalSubnets    []string `json:"local_subnets"`
+	PrivateRanges   []string `json:"private_ranges"`
+	TrustedNetworks []string `json:"trusted_networks"` // As configured
+	VirtualNetworks []string `json:"virtual_networks"`
+	DockerNetworks  []string `json:"docker_networks"`
+	Trusted         []string `json:"trusted"` // Every network ClassifyIP doesn't call EXTERNAL, without duplicates
+}
+
+// Summary returns the topology with every network as a CIDR string
+func (topo *NetworkTopology) Summary() TopologySummary {
+	summary := TopologySummary{
+		LocalSubnets:    cidrStrings(topo.LocalSubnets),
+		PrivateRanges:   cidrStrings(topo.PrivateRanges),
+		TrustedNetworks: cidrStrings(topo.TrustedNetworks),
+		VirtualNetworks: cidrStrings(topo.VirtualNetworks),
+		DockerNetworks:  cidrStrings(topo.DockerNetworks),
+		Trusted:         []string{},
+	}
+	if topo.DefaultGateway != nil {
+		summary.DefaultGateway = topo.DefaultGateway.String()
+	}
+	if topo.PrimarySubnet != nil {
+		summary.PrimarySubnet = topo.PrimarySubnet.String()
+	}
+
+	seen := make(map[string]bool)
+	for _, group := range [][]*net.IPNet{topo.DockerNetworks, topo.VirtualNetworks, topo.LocalSubnets, topo.TrustedNetworks, topo.PrivateRanges} {
+		for _, ipnet := range group {
+			cidr := ipnet.String()
+			if !seen[cidr] {
+				seen[cidr] = true
+				summary.Trusted = append(summary.Trusted, cidr)
+			}
+		}
+	}
+	return summary
+}
+
+func cidrStrings(nets []*net.IPNet) []string {
+	result := make([]string, 0, len(nets))
+	for _, ipnet := range nets {
+		result = append(result, ipnet.String())
+	}
+	return result
+}
+
+// InterfaceRecommendation describes whether an interface is worth capturing on
+type InterfaceRecommendation struct {
+	Name        string `json:"name"`
+	IPAddress   string `json:"ip"`
+	Subnet      string `json:"subnet"`
+	Primary     bool   `json:"primary"`
+	Recommended bool   `json:"recommended"`
+	Reason      string `json:"reason"`
+}
+
+// InterfaceRecommendations classifies every detected interface, recommending
+// physical ones and excluding virtual and container networks. The primary
+// interface comes first, then other recommended interfaces, then the rest.
+func (topo *NetworkTopology) InterfaceRecommendations() []InterfaceRecommendation {
+	primary := topo.GetPrimaryInterface()
+
+	recs := make([]InterfaceRecommendation, 0, len(topo.Interfaces))
+	for name, info := range topo.Interfaces {
+		rec := InterfaceRecommendation{
+			Name:        name,
+			IPAddress:   info.IPAddress.String(),
+			Subnet:      info.Subnet.String(),
+			Primary:     primary != nil && primary.InterfaceName == name,
+			Recommended: true,
+		}
+
+		switch {
+		case info.IsDockerNet:
+			rec.Recommended = false
+			rec.Reason = "container network"
+		case info.IsVirtualNet:
+			rec.Recommended = false
+			rec.Reason = "virtual interface"
+		case rec.Primary:
+			rec.Reason = "primary interface (default route)"
+		default:
+			rec.Reason = "physical interface"
+		}
+
+		recs = append(recs, rec)
+	}
+
+	sort.Slice(recs, func(i, j int) bool {
+		if recs[i].Primary != recs[j].Primary {
+			return recs[i].Primary
+		}
+		if recs[i].Recommended != recs[j].Recommended {
+			return recs[i].Recommended
+		}
+		return recs[i].Name < recs[j].Name
+	})
+	return recs
+}
+
+// RecommendedInterfaces returns the names of the interfaces worth capturing on
+func (topo *NetworkTopology) RecommendedInterfaces() []string {
+	var names []string
+	for _, rec := range topo.InterfaceRecommendations() {
+		if rec.Recommended {
+			names = append(names, rec.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,149 @@
+package outbound
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrDisabled is returned for every request while outbound HTTP is disabled
+var ErrDisabled = errors.New("outbound HTTP is disabled")
+
+// retryBackoff is the delay before the first retry; it doubles on each attempt
+const retryBackoff = time.Second
+
+// Config controls every outbound HTTP request cerberus makes: IEEE OUI and
+// IANA registry downloads and online vendor lookups
+type Config struct {
+	Disabled bool          // Air-gapped: never attempt a connection
+	Proxy    string        // Proxy URL; empty honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	Timeout  time.Duration // Per-request timeout; 0 keeps each caller's default
+	Retries  int           // Extra attempts after a network error, 429 or 5xx
+	CABundle string        // PEM file of CAs trusted in addition to the system pool
+}
+
+var (
+	mu           sync.RWMutex
+	config       Config
+	transport    = newTransport(http.ProxyFromEnvironment, nil)
+	disabledOnce sync.Once
+)
+
+func newTransport(proxy func(*http.Request) (*url.URL, error), roots *x509.CertPool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	if roots != nil {
+		t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return t
+}
+
+// Configure replaces the outbound HTTP settings
+func Configure(c Config) error {
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		proxyURL, err := url.Parse(c.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", c.Proxy)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	var roots *x509.CertPool
+	if c.CABundle != "" {
+		pem, err := os.ReadFile(c.CABundle)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots, err = x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", c.CABundle)
+		}
+	}
+
+	if c.Retries < 0 || c.Timeout < 0 {
+		return fmt.Errorf("timeout and retries must not be negative")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+	transport = newTransport(proxy, roots)
+	return nil
+}
+
+// Enabled reports whether outbound HTTP is allowed
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return !config.Disabled
+}
+
+// Check returns ErrDisabled, announcing it once, while outbound HTTP is disabled
+func Check() error {
+	if Enabled() {
+		return nil
+	}
+	disabledOnce.Do(func() {
+		fmt.Println("Outbound HTTP disabled, skipping online downloads and lookups")
+	})
+	return ErrDisabled
+}
+
+// Client returns an HTTP client using the configured proxy and CAs. The
+// configured timeout takes precedence over defaultTimeout.
+func Client(defaultTimeout time.Duration) *http.Client {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// Get fetches url with the configured client, retrying transient failures.
+// While outbound HTTP is disabled it fails with ErrDisabled without connecting.
+func Get(url string, header http.Header, defaultTimeout time.Duration) (*http.Response, error) {
+	if err := Check(); err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	retries := config.Retries
+	mu.RUnlock()
+
+	client := Client(defaultTimeout)
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := client.Do(req)
+		transient := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !transient || attempt >= retries {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
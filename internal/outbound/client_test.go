@@ -0,0 +1,137 @@
+package outbound
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// configure applies c for the rest of the test, restoring the defaults after
+func configure(t *testing.T, c Config) {
+	t.Helper()
+	if err := Configure(c); err != nil {
+		t.Fatalf("Configure(%+v): %v", c, err)
+	}
+	t.Cleanup(func() { Configure(Config{}) })
+}
+
+// fetch gets url and returns the body, failing the test on any error
+func fetch(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := Get(url, http.Header{"User-Agent": {"cerberus-test"}}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// Lookups go through the configured proxy, headers included
+func TestGetThroughProxy(t *testing.T) {
+	var requested atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(r.URL.String() + " " + r.Header.Get("User-Agent"))
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+	configure(t, Config{Proxy: proxy.URL})
+
+	if body := fetch(t, "http://standards-oui.ieee.org/oui/oui.txt"); body != "via proxy" {
+		t.Errorf("body = %q, want the proxy's", body)
+	}
+	if got, want := requested.Load(), "http://standards-oui.ieee.org/oui/oui.txt cerberus-test"; got != want {
+		t.Errorf("proxy got %v, want %q", got, want)
+	}
+}
+
+// A server signed by a CA outside the system pool is trusted once the CA
+// bundle holds it
+func TestGetCustomCA(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "trusted")
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // The rejected handshake
+	server.StartTLS()
+	defer server.Close()
+
+	configure(t, Config{})
+	if _, err := Get(server.URL, nil, 5*time.Second); err == nil {
+		t.Fatal("Get succeeded without the CA")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configure(t, Config{CABundle: bundle})
+	if body := fetch(t, server.URL); body != "trusted" {
+		t.Errorf("body = %q, want %q", body, "trusted")
+	}
+}
+
+// Offline mode refuses every request without connecting
+func TestGetDisabled(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	configure(t, Config{Disabled: true, Retries: 3})
+	if Enabled() {
+		t.Error("Enabled() = true in offline mode")
+	}
+	if resp, err := Get(server.URL, nil, 5*time.Second); !errors.Is(err, ErrDisabled) || resp != nil {
+		t.Errorf("Get = %v, %v, want %v", resp, err, ErrDisabled)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("server hit %d times in offline mode", n)
+	}
+
+	configure(t, Config{})
+	if !Enabled() || fetch(t, server.URL) != "" || hits.Load() != 1 {
+		t.Errorf("back online: enabled %v, %d hits", Enabled(), hits.Load())
+	}
+}
+
+func TestConfigureRejects(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]Config{
+		"proxy without host":             {Proxy: "proxy.example:3128"},
+		"missing CA bundle":              {CABundle: filepath.Join(dir, "missing.pem")},
+		"CA bundle without certificates": {CABundle: empty},
+		"negative retries":               {Retries: -1},
+		"negative timeout":               {Timeout: -time.Second},
+	}
+	for name, c := range tests {
+		if err := Configure(c); err == nil {
+			t.Errorf("%s: Configure(%+v) accepted", name, c)
+		}
+	}
+}
+
+// The configured timeout takes precedence over each caller's default
+func TestClientTimeout(t *testing.T) {
+	configure(t, Config{})
+	if got := Client(30 * time.Second).Timeout; got != 30*time.Second {
+		t.Errorf("default timeout %v, want 30s", got)
+	}
+	configure(t, Config{Timeout: 5 * time.Second})
+	if got := Client(30 * time.Second).Timeout; got != 5*time.Second {
+		t.Errorf("configured timeout %v, want 5s", got)
+	}
+}
@@ -0,0 +1,226 @@
+package threatintel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/outbound"
+)
+
+// Source names a list and where it is loaded from
+type Source struct {
+	Name     string
+	Location string // Local path, or http(s) URL
+}
+
+// Match is a list entry an address or name matched
+type Match struct {
+	List  string
+	Entry string // CIDR, IP or domain as listed
+}
+
+// ParseSources parses comma-separated name=path-or-URL pairs
+func ParseSources(value string) ([]Source, error) {
+	var sources []Source
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, location, ok := strings.Cut(item, "=")
+		name, location = strings.TrimSpace(name), strings.TrimSpace(location)
+		if !ok || name == "" || location == "" {
+			return nil, fmt.Errorf("invalid list %q: expected <name>=<path or URL>", item)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate list name %q", name)
+		}
+		seen[name] = true
+		sources = append(sources, Source{Name: name, Location: location})
+	}
+	return sources, nil
+}
+
+// listStats counts the matches of a list. It outlives reloads of the list.
+type listStats struct {
+	matches   atomic.Uint64
+	lastMatch atomic.Int64 // Unix nanoseconds, 0 before the first match
+}
+
+// Feed matches against a set of lists and reloads them. Matching reads an
+// immutable snapshot swapped in whole once every list is loaded, so it never
+// sees a half-loaded list and takes no lock.
+type Feed struct {
+	sources []Source
+	stats   map[string]*listStats // By list name, fixed at creation
+
+	lists atomic.Pointer[[]*List] // In source order; a list that never loaded is absent
+
+	mu       sync.Mutex // Serializes reloads and guards the load state below
+	loadedAt map[string]time.Time
+	errors   map[string]string
+}
+
+// NewFeed loads every list. A list that fails to load is reported in the
+// returned error and in Lists, and matches nothing until a reload succeeds.
+func NewFeed(sources []Source) (*Feed, error) {
+	f := &Feed{
+		sources:  sources,
+		stats:    make(map[string]*listStats, len(sources)),
+		loadedAt: make(map[string]time.Time),
+		errors:   make(map[string]string),
+	}
+	for _, source := range sources {
+		f.stats[source.Name] = &listStats{}
+	}
+	f.lists.Store(&[]*List{})
+	return f, f.Reload()
+}
+
+// Reload loads every list again and swaps them in at once. A list that fails
+// to load keeps its previous version; the failures are returned together.
+func (f *Feed) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	previous := make(map[string]*List)
+	for _, list := range *f.lists.Load() {
+		previous[list.name] = list
+	}
+
+	var lists []*List
+	var failures []string
+	for _, source := range f.sources {
+		list, err := load(source)
+		if err != nil {
+			f.errors[source.Name] = err.Error()
+			failures = append(failures, err.Error())
+			list = previous[source.Name]
+		} else {
+			delete(f.errors, source.Name)
+			f.loadedAt[source.Name] = time.Now()
+		}
+		if list != nil {
+			lists = append(lists, list)
+		}
+	}
+	f.lists.Store(&lists)
+
+	if len(failures) > 0 {
+		return fmt.Errorf("threat lists: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// load reads and parses the list of a source
+func load(source Source) (*List, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(source.Location, "http://") || strings.HasPrefix(source.Location, "https://") {
+		resp, err := outbound.Get(source.Location, nil, 30*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.Name, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: download failed: %s", source.Name, resp.Status)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(source.Location)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", source.Name, err)
+		}
+		r = file
+	}
+	defer r.Close()
+
+	return Parse(source.Name, r)
+}
+
+// Start reloads the lists every interval, reporting failures through report
+func (f *Feed) Start(interval time.Duration, report func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := f.Reload(); err != nil {
+				report(err)
+			}
+		}
+	}()
+}
+
+// record counts a match of a list
+func (f *Feed) record(list string, now time.Time) {
+	if stats := f.stats[list]; stats != nil {
+		stats.matches.Add(1)
+		stats.lastMatch.Store(now.UnixNano())
+	}
+}
+
+// MatchIP returns the first list, in source order, with an entry covering addr
+func (f *Feed) MatchIP(addr netip.Addr, now time.Time) (Match, bool) {
+	for _, list := range *f.lists.Load() {
+		if entry, ok := list.MatchIP(addr); ok {
+			f.record(list.name, now)
+			return Match{List: list.name, Entry: entry}, true
+		}
+	}
+	return Match{}, false
+}
+
+// MatchDomain returns the first list, in source order, listing name or a
+// parent domain of it. name must be lower case without a trailing dot.
+func (f *Feed) MatchDomain(name string, now time.Time) (Match, bool) {
+	for _, list := range *f.lists.Load() {
+		if entry, ok := list.MatchDomain(name); ok {
+			f.record(list.name, now)
+			return Match{List: list.name, Entry: entry}, true
+		}
+	}
+	return Match{}, false
+}
+
+// Lists returns the state of every configured list, in source order
+func (f *Feed) Lists() []models.ThreatList {
+	loaded := make(map[string]*List)
+	for _, list := range *f.lists.Load() {
+		loaded[list.name] = list
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lists := make([]models.ThreatList, 0, len(f.sources))
+	for _, source := range f.sources {
+		state := models.ThreatList{
+			Name:      source.Name,
+			Source:    source.Location,
+			LastError: f.errors[source.Name],
+		}
+		if list := loaded[source.Name]; list != nil {
+			state.CIDRs, state.Domains = list.Len()
+		}
+		if loadedAt, ok := f.loadedAt[source.Name]; ok {
+			state.LoadedAt = &loadedAt
+		}
+		stats := f.stats[source.Name]
+		state.Matches = stats.matches.Load()
+		if nanos := stats.lastMatch.Load(); nanos != 0 {
+			lastMatch := time.Unix(0, nanos)
+			state.LastMatch = &lastMatch
+		}
+		lists = append(lists, state)
+	}
+	return lists
+}
@@ -0,0 +1,209 @@
+// Package threatintel loads blocklists of CIDRs and domains, such as the
+// Spamhaus DROP list or Pi-hole style domain lists, and matches destination
+// addresses and names against them.
+package threatintel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// List is one parsed blocklist. It is never modified once parsed, so it can
+// be matched from any goroutine.
+type List struct {
+	name string
+
+	v4      map[int]map[uint32]string       // Prefix length -> masked address -> entry
+	v4Bits  []int                           // Prefix lengths in v4, longest first
+	v6      map[int]map[netip.Prefix]string // Prefix length -> masked prefix -> entry
+	v6Bits  []int
+	domains map[string]bool
+
+	cidrs int
+}
+
+// Parse reads a blocklist with one CIDR, IP or domain per line. Text after #
+// or ; is a comment, so Spamhaus DROP lines ("1.2.3.0/24 ; SBL123") parse as
+// is. Hosts-file lines ("0.0.0.0 ads.example") list the domain, and a leading
+// "*." is dropped since every domain also covers its subdomains.
+func Parse(name string, r io.Reader) (*List, error) {
+	list := &List{
+		name:    name,
+		v4:      make(map[int]map[uint32]string),
+		v6:      make(map[int]map[netip.Prefix]string),
+		domains: make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := fields[0]
+		// Hosts-file format: a sink address followed by the blocked names
+		if addr, err := netip.ParseAddr(entry); err == nil && len(fields) > 1 && (addr.IsUnspecified() || addr.IsLoopback()) {
+			for _, domain := range fields[1:] {
+				if validDomain(domain) {
+					list.addDomain(domain)
+				}
+			}
+			continue
+		}
+		if len(fields) > 1 {
+			return nil, fmt.Errorf("%s:%d: expected one entry per line, got %q", name, lineNum, strings.TrimSpace(line))
+		}
+
+		if prefix, err := parsePrefix(entry); err == nil {
+			list.addPrefix(prefix, entry)
+			continue
+		}
+		if !validDomain(entry) {
+			return nil, fmt.Errorf("%s:%d: invalid CIDR, IP or domain %q", name, lineNum, entry)
+		}
+		list.addDomain(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	list.v4Bits = lengths(list.v4)
+	list.v6Bits = lengths(list.v6)
+	return list, nil
+}
+
+// parsePrefix parses a CIDR, or an IP as a single-address prefix
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// validDomain reports whether entry looks like a domain name
+func validDomain(entry string) bool {
+	entry = strings.TrimPrefix(strings.TrimSuffix(entry, "."), "*.")
+	if entry == "" || len(entry) > 253 || !strings.Contains(entry, ".") {
+		return false
+	}
+	for _, label := range strings.Split(entry, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (l *List) addPrefix(prefix netip.Prefix, entry string) {
+	l.cidrs++
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		if l.v4[bits] == nil {
+			l.v4[bits] = make(map[uint32]string)
+		}
+		l.v4[bits][v4Key(prefix.Addr(), bits)] = entry
+		return
+	}
+	if l.v6[bits] == nil {
+		l.v6[bits] = make(map[netip.Prefix]string)
+	}
+	l.v6[bits][prefix] = entry
+}
+
+func (l *List) addDomain(domain string) {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSuffix(domain, "."), "*."))
+	if domain != "" && domain != "localhost" {
+		l.domains[domain] = true
+	}
+}
+
+// lengths returns the prefix lengths of a table, longest first
+func lengths[K comparable](table map[int]map[K]string) []int {
+	bits := make([]int, 0, len(table))
+	for b := range table {
+		bits = append(bits, b)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(bits)))
+	return bits
+}
+
+// v4Key returns the first bits of an IPv4 address as a map key
+func v4Key(addr netip.Addr, bits int) uint32 {
+	b := addr.As4()
+	v := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	if bits == 0 {
+		return 0
+	}
+	return v &^ (1<<(32-bits) - 1)
+}
+
+// Name returns the name the list was loaded under
+func (l *List) Name() string {
+	return l.name
+}
+
+// Len returns the CIDRs and domains in the list
+func (l *List) Len() (cidrs, domains int) {
+	return l.cidrs, len(l.domains)
+}
+
+// MatchIP returns the most specific entry covering addr. The table of each
+// prefix length in use is probed once, longest first, which bounds a lookup by
+// the distinct lengths in the list rather than by its size.
+func (l *List) MatchIP(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	if addr.Is4() {
+		for _, bits := range l.v4Bits {
+			if entry, ok := l.v4[bits][v4Key(addr, bits)]; ok {
+				return entry, true
+			}
+		}
+		return "", false
+	}
+	for _, bits := range l.v6Bits {
+		prefix, _ := addr.Prefix(bits)
+		if entry, ok := l.v6[bits][prefix]; ok {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// MatchDomain returns the listed domain that name is, or is a subdomain of
+func (l *List) MatchDomain(name string) (string, bool) {
+	if len(l.domains) == 0 {
+		return "", false
+	}
+	for {
+		if l.domains[name] {
+			return name, true
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			return "", false
+		}
+		name = name[dot+1:]
+	}
+}
@@ -4,28 +4,85 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zrougamed/cerberus/internal/models"
 )
 
-func Contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+// ParseEventTypes converts a comma-separated list of event names (e.g. "arp,dns")
+// into event type identifiers. An empty list or "all" selects every event type;
+// a list naming none, such as ",", is an error rather than a filter dropping
+// every event.
+func ParseEventTypes(list string) ([]uint8, error) {
+	if list == "" || strings.EqualFold(strings.TrimSpace(list), "all") {
+		types := make([]uint8, 0, len(models.EventTypeNames))
+		for t := range models.EventTypeNames {
+			types = append(types, t)
 		}
+		return types, nil
 	}
-	return false
+
+	var types []uint8
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for t, n := range models.EventTypeNames {
+			if n == name {
+				types = append(types, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event type %q", name)
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no event types in %q", list)
+	}
+	return types, nil
 }
 
-func Max(a, b int) int {
-	if a > b {
-		return a
+// EventByteOrder returns the byte order of the multi-byte fields of records
+// emitted by a BPF object with the given event_layout. Since layout 4 every
+// field is big-endian; before, only IPv4 addresses were, the other fields
+// being in the byte order of the host that ran the program.
+func EventByteOrder(layout uint32) binary.ByteOrder {
+	if layout >= 4 {
+		return binary.BigEndian
 	}
-	return b
+	return binary.NativeEndian
 }
 
-func ParseNetworkEvent(data []byte) *models.NetworkEvent {
+// EventHeaderSize is the fixed part of a network_event of the current layout;
+// the L7 payload follows
+const EventHeaderSize = 54
+
+// legacyEventPayload is the fixed L7 payload of layouts before 5
+const legacyEventPayload = 32
+
+// MinEventSize returns the shortest valid network_event record of a layout
+func MinEventSize(layout uint32) int {
+	if layout >= 5 {
+		return EventHeaderSize
+	}
+	// Layout 3 records are 84 bytes; 82 still parses those of layout 2
+	return 82
+}
+
+// ParseNetworkEvent parses a network_event record emitted by a BPF object with
+// the given event_layout, which must be at least MinEventSize(layout) bytes.
+// Fields other than the IPv4 addresses are in EventByteOrder(layout); IPv4
+// addresses are always in network byte order, as copied from the packet.
+func ParseNetworkEvent(data []byte, layout uint32) *models.NetworkEvent {
+	order := EventByteOrder(layout)
 	evt := &models.NetworkEvent{}
 	offset := 0
 
@@ -42,19 +99,19 @@ func ParseNetworkEvent(data []byte) *models.NetworkEvent {
 	offset += 6
 
 	// Source IP (4 bytes)
-	evt.SrcIP = binary.LittleEndian.Uint32(data[offset : offset+4])
+	evt.SrcIP = binary.BigEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
 	// Destination IP (4 bytes)
-	evt.DstIP = binary.LittleEndian.Uint32(data[offset : offset+4])
+	evt.DstIP = binary.BigEndian.Uint32(data[offset : offset+4])
 	offset += 4
 
 	// Source Port (2 bytes)
-	evt.SrcPort = binary.LittleEndian.Uint16(data[offset : offset+2])
+	evt.SrcPort = order.Uint16(data[offset : offset+2])
 	offset += 2
 
 	// Destination Port (2 bytes)
-	evt.DstPort = binary.LittleEndian.Uint16(data[offset : offset+2])
+	evt.DstPort = order.Uint16(data[offset : offset+2])
 	offset += 2
 
 	// Protocol (1 byte)
@@ -66,7 +123,7 @@ func ParseNetworkEvent(data []byte) *models.NetworkEvent {
 	offset += 1
 
 	// ARP Operation (2 bytes)
-	evt.ArpOp = binary.LittleEndian.Uint16(data[offset : offset+2])
+	evt.ArpOp = order.Uint16(data[offset : offset+2])
 	offset += 2
 
 	// ARP SHA (6 bytes)
@@ -86,20 +143,667 @@ func ParseNetworkEvent(data []byte) *models.NetworkEvent {
 	offset += 1
 
 	// Interface Index (4 bytes)
-	evt.IfIndex = binary.LittleEndian.Uint32(data[offset : offset+4])
+	evt.IfIndex = order.Uint32(data[offset : offset+4])
 	offset += 4
 
+	if layout >= 5 {
+		// IP TTL (1 byte), TCP window (2 bytes), packet length (2 bytes)
+		evt.IPTTL = data[offset]
+		evt.TCPWindow = order.Uint16(data[offset+1 : offset+3])
+		evt.PacketLen = order.Uint16(data[offset+3 : offset+5])
+		offset += 5
+
+		// L7 payload length (2 bytes) and as many payload bytes, trusting
+		// the record size over the length field
+		length := int(order.Uint16(data[offset : offset+2]))
+		offset += 2
+		length = min(length, len(data)-offset)
+		evt.L7Payload = append([]byte(nil), data[offset:offset+length]...)
+		return evt
+	}
+
 	// L7 Payload (32 bytes)
-	if len(data) >= offset+32 {
-		copy(evt.L7Payload[:], data[offset:offset+32])
+	if len(data) >= offset+legacyEventPayload {
+		evt.L7Payload = append([]byte(nil), data[offset:offset+legacyEventPayload]...)
+	}
+	offset += legacyEventPayload
+
+	// IP TTL (1 byte) and TCP window (2 bytes)
+	if len(data) >= offset+3 {
+		evt.IPTTL = data[offset]
+		evt.TCPWindow = order.Uint16(data[offset+1 : offset+3])
+	}
+	offset += 3
+
+	// Packet length (2 bytes), absent from events of older BPF objects
+	if len(data) >= offset+2 {
+		evt.PacketLen = order.Uint16(data[offset : offset+2])
+	}
+
+	return evt
+}
+
+// tlsHelloHeaderSize is the fixed part of a tls_hello_event before its data
+const tlsHelloHeaderSize = 20
+
+// ParseTLSHelloEvent parses a tls_hello_event record in the given byte order,
+// or returns nil if it is malformed
+func ParseTLSHelloEvent(data []byte, order binary.ByteOrder) *models.TLSHelloEvent {
+	if len(data) < tlsHelloHeaderSize {
+		return nil
+	}
+
+	evt := &models.TLSHelloEvent{}
+	copy(evt.SrcMac[:], data[0:6])
+	evt.SrcIP = binary.BigEndian.Uint32(data[6:10])
+	evt.DstIP = binary.BigEndian.Uint32(data[10:14])
+	evt.SrcPort = order.Uint16(data[14:16])
+	evt.DstPort = order.Uint16(data[16:18])
+
+	length := int(order.Uint16(data[18:20]))
+	if length > len(data)-tlsHelloHeaderSize {
+		return nil
 	}
+	evt.Data = append([]byte(nil), data[tlsHelloHeaderSize:tlsHelloHeaderSize+length]...)
 
 	return evt
 }
 
-func IntToIP(i uint32) net.IP {
+// dnsQueryHeaderSize is the fixed part of a dns_query_event before its data
+const dnsQueryHeaderSize = 16
+
+// ParseDNSQueryEvent parses a dns_query_event record in the given byte order,
+// or returns nil if it is malformed
+func ParseDNSQueryEvent(data []byte, order binary.ByteOrder) *models.DNSQueryEvent {
+	if len(data) < dnsQueryHeaderSize {
+		return nil
+	}
+
+	evt := &models.DNSQueryEvent{}
+	copy(evt.SrcMac[:], data[0:6])
+	evt.SrcIP = binary.BigEndian.Uint32(data[6:10])
+	evt.DstIP = binary.BigEndian.Uint32(data[10:14])
+
+	length := int(order.Uint16(data[14:16]))
+	if length > len(data)-dnsQueryHeaderSize {
+		return nil
+	}
+	evt.Data = append([]byte(nil), data[dnsQueryHeaderSize:dnsQueryHeaderSize+length]...)
+
+	return evt
+}
+
+// httpRequestHeaderSize is the fixed part of an http_request_event before its data
+const httpRequestHeaderSize = 20
+
+// ParseHTTPRequestEvent parses an http_request_event record in the given byte
+// order, or returns nil if it is malformed
+func ParseHTTPRequestEvent(data []byte, order binary.ByteOrder) *models.HTTPRequestEvent {
+	if len(data) < httpRequestHeaderSize {
+		return nil
+	}
+
+	evt := &models.HTTPRequestEvent{}
+	copy(evt.SrcMac[:], data[0:6])
+	evt.SrcIP = binary.BigEndian.Uint32(data[6:10])
+	evt.DstIP = binary.BigEndian.Uint32(data[10:14])
+	evt.SrcPort = order.Uint16(data[14:16])
+	evt.DstPort = order.Uint16(data[16:18])
+
+	length := int(order.Uint16(data[18:20]))
+	if length > len(data)-httpRequestHeaderSize {
+		return nil
+	}
+	evt.Data = append([]byte(nil), data[httpRequestHeaderSize:httpRequestHeaderSize+length]...)
+
+	return evt
+}
+
+// flowSummarySize is the size of a flow_summary_event record
+const flowSummarySize = 46
+
+// ParseFlowSummaryEvent parses a flow_summary_event record in the given byte
+// order, or returns nil if it is malformed
+func ParseFlowSummaryEvent(data []byte, order binary.ByteOrder) *models.FlowSummaryEvent {
+	if len(data) < flowSummarySize {
+		return nil
+	}
+
+	evt := &models.FlowSummaryEvent{}
+	copy(evt.SrcMac[:], data[0:6])
+	copy(evt.DstMac[:], data[6:12])
+	evt.SrcIP = binary.BigEndian.Uint32(data[12:16])
+	evt.DstIP = binary.BigEndian.Uint32(data[16:20])
+	evt.SrcPort = order.Uint16(data[20:22])
+	evt.DstPort = order.Uint16(data[22:24])
+	evt.Protocol = data[24]
+	evt.Reason = data[25]
+	evt.IfIndex = order.Uint32(data[26:30])
+	evt.Packets = order.Uint32(data[30:34])
+	evt.Bytes = order.Uint64(data[34:42])
+	evt.Duration = time.Duration(order.Uint32(data[42:46])) * time.Millisecond
+
+	return evt
+}
+
+// HTTPHostHeader returns the lowercased Host header of an HTTP request without
+// its port, or "" if the header is missing or was not captured in full
+func HTTPHostHeader(request []byte) string {
+	lines := strings.Split(string(request), "\r\n")
+	if len(lines) < 3 {
+		return ""
+	}
+	// The last line may be cut off by the capture length
+	for _, line := range lines[1 : len(lines)-1] {
+		if line == "" {
+			break // End of headers
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, "host") {
+			continue
+		}
+		host := strings.ToLower(strings.TrimSpace(value))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host
+	}
+	return ""
+}
+
+// DNSQuestionLabels returns the labels of the first question of a DNS message,
+// or nil if the name is truncated or malformed
+func DNSQuestionLabels(msg []byte) []string {
+	// Skip DNS header (12 bytes) and parse QNAME
+	offset := 12
+	var labels []string
+
+	for {
+		if offset >= len(msg) {
+			return nil
+		}
+		labelLen := int(msg[offset])
+		if labelLen == 0 {
+			return labels
+		}
+		// Compression pointers never appear in a question's name
+		if labelLen > 63 || offset+labelLen+1 > len(msg) {
+			return nil
+		}
+
+		offset++
+		labels = append(labels, strings.ToLower(string(msg[offset:offset+labelLen])))
+		offset += labelLen
+	}
+}
+
+// DNSIsResponse reports whether a DNS message is a response (QR bit set)
+func DNSIsResponse(msg []byte) bool {
+	return len(msg) >= 12 && msg[2]&0x80 != 0
+}
+
+// skipDNSName returns the offset after the (possibly compressed) name at
+// offset, or -1 if it is truncated or malformed
+func skipDNSName(msg []byte, offset int) int {
+	for offset < len(msg) {
+		labelLen := int(msg[offset])
+		switch {
+		case labelLen == 0:
+			return offset + 1
+		case labelLen&0xC0 == 0xC0:
+			if offset+2 > len(msg) {
+				return -1
+			}
+			return offset + 2
+		case labelLen > 63:
+			return -1
+		}
+		offset += labelLen + 1
+	}
+	return -1
+}
+
+// DNSAnswerAddrs returns the IPv4 addresses (A records) in the answer section
+// of a DNS response, as far as it was captured
+func DNSAnswerAddrs(msg []byte) []net.IP {
+	if len(msg) < 12 {
+		return nil
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:6]))
+	answers := int(binary.BigEndian.Uint16(msg[6:8]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		if offset = skipDNSName(msg, offset); offset < 0 || offset+4 > len(msg) {
+			return nil
+		}
+		offset += 4 // QTYPE, QCLASS
+	}
+
+	var addrs []net.IP
+	for i := 0; i < answers; i++ {
+		if offset = skipDNSName(msg, offset); offset < 0 || offset+10 > len(msg) {
+			break
+		}
+		rrType := binary.BigEndian.Uint16(msg[offset : offset+2])
+		rrClass := binary.BigEndian.Uint16(msg[offset+2 : offset+4])
+		length := int(binary.BigEndian.Uint16(msg[offset+8 : offset+10]))
+		offset += 10
+		if offset+length > len(msg) {
+			break
+		}
+		if rrType == 1 && rrClass == 1 && length == 4 {
+			addrs = append(addrs, net.IP(append([]byte(nil), msg[offset:offset+4]...)))
+		}
+		offset += length
+	}
+	return addrs
+}
+
+// Flags of an event_filter map entry, matching FILTER_* in the eBPF program
+const (
+	EventFilterDisabled    uint8 = 0x01
+	EventFilterControlOnly uint8 = 0x02 // TCP: only SYN, FIN and RST segments
+)
+
+// EncodeEventFilter returns the event_filter map value of every event type for
+// a capture configuration
+func EncodeEventFilter(config models.CaptureConfig) (map[uint8]uint8, error) {
+	enabled, err := ParseEventTypes(strings.Join(config.Events, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[uint8]uint8, len(models.EventTypeNames))
+	for t := range models.EventTypeNames {
+		values[t] = EventFilterDisabled
+	}
+	for _, t := range enabled {
+		values[t] = 0
+	}
+	if config.TCPControlOnly {
+		values[models.EVENT_TYPE_TCP] |= EventFilterControlOnly
+	}
+	return values, nil
+}
+
+// DecodeEventFilter is the inverse of EncodeEventFilter. Events are listed in
+// event type order.
+func DecodeEventFilter(values map[uint8]uint8) models.CaptureConfig {
+	config := models.CaptureConfig{Events: []string{}}
+	for t := uint8(models.EVENT_TYPE_ARP); t <= models.EVENT_TYPE_TLS; t++ {
+		if values[t]&EventFilterDisabled == 0 {
+			config.Events = append(config.Events, models.EventTypeNames[t])
+		}
+	}
+	config.TCPControlOnly = values[models.EVENT_TYPE_TCP]&EventFilterControlOnly != 0
+	return config
+}
+
+// PayloadBytesMax is the most L7 payload the side ring buffer records of each
+// event type hold, matching DNS_QUERY_MAX, HTTP_REQUEST_MAX and TLS_HELLO_MAX
+// in the eBPF program (less one, to keep the verifier's bound)
+var PayloadBytesMax = map[uint8]int{
+	models.EVENT_TYPE_DNS:  511,
+	models.EVENT_TYPE_HTTP: 1023,
+	models.EVENT_TYPE_TLS:  2047,
+}
+
+// dnsHeaderSize is the shortest DNS payload the eBPF program captures
+const dnsHeaderSize = 12
+
+// ParsePayloadBytes converts a comma-separated list of per event type capture
+// lengths (e.g. "dns=511,http=512") into a CaptureConfig.PayloadBytes map
+func ParsePayloadBytes(list string) (map[string]int, error) {
+	payload, err := parsePayloadList(list)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := EncodePayloadLimits(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// parsePayloadList parses a comma-separated list of type=bytes entries
+func parsePayloadList(list string) (map[string]int, error) {
+	payload := make(map[string]int)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid payload length %q, want type=bytes", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload length %q", entry)
+		}
+		payload[strings.ToUpper(strings.TrimSpace(name))] = n
+	}
+	return payload, nil
+}
+
+// EncodePayloadLimits returns the payload_limits map value of every event type
+// with a side ring buffer. Types missing from payload get 0, which captures as
+// much as the record holds.
+func EncodePayloadLimits(payload map[string]int) (map[uint8]uint16, error) {
+	values := make(map[uint8]uint16, len(PayloadBytesMax))
+	for t := range PayloadBytesMax {
+		values[t] = 0
+	}
+	for name, n := range payload {
+		var eventType uint8
+		for t := range PayloadBytesMax {
+			if strings.EqualFold(models.EventTypeNames[t], name) {
+				eventType = t
+			}
+		}
+		if eventType == 0 {
+			return nil, fmt.Errorf("payload length of %q cannot be set, only of DNS, HTTP and TLS", name)
+		}
+		min := 1
+		if eventType == models.EVENT_TYPE_DNS {
+			min = dnsHeaderSize
+		}
+		if n < min || n > PayloadBytesMax[eventType] {
+			return nil, fmt.Errorf("%s payload length %d out of range %d-%d", models.EventTypeNames[eventType], n, min, PayloadBytesMax[eventType])
+		}
+		values[eventType] = uint16(n)
+	}
+	return values, nil
+}
+
+// DecodePayloadLimits is the inverse of EncodePayloadLimits, with every event
+// type's effective length filled in
+func DecodePayloadLimits(values map[uint8]uint16) map[string]int {
+	payload := make(map[string]int, len(PayloadBytesMax))
+	for t, max := range PayloadBytesMax {
+		n := int(values[t])
+		if n == 0 || n > max {
+			n = max
+		}
+		payload[models.EventTypeNames[t]] = n
+	}
+	return payload
+}
+
+// EventPayloadMax is the most L7 payload a network_event carries, matching
+// L7_PAYLOAD_MAX in the eBPF program (less one, to keep the verifier's bound)
+const EventPayloadMax = 511
+
+// EventPayloadTypes are the event types whose network_event can carry L7
+// payload; ARP and ICMP events never do
+var EventPayloadTypes = []uint8{
+	models.EVENT_TYPE_TCP,
+	models.EVENT_TYPE_UDP,
+	models.EVENT_TYPE_DNS,
+	models.EVENT_TYPE_HTTP,
+	models.EVENT_TYPE_TLS,
+}
+
+// DefaultEventPayloadBytes is the L7 payload events carry when none is
+// configured: enough of DNS names, HTTP request lines and TLS handshakes for
+// classification, none for plain TCP and UDP
+var DefaultEventPayloadBytes = map[string]int{
+	"DNS":  64,
+	"HTTP": 192,
+	"TLS":  256,
+}
+
+// ParseEventPayloadBytes converts a comma-separated list of per event type
+// event payload lengths (e.g. "dns=64,http=192,tls=256") into a
+// CaptureConfig.EventPayloadBytes map
+func ParseEventPayloadBytes(list string) (map[string]int, error) {
+	payload, err := parsePayloadList(list)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := EncodeEventPayloadLimits(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// EncodeEventPayloadLimits returns the event_payload_limits map value of every
+// event type that can carry payload. A nil payload uses
+// DefaultEventPayloadBytes; types missing from it get 0, which sends none.
+func EncodeEventPayloadLimits(payload map[string]int) (map[uint8]uint16, error) {
+	if payload == nil {
+		payload = DefaultEventPayloadBytes
+	}
+	values := make(map[uint8]uint16, len(EventPayloadTypes))
+	for _, t := range EventPayloadTypes {
+		values[t] = 0
+	}
+	for name, n := range payload {
+		var eventType uint8
+		for _, t := range EventPayloadTypes {
+			if strings.EqualFold(models.EventTypeNames[t], name) {
+				eventType = t
+			}
+		}
+		if eventType == 0 {
+			return nil, fmt.Errorf("event payload length of %q cannot be set, only of TCP, UDP, DNS, HTTP and TLS", name)
+		}
+		if n < 0 || n > EventPayloadMax {
+			return nil, fmt.Errorf("%s event payload length %d out of range 0-%d", models.EventTypeNames[eventType], n, EventPayloadMax)
+		}
+		values[eventType] = uint16(n)
+	}
+	return values, nil
+}
+
+// DecodeEventPayloadLimits is the inverse of EncodeEventPayloadLimits, with
+// every event type that can carry payload listed
+func DecodeEventPayloadLimits(values map[uint8]uint16) map[string]int {
+	payload := make(map[string]int, len(EventPayloadTypes))
+	for _, t := range EventPayloadTypes {
+		payload[models.EventTypeNames[t]] = min(int(values[t]), EventPayloadMax)
+	}
+	return payload
+}
+
+// FlowConfig is the flow_config map value
+type FlowConfig struct {
+	Packets    uint32 // Packets per summary, 0 sends every packet as an event
+	IntervalMs uint32 // Most time a summary waits, 0 for no limit
+}
+
+// EncodeFlowConfig returns the flow_config map value of a capture
+// configuration
+func EncodeFlowConfig(config models.CaptureConfig) (FlowConfig, error) {
+	if config.FlowPackets < 0 || config.FlowPackets > 1<<20 {
+		return FlowConfig{}, fmt.Errorf("flow packets %d out of range 0-%d", config.FlowPackets, 1<<20)
+	}
+	if config.FlowIntervalMs < 0 || config.FlowIntervalMs > 3600000 {
+		return FlowConfig{}, fmt.Errorf("flow interval %dms out of range 0-3600000", config.FlowIntervalMs)
+	}
+	return FlowConfig{Packets: uint32(config.FlowPackets), IntervalMs: uint32(config.FlowIntervalMs)}, nil
+}
+
+// InterfaceCaptureMax is the capacity of the iface_capture map
+const InterfaceCaptureMax = 64
+
+// SampleMax is the largest sampling rate of an interface
+const SampleMax = 1 << 16
+
+// InterfaceCaptureValue is the iface_capture map value, keyed by ifindex. The
+// arrays are indexed by event type.
+type InterfaceCaptureValue struct {
+	EventFilter        [8]uint8
+	PayloadLimits      [8]uint16
+	EventPayloadLimits [8]uint16
+	Sample             uint32 // 0 or 1 sends every event
+}
+
+// InterfaceCaptureKey returns the iface_capture key of an interface
+func InterfaceCaptureKey(ifindex int) (uint32, error) {
+	if ifindex <= 0 {
+		return 0, fmt.Errorf("invalid ifindex %d", ifindex)
+	}
+	return uint32(ifindex), nil
+}
+
+// EncodeInterfaceCapture returns the iface_capture map value of an interface's
+// capture configuration, encoded like the global maps
+func EncodeInterfaceCapture(config models.InterfaceCapture) (InterfaceCaptureValue, error) {
+	var value InterfaceCaptureValue
+	filter, err := EncodeEventFilter(models.CaptureConfig{Events: config.Events, TCPControlOnly: config.TCPControlOnly})
+	if err != nil {
+		return value, err
+	}
+	limits, err := EncodePayloadLimits(config.PayloadBytes)
+	if err != nil {
+		return value, err
+	}
+	eventLimits, err := EncodeEventPayloadLimits(config.EventPayloadBytes)
+	if err != nil {
+		return value, err
+	}
+	if config.Sample < 0 || config.Sample > SampleMax {
+		return value, fmt.Errorf("sample %d out of range 0-%d", config.Sample, SampleMax)
+	}
+
+	for t, v := range filter {
+		value.EventFilter[t] = v
+	}
+	for t, v := range limits {
+		value.PayloadLimits[t] = v
+	}
+	for t, v := range eventLimits {
+		value.EventPayloadLimits[t] = v
+	}
+	value.Sample = uint32(config.Sample)
+	return value, nil
+}
+
+// DecodeInterfaceCapture is the inverse of EncodeInterfaceCapture, with every
+// event type's effective payload lengths filled in and a sample of 1 when
+// every event is sent
+func DecodeInterfaceCapture(value InterfaceCaptureValue) models.InterfaceCapture {
+	filter := make(map[uint8]uint8, len(models.EventTypeNames))
+	limits := make(map[uint8]uint16, len(PayloadBytesMax))
+	eventLimits := make(map[uint8]uint16, len(EventPayloadTypes))
+	for t := range models.EventTypeNames {
+		filter[t] = value.EventFilter[t]
+		limits[t] = value.PayloadLimits[t]
+		eventLimits[t] = value.EventPayloadLimits[t]
+	}
+	decoded := DecodeEventFilter(filter)
+	return models.InterfaceCapture{
+		Events:            decoded.Events,
+		TCPControlOnly:    decoded.TCPControlOnly,
+		PayloadBytes:      DecodePayloadLimits(limits),
+		EventPayloadBytes: DecodeEventPayloadLimits(eventLimits),
+		Sample:            int(max(value.Sample, 1)),
+	}
+}
+
+// SubnetFilterMax is the capacity of the subnet_filter map
+const SubnetFilterMax = 64
+
+// SubnetFilterKey is a subnet_filter LPM trie key
+type SubnetFilterKey struct {
+	PrefixLen uint32
+	Addr      [4]byte // Network byte order
+}
+
+// EncodeSubnetFilter converts IPv4 CIDRs to subnet_filter keys
+func EncodeSubnetFilter(subnets []string) ([]SubnetFilterKey, error) {
+	if len(subnets) > SubnetFilterMax {
+		return nil, fmt.Errorf("at most %d subnets can be filtered", SubnetFilterMax)
+	}
+
+	keys := make([]SubnetFilterKey, 0, len(subnets))
+	for _, subnet := range subnets {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(subnet))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q", subnet)
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("subnet %q is not IPv4", subnet)
+		}
+		ones, _ := ipNet.Mask.Size()
+		key := SubnetFilterKey{PrefixLen: uint32(ones)}
+		copy(key.Addr[:], ip)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// DecodeSubnetFilter is the inverse of EncodeSubnetFilter, sorted
+func DecodeSubnetFilter(keys []SubnetFilterKey) []string {
+	subnets := make([]string, 0, len(keys))
+	for _, key := range keys {
+		subnets = append(subnets, fmt.Sprintf("%s/%d", net.IP(key.Addr[:]), key.PrefixLen))
+	}
+	sort.Strings(subnets)
+	return subnets
+}
+
+// IgnoreMax is the capacity of the ignore_macs and ignore_cidrs maps, and
+// so of the ignore list, and the number of ignore_hits counters
+const IgnoreMax = 256
+
+// Scopes of an ignore_macs or ignore_cidrs entry
+const (
+	IgnoreScopeAll       = 0 // Drop every event
+	IgnoreScopeDataPlane = 1 // Let ARP and DNS events through
+)
+
+// IgnoreMACKey is an ignore_macs map key
+type IgnoreMACKey struct {
+	MAC [6]byte
+	Pad [2]byte
+}
+
+// IgnoreValue is the value of ignore_macs and ignore_cidrs entries
+type IgnoreValue struct {
+	Slot  uint32 // ignore_hits counter of the entry
+	Scope uint8
+	Pad   [3]byte
+}
+
+// EncodeIgnoreMAC returns the ignore_macs key of a MAC address
+func EncodeIgnoreMAC(mac string) (IgnoreMACKey, error) {
+	hw, err := net.ParseMAC(strings.TrimSpace(mac))
+	if err != nil || len(hw) != 6 {
+		return IgnoreMACKey{}, fmt.Errorf("invalid MAC address %q", mac)
+	}
+	var key IgnoreMACKey
+	copy(key.MAC[:], hw)
+	return key, nil
+}
+
+// EncodeIgnoreCIDR returns the ignore_cidrs key of an IPv4 CIDR
+func EncodeIgnoreCIDR(cidr string) (SubnetFilterKey, error) {
+	keys, err := EncodeSubnetFilter([]string{cidr})
+	if err != nil {
+		return SubnetFilterKey{}, err
+	}
+	return keys[0], nil
+}
+
+// EncodeIgnoreScope returns the scope value of an ignore list scope
+func EncodeIgnoreScope(scope string) (uint8, error) {
+	switch scope {
+	case models.IgnoreScopeAll, "":
+		return IgnoreScopeAll, nil
+	case models.IgnoreScopeDataPlane:
+		return IgnoreScopeDataPlane, nil
+	}
+	return 0, fmt.Errorf("invalid scope %q, want %s or %s", scope, models.IgnoreScopeAll, models.IgnoreScopeDataPlane)
+}
+
+// IPFromBEUint32 converts an IPv4 address held as a big-endian uint32, as
+// parsed from events, to a net.IP
+func IPFromBEUint32(i uint32) net.IP {
 	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, i)
+	binary.BigEndian.PutUint32(b, i)
 	return net.IP(b)
 }
 
@@ -108,6 +812,16 @@ func MacToString(mac [6]byte) string {
 		mac[0], mac[1], mac[2], mac[3], mac[4], mac[5])
 }
 
+// IsRandomizedMAC reports whether a MAC has the locally administered bit set,
+// as used by privacy-randomized addresses on phones and laptops
+func IsRandomizedMAC(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) == 0 {
+		return false
+	}
+	return hw[0]&0x02 != 0
+}
+
 // IfIndexToName converts an interface index to its name (e.g., "eth0")
 func IfIndexToName(ifindex uint32) string {
 	iface, err := net.InterfaceByIndex(int(ifindex))
@@ -118,7 +832,7 @@ func IfIndexToName(ifindex uint32) string {
 }
 
 // InspectDNS extracts domain name from DNS query/response payload
-func InspectDNS(payload [32]byte) string {
+func InspectDNS(payload []byte) string {
 	// Simple DNS query name extraction
 	// DNS query format: [transaction_id(2)][flags(2)][questions(2)][answers(2)][authority(2)][additional(2)][query...]
 	if len(payload) < 13 {
@@ -151,7 +865,7 @@ func InspectDNS(payload [32]byte) string {
 }
 
 // InspectHTTP extracts HTTP method and path from payload
-func InspectHTTP(payload [32]byte) (method string, path string) {
+func InspectHTTP(payload []byte) (method string, path string) {
 	str := string(payload[:])
 
 	// Check for HTTP methods
@@ -191,7 +905,7 @@ func InspectHTTP(payload [32]byte) (method string, path string) {
 }
 
 // InspectTLS extracts SNI from TLS Client Hello
-func InspectTLS(payload [32]byte) string {
+func InspectTLS(payload []byte) string {
 	// TLS Client Hello starts with: 0x16 (handshake), 0x03 0x01/0x03 (version)
 	if len(payload) < 5 {
 		return ""
@@ -202,11 +916,21 @@ func InspectTLS(payload [32]byte) string {
 	}
 
 	// Simple SNI extraction would require parsing the full TLS handshake
-	// TODO: Full SNI parsing requires more than 32 bytes typically
+	// TODO: Full SNI parsing needs more of the handshake than events usually carry
 
 	return "TLS"
 }
 
+// TLSClientVersion returns the client_version offered in a TLS Client Hello,
+// or 0 if the payload is not a Client Hello
+func TLSClientVersion(payload []byte) uint16 {
+	// Record header (5 bytes), handshake type (1), length (3), client_version (2)
+	if len(payload) < 11 || payload[0] != 0x16 || payload[5] != 0x01 {
+		return 0
+	}
+	return uint16(payload[9])<<8 | uint16(payload[10])
+}
+
 // GetL7Info extracts layer 7 information based on event type and payload
 func GetL7Info(evt *models.NetworkEvent) string {
 	switch evt.EventType {
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+func TestParseEventTypes(t *testing.T) {
+	all := len(models.EventTypeNames)
+	tests := []struct {
+		list    string
+		want    []uint8 // nil when every type is selected
+		wantErr bool
+	}{
+		{list: "", want: nil},
+		{list: "all", want: nil},
+		{list: " ALL ", want: nil},
+		{list: "arp,dns", want: []uint8{models.EVENT_TYPE_ARP, models.EVENT_TYPE_DNS}},
+		{list: " tcp , , udp ", want: []uint8{models.EVENT_TYPE_TCP, models.EVENT_TYPE_UDP}},
+		{list: ",", wantErr: true},
+		{list: " ", wantErr: true},
+		{list: ", ,", wantErr: true},
+		{list: "arp,smtp", wantErr: true},
+	}
+	for _, tt := range tests {
+		types, err := ParseEventTypes(tt.list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseEventTypes(%q) = %v, want an error", tt.list, types)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseEventTypes(%q): %v", tt.list, err)
+			continue
+		}
+		if tt.want == nil {
+			if len(types) != all {
+				t.Errorf("ParseEventTypes(%q) selected %d types, want all %d", tt.list, len(types), all)
+			}
+			continue
+		}
+		if !slices.Equal(types, tt.want) {
+			t.Errorf("ParseEventTypes(%q) = %v, want %v", tt.list, types, tt.want)
+		}
+	}
+}
+
+func TestEventFilterRoundTrip(t *testing.T) {
+	configs := []models.CaptureConfig{
+		{Events: []string{"arp", "tcp", "udp", "icmp", "dns", "http", "tls"}},
+		{Events: []string{"arp", "dns", "tcp"}, TCPControlOnly: true},
+		{Events: []string{"dns"}},
+		{Events: []string{"tls", "arp"}, TCPControlOnly: true},
+	}
+	for _, config := range configs {
+		values, err := EncodeEventFilter(config)
+		if err != nil {
+			t.Fatalf("EncodeEventFilter(%+v): %v", config, err)
+		}
+		if len(values) != len(models.EventTypeNames) {
+			t.Errorf("EncodeEventFilter(%+v) wrote %d entries, want one per event type", config, len(values))
+		}
+
+		got := DecodeEventFilter(values)
+		// Decoded in event type order, by display name
+		var want []string
+		for _, name := range config.Events {
+			want = append(want, models.EventTypeNames[eventTypeByName(t, name)])
+		}
+		slices.SortFunc(want, func(a, b string) int {
+			return int(eventTypeByName(t, a)) - int(eventTypeByName(t, b))
+		})
+		if !slices.Equal(got.Events, want) || got.TCPControlOnly != config.TCPControlOnly {
+			t.Errorf("round trip of %+v = %+v", config, got)
+		}
+	}
+
+	// Control-only TCP keeps TCP enabled; a disabled type drops the rest
+	values, _ := EncodeEventFilter(models.CaptureConfig{Events: []string{"tcp"}, TCPControlOnly: true})
+	if values[models.EVENT_TYPE_TCP] != EventFilterControlOnly || values[models.EVENT_TYPE_UDP] != EventFilterDisabled {
+		t.Errorf("event filter values %v", values)
+	}
+
+	if _, err := EncodeEventFilter(models.CaptureConfig{Events: []string{"smtp"}}); err == nil {
+		t.Error("EncodeEventFilter accepted an unknown event type")
+	}
+}
+
+func eventTypeByName(t *testing.T, name string) uint8 {
+	t.Helper()
+	for eventType, n := range models.EventTypeNames {
+		if strings.EqualFold(n, name) {
+			return eventType
+		}
+	}
+	t.Fatalf("unknown event type %q", name)
+	return 0
+}
+
+// The iface_capture key is the ifindex and the value has the layout of struct
+// iface_capture; settings read back decode as they were written
+func TestInterfaceCaptureEncoding(t *testing.T) {
+	for _, ifindex := range []int{0, -1} {
+		if _, err := InterfaceCaptureKey(ifindex); err == nil {
+			t.Errorf("InterfaceCaptureKey(%d) succeeded", ifindex)
+		}
+	}
+	if key, err := InterfaceCaptureKey(3); err != nil || key != 3 {
+		t.Errorf("InterfaceCaptureKey(3) = %d, %v", key, err)
+	}
+	if size := binary.Size(InterfaceCaptureValue{}); size != 44 {
+		t.Errorf("value of %d bytes, want the 44 of struct iface_capture", size)
+	}
+
+	config := models.InterfaceCapture{
+		Events:            []string{"dns", "tcp"},
+		TCPControlOnly:    true,
+		PayloadBytes:      map[string]int{"DNS": 64},
+		EventPayloadBytes: map[string]int{"TCP": 16},
+		Sample:            8,
+	}
+	value, err := EncodeInterfaceCapture(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.EventFilter[models.EVENT_TYPE_TCP] != EventFilterControlOnly || value.EventFilter[models.EVENT_TYPE_UDP] != EventFilterDisabled ||
+		value.EventFilter[models.EVENT_TYPE_DNS] != 0 {
+		t.Errorf("event filter %v", value.EventFilter)
+	}
+	if value.PayloadLimits[models.EVENT_TYPE_DNS] != 64 || value.EventPayloadLimits[models.EVENT_TYPE_TCP] != 16 || value.Sample != 8 {
+		t.Errorf("value %+v", value)
+	}
+
+	decoded := DecodeInterfaceCapture(value)
+	if !slices.Equal(decoded.Events, []string{"TCP", "DNS"}) || !decoded.TCPControlOnly || decoded.Sample != 8 ||
+		decoded.PayloadBytes["DNS"] != 64 || decoded.EventPayloadBytes["TCP"] != 16 {
+		t.Errorf("decoded %+v", decoded)
+	}
+	if sample := DecodeInterfaceCapture(InterfaceCaptureValue{}).Sample; sample != 1 {
+		t.Errorf("unsampled value decodes to sample %d, want 1", sample)
+	}
+
+	for _, bad := range []models.InterfaceCapture{
+		{Sample: -1},
+		{Sample: SampleMax + 1},
+		{Events: []string{"smtp"}},
+		{PayloadBytes: map[string]int{"DNS": 1 << 20}},
+	} {
+		if _, err := EncodeInterfaceCapture(bad); err == nil {
+			t.Errorf("EncodeInterfaceCapture(%+v) succeeded", bad)
+		}
+	}
+}
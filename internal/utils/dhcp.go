@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"encoding/hex"
+	"net"
+)
+
+// DHCP message layout (RFC 2131): a fixed 236-byte header, the magic cookie,
+// then options
+const (
+	dhcpHeaderSize  = 236
+	dhcpOptionsFrom = dhcpHeaderSize + 4
+
+	dhcpOptionPad         = 0
+	dhcpOptionMessageType = 53
+	dhcpOptionParamList   = 55 // Parameter request list
+	dhcpOptionRelayAgent  = 82 // Relay agent information, RFC 3046
+	dhcpOptionEnd         = 255
+
+	relayAgentCircuitID = 1
+	relayAgentRemoteID  = 2
+)
+
+var dhcpMagicCookie = [4]byte{99, 130, 83, 99}
+
+// DHCPMessage is what cerberus reads from a DHCP message
+type DHCPMessage struct {
+	Op        uint8  // 1 for client requests, 2 for server replies
+	Type      uint8  // DHCP message type (option 53), 0 if absent
+	ClientMAC string // chaddr
+	ClientIP  net.IP // ciaddr, unspecified if the client has none yet
+	YourIP    net.IP // yiaddr, the address the server assigns
+	RelayIP   net.IP // giaddr, unspecified unless relayed
+	ParamList []byte // Options requested by the client (option 55), in its order, nil if absent
+	CircuitID []byte // Relay agent circuit ID (option 82 sub-option 1), nil if absent
+	RemoteID  []byte // Relay agent remote ID (option 82 sub-option 2), nil if absent
+}
+
+// Relayed reports whether a relay agent added its information to the message
+func (m *DHCPMessage) Relayed() bool {
+	return m.CircuitID != nil || m.RemoteID != nil
+}
+
+// ParseDHCP reads a DHCP message of an Ethernet client from the start of a
+// UDP payload, or returns nil. Options cut off by the capture length are
+// skipped; those before them are kept.
+func ParseDHCP(payload []byte) *DHCPMessage {
+	if len(payload) < dhcpOptionsFrom || [4]byte(payload[dhcpHeaderSize:dhcpOptionsFrom]) != dhcpMagicCookie {
+		return nil
+	}
+	op, htype, hlen := payload[0], payload[1], payload[2]
+	if (op != 1 && op != 2) || htype != 1 || hlen != 6 {
+		return nil
+	}
+
+	msg := &DHCPMessage{
+		Op:        op,
+		ClientMAC: MacToString([6]byte(payload[28:34])),
+		ClientIP:  net.IP(payload[12:16]),
+		YourIP:    net.IP(payload[16:20]),
+		RelayIP:   net.IP(payload[24:28]),
+	}
+	for offset := dhcpOptionsFrom; offset < len(payload); {
+		code := payload[offset]
+		if code == dhcpOptionEnd {
+			break
+		}
+		if code == dhcpOptionPad {
+			offset++
+			continue
+		}
+		if offset+2 > len(payload) {
+			break
+		}
+		length := int(payload[offset+1])
+		value := payload[offset+2 : min(offset+2+length, len(payload))]
+		if len(value) < length {
+			break
+		}
+		switch code {
+		case dhcpOptionMessageType:
+			if length == 1 {
+				msg.Type = value[0]
+			}
+		case dhcpOptionParamList:
+			msg.ParamList = append([]byte{}, value...)
+		case dhcpOptionRelayAgent:
+			msg.CircuitID, msg.RemoteID = parseRelayAgentInfo(value)
+		}
+		offset += 2 + length
+	}
+	return msg
+}
+
+// parseRelayAgentInfo returns the circuit and remote IDs of the sub-options
+// of a relay agent information option
+func parseRelayAgentInfo(option []byte) (circuitID, remoteID []byte) {
+	for offset := 0; offset+2 <= len(option); {
+		code, length := option[offset], int(option[offset+1])
+		if offset+2+length > len(option) {
+			break
+		}
+		value := option[offset+2 : offset+2+length]
+		switch code {
+		case relayAgentCircuitID:
+			circuitID = append([]byte{}, value...)
+		case relayAgentRemoteID:
+			remoteID = append([]byte{}, value...)
+		}
+		offset += 2 + length
+	}
+	return circuitID, remoteID
+}
+
+// RenderAgentID renders a relay agent circuit or remote ID: as text when it
+// is printable ASCII, as most switches send port names like "Gi1/0/7", and
+// as hex otherwise, such as the VLAN/module/port encodings of others
+func RenderAgentID(id []byte) string {
+	if len(id) == 0 {
+		return ""
+	}
+	for _, b := range id {
+		if b < 0x20 || b > 0x7e {
+			return hex.EncodeToString(id)
+		}
+	}
+	return string(id)
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dhcpPayload reads a captured UDP payload from testdata/dhcp/<name>.hex:
+// hex lines after "#" comments describing it
+func dhcpPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "dhcp", name+".hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var digits strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); !strings.HasPrefix(line, "#") {
+			digits.WriteString(line)
+		}
+	}
+	payload, err := hex.DecodeString(digits.String())
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return payload
+}
+
+func TestParseDHCP(t *testing.T) {
+	tests := []struct {
+		name      string
+		op, typ   uint8
+		clientMAC string
+		yourIP    string
+		relayIP   string
+		paramList []byte
+		circuitID string // Rendered
+		remoteID  string // Rendered
+	}{
+		{"relayed_request_ascii", 1, 3, "00:03:93:aa:00:01", "0.0.0.0", "192.168.20.1", []byte{1, 3, 6, 15, 119, 252}, "Gi1/0/7", "sw-floor2"},
+		{"relayed_ack_binary", 2, 5, "00:03:93:aa:00:01", "192.168.20.5", "192.168.20.1", nil, "000400140107", "0006001b54c21080"},
+		{"discover_direct", 1, 1, "02:00:00:cc:00:03", "0.0.0.0", "0.0.0.0", []byte{1, 3, 6, 15}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := ParseDHCP(dhcpPayload(t, tt.name))
+			if msg == nil {
+				t.Fatal("not parsed")
+			}
+			if msg.Op != tt.op || msg.Type != tt.typ || msg.ClientMAC != tt.clientMAC {
+				t.Errorf("op %d type %d client %s, want %d %d %s", msg.Op, msg.Type, msg.ClientMAC, tt.op, tt.typ, tt.clientMAC)
+			}
+			if !msg.ClientIP.Equal(net.IPv4zero) || !msg.YourIP.Equal(net.ParseIP(tt.yourIP)) || !msg.RelayIP.Equal(net.ParseIP(tt.relayIP)) {
+				t.Errorf("ciaddr %s yiaddr %s giaddr %s, want 0.0.0.0 %s %s", msg.ClientIP, msg.YourIP, msg.RelayIP, tt.yourIP, tt.relayIP)
+			}
+			if !bytes.Equal(msg.ParamList, tt.paramList) {
+				t.Errorf("parameter request list %v, want %v", msg.ParamList, tt.paramList)
+			}
+			if msg.Relayed() != (tt.circuitID != "") {
+				t.Errorf("Relayed() = %v", msg.Relayed())
+			}
+			if got := RenderAgentID(msg.CircuitID); got != tt.circuitID {
+				t.Errorf("circuit ID %q, want %q", got, tt.circuitID)
+			}
+			if got := RenderAgentID(msg.RemoteID); got != tt.remoteID {
+				t.Errorf("remote ID %q, want %q", got, tt.remoteID)
+			}
+		})
+	}
+}
+
+// A payload cut by the capture length keeps the header and the options
+// before the cut; an option 82 cut short is dropped whole, never half read
+func TestParseDHCPTruncated(t *testing.T) {
+	payload := dhcpPayload(t, "relayed_request_ascii")
+	for n := range len(payload) {
+		msg := ParseDHCP(payload[:n])
+		if n < dhcpOptionsFrom {
+			if msg != nil {
+				t.Errorf("%d bytes parsed without the magic cookie", n)
+			}
+			continue
+		}
+		if msg == nil || msg.ClientMAC != "00:03:93:aa:00:01" {
+			t.Fatalf("%d bytes: %+v, want the header", n, msg)
+		}
+		if want := uint8(3); n >= dhcpOptionsFrom+3 && msg.Type != want {
+			t.Errorf("%d bytes: type %d, want %d", n, msg.Type, want)
+		}
+		circuit, remote := string(msg.CircuitID), string(msg.RemoteID)
+		if (circuit != "" || remote != "") && (circuit != "Gi1/0/7" || remote != "sw-floor2") {
+			t.Errorf("%d bytes: circuit %q remote %q, partly read", n, circuit, remote)
+		}
+	}
+	// Without the end option, as when it falls past the capture length
+	if msg := ParseDHCP(payload[:len(payload)-1]); string(msg.CircuitID) != "Gi1/0/7" {
+		t.Errorf("without the end option: circuit %q", msg.CircuitID)
+	}
+}
+
+// Payloads that are not DHCP from an Ethernet client are not parsed
+func TestParseDHCPRejects(t *testing.T) {
+	valid := dhcpPayload(t, "discover_direct")
+	tests := map[string]func(p []byte){
+		"bad cookie":     func(p []byte) { p[dhcpHeaderSize] = 0 },
+		"bad op":         func(p []byte) { p[0] = 3 },
+		"not Ethernet":   func(p []byte) { p[1] = 6 },
+		"address length": func(p []byte) { p[2] = 8 },
+	}
+	for name, corrupt := range tests {
+		p := bytes.Clone(valid)
+		corrupt(p)
+		if msg := ParseDHCP(p); msg != nil {
+			t.Errorf("%s: parsed %+v", name, msg)
+		}
+	}
+	if msg := ParseDHCP(nil); msg != nil {
+		t.Errorf("empty payload parsed %+v", msg)
+	}
+}
+
+func TestParseRelayAgentInfo(t *testing.T) {
+	tests := []struct {
+		name                string
+		option              []byte
+		circuit, remote     string
+		noCircuit, noRemote bool
+	}{
+		{"both", []byte{1, 2, 'a', 'b', 2, 1, 'c'}, "ab", "c", false, false},
+		{"remote first", []byte{2, 1, 'c', 1, 2, 'a', 'b'}, "ab", "c", false, false},
+		{"circuit only", []byte{1, 3, 'x', 'y', 'z'}, "xyz", "", false, true},
+		{"unknown sub-options", []byte{5, 4, 10, 0, 0, 0, 1, 1, 'p', 151, 0}, "p", "", false, true},
+		{"empty circuit", []byte{1, 0, 2, 1, 'r'}, "", "r", false, false},
+		{"sub-option overruns", []byte{1, 1, 'p', 2, 9, 'r'}, "p", "", false, true},
+		{"empty", nil, "", "", true, true},
+	}
+	for _, tt := range tests {
+		circuit, remote := parseRelayAgentInfo(tt.option)
+		if string(circuit) != tt.circuit || (circuit == nil) != tt.noCircuit {
+			t.Errorf("%s: circuit %q (nil %v), want %q", tt.name, circuit, circuit == nil, tt.circuit)
+		}
+		if string(remote) != tt.remote || (remote == nil) != tt.noRemote {
+			t.Errorf("%s: remote %q (nil %v), want %q", tt.name, remote, remote == nil, tt.remote)
+		}
+	}
+}
+
+func TestRenderAgentID(t *testing.T) {
+	tests := []struct {
+		id   []byte
+		want string
+	}{
+		{nil, ""},
+		{[]byte("Gi1/0/7"), "Gi1/0/7"},
+		{[]byte("eth0:vlan 20"), "eth0:vlan 20"},
+		{[]byte{0x00, 0x04, 0x00, 0x14, 0x01, 0x07}, "000400140107"},
+		{[]byte("port\t7"), "706f72740937"},
+		{[]byte("caf\xc3\xa9"), "636166c3a9"},
+	}
+	for _, tt := range tests {
+		if got := RenderAgentID(tt.id); got != tt.want {
+			t.Errorf("RenderAgentID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// TLS extensions that feed the JA3 curve and point-format fields
+const (
+	tlsExtSupportedGroups = 10
+	tlsExtECPointFormats  = 11
+)
+
+// isGREASE reports whether v is a GREASE value (RFC 8701), which JA3 ignores
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// helloReader walks a ClientHello, noting when the data runs out
+type helloReader struct {
+	data      []byte
+	pos       int
+	truncated bool
+}
+
+func (r *helloReader) bytes(n int) []byte {
+	if r.truncated || r.pos+n > len(r.data) {
+		r.truncated = true
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *helloReader) u8() int {
+	if b := r.bytes(1); b != nil {
+		return int(b[0])
+	}
+	return 0
+}
+
+func (r *helloReader) u16() int {
+	if b := r.bytes(2); b != nil {
+		return int(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+// joinU16 renders big-endian uint16 values as dash-separated decimals, skipping GREASE
+func joinU16(data []byte) string {
+	var parts []string
+	for i := 0; i+1 < len(data); i += 2 {
+		v := binary.BigEndian.Uint16(data[i:])
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// ComputeJA3 fingerprints a TLS record holding a ClientHello. It returns nil if
+// the record is not a ClientHello. A hello cut short by the capture length is
+// returned as Partial, without a hash, holding the fields parsed so far.
+func ComputeJA3(record []byte) *models.JA3 {
+	// Record header (5) + handshake header (4)
+	if len(record) < 9 || record[0] != 0x16 || record[5] != 0x01 {
+		return nil
+	}
+
+	// Bound parsing by the handshake length so trailing bytes are ignored
+	helloLen := int(record[6])<<16 | int(record[7])<<8 | int(record[8])
+	body := record[9:]
+	truncated := len(body) < helloLen
+	if !truncated {
+		body = body[:helloLen]
+	}
+
+	r := &helloReader{data: body}
+	var fields []string
+
+	version := r.u16()
+	r.bytes(32)     // Random
+	r.bytes(r.u8()) // Session ID
+	ciphers := r.bytes(r.u16())
+	r.bytes(r.u8()) // Compression methods
+
+	if !r.truncated {
+		fields = append(fields, strconv.Itoa(version), joinU16(ciphers))
+	} else if version != 0 {
+		fields = append(fields, strconv.Itoa(version))
+	}
+
+	var extensions []string
+	var curves, pointFormats string
+
+	if !r.truncated && r.pos < len(r.data) {
+		extEnd := r.pos + r.u16()
+		for !r.truncated && r.pos < extEnd {
+			extType := uint16(r.u16())
+			extData := r.bytes(r.u16())
+			if r.truncated {
+				break
+			}
+			if isGREASE(extType) {
+				continue
+			}
+			extensions = append(extensions, strconv.Itoa(int(extType)))
+
+			switch extType {
+			case tlsExtSupportedGroups:
+				if len(extData) >= 2 {
+					curves = joinU16(extData[2:])
+				}
+			case tlsExtECPointFormats:
+				if len(extData) >= 1 {
+					var formats []string
+					for _, f := range extData[1:] {
+						formats = append(formats, strconv.Itoa(int(f)))
+					}
+					pointFormats = strings.Join(formats, "-")
+				}
+			}
+		}
+		if extEnd > len(r.data) {
+			r.truncated = true
+		}
+	}
+
+	if r.truncated || truncated {
+		if len(fields) == 2 {
+			fields = append(fields, strings.Join(extensions, "-"))
+		}
+		return &models.JA3{String: strings.Join(fields, ","), Partial: true}
+	}
+
+	fields = append(fields, strings.Join(extensions, "-"), curves, pointFormats)
+	ja3 := strings.Join(fields, ",")
+	sum := md5.Sum([]byte(ja3))
+
+	return &models.JA3{Hash: hex.EncodeToString(sum[:]), String: ja3}
+}
@@ -0,0 +1,164 @@
+// Package orderedset provides a bounded set that remembers the order its
+// values were last added in. It depends on nothing in cerberus, so models can
+// hold one.
+package orderedset
+
+import "encoding/json"
+
+// Set is a set of at most a number of values, ordered from the least to the
+// most recently added; adding a value already held makes it the most recent,
+// and adding one to a full set drops the least recent. Membership is a map
+// lookup and the order a ring of slots linked by index, so adding a value
+// takes constant time whatever the limit.
+//
+// The zero value is an empty set without limit. A Set must not be copied
+// once used: the copy would share its map; use Clone.
+type Set[T comparable] struct {
+	limit int
+	index map[T]int // Value -> its slot
+	slots []slot[T]
+	head  int // Slot of the least recent value
+	tail  int // Slot of the most recent value
+}
+
+type slot[T comparable] struct {
+	value      T
+	prev, next int
+}
+
+// New returns an empty set of at most limit values, no limit when limit is 0
+// or less
+func New[T comparable](limit int) Set[T] {
+	var s Set[T]
+	s.SetLimit(limit)
+	return s
+}
+
+// Len returns the number of values held
+func (s *Set[T]) Len() int {
+	return len(s.index)
+}
+
+// Limit returns the most values the set holds, 0 when unlimited
+func (s *Set[T]) Limit() int {
+	return s.limit
+}
+
+// SetLimit changes the most values the set holds, dropping the least recent
+// ones beyond it. A limit of 0 or less removes the limit.
+func (s *Set[T]) SetLimit(limit int) {
+	s.limit = max(limit, 0)
+	if s.limit > 0 && len(s.index) > s.limit {
+		values := s.Values()
+		s.reset(values[len(values)-s.limit:])
+	}
+}
+
+// Contains reports whether the set holds a value
+func (s *Set[T]) Contains(value T) bool {
+	_, ok := s.index[value]
+	return ok
+}
+
+// Add makes a value the most recent of the set, adding it when not held
+func (s *Set[T]) Add(value T) {
+	if i, ok := s.index[value]; ok {
+		if i != s.tail {
+			s.unlink(i)
+			s.append(i)
+		}
+		return
+	}
+	if s.index == nil {
+		s.index = make(map[T]int)
+	}
+
+	var i int
+	if s.limit > 0 && len(s.index) >= s.limit {
+		// Full: the slot of the least recent value takes the new one
+		i = s.head
+		delete(s.index, s.slots[i].value)
+		s.unlink(i)
+		s.slots[i].value = value
+	} else {
+		i = len(s.slots)
+		s.slots = append(s.slots, slot[T]{value: value})
+	}
+	s.index[value] = i
+	s.append(i)
+}
+
+// Values returns the values held, from the least to the most recent
+func (s *Set[T]) Values() []T {
+	values := make([]T, 0, len(s.index))
+	for i, n := s.head, 0; n < len(s.index); i, n = s.slots[i].next, n+1 {
+		values = append(values, s.slots[i].value)
+	}
+	return values
+}
+
+// Last returns the n most recent values, from the least to the most recent
+func (s *Set[T]) Last(n int) []T {
+	values := s.Values()
+	return values[len(values)-min(max(n, 0), len(values)):]
+}
+
+// Clone returns an independent copy of the set
+func (s *Set[T]) Clone() Set[T] {
+	clone := New[T](s.limit)
+	clone.reset(s.Values())
+	return clone
+}
+
+// MarshalJSON encodes the set as an array of its values, from the least to
+// the most recent
+func (s Set[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Values())
+}
+
+// UnmarshalJSON replaces the values of the set with those of an array, the
+// last being the most recent. The limit of the set is kept, so only the last
+// values up to it are.
+func (s *Set[T]) UnmarshalJSON(data []byte) error {
+	var values []T
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	s.reset(values)
+	return nil
+}
+
+// reset makes the set hold values, the last being the most recent
+func (s *Set[T]) reset(values []T) {
+	s.index, s.slots, s.head, s.tail = nil, nil, 0, 0
+	for _, value := range values {
+		s.Add(value)
+	}
+}
+
+// unlink takes a slot out of the order
+func (s *Set[T]) unlink(i int) {
+	prev, next := s.slots[i].prev, s.slots[i].next
+	if i == s.head {
+		s.head = next
+	} else {
+		s.slots[prev].next = next
+	}
+	if i == s.tail {
+		s.tail = prev
+	} else {
+		s.slots[next].prev = prev
+	}
+}
+
+// append links a slot as the most recent. The slot's value is already in the
+// index, so the set was empty when it holds one value.
+func (s *Set[T]) append(i int) {
+	if len(s.index) == 1 {
+		s.head, s.tail = i, i
+		return
+	}
+	s.slots[i].prev = s.tail
+	s.slots[s.tail].next = i
+	s.tail = i
+}
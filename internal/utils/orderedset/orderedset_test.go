@@ -0,0 +1,184 @@
+package orderedset
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// A full set drops its least recent value for each new one and never holds
+// more than its limit
+func TestCap(t *testing.T) {
+	s := New[string](3)
+	for i, value := range []string{"a", "b", "c", "d", "e"} {
+		s.Add(value)
+		if s.Len() > 3 {
+			t.Fatalf("after %d adds the set holds %d values", i+1, s.Len())
+		}
+	}
+	if got := s.Values(); !slices.Equal(got, []string{"c", "d", "e"}) {
+		t.Errorf("Values = %v, want [c d e]", got)
+	}
+	if s.Contains("a") || s.Contains("b") || !s.Contains("c") {
+		t.Errorf("dropped values still held, or kept ones not: %v", s.Values())
+	}
+
+	// Shrinking keeps the most recent; 0 removes the limit
+	s.SetLimit(2)
+	if got := s.Values(); !slices.Equal(got, []string{"d", "e"}) || s.Limit() != 2 {
+		t.Errorf("after SetLimit(2): %v, limit %d", got, s.Limit())
+	}
+	s.SetLimit(0)
+	for i := range 100 {
+		s.Add(fmt.Sprint(i))
+	}
+	if s.Len() != 102 || s.Limit() != 0 {
+		t.Errorf("unlimited set holds %d values, limit %d", s.Len(), s.Limit())
+	}
+
+	one := New[int](1)
+	one.Add(1)
+	one.Add(2)
+	if got := one.Values(); !slices.Equal(got, []int{2}) {
+		t.Errorf("set of one = %v, want [2]", got)
+	}
+}
+
+// Adding a value already held makes it the most recent without growing the
+// set, so it is dropped last
+func TestReinsert(t *testing.T) {
+	s := New[string](3)
+	for _, value := range []string{"a", "b", "c"} {
+		s.Add(value)
+	}
+	tests := []struct {
+		add  string
+		want []string
+	}{
+		{"a", []string{"b", "c", "a"}}, // The least recent
+		{"c", []string{"b", "a", "c"}}, // One in the middle
+		{"c", []string{"b", "a", "c"}}, // The most recent already
+		{"d", []string{"a", "c", "d"}}, // b is now the least recent, dropped
+	}
+	for _, tt := range tests {
+		s.Add(tt.add)
+		if got := s.Values(); !slices.Equal(got, tt.want) {
+			t.Errorf("after adding %s: %v, want %v", tt.add, got, tt.want)
+		}
+	}
+	if got := s.Last(2); !slices.Equal(got, []string{"c", "d"}) {
+		t.Errorf("Last(2) = %v", got)
+	}
+	if got := s.Last(10); !slices.Equal(got, []string{"a", "c", "d"}) {
+		t.Errorf("Last(10) = %v", got)
+	}
+}
+
+// Random adds to a small set give the values a slice kept in order would
+func TestAgainstSlice(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, limit := range []int{1, 2, 5, 20} {
+		s := New[int](limit)
+		var want []int
+		for range 2000 {
+			value := r.IntN(3 * limit)
+			if i := slices.Index(want, value); i >= 0 {
+				want = slices.Delete(want, i, i+1)
+			} else if len(want) == limit {
+				want = want[1:]
+			}
+			want = append(want, value)
+			s.Add(value)
+			if got := s.Values(); !slices.Equal(got, want) {
+				t.Fatalf("limit %d: Values = %v, want %v", limit, got, want)
+			}
+		}
+	}
+}
+
+// The set encodes as a JSON array from the least to the most recent, and
+// decodes back to the same order under its own limit
+func TestJSON(t *testing.T) {
+	s := New[string](3)
+	for _, value := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.1"} {
+		s.Add(value)
+	}
+	data, err := json.Marshal(struct {
+		Targets Set[string] `json:"targets"`
+	}{s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"targets":["10.0.0.2","10.0.0.3","10.0.0.1"]}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	var decoded struct {
+		Targets Set[string] `json:"targets"`
+	}
+	decoded.Targets = New[string](3)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Targets.Values(); !slices.Equal(got, s.Values()) || decoded.Targets.Limit() != 3 {
+		t.Errorf("round trip = %v, limit %d", got, decoded.Targets.Limit())
+	}
+	decoded.Targets.Add("10.0.0.4")
+	if got := decoded.Targets.Values(); !slices.Equal(got, []string{"10.0.0.3", "10.0.0.1", "10.0.0.4"}) {
+		t.Errorf("decoded set orders %v", got)
+	}
+
+	// Decoding more values than the limit keeps the last ones
+	small := New[string](2)
+	if err := json.Unmarshal([]byte(`["a","b","c"]`), &small); err != nil {
+		t.Fatal(err)
+	}
+	if got := small.Values(); !slices.Equal(got, []string{"b", "c"}) {
+		t.Errorf("decoded over the limit: %v", got)
+	}
+
+	var empty Set[string]
+	if data, err := json.Marshal(empty); err != nil || string(data) != "[]" {
+		t.Errorf("empty set encodes as %s, %v", data, err)
+	}
+	if err := json.Unmarshal([]byte(`{"not":"an array"}`), &empty); err == nil {
+		t.Error("decoded an object")
+	}
+}
+
+// A clone shares nothing with its original
+func TestClone(t *testing.T) {
+	s := New[string](3)
+	s.Add("a")
+	s.Add("b")
+	clone := s.Clone()
+	clone.Add("c")
+	s.Add("a")
+	if got := s.Values(); !slices.Equal(got, []string{"b", "a"}) {
+		t.Errorf("original = %v", got)
+	}
+	if got := clone.Values(); !slices.Equal(got, []string{"a", "b", "c"}) || clone.Limit() != 3 {
+		t.Errorf("clone = %v, limit %d", got, clone.Limit())
+	}
+}
+
+// BenchmarkAdd measures adding to a full set of 20: values it holds, as the
+// targets of a device mostly are, and new values dropping the least recent
+func BenchmarkAdd(b *testing.B) {
+	for _, distinct := range []int{20, 40} {
+		b.Run(fmt.Sprintf("distinct=%d", distinct), func(b *testing.B) {
+			s := New[string](20)
+			values := make([]string, distinct)
+			for i := range values {
+				values[i] = fmt.Sprintf("203.0.113.%d", i)
+				s.Add(values[i])
+			}
+			b.ResetTimer()
+			for i := range b.N {
+				s.Add(values[i%len(values)])
+			}
+		})
+	}
+}
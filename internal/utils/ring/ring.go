@@ -0,0 +1,44 @@
+// Package ring provides a fixed-size buffer of the values most recently
+// added. It depends on nothing in cerberus.
+package ring
+
+// Buffer holds the last values added, up to its size: adding to a full
+// buffer overwrites the oldest value, in constant time. It is not safe for
+// concurrent use.
+type Buffer[T any] struct {
+	slots []T
+	next  int // Slot the next value goes in
+	full  bool
+}
+
+// New returns an empty buffer of size values, at least one
+func New[T any](size int) *Buffer[T] {
+	return &Buffer[T]{slots: make([]T, max(size, 1))}
+}
+
+// Add adds a value, dropping the oldest when the buffer is full
+func (b *Buffer[T]) Add(value T) {
+	b.slots[b.next] = value
+	b.next++
+	if b.next == len(b.slots) {
+		b.next, b.full = 0, true
+	}
+}
+
+// Len returns the number of values held
+func (b *Buffer[T]) Len() int {
+	if b.full {
+		return len(b.slots)
+	}
+	return b.next
+}
+
+// Values returns the values held, oldest first
+func (b *Buffer[T]) Values() []T {
+	if !b.full {
+		return append([]T(nil), b.slots[:b.next]...)
+	}
+	values := make([]T, 0, len(b.slots))
+	values = append(values, b.slots[b.next:]...)
+	return append(values, b.slots[:b.next]...)
+}
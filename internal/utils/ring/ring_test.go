@@ -0,0 +1,42 @@
+package ring
+
+import (
+	"slices"
+	"testing"
+)
+
+// A buffer keeps the latest values, oldest first, through several
+// wraparounds
+func TestBuffer(t *testing.T) {
+	b := New[int](3)
+	if b.Len() != 0 || len(b.Values()) != 0 {
+		t.Fatalf("new buffer holds %v", b.Values())
+	}
+
+	var added []int
+	for i := range 10 {
+		b.Add(i)
+		added = append(added, i)
+		want := added[max(0, len(added)-3):]
+		if got := b.Values(); !slices.Equal(got, want) || b.Len() != len(want) {
+			t.Errorf("after adding %d: %v (len %d), want %v", i, got, b.Len(), want)
+		}
+	}
+
+	// Values are copied out
+	values := b.Values()
+	values[0] = -1
+	if b.Values()[0] == -1 {
+		t.Error("Values shares the buffer's storage")
+	}
+}
+
+// A size below one holds the last value
+func TestBufferMinimumSize(t *testing.T) {
+	b := New[string](0)
+	b.Add("a")
+	b.Add("b")
+	if got := b.Values(); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("Values = %v, want [b]", got)
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// IP protocol numbers the BPF program stores in events
+const (
+	protoICMP = 1
+	protoTCP  = 6
+	protoUDP  = 17
+)
+
+// tcpFlagsCaptured are the TCP flags the BPF program copies (FIN, SYN, RST,
+// PSH, ACK)
+const tcpFlagsCaptured = 0x1f
+
+// eventProtocols is the IP protocol each event type is built from
+var eventProtocols = map[uint8]uint8{
+	models.EVENT_TYPE_ARP:  0,
+	models.EVENT_TYPE_TCP:  protoTCP,
+	models.EVENT_TYPE_UDP:  protoUDP,
+	models.EVENT_TYPE_ICMP: protoICMP,
+	models.EVENT_TYPE_DNS:  protoUDP,
+	models.EVENT_TYPE_HTTP: protoTCP,
+	models.EVENT_TYPE_TLS:  protoTCP,
+}
+
+// ValidateNetworkEvent checks that a parsed event could have been built by
+// the BPF program. A misaligned or corrupt ring buffer record, or a record
+// from an object with another event layout, yields fields that contradict
+// each other; the error names the first contradiction found.
+func ValidateNetworkEvent(evt *models.NetworkEvent) error {
+	protocol, ok := eventProtocols[evt.EventType]
+	if !ok {
+		return fmt.Errorf("unknown event type %d", evt.EventType)
+	}
+	if evt.Protocol != protocol {
+		return fmt.Errorf("protocol %d in a %s event", evt.Protocol, models.EventTypeNames[evt.EventType])
+	}
+	if evt.IfIndex == 0 {
+		return fmt.Errorf("interface index 0")
+	}
+	// Ethernet source addresses are never multicast
+	if evt.SrcMac[0]&0x01 != 0 {
+		return fmt.Errorf("multicast source MAC %s", MacToString(evt.SrcMac))
+	}
+
+	hasPorts := protocol == protoTCP || protocol == protoUDP
+	if !hasPorts && (evt.SrcPort != 0 || evt.DstPort != 0) {
+		return fmt.Errorf("ports %d -> %d in a %s event", evt.SrcPort, evt.DstPort, models.EventTypeNames[evt.EventType])
+	}
+	if hasPorts && evt.DstPort == 0 {
+		return fmt.Errorf("destination port 0")
+	}
+	if evt.EventType == models.EVENT_TYPE_DNS && evt.SrcPort != 53 && evt.DstPort != 53 {
+		return fmt.Errorf("DNS event between ports %d and %d", evt.SrcPort, evt.DstPort)
+	}
+	if protocol != protoTCP && evt.TCPFlags != 0 {
+		return fmt.Errorf("TCP flags in a %s event", models.EventTypeNames[evt.EventType])
+	}
+	if evt.TCPFlags&^tcpFlagsCaptured != 0 {
+		return fmt.Errorf("uncaptured TCP flags %#02x", evt.TCPFlags)
+	}
+
+	if evt.EventType == models.EVENT_TYPE_ARP {
+		if evt.ArpOp != 1 && evt.ArpOp != 2 {
+			return fmt.Errorf("ARP operation %d", evt.ArpOp)
+		}
+		return nil
+	}
+	if evt.ArpOp != 0 {
+		return fmt.Errorf("ARP operation in a %s event", models.EventTypeNames[evt.EventType])
+	}
+
+	src, dst := IPFromBEUint32(evt.SrcIP), IPFromBEUint32(evt.DstIP)
+	// DHCP clients send from 0.0.0.0 before they have an address
+	if src.IsUnspecified() && protocol != protoUDP {
+		return fmt.Errorf("source IP 0.0.0.0")
+	}
+	if src.IsMulticast() || src.IsLoopback() || src.Equal(net.IPv4bcast) || reservedIPv4(src) {
+		return fmt.Errorf("impossible source IP %s", src)
+	}
+	if dst.IsUnspecified() || dst.IsLoopback() || (reservedIPv4(dst) && !dst.Equal(net.IPv4bcast)) {
+		return fmt.Errorf("impossible destination IP %s", dst)
+	}
+	return nil
+}
+
+// reservedIPv4 reports whether ip is in 240.0.0.0/4, which hosts never use
+// (the limited broadcast address included)
+func reservedIPv4(ip net.IP) bool {
+	ip4 := ip.To4()
+	return ip4 != nil && ip4[0] >= 240
+}
+
+// ValidateFlowSummaryEvent checks that a parsed flow summary could have been
+// built by the BPF program, as ValidateNetworkEvent does for events
+func ValidateFlowSummaryEvent(evt *models.FlowSummaryEvent) error {
+	if evt.Protocol != protoTCP {
+		return fmt.Errorf("protocol %d in a flow summary", evt.Protocol)
+	}
+	if evt.Reason < models.FlowSummaryPackets || evt.Reason > models.FlowSummaryIdle {
+		return fmt.Errorf("unknown flow summary reason %d", evt.Reason)
+	}
+	if evt.IfIndex == 0 {
+		return fmt.Errorf("interface index 0")
+	}
+	if evt.SrcMac[0]&0x01 != 0 {
+		return fmt.Errorf("multicast source MAC %s", MacToString(evt.SrcMac))
+	}
+	if evt.DstPort == 0 {
+		return fmt.Errorf("destination port 0")
+	}
+	if evt.Packets == 0 || evt.Bytes < uint64(evt.Packets)*minFrameBytes {
+		return fmt.Errorf("%d bytes in %d packets", evt.Bytes, evt.Packets)
+	}
+
+	src, dst := IPFromBEUint32(evt.SrcIP), IPFromBEUint32(evt.DstIP)
+	if src.IsUnspecified() || src.IsMulticast() || src.IsLoopback() || reservedIPv4(src) {
+		return fmt.Errorf("impossible source IP %s", src)
+	}
+	if dst.IsUnspecified() || dst.IsMulticast() || dst.IsLoopback() || reservedIPv4(dst) {
+		return fmt.Errorf("impossible destination IP %s", dst)
+	}
+	return nil
+}
+
+// minFrameBytes is the shortest frame carrying a TCP segment: Ethernet, IPv4
+// and TCP headers without options
+const minFrameBytes = 14 + 20 + 20
@@ -0,0 +1,49 @@
+// Package version describes the running build. Its variables are set at
+// link time, e.g. by make build:
+//
+//	go build -ldflags "-X github.com/zrougamed/cerberus/internal/version.Version=v1.2.0" ./cmd/cerberus
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// Set with -ldflags -X; builds without them report the defaults
+var (
+	Version   = "dev"
+	Commit    = "" // Falls back to the VCS revision Go embeds, if any
+	BuildDate = ""
+)
+
+// Info returns the build description. features lists the runtime settings
+// worth knowing about, e.g. the capture backend.
+func Info(features map[string]string) models.BuildInfo {
+	info := models.BuildInfo{
+		Version:     Version,
+		Commit:      Commit,
+		BuildDate:   BuildDate,
+		GoVersion:   runtime.Version(),
+		EventLayout: models.EventLayoutVersion,
+		Features:    features,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
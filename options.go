@@ -0,0 +1,155 @@
+package cerberus
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+// Option configures a Runner
+type Option func(*options)
+
+type options struct {
+	interfaces       []string
+	allInterfaces    bool
+	storageDir       string
+	strictStorage    bool
+	readOnly         bool
+	apiOnly          bool
+	reloadInterval   time.Duration
+	eventSocket      string
+	backend          Backend
+	captureConfig    models.CaptureConfig
+	interfaceCapture map[string]models.InterfaceCapture
+	apiAddr          string
+	logger           *log.Logger
+
+	apiSetup       []func(*api.Server)
+	monitorSetup   []func(*monitor.NetworkMonitor)       // Every monitor, including reloaded ones
+	captureSetup   []func(*monitor.NetworkMonitor) error // The monitor of a capturing runner
+	captureStarted []func() error
+	captureStopped []func()
+	closers        []func() // Run by Shutdown in reverse order, before the monitor closes
+}
+
+// WithInterfaces sets the interfaces the BPF backend attaches to. Without
+// interfaces it picks the recommended physical ones, see 'cerberus check'.
+func WithInterfaces(names ...string) Option {
+	return func(o *options) {
+		o.interfaces = append(o.interfaces, names...)
+	}
+}
+
+// WithAllInterfaces makes the BPF backend attach to every up, non-loopback
+// interface, including virtual and container ones
+func WithAllInterfaces() Option {
+	return func(o *options) {
+		o.allInterfaces = true
+	}
+}
+
+// WithStorage keeps the database in dir, creating it if needed. Without it,
+// or with an empty dir, devices and patterns are kept in memory only.
+func WithStorage(dir string) Option {
+	return func(o *options) {
+		o.storageDir = dir
+	}
+}
+
+// WithStrictStorage makes New fail on a corrupt database instead of moving it
+// aside and starting with an empty one
+func WithStrictStorage() Option {
+	return func(o *options) {
+		o.strictStorage = true
+	}
+}
+
+// WithReadOnlyStorage serves the database in the storage directory without
+// writing to it, such as a copy taken for post-incident analysis. The database
+// must exist. Run then serves the API only, without capture.
+func WithReadOnlyStorage() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithAPIOnly serves the database of a cerberus process capturing into the
+// same storage directory, without capturing: the database is reloaded when
+// it changed, at most every reload interval (default: DefaultReloadInterval),
+// and the streaming endpoints relay the events that process publishes on its
+// event socket. It implies WithReadOnlyStorage.
+func WithAPIOnly(reload time.Duration) Option {
+	return func(o *options) {
+		o.apiOnly = true
+		o.readOnly = true
+		o.reloadInterval = reload
+	}
+}
+
+// WithEventSocket sets the path of the unix socket a capturing runner
+// publishes its events on and an api-only one reads them from (default:
+// SocketFile in the storage directory; none without one)
+func WithEventSocket(path string) Option {
+	return func(o *options) {
+		o.eventSocket = path
+	}
+}
+
+// WithCaptureBackend sets where events come from: BPF (the default) or Replay
+func WithCaptureBackend(backend Backend) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithCaptureConfig sets the capture configuration applied when capture
+// starts (default: every event type, default payload lengths)
+func WithCaptureConfig(config models.CaptureConfig) Option {
+	return func(o *options) {
+		o.captureConfig = config
+	}
+}
+
+// WithInterfaceCapture sets the capture settings of interfaces by name,
+// replacing the capture configuration's event types, payload lengths and
+// sampling on them. Only the BPF backend applies them.
+func WithInterfaceCapture(configs map[string]models.InterfaceCapture) Option {
+	return func(o *options) {
+		o.interfaceCapture = configs
+	}
+}
+
+// WithAPIAddr serves the HTTP API on addr while running. Without it the API is
+// disabled.
+func WithAPIAddr(addr string) Option {
+	return func(o *options) {
+		o.apiAddr = addr
+	}
+}
+
+// WithLogger sets where progress and warnings are written (default: stdout)
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithCaptureStarted runs fn once capture has started, before Run waits. An
+// error stops the runner and is returned by Run.
+func WithCaptureStarted(fn func() error) Option {
+	return func(o *options) {
+		o.captureStarted = append(o.captureStarted, fn)
+	}
+}
+
+// defaultOptions returns the options before any Option is applied
+func defaultOptions() options {
+	return options{
+		backend: BPF(BPFConfig{}),
+		logger:  log.New(os.Stdout, "", 0),
+	}
+}
@@ -0,0 +1,185 @@
+package cerberus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/zrougamed/cerberus/internal/capture"
+	"github.com/zrougamed/cerberus/internal/models"
+)
+
+// ReplayIfIndex is the interface index replayed frames are captured on. No
+// real interface has it, as indexes are allocated from 1 upwards.
+const ReplayIfIndex = 0xffff
+
+// ReplayBackend feeds the frames of a pcap file into the monitor, decoding and
+// filtering them in userspace the way the BPF program does. It needs no
+// privileges. Frames are replayed as fast as they are read, and are tracked
+// at the time they are replayed rather than the time they were captured.
+type ReplayBackend struct {
+	path string
+	r    io.Reader
+
+	file    *os.File
+	decoder *capture.Decoder
+	quit    chan struct{}
+	done    chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	frames uint64
+}
+
+// Replay returns the backend replaying a pcap file
+func Replay(path string) *ReplayBackend {
+	return &ReplayBackend{path: path, done: make(chan struct{}), quit: make(chan struct{})}
+}
+
+// ReplayReader returns the backend replaying a pcap stream. If r is an
+// io.Closer, it is closed when the runner stops, so a read waiting on a live
+// stream returns.
+func ReplayReader(r io.Reader) *ReplayBackend {
+	return &ReplayBackend{r: r, done: make(chan struct{}), quit: make(chan struct{})}
+}
+
+// Name returns "replay"
+func (b *ReplayBackend) Name() string {
+	return "replay"
+}
+
+// Done is closed once every frame has been replayed, reading failed or the
+// runner stopped
+func (b *ReplayBackend) Done() <-chan struct{} {
+	return b.done
+}
+
+// Err returns why the replay stopped before the end of the file, if it did
+func (b *ReplayBackend) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Frames returns the frames replayed so far
+func (b *ReplayBackend) Frames() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.frames
+}
+
+func (b *ReplayBackend) start(r *Runner) error {
+	if b.r == nil {
+		file, err := os.Open(b.path)
+		if err != nil {
+			return fmt.Errorf("failed to open capture file: %w", err)
+		}
+		b.file, b.r = file, file
+	}
+	pcap, err := capture.NewPcapReader(b.r)
+	if err != nil {
+		b.closeFile()
+		return err
+	}
+
+	b.decoder = capture.NewDecoder()
+	r.mon.SetCaptureControl(b.decoder)
+	if _, err := r.mon.ApplyCaptureConfig(r.opts.captureConfig); err != nil {
+		b.closeFile()
+		return fmt.Errorf("failed to configure event filter: %w", err)
+	}
+
+	r.mon.Interfaces().Update(ReplayIfIndex, func(s *models.InterfaceStatus) {
+		s.Name = "replay"
+		s.Attached = true
+		s.Mode = b.Name()
+	})
+
+	r.opts.logger.Printf("Replaying %s", b.source())
+	go b.replay(r, pcap)
+	return nil
+}
+
+// source names what is replayed
+func (b *ReplayBackend) source() string {
+	if b.path == "" {
+		return "capture stream"
+	}
+	return b.path
+}
+
+// replay tracks every frame until the end of the file or stop
+func (b *ReplayBackend) replay(r *Runner, pcap *capture.PcapReader) {
+	defer close(b.done)
+
+	for {
+		if b.stopping() {
+			return
+		}
+
+		data, length, at, err := pcap.Next()
+		if err != nil {
+			// Flows still counted are reported as a capture's final sweep does
+			for _, summary := range b.decoder.FlushFlows() {
+				r.mon.TrackFlowSummary(summary)
+			}
+			if !errors.Is(err, io.EOF) && !b.stopping() {
+				b.mu.Lock()
+				b.err = err
+				b.mu.Unlock()
+				r.opts.logger.Printf("Replay of %s stopped: %v", b.source(), err)
+			}
+			return
+		}
+
+		frame := b.decoder.Decode(data, length, ReplayIfIndex, at)
+		if frame.Event != nil {
+			r.trackEvent(frame.Event)
+		}
+		if frame.TLSHello != nil {
+			r.mon.TrackTLSHello(frame.TLSHello)
+		}
+		if frame.DNSQuery != nil {
+			r.trackDNS(frame.DNSQuery)
+		}
+		if frame.HTTPRequest != nil {
+			r.mon.TrackHTTPRequest(frame.HTTPRequest)
+		}
+		if frame.FlowSummary != nil {
+			r.mon.TrackFlowSummary(frame.FlowSummary)
+		}
+
+		b.mu.Lock()
+		b.frames++
+		b.mu.Unlock()
+	}
+}
+
+// stopping reports whether stop was called
+func (b *ReplayBackend) stopping() bool {
+	select {
+	case <-b.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *ReplayBackend) stop() {
+	close(b.quit)
+	if closer, ok := b.r.(io.Closer); ok {
+		closer.Close()
+	}
+	<-b.done
+	b.file = nil
+}
+
+// closeFile closes the file opened by start, if any
+func (b *ReplayBackend) closeFile() {
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+}
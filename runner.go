@@ -0,0 +1,297 @@
+// Package cerberus runs the network monitor: it starts a capture backend, feeds
+// the events into the monitor and serves the HTTP API. cmd/cerberus is a thin
+// wrapper around it; other programs can embed it the same way, for example to
+// replay a capture file without root:
+//
+//	r, err := cerberus.New(cerberus.WithCaptureBackend(cerberus.Replay("traffic.pcap")))
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Shutdown(context.Background())
+//	err = r.Run(ctx) // Returns once ctx is canceled
+package cerberus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/api"
+	"github.com/zrougamed/cerberus/internal/eventsock"
+	"github.com/zrougamed/cerberus/internal/messages"
+	"github.com/zrougamed/cerberus/internal/models"
+	"github.com/zrougamed/cerberus/internal/monitor"
+	"github.com/zrougamed/cerberus/internal/utils"
+)
+
+// DatabaseFile is the name of the database in the storage directory
+const DatabaseFile = "network.db"
+
+// MessagesFile is the name of the file in the storage directory overriding
+// the templates of anomaly descriptions, such as with a translation
+const MessagesFile = messages.File
+
+// cacheSize is the number of devices kept in memory
+const cacheSize = 1000
+
+// debugEvents is the number of events printed as they are parsed, to verify
+// that capture works
+const debugEvents = 10
+
+// ErrRunnerStopped is returned by Run once the runner has been shut down
+var ErrRunnerStopped = errors.New("runner is shut down")
+
+// Runner captures traffic and monitors it until its context is canceled or it
+// is shut down
+type Runner struct {
+	opts     options
+	mon      *monitor.NetworkMonitor // Replaced by an api-only runner on reload, under mu
+	loadedAt time.Time
+
+	mu       sync.Mutex
+	started  bool
+	shutdown bool
+	stop     chan struct{} // Closed by Shutdown
+	done     chan struct{} // Closed when Run returns
+
+	eventCount int // Events tracked; only the backend's event loop touches it
+}
+
+// New creates a runner and its monitor. Capture doesn't start until Run.
+func New(opts ...Option) (*Runner, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.backend == nil {
+		return nil, errors.New("no capture backend")
+	}
+
+	if o.readOnly && o.storageDir == "" {
+		return nil, errors.New("read-only storage needs a data directory")
+	}
+
+	dbPath := ":memory:"
+	if o.storageDir != "" && !o.readOnly {
+		if err := os.MkdirAll(o.storageDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+	if o.storageDir != "" {
+		dbPath = filepath.Join(o.storageDir, DatabaseFile)
+
+		// Bad templates fail startup rather than garble descriptions later
+		path := filepath.Join(o.storageDir, MessagesFile)
+		catalog, err := messages.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		if catalog != messages.Default() {
+			o.logger.Printf("Loaded message templates from %s", path)
+			o.monitorSetup = append(o.monitorSetup, func(mon *monitor.NetworkMonitor) {
+				mon.SetMessages(catalog)
+			})
+		}
+	}
+
+	var mon *monitor.NetworkMonitor
+	var err error
+	if o.readOnly {
+		mon, err = monitor.NewReadOnlyNetworkMonitor(cacheSize, dbPath)
+	} else {
+		mon, err = monitor.NewNetworkMonitor(cacheSize, dbPath)
+	}
+	if errors.Is(err, monitor.ErrCorruptDatabase) && !o.strictStorage && !o.readOnly && o.storageDir != "" {
+		// Capture matters more than history: keep the damaged file for
+		// inspection and start over with what can be salvaged
+		incident, recoverErr := monitor.RecoverDatabase(dbPath, err)
+		if recoverErr != nil {
+			return nil, fmt.Errorf("%w, and it couldn't be moved aside: %v", err, recoverErr)
+		}
+		o.logger.Printf("WARNING: %v", err)
+		o.logger.Printf("WARNING: moved it to %s. Recovered %d entries written before the damage; %d bytes of history after it are lost",
+			incident.MovedTo, incident.RecoveredKeys, incident.LostBytes)
+		mon, err = monitor.NewNetworkMonitor(cacheSize, dbPath)
+		if err == nil {
+			mon.SetDatabaseIncident(incident)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the monitor: %w", err)
+	}
+	for _, setup := range o.monitorSetup {
+		setup(mon)
+	}
+	if !o.apiOnly {
+		for _, setup := range o.captureSetup {
+			if err := setup(mon); err != nil {
+				mon.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return &Runner{
+		opts:     o,
+		mon:      mon,
+		loadedAt: time.Now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// monitor returns the monitor events are tracked in. An api-only runner
+// replaces it whenever it reloads the database.
+func (r *Runner) monitor() *monitor.NetworkMonitor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mon
+}
+
+// Run starts the API and capture, and blocks until ctx is canceled or Shutdown
+// is called. It then stops capture and the API; the monitor stays open until
+// Shutdown. With read-only storage, it serves the API only; api-only, it
+// also follows the process capturing into the same storage. A capturing
+// runner with storage publishes its events for such runners. A runner runs
+// once.
+func (r *Runner) Run(ctx context.Context) error {
+	r.mu.Lock()
+	switch {
+	case r.shutdown:
+		r.mu.Unlock()
+		return ErrRunnerStopped
+	case r.started:
+		r.mu.Unlock()
+		return errors.New("runner is already running")
+	}
+	r.started = true
+	r.mu.Unlock()
+	defer close(r.done)
+
+	var apiServer *api.Server
+	var writer *follower
+	if r.opts.apiAddr != "" {
+		apiServer = api.NewServer(r.mon)
+		apiServer.SetLogger(r.opts.logger)
+		apiServer.SetFeatures(map[string]string{"capture_backend": r.opts.backend.Name()})
+		for _, setup := range r.opts.apiSetup {
+			setup(apiServer)
+		}
+		if r.opts.apiOnly {
+			writer = r.followWriter(apiServer)
+		}
+		if err := apiServer.Start(r.opts.apiAddr); err != nil {
+			return fmt.Errorf("cannot start the API on %s: %w", r.opts.apiAddr, err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			apiServer.Shutdown(ctx)
+		}()
+	}
+
+	if writer != nil {
+		writer.run(ctx, r.stop)
+		return nil
+	}
+	if r.opts.readOnly {
+		r.opts.logger.Printf("Serving %s read-only, capture disabled", filepath.Join(r.opts.storageDir, DatabaseFile))
+		select {
+		case <-ctx.Done():
+		case <-r.stop:
+		}
+		return nil
+	}
+
+	if err := r.opts.backend.start(r); err != nil {
+		return err
+	}
+	defer func() {
+		r.opts.backend.stop()
+		for _, stopped := range r.opts.captureStopped {
+			stopped()
+		}
+	}()
+
+	for _, started := range r.opts.captureStarted {
+		if err := started(); err != nil {
+			return err
+		}
+	}
+
+	// Created once privileges are dropped, so api-only processes of the
+	// user's group can connect
+	stopPublishing, err := r.publishEvents()
+	if errors.Is(err, eventsock.ErrWriterRunning) {
+		return err
+	}
+	if err != nil {
+		r.opts.logger.Printf("Warning: api-only processes won't receive live events: %v", err)
+	} else {
+		defer stopPublishing()
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-r.stop:
+	}
+	return nil
+}
+
+// Shutdown stops Run, waits for it to return or ctx to expire, and closes the
+// monitor. Calling it again does nothing.
+func (r *Runner) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	if r.shutdown {
+		r.mu.Unlock()
+		return nil
+	}
+	r.shutdown = true
+	started := r.started
+	r.mu.Unlock()
+
+	close(r.stop)
+	if started {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for i := len(r.opts.closers) - 1; i >= 0; i-- {
+		r.opts.closers[i]()
+	}
+	return r.monitor().Close()
+}
+
+// trackEvent feeds a captured event into the monitor. The first events are
+// printed to show that capture works.
+func (r *Runner) trackEvent(evt *models.NetworkEvent) {
+	r.eventCount++
+	if r.eventCount <= debugEvents {
+		eventTypeStr, ok := models.EventTypeNames[evt.EventType]
+		if !ok {
+			eventTypeStr = "UNKNOWN"
+		}
+
+		r.opts.logger.Printf("Event #%d: Type=%s(%d) SrcIP=%s DstIP=%s SrcPort=%d DstPort=%d",
+			r.eventCount, eventTypeStr, evt.EventType,
+			utils.IPFromBEUint32(evt.SrcIP), utils.IPFromBEUint32(evt.DstIP),
+			evt.SrcPort, evt.DstPort)
+	}
+
+	r.mon.TrackEvent(evt)
+}
+
+// trackDNS feeds a DNS query or response into the monitor
+func (r *Runner) trackDNS(query *models.DNSQueryEvent) {
+	if utils.DNSIsResponse(query.Data) {
+		r.mon.TrackDNSResponse(query)
+	} else {
+		r.mon.TrackDNSQuery(query)
+	}
+}
@@ -0,0 +1,262 @@
+package cerberus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zrougamed/cerberus/internal/monitor"
+)
+
+var (
+	clientMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	serverMAC = []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	clientIP  = []byte{192, 168, 1, 10}
+	serverIP  = []byte{192, 168, 1, 20}
+)
+
+// pcapHeader returns the header of a classic pcap file of Ethernet frames
+func pcapHeader() []byte {
+	hdr := binary.LittleEndian.AppendUint32(nil, 0xa1b2c3d4)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 2)
+	hdr = binary.LittleEndian.AppendUint16(hdr, 4)
+	hdr = append(hdr, make([]byte, 8)...)
+	hdr = binary.LittleEndian.AppendUint32(hdr, 65535)
+	return binary.LittleEndian.AppendUint32(hdr, 1)
+}
+
+// pcapRecord returns a pcap record holding frame
+func pcapRecord(frame []byte) []byte {
+	rec := binary.LittleEndian.AppendUint32(nil, uint32(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC).Unix()))
+	rec = binary.LittleEndian.AppendUint32(rec, 0)
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(len(frame)))
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(len(frame)))
+	return append(rec, frame...)
+}
+
+// arpFrame returns an ARP request broadcast by the client for the server
+func arpFrame() []byte {
+	frame := append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, clientMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, 0x0806)
+	frame = append(frame, 0, 1, 0x08, 0x00, 6, 4, 0, 1)
+	frame = append(frame, clientMAC...)
+	frame = append(frame, clientIP...)
+	frame = append(frame, 0, 0, 0, 0, 0, 0)
+	frame = append(frame, serverIP...)
+	return append(frame, make([]byte, 18)...)
+}
+
+// synFrame returns a TCP SYN from the client to port 22 of the server
+func synFrame() []byte {
+	frame := append(append([]byte{}, serverMAC...), clientMAC...)
+	frame = binary.BigEndian.AppendUint16(frame, 0x0800)
+	ip := make([]byte, 40)
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 40)
+	ip[8], ip[9] = 64, 6
+	copy(ip[12:16], clientIP)
+	copy(ip[16:20], serverIP)
+	binary.BigEndian.PutUint16(ip[20:22], 40000)
+	binary.BigEndian.PutUint16(ip[22:24], 22)
+	ip[32], ip[33] = 5<<4, 0x02
+	return append(frame, ip...)
+}
+
+func quietLogger() Option {
+	return WithLogger(log.New(io.Discard, "", 0))
+}
+
+// waitFor polls cond until it holds or a few seconds passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// runAsync runs r and returns the channel Run's error is sent on
+func runAsync(ctx context.Context, r *Runner) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+	return done
+}
+
+// The replay backend needs no privileges, tracks what it reads, and stops on
+// cancel even while waiting on a live stream
+func TestReplayRunsUntilCanceled(t *testing.T) {
+	stream, feed := io.Pipe()
+	backend := ReplayReader(stream)
+	r, err := New(WithCaptureBackend(backend), quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runAsync(ctx, r)
+	go func() {
+		feed.Write(pcapHeader())
+		feed.Write(pcapRecord(arpFrame()))
+		feed.Write(pcapRecord(synFrame()))
+		// The stream stays open, as a live capture's would
+	}()
+	waitFor(t, "two frames", func() bool { return backend.Frames() == 2 })
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return once canceled")
+	}
+	if err := backend.Err(); err != nil {
+		t.Errorf("replay stopped with %v", err)
+	}
+
+	mon := r.monitor()
+	if n := mon.Stats.Snapshot().TotalPackets; n != 2 {
+		t.Errorf("%d packets tracked, want 2", n)
+	}
+	if _, ok := mon.GetDevice("02:00:00:00:00:01"); !ok {
+		t.Error("the client was not tracked")
+	}
+	status, ok := mon.Interfaces().Get(ReplayIfIndex)
+	if !ok || !status.Attached || status.Mode != "replay" {
+		t.Errorf("replay interface = %+v", status)
+	}
+}
+
+// A replayed file ends without stopping the runner, and a runner runs once
+func TestReplayFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.pcap")
+	data := append(pcapHeader(), pcapRecord(arpFrame())...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	backend := Replay(path)
+	r, err := New(WithCaptureBackend(backend), WithStorage(t.TempDir()), quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := runAsync(ctx, r)
+	select {
+	case <-backend.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay didn't finish")
+	}
+	if backend.Frames() != 1 || backend.Err() != nil {
+		t.Errorf("replayed %d frames, error %v", backend.Frames(), backend.Err())
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v at the end of the file", err)
+	default:
+	}
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v", err)
+	}
+	if err := r.Run(context.Background()); !errors.Is(err, ErrRunnerStopped) {
+		t.Errorf("Run after Shutdown = %v, want ErrRunnerStopped", err)
+	}
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown = %v", err)
+	}
+}
+
+func TestReplayBadFile(t *testing.T) {
+	r, err := New(WithCaptureBackend(ReplayReader(bytes.NewReader([]byte("not a capture file at all")))), quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown(context.Background())
+	if err := r.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "not a pcap file") {
+		t.Errorf("Run = %v, want the file rejected", err)
+	}
+}
+
+// Capture setup runs in New, where an error fails it, and closers run on
+// Shutdown, last registered first
+func TestRunnerHooks(t *testing.T) {
+	var calls []string
+	hooks := func(o *options) {
+		o.captureSetup = append(o.captureSetup, func(mon *monitor.NetworkMonitor) error {
+			calls = append(calls, "setup")
+			return nil
+		})
+		o.closers = append(o.closers, func() { calls = append(calls, "first") }, func() { calls = append(calls, "second") })
+	}
+	r, err := New(WithCaptureBackend(ReplayReader(bytes.NewReader(nil))), quietLogger(), hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Shutdown(context.Background())
+	if got := strings.Join(calls, ","); got != "setup,second,first" {
+		t.Errorf("calls = %s", got)
+	}
+
+	failing := func(o *options) {
+		o.captureSetup = append(o.captureSetup, func(*monitor.NetworkMonitor) error { return errors.New("bad setting") })
+	}
+	if _, err := New(quietLogger(), failing); err == nil || err.Error() != "bad setting" {
+		t.Errorf("New = %v, want the setup error", err)
+	}
+}
+
+// A corrupt database is moved aside and replaced, so capture starts anyway
+// and /health reports the loss, unless storage is strict
+func TestCorruptDatabase(t *testing.T) {
+	corrupt := []byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n#garbage\r\n")
+	newRunner := func(dir string, opts ...Option) (*Runner, error) {
+		if err := os.WriteFile(filepath.Join(dir, DatabaseFile), corrupt, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		opts = append(opts, WithCaptureBackend(ReplayReader(bytes.NewReader(nil))), WithStorage(dir), quietLogger())
+		return New(opts...)
+	}
+
+	dir := t.TempDir()
+	r, err := newRunner(dir)
+	if err != nil {
+		t.Fatalf("New = %v, want the database replaced", err)
+	}
+	defer r.Shutdown(context.Background())
+	incident := r.monitor().DatabaseIncident()
+	if incident == nil || incident.RecoveredKeys != 1 {
+		t.Fatalf("incident = %+v, want one key recovered", incident)
+	}
+	if kept, err := os.ReadFile(incident.MovedTo); err != nil || !bytes.Equal(kept, corrupt) {
+		t.Errorf("corrupt file not kept at %s: %v", incident.MovedTo, err)
+	}
+	if health := r.monitor().Health(); health.Database == nil || len(health.Reasons) == 0 {
+		t.Errorf("health = %+v, want the incident", health)
+	}
+
+	dir = t.TempDir()
+	if _, err := newRunner(dir, WithStrictStorage()); !errors.Is(err, monitor.ErrCorruptDatabase) {
+		t.Errorf("strict New = %v, want ErrCorruptDatabase", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, DatabaseFile+".corrupt-*")); len(matches) != 0 {
+		t.Errorf("strict storage moved the file: %v", matches)
+	}
+}